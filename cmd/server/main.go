@@ -8,11 +8,13 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/joho/godotenv"
 	"go.uber.org/zap"
 	"golang.org/x/sync/errgroup"
 	awss3 "stockinos.com/api/aws_s3"
+	"stockinos.com/api/ratelimit"
 	"stockinos.com/api/server"
 	"stockinos.com/api/storage"
 	"stockinos.com/api/utils"
@@ -60,12 +62,29 @@ func start() int {
 
 	s3Client := awss3.NewS3Client()
 
+	// memory for a single node, mongo or redis to share the limits between the nodes
+	var rateLimitStore ratelimit.Store
+	switch backend := utils.GetDefault("RATE_LIMIT_BACKEND", "memory"); backend {
+	case "mongo":
+		rateLimitStore = ratelimit.NewMongoStore(database.GetCollection("rate_limits"))
+	case "redis":
+		rateLimitStore = ratelimit.NewRedisStore(utils.GetDefault("RATE_LIMIT_REDIS_URL", "redis://localhost:6379/0"))
+	default:
+		rateLimitStore = ratelimit.NewMemoryStore()
+	}
+
 	s := server.New(server.Options{
 		Database: database,
 		S3:       s3Client,
 		Host:     host,
 		Port:     port,
 		Log:      log,
+
+		TrashRetention:     utils.GetDurationDefault("TRASH_RETENTION", 30*24*time.Hour),
+		TrashPurgeInterval: utils.GetDurationDefault("TRASH_PURGE_INTERVAL", time.Hour),
+
+		RateLimitStore: rateLimitStore,
+		TrustedProxies: utils.GetIntDefault("TRUSTED_PROXIES", 0),
 	})
 
 	// gs := grpc.New(grpc.Options{
@@ -0,0 +1,379 @@
+// Package filters parses the filter expressions used to search the data of an activity
+// and compiles them into MongoDB filters.
+//
+// An expression is a list of conditions joined by "and":
+//
+//	<field> <operator> [value]
+//
+// where field is the id of a field of the activity, created_at or updated_at,
+// and value is a literal (bare or between double quotes) or a list of literals [a, b].
+//
+// Operators: eq, ne, gt, gte, lt, lte, between, in, nin, contains, prefix, isnull, notnull.
+package filters
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"stockinos.com/api/models"
+)
+
+// Error is returned when an expression can not be parsed or does not match the activity
+type Error struct {
+	Field   string
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Field == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+func newError(field, format string, args ...any) *Error {
+	return &Error{
+		Field:   field,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+type Condition struct {
+	Field    string
+	Operator string
+	Values   []string
+	IsList   bool
+}
+
+var operatorArity = map[string]int{
+	"eq":       1,
+	"ne":       1,
+	"gt":       1,
+	"gte":      1,
+	"lt":       1,
+	"lte":      1,
+	"contains": 1,
+	"prefix":   1,
+	"between":  2,
+	"in":       -1, // a list of any length
+	"nin":      -1,
+	"isnull":   0,
+	"notnull":  0,
+}
+
+// Parse reads the expression and compiles it against the fields of the activity.
+// An empty expression returns an empty filter.
+func Parse(activity *models.Activity, expression string) (bson.M, error) {
+	conditions, err := ParseConditions(expression)
+	if err != nil {
+		return nil, err
+	}
+	return Compile(activity, conditions)
+}
+
+// ParseConditions reads the conditions of the expression without checking the fields
+func ParseConditions(expression string) ([]Condition, error) {
+	tokens, err := tokenize(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	conditions := make([]Condition, 0)
+	for i := 0; i < len(tokens); {
+		if len(conditions) > 0 {
+			if !tokens[i].isWord("and") {
+				return nil, newError("", "\"and\" expected instead of %q", tokens[i].text)
+			}
+			i++
+		}
+
+		if i+1 >= len(tokens) || tokens[i].kind != tokenLiteral || tokens[i+1].kind != tokenLiteral {
+			return nil, newError("", "a condition must be written <field> <operator> [value]")
+		}
+		condition := Condition{
+			Field:    tokens[i].text,
+			Operator: strings.ToLower(tokens[i+1].text),
+		}
+		i += 2
+
+		arity, ok := operatorArity[condition.Operator]
+		if !ok {
+			return nil, newError(condition.Field, "unknown operator %q", condition.Operator)
+		}
+
+		switch {
+		case arity == 0:
+		case arity == 1:
+			if i >= len(tokens) || tokens[i].kind != tokenLiteral {
+				return nil, newError(condition.Field, "a value is expected after %s", condition.Operator)
+			}
+			condition.Values = []string{tokens[i].text}
+			i++
+		default:
+			values, next, err := parseList(tokens, i)
+			if err != nil {
+				return nil, newError(condition.Field, "%s", err)
+			}
+			if arity > 0 && len(values) != arity {
+				return nil, newError(condition.Field, "%s expects %d values", condition.Operator, arity)
+			}
+			condition.Values = values
+			condition.IsList = true
+			i = next
+		}
+
+		conditions = append(conditions, condition)
+	}
+
+	return conditions, nil
+}
+
+func parseList(tokens []token, i int) ([]string, int, error) {
+	if i >= len(tokens) || tokens[i].kind != tokenOpen {
+		return nil, i, fmt.Errorf("a list [a, b] is expected")
+	}
+	i++
+
+	values := make([]string, 0)
+	for {
+		if i >= len(tokens) {
+			return nil, i, fmt.Errorf("the list is not closed")
+		}
+		if tokens[i].kind == tokenClose && len(values) == 0 {
+			return values, i + 1, nil
+		}
+		if tokens[i].kind != tokenLiteral {
+			return nil, i, fmt.Errorf("a value is expected in the list")
+		}
+		values = append(values, tokens[i].text)
+		i++
+
+		if i >= len(tokens) {
+			return nil, i, fmt.Errorf("the list is not closed")
+		}
+		switch tokens[i].kind {
+		case tokenClose:
+			return values, i + 1, nil
+		case tokenComma:
+			i++
+		default:
+			return nil, i, fmt.Errorf("\",\" or \"]\" expected in the list")
+		}
+	}
+}
+
+// Compile converts the conditions into a MongoDB filter on the data of the activity.
+// Values are cast to the type of their field, so they can never be read as operators.
+func Compile(activity *models.Activity, conditions []Condition) (bson.M, error) {
+	if len(conditions) == 0 {
+		return bson.M{}, nil
+	}
+
+	fields := make(map[string]models.ActivityField, len(activity.Fields))
+	for _, field := range activity.Fields {
+		fields[field.Id.Hex()] = field
+	}
+
+	clauses := make(bson.A, 0, len(conditions))
+	for _, condition := range conditions {
+		var clause bson.M
+		var err error
+
+		switch condition.Field {
+		case "created_at", "updated_at":
+			clause, err = compileTimestamp(condition)
+		default:
+			field, ok := fields[condition.Field]
+			if !ok {
+				return nil, newError(condition.Field, "unknown field")
+			}
+			clause, err = compileField(field, condition)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		clauses = append(clauses, clause)
+	}
+
+	if len(clauses) == 1 {
+		return clauses[0].(bson.M), nil
+	}
+	return bson.M{"$and": clauses}, nil
+}
+
+func isOrderedType(fieldType string) bool {
+	switch fieldType {
+	case models.FieldTypeDate, models.FieldTypeTime, models.FieldTypeDateTime:
+		return true
+	default:
+		return models.IsNumberType(fieldType)
+	}
+}
+
+func isTextualType(fieldType string) bool {
+	switch fieldType {
+	case models.FieldTypeText, models.FieldTypeKey, models.FieldTypeMultipleChoices, models.FieldTypeEmail,
+		models.FieldTypePhone, models.FieldTypeURL, models.FieldTypeBarcode, models.FieldTypeRichText:
+		return true
+	default:
+		return false
+	}
+}
+
+func compileField(field models.ActivityField, condition Condition) (bson.M, error) {
+	key := fmt.Sprintf("values.%s", field.Id.Hex())
+
+	switch condition.Operator {
+	case "isnull":
+		return bson.M{key: nil}, nil
+	case "notnull":
+		return bson.M{key: bson.M{"$ne": nil}}, nil
+	}
+
+	if field.Type == models.FieldTypeUpload {
+		return nil, newError(condition.Field, "files can only be filtered with isnull or notnull")
+	}
+	if field.Type == models.FieldTypeGeolocation {
+		return nil, newError(condition.Field, "locations can only be filtered with isnull or notnull")
+	}
+
+	switch condition.Operator {
+	case "gt", "gte", "lt", "lte", "between":
+		if !isOrderedType(field.Type) {
+			return nil, newError(condition.Field, "%s can not be used on a %s field", condition.Operator, field.Type)
+		}
+	case "contains", "prefix":
+		if !isTextualType(field.Type) {
+			return nil, newError(condition.Field, "%s can not be used on a %s field", condition.Operator, field.Type)
+		}
+		pattern := regexp.QuoteMeta(condition.Values[0])
+		if condition.Operator == "prefix" {
+			pattern = "^" + pattern
+		}
+		return bson.M{key: bson.M{"$regex": pattern, "$options": "i"}}, nil
+	}
+
+	values := make(bson.A, 0, len(condition.Values))
+	for _, literal := range condition.Values {
+		castValues, err := castLiteral(field, literal)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, castValues...)
+	}
+
+	switch condition.Operator {
+	case "eq":
+		if len(values) > 1 {
+			return bson.M{key: bson.M{"$in": values}}, nil
+		}
+		return bson.M{key: values[0]}, nil
+	case "ne":
+		if len(values) > 1 {
+			return bson.M{key: bson.M{"$nin": values}}, nil
+		}
+		return bson.M{key: bson.M{"$ne": values[0]}}, nil
+	case "in":
+		return bson.M{key: bson.M{"$in": values}}, nil
+	case "nin":
+		return bson.M{key: bson.M{"$nin": values}}, nil
+	case "between":
+		return bson.M{key: bson.M{"$gte": values[0], "$lte": values[1]}}, nil
+	default:
+		return bson.M{key: bson.M{"$" + condition.Operator: values[0]}}, nil
+	}
+}
+
+// castLiteral returns the values a literal can be stored as for the field.
+// A key can reference a text or a number, so a numeric literal matches both.
+func castLiteral(field models.ActivityField, literal string) (bson.A, error) {
+	switch field.Type {
+	case models.FieldTypeMultipleChoices:
+		return bson.A{literal}, nil
+	case models.FieldTypeKey:
+		if n, err := strconv.ParseFloat(literal, 64); err == nil {
+			return bson.A{literal, n}, nil
+		}
+		return bson.A{literal}, nil
+	}
+
+	// Each item of a multiple field is compared on its own
+	field.Options.Multiple = false
+	value, fieldErr := field.Cast(literal)
+	if fieldErr != nil {
+		return nil, newError(field.Id.Hex(), "%s", fieldErr.Message)
+	}
+	return bson.A{value}, nil
+}
+
+func compileTimestamp(condition Condition) (bson.M, error) {
+	key := condition.Field
+
+	switch condition.Operator {
+	case "isnull", "notnull", "contains", "prefix":
+		return nil, newError(condition.Field, "%s can not be used on a date", condition.Operator)
+	}
+
+	// A day without hour covers the whole day
+	type bound struct {
+		start, end time.Time
+	}
+	bounds := make([]bound, len(condition.Values))
+	for i, literal := range condition.Values {
+		if t, err := time.Parse(time.RFC3339, literal); err == nil {
+			bounds[i] = bound{t, t}
+			continue
+		}
+		day, ok := models.ParseDate(literal)
+		if !ok {
+			return nil, newError(condition.Field, "%q is not a valid date", literal)
+		}
+		bounds[i] = bound{day, day.AddDate(0, 0, 1)}
+	}
+
+	switch condition.Operator {
+	case "eq":
+		if bounds[0].start.Equal(bounds[0].end) {
+			return bson.M{key: bounds[0].start}, nil
+		}
+		return bson.M{key: bson.M{"$gte": bounds[0].start, "$lt": bounds[0].end}}, nil
+	case "ne":
+		if bounds[0].start.Equal(bounds[0].end) {
+			return bson.M{key: bson.M{"$ne": bounds[0].start}}, nil
+		}
+		return bson.M{"$or": bson.A{
+			bson.M{key: bson.M{"$lt": bounds[0].start}},
+			bson.M{key: bson.M{"$gte": bounds[0].end}},
+		}}, nil
+	case "gt":
+		if bounds[0].start.Equal(bounds[0].end) {
+			return bson.M{key: bson.M{"$gt": bounds[0].end}}, nil
+		}
+		return bson.M{key: bson.M{"$gte": bounds[0].end}}, nil
+	case "gte":
+		return bson.M{key: bson.M{"$gte": bounds[0].start}}, nil
+	case "lt":
+		return bson.M{key: bson.M{"$lt": bounds[0].start}}, nil
+	case "lte":
+		if bounds[0].start.Equal(bounds[0].end) {
+			return bson.M{key: bson.M{"$lte": bounds[0].end}}, nil
+		}
+		return bson.M{key: bson.M{"$lt": bounds[0].end}}, nil
+	case "between":
+		end := bson.M{"$lt": bounds[1].end}
+		if bounds[1].start.Equal(bounds[1].end) {
+			end = bson.M{"$lte": bounds[1].end}
+		}
+		return bson.M{"$and": bson.A{
+			bson.M{key: bson.M{"$gte": bounds[0].start}},
+			bson.M{key: end},
+		}}, nil
+	default:
+		return nil, newError(condition.Field, "%s can not be used on a date", condition.Operator)
+	}
+}
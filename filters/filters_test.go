@@ -0,0 +1,114 @@
+package filters_test
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"stockinos.com/api/filters"
+	"stockinos.com/api/models"
+)
+
+func TestParse(t *testing.T) {
+	weight := models.ActivityField{Id: primitive.NewObjectID(), Type: models.FieldTypeNumber}
+	site := models.ActivityField{Id: primitive.NewObjectID(), Type: models.FieldTypeText}
+	day := models.ActivityField{Id: primitive.NewObjectID(), Type: models.FieldTypeDate}
+	photos := models.ActivityField{Id: primitive.NewObjectID(), Type: models.FieldTypeUpload}
+	activity := &models.Activity{Fields: []models.ActivityField{weight, site, day, photos}}
+
+	w := fmt.Sprintf("values.%s", weight.Id.Hex())
+	s := fmt.Sprintf("values.%s", site.Id.Hex())
+	d := fmt.Sprintf("values.%s", day.Id.Hex())
+	p := fmt.Sprintf("values.%s", photos.Id.Hex())
+
+	tests := map[string]struct {
+		expression string
+		want       bson.M
+		wantErr    bool
+	}{
+		"empty": {
+			expression: "",
+			want:       bson.M{},
+		},
+		"number greater or equal": {
+			expression: fmt.Sprintf("%s gte 10", weight.Id.Hex()),
+			want:       bson.M{w: bson.M{"$gte": 10.0}},
+		},
+		"number and list": {
+			expression: fmt.Sprintf(`%s gt 50 and %s in [a, "b c"]`, weight.Id.Hex(), site.Id.Hex()),
+			want: bson.M{"$and": bson.A{
+				bson.M{w: bson.M{"$gt": 50.0}},
+				bson.M{s: bson.M{"$in": bson.A{"a", "b c"}}},
+			}},
+		},
+		"date range with different formats": {
+			expression: fmt.Sprintf("%s between [01/02/2024, 2024-02-07]", day.Id.Hex()),
+			want:       bson.M{d: bson.M{"$gte": "2024-02-01", "$lte": "2024-02-07"}},
+		},
+		"text contains is escaped": {
+			expression: fmt.Sprintf(`%s contains "a.b*"`, site.Id.Hex()),
+			want:       bson.M{s: bson.M{"$regex": `a\.b\*`, "$options": "i"}},
+		},
+		"operator as value is a literal": {
+			expression: fmt.Sprintf(`%s eq "$where"`, site.Id.Hex()),
+			want:       bson.M{s: "$where"},
+		},
+		"not null": {
+			expression: fmt.Sprintf("%s notnull", photos.Id.Hex()),
+			want:       bson.M{p: bson.M{"$ne": nil}},
+		},
+		"created at a day": {
+			expression: "created_at lte 2024-02-07",
+			want:       bson.M{"created_at": bson.M{"$lt": time.Date(2024, 2, 8, 0, 0, 0, 0, time.UTC)}},
+		},
+		"unknown field": {
+			expression: fmt.Sprintf("%s eq 1", primitive.NewObjectID().Hex()),
+			wantErr:    true,
+		},
+		"unknown operator": {
+			expression: fmt.Sprintf("%s $gt 1", weight.Id.Hex()),
+			wantErr:    true,
+		},
+		"invalid number": {
+			expression: fmt.Sprintf("%s gt ten", weight.Id.Hex()),
+			wantErr:    true,
+		},
+		"order on text": {
+			expression: fmt.Sprintf("%s gt a", site.Id.Hex()),
+			wantErr:    true,
+		},
+		"missing and": {
+			expression: fmt.Sprintf("%s gt 1 %s gt 2", weight.Id.Hex(), weight.Id.Hex()),
+			wantErr:    true,
+		},
+		"unclosed list": {
+			expression: fmt.Sprintf("%s in [1, 2", weight.Id.Hex()),
+			wantErr:    true,
+		},
+		"filter on files": {
+			expression: fmt.Sprintf("%s eq a.png", photos.Id.Hex()),
+			wantErr:    true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := filters.Parse(activity, tc.expression)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("Parse(%q) = %v; want an error", tc.expression, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse(%q) error = %v; want nil", tc.expression, err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("Parse(%q) = %#v; want %#v", tc.expression, got, tc.want)
+			}
+		})
+	}
+}
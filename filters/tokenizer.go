@@ -0,0 +1,77 @@
+package filters
+
+import (
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokenLiteral tokenKind = iota
+	tokenOpen
+	tokenClose
+	tokenComma
+)
+
+type token struct {
+	kind   tokenKind
+	text   string
+	quoted bool
+}
+
+func (t token) isWord(word string) bool {
+	return t.kind == tokenLiteral && !t.quoted && strings.EqualFold(t.text, word)
+}
+
+func tokenize(expression string) ([]token, error) {
+	tokens := make([]token, 0)
+	runes := []rune(expression)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '[':
+			tokens = append(tokens, token{kind: tokenOpen, text: "["})
+			i++
+		case r == ']':
+			tokens = append(tokens, token{kind: tokenClose, text: "]"})
+			i++
+		case r == ',':
+			tokens = append(tokens, token{kind: tokenComma, text: ","})
+			i++
+		case r == '"':
+			var text strings.Builder
+			i++
+			closed := false
+			for i < len(runes) {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					text.WriteRune(runes[i+1])
+					i += 2
+					continue
+				}
+				if runes[i] == '"' {
+					closed = true
+					i++
+					break
+				}
+				text.WriteRune(runes[i])
+				i++
+			}
+			if !closed {
+				return nil, newError("", "a quoted value is not closed")
+			}
+			tokens = append(tokens, token{kind: tokenLiteral, text: text.String(), quoted: true})
+		default:
+			start := i
+			for i < len(runes) && !unicode.IsSpace(runes[i]) && !strings.ContainsRune("[],\"", runes[i]) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenLiteral, text: string(runes[start:i])})
+		}
+	}
+
+	return tokens, nil
+}
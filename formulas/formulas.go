@@ -0,0 +1,805 @@
+// Package formulas computes the values of the automatic fields of an activity
+// from the other values of the data.
+//
+// A formula is an expression over the values of the data:
+//
+//	{quantity} * {unit_price}
+//	{delivered_on} - {ordered_on}
+//	{first_name} & " " & {last_name}
+//	if({stock} < 10, "reorder", "ok")
+//	{product.unit_price} * {quantity}
+//
+// where a field is written between braces with its id or its code, and {key.field} is a field
+// of the data referenced by the key field key. Texts are between double quotes.
+//
+// Operators, from the lowest precedence: or, and, not, comparisons (= != < <= > >=),
+// & (concatenation), + -, * /, unary -.
+// Subtracting two dates gives the number of days between them, adding a number to a date adds days.
+// The boolean fields are conditions, the integers, decimals, currencies, ratings and durations
+// (in seconds) are numbers.
+//
+// Functions: if(condition, then, else), round(number[, digits]), abs(number),
+// min(numbers...), max(numbers...), isblank(value).
+//
+// A formula without a value to compute from has no value: a missing value gives no result,
+// except in isblank, in the conditions where it is false, and in & where it is an empty text.
+package formulas
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"stockinos.com/api/models"
+)
+
+// Error is returned when a formula can not be parsed or does not match the fields of the activity
+type Error struct {
+	Field   string
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Field == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+func newError(field, format string, args ...any) *Error {
+	return &Error{
+		Field:   field,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+// maxRoundDigits is the most digits a number can be rounded to
+const maxRoundDigits = 15
+
+type valueType int
+
+const (
+	// the values of the key fields can be texts or numbers
+	typeAny valueType = iota
+	typeNumber
+	typeText
+	typeDate
+	typeBool
+)
+
+func (t valueType) String() string {
+	switch t {
+	case typeNumber:
+		return "number"
+	case typeText:
+		return "text"
+	case typeDate:
+		return "date"
+	case typeBool:
+		return "condition"
+	default:
+		return "key"
+	}
+}
+
+// valueNode is a field of the activity once checked
+type valueNode struct {
+	fieldId   string
+	valueType valueType
+}
+
+// lookupNode is a field of the data referenced by a key field of the activity, once checked
+type lookupNode struct {
+	keyFieldId string
+	key        models.ActivityFieldKey
+	fieldId    string
+	valueType  valueType
+}
+
+// Resolver finds the values of the data referenced by a value of a key field
+type Resolver interface {
+	Resolve(ctx context.Context, key models.ActivityFieldKey, value any) (map[string]any, error)
+}
+
+type formula struct {
+	field models.ActivityField
+	root  node
+}
+
+// Program is the formulas of the automatic fields of an activity, in the order they are computed
+type Program struct {
+	formulas []formula
+}
+
+// HasFormulas tells if some fields are computed
+func HasFormulas(fields []models.ActivityField) bool {
+	for _, field := range fields {
+		if field.HasFormula() {
+			return true
+		}
+	}
+	return false
+}
+
+// Compile checks the formulas of the automatic fields against the fields of the activity
+// and the activities referenced by its key fields, and the formulas that depend on each other.
+// It returns an *Error for the first formula rejected.
+func Compile(fields []models.ActivityField, referenced map[primitive.ObjectID]*models.Activity) (*Program, error) {
+	c := checker{
+		fields:     fields,
+		referenced: referenced,
+	}
+
+	formulas := make(map[string]formula)
+	dependencies := make(map[string][]string)
+	for _, field := range fields {
+		if !field.HasFormula() {
+			continue
+		}
+		fieldId := field.Id.Hex()
+
+		if field.IsList() {
+			return nil, newError(fieldId, "a list can not be computed")
+		}
+		switch field.Type {
+		case models.FieldTypeNumber, models.FieldTypeInteger, models.FieldTypeDecimal, models.FieldTypeCurrency,
+			models.FieldTypeText, models.FieldTypeDate:
+		default:
+			return nil, newError(fieldId, "only a number, a text or a date can be computed, not a %s", field.Type)
+		}
+
+		tree, err := parse(*field.Options.Formula)
+		if err != nil {
+			return nil, withField(err, fieldId)
+		}
+
+		c.dependencies = nil
+		root, resultType, err := c.check(tree)
+		if err != nil {
+			return nil, withField(err, fieldId)
+		}
+		if !fits(field.Type, resultType) {
+			return nil, newError(fieldId, "the formula gives a %s, a %s is expected", resultType, field.Type)
+		}
+
+		formulas[fieldId] = formula{field: field, root: root}
+		dependencies[fieldId] = c.dependencies
+	}
+
+	// Computed in the order of their dependencies, a formula can use the result of another one
+	program := &Program{formulas: make([]formula, 0, len(formulas))}
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	states := make(map[string]int)
+	var visit func(fieldId string) error
+	visit = func(fieldId string) error {
+		switch states[fieldId] {
+		case visiting:
+			return newError(fieldId, "the formula depends on itself")
+		case visited:
+			return nil
+		}
+		states[fieldId] = visiting
+		for _, dependency := range dependencies[fieldId] {
+			if _, ok := formulas[dependency]; !ok {
+				continue
+			}
+			if err := visit(dependency); err != nil {
+				return err
+			}
+		}
+		states[fieldId] = visited
+		program.formulas = append(program.formulas, formulas[fieldId])
+		return nil
+	}
+	for _, field := range fields {
+		if _, ok := formulas[field.Id.Hex()]; !ok {
+			continue
+		}
+		if err := visit(field.Id.Hex()); err != nil {
+			return nil, err
+		}
+	}
+
+	return program, nil
+}
+
+// Computes tells if the value of the field is computed by a formula
+func (program *Program) Computes(fieldId string) bool {
+	for _, f := range program.formulas {
+		if f.field.Id.Hex() == fieldId {
+			return true
+		}
+	}
+	return false
+}
+
+// Evaluate computes the values of the automatic fields from the other values.
+// It returns the computed values by field id, nil when a formula has no result.
+// The results are not cast to the type of their field, they are checked like the values written.
+func (program *Program) Evaluate(ctx context.Context, values map[string]any, resolver Resolver) (map[string]any, error) {
+	current := make(map[string]any, len(values)+len(program.formulas))
+	for key, value := range values {
+		current[key] = value
+	}
+
+	e := evaluator{ctx: ctx, values: current, resolver: resolver}
+	computed := make(map[string]any, len(program.formulas))
+	for _, f := range program.formulas {
+		result, err := e.eval(f.root)
+		if err != nil {
+			return nil, withField(err, f.field.Id.Hex())
+		}
+		value := render(f.field, result)
+
+		fieldId := f.field.Id.Hex()
+		current[fieldId] = value
+		computed[fieldId] = value
+	}
+
+	return computed, nil
+}
+
+func withField(err error, fieldId string) error {
+	if formulaErr, ok := err.(*Error); ok && formulaErr.Field == "" {
+		return newError(fieldId, "%s", formulaErr.Message)
+	}
+	return err
+}
+
+// fits tells if a result can be stored in a field of the type
+func fits(fieldType string, resultType valueType) bool {
+	switch fieldType {
+	case models.FieldTypeNumber, models.FieldTypeInteger, models.FieldTypeDecimal, models.FieldTypeCurrency:
+		return resultType == typeNumber
+	case models.FieldTypeDate:
+		return resultType == typeDate
+	default:
+		return resultType != typeBool
+	}
+}
+
+// render converts the result of a formula into the representation of the values of the field
+func render(field models.ActivityField, result any) any {
+	switch v := result.(type) {
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil
+		}
+		if field.Type == models.FieldTypeInteger {
+			return math.Round(v)
+		}
+	case time.Time:
+		return v.Format(models.DateLayout)
+	}
+	return result
+}
+
+type checker struct {
+	fields     []models.ActivityField
+	referenced map[primitive.ObjectID]*models.Activity
+	// the fields of the activity used by the formula being checked
+	dependencies []string
+}
+
+// findField finds a field by its id or its code
+func findField(fields []models.ActivityField, reference string) (models.ActivityField, bool) {
+	for _, field := range fields {
+		if field.Id.Hex() == reference || (field.Code != "" && strings.EqualFold(field.Code, reference)) {
+			return field, true
+		}
+	}
+	return models.ActivityField{}, false
+}
+
+// fieldValueType is the type of the values of a field in the formulas
+func fieldValueType(field models.ActivityField) (valueType, error) {
+	if field.IsList() {
+		return 0, newError("", "{%s} is a list, it can not be used in a formula", field.Id.Hex())
+	}
+	if models.IsNumberType(field.Type) {
+		return typeNumber, nil
+	}
+	switch field.Type {
+	case models.FieldTypeDate:
+		return typeDate, nil
+	case models.FieldTypeBoolean:
+		return typeBool, nil
+	case models.FieldTypeText, models.FieldTypeTime, models.FieldTypeMultipleChoices, models.FieldTypeEmail,
+		models.FieldTypePhone, models.FieldTypeURL, models.FieldTypeBarcode, models.FieldTypeDateTime, models.FieldTypeRichText:
+		return typeText, nil
+	default:
+		return typeAny, nil
+	}
+}
+
+func (c *checker) check(n node) (node, valueType, error) {
+	switch n := n.(type) {
+	case numberNode:
+		return n, typeNumber, nil
+	case textNode:
+		return n, typeText, nil
+	case boolNode:
+		return n, typeBool, nil
+	case fieldNode:
+		return c.checkField(n)
+	case unaryNode:
+		operand, operandType, err := c.check(n.operand)
+		if err != nil {
+			return nil, 0, err
+		}
+		n.operand = operand
+		if n.operator == "not" {
+			if operandType != typeBool {
+				return nil, 0, newError("", "not expects a condition, not a %s", operandType)
+			}
+			return n, typeBool, nil
+		}
+		if operandType != typeNumber {
+			return nil, 0, newError("", "- expects a number, not a %s", operandType)
+		}
+		return n, typeNumber, nil
+	case binaryNode:
+		return c.checkBinary(n)
+	case callNode:
+		return c.checkCall(n)
+	default:
+		return nil, 0, newError("", "unexpected expression")
+	}
+}
+
+func (c *checker) checkField(n fieldNode) (node, valueType, error) {
+	field, ok := findField(c.fields, n.path[0])
+	if !ok {
+		return nil, 0, newError("", "unknown field {%s}", n.path[0])
+	}
+	c.dependencies = append(c.dependencies, field.Id.Hex())
+
+	if len(n.path) == 1 {
+		t, err := fieldValueType(field)
+		if err != nil {
+			return nil, 0, err
+		}
+		return valueNode{fieldId: field.Id.Hex(), valueType: t}, t, nil
+	}
+
+	if len(n.path) > 2 {
+		return nil, 0, newError("", "{%s}: only the fields of the data referenced by a key field can be used", strings.Join(n.path, "."))
+	}
+	details := field.Details.ActivityFieldKey
+	if field.Type != models.FieldTypeKey || details == nil || details.ActivityId.IsZero() {
+		return nil, 0, newError("", "{%s} is not a key field referencing an activity", n.path[0])
+	}
+	if field.IsList() {
+		return nil, 0, newError("", "{%s} is a list, it can not be used in a formula", n.path[0])
+	}
+	referencedActivity := c.referenced[details.ActivityId]
+	if referencedActivity == nil {
+		return nil, 0, newError("", "the activity referenced by {%s} does not exist", n.path[0])
+	}
+	referencedField, ok := findField(referencedActivity.Fields, n.path[1])
+	if !ok {
+		return nil, 0, newError("", "unknown field {%s} in the activity %s", n.path[1], referencedActivity.Name)
+	}
+	t, err := fieldValueType(referencedField)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return lookupNode{
+		keyFieldId: field.Id.Hex(),
+		key:        *details,
+		fieldId:    referencedField.Id.Hex(),
+		valueType:  t,
+	}, t, nil
+}
+
+func (c *checker) checkBinary(n binaryNode) (node, valueType, error) {
+	left, leftType, err := c.check(n.left)
+	if err != nil {
+		return nil, 0, err
+	}
+	right, rightType, err := c.check(n.right)
+	if err != nil {
+		return nil, 0, err
+	}
+	n.left, n.right = left, right
+
+	switch n.operator {
+	case "or", "and":
+		if leftType != typeBool || rightType != typeBool {
+			return nil, 0, newError("", "%s expects conditions", n.operator)
+		}
+		return n, typeBool, nil
+	case "&":
+		if leftType == typeBool || rightType == typeBool {
+			return nil, 0, newError("", "a condition can not be concatenated")
+		}
+		return n, typeText, nil
+	case "=", "!=", "<", "<=", ">", ">=":
+		if leftType != rightType && leftType != typeAny && rightType != typeAny {
+			return nil, 0, newError("", "a %s can not be compared to a %s", leftType, rightType)
+		}
+		if leftType == typeBool && n.operator != "=" && n.operator != "!=" {
+			return nil, 0, newError("", "conditions can not be ordered")
+		}
+		return n, typeBool, nil
+	case "+":
+		switch {
+		case leftType == typeNumber && rightType == typeNumber:
+			return n, typeNumber, nil
+		case leftType == typeDate && rightType == typeNumber, leftType == typeNumber && rightType == typeDate:
+			return n, typeDate, nil
+		}
+	case "-":
+		switch {
+		case leftType == typeNumber && rightType == typeNumber:
+			return n, typeNumber, nil
+		case leftType == typeDate && rightType == typeDate:
+			return n, typeNumber, nil
+		case leftType == typeDate && rightType == typeNumber:
+			return n, typeDate, nil
+		}
+	case "*", "/":
+		if leftType == typeNumber && rightType == typeNumber {
+			return n, typeNumber, nil
+		}
+	}
+	return nil, 0, newError("", "%s can not be used between a %s and a %s", n.operator, leftType, rightType)
+}
+
+func (c *checker) checkCall(n callNode) (node, valueType, error) {
+	types := make([]valueType, len(n.arguments))
+	for i, argument := range n.arguments {
+		checked, t, err := c.check(argument)
+		if err != nil {
+			return nil, 0, err
+		}
+		n.arguments[i] = checked
+		types[i] = t
+	}
+
+	switch n.name {
+	case "if":
+		if len(types) != 3 {
+			return nil, 0, newError("", "if expects a condition, a value and another value")
+		}
+		if types[0] != typeBool {
+			return nil, 0, newError("", "the first value of if must be a condition")
+		}
+		switch {
+		case types[1] == types[2]:
+			return n, types[1], nil
+		case types[1] == typeAny || types[2] == typeAny:
+			return n, typeAny, nil
+		}
+		return nil, 0, newError("", "the values of if must have the same type, not a %s and a %s", types[1], types[2])
+	case "round":
+		if len(types) < 1 || len(types) > 2 {
+			return nil, 0, newError("", "round expects a number and a number of digits")
+		}
+		if len(types) == 2 {
+			if digits, ok := n.arguments[1].(numberNode); ok && (digits.value < 0 || digits.value > maxRoundDigits) {
+				return nil, 0, newError("", "round expects between 0 and %d digits", maxRoundDigits)
+			}
+		}
+	case "abs":
+		if len(types) != 1 {
+			return nil, 0, newError("", "abs expects a number")
+		}
+	case "min", "max":
+		if len(types) == 0 {
+			return nil, 0, newError("", "%s expects numbers", n.name)
+		}
+	case "isblank":
+		if len(types) != 1 {
+			return nil, 0, newError("", "isblank expects a value")
+		}
+		return n, typeBool, nil
+	default:
+		return nil, 0, newError("", "unknown function %s", n.name)
+	}
+
+	for _, t := range types {
+		if t != typeNumber {
+			return nil, 0, newError("", "%s expects numbers, not a %s", n.name, t)
+		}
+	}
+	return n, typeNumber, nil
+}
+
+type evaluator struct {
+	ctx      context.Context
+	values   map[string]any
+	resolver Resolver
+}
+
+func (e *evaluator) eval(n node) (any, error) {
+	switch n := n.(type) {
+	case numberNode:
+		return n.value, nil
+	case textNode:
+		return n.value, nil
+	case boolNode:
+		return n.value, nil
+	case valueNode:
+		return convert(e.values[n.fieldId], n.valueType), nil
+	case lookupNode:
+		keyValue := e.values[n.keyFieldId]
+		if keyValue == nil || e.resolver == nil {
+			return nil, nil
+		}
+		referencedValues, err := e.resolver.Resolve(e.ctx, n.key, keyValue)
+		if err != nil {
+			return nil, err
+		}
+		return convert(referencedValues[n.fieldId], n.valueType), nil
+	case unaryNode:
+		operand, err := e.eval(n.operand)
+		if err != nil {
+			return nil, err
+		}
+		if n.operator == "not" {
+			return !isTrue(operand), nil
+		}
+		if number, ok := operand.(float64); ok {
+			return -number, nil
+		}
+		return nil, nil
+	case binaryNode:
+		return e.evalBinary(n)
+	case callNode:
+		return e.evalCall(n)
+	default:
+		return nil, fmt.Errorf("formulas: unexpected expression %T", n)
+	}
+}
+
+func (e *evaluator) evalBinary(n binaryNode) (any, error) {
+	left, err := e.eval(n.left)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.operator {
+	case "and":
+		if !isTrue(left) {
+			return false, nil
+		}
+	case "or":
+		if isTrue(left) {
+			return true, nil
+		}
+	}
+
+	right, err := e.eval(n.right)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.operator {
+	case "and", "or":
+		return isTrue(right), nil
+	case "&":
+		return text(left) + text(right), nil
+	}
+
+	if left == nil || right == nil {
+		return nil, nil
+	}
+
+	switch n.operator {
+	case "=", "!=", "<", "<=", ">", ">=":
+		return compare(n.operator, left, right), nil
+	}
+
+	leftNumber, leftIsNumber := left.(float64)
+	rightNumber, rightIsNumber := right.(float64)
+	leftDate, leftIsDate := left.(time.Time)
+	rightDate, rightIsDate := right.(time.Time)
+
+	switch {
+	case leftIsNumber && rightIsNumber:
+		switch n.operator {
+		case "+":
+			return leftNumber + rightNumber, nil
+		case "-":
+			return leftNumber - rightNumber, nil
+		case "*":
+			return leftNumber * rightNumber, nil
+		case "/":
+			if rightNumber == 0 {
+				return nil, nil
+			}
+			return leftNumber / rightNumber, nil
+		}
+	case leftIsDate && rightIsDate:
+		return math.Round(leftDate.Sub(rightDate).Hours() / 24), nil
+	case leftIsDate && rightIsNumber:
+		if n.operator == "-" {
+			rightNumber = -rightNumber
+		}
+		return leftDate.AddDate(0, 0, int(math.Round(rightNumber))), nil
+	case leftIsNumber && rightIsDate:
+		return rightDate.AddDate(0, 0, int(math.Round(leftNumber))), nil
+	}
+	return nil, nil
+}
+
+func (e *evaluator) evalCall(n callNode) (any, error) {
+	if n.name == "if" {
+		condition, err := e.eval(n.arguments[0])
+		if err != nil {
+			return nil, err
+		}
+		if isTrue(condition) {
+			return e.eval(n.arguments[1])
+		}
+		return e.eval(n.arguments[2])
+	}
+
+	arguments := make([]any, len(n.arguments))
+	for i, argument := range n.arguments {
+		value, err := e.eval(argument)
+		if err != nil {
+			return nil, err
+		}
+		arguments[i] = value
+	}
+
+	switch n.name {
+	case "isblank":
+		return arguments[0] == nil || arguments[0] == "", nil
+	case "min", "max":
+		// the missing values are ignored
+		var result any
+		for _, argument := range arguments {
+			number, ok := argument.(float64)
+			if !ok {
+				continue
+			}
+			if current, ok := result.(float64); !ok ||
+				(n.name == "min" && number < current) ||
+				(n.name == "max" && number > current) {
+				result = number
+			}
+		}
+		return result, nil
+	}
+
+	number, ok := arguments[0].(float64)
+	if !ok {
+		return nil, nil
+	}
+	switch n.name {
+	case "abs":
+		return math.Abs(number), nil
+	case "round":
+		digits := 0.0
+		if len(arguments) == 2 {
+			if digits, ok = arguments[1].(float64); !ok {
+				return nil, nil
+			}
+		}
+		// beyond the precision of a float64 the scale overflows
+		digits = math.Max(0, math.Min(math.Round(digits), maxRoundDigits))
+		scale := math.Pow(10, digits)
+		return math.Round(number*scale) / scale, nil
+	}
+	return nil, nil
+}
+
+// convert reads a stored value as a value of the type, nil when it can not be read
+func convert(value any, t valueType) any {
+	if value == nil {
+		return nil
+	}
+
+	switch t {
+	case typeNumber:
+		switch v := value.(type) {
+		case float64:
+			return v
+		case float32:
+			return float64(v)
+		case int:
+			return float64(v)
+		case int32:
+			return float64(v)
+		case int64:
+			return float64(v)
+		}
+		return nil
+	case typeDate:
+		switch v := value.(type) {
+		case time.Time:
+			return v
+		case primitive.DateTime:
+			return v.Time().UTC()
+		case string:
+			if date, ok := models.ParseDate(v); ok {
+				return date
+			}
+		}
+		return nil
+	case typeText:
+		if v, ok := value.(string); ok {
+			return v
+		}
+		return text(value)
+	default:
+		if number := convert(value, typeNumber); number != nil {
+			return number
+		}
+		return value
+	}
+}
+
+func isTrue(value any) bool {
+	v, ok := value.(bool)
+	return ok && v
+}
+
+// text writes a value as it is concatenated, a missing value being an empty text
+func text(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case time.Time:
+		return v.Format(models.DateLayout)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func compare(operator string, left, right any) bool {
+	var order int
+	leftNumber, leftIsNumber := left.(float64)
+	rightNumber, rightIsNumber := right.(float64)
+	leftDate, leftIsDate := left.(time.Time)
+	rightDate, rightIsDate := right.(time.Time)
+
+	switch {
+	case leftIsNumber && rightIsNumber:
+		switch {
+		case leftNumber < rightNumber:
+			order = -1
+		case leftNumber > rightNumber:
+			order = 1
+		}
+	case leftIsDate && rightIsDate:
+		switch {
+		case leftDate.Before(rightDate):
+			order = -1
+		case leftDate.After(rightDate):
+			order = 1
+		}
+	default:
+		order = strings.Compare(text(left), text(right))
+	}
+
+	switch operator {
+	case "=":
+		return order == 0
+	case "!=":
+		return order != 0
+	case "<":
+		return order < 0
+	case "<=":
+		return order <= 0
+	case ">":
+		return order > 0
+	default:
+		return order >= 0
+	}
+}
@@ -0,0 +1,247 @@
+package formulas_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"stockinos.com/api/formulas"
+	"stockinos.com/api/models"
+)
+
+func formula(expression string) models.ActivityFieldOptions {
+	return models.ActivityFieldOptions{Automatic: true, Formula: &expression}
+}
+
+type fakeResolver map[string]map[string]any
+
+func (resolver fakeResolver) Resolve(ctx context.Context, key models.ActivityFieldKey, value any) (map[string]any, error) {
+	return resolver[value.(string)], nil
+}
+
+func TestEvaluate(t *testing.T) {
+	unitPrice := models.ActivityField{Id: primitive.NewObjectID(), Code: "unit_price", Type: models.FieldTypeNumber}
+	products := &models.Activity{Id: primitive.NewObjectID(), Name: "Products", Fields: []models.ActivityField{unitPrice}}
+
+	quantity := models.ActivityField{Id: primitive.NewObjectID(), Code: "quantity", Type: models.FieldTypeNumber}
+	site := models.ActivityField{Id: primitive.NewObjectID(), Code: "site", Type: models.FieldTypeText}
+	orderedOn := models.ActivityField{Id: primitive.NewObjectID(), Code: "ordered_on", Type: models.FieldTypeDate}
+	deliveredOn := models.ActivityField{Id: primitive.NewObjectID(), Code: "delivered_on", Type: models.FieldTypeDate}
+	paid := models.ActivityField{Id: primitive.NewObjectID(), Code: "paid", Type: models.FieldTypeBoolean}
+	product := models.ActivityField{
+		Id:   primitive.NewObjectID(),
+		Code: "product",
+		Type: models.FieldTypeKey,
+		Details: models.ActivityFieldType{ActivityFieldKey: &models.ActivityFieldKey{
+			ActivityId: products.Id,
+			FieldId:    primitive.NewObjectID(),
+		}},
+	}
+	referenced := map[primitive.ObjectID]*models.Activity{products.Id: products}
+
+	values := map[string]any{
+		quantity.Id.Hex():    4.0,
+		site.Id.Hex():        "Douala",
+		orderedOn.Id.Hex():   "2024-02-01",
+		deliveredOn.Id.Hex(): "2024-02-11",
+		paid.Id.Hex():        true,
+		product.Id.Hex():     "P1",
+	}
+	resolver := fakeResolver{"P1": {unitPrice.Id.Hex(): 2.5}}
+
+	tests := map[string]struct {
+		fieldType string
+		formula   string
+		values    map[string]any
+		want      any
+	}{
+		"arithmetic": {
+			fieldType: models.FieldTypeNumber,
+			formula:   "({quantity} + 1) * 2 - 10 / 5",
+			want:      8.0,
+		},
+		"reference by id": {
+			fieldType: models.FieldTypeNumber,
+			formula:   "-{" + quantity.Id.Hex() + "}",
+			want:      -4.0,
+		},
+		"lookup through a key": {
+			fieldType: models.FieldTypeNumber,
+			formula:   "{quantity} * {product.unit_price}",
+			want:      10.0,
+		},
+		"date difference": {
+			fieldType: models.FieldTypeNumber,
+			formula:   "{delivered_on} - {ordered_on}",
+			want:      10.0,
+		},
+		"date plus days": {
+			fieldType: models.FieldTypeDate,
+			formula:   "{ordered_on} + 30",
+			want:      "2024-03-02",
+		},
+		"concatenation": {
+			fieldType: models.FieldTypeText,
+			formula:   `{site} & " x" & {quantity}`,
+			want:      "Douala x4",
+		},
+		"conditional": {
+			fieldType: models.FieldTypeText,
+			formula:   `if({quantity} >= 4 and not isblank({site}), "big", "small")`,
+			want:      "big",
+		},
+		"boolean as a condition": {
+			fieldType: models.FieldTypeText,
+			formula:   `if({paid}, "paid", "due")`,
+			want:      "paid",
+		},
+		"integer rounded": {
+			fieldType: models.FieldTypeInteger,
+			formula:   "{quantity} / 3",
+			want:      1.0,
+		},
+		"functions": {
+			fieldType: models.FieldTypeNumber,
+			formula:   "round(max(1, {quantity} / 3, abs(-0.5)), 2)",
+			want:      1.33,
+		},
+		"round digits clamped": {
+			fieldType: models.FieldTypeNumber,
+			formula:   "round({quantity} / 3, {quantity} * 100)",
+			want:      1.333333333333333,
+		},
+		"missing value": {
+			fieldType: models.FieldTypeNumber,
+			formula:   "{quantity} * 2",
+			values:    map[string]any{},
+			want:      nil,
+		},
+		"missing value in a condition": {
+			fieldType: models.FieldTypeText,
+			formula:   `if({quantity} > 1, "many", "none")`,
+			values:    map[string]any{},
+			want:      "none",
+		},
+		"division by zero": {
+			fieldType: models.FieldTypeNumber,
+			formula:   "{quantity} / 0",
+			want:      nil,
+		},
+		"unknown key": {
+			fieldType: models.FieldTypeNumber,
+			formula:   "{product.unit_price}",
+			values:    map[string]any{product.Id.Hex(): "P2"},
+			want:      nil,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			total := models.ActivityField{Id: primitive.NewObjectID(), Type: tt.fieldType, Options: formula(tt.formula)}
+			fields := []models.ActivityField{quantity, site, orderedOn, deliveredOn, paid, product, total}
+
+			program, err := formulas.Compile(fields, referenced)
+			if err != nil {
+				t.Fatalf("Compile() error = %v", err)
+			}
+
+			input := values
+			if tt.values != nil {
+				input = tt.values
+			}
+			computed, err := program.Evaluate(context.Background(), input, resolver)
+			if err != nil {
+				t.Fatalf("Evaluate() error = %v", err)
+			}
+			if got := computed[total.Id.Hex()]; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Evaluate() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluateInOrder(t *testing.T) {
+	quantity := models.ActivityField{Id: primitive.NewObjectID(), Code: "quantity", Type: models.FieldTypeNumber}
+	withTax := models.ActivityField{Id: primitive.NewObjectID(), Code: "with_tax", Type: models.FieldTypeNumber, Options: formula("{total} * 1.5")}
+	total := models.ActivityField{Id: primitive.NewObjectID(), Code: "total", Type: models.FieldTypeNumber, Options: formula("{quantity} * 2")}
+
+	program, err := formulas.Compile([]models.ActivityField{quantity, withTax, total}, nil)
+	if err != nil {
+		t.Fatalf("Compile() error = %v", err)
+	}
+	computed, err := program.Evaluate(context.Background(), map[string]any{quantity.Id.Hex(): 3.0}, nil)
+	if err != nil {
+		t.Fatalf("Evaluate() error = %v", err)
+	}
+
+	want := map[string]any{total.Id.Hex(): 6.0, withTax.Id.Hex(): 9.0}
+	if !reflect.DeepEqual(computed, want) {
+		t.Errorf("Evaluate() = %v, want %v", computed, want)
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	quantity := models.ActivityField{Id: primitive.NewObjectID(), Code: "quantity", Type: models.FieldTypeNumber}
+	site := models.ActivityField{Id: primitive.NewObjectID(), Code: "site", Type: models.FieldTypeText}
+	photos := models.ActivityField{Id: primitive.NewObjectID(), Code: "photos", Type: models.FieldTypeUpload}
+	product := models.ActivityField{
+		Id:   primitive.NewObjectID(),
+		Code: "product",
+		Type: models.FieldTypeKey,
+		Details: models.ActivityFieldType{ActivityFieldKey: &models.ActivityFieldKey{
+			ActivityId: primitive.NewObjectID(),
+		}},
+	}
+
+	tests := map[string]struct {
+		fieldType string
+		formula   string
+	}{
+		"syntax":                   {models.FieldTypeNumber, "{quantity} *"},
+		"unclosed reference":       {models.FieldTypeNumber, "{quantity * 2"},
+		"unknown field":            {models.FieldTypeNumber, "{weight} * 2"},
+		"bare word":                {models.FieldTypeNumber, "quantity * 2"},
+		"number times text":        {models.FieldTypeNumber, "{quantity} * {site}"},
+		"text into number":         {models.FieldTypeNumber, `{site} & "kg"`},
+		"condition into text":      {models.FieldTypeText, "{quantity} > 1"},
+		"if branches differ":       {models.FieldTypeText, `if({quantity} > 1, "many", 0)`},
+		"unknown function":         {models.FieldTypeNumber, "sqrt({quantity})"},
+		"list field":               {models.FieldTypeText, `{photos} & ""`},
+		"missing referenced":       {models.FieldTypeNumber, "{product.price}"},
+		"lookup on non key field":  {models.FieldTypeNumber, "{quantity.price}"},
+		"itself":                   {models.FieldTypeNumber, "{total} + 1"},
+		"round to too many digits": {models.FieldTypeNumber, "round({quantity}, 400)"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			total := models.ActivityField{Id: primitive.NewObjectID(), Code: "total", Type: tt.fieldType, Options: formula(tt.formula)}
+			fields := []models.ActivityField{quantity, site, photos, product, total}
+
+			_, err := formulas.Compile(fields, nil)
+			var formulaErr *formulas.Error
+			if !errors.As(err, &formulaErr) {
+				t.Fatalf("Compile() error = %v, want a *formulas.Error", err)
+			}
+			if formulaErr.Field != total.Id.Hex() {
+				t.Errorf("Compile() error on %s, want %s", formulaErr.Field, total.Id.Hex())
+			}
+		})
+	}
+}
+
+func TestCompileCycle(t *testing.T) {
+	a := models.ActivityField{Id: primitive.NewObjectID(), Code: "a", Type: models.FieldTypeNumber, Options: formula("{b} + 1")}
+	b := models.ActivityField{Id: primitive.NewObjectID(), Code: "b", Type: models.FieldTypeNumber, Options: formula("{c} + 1")}
+	c := models.ActivityField{Id: primitive.NewObjectID(), Code: "c", Type: models.FieldTypeNumber, Options: formula("{a} + 1")}
+
+	if _, err := formulas.Compile([]models.ActivityField{a, b, c}, nil); err == nil {
+		t.Fatal("Compile() accepted formulas depending on each other")
+	}
+
+	// A formula of a field no longer automatic is not computed
+	c.Options.Automatic = false
+	if _, err := formulas.Compile([]models.ActivityField{a, b, c}, nil); err != nil {
+		t.Fatalf("Compile() error = %v", err)
+	}
+}
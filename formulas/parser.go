@@ -0,0 +1,288 @@
+package formulas
+
+import (
+	"strconv"
+	"strings"
+)
+
+type node interface{}
+
+type numberNode struct {
+	value float64
+}
+
+type textNode struct {
+	value string
+}
+
+type boolNode struct {
+	value bool
+}
+
+// fieldNode is a field of the activity, or a field of the data referenced by a key field: key.field
+type fieldNode struct {
+	path []string
+}
+
+type unaryNode struct {
+	operator string
+	operand  node
+}
+
+type binaryNode struct {
+	operator    string
+	left, right node
+}
+
+type callNode struct {
+	name      string
+	arguments []node
+}
+
+type parser struct {
+	tokens   []token
+	position int
+}
+
+// parse reads the expression of a formula into its tree
+func parse(expression string) (node, error) {
+	tokens, err := tokenize(expression)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, newError("", "the formula is empty")
+	}
+
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t, ok := p.peek(); ok {
+		return nil, newError("", "unexpected %q", t.text)
+	}
+	return root, nil
+}
+
+func (p *parser) peek() (token, bool) {
+	if p.position >= len(p.tokens) {
+		return token{}, false
+	}
+	return p.tokens[p.position], true
+}
+
+// accept moves to the next token when the current one is of the kind and one of the texts
+func (p *parser) accept(kind tokenKind, texts ...string) (string, bool) {
+	t, ok := p.peek()
+	if !ok || t.kind != kind {
+		return "", false
+	}
+	for _, text := range texts {
+		if t.is(kind, text) {
+			p.position++
+			return text, true
+		}
+	}
+	return "", false
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.accept(tokenWord, "or"); !ok {
+			return left, nil
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryNode{operator: "or", left: left, right: right}
+	}
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.accept(tokenWord, "and"); !ok {
+			return left, nil
+		}
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryNode{operator: "and", left: left, right: right}
+	}
+}
+
+func (p *parser) parseNot() (node, error) {
+	if _, ok := p.accept(tokenWord, "not"); ok {
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return unaryNode{operator: "not", operand: operand}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parseConcatenation()
+	if err != nil {
+		return nil, err
+	}
+	operator, ok := p.accept(tokenOperator, "=", "!=", "<>", "<", "<=", ">", ">=")
+	if !ok {
+		return left, nil
+	}
+	if operator == "<>" {
+		operator = "!="
+	}
+	right, err := p.parseConcatenation()
+	if err != nil {
+		return nil, err
+	}
+	return binaryNode{operator: operator, left: left, right: right}, nil
+}
+
+func (p *parser) parseConcatenation() (node, error) {
+	left, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.accept(tokenOperator, "&"); !ok {
+			return left, nil
+		}
+		right, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryNode{operator: "&", left: left, right: right}
+	}
+}
+
+func (p *parser) parseAdditive() (node, error) {
+	left, err := p.parseMultiplicative()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		operator, ok := p.accept(tokenOperator, "+", "-")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseMultiplicative()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryNode{operator: operator, left: left, right: right}
+	}
+}
+
+func (p *parser) parseMultiplicative() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		operator, ok := p.accept(tokenOperator, "*", "/")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryNode{operator: operator, left: left, right: right}
+	}
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if _, ok := p.accept(tokenOperator, "-"); ok {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return unaryNode{operator: "-", operand: operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t, ok := p.peek()
+	if !ok {
+		return nil, newError("", "the formula ends unexpectedly")
+	}
+	p.position++
+
+	switch t.kind {
+	case tokenNumber:
+		value, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, newError("", "%q is not a number", t.text)
+		}
+		return numberNode{value: value}, nil
+	case tokenText:
+		return textNode{value: t.text}, nil
+	case tokenField:
+		if t.text == "" {
+			return nil, newError("", "a field reference is empty")
+		}
+		path := strings.Split(t.text, ".")
+		for i := range path {
+			path[i] = strings.TrimSpace(path[i])
+		}
+		return fieldNode{path: path}, nil
+	case tokenOpen:
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := p.accept(tokenClose, ")"); !ok {
+			return nil, newError("", "a parenthesis is not closed")
+		}
+		return inner, nil
+	case tokenWord:
+		switch strings.ToLower(t.text) {
+		case "true":
+			return boolNode{value: true}, nil
+		case "false":
+			return boolNode{value: false}, nil
+		}
+		if _, ok := p.accept(tokenOpen, "("); !ok {
+			return nil, newError("", "unknown word %q, fields are written between braces: {%s}", t.text, t.text)
+		}
+		return p.parseCall(strings.ToLower(t.text))
+	default:
+		return nil, newError("", "unexpected %q", t.text)
+	}
+}
+
+// parseCall reads the arguments of a function, after its opening parenthesis
+func (p *parser) parseCall(name string) (node, error) {
+	call := callNode{name: name, arguments: make([]node, 0)}
+	if _, ok := p.accept(tokenClose, ")"); ok {
+		return call, nil
+	}
+	for {
+		argument, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		call.arguments = append(call.arguments, argument)
+
+		if _, ok := p.accept(tokenClose, ")"); ok {
+			return call, nil
+		}
+		if _, ok := p.accept(tokenComma, ","); !ok {
+			return nil, newError("", "\",\" or \")\" expected in the arguments of %s", name)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package formulas
+
+import (
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokenNumber tokenKind = iota
+	tokenText
+	tokenField
+	tokenWord
+	tokenOperator
+	tokenOpen
+	tokenClose
+	tokenComma
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+func (t token) is(kind tokenKind, text string) bool {
+	return t.kind == kind && strings.EqualFold(t.text, text)
+}
+
+// operators are matched longest first
+var operators = []string{"<=", ">=", "!=", "<>", "+", "-", "*", "/", "&", "=", "<", ">"}
+
+func tokenize(expression string) ([]token, error) {
+	tokens := make([]token, 0)
+	runes := []rune(expression)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, token{kind: tokenOpen, text: "("})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{kind: tokenClose, text: ")"})
+			i++
+		case r == ',':
+			tokens = append(tokens, token{kind: tokenComma, text: ","})
+			i++
+		case r == '{':
+			start := i + 1
+			for i < len(runes) && runes[i] != '}' {
+				i++
+			}
+			if i >= len(runes) {
+				return nil, newError("", "a field reference is not closed")
+			}
+			tokens = append(tokens, token{kind: tokenField, text: strings.TrimSpace(string(runes[start:i]))})
+			i++
+		case r == '"':
+			var text strings.Builder
+			i++
+			closed := false
+			for i < len(runes) {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					text.WriteRune(runes[i+1])
+					i += 2
+					continue
+				}
+				if runes[i] == '"' {
+					closed = true
+					i++
+					break
+				}
+				text.WriteRune(runes[i])
+				i++
+			}
+			if !closed {
+				return nil, newError("", "a text is not closed")
+			}
+			tokens = append(tokens, token{kind: tokenText, text: text.String()})
+		case unicode.IsDigit(r) || (r == '.' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			start := i
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: string(runes[start:i])})
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_') {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenWord, text: string(runes[start:i])})
+		default:
+			matched := false
+			for _, operator := range operators {
+				if strings.HasPrefix(string(runes[i:]), operator) {
+					tokens = append(tokens, token{kind: tokenOperator, text: operator})
+					i += len([]rune(operator))
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, newError("", "unexpected character %q", r)
+			}
+		}
+	}
+
+	return tokens, nil
+}
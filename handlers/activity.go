@@ -3,6 +3,7 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -88,6 +89,7 @@ func (handler *AppHandler) GetAllActivities(mux chi.Router, db getAllActivitiesI
 }
 
 type createActivityInterface interface {
+	GetActivity(ctx context.Context, arg storage.GetActivityParams) (*models.Activity, error)
 	CreateActivity(ctx context.Context, arg storage.CreateActivityParams) (*models.Activity, error)
 }
 
@@ -121,6 +123,29 @@ func (handler *AppHandler) CreateActivity(mux chi.Router, db createActivityInter
 			fields = input.Fields
 		}
 
+		if fieldErrors := models.ValidateFieldDetails(fields); len(fieldErrors) > 0 {
+			writeFieldErrors(w, "ERR_ATVT_CRT_DETAILS", fieldErrors)
+			return
+		}
+		if fieldErrors := models.ValidateDefaultValues(fields); len(fieldErrors) > 0 {
+			writeFieldErrors(w, "ERR_ATVT_CRT_DEFAULT_VALUE", fieldErrors)
+			return
+		}
+		if fieldErrors := models.ValidateFieldRules(fields); len(fieldErrors) > 0 {
+			writeFieldErrors(w, "ERR_ATVT_CRT_RULES", fieldErrors)
+			return
+		}
+		if fieldErrors := models.ValidateFieldConditions(fields); len(fieldErrors) > 0 {
+			writeFieldErrors(w, "ERR_ATVT_CRT_CONDITIONS", fieldErrors)
+			return
+		}
+
+		_, err = compileFormulas(ctx, &models.Activity{Fields: fields, OrganizationId: organization.Id}, db)
+		if err != nil {
+			writeFormulaError(w, "ERR_ATVT_CRT_FORMULA", err)
+			return
+		}
+
 		activity, err := db.CreateActivity(ctx, storage.CreateActivityParams{
 			Name:        input.Name,
 			Description: input.Description,
@@ -173,7 +198,7 @@ func (handler *AppHandler) GetActivity(mux chi.Router, db getActivityInterface)
 }
 
 type deleteActivityInterface interface {
-	DeleteActivity(ctx context.Context, arg storage.DeleteActivityParams) error
+	DeleteActivityTx(ctx context.Context, arg storage.DeleteActivityParams) error
 }
 
 type DeleteActivityResponse struct {
@@ -187,9 +212,11 @@ func (handler *AppHandler) DeleteActivity(mux chi.Router, db deleteActivityInter
 		organization := ctx.Value("organization").(*models.Organization)
 		activity := ctx.Value("activity").(*models.Activity)
 
-		err := db.DeleteActivity(ctx, storage.DeleteActivityParams{
+		// The data go to the trash with the activity
+		err := db.DeleteActivityTx(ctx, storage.DeleteActivityParams{
 			Id:             activity.Id,
 			OrganizationId: organization.Id,
+			DeletedBy:      dataAuthor(handler.GetAuthenticatedUser(r)),
 		})
 		if err != nil {
 			http.Error(w, "ERR_ATVT_DLT_01", http.StatusBadRequest)
@@ -210,9 +237,13 @@ func (handler *AppHandler) DeleteActivity(mux chi.Router, db deleteActivityInter
 }
 
 type updateActivityInterface interface {
+	GetActivity(ctx context.Context, arg storage.GetActivityParams) (*models.Activity, error)
+	GetAllActivities(ctx context.Context, arg storage.GetAllActivitiesParams) ([]*models.Activity, error)
 	UpdateSetInActivityTx(ctx context.Context, arg storage.UpdateSetInActivityTxParams) (*models.Activity, error)
-	UpdateAddToActivity(ctx context.Context, arg storage.UpdateAddToActivityParams) (*models.Activity, error)
+	UpdateAddToActivityTx(ctx context.Context, arg storage.UpdateAddToActivityTxParams) (*models.Activity, error)
 	UpdateRemoveFromActivityTx(ctx context.Context, arg storage.UpdateRemoveFromActivityTxParams) (*models.Activity, error)
+	MigrateDataTx(ctx context.Context, arg storage.MigrateDataTxParams) error
+	RefreshDataUniqueKeysTx(ctx context.Context, arg storage.RefreshDataUniqueKeysTxParams) error
 }
 
 type UpdateActivityRequest struct {
@@ -220,6 +251,9 @@ type UpdateActivityRequest struct {
 	Field     string      `json:"field"`
 	Value     interface{} `json:"value"`
 	Position  uint64      `json:"position"`
+	// What happens to the values of the existing data when a field is removed
+	// or its type changes: convert, clear or reject
+	Migration string `json:"migration"`
 }
 
 type UpdateActivityResponse struct {
@@ -239,6 +273,37 @@ func (handler *AppHandler) UpdateActivity(mux chi.Router, db updateActivityInter
 
 		organization := ctx.Value("organization").(*models.Organization)
 		activity := ctx.Value("activity").(*models.Activity)
+		authUser := handler.GetAuthenticatedUser(r)
+
+		migrations, err := planActivityMigrations(activity, input)
+		if err != nil {
+			http.Error(w, "ERR_ATVT_UDT_MIGRATION", http.StatusBadRequest)
+			return
+		}
+
+		// The details, the default values, the conditions and the formulas using the fields updated must still be valid
+		if fields, ok := activityFieldsAfterUpdate(activity, input); ok {
+			if fieldErrors := models.ValidateFieldDetails(fields); len(fieldErrors) > 0 {
+				writeFieldErrors(w, "ERR_ATVT_UDT_DETAILS", fieldErrors)
+				return
+			}
+			if fieldErrors := models.ValidateDefaultValues(fields); len(fieldErrors) > 0 {
+				writeFieldErrors(w, "ERR_ATVT_UDT_DEFAULT_VALUE", fieldErrors)
+				return
+			}
+			if fieldErrors := models.ValidateFieldRules(fields); len(fieldErrors) > 0 {
+				writeFieldErrors(w, "ERR_ATVT_UDT_RULES", fieldErrors)
+				return
+			}
+			if fieldErrors := models.ValidateFieldConditions(fields); len(fieldErrors) > 0 {
+				writeFieldErrors(w, "ERR_ATVT_UDT_CONDITIONS", fieldErrors)
+				return
+			}
+			if err := checkActivityFormulas(ctx, activity, fields, db); err != nil {
+				writeFormulaError(w, "ERR_ATVT_UDT_FORMULA", err)
+				return
+			}
+		}
 
 		var updatedActivity *models.Activity
 		field := strings.ToLower(input.Field)
@@ -267,59 +332,66 @@ func (handler *AppHandler) UpdateActivity(mux chi.Router, db updateActivityInter
 				set[field] = v
 
 			case fieldSplitten[len(fieldSplitten)-1] == "details":
+				if len(fieldSplitten) < 3 {
+					http.Error(w, "ERR_ATVT_UDT_015", http.StatusBadRequest)
+					return
+				}
 				position, err := strconv.Atoi(fieldSplitten[1])
-				if err != nil {
+				if err != nil || position < 0 || position >= len(activity.Fields) {
 					http.Error(w, "ERR_ATVT_UDT_015", http.StatusBadRequest)
 					return
 				}
 
-				switch activity.Fields[position].Type {
-				case "key":
-					t, _ := json.Marshal(input.Value)
-					j := []byte(t)
-					var v models.ActivityFieldKey
-					err := json.Unmarshal(j, &v)
-					if err != nil {
-						http.Error(w, "ERR_ATVT_UDT_014", http.StatusBadRequest)
-						return
-					}
+				t, _ := json.Marshal(input.Value)
+				details, err := models.DecodeActivityFieldType(activity.Fields[position].Type, t)
+				if err != nil {
+					http.Error(w, "ERR_ATVT_UDT_014", http.StatusBadRequest)
+					return
+				}
 
+				// the types without details, like text, number, date or time, have nothing to set
+				if v := details.Value(); v != nil {
 					set[field] = v
+				}
 
-				case "upload":
+			case fieldSplitten[len(fieldSplitten)-1] == "type":
+				v := input.Value.(string)
+				set[field] = v
+				set[fmt.Sprintf("fields.%s.details", fieldSplitten[1])] = models.NewActivityFieldType(v)
+
+			case fieldSplitten[len(fieldSplitten)-1] == "rules":
+				var v *models.ActivityFieldRules
+				if input.Value != nil {
 					t, _ := json.Marshal(input.Value)
-					j := []byte(t)
-					var v models.ActivityFieldUpload
-					err := json.Unmarshal(j, &v)
-					if err != nil {
+					if err := json.Unmarshal(t, &v); err != nil {
 						http.Error(w, "ERR_ATVT_UDT_014", http.StatusBadRequest)
 						return
 					}
+				}
 
-					set[field] = v
+				set[field] = v
 
-				case "multiple-choices":
+			case fieldSplitten[len(fieldSplitten)-1] == "conditions":
+				var v *models.ActivityFieldConditions
+				if input.Value != nil {
 					t, _ := json.Marshal(input.Value)
-					j := []byte(t)
-					var v models.ActivityFieldMultipleChoices
-					err := json.Unmarshal(j, &v)
-					if err != nil {
+					if err := json.Unmarshal(t, &v); err != nil {
 						http.Error(w, "ERR_ATVT_UDT_014", http.StatusBadRequest)
 						return
 					}
+				}
 
-					set[field] = v
+				set[field] = v
 
-				case "date":
-				case "time":
+			case fieldSplitten[len(fieldSplitten)-1] == "default_value", fieldSplitten[len(fieldSplitten)-1] == "formula":
+				switch input.Value.(type) {
+				case nil, string:
+					set[field] = input.Value
 				default:
+					http.Error(w, "ERR_ATVT_UDT_014", http.StatusBadRequest)
+					return
 				}
 
-			case fieldSplitten[len(fieldSplitten)-1] == "type":
-				v := input.Value.(string)
-				set[field] = v
-				set[fmt.Sprintf("fields.%s.details", fieldSplitten[1])] = models.NewActivityFieldType(v)
-
 			default:
 				// TODO: check type of input.Value string|int|bool
 				set[field] = input.Value
@@ -333,26 +405,38 @@ func (handler *AppHandler) UpdateActivity(mux chi.Router, db updateActivityInter
 
 				Field:   field,
 				Details: set[field],
+
+				Migrations: migrations,
+				Author:     dataAuthor(authUser),
 			})
 		case "add":
 			if field != "fields" {
 				http.Error(w, "ERR_ATVT_UDT_011", http.StatusBadRequest)
 				return
 			}
-			if err, ok := input.Value.(models.ActivityField); !ok {
-				log.Printf("Error : %+v\n%+v\n\n%+v\n\n", err, input.Value, input)
-				// http.Error(w, "ERR_ATVT_UDT_014", http.StatusBadRequest)
-				// return
-
+			fieldValue, ok := input.Value.(map[string]any)
+			if !ok {
+				http.Error(w, "ERR_ATVT_UDT_014", http.StatusBadRequest)
+				return
+			}
+			fieldType, okType := getOrDefault(fieldValue, "type", "text").(string)
+			name, okName := getOrDefault(fieldValue, "name", "").(string)
+			description, okDescription := getOrDefault(fieldValue, "description", "").(string)
+			primaryKey, okPrimaryKey := getOrDefault(fieldValue, "primary_key", false).(bool)
+			unique, okUnique := getOrDefault(fieldValue, "unique", false).(bool)
+			code, okCode := getOrDefault(fieldValue, "code", "").(string)
+			if !okType || !okName || !okDescription || !okPrimaryKey || !okUnique || !okCode {
+				http.Error(w, "ERR_ATVT_UDT_014", http.StatusBadRequest)
+				return
 			}
-			fieldType := getOrDefault(input.Value.(map[string]any), "type", "text").(string)
 			value := models.ActivityField{
 				Id:          primitive.NewObjectID(),
-				Name:        getOrDefault(input.Value.(map[string]any), "name", "").(string),
-				Description: getOrDefault(input.Value.(map[string]any), "description", "").(string),
+				Name:        name,
+				Description: description,
 				Type:        fieldType,
-				PrimaryKey:  getOrDefault(input.Value.(map[string]any), "id", false).(bool),
-				Code:        getOrDefault(input.Value.(map[string]any), "code", "").(string),
+				PrimaryKey:  primaryKey,
+				Unique:      unique,
+				Code:        code,
 				Options: models.ActivityFieldOptions{
 					Reference:    nil,
 					DefaultValue: nil,
@@ -362,8 +446,8 @@ func (handler *AppHandler) UpdateActivity(mux chi.Router, db updateActivityInter
 				Details: models.NewActivityFieldType(fieldType),
 			}
 
-			updatedActivity, err = db.UpdateAddToActivity(ctx, storage.UpdateAddToActivityParams{
-				Id:             activity.Id,
+			updatedActivity, err = db.UpdateAddToActivityTx(ctx, storage.UpdateAddToActivityTxParams{
+				Activity:       *activity,
 				OrganizationId: organization.Id,
 
 				Field:    field,
@@ -384,17 +468,49 @@ func (handler *AppHandler) UpdateActivity(mux chi.Router, db updateActivityInter
 
 				Field:    field,
 				Position: uint(input.Position),
+
+				Migrations: migrations,
+				Author:     dataAuthor(authUser),
 			})
 		default:
 			http.Error(w, "ERR_ATVT_UDT_013", http.StatusBadRequest)
 			return
 		}
 
+		if errors.Is(err, storage.ErrDataNotUnique) {
+			http.Error(w, "ERR_ATVT_UDT_NOT_UNIQUE", http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, storage.ErrMigrationRejected) {
+			http.Error(w, "ERR_ATVT_UDT_MIGRATION_REJECTED", http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, storage.ErrMigrationPending) {
+			http.Error(w, "ERR_ATVT_UDT_MIGRATION_PENDING", http.StatusConflict)
+			return
+		}
 		if err != nil {
 			http.Error(w, "ERR_ATVT_UDT_02", http.StatusBadRequest)
 			return
 		}
 
+		// The fields are updated, a migration which fails here is resumed later from its last batch
+		if updatedActivity.Migration != nil {
+			if err := db.MigrateDataTx(ctx, storage.MigrateDataTxParams{Activity: *updatedActivity}); err != nil {
+				log.Printf("Error while migrating the data of the activity %s: %v", updatedActivity.Id.Hex(), err)
+			} else {
+				updatedActivity.Migration = nil
+			}
+		}
+		// The unique constraints changed, a refresh of the keys of the data which fails here is resumed later too
+		if updatedActivity.UniqueKeysRefresh != nil {
+			if err := db.RefreshDataUniqueKeysTx(ctx, storage.RefreshDataUniqueKeysTxParams{Activity: *updatedActivity}); err != nil {
+				log.Printf("Error while refreshing the unique keys of the data of the activity %s: %v", updatedActivity.Id.Hex(), err)
+			} else {
+				updatedActivity.UniqueKeysRefresh = nil
+			}
+		}
+
 		response := UpdateActivityResponse{
 			Activity: *updatedActivity,
 		}
@@ -408,6 +524,184 @@ func (handler *AppHandler) UpdateActivity(mux chi.Router, db updateActivityInter
 	})
 }
 
+// planActivityMigrations returns the migrations of the values of the existing data needed by the update
+func planActivityMigrations(activity *models.Activity, input UpdateActivityRequest) ([]models.FieldMigration, error) {
+	if input.Migration != "" && !models.IsMigrationStrategy(input.Migration) {
+		return nil, fmt.Errorf("unknown migration strategy %q", input.Migration)
+	}
+
+	fields, ok := activityFieldsAfterUpdate(activity, input)
+	if !ok {
+		return []models.FieldMigration{}, nil
+	}
+	return models.PlanFieldMigrations(activity.Fields, fields, input.Migration), nil
+}
+
+// activityFieldsAfterUpdate returns the fields of the activity once updated,
+// or false when the update does not change what the values of the fields are, their details,
+// their rules, their conditions, their default values or how they are computed
+func activityFieldsAfterUpdate(activity *models.Activity, input UpdateActivityRequest) ([]models.ActivityField, bool) {
+	field := strings.ToLower(input.Field)
+	fieldSplitten := strings.Split(field, ".")
+	fields := append([]models.ActivityField{}, activity.Fields...)
+
+	switch strings.ToLower(input.Operation) {
+	case "set":
+		if field == "fields" {
+			t, _ := json.Marshal(input.Value)
+			var v []models.ActivityField
+			if err := json.Unmarshal(t, &v); err != nil {
+				return nil, false
+			}
+			return v, true
+		}
+
+		if len(fieldSplitten) < 3 || fieldSplitten[0] != "fields" {
+			return nil, false
+		}
+		position, err := strconv.Atoi(fieldSplitten[1])
+		if err != nil || position < 0 || position >= len(fields) {
+			return nil, false
+		}
+
+		switch strings.Join(fieldSplitten[2:], ".") {
+		case "type":
+			v, ok := input.Value.(string)
+			if !ok {
+				return nil, false
+			}
+			fields[position].Type = v
+			fields[position].Details = models.NewActivityFieldType(v)
+		case "options.multiple":
+			v, ok := input.Value.(bool)
+			if !ok {
+				return nil, false
+			}
+			fields[position].Options.Multiple = v
+		case "options.automatic":
+			v, ok := input.Value.(bool)
+			if !ok {
+				return nil, false
+			}
+			fields[position].Options.Automatic = v
+		case "options.formula":
+			switch v := input.Value.(type) {
+			case nil:
+				fields[position].Options.Formula = nil
+			case string:
+				fields[position].Options.Formula = &v
+			default:
+				return nil, false
+			}
+		case "rules":
+			var v *models.ActivityFieldRules
+			if input.Value != nil {
+				t, _ := json.Marshal(input.Value)
+				if err := json.Unmarshal(t, &v); err != nil {
+					return nil, false
+				}
+			}
+			fields[position].Rules = v
+		case "conditions":
+			var v *models.ActivityFieldConditions
+			if input.Value != nil {
+				t, _ := json.Marshal(input.Value)
+				if err := json.Unmarshal(t, &v); err != nil {
+					return nil, false
+				}
+			}
+			fields[position].Conditions = v
+		case "options.default_value":
+			switch v := input.Value.(type) {
+			case nil:
+				fields[position].Options.DefaultValue = nil
+			case string:
+				fields[position].Options.DefaultValue = &v
+			default:
+				return nil, false
+			}
+		case "code":
+			v, ok := input.Value.(string)
+			if !ok {
+				return nil, false
+			}
+			fields[position].Code = v
+		case "details":
+			t, _ := json.Marshal(input.Value)
+			details, err := models.DecodeActivityFieldType(fields[position].Type, t)
+			if err != nil || details.Value() == nil {
+				return nil, false
+			}
+			fields[position].Details = details
+		default:
+			return nil, false
+		}
+		return fields, true
+
+	case "remove":
+		if field != "fields" || input.Position >= uint64(len(fields)) {
+			return nil, false
+		}
+		return append(fields[:input.Position], fields[input.Position+1:]...), true
+
+	default:
+		return nil, false
+	}
+}
+
+type previewActivityUpdateInterface interface {
+	PreviewDataMigration(ctx context.Context, arg storage.PreviewDataMigrationParams) ([]models.FieldMigrationImpact, error)
+}
+
+type PreviewActivityUpdateResponse struct {
+	Version    int                           `json:"version"`
+	Migrations []models.FieldMigrationImpact `json:"migrations"`
+}
+
+// PreviewActivityUpdate tells how many data an update of the activity would change,
+// for the same body as the update, without updating anything
+func (handler *AppHandler) PreviewActivityUpdate(mux chi.Router, db previewActivityUpdateInterface) {
+	mux.Post("/preview", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		var input UpdateActivityRequest
+		httpStatus, err := handler.ParsingRequestBody(w, r, &input)
+		if err != nil {
+			http.Error(w, err.Error(), httpStatus)
+			return
+		}
+
+		activity := ctx.Value("activity").(*models.Activity)
+
+		migrations, err := planActivityMigrations(activity, input)
+		if err != nil {
+			http.Error(w, "ERR_ATVT_PRVW_MIGRATION", http.StatusBadRequest)
+			return
+		}
+
+		impacts, err := db.PreviewDataMigration(ctx, storage.PreviewDataMigrationParams{
+			ActivityId: activity.Id,
+			Migrations: migrations,
+		})
+		if err != nil {
+			http.Error(w, "ERR_ATVT_PRVW_01", http.StatusBadRequest)
+			return
+		}
+
+		response := PreviewActivityUpdateResponse{
+			Version:    activity.Version,
+			Migrations: impacts,
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			http.Error(w, "ERR_ATVT_PRVW_END", http.StatusBadRequest)
+			return
+		}
+	})
+}
+
 func getOrDefault(m map[string]any, key string, defaultValue any) any {
 	value, ok := m[key]
 	if ok {
@@ -10,6 +10,7 @@ import (
 	"sort"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	gofaker "github.com/go-faker/faker/v4"
@@ -23,6 +24,9 @@ import (
 
 func TestActivity(t *testing.T) {
 	handler := handlers.NewAppHandler()
+	handler.GetAuthenticatedUser = func(r *http.Request) *models.User {
+		return authenticatedUser
+	}
 
 	tests := map[string]func(*testing.T, *handlers.AppHandler){
 		"ActivityMiddleware": testActivityMiddleware,
@@ -330,6 +334,11 @@ type mockCreateActivityDB struct {
 	CreateActivityFunc func(ctx context.Context, arg storage.CreateActivityParams) (*models.Activity, error)
 }
 
+// GetActivity finds no activity referenced by a formula
+func (mdb *mockCreateActivityDB) GetActivity(ctx context.Context, arg storage.GetActivityParams) (*models.Activity, error) {
+	return nil, nil
+}
+
 func (mdb *mockCreateActivityDB) CreateActivity(ctx context.Context, arg storage.CreateActivityParams) (*models.Activity, error) {
 	return mdb.CreateActivityFunc(ctx, arg)
 }
@@ -519,21 +528,49 @@ func testGetActivity(t *testing.T, handler *handlers.AppHandler) {
 }
 
 type mockUpdateActivityDB struct {
-	UpdateSetInActivityFunc      func(ctx context.Context, arg storage.UpdateSetInActivityParams) (*models.Activity, error)
-	UpdateAddToActivityFunc      func(ctx context.Context, arg storage.UpdateAddToActivityParams) (*models.Activity, error)
-	UpdateRemoveFromActivityFunc func(ctx context.Context, arg storage.UpdateRemoveFromActivityParams) (*models.Activity, error)
+	GetActivityFunc                func(ctx context.Context, arg storage.GetActivityParams) (*models.Activity, error)
+	GetAllActivitiesFunc           func(ctx context.Context, arg storage.GetAllActivitiesParams) ([]*models.Activity, error)
+	UpdateSetInActivityTxFunc      func(ctx context.Context, arg storage.UpdateSetInActivityTxParams) (*models.Activity, error)
+	UpdateAddToActivityTxFunc      func(ctx context.Context, arg storage.UpdateAddToActivityTxParams) (*models.Activity, error)
+	UpdateRemoveFromActivityTxFunc func(ctx context.Context, arg storage.UpdateRemoveFromActivityTxParams) (*models.Activity, error)
+	MigrateDataTxFunc              func(ctx context.Context, arg storage.MigrateDataTxParams) error
+	RefreshDataUniqueKeysTxFunc    func(ctx context.Context, arg storage.RefreshDataUniqueKeysTxParams) error
+}
+
+// GetActivity finds no activity referenced by a formula unless the test needs one
+func (mdb *mockUpdateActivityDB) GetActivity(ctx context.Context, arg storage.GetActivityParams) (*models.Activity, error) {
+	if mdb.GetActivityFunc == nil {
+		return nil, nil
+	}
+	return mdb.GetActivityFunc(ctx, arg)
+}
+
+// GetAllActivities finds no other activity unless the test needs some
+func (mdb *mockUpdateActivityDB) GetAllActivities(ctx context.Context, arg storage.GetAllActivitiesParams) ([]*models.Activity, error) {
+	if mdb.GetAllActivitiesFunc == nil {
+		return []*models.Activity{}, nil
+	}
+	return mdb.GetAllActivitiesFunc(ctx, arg)
+}
+
+func (mdb *mockUpdateActivityDB) UpdateSetInActivityTx(ctx context.Context, arg storage.UpdateSetInActivityTxParams) (*models.Activity, error) {
+	return mdb.UpdateSetInActivityTxFunc(ctx, arg)
+}
+
+func (mdb *mockUpdateActivityDB) UpdateAddToActivityTx(ctx context.Context, arg storage.UpdateAddToActivityTxParams) (*models.Activity, error) {
+	return mdb.UpdateAddToActivityTxFunc(ctx, arg)
 }
 
-func (mdb *mockUpdateActivityDB) UpdateSetInActivity(ctx context.Context, arg storage.UpdateSetInActivityParams) (*models.Activity, error) {
-	return mdb.UpdateSetInActivityFunc(ctx, arg)
+func (mdb *mockUpdateActivityDB) UpdateRemoveFromActivityTx(ctx context.Context, arg storage.UpdateRemoveFromActivityTxParams) (*models.Activity, error) {
+	return mdb.UpdateRemoveFromActivityTxFunc(ctx, arg)
 }
 
-func (mdb *mockUpdateActivityDB) UpdateAddToActivity(ctx context.Context, arg storage.UpdateAddToActivityParams) (*models.Activity, error) {
-	return mdb.UpdateAddToActivityFunc(ctx, arg)
+func (mdb *mockUpdateActivityDB) MigrateDataTx(ctx context.Context, arg storage.MigrateDataTxParams) error {
+	return mdb.MigrateDataTxFunc(ctx, arg)
 }
 
-func (mdb *mockUpdateActivityDB) UpdateRemoveFromActivity(ctx context.Context, arg storage.UpdateRemoveFromActivityParams) (*models.Activity, error) {
-	return mdb.UpdateRemoveFromActivityFunc(ctx, arg)
+func (mdb *mockUpdateActivityDB) RefreshDataUniqueKeysTx(ctx context.Context, arg storage.RefreshDataUniqueKeysTxParams) error {
+	return mdb.RefreshDataUniqueKeysTxFunc(ctx, arg)
 }
 
 func testUpdateActivity(t *testing.T, handler *handlers.AppHandler) {
@@ -590,13 +627,13 @@ func testUpdateActivity(t *testing.T, handler *handlers.AppHandler) {
 			CreatedBy:      primitive.NewObjectID(),
 		}
 		db := &mockUpdateActivityDB{
-			UpdateSetInActivityFunc: func(ctx context.Context, arg storage.UpdateSetInActivityParams) (*models.Activity, error) {
+			UpdateSetInActivityTxFunc: func(ctx context.Context, arg storage.UpdateSetInActivityTxParams) (*models.Activity, error) {
 				return nil, nil
 			},
-			UpdateAddToActivityFunc: func(ctx context.Context, arg storage.UpdateAddToActivityParams) (*models.Activity, error) {
+			UpdateAddToActivityTxFunc: func(ctx context.Context, arg storage.UpdateAddToActivityTxParams) (*models.Activity, error) {
 				return nil, nil
 			},
-			UpdateRemoveFromActivityFunc: func(ctx context.Context, arg storage.UpdateRemoveFromActivityParams) (*models.Activity, error) {
+			UpdateRemoveFromActivityTxFunc: func(ctx context.Context, arg storage.UpdateRemoveFromActivityTxParams) (*models.Activity, error) {
 				return nil, nil
 			},
 		}
@@ -642,6 +679,51 @@ func testUpdateActivity(t *testing.T, handler *handlers.AppHandler) {
 				HttpStatusCode: http.StatusBadRequest,
 				ResponseError:  "ERR_ATVT_UDT_013",
 			},
+			"add a field which is not an object": {
+				Input: handlers.UpdateActivityRequest{
+					Operation: "add",
+					Field:     "fields",
+					Value:     sfaker.App().String(),
+				},
+				HttpStatusCode: http.StatusBadRequest,
+				ResponseError:  "ERR_ATVT_UDT_014",
+			},
+			"add a field with a name which is not a text": {
+				Input: handlers.UpdateActivityRequest{
+					Operation: "add",
+					Field:     "fields",
+					Value:     map[string]any{"name": 12, "type": "text"},
+				},
+				HttpStatusCode: http.StatusBadRequest,
+				ResponseError:  "ERR_ATVT_UDT_014",
+			},
+			"set the details without a field": {
+				Input: handlers.UpdateActivityRequest{
+					Operation: "set",
+					Field:     "fields.details",
+					Value:     map[string]any{},
+				},
+				HttpStatusCode: http.StatusBadRequest,
+				ResponseError:  "ERR_ATVT_UDT_015",
+			},
+			"set the details of a field after the last one": {
+				Input: handlers.UpdateActivityRequest{
+					Operation: "set",
+					Field:     "fields.2.details",
+					Value:     map[string]any{},
+				},
+				HttpStatusCode: http.StatusBadRequest,
+				ResponseError:  "ERR_ATVT_UDT_015",
+			},
+			"set the details of a negative position": {
+				Input: handlers.UpdateActivityRequest{
+					Operation: "set",
+					Field:     "fields.-1.details",
+					Value:     map[string]any{},
+				},
+				HttpStatusCode: http.StatusBadRequest,
+				ResponseError:  "ERR_ATVT_UDT_015",
+			},
 		}
 
 		for name, tc := range testCases {
@@ -812,13 +894,13 @@ func testUpdateActivity(t *testing.T, handler *handlers.AppHandler) {
 			CreatedBy:      primitive.NewObjectID(),
 		}
 		db := &mockUpdateActivityDB{
-			UpdateSetInActivityFunc: func(ctx context.Context, arg storage.UpdateSetInActivityParams) (*models.Activity, error) {
+			UpdateSetInActivityTxFunc: func(ctx context.Context, arg storage.UpdateSetInActivityTxParams) (*models.Activity, error) {
 				return nil, errors.New("an error happens")
 			},
-			UpdateAddToActivityFunc: func(ctx context.Context, arg storage.UpdateAddToActivityParams) (*models.Activity, error) {
+			UpdateAddToActivityTxFunc: func(ctx context.Context, arg storage.UpdateAddToActivityTxParams) (*models.Activity, error) {
 				return nil, errors.New("an error happens")
 			},
-			UpdateRemoveFromActivityFunc: func(ctx context.Context, arg storage.UpdateRemoveFromActivityParams) (*models.Activity, error) {
+			UpdateRemoveFromActivityTxFunc: func(ctx context.Context, arg storage.UpdateRemoveFromActivityTxParams) (*models.Activity, error) {
 				return nil, errors.New("an error happens")
 			},
 		}
@@ -841,7 +923,7 @@ func testUpdateActivity(t *testing.T, handler *handlers.AppHandler) {
 				Input: handlers.UpdateActivityRequest{
 					Operation: "add",
 					Field:     "Fields",
-					Value:     sfaker.App().String(),
+					Value:     map[string]any{"name": sfaker.App().String(), "type": "text"},
 				},
 				HttpStatusCode: http.StatusBadRequest,
 				ResponseError:  "ERR_ATVT_UDT_02",
@@ -896,11 +978,11 @@ func testUpdateActivity(t *testing.T, handler *handlers.AppHandler) {
 			{
 				Operation: "add",
 				Field:     "Fields",
-				Value: models.ActivityField{
-					Name:        sfaker.App().String(),
-					Description: gofaker.Paragraph(),
-					Type:        "number",
-					Code:        sfaker.App().Name(),
+				Value: map[string]any{
+					"name":        sfaker.App().String(),
+					"description": gofaker.Paragraph(),
+					"type":        "number",
+					"code":        sfaker.App().Name(),
 				},
 				Position: 0,
 			},
@@ -919,12 +1001,12 @@ func testUpdateActivity(t *testing.T, handler *handlers.AppHandler) {
 
 		mux := chi.NewMux()
 		db := &mockUpdateActivityDB{
-			UpdateSetInActivityFunc: func(ctx context.Context, arg storage.UpdateSetInActivityParams) (*models.Activity, error) {
+			UpdateSetInActivityTxFunc: func(ctx context.Context, arg storage.UpdateSetInActivityTxParams) (*models.Activity, error) {
 				updatedActivity := activity
 				updatedActivity.Name = dataRequest[0].Value.(string)
 				return updatedActivity, nil
 			},
-			UpdateAddToActivityFunc: func(ctx context.Context, arg storage.UpdateAddToActivityParams) (*models.Activity, error) {
+			UpdateAddToActivityTxFunc: func(ctx context.Context, arg storage.UpdateAddToActivityTxParams) (*models.Activity, error) {
 				updatedActivity := &models.Activity{
 					Id:             activity.Id,
 					Name:           activity.Name,
@@ -934,10 +1016,10 @@ func testUpdateActivity(t *testing.T, handler *handlers.AppHandler) {
 					Fields:         []models.ActivityField{},
 				}
 				updatedActivity.Fields = append(updatedActivity.Fields, activity.Fields...)
-				updatedActivity.Fields = append(updatedActivity.Fields, dataRequest[1].Value.(models.ActivityField))
+				updatedActivity.Fields = append(updatedActivity.Fields, arg.Value)
 				return updatedActivity, nil
 			},
-			UpdateRemoveFromActivityFunc: func(ctx context.Context, arg storage.UpdateRemoveFromActivityParams) (*models.Activity, error) {
+			UpdateRemoveFromActivityTxFunc: func(ctx context.Context, arg storage.UpdateRemoveFromActivityTxParams) (*models.Activity, error) {
 				updatedActivity := &models.Activity{
 					Id:             activity.Id,
 					Name:           activity.Name,
@@ -990,8 +1072,8 @@ func testUpdateActivity(t *testing.T, handler *handlers.AppHandler) {
 					if len(got.Activity.Fields) != len(activity.Fields)+1 {
 						t.Fatalf("UpdateActivity(): %s - Length Fields - got %d; want %d", name, len(got.Activity.Fields), len(activity.Fields)+1)
 					}
-					if got.Activity.Fields[len(got.Activity.Fields)-1] != dataRequest[1].Value.(models.ActivityField) {
-						t.Fatalf("UpdateActivity(): %s - Last Fields - got %+v; want %+v", name, got.Activity.Fields[len(got.Activity.Fields)-1], dataRequest[1].Value.(models.ActivityField))
+					if lastField := got.Activity.Fields[len(got.Activity.Fields)-1]; lastField.Name != dataRequest[1].Value.(map[string]any)["name"] {
+						t.Fatalf("UpdateActivity(): %s - Last Fields - got %+v; want %+v", name, lastField, dataRequest[1].Value)
 					}
 				},
 			},
@@ -1012,7 +1094,7 @@ func testUpdateActivity(t *testing.T, handler *handlers.AppHandler) {
 						t.Fatalf("UpdateActivity(): %s - Length Fields - got %d; want %d", name, len(got.Activity.Fields), len(activity.Fields)-1)
 					}
 					if got.Activity.Fields[0] != activity.Fields[0] {
-						t.Fatalf("UpdateActivity(): %s - Last Fields - got %v; want %v", name, got.Activity.Fields[len(got.Activity.Fields)-1], dataRequest[1].Value.(models.ActivityField))
+						t.Fatalf("UpdateActivity(): %s - Last Fields - got %v; want %v", name, got.Activity.Fields[len(got.Activity.Fields)-1], dataRequest[1].Value)
 					}
 				},
 			},
@@ -1095,14 +1177,161 @@ func testUpdateActivity(t *testing.T, handler *handlers.AppHandler) {
 		// 	t.Fatalf("UpdateActivity(): Name - got %s; want %s", got.Activity.Name, dataRequest.Value)
 		// }
 	})
+
+	t.Run("rejected formula", func(t *testing.T) {
+		mux := chi.NewMux()
+		organization := &models.Organization{Id: primitive.NewObjectID()}
+		quantity := models.ActivityField{Id: primitive.NewObjectID(), Code: "quantity", Type: models.FieldTypeNumber}
+		expression := "{quantity} * 2"
+		total := models.ActivityField{
+			Id:      primitive.NewObjectID(),
+			Code:    "total",
+			Type:    models.FieldTypeNumber,
+			Options: models.ActivityFieldOptions{Automatic: true, Formula: &expression},
+		}
+		activity := &models.Activity{
+			Id:             primitive.NewObjectID(),
+			Name:           sfaker.Hacker().Noun(),
+			Fields:         []models.ActivityField{quantity, total},
+			OrganizationId: organization.Id,
+		}
+		db := &mockUpdateActivityDB{
+			UpdateSetInActivityTxFunc: func(ctx context.Context, arg storage.UpdateSetInActivityTxParams) (*models.Activity, error) {
+				t.Errorf("UpdateActivity(): the formula is set; want it rejected")
+				return nil, nil
+			},
+		}
+
+		handler.UpdateActivity(mux, db)
+		code, _, response := helpertest.MakePatchRequest(
+			mux,
+			"/",
+			helpertest.CreateFormHeader(),
+			handlers.UpdateActivityRequest{
+				Operation: "set",
+				Field:     "fields.1.options.formula",
+				Value:     "round({quantity} / 3, 99)",
+			},
+			[]helpertest.ContextData{
+				{Name: "organization", Value: organization},
+				{Name: "activity", Value: activity},
+			},
+		)
+		if code != http.StatusBadRequest {
+			t.Fatalf("UpdateActivity(): status - got %d; want %d", code, http.StatusBadRequest)
+		}
+		got := handlers.DataValidationErrorResponse{}
+		json.Unmarshal([]byte(response), &got)
+		if got.Error != "ERR_ATVT_UDT_FORMULA" || len(got.Fields) != 1 || got.Fields[0].Code != "ERR_FIELD_INVALID_FORMULA" {
+			t.Fatalf("UpdateActivity(): response error - got %s; want ERR_ATVT_UDT_FORMULA on the formula", response)
+		}
+	})
+
+	t.Run("add a unique field", func(t *testing.T) {
+		organization := &models.Organization{Id: primitive.NewObjectID()}
+		activity := &models.Activity{
+			Id:   primitive.NewObjectID(),
+			Name: sfaker.Hacker().Noun(),
+			Fields: []models.ActivityField{
+				{Id: primitive.NewObjectID(), Code: "reference", Type: models.FieldTypeText},
+			},
+			OrganizationId: organization.Id,
+		}
+		input := handlers.UpdateActivityRequest{
+			Operation: "add",
+			Field:     "fields",
+			Value:     map[string]any{"name": "Serial number", "type": "text", "unique": true},
+			Position:  1,
+		}
+
+		testCases := map[string]struct {
+			Err            error
+			RefreshErr     error
+			HttpStatusCode int
+			ResponseError  string
+			Refreshing     bool // the refresh of the keys is left to be resumed
+		}{
+			"values already used": {
+				Err:            storage.ErrDataNotUnique,
+				HttpStatusCode: http.StatusBadRequest,
+				ResponseError:  "ERR_ATVT_UDT_NOT_UNIQUE",
+			},
+			"added": {
+				HttpStatusCode: http.StatusOK,
+			},
+			"keys not refreshed": {
+				RefreshErr:     errors.New("an error happens"),
+				HttpStatusCode: http.StatusOK,
+				Refreshing:     true,
+			},
+		}
+
+		for name, tc := range testCases {
+			t.Run(name, func(t *testing.T) {
+				refreshed := false
+				mux := chi.NewMux()
+				db := &mockUpdateActivityDB{
+					UpdateAddToActivityTxFunc: func(ctx context.Context, arg storage.UpdateAddToActivityTxParams) (*models.Activity, error) {
+						if arg.Activity.Id != activity.Id || !arg.Value.Unique || arg.Value.Name != "Serial number" || arg.Position != 1 {
+							t.Errorf("UpdateAddToActivityTx(): got %+v; want the unique field added at 1", arg)
+						}
+						if tc.Err != nil {
+							return nil, tc.Err
+						}
+						updated := *activity
+						updated.Fields = append(append([]models.ActivityField{}, activity.Fields...), arg.Value)
+						updated.UniqueKeysRefresh = &models.UniqueKeysRefresh{StartedAt: time.Now(), UpdatedAt: time.Now()}
+						return &updated, nil
+					},
+					RefreshDataUniqueKeysTxFunc: func(ctx context.Context, arg storage.RefreshDataUniqueKeysTxParams) error {
+						if arg.Activity.UniqueKeysRefresh == nil || len(arg.Activity.Fields) != 2 {
+							t.Errorf("RefreshDataUniqueKeysTx(): got %+v; want the activity with the unique field", arg.Activity)
+						}
+						refreshed = true
+						return tc.RefreshErr
+					},
+				}
+
+				handler.UpdateActivity(mux, db)
+				code, _, response := helpertest.MakePatchRequest(
+					mux,
+					"/",
+					helpertest.CreateFormHeader(),
+					input,
+					[]helpertest.ContextData{
+						{Name: "organization", Value: organization},
+						{Name: "activity", Value: activity},
+					},
+				)
+				if code != tc.HttpStatusCode {
+					t.Fatalf("UpdateActivity(): status - got %d; want %d (%s)", code, tc.HttpStatusCode, response)
+				}
+				if tc.ResponseError != "" {
+					if response != tc.ResponseError {
+						t.Fatalf("UpdateActivity(): response error - got %s, want %s", response, tc.ResponseError)
+					}
+					return
+				}
+
+				if !refreshed {
+					t.Fatalf("UpdateActivity(): unique keys not refreshed")
+				}
+				got := handlers.UpdateActivityResponse{}
+				json.Unmarshal([]byte(response), &got)
+				if (got.Activity.UniqueKeysRefresh != nil) != tc.Refreshing {
+					t.Fatalf("UpdateActivity(): refresh of the unique keys - got %+v; want in progress %v", got.Activity.UniqueKeysRefresh, tc.Refreshing)
+				}
+			})
+		}
+	})
 }
 
 type mockDeleteActivityDB struct {
-	DeleteActivityFunc func(ctx context.Context, arg storage.DeleteActivityParams) error
+	DeleteActivityTxFunc func(ctx context.Context, arg storage.DeleteActivityParams) error
 }
 
-func (mdb *mockDeleteActivityDB) DeleteActivity(ctx context.Context, arg storage.DeleteActivityParams) error {
-	return mdb.DeleteActivityFunc(ctx, arg)
+func (mdb *mockDeleteActivityDB) DeleteActivityTx(ctx context.Context, arg storage.DeleteActivityParams) error {
+	return mdb.DeleteActivityTxFunc(ctx, arg)
 }
 
 func testDeleteActivity(t *testing.T, handler *handlers.AppHandler) {
@@ -1138,7 +1367,7 @@ func testDeleteActivity(t *testing.T, handler *handlers.AppHandler) {
 			CreatedBy:      primitive.NewObjectID(),
 		}
 		db := &mockDeleteActivityDB{
-			DeleteActivityFunc: func(ctx context.Context, arg storage.DeleteActivityParams) error {
+			DeleteActivityTxFunc: func(ctx context.Context, arg storage.DeleteActivityParams) error {
 				return errors.New("an error happens")
 			},
 		}
@@ -1196,7 +1425,7 @@ func testDeleteActivity(t *testing.T, handler *handlers.AppHandler) {
 			CreatedBy:      primitive.NewObjectID(),
 		}
 		db := &mockDeleteActivityDB{
-			DeleteActivityFunc: func(ctx context.Context, arg storage.DeleteActivityParams) error {
+			DeleteActivityTxFunc: func(ctx context.Context, arg storage.DeleteActivityParams) error {
 				return nil
 			},
 		}
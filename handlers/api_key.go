@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"stockinos.com/api/models"
+	"stockinos.com/api/services"
+	"stockinos.com/api/storage"
+)
+
+type getApiKeysInterface interface {
+	GetApiKeys(ctx context.Context, arg storage.GetApiKeysParams) ([]*models.ApiKey, error)
+}
+
+type GetApiKeysResponse struct {
+	ApiKeys []*models.ApiKey `json:"api_keys"`
+}
+
+// GetApiKeys lists the API keys of the organization not revoked, the latest created first
+func (appHandler *AppHandler) GetApiKeys(mux chi.Router, db getApiKeysInterface) {
+	mux.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		organization := ctx.Value("organization").(*models.Organization)
+
+		apiKeys, err := db.GetApiKeys(ctx, storage.GetApiKeysParams{
+			OrganizationId: organization.Id,
+		})
+		if err != nil {
+			http.Error(w, "ERR_APIK_GALL_01", http.StatusBadRequest)
+			return
+		}
+
+		response := GetApiKeysResponse{
+			ApiKeys: apiKeys,
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			http.Error(w, "ERR_APIK_GALL_END", http.StatusBadRequest)
+			return
+		}
+	})
+}
+
+type createApiKeyInterface interface {
+	CreateApiKey(ctx context.Context, arg storage.CreateApiKeyParams) (*models.ApiKey, error)
+	GetActivity(ctx context.Context, arg storage.GetActivityParams) (*models.Activity, error)
+}
+
+type CreateApiKeyRequest struct {
+	Name string `json:"name"`
+	// none: every permission an API key can have
+	Scopes    []models.ApiKeyScope `json:"scopes"`
+	ExpiresAt *time.Time           `json:"expires_at"`
+}
+
+type CreateApiKeyResponse struct {
+	ApiKey models.ApiKey `json:"api_key"`
+	// only given once, to use as a Bearer token
+	Key string `json:"key"`
+}
+
+// CreateApiKey creates an API key for the machine clients of the organization
+func (appHandler *AppHandler) CreateApiKey(mux chi.Router, db createApiKeyInterface) {
+	mux.Post("/", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		organization := ctx.Value("organization").(*models.Organization)
+		user := appHandler.GetAuthenticatedUser(r)
+
+		var input CreateApiKeyRequest
+		httpStatus, err := appHandler.ParsingRequestBody(w, r, &input)
+		if err != nil {
+			http.Error(w, err.Error(), httpStatus)
+			return
+		}
+
+		input.Name = strings.TrimSpace(input.Name)
+		if input.Name == "" {
+			http.Error(w, "ERR_APIK_CRT_NAME", http.StatusBadRequest)
+			return
+		}
+		if input.ExpiresAt != nil && !input.ExpiresAt.After(time.Now()) {
+			http.Error(w, "ERR_APIK_CRT_EXPIRES_AT", http.StatusBadRequest)
+			return
+		}
+
+		for _, scope := range input.Scopes {
+			if !models.IsApiKeyScope(scope.Permission) {
+				http.Error(w, "ERR_APIK_CRT_SCOPE", http.StatusBadRequest)
+				return
+			}
+			if scope.ActivityId == nil {
+				continue
+			}
+
+			activity, err := db.GetActivity(ctx, storage.GetActivityParams{
+				Id:             *scope.ActivityId,
+				OrganizationId: organization.Id,
+			})
+			if err != nil {
+				http.Error(w, "ERR_APIK_CRT_01", http.StatusBadRequest)
+				return
+			}
+			if activity == nil {
+				http.Error(w, "ERR_APIK_CRT_SCOPE_ACTIVITY", http.StatusBadRequest)
+				return
+			}
+		}
+
+		key, keyHash, err := services.GenerateApiKey()
+		if err != nil {
+			log.Println("error when generating the api key: ", err)
+			http.Error(w, "ERR_APIK_CRT_02", http.StatusBadRequest)
+			return
+		}
+
+		apiKey, err := db.CreateApiKey(ctx, storage.CreateApiKeyParams{
+			OrganizationId: organization.Id,
+			Name:           input.Name,
+			Hint:           key[len(key)-4:],
+			KeyHash:        keyHash,
+			Scopes:         input.Scopes,
+			CreatedBy:      dataAuthor(user),
+			ExpiresAt:      input.ExpiresAt,
+		})
+		if err != nil {
+			http.Error(w, "ERR_APIK_CRT_03", http.StatusBadRequest)
+			return
+		}
+
+		response := CreateApiKeyResponse{
+			ApiKey: *apiKey,
+			Key:    key,
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			http.Error(w, "ERR_APIK_CRT_END", http.StatusBadRequest)
+			return
+		}
+	})
+}
+
+type revokeApiKeyInterface interface {
+	RevokeApiKey(ctx context.Context, arg storage.RevokeApiKeyParams) (*models.ApiKey, error)
+}
+
+type RevokeApiKeyResponse struct {
+	ApiKey models.ApiKey `json:"api_key"`
+}
+
+// RevokeApiKey refuses the key from now on
+func (appHandler *AppHandler) RevokeApiKey(mux chi.Router, db revokeApiKeyInterface) {
+	mux.Delete("/{apiKeyId}", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		organization := ctx.Value("organization").(*models.Organization)
+
+		apiKeyId, err := primitive.ObjectIDFromHex(chi.URLParam(r, "apiKeyId"))
+		if err != nil {
+			http.Error(w, "ERR_APIK_RVK_01", http.StatusBadRequest)
+			return
+		}
+
+		apiKey, err := db.RevokeApiKey(ctx, storage.RevokeApiKeyParams{
+			Id:             apiKeyId,
+			OrganizationId: organization.Id,
+		})
+		if err != nil {
+			http.Error(w, "ERR_APIK_RVK_02", http.StatusBadRequest)
+			return
+		}
+		if apiKey == nil {
+			http.Error(w, "ERR_APIK_RVK_03", http.StatusNotFound)
+			return
+		}
+
+		response := RevokeApiKeyResponse{
+			ApiKey: *apiKey,
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			http.Error(w, "ERR_APIK_RVK_END", http.StatusBadRequest)
+			return
+		}
+	})
+}
@@ -0,0 +1,268 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"stockinos.com/api/handlers"
+	"stockinos.com/api/helpertest"
+	"stockinos.com/api/models"
+	"stockinos.com/api/services"
+	"stockinos.com/api/storage"
+	sfaker "syreclabs.com/go/faker"
+)
+
+// mockApiKeyDB keeps the API keys and the activities of one organization, as the storage would
+type mockApiKeyDB struct {
+	organization *models.Organization
+	activities   []*models.Activity
+	apiKeys      []*models.ApiKey
+
+	// the error of GetActivity, when set
+	getActivityErr error
+}
+
+func (mdb *mockApiKeyDB) GetApiKeys(ctx context.Context, arg storage.GetApiKeysParams) ([]*models.ApiKey, error) {
+	apiKeys := make([]*models.ApiKey, 0)
+	for _, apiKey := range mdb.apiKeys {
+		if apiKey.OrganizationId == arg.OrganizationId && apiKey.RevokedAt == nil {
+			apiKeys = append(apiKeys, apiKey)
+		}
+	}
+	return apiKeys, nil
+}
+
+func (mdb *mockApiKeyDB) GetActivity(ctx context.Context, arg storage.GetActivityParams) (*models.Activity, error) {
+	if mdb.getActivityErr != nil {
+		return nil, mdb.getActivityErr
+	}
+	for _, activity := range mdb.activities {
+		if activity.Id == arg.Id && activity.OrganizationId == arg.OrganizationId {
+			return activity, nil
+		}
+	}
+	return nil, nil
+}
+
+func (mdb *mockApiKeyDB) CreateApiKey(ctx context.Context, arg storage.CreateApiKeyParams) (*models.ApiKey, error) {
+	apiKey := &models.ApiKey{
+		Id:             primitive.NewObjectID(),
+		OrganizationId: arg.OrganizationId,
+		Name:           arg.Name,
+		Hint:           arg.Hint,
+		KeyHash:        arg.KeyHash,
+		Scopes:         arg.Scopes,
+		CreatedBy:      arg.CreatedBy,
+		CreatedAt:      time.Now(),
+		ExpiresAt:      arg.ExpiresAt,
+	}
+	mdb.apiKeys = append(mdb.apiKeys, apiKey)
+	return apiKey, nil
+}
+
+func (mdb *mockApiKeyDB) RevokeApiKey(ctx context.Context, arg storage.RevokeApiKeyParams) (*models.ApiKey, error) {
+	for _, apiKey := range mdb.apiKeys {
+		if apiKey.Id == arg.Id && apiKey.OrganizationId == arg.OrganizationId && apiKey.RevokedAt == nil {
+			now := time.Now()
+			apiKey.RevokedAt = &now
+			return apiKey, nil
+		}
+	}
+	return nil, nil
+}
+
+// newApiKeys returns an organization with an activity, and an activity of another organization
+func newApiKeys() *mockApiKeyDB {
+	organization := &models.Organization{
+		Id:   primitive.NewObjectID(),
+		Name: sfaker.Company().Name(),
+	}
+	return &mockApiKeyDB{
+		organization: organization,
+		activities: []*models.Activity{
+			{Id: primitive.NewObjectID(), OrganizationId: organization.Id, Name: "Orders"},
+			{Id: primitive.NewObjectID(), OrganizationId: primitive.NewObjectID(), Name: "Deliveries"},
+		},
+	}
+}
+
+func TestCreateApiKey(t *testing.T) {
+	handler := handlers.NewAppHandler()
+	handler.GetAuthenticatedUser = func(req *http.Request) *models.User {
+		return authenticatedUser
+	}
+	past := time.Now().Add(-time.Hour)
+
+	testCases := map[string]struct {
+		Input          func(db *mockApiKeyDB) handlers.CreateApiKeyRequest
+		GetActivityErr error
+		ResponseError  string
+	}{
+		"without name": {
+			Input: func(db *mockApiKeyDB) handlers.CreateApiKeyRequest {
+				return handlers.CreateApiKeyRequest{Name: "   "}
+			},
+			ResponseError: "ERR_APIK_CRT_NAME",
+		},
+		"already expired": {
+			Input: func(db *mockApiKeyDB) handlers.CreateApiKeyRequest {
+				return handlers.CreateApiKeyRequest{Name: "ERP", ExpiresAt: &past}
+			},
+			ResponseError: "ERR_APIK_CRT_EXPIRES_AT",
+		},
+		"permission kept to the members": {
+			Input: func(db *mockApiKeyDB) handlers.CreateApiKeyRequest {
+				return handlers.CreateApiKeyRequest{Name: "ERP", Scopes: []models.ApiKeyScope{
+					{Permission: models.PermDataRead},
+					{Permission: models.PermTeamManage},
+				}}
+			},
+			ResponseError: "ERR_APIK_CRT_SCOPE",
+		},
+		"activity of another organization": {
+			Input: func(db *mockApiKeyDB) handlers.CreateApiKeyRequest {
+				return handlers.CreateApiKeyRequest{Name: "ERP", Scopes: []models.ApiKeyScope{
+					{Permission: models.PermDataRead, ActivityId: &db.activities[1].Id},
+				}}
+			},
+			ResponseError: "ERR_APIK_CRT_SCOPE_ACTIVITY",
+		},
+		"error when reading the activity": {
+			Input: func(db *mockApiKeyDB) handlers.CreateApiKeyRequest {
+				return handlers.CreateApiKeyRequest{Name: "ERP", Scopes: []models.ApiKeyScope{
+					{Permission: models.PermDataRead, ActivityId: &db.activities[0].Id},
+				}}
+			},
+			GetActivityErr: errors.New("an error happens"),
+			ResponseError:  "ERR_APIK_CRT_01",
+		},
+		"every permission": {
+			Input: func(db *mockApiKeyDB) handlers.CreateApiKeyRequest {
+				return handlers.CreateApiKeyRequest{Name: " ERP "}
+			},
+		},
+		"scoped to an activity": {
+			Input: func(db *mockApiKeyDB) handlers.CreateApiKeyRequest {
+				return handlers.CreateApiKeyRequest{Name: "ERP", Scopes: []models.ApiKeyScope{
+					{Permission: models.PermDataWrite, ActivityId: &db.activities[0].Id},
+					{Permission: models.PermActivityRead},
+				}}
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			db := newApiKeys()
+			db.getActivityErr = tc.GetActivityErr
+			input := tc.Input(db)
+
+			mux := chi.NewMux()
+			handler.CreateApiKey(mux, db)
+			code, _, response := helpertest.MakePostRequest(
+				mux,
+				"/",
+				helpertest.CreateFormHeader(),
+				input,
+				[]helpertest.ContextData{{Name: "organization", Value: db.organization}},
+			)
+
+			if tc.ResponseError != "" {
+				if code != http.StatusBadRequest {
+					t.Fatalf("CreateApiKey(): status - got %d; want %d", code, http.StatusBadRequest)
+				}
+				if response != tc.ResponseError {
+					t.Fatalf("CreateApiKey(): response error - got %s; want %s", response, tc.ResponseError)
+				}
+				if len(db.apiKeys) != 0 {
+					t.Fatalf("CreateApiKey(): %d keys created; want none", len(db.apiKeys))
+				}
+				return
+			}
+
+			if code != http.StatusOK {
+				t.Fatalf("CreateApiKey(): status - got %d; want %d (%s)", code, http.StatusOK, response)
+			}
+			got := handlers.CreateApiKeyResponse{}
+			json.Unmarshal([]byte(response), &got)
+
+			// only the hash of the key is kept
+			if !strings.HasPrefix(got.Key, models.ApiKeyPrefix) || len(db.apiKeys) != 1 || db.apiKeys[0].KeyHash != services.HashApiKey(got.Key) {
+				t.Fatalf("CreateApiKey(): key %s; want a key whose hash is stored", got.Key)
+			}
+			if strings.Contains(response, db.apiKeys[0].KeyHash) {
+				t.Fatalf("CreateApiKey(): the hash of the key is sent")
+			}
+			if got.ApiKey.Name != "ERP" || !strings.HasSuffix(got.Key, got.ApiKey.Hint) || len(got.ApiKey.Hint) != 4 {
+				t.Fatalf("CreateApiKey(): got %+v; want the name ERP and the hint of the key", got.ApiKey)
+			}
+			if len(got.ApiKey.Scopes) != len(input.Scopes) || got.ApiKey.CreatedBy.Id != authenticatedUser.Id {
+				t.Fatalf("CreateApiKey(): got %+v; want the scopes %+v created by the user", got.ApiKey, input.Scopes)
+			}
+		})
+	}
+}
+
+func TestApiKeys(t *testing.T) {
+	handler := handlers.NewAppHandler()
+	db := newApiKeys()
+	for _, name := range []string{"ERP", "Website"} {
+		db.CreateApiKey(context.Background(), storage.CreateApiKeyParams{OrganizationId: db.organization.Id, Name: name})
+	}
+	// a key of another organization
+	db.CreateApiKey(context.Background(), storage.CreateApiKeyParams{OrganizationId: primitive.NewObjectID(), Name: "ERP"})
+	ctxData := []helpertest.ContextData{{Name: "organization", Value: db.organization}}
+
+	mux := chi.NewMux()
+	handler.GetApiKeys(mux, db)
+	handler.RevokeApiKey(mux, db)
+
+	getApiKeys := func() []*models.ApiKey {
+		_, w, response := helpertest.MakeGetRequest(mux, "/", ctxData)
+		if w.StatusCode != http.StatusOK {
+			t.Fatalf("GetApiKeys(): status - got %d; want %d (%s)", w.StatusCode, http.StatusOK, response)
+		}
+		got := handlers.GetApiKeysResponse{}
+		json.Unmarshal([]byte(response), &got)
+		return got.ApiKeys
+	}
+
+	if apiKeys := getApiKeys(); len(apiKeys) != 2 {
+		t.Fatalf("GetApiKeys(): got %d keys; want the 2 of the organization", len(apiKeys))
+	}
+
+	testCases := []struct {
+		Name          string
+		ApiKeyId      string
+		Status        int
+		ResponseError string
+	}{
+		{Name: "invalid id", ApiKeyId: "not-an-id", Status: http.StatusBadRequest, ResponseError: "ERR_APIK_RVK_01"},
+		{Name: "key of another organization", ApiKeyId: db.apiKeys[2].Id.Hex(), Status: http.StatusNotFound, ResponseError: "ERR_APIK_RVK_03"},
+		{Name: "revoked key", ApiKeyId: db.apiKeys[0].Id.Hex(), Status: http.StatusOK},
+		{Name: "key revoked already", ApiKeyId: db.apiKeys[0].Id.Hex(), Status: http.StatusNotFound, ResponseError: "ERR_APIK_RVK_03"},
+	}
+	for _, tc := range testCases {
+		code, _, response := helpertest.MakeDeleteRequest(mux, "/"+tc.ApiKeyId, helpertest.CreateFormHeader(), nil, ctxData)
+		if code != tc.Status {
+			t.Fatalf("RevokeApiKey(%s): status - got %d; want %d (%s)", tc.Name, code, tc.Status, response)
+		}
+		if tc.ResponseError != "" && response != tc.ResponseError {
+			t.Fatalf("RevokeApiKey(%s): response error - got %s; want %s", tc.Name, response, tc.ResponseError)
+		}
+	}
+
+	if db.apiKeys[2].RevokedAt != nil {
+		t.Fatalf("RevokeApiKey(): the key of another organization is revoked")
+	}
+	if apiKeys := getApiKeys(); len(apiKeys) != 1 || apiKeys[0].Id != db.apiKeys[1].Id {
+		t.Fatalf("GetApiKeys(): got %v; want only the key not revoked", apiKeys)
+	}
+}
@@ -3,6 +3,7 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -11,15 +12,48 @@ import (
 
 	"github.com/fatih/structs"
 	"github.com/go-chi/chi/v5"
-	"github.com/google/uuid"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.uber.org/zap"
 	"stockinos.com/api/models"
 	"stockinos.com/api/services"
 	"stockinos.com/api/storage"
-	"stockinos.com/api/utils"
 )
 
+type otpSenderInterface interface {
+	SendOTP(ctx context.Context, message services.OTPMessage, preferred string) (*services.OTPDelivery, error)
+}
+
+// sendOTP delivers the code to the user on the channel asked for, the one of the last code received
+// or the next ones when it fails. It returns how to save the code.
+func sendOTP(ctx context.Context, sender otpSenderInterface, user *models.User, otp *services.IssuedOTP, language, channel string) (*storage.CreateOTPParams, error) {
+	if channel == "" {
+		channel = user.Preferences.OTPChannel
+	}
+
+	delivery, err := sender.SendOTP(ctx, services.OTPMessage{
+		PhoneNumber: user.PhoneNumber,
+		Email:       user.Email,
+		Language:    language,
+		Code:        otp.Code,
+		ExpiresAt:   otp.ExpiresAt,
+	}, channel)
+	if err != nil {
+		return nil, err
+	}
+
+	params := &storage.CreateOTPParams{
+		Channel:     delivery.Channel,
+		MessageId:   delivery.MessageId,
+		PinCode:     otp.Hash,
+		PhoneNumber: user.PhoneNumber,
+		ExpiresAt:   otp.ExpiresAt,
+	}
+	if delivery.Channel == services.OTPChannelWhatsApp {
+		params.WaMessageId = delivery.MessageId
+	}
+	return params, nil
+}
+
 type getOTPInterface interface {
 	CreateUser(ctx context.Context, arg storage.CreateUserParams) (*models.User, error)
 	DoesUserExists(ctx context.Context, arg storage.DoesUserExistsParams) (*models.User, error)
@@ -30,6 +64,8 @@ type getOTPInterface interface {
 type CreateOTPRequest struct {
 	PhoneNumber string `json:"phone_number"` // Phone number of the customer
 	Language    string `json:"language"`     // Language for template
+	// whatsapp, sms or email, the channel of the last code received by default
+	Channel string `json:"channel,omitempty"`
 }
 
 type CreateOTPResponse struct {
@@ -37,7 +73,7 @@ type CreateOTPResponse struct {
 	RedirectToUrl string `json:"redirect_to_url"`
 }
 
-func (appHandler *AppHandler) CreateOTP(mux chi.Router, db getOTPInterface) {
+func (appHandler *AppHandler) CreateOTP(mux chi.Router, db getOTPInterface, sender otpSenderInterface) {
 	mux.Post("/otp", func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 
@@ -48,22 +84,19 @@ func (appHandler *AppHandler) CreateOTP(mux chi.Router, db getOTPInterface) {
 			return
 		}
 
-		// generate the pin code of 4 digits
+		if input.Channel != "" && !services.IsOTPChannel(input.Channel) {
+			http.Error(w, "ERR_COTP_CHANNEL", http.StatusBadRequest)
+			return
+		}
+
+		// generate the pin code, only its hash is saved
 		now := time.Now()
-		pinCode := utils.GenerateOTP(now)
-
-		// send the pin code to a the phone number using Whatsapp API
-		// waMessageId, err := requests.SendWoZOTP(
-		// 	input.PhoneNumber,
-		// 	input.Language,
-		// 	pinCode,
-		// )
-		// if err != nil {
-		// 	log.Println("error when sending the OTP via WhatsApp: ", err)
-		// 	http.Error(w, "ERR_COTP_150", http.StatusBadRequest)
-		// 	return
-		// }
-		waMessageId := "xxx-yyy-zzz"
+		otp, err := services.IssueOTP(input.PhoneNumber, now)
+		if err != nil {
+			log.Println("error when generating the OTP: ", err)
+			http.Error(w, "ERR_COTP_151", http.StatusBadRequest)
+			return
+		}
 
 		var user *models.User
 		// Check if there is an user with this phone number
@@ -77,7 +110,7 @@ func (appHandler *AppHandler) CreateOTP(mux chi.Router, db getOTPInterface) {
 
 		// If there is none, we create the user
 		if user == nil {
-			_, err = db.CreateUser(ctx, storage.CreateUserParams{
+			user, err = db.CreateUser(ctx, storage.CreateUserParams{
 				PhoneNumber: input.PhoneNumber,
 				FirstName:   "",
 				LastName:    "",
@@ -88,14 +121,18 @@ func (appHandler *AppHandler) CreateOTP(mux chi.Router, db getOTPInterface) {
 			}
 		}
 
+		// send the pin code to the user
+		otpParams, err := sendOTP(ctx, sender, user, otp, input.Language, input.Channel)
+		if err != nil {
+			log.Println("error when sending the OTP: ", err)
+			http.Error(w, "ERR_COTP_150", http.StatusBadRequest)
+			return
+		}
+
 		// Then we save the OTP
 		// 1- update all otps to not active
 		// 2- create the new otp as active
-		_, err = db.CreateOTPx(ctx, storage.CreateOTPParams{
-			WaMessageId: waMessageId,
-			PhoneNumber: input.PhoneNumber,
-			PinCode:     pinCode,
-		})
+		_, err = db.CreateOTPx(ctx, *otpParams)
 		if err != nil {
 			log.Println("error when saving the OTP: ", err)
 			http.Error(w, "ERR_COTP_152", http.StatusBadRequest)
@@ -121,6 +158,7 @@ type checkOTPInterface interface {
 	DoesUserExists(ctx context.Context, arg storage.DoesUserExistsParams) (*models.User, error)
 	CheckOTPTx(ctx context.Context, arg storage.CheckOTPParams) (*models.OTP, error)
 	UpdateUserPreferences(ctx context.Context, arg storage.UpdateUserPreferencesParams) (*models.User, error)
+	CreateSession(ctx context.Context, arg storage.CreateSessionParams) (*models.Session, error)
 }
 
 type CheckOTPRequest struct {
@@ -165,8 +203,20 @@ func (appHandler *AppHandler) CheckOTP(mux chi.Router, db checkOTPInterface) {
 		// check that the phone number is correct
 		otp, err := db.CheckOTPTx(r.Context(), storage.CheckOTPParams{
 			PhoneNumber: input.PhoneNumber,
-			UserOTP:     input.PinCode,
+			UserOTP:     services.HashOTP(input.PhoneNumber, input.PinCode),
+			MaxAttempts: services.GetOTPConfig().MaxAttempts,
 		})
+		switch {
+		case errors.Is(err, storage.ErrOTPNotFound):
+			http.Error(w, "ERR_COTP_EXPIRED", http.StatusBadRequest)
+			return
+		case errors.Is(err, storage.ErrOTPInvalid):
+			http.Error(w, "ERR_COTP_INVALID", http.StatusBadRequest)
+			return
+		case errors.Is(err, storage.ErrOTPTooManyAttempts):
+			http.Error(w, "ERR_COTP_TOO_MANY_ATTEMPTS", http.StatusTooManyRequests)
+			return
+		}
 		if err != nil {
 			log.Println("error when checking the otp: ", err)
 			http.Error(w, fmt.Sprintf("ERR_COTP_102_%s", err), http.StatusBadRequest)
@@ -179,37 +229,50 @@ func (appHandler *AppHandler) CheckOTP(mux chi.Router, db checkOTPInterface) {
 		}
 
 		finalCurrentStatus := updateCurrentStatus(user.Preferences.CurrentStatus, "account-checked")
+		changes := map[string]any{
+			"current_status": finalCurrentStatus,
+		}
+		// the next codes are sent where this one was received
+		if otp.Channel != "" {
+			changes["otp_channel"] = otp.Channel
+		}
 		user, err = db.UpdateUserPreferences(ctx, storage.UpdateUserPreferencesParams{
-			Id: user.Id,
-			Changes: map[string]any{
-				"current_status": finalCurrentStatus,
-			},
+			Id:      user.Id,
+			Changes: changes,
 		})
 		if err != nil {
 			http.Error(w, "ERR_COTP_ADD_MBR_PFRS", http.StatusBadRequest)
 			return
 		}
 
-		tokenString, err := services.GenerateJwtToken(structs.Map(&user))
+		// a session per device, to sign it out later
+		refreshToken, refreshTokenHash, err := services.GenerateRefreshToken()
+		if err != nil {
+			log.Println("error when generating the refresh token: ", err)
+			http.Error(w, "ERR_COTP_SESSION", http.StatusBadRequest)
+			return
+		}
+		session, err := db.CreateSession(ctx, storage.CreateSessionParams{
+			UserId:           user.Id,
+			RefreshTokenHash: refreshTokenHash,
+			UserAgent:        r.UserAgent(),
+			IP:               appHandler.ClientIP(r),
+			ExpiresAt:        time.Now().Add(services.GetSessionConfig().RefreshTTL),
+		})
+		if err != nil {
+			log.Println("error when creating the session: ", err)
+			http.Error(w, "ERR_COTP_SESSION", http.StatusBadRequest)
+			return
+		}
+
+		tokenString, err := services.GenerateJwtToken(structs.Map(&user), session.Id.Hex())
 		if err != nil {
 			log.Println("Error CreateUser", zap.Error(err))
 			http.Error(w, "error when creating token", http.StatusBadRequest)
 			return
 		}
 
-		http.SetCookie(
-			w,
-			&http.Cookie{
-				Name:     "jwt",
-				Value:    tokenString,
-				Path:     "/",
-				Expires:  time.Now().Add(3600 * 24 * time.Second),
-				MaxAge:   3600 * 24,
-				HttpOnly: true,
-				Secure:   true,
-				SameSite: http.SameSiteLaxMode,
-			},
-		)
+		services.SetAuthCookies(w, tokenString, refreshToken)
 
 		reqOrigin := r.URL.Query().Get("from")
 		redirectToUrl := nextLocation(reqOrigin, finalCurrentStatus)
@@ -373,22 +436,25 @@ func (appHandler *AppHandler) SetUpOrganization(mux chi.Router, db SetUpOrganiza
 		}
 
 		organization, err := db.CreateOrganization(ctx, storage.CreateOrganizationParams{
-			Name:            input.Name,
-			Bio:             input.Bio,
-			Email:           input.Email,
-			Address:         input.Address,
-			CreatedBy:       user.Id,
-			OwnedBy:         user.Id,
-			InvitationToken: uuid.New().String(),
+			Name:      input.Name,
+			Bio:       input.Bio,
+			Email:     input.Email,
+			Address:   input.Address,
+			CreatedBy: user.Id,
+			OwnedBy:   user.Id,
 		})
 		if err != nil {
 			http.Error(w, "ERR_OBD_CPN_01", http.StatusBadRequest)
 			return
 		}
 
+		now := time.Now()
 		_, err = db.AddMemberIntoOrganization(ctx, storage.AddMemberIntoOrganizationParams{
 			OrganizationId: organization.Id,
 			UserId:         user.Id,
+			InvitedAt:      now,
+			ConfirmedAt:    &now,
+			Role:           models.RoleOwner,
 		})
 		if err != nil {
 			http.Error(w, "ERR_OBD_CPN_12", http.StatusBadRequest)
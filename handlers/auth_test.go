@@ -5,22 +5,21 @@ import (
 	"encoding/json"
 	"net/http"
 	"testing"
-	"time"
 
 	"github.com/go-chi/chi/v5"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"stockinos.com/api/handlers"
 	"stockinos.com/api/helpertest"
 	"stockinos.com/api/models"
+	"stockinos.com/api/ratelimit"
+	"stockinos.com/api/services"
 	"stockinos.com/api/storage"
-	"stockinos.com/api/utils"
 )
 
 type getOTPMock struct{}
 
 var userId = primitive.NewObjectID()
 var otpId = primitive.NewObjectID()
-var pinCode = utils.GenerateOTP(time.Now())
 var users []models.User
 var otps []models.OTP
 
@@ -46,11 +45,18 @@ func (s *getOTPMock) DoesUserExists(ctx context.Context, arg storage.DoesUserExi
 }
 
 func (s *getOTPMock) CreateOTPx(ctx context.Context, arg storage.CreateOTPParams) (*models.OTP, error) {
+	// only one active otp per phone number
+	for i := range otps {
+		if otps[i].PhoneNumber == arg.PhoneNumber {
+			otps[i].Active = false
+		}
+	}
 	otp := models.OTP{
 		Id:          otpId,
-		WaMessageId: "xxx-yyy-zzz",
-		PhoneNumber: "695165033",
-		PinCode:     pinCode,
+		WaMessageId: arg.WaMessageId,
+		PhoneNumber: arg.PhoneNumber,
+		PinCode:     arg.PinCode,
+		Channel:     arg.Channel,
 		Active:      true,
 	}
 	otps = append(otps, otp)
@@ -58,6 +64,10 @@ func (s *getOTPMock) CreateOTPx(ctx context.Context, arg storage.CreateOTPParams
 	return &otps[len(otps)-1], nil
 }
 
+func (s *getOTPMock) UpdateUserPreferences(ctx context.Context, arg storage.UpdateUserPreferencesParams) (*models.User, error) {
+	return nil, nil
+}
+
 func Init() {
 	users = make([]models.User, 0)
 	otps = make([]models.OTP, 0)
@@ -65,8 +75,10 @@ func Init() {
 
 func TestCreateOTP(t *testing.T) {
 	mux := chi.NewMux()
+	handler := handlers.NewAppHandler()
 	svc := &getOTPMock{}
-	handlers.CreateOTP(mux, svc)
+	sender := services.NewOTPDispatcher(services.NewFakeOTPSender(services.OTPChannelWhatsApp))
+	handler.CreateOTP(mux, svc, sender)
 
 	t.Run("return 200", func(t *testing.T) {
 		Init()
@@ -81,10 +93,10 @@ func TestCreateOTP(t *testing.T) {
 			t.Fatalf("CreateOTP() status code = %d; want = %d", code, http.StatusOK)
 		}
 
-		var responsePhoneNumber string
-		err := json.Unmarshal([]byte(responseData), &responsePhoneNumber)
-		if err != nil || responsePhoneNumber != userPhoneNumber {
-			t.Fatalf("CreateOTP() response request = %s; want = %s", responsePhoneNumber, userPhoneNumber)
+		var response handlers.CreateOTPResponse
+		err := json.Unmarshal([]byte(responseData), &response)
+		if err != nil || response.PhoneNumber != userPhoneNumber {
+			t.Fatalf("CreateOTP() response request = %s; want = %s", response.PhoneNumber, userPhoneNumber)
 		}
 		if users[len(users)-1].PhoneNumber != "695165033" {
 			t.Fatalf("CreateOTP() last user phone number = %s; want = %s", otps[len(otps)-1].PhoneNumber, "695165033")
@@ -145,7 +157,9 @@ func TestCreateOTP(t *testing.T) {
 
 }
 
-type checkOTPMock struct{}
+type checkOTPMock struct {
+	sessions []storage.CreateSessionParams
+}
 
 func (s *checkOTPMock) DoesUserExists(ctx context.Context, arg storage.DoesUserExistsParams) (*models.User, error) {
 	var user *models.User = nil
@@ -160,32 +174,51 @@ func (s *checkOTPMock) DoesUserExists(ctx context.Context, arg storage.DoesUserE
 }
 
 func (s *checkOTPMock) CheckOTPTx(ctx context.Context, arg storage.CheckOTPParams) (*models.OTP, error) {
-	var otp *models.OTP = nil
-	var ix int
-	for _i, _otp := range otps {
-		if _otp.PhoneNumber == arg.PhoneNumber && _otp.PinCode == arg.UserOTP && _otp.Active == true {
-			otp = &_otp
-			ix = _i
-
-			break
+	for i := range otps {
+		if otps[i].PhoneNumber != arg.PhoneNumber || !otps[i].Active {
+			continue
 		}
+		if otps[i].PinCode != arg.UserOTP {
+			otps[i].Attempts++
+			if arg.MaxAttempts > 0 && otps[i].Attempts >= arg.MaxAttempts {
+				otps[i].Active = false
+				return nil, storage.ErrOTPTooManyAttempts
+			}
+			return nil, storage.ErrOTPInvalid
+		}
+		otps[i].Active = false
+		return &otps[i], nil
 	}
-	if otp != nil {
-		otps[ix].Active = false
-	}
-	return otp, nil
+	return nil, storage.ErrOTPNotFound
 }
 
 func (s *checkOTPMock) UpdateUserPreferences(ctx context.Context, arg storage.UpdateUserPreferencesParams) (*models.User, error) {
+	for i := range users {
+		if users[i].Id == arg.Id {
+			return &users[i], nil
+		}
+	}
 	return nil, nil
 }
 
+func (s *checkOTPMock) CreateSession(ctx context.Context, arg storage.CreateSessionParams) (*models.Session, error) {
+	s.sessions = append(s.sessions, arg)
+	return &models.Session{
+		Id:               primitive.NewObjectID(),
+		UserId:           arg.UserId,
+		RefreshTokenHash: arg.RefreshTokenHash,
+		ExpiresAt:        arg.ExpiresAt,
+	}, nil
+}
+
 func TestCheckOTP(t *testing.T) {
 	mux := chi.NewMux()
+	handler := handlers.NewAppHandler()
 	checkSVC := &checkOTPMock{}
 	getSVC := &getOTPMock{}
-	handlers.CheckOTP(mux, checkSVC)
-	handlers.CreateOTP(mux, getSVC)
+	fakeSender := services.NewFakeOTPSender(services.OTPChannelWhatsApp)
+	handler.CheckOTP(mux, checkSVC)
+	handler.CreateOTP(mux, getSVC, services.NewOTPDispatcher(fakeSender))
 
 	t.Run("return 200", func(t *testing.T) {
 		Init()
@@ -195,10 +228,10 @@ func TestCheckOTP(t *testing.T) {
 			Language:    "fr",
 		}, []helpertest.ContextData{})
 
-		code, _, _ := helpertest.MakePostRequest(mux, "/otp/check", helpertest.CreateFormHeader(), handlers.CheckOTPRequest{
+		code, _, _ := helpertest.MakePostRequest(mux, "/otp-check", helpertest.CreateFormHeader(), handlers.CheckOTPRequest{
 			PhoneNumber: "695165033",
 			Language:    "fr",
-			PinCode:     otps[len(otps)-1].PinCode,
+			PinCode:     fakeSender.LastCode("695165033"),
 		}, []helpertest.ContextData{})
 		if code != http.StatusOK {
 			t.Fatalf("CheckOTP() status code = %d; want = %d", code, http.StatusOK)
@@ -213,10 +246,10 @@ func TestCheckOTP(t *testing.T) {
 			Language:    "fr",
 		}, []helpertest.ContextData{})
 
-		code, _, _ := helpertest.MakePostRequest(mux, "/otp/check", helpertest.CreateFormHeader(), handlers.CheckOTPRequest{
+		code, _, _ := helpertest.MakePostRequest(mux, "/otp-check", helpertest.CreateFormHeader(), handlers.CheckOTPRequest{
 			PhoneNumber: "678908989",
 			Language:    "fr",
-			PinCode:     otps[len(otps)-1].PinCode,
+			PinCode:     fakeSender.LastCode("695165033"),
 		}, []helpertest.ContextData{})
 		if code != http.StatusBadRequest {
 			t.Fatalf("CheckOTP() status code = %d; want = %d", code, http.StatusBadRequest)
@@ -231,13 +264,13 @@ func TestCheckOTP(t *testing.T) {
 			Language:    "fr",
 		}, []helpertest.ContextData{})
 
-		code, _, _ := helpertest.MakePostRequest(mux, "/otp/check", helpertest.CreateFormHeader(), handlers.CheckOTPRequest{
+		code, _, response := helpertest.MakePostRequest(mux, "/otp-check", helpertest.CreateFormHeader(), handlers.CheckOTPRequest{
 			PhoneNumber: "695165033",
 			Language:    "fr",
 			PinCode:     "0000",
 		}, []helpertest.ContextData{})
-		if code != http.StatusBadRequest {
-			t.Fatalf("CheckOTP() status code = %d; want = %d", code, http.StatusBadRequest)
+		if code != http.StatusBadRequest || response != "ERR_COTP_INVALID" {
+			t.Fatalf("CheckOTP() = %d %s; want = %d ERR_COTP_INVALID", code, response, http.StatusBadRequest)
 		}
 	})
 
@@ -249,10 +282,10 @@ func TestCheckOTP(t *testing.T) {
 			Language:    "fr",
 		}, []helpertest.ContextData{})
 
-		_, _, _ = helpertest.MakePostRequest(mux, "/otp/check", helpertest.CreateFormHeader(), handlers.CheckOTPRequest{
+		_, _, _ = helpertest.MakePostRequest(mux, "/otp-check", helpertest.CreateFormHeader(), handlers.CheckOTPRequest{
 			PhoneNumber: "695165033",
 			Language:    "fr",
-			PinCode:     otps[len(otps)-1].PinCode,
+			PinCode:     fakeSender.LastCode("695165033"),
 		}, []helpertest.ContextData{})
 
 		var activeOTPExists bool = false
@@ -275,11 +308,90 @@ func TestCheckOTP(t *testing.T) {
 			Language:    "fr",
 		}, []helpertest.ContextData{})
 
-		_, _, _ = helpertest.MakePostRequest(mux, "/otp/check", helpertest.CreateFormHeader(), handlers.CheckOTPRequest{
+		_, _, _ = helpertest.MakePostRequest(mux, "/otp-check", helpertest.CreateFormHeader(), handlers.CheckOTPRequest{
 			PhoneNumber: "695165033",
 			Language:    "fr",
-			PinCode:     otps[len(otps)-1].PinCode,
+			PinCode:     fakeSender.LastCode("695165033"),
 		}, []helpertest.ContextData{})
 		// headers.
 	})
+
+	t.Run("return 400 if the otp was already used", func(t *testing.T) {
+		Init()
+
+		_, _, _ = helpertest.MakePostRequest(mux, "/otp", helpertest.CreateFormHeader(), handlers.CreateOTPRequest{
+			PhoneNumber: "695165033",
+			Language:    "fr",
+		}, []helpertest.ContextData{})
+
+		input := handlers.CheckOTPRequest{
+			PhoneNumber: "695165033",
+			Language:    "fr",
+			PinCode:     fakeSender.LastCode("695165033"),
+		}
+		_, _, _ = helpertest.MakePostRequest(mux, "/otp-check", helpertest.CreateFormHeader(), input, []helpertest.ContextData{})
+		code, _, response := helpertest.MakePostRequest(mux, "/otp-check", helpertest.CreateFormHeader(), input, []helpertest.ContextData{})
+		if code != http.StatusBadRequest || response != "ERR_COTP_EXPIRED" {
+			t.Fatalf("CheckOTP() = %d %s; want = %d ERR_COTP_EXPIRED", code, response, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("return 429 after too many wrong otps", func(t *testing.T) {
+		Init()
+
+		_, _, _ = helpertest.MakePostRequest(mux, "/otp", helpertest.CreateFormHeader(), handlers.CreateOTPRequest{
+			PhoneNumber: "695165033",
+			Language:    "fr",
+		}, []helpertest.ContextData{})
+
+		wrong := handlers.CheckOTPRequest{
+			PhoneNumber: "695165033",
+			Language:    "fr",
+			PinCode:     "0000",
+		}
+		maxAttempts := services.GetOTPConfig().MaxAttempts
+		for i := 1; i < maxAttempts; i++ {
+			_, _, _ = helpertest.MakePostRequest(mux, "/otp-check", helpertest.CreateFormHeader(), wrong, []helpertest.ContextData{})
+		}
+		code, _, response := helpertest.MakePostRequest(mux, "/otp-check", helpertest.CreateFormHeader(), wrong, []helpertest.ContextData{})
+		if code != http.StatusTooManyRequests || response != "ERR_COTP_TOO_MANY_ATTEMPTS" {
+			t.Fatalf("CheckOTP() = %d %s; want = %d ERR_COTP_TOO_MANY_ATTEMPTS", code, response, http.StatusTooManyRequests)
+		}
+
+		// the right code can not be used anymore
+		code, _, response = helpertest.MakePostRequest(mux, "/otp-check", helpertest.CreateFormHeader(), handlers.CheckOTPRequest{
+			PhoneNumber: "695165033",
+			Language:    "fr",
+			PinCode:     fakeSender.LastCode("695165033"),
+		}, []helpertest.ContextData{})
+		if code != http.StatusBadRequest || response != "ERR_COTP_EXPIRED" {
+			t.Fatalf("CheckOTP() = %d %s; want = %d ERR_COTP_EXPIRED", code, response, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("the session is signed in from the ip given by the trusted proxy", func(t *testing.T) {
+		Init()
+		handler.ClientIP = ratelimit.ClientIP(1)
+		defer func() { handler.ClientIP = ratelimit.ClientIP(0) }()
+
+		_, _, _ = helpertest.MakePostRequest(mux, "/otp", helpertest.CreateFormHeader(), handlers.CreateOTPRequest{
+			PhoneNumber: "695165033",
+			Language:    "fr",
+		}, []helpertest.ContextData{})
+
+		// the client sent a fake address before the one appended by the proxy
+		header := helpertest.CreateFormHeader()
+		header.Set("X-Forwarded-For", "203.0.113.7, 198.51.100.1")
+		code, _, _ := helpertest.MakePostRequest(mux, "/otp-check", header, handlers.CheckOTPRequest{
+			PhoneNumber: "695165033",
+			Language:    "fr",
+			PinCode:     fakeSender.LastCode("695165033"),
+		}, []helpertest.ContextData{})
+		if code != http.StatusOK {
+			t.Fatalf("CheckOTP() status code = %d; want = %d", code, http.StatusOK)
+		}
+		if session := checkSVC.sessions[len(checkSVC.sessions)-1]; session.IP != "198.51.100.1" {
+			t.Fatalf("CheckOTP() session ip = %s; want = 198.51.100.1", session.IP)
+		}
+	})
 }
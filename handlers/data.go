@@ -3,6 +3,7 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -11,13 +12,16 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"stockinos.com/api/filters"
 	"stockinos.com/api/models"
+	"stockinos.com/api/services"
 	"stockinos.com/api/storage"
 )
 
@@ -61,7 +65,9 @@ func (handler *AppHandler) DataMiddleware(mux chi.Router, db dataMiddlewareInter
 }
 
 type createDataInterface interface {
-	CreateData(ctx context.Context, arg storage.CreateDataParams) (*models.Data, error)
+	GetLastValueOfAuthor(ctx context.Context, arg storage.GetLastValueOfAuthorParams) (any, error)
+	GetActivity(ctx context.Context, arg storage.GetActivityParams) (*models.Activity, error)
+	CreateDataTx(ctx context.Context, arg storage.CreateDataParams) (*models.Data, error)
 	GetDataFilterByValues(ctx context.Context, arg storage.GetDataFilterByValuesParams) (*models.Data, error)
 }
 
@@ -87,64 +93,72 @@ func (handler *AppHandler) CreateData(mux chi.Router, db createDataInterface) {
 
 		activity := ctx.Value("activity").(*models.Activity)
 
-		// PRIMARY KEY CHECKING
-		var primaryKeyField models.ActivityField
-		for i := range activity.Fields {
-			field := activity.Fields[i]
-			if field.PrimaryKey {
-				primaryKeyField = field
-			}
+		dataFormulas, err := loadDataFormulas(ctx, activity, db)
+		if err != nil {
+			http.Error(w, "ERR_DATA_CRT_FORMULA", http.StatusBadRequest)
+			return
 		}
 
-		primaryKeyValue := input.Values[primaryKeyField.Id.Hex()]
-		if primaryKeyValue == nil {
-			http.Error(w, "ERR_DATA_CRT_PRKEY_NOT_FOUND", http.StatusBadRequest)
+		inputValues, fieldErrors, err := withDefaultValues(ctx, activity, authUser, dataFormulas.withoutComputed(input.Values), dataFormulas, db)
+		if err != nil {
+			http.Error(w, "ERR_DATA_CRT_DEFAULT_VALUES", http.StatusBadRequest)
+			return
+		}
+		if len(fieldErrors) > 0 {
+			writeFieldErrors(w, "ERR_DATA_CRT_INVALID_VALUES", fieldErrors)
 			return
 		}
 
-		dataWithPrKey, err := db.GetDataFilterByValues(ctx, storage.GetDataFilterByValuesParams{
-			Values: map[string]any{
-				primaryKeyField.Id.Hex(): primaryKeyValue,
-			},
-			ActivityId: activity.Id,
-		})
+		values, fieldErrors, err := validateDataValues(ctx, activity, inputValues, db)
 		if err != nil {
-			http.Error(w, "", http.StatusBadRequest)
+			http.Error(w, "ERR_DATA_CRT_VALIDATION", http.StatusBadRequest)
 			return
 		}
-		if dataWithPrKey != nil {
-			http.Error(w, "ERR_DATA_CRT_PRKEY_ALREADY_USED", http.StatusBadRequest)
+		if len(fieldErrors) > 0 {
+			writeFieldErrors(w, "ERR_DATA_CRT_INVALID_VALUES", fieldErrors)
 			return
 		}
 
-		// We should ensure that all the data are the type of the one defined in activity
-		// values := make(map[string]any)
-		// for _, field := range activity.Fields {
-		// 	value := input.Values[field.Code]
-		// 	castValue, ok := field.IsValid(value)
-		// 	if ok {
-		// 		values[field.Code] = castValue
-		// 	}
-		// }
-		// for code, value := range input.Values {
-		// 	var field models.ActivityFields
+		_, fieldErrors, err = completeDataValues(ctx, activity, values, dataFormulas)
+		if err != nil {
+			http.Error(w, "ERR_DATA_CRT_FORMULA", http.StatusBadRequest)
+			return
+		}
+		if len(fieldErrors) > 0 {
+			writeFieldErrors(w, "ERR_DATA_CRT_INVALID_VALUES", fieldErrors)
+			return
+		}
 
-		// 	for _, f := range activity.Fields {
+		if fieldErrors := activity.CheckRules(values, time.Now()); len(fieldErrors) > 0 {
+			writeFieldErrors(w, "ERR_DATA_CRT_INVALID_VALUES", fieldErrors)
+			return
+		}
 
-		// 	}
-		// }
+		fieldErrors, err = checkDataUniqueness(ctx, activity, values, primitive.NilObjectID, db)
+		if err != nil {
+			http.Error(w, "ERR_DATA_CRT_UNIQUENESS", http.StatusBadRequest)
+			return
+		}
+		if len(fieldErrors) > 0 {
+			writeFieldErrors(w, uniquenessErrorCode("ERR_DATA_CRT", fieldErrors), fieldErrors)
+			return
+		}
 
-		data, err := db.CreateData(ctx, storage.CreateDataParams{
-			Values: input.Values,
+		// the data is created along with the first entry of its history
+		data, err := db.CreateDataTx(ctx, storage.CreateDataParams{
+			Values:        values,
+			UniqueKeys:    activity.UniqueKeys(values),
+			SchemaVersion: activity.Version,
 
 			ActivityId: activity.Id,
-			CreatedBy: models.DataAuthor{
-				Id:   authUser.Id,
-				Name: fmt.Sprintf("%s %s", authUser.LastName, authUser.FirstName),
-			},
+			CreatedBy:  dataAuthor(authUser),
 		})
+		if errors.Is(err, storage.ErrDataNotUnique) {
+			http.Error(w, "ERR_DATA_CRT_NOT_UNIQUE", http.StatusBadRequest)
+			return
+		}
 		if err != nil {
-			http.Error(w, "ERR_DATA_CRT_01", http.StatusBadRequest)
+			http.Error(w, "ERR_DATA_CRT_STORAGE", http.StatusInternalServerError)
 			return
 		}
 
@@ -161,8 +175,218 @@ func (handler *AppHandler) CreateData(mux chi.Router, db createDataInterface) {
 	})
 }
 
+type defaultValuesInterface interface {
+	GetLastValueOfAuthor(ctx context.Context, arg storage.GetLastValueOfAuthorParams) (any, error)
+}
+
+// withDefaultValues completes the values with the default values of the fields without a value,
+// the computed fields excepted
+func withDefaultValues(ctx context.Context, activity *models.Activity, user *models.User, values map[string]any, dataFormulas *dataFormulas, db defaultValuesInterface) (map[string]any, []models.FieldError, error) {
+	author := dataAuthor(user)
+	defaultContext := models.DefaultValueContext{
+		Now:             time.Now(),
+		UserName:        author.Name,
+		UserPhoneNumber: user.PhoneNumber,
+		LastValues:      make(map[string]any),
+	}
+
+	completedValues := make(map[string]any, len(values))
+	for key, value := range values {
+		completedValues[key] = value
+	}
+	fieldErrors := make([]models.FieldError, 0)
+	for _, field := range activity.Fields {
+		fieldId := field.Id.Hex()
+		if completedValues[fieldId] != nil || !field.HasDefaultValue() || dataFormulas.computes(fieldId) {
+			continue
+		}
+
+		if token, _ := field.DefaultValueToken(); token == models.DefaultLastValue {
+			lastValue, err := db.GetLastValueOfAuthor(ctx, storage.GetLastValueOfAuthorParams{
+				ActivityId: activity.Id,
+				FieldId:    fieldId,
+				AuthorId:   author.Id,
+			})
+			if err != nil {
+				return nil, nil, err
+			}
+			defaultContext.LastValues[fieldId] = lastValue
+		}
+
+		value, fieldErr := field.DefaultValue(defaultContext)
+		if fieldErr != nil {
+			fieldErrors = append(fieldErrors, *fieldErr)
+			continue
+		}
+		if value != nil {
+			completedValues[fieldId] = value
+		}
+	}
+
+	return completedValues, fieldErrors, nil
+}
+
+// completeDataValues computes the automatic fields and drops the values of the fields hidden by their conditions,
+// before and after the formulas as the conditions may depend on the results. It returns the values changed,
+// nil for the values dropped. The results are cast to the type of their field like the values sent, a result
+// which can not be is refused with the error of its field.
+func completeDataValues(ctx context.Context, activity *models.Activity, values map[string]any, dataFormulas *dataFormulas) (map[string]any, []models.FieldError, error) {
+	changed := make(map[string]any)
+	for _, fieldId := range activity.StripHiddenValues(values) {
+		changed[fieldId] = nil
+	}
+
+	computed, err := dataFormulas.compute(ctx, values)
+	if err != nil {
+		return nil, nil, err
+	}
+	fieldErrors := make([]models.FieldError, 0)
+	for _, field := range activity.Fields {
+		fieldId := field.Id.Hex()
+		value, ok := computed[fieldId]
+		if !ok {
+			continue
+		}
+		castValue, fieldError := field.Cast(value)
+		if fieldError != nil {
+			fieldErrors = append(fieldErrors, models.FieldError{
+				FieldId: fieldId,
+				Code:    "ERR_FIELD_INVALID_FORMULA",
+				Message: "the result of the formula does not fit the type of the field",
+			})
+			continue
+		}
+		if castValue == nil {
+			delete(values, fieldId)
+		} else {
+			values[fieldId] = castValue
+		}
+		changed[fieldId] = castValue
+	}
+	if len(fieldErrors) > 0 {
+		return nil, fieldErrors, nil
+	}
+
+	for _, fieldId := range activity.StripHiddenValues(values) {
+		changed[fieldId] = nil
+	}
+	return changed, nil, nil
+}
+
+type dataValuesValidatorInterface interface {
+	GetDataFilterByValues(ctx context.Context, arg storage.GetDataFilterByValuesParams) (*models.Data, error)
+}
+
+type DataValidationErrorResponse struct {
+	Error  string              `json:"error"`
+	Fields []models.FieldError `json:"fields"`
+}
+
+// validateDataValues casts the values to the type of their field and checks that
+// every value of a key field references an existing data of the referenced activity
+func validateDataValues(ctx context.Context, activity *models.Activity, values map[string]any, db dataValuesValidatorInterface) (map[string]any, []models.FieldError, error) {
+	castValues, fieldErrors := activity.ValidateValues(values)
+
+	for _, field := range activity.Fields {
+		value := castValues[field.Id.Hex()]
+		details := field.Details.ActivityFieldKey
+		if field.Type != models.FieldTypeKey || value == nil || details == nil || details.ActivityId.IsZero() {
+			continue
+		}
+
+		referencedData, err := db.GetDataFilterByValues(ctx, storage.GetDataFilterByValuesParams{
+			Values: map[string]any{
+				details.FieldId.Hex(): value,
+			},
+			ActivityId: details.ActivityId,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		if referencedData == nil {
+			fieldErrors = append(fieldErrors, models.FieldError{
+				FieldId: field.Id.Hex(),
+				Code:    "ERR_FIELD_KEY_NOT_FOUND",
+				Message: fmt.Sprintf("no data found with the key %v", value),
+			})
+		}
+	}
+
+	return castValues, fieldErrors, nil
+}
+
+type dataUniquenessInterface interface {
+	GetDataFilterByValues(ctx context.Context, arg storage.GetDataFilterByValuesParams) (*models.Data, error)
+}
+
+// checkDataUniqueness checks the primary key and the unique constraints of the activity
+// before writing the values. The unique index on the keys of the data still rejects
+// the concurrent writes of the same values.
+func checkDataUniqueness(ctx context.Context, activity *models.Activity, values map[string]any, excludeId primitive.ObjectID, db dataUniquenessInterface) ([]models.FieldError, error) {
+	fieldErrors := activity.ValidatePrimaryKey(values)
+	if len(fieldErrors) > 0 {
+		return fieldErrors, nil
+	}
+
+	for _, constraint := range activity.UniqueConstraints() {
+		if _, ok := activity.UniqueKey(constraint, values); !ok {
+			continue
+		}
+
+		constraintValues := make(map[string]any, len(constraint.FieldIds))
+		for _, fieldId := range constraint.FieldIds {
+			constraintValues[fieldId] = values[fieldId]
+		}
+
+		existingData, err := db.GetDataFilterByValues(ctx, storage.GetDataFilterByValuesParams{
+			Values:     constraintValues,
+			ActivityId: activity.Id,
+			ExcludeId:  excludeId,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if existingData != nil {
+			fieldErrors = append(fieldErrors, constraint.UniqueErrors()...)
+		}
+	}
+
+	return fieldErrors, nil
+}
+
+// uniquenessErrorCode keeps the codes used when an activity could only have a single primary key
+func uniquenessErrorCode(prefix string, fieldErrors []models.FieldError) string {
+	for _, fieldError := range fieldErrors {
+		switch fieldError.Code {
+		case "ERR_FIELD_PRKEY_REQUIRED":
+			return prefix + "_PRKEY_NOT_FOUND"
+		case "ERR_FIELD_PRKEY_ALREADY_USED":
+			return prefix + "_PRKEY_ALREADY_USED"
+		}
+	}
+	return prefix + "_NOT_UNIQUE"
+}
+
+func writeFieldErrors(w http.ResponseWriter, code string, fieldErrors []models.FieldError) {
+	writeFieldErrorsStatus(w, http.StatusBadRequest, code, fieldErrors)
+}
+
+func writeFieldErrorsStatus(w http.ResponseWriter, status int, code string, fieldErrors []models.FieldError) {
+	response := DataValidationErrorResponse{
+		Error:  code,
+		Fields: fieldErrors,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Println("error when encoding the validation errors: ", err)
+	}
+}
+
 type updateDataInterface interface {
-	UpdateData(ctx context.Context, arg storage.UpdateDataParams) (*models.Data, error)
+	GetActivity(ctx context.Context, arg storage.GetActivityParams) (*models.Activity, error)
+	UpdateDataTx(ctx context.Context, arg storage.UpdateDataTxParams) (*models.Data, error)
 	GetDataFilterByValues(ctx context.Context, arg storage.GetDataFilterByValuesParams) (*models.Data, error)
 }
 
@@ -177,7 +401,7 @@ type UpdateDataResponse struct {
 func (appHandler *AppHandler) UpdateData(mux chi.Router, db updateDataInterface) {
 	mux.Put("/", func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
-		// authUser := appHandler.GetAuthenticatedUser(r)
+		authUser := appHandler.GetAuthenticatedUser(r)
 
 		var input UpdateDataRequest
 		httpStatus, err := appHandler.ParsingRequestBody(w, r, &input)
@@ -189,49 +413,86 @@ func (appHandler *AppHandler) UpdateData(mux chi.Router, db updateDataInterface)
 		activity := ctx.Value("activity").(*models.Activity)
 		data := ctx.Value("data").(*models.Data)
 
-		// PRIMARY KEY CHECKING
-		var primaryKeyField models.ActivityField
-		for i := range activity.Fields {
-			field := activity.Fields[i]
-			if field.PrimaryKey {
-				primaryKeyField = field
+		dataFormulas, err := loadDataFormulas(ctx, activity, db)
+		if err != nil {
+			http.Error(w, "ERR_DATA_UPDT_FORMULA", http.StatusBadRequest)
+			return
+		}
+
+		values, fieldErrors, err := validateDataValues(ctx, activity, dataFormulas.withoutComputed(input.Values), db)
+		if err != nil {
+			http.Error(w, "ERR_DATA_UPDT_VALIDATION", http.StatusBadRequest)
+			return
+		}
+		if len(fieldErrors) > 0 {
+			writeFieldErrors(w, "ERR_DATA_UPDT_INVALID_VALUES", fieldErrors)
+			return
+		}
+
+		// All the values are replaced: the fields not sent no longer have a value
+		for key, value := range values {
+			if value == nil {
+				delete(values, key)
 			}
 		}
 
-		primaryKeyValue := input.Values[primaryKeyField.Id.Hex()]
-		if primaryKeyValue == nil {
-			http.Error(w, "ERR_DATA_UPDT_PRKEY_NOT_FOUND", http.StatusBadRequest)
+		_, fieldErrors, err = completeDataValues(ctx, activity, values, dataFormulas)
+		if err != nil {
+			http.Error(w, "ERR_DATA_UPDT_FORMULA", http.StatusBadRequest)
+			return
+		}
+		if len(fieldErrors) > 0 {
+			writeFieldErrors(w, "ERR_DATA_UPDT_INVALID_VALUES", fieldErrors)
+			return
+		}
+
+		if fieldErrors := activity.CheckRules(values, time.Now()); len(fieldErrors) > 0 {
+			writeFieldErrors(w, "ERR_DATA_UPDT_INVALID_VALUES", fieldErrors)
 			return
 		}
 
-		dataWithPrKey, err := db.GetDataFilterByValues(ctx, storage.GetDataFilterByValuesParams{
-			Values: map[string]any{
-				primaryKeyField.Id.Hex(): primaryKeyValue,
-			},
-			ActivityId: activity.Id,
-		})
+		fieldErrors, err = checkDataUniqueness(ctx, activity, values, data.Id, db)
 		if err != nil {
-			http.Error(w, "", http.StatusBadRequest)
+			http.Error(w, "ERR_DATA_UPDT_UNIQUENESS", http.StatusBadRequest)
 			return
 		}
-		if dataWithPrKey != nil {
-			http.Error(w, "ERR_DATA_UPDT_PRKEY_ALREADY_USED", http.StatusBadRequest)
+		if len(fieldErrors) > 0 {
+			writeFieldErrors(w, uniquenessErrorCode("ERR_DATA_UPDT", fieldErrors), fieldErrors)
 			return
 		}
 
-		data, err = db.UpdateData(ctx, storage.UpdateDataParams{
-			Id:         data.Id,
-			ActivityId: activity.Id,
+		updatedData, err := db.UpdateDataTx(ctx, storage.UpdateDataTxParams{
+			UpdateDataParams: storage.UpdateDataParams{
+				Id:         data.Id,
+				ActivityId: activity.Id,
 
-			Values: input.Values,
+				Values:        values,
+				UniqueKeys:    activity.UniqueKeys(values),
+				SchemaVersion: activity.Version,
+				Replace:       true,
+			},
+			History: storage.AddDataHistoryParams{
+				Action: models.DataHistoryUpdated,
+				Before: data.Values,
+				Author: dataAuthor(authUser),
+			},
 		})
+		if errors.Is(err, storage.ErrDataNotUnique) {
+			http.Error(w, "ERR_DATA_UPDT_NOT_UNIQUE", http.StatusBadRequest)
+			return
+		}
 		if err != nil {
-			http.Error(w, "ERR_DATA_UPDT_FAILED", http.StatusBadRequest)
+			http.Error(w, "ERR_DATA_UPDT_STORAGE", http.StatusInternalServerError)
+			return
+		}
+		// the data was deleted meanwhile
+		if updatedData == nil {
+			http.Error(w, "ERR_DATA_UPDT_NOT_FOUND", http.StatusNotFound)
 			return
 		}
 
 		response := UpdateDataResponse{
-			Data: *data,
+			Data: *updatedData,
 		}
 
 		w.Header().Set("Content-Type", "application/json")
@@ -243,34 +504,376 @@ func (appHandler *AppHandler) UpdateData(mux chi.Router, db updateDataInterface)
 	})
 }
 
+type patchDataInterface interface {
+	GetActivity(ctx context.Context, arg storage.GetActivityParams) (*models.Activity, error)
+	UpdateDataTx(ctx context.Context, arg storage.UpdateDataTxParams) (*models.Data, error)
+	UpdateSetInDataTx(ctx context.Context, arg storage.UpdateSetInDataTxParams) (*models.Data, error)
+	UpdateAddToDataTx(ctx context.Context, arg storage.UpdateAddToDataTxParams) (*models.Data, error)
+	UpdateRemoveFromDataTx(ctx context.Context, arg storage.UpdateRemoveFromDataTxParams) (*models.Data, error)
+	GetDataFilterByValues(ctx context.Context, arg storage.GetDataFilterByValuesParams) (*models.Data, error)
+}
+
+// PatchDataRequest is either a partial update of the values, the fields not sent being kept
+// and a null value removing the value of a field, or an operation on one field:
+//   - set: replace the value of the field
+//   - add: insert value in the list of the field at position, at the end by default
+//   - remove: remove the item of the list of the field at position, 404 when the list has none there
+type PatchDataRequest struct {
+	Values map[string]any `json:"values"`
+
+	Operation string  `json:"op"`
+	Field     string  `json:"field"`
+	Value     any     `json:"value"`
+	Position  *uint64 `json:"position"`
+}
+
+func (appHandler *AppHandler) PatchData(mux chi.Router, db patchDataInterface) {
+	mux.Patch("/", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authUser := appHandler.GetAuthenticatedUser(r)
+
+		var input PatchDataRequest
+		httpStatus, err := appHandler.ParsingRequestBody(w, r, &input)
+		if err != nil {
+			http.Error(w, err.Error(), httpStatus)
+			return
+		}
+
+		activity := ctx.Value("activity").(*models.Activity)
+		data := ctx.Value("data").(*models.Data)
+
+		dataFormulas, err := loadDataFormulas(ctx, activity, db)
+		if err != nil {
+			http.Error(w, "ERR_DATA_PTCH_FORMULA", http.StatusBadRequest)
+			return
+		}
+
+		operation := strings.ToLower(input.Operation)
+		changes := dataFormulas.withoutComputed(input.Values)
+		var field models.ActivityField
+		var items []any
+		var position int
+
+		switch operation {
+		case "":
+			if len(changes) == 0 {
+				http.Error(w, "ERR_DATA_PTCH_NO_VALUES", http.StatusBadRequest)
+				return
+			}
+		case "set", "add", "remove":
+			found := false
+			for i := range activity.Fields {
+				if activity.Fields[i].Id.Hex() == input.Field {
+					field = activity.Fields[i]
+					found = true
+				}
+			}
+			if !found {
+				http.Error(w, "ERR_DATA_PTCH_FIELD", http.StatusBadRequest)
+				return
+			}
+			if dataFormulas.computes(input.Field) {
+				http.Error(w, "ERR_DATA_PTCH_AUTOMATIC", http.StatusBadRequest)
+				return
+			}
+			if operation == "set" {
+				changes = map[string]any{input.Field: input.Value}
+				break
+			}
+
+			if !field.IsList() {
+				http.Error(w, "ERR_DATA_PTCH_NOT_LIST", http.StatusBadRequest)
+				return
+			}
+			items = append([]any{}, models.ListItems(data.Values[input.Field])...)
+
+			position = len(items)
+			if input.Position != nil {
+				if *input.Position > uint64(len(items)) {
+					http.Error(w, "ERR_DATA_PTCH_POSITION", http.StatusBadRequest)
+					return
+				}
+				position = int(*input.Position)
+			}
+			if operation == "add" {
+				if input.Value == nil {
+					http.Error(w, "ERR_DATA_PTCH_VALUE", http.StatusBadRequest)
+					return
+				}
+				items = append(items[:position], append([]any{input.Value}, items[position:]...)...)
+			} else {
+				if input.Position == nil {
+					http.Error(w, "ERR_DATA_PTCH_POSITION", http.StatusBadRequest)
+					return
+				}
+				if position >= len(items) {
+					http.Error(w, "ERR_DATA_PTCH_POSITION_NOT_FOUND", http.StatusNotFound)
+					return
+				}
+				items = append(items[:position], items[position+1:]...)
+			}
+			// The whole list is validated, to check its length as well
+			changes = map[string]any{input.Field: items}
+		default:
+			http.Error(w, "ERR_DATA_PTCH_OP", http.StatusBadRequest)
+			return
+		}
+
+		values, fieldErrors, err := validateDataValues(ctx, activity, changes, db)
+		if err != nil {
+			http.Error(w, "ERR_DATA_PTCH_VALIDATION", http.StatusBadRequest)
+			return
+		}
+		if len(fieldErrors) > 0 {
+			writeFieldErrors(w, "ERR_DATA_PTCH_INVALID_VALUES", fieldErrors)
+			return
+		}
+
+		// The constraints are checked on all the values of the data, once updated
+		updatedValues := make(map[string]any, len(data.Values)+len(values))
+		for key, value := range data.Values {
+			updatedValues[key] = value
+		}
+		for key, value := range values {
+			if value == nil {
+				delete(updatedValues, key)
+			} else {
+				updatedValues[key] = value
+			}
+		}
+
+		computed, fieldErrors, err := completeDataValues(ctx, activity, updatedValues, dataFormulas)
+		if err != nil {
+			http.Error(w, "ERR_DATA_PTCH_FORMULA", http.StatusBadRequest)
+			return
+		}
+		if len(fieldErrors) > 0 {
+			writeFieldErrors(w, "ERR_DATA_PTCH_INVALID_VALUES", fieldErrors)
+			return
+		}
+		if _, ok := computed[input.Field]; ok && operation != "" {
+			http.Error(w, "ERR_DATA_PTCH_HIDDEN", http.StatusBadRequest)
+			return
+		}
+		for key, value := range computed {
+			values[key] = value
+		}
+
+		if fieldErrors := activity.CheckRules(updatedValues, time.Now()); len(fieldErrors) > 0 {
+			writeFieldErrors(w, "ERR_DATA_PTCH_INVALID_VALUES", fieldErrors)
+			return
+		}
+
+		fieldErrors, err = checkDataUniqueness(ctx, activity, updatedValues, data.Id, db)
+		if err != nil {
+			http.Error(w, "ERR_DATA_PTCH_UNIQUENESS", http.StatusBadRequest)
+			return
+		}
+		if len(fieldErrors) > 0 {
+			writeFieldErrors(w, uniquenessErrorCode("ERR_DATA_PTCH", fieldErrors), fieldErrors)
+			return
+		}
+		uniqueKeys := activity.UniqueKeys(updatedValues)
+
+		history := storage.AddDataHistoryParams{
+			Action: models.DataHistoryUpdated,
+			Before: data.Values,
+			Author: dataAuthor(authUser),
+		}
+		var updatedData *models.Data
+		switch operation {
+		case "":
+			updatedData, err = db.UpdateDataTx(ctx, storage.UpdateDataTxParams{
+				UpdateDataParams: storage.UpdateDataParams{
+					Id:         data.Id,
+					ActivityId: activity.Id,
+
+					Values:        values,
+					UniqueKeys:    uniqueKeys,
+					SchemaVersion: activity.Version,
+				},
+				History: history,
+			})
+		case "set":
+			updatedData, err = db.UpdateSetInDataTx(ctx, storage.UpdateSetInDataTxParams{
+				UpdateSetInDataParams: storage.UpdateSetInDataParams{
+					Id:         data.Id,
+					ActivityId: activity.Id,
+
+					Field:         input.Field,
+					Value:         values[input.Field],
+					Computed:      computed,
+					UniqueKeys:    uniqueKeys,
+					SchemaVersion: activity.Version,
+				},
+				History: history,
+			})
+		case "add":
+			updatedData, err = db.UpdateAddToDataTx(ctx, storage.UpdateAddToDataTxParams{
+				UpdateAddToDataParams: storage.UpdateAddToDataParams{
+					Id:         data.Id,
+					ActivityId: activity.Id,
+
+					Field:         input.Field,
+					Value:         models.ListItems(values[input.Field])[position],
+					Position:      uint(position),
+					Computed:      computed,
+					UniqueKeys:    uniqueKeys,
+					SchemaVersion: activity.Version,
+				},
+				History: history,
+			})
+		case "remove":
+			updatedData, err = db.UpdateRemoveFromDataTx(ctx, storage.UpdateRemoveFromDataTxParams{
+				UpdateRemoveFromDataParams: storage.UpdateRemoveFromDataParams{
+					Id:         data.Id,
+					ActivityId: activity.Id,
+
+					Field:         input.Field,
+					Position:      uint(position),
+					Computed:      computed,
+					UniqueKeys:    uniqueKeys,
+					SchemaVersion: activity.Version,
+				},
+				History: history,
+			})
+		}
+		if errors.Is(err, storage.ErrDataNotUnique) {
+			http.Error(w, "ERR_DATA_PTCH_NOT_UNIQUE", http.StatusBadRequest)
+			return
+		}
+		// the item was removed from the list meanwhile
+		if err == nil && updatedData == nil && operation == "remove" {
+			http.Error(w, "ERR_DATA_PTCH_POSITION_NOT_FOUND", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "ERR_DATA_PTCH_STORAGE", http.StatusInternalServerError)
+			return
+		}
+		// the data was deleted meanwhile
+		if updatedData == nil {
+			http.Error(w, "ERR_DATA_PTCH_NOT_FOUND", http.StatusNotFound)
+			return
+		}
+
+		response := UpdateDataResponse{
+			Data: *updatedData,
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			http.Error(w, "ERR_DATA_PTCH_END", http.StatusBadRequest)
+			return
+		}
+	})
+}
+
 type getAllDataInterface interface {
-	GetAllData(ctx context.Context, arg storage.GetAllDataParams) ([]*models.Data, error)
+	GetPaginatedData(ctx context.Context, arg storage.GetPaginatedDataParams) (*storage.PaginatedData, error)
 }
 
+const (
+	defaultDataPageLimit = 50
+	maxDataPageLimit     = 500
+)
+
 type FieldResponse struct {
 	Name string `json:"name"`
 	Type string `json:"type"`
 }
 
+type DataPagination struct {
+	Total      int64  `json:"total"`
+	Limit      int64  `json:"limit"`
+	Offset     int64  `json:"offset"`
+	Sort       string `json:"sort"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	PrevCursor string `json:"prev_cursor,omitempty"`
+	Next       string `json:"next,omitempty"`
+	Prev       string `json:"prev,omitempty"`
+}
+
 type GetAllDataResponse struct {
-	Fields map[string]FieldResponse `json:"fields"`
-	Data   []*models.Data           `json:"data"`
+	Fields     map[string]FieldResponse `json:"fields"`
+	Data       []*models.Data           `json:"data"`
+	Pagination DataPagination           `json:"pagination"`
 }
 
+// GetAllData lists the data of an activity, page by page.
+// Query parameters:
+//   - limit: number of data per page
+//   - offset: number of data to skip
+//   - cursor: opaque position returned as next_cursor or prev_cursor, with the same sort; it takes precedence over offset
+//   - sort: created_at, updated_at or a field id, prefixed with - for a descending order
+//   - filter: conditions on the values of the data, see the filters package
 func (handler *AppHandler) GetAllData(mux chi.Router, db getAllDataInterface) {
 	mux.Get("/", func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
+		query := r.URL.Query()
 
 		activity := ctx.Value("activity").(*models.Activity)
 
-		data, err := db.GetAllData(ctx, storage.GetAllDataParams{
+		limit, err := queryInt(query.Get("limit"), defaultDataPageLimit)
+		if err != nil || limit <= 0 {
+			http.Error(w, "ERR_DATA_GALL_LIMIT", http.StatusBadRequest)
+			return
+		}
+		if limit > maxDataPageLimit {
+			limit = maxDataPageLimit
+		}
+
+		offset, err := queryInt(query.Get("offset"), 0)
+		if err != nil || offset < 0 {
+			http.Error(w, "ERR_DATA_GALL_OFFSET", http.StatusBadRequest)
+			return
+		}
+
+		sort := query.Get("sort")
+		sortBy, sortOrder, ok := dataSortFromQuery(activity, sort)
+		if !ok {
+			http.Error(w, "ERR_DATA_GALL_SORT", http.StatusBadRequest)
+			return
+		}
+
+		filterBy, err := filters.Parse(activity, query.Get("filter"))
+		if err != nil {
+			writeFilterError(w, "ERR_DATA_GALL_FILTER", err)
+			return
+		}
+
+		var cursor *storage.DataCursor
+		if query.Get("cursor") != "" {
+			cursor, err = storage.DecodeDataCursor(query.Get("cursor"), services.CursorSecretKey())
+			if err != nil || cursor.SortBy != sortBy || cursor.SortOrder != sortOrder || !dataSortValueMatches(activity, sortBy, cursor.Value) {
+				http.Error(w, "ERR_DATA_GALL_CURSOR", http.StatusBadRequest)
+				return
+			}
+			offset = 0
+		}
+
+		page, err := db.GetPaginatedData(ctx, storage.GetPaginatedDataParams{
 			ActivityId: activity.Id,
+			FilterBy:   filterBy,
+			SortBy:     sortBy,
+			SortOrder:  sortOrder,
+			Limit:      limit,
+			Offset:     offset,
+			Cursor:     cursor,
 		})
 		if err != nil {
 			http.Error(w, "ERR_DATA_GALL_01", http.StatusBadRequest)
 			return
 		}
 
+		pagination, err := dataPagination(r, page, cursor, sortBy, sortOrder, limit, offset)
+		if err != nil {
+			http.Error(w, "ERR_DATA_GALL_02", http.StatusBadRequest)
+			return
+		}
+		pagination.Sort = sort
+
 		fields := make(map[string]FieldResponse)
 		for _, field := range activity.Fields {
 			fields[field.Id.Hex()] = FieldResponse{
@@ -280,8 +883,20 @@ func (handler *AppHandler) GetAllData(mux chi.Router, db getAllDataInterface) {
 		}
 
 		response := GetAllDataResponse{
-			Fields: fields,
-			Data:   data,
+			Fields:     fields,
+			Data:       page.Data,
+			Pagination: *pagination,
+		}
+
+		links := make([]string, 0, 2)
+		if pagination.Next != "" {
+			links = append(links, fmt.Sprintf(`<%s>; rel="next"`, pagination.Next))
+		}
+		if pagination.Prev != "" {
+			links = append(links, fmt.Sprintf(`<%s>; rel="prev"`, pagination.Prev))
+		}
+		if len(links) > 0 {
+			w.Header().Set("Link", strings.Join(links, ", "))
 		}
 
 		w.Header().Set("Content-Type", "application/json")
@@ -293,6 +908,196 @@ func (handler *AppHandler) GetAllData(mux chi.Router, db getAllDataInterface) {
 	})
 }
 
+// dataSortFromQuery converts the sort query parameter into the document field to sort on
+func dataSortFromQuery(activity *models.Activity, sort string) (string, int, bool) {
+	sortOrder := 1
+	if strings.HasPrefix(sort, "-") {
+		sortOrder = -1
+		sort = strings.TrimPrefix(sort, "-")
+	}
+
+	switch sort {
+	case "":
+		return "created_at", sortOrder, true
+	case "created_at", "updated_at":
+		return sort, sortOrder, true
+	}
+
+	for _, field := range activity.Fields {
+		// the locations have no order, and can not be kept in a cursor
+		if field.Id.Hex() == sort && field.Type != models.FieldTypeGeolocation {
+			return fmt.Sprintf("values.%s", sort), sortOrder, true
+		}
+	}
+	return "", 0, false
+}
+
+// dataSortValueMatches tells if the value of a cursor has the type of the values sorted on
+func dataSortValueMatches(activity *models.Activity, sortBy string, value any) bool {
+	if value == nil {
+		return true
+	}
+
+	switch sortBy {
+	case "created_at", "updated_at":
+		_, ok := value.(primitive.DateTime)
+		return ok
+	}
+
+	for _, field := range activity.Fields {
+		if fmt.Sprintf("values.%s", field.Id.Hex()) != sortBy {
+			continue
+		}
+
+		items, ok := value.(primitive.A)
+		if !ok {
+			return isFieldSortValue(field, value)
+		}
+		if !field.IsList() {
+			return false
+		}
+		for _, item := range items {
+			if !isFieldSortValue(field, item) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// isFieldSortValue tells if the value has the type of the values of the field once cast
+func isFieldSortValue(field models.ActivityField, value any) bool {
+	switch value.(type) {
+	case string:
+		switch field.Type {
+		case models.FieldTypeNumber, models.FieldTypeInteger, models.FieldTypeDecimal, models.FieldTypeCurrency,
+			models.FieldTypeRating, models.FieldTypeDuration, models.FieldTypeBoolean, models.FieldTypeGeolocation:
+			return false
+		}
+		return true
+	case int32, int64, float64:
+		switch field.Type {
+		case models.FieldTypeNumber, models.FieldTypeInteger, models.FieldTypeDecimal, models.FieldTypeCurrency,
+			models.FieldTypeRating, models.FieldTypeDuration, models.FieldTypeKey:
+			return true
+		}
+		return false
+	case bool:
+		return field.Type == models.FieldTypeBoolean
+	default:
+		return false
+	}
+}
+
+func dataPagination(r *http.Request, page *storage.PaginatedData, cursor *storage.DataCursor, sortBy string, sortOrder int, limit, offset int64) (*DataPagination, error) {
+	pagination := DataPagination{
+		Total:  page.Total,
+		Limit:  limit,
+		Offset: offset,
+	}
+	if len(page.Data) == 0 {
+		return &pagination, nil
+	}
+
+	first := page.Data[0]
+	last := page.Data[len(page.Data)-1]
+
+	hasNext := page.HasMore
+	hasPrev := offset > 0
+	if cursor != nil {
+		hasPrev = true
+		if cursor.Backward {
+			hasNext, hasPrev = true, page.HasMore
+		}
+	}
+
+	var err error
+	if hasNext {
+		pagination.NextCursor, err = storage.EncodeDataCursor(storage.DataCursor{
+			Value:     storage.DataSortValue(last, sortBy),
+			Id:        last.Id,
+			SortBy:    sortBy,
+			SortOrder: sortOrder,
+		}, services.CursorSecretKey())
+		if err != nil {
+			return nil, err
+		}
+	}
+	if hasPrev {
+		pagination.PrevCursor, err = storage.EncodeDataCursor(storage.DataCursor{
+			Value:     storage.DataSortValue(first, sortBy),
+			Id:        first.Id,
+			Backward:  true,
+			SortBy:    sortBy,
+			SortOrder: sortOrder,
+		}, services.CursorSecretKey())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if cursor == nil {
+		if hasNext {
+			pagination.Next = pageLink(r, map[string]string{"offset": strconv.FormatInt(offset+limit, 10)})
+		}
+		if hasPrev {
+			prevOffset := offset - limit
+			if prevOffset < 0 {
+				prevOffset = 0
+			}
+			pagination.Prev = pageLink(r, map[string]string{"offset": strconv.FormatInt(prevOffset, 10)})
+		}
+	} else {
+		if hasNext {
+			pagination.Next = pageLink(r, map[string]string{"cursor": pagination.NextCursor, "offset": ""})
+		}
+		if hasPrev {
+			pagination.Prev = pageLink(r, map[string]string{"cursor": pagination.PrevCursor, "offset": ""})
+		}
+	}
+
+	return &pagination, nil
+}
+
+// pageLink returns the current request URI with its query parameters changed.
+// An empty value removes the parameter.
+func pageLink(r *http.Request, changes map[string]string) string {
+	u := *r.URL
+	query := u.Query()
+	for key, value := range changes {
+		if value == "" {
+			query.Del(key)
+		} else {
+			query.Set(key, value)
+		}
+	}
+	u.RawQuery = query.Encode()
+
+	return u.RequestURI()
+}
+
+func writeFilterError(w http.ResponseWriter, code string, err error) {
+	fieldError := models.FieldError{
+		Code:    "ERR_FILTER_INVALID",
+		Message: err.Error(),
+	}
+	var filterErr *filters.Error
+	if errors.As(err, &filterErr) {
+		fieldError.FieldId = filterErr.Field
+		fieldError.Message = filterErr.Message
+	}
+
+	writeFieldErrors(w, code, []models.FieldError{fieldError})
+}
+
+func queryInt(value string, defaultValue int64) (int64, error) {
+	if value == "" {
+		return defaultValue, nil
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
 type getDataInterface interface {
 }
 
@@ -320,7 +1125,7 @@ func (handler *AppHandler) GetData(mux chi.Router, db getDataInterface) {
 }
 
 type deleteDataInterface interface {
-	DeleteData(ctx context.Context, arg storage.DeleteDataParams) error
+	DeleteDataTx(ctx context.Context, arg storage.DeleteDataTxParams) error
 	GetAllData(ctx context.Context, arg storage.GetAllDataParams) ([]*models.Data, error)
 }
 
@@ -348,52 +1153,51 @@ func (handler *AppHandler) DeleteData(mux chi.Router, db deleteDataInterface, s3
 			}
 		}
 		if len(relationships) > 0 {
-			var primaryKeyField *models.ActivityField = nil
-			for i := range activity.Fields {
-				if activity.Fields[i].PrimaryKey {
-					primaryKeyField = &activity.Fields[i]
-
-					break
-				}
-			}
-			if primaryKeyField != nil {
-
-				var notDeletedReasons []string = make([]string, 0)
-				for i := range relationships {
-					relationship := relationships[i]
-
-					relationshipData, err := db.GetAllData(ctx, storage.GetAllDataParams{
-						ActivityId: relationship.ActivityId,
-						Projections: map[string]int{
-							fmt.Sprintf("values.%s", relationship.FieldId.Hex()): 1,
-						},
-						FilterBy: map[string]any{
-							fmt.Sprintf("values.%s", relationship.FieldId.Hex()): data.Values[primaryKeyField.Id.Hex()],
-						},
-					})
-					if err != nil {
-						http.Error(w, "ERR_DATA_DLT_GET_DATA_USING_PK_VALUE", http.StatusBadRequest)
-						return
-					}
-
-					if len(relationshipData) > 0 {
-						// Must not be deleted
-						// Delete the children first
-						notDeletedReasons = append(notDeletedReasons, relationship.ActivityId.Hex())
-					}
+			var notDeletedReasons []string = make([]string, 0)
+			for i := range relationships {
+				relationship := relationships[i]
+
+				// The key field of the other activity holds the value of the referenced field,
+				// which is not always the primary key: it can be composite or missing
+				referencedValue := data.Values[relationship.ConcernedFieldId.Hex()]
+				if referencedValue == nil {
+					continue
 				}
 
-				if len(notDeletedReasons) > 0 {
-					http.Error(w, "ERR_DATA_DLT_ROW_REF_SWH", http.StatusBadRequest)
+				relationshipData, err := db.GetAllData(ctx, storage.GetAllDataParams{
+					ActivityId: relationship.ActivityId,
+					Projections: map[string]int{
+						fmt.Sprintf("values.%s", relationship.FieldId.Hex()): 1,
+					},
+					FilterBy: map[string]any{
+						fmt.Sprintf("values.%s", relationship.FieldId.Hex()): referencedValue,
+					},
+				})
+				if err != nil {
+					http.Error(w, "ERR_DATA_DLT_GET_DATA_USING_PK_VALUE", http.StatusBadRequest)
 					return
 				}
+
+				if len(relationshipData) > 0 {
+					// Must not be deleted
+					// Delete the children first
+					notDeletedReasons = append(notDeletedReasons, relationship.ActivityId.Hex())
+				}
 			}
 
+			if len(notDeletedReasons) > 0 {
+				http.Error(w, "ERR_DATA_DLT_ROW_REF_SWH", http.StatusBadRequest)
+				return
+			}
 		}
 
-		err := db.DeleteData(ctx, storage.DeleteDataParams{
-			Id:         data.Id,
-			ActivityId: activity.Id,
+		err := db.DeleteDataTx(ctx, storage.DeleteDataTxParams{
+			DeleteDataParams: storage.DeleteDataParams{
+				Id:         data.Id,
+				ActivityId: activity.Id,
+				DeletedBy:  dataAuthor(handler.GetAuthenticatedUser(r)),
+			},
+			Before: data.Values,
 		})
 		if err != nil {
 			http.Error(w, "ERR_DATA_DLT_01", http.StatusBadRequest)
@@ -0,0 +1,365 @@
+package handlers
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/xuri/excelize/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"stockinos.com/api/filters"
+	"stockinos.com/api/models"
+	"stockinos.com/api/storage"
+)
+
+// An export can take much longer than the usual requests
+const exportDataTimeout = 5 * time.Minute
+
+type exportDataInterface interface {
+	ForEachData(ctx context.Context, arg storage.ForEachDataParams, fn func(data *models.Data) error) error
+	GetAllData(ctx context.Context, arg storage.GetAllDataParams) ([]*models.Data, error)
+}
+
+// ExportData streams the data of an activity as a CSV (default) or a XLSX file.
+// Query parameters:
+//   - format: csv or xlsx
+//   - sort and filter: same as the listing of the data
+func (appHandler *AppHandler) ExportData(mux chi.Router, db exportDataInterface) {
+	mux.Get("/export", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		query := r.URL.Query()
+
+		activity := ctx.Value("activity").(*models.Activity)
+
+		format := strings.ToLower(query.Get("format"))
+		if format == "" {
+			format = "csv"
+		}
+		if format != "csv" && format != "xlsx" {
+			http.Error(w, "ERR_DATA_EXP_FORMAT", http.StatusBadRequest)
+			return
+		}
+
+		sortBy, sortOrder, ok := dataSortFromQuery(activity, query.Get("sort"))
+		if !ok {
+			http.Error(w, "ERR_DATA_EXP_SORT", http.StatusBadRequest)
+			return
+		}
+
+		filterBy, err := filters.Parse(activity, query.Get("filter"))
+		if err != nil {
+			writeFilterError(w, "ERR_DATA_EXP_FILTER", err)
+			return
+		}
+
+		keyDisplayValues, err := getKeyDisplayValues(ctx, activity, db)
+		if err != nil {
+			http.Error(w, "ERR_DATA_EXP_KEYS", http.StatusBadRequest)
+			return
+		}
+
+		rc := http.NewResponseController(w)
+		if err := rc.SetWriteDeadline(time.Now().Add(exportDataTimeout)); err != nil {
+			log.Println("error when extending the write deadline of the export: ", err)
+		}
+
+		var writer dataExportWriter
+		filename := exportFilename(activity.Name, format)
+		if format == "xlsx" {
+			w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+			writer, err = newXLSXExportWriter(w)
+			if err != nil {
+				http.Error(w, "ERR_DATA_EXP_01", http.StatusBadRequest)
+				return
+			}
+		} else {
+			w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+			writer = newCSVExportWriter(w)
+		}
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+		header := make([]any, len(activity.Fields))
+		for i, field := range activity.Fields {
+			header[i] = field.Name
+		}
+		if err := writer.WriteRow(header); err != nil {
+			http.Error(w, "ERR_DATA_EXP_02", http.StatusBadRequest)
+			return
+		}
+
+		// From here, the response has started: errors can only be logged
+		err = db.ForEachData(ctx, storage.ForEachDataParams{
+			ActivityId: activity.Id,
+			FilterBy:   filterBy,
+			SortBy:     sortBy,
+			SortOrder:  sortOrder,
+		}, func(data *models.Data) error {
+			row := make([]any, len(activity.Fields))
+			for i, field := range activity.Fields {
+				row[i] = exportCellValue(field, data.Values[field.Id.Hex()], keyDisplayValues[field.Id.Hex()])
+			}
+			return writer.WriteRow(row)
+		})
+		if err != nil {
+			log.Println("error when exporting the data: ", err)
+		}
+
+		if err := writer.Close(); err != nil {
+			log.Println("error when closing the export: ", err)
+		}
+	})
+}
+
+// getKeyDisplayValues returns, for each key field of the activity, the value to display
+// for each key value: the field to use of the referenced data.
+func getKeyDisplayValues(ctx context.Context, activity *models.Activity, db exportDataInterface) (map[string]map[string]any, error) {
+	displayValues := make(map[string]map[string]any)
+
+	for _, field := range activity.Fields {
+		details := field.Details.ActivityFieldKey
+		if field.Type != models.FieldTypeKey || details == nil || details.ActivityId.IsZero() {
+			continue
+		}
+
+		fieldToUseId := details.FieldToUseId
+		if fieldToUseId.IsZero() {
+			fieldToUseId = details.FieldId
+		}
+
+		referencedData, err := db.GetAllData(ctx, storage.GetAllDataParams{
+			ActivityId: details.ActivityId,
+			Projections: map[string]int{
+				fmt.Sprintf("values.%s", details.FieldId.Hex()): 1,
+				fmt.Sprintf("values.%s", fieldToUseId.Hex()):    1,
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		values := make(map[string]any, len(referencedData))
+		for _, data := range referencedData {
+			key := data.Values[details.FieldId.Hex()]
+			if key != nil {
+				values[fmt.Sprint(key)] = data.Values[fieldToUseId.Hex()]
+			}
+		}
+		displayValues[field.Id.Hex()] = values
+	}
+
+	return displayValues, nil
+}
+
+// exportCellValue converts a value into what is written in a cell.
+// Numbers are kept as numbers, everything else is written as a text.
+func exportCellValue(field models.ActivityField, value any, keyDisplayValues map[string]any) any {
+	if value == nil {
+		return ""
+	}
+
+	if items, ok := value.(primitive.A); ok {
+		value = []any(items)
+	}
+
+	if models.IsNumberType(field.Type) {
+		if n, ok := models.NumberValue(value); ok {
+			return n
+		}
+	}
+
+	switch field.Type {
+	case models.FieldTypeGeolocation:
+		if !field.IsList() {
+			return exportGeolocation(value)
+		}
+		locations := models.ListItems(value)
+		lines := make([]string, len(locations))
+		for i := range locations {
+			lines[i] = exportGeolocation(locations[i])
+		}
+		return strings.Join(lines, "\n")
+	case models.FieldTypeKey:
+		if !field.IsList() {
+			return exportKey(value, keyDisplayValues)
+		}
+		keys := models.ListItems(value)
+		items := make([]string, len(keys))
+		for i := range keys {
+			items[i] = exportKey(keys[i], keyDisplayValues)
+		}
+		return strings.Join(items, ", ")
+	case models.FieldTypeUpload:
+		files := exportList(value)
+		for i := range files {
+			if !strings.HasPrefix(files[i], "http") {
+				files[i] = AWS_S3_ROOT + files[i]
+			}
+		}
+		return strings.Join(files, "\n")
+	}
+
+	return exportText(value)
+}
+
+func exportText(value any) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case time.Time:
+		return v.Format(time.RFC3339)
+	case primitive.DateTime:
+		return v.Time().Format(time.RFC3339)
+	case []any, []string, primitive.A:
+		return strings.Join(exportList(v), ", ")
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// exportKey writes the value to display for a key, the key itself when the data referenced is not found
+func exportKey(value any, keyDisplayValues map[string]any) string {
+	if displayValue, ok := keyDisplayValues[fmt.Sprint(value)]; ok && displayValue != nil {
+		return exportText(displayValue)
+	}
+	return exportText(value)
+}
+
+// exportFormulaSafe keeps a spreadsheet from running a text as a formula, the numbers are left as they are
+func exportFormulaSafe(cell any) any {
+	text, ok := cell.(string)
+	if !ok || text == "" {
+		return cell
+	}
+	switch text[0] {
+	case '=', '+', '-', '@', '\t', '\r':
+		return "'" + text
+	}
+	return cell
+}
+
+// exportGeolocation writes a location latitude first, as it is read when imported
+func exportGeolocation(value any) string {
+	coordinates := exportList(value)
+	if len(coordinates) != 2 {
+		return exportText(value)
+	}
+	return coordinates[1] + ", " + coordinates[0]
+}
+
+func exportList(value any) []string {
+	switch v := value.(type) {
+	case []string:
+		return append([]string{}, v...)
+	case []any:
+		items := make([]string, len(v))
+		for i := range v {
+			items[i] = exportText(v[i])
+		}
+		return items
+	case primitive.A:
+		return exportList([]any(v))
+	default:
+		return []string{exportText(v)}
+	}
+}
+
+var unsafeFilenameCharacters = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+func exportFilename(activityName, format string) string {
+	name := strings.Trim(unsafeFilenameCharacters.ReplaceAllString(activityName, "-"), "-")
+	if name == "" {
+		name = "data"
+	}
+	return fmt.Sprintf("%s-%s.%s", name, time.Now().Format("20060102"), format)
+}
+
+type dataExportWriter interface {
+	WriteRow(row []any) error
+	Close() error
+}
+
+type csvExportWriter struct {
+	writer *csv.Writer
+	rows   int
+}
+
+func newCSVExportWriter(w http.ResponseWriter) *csvExportWriter {
+	return &csvExportWriter{
+		writer: csv.NewWriter(w),
+	}
+}
+
+func (cw *csvExportWriter) WriteRow(row []any) error {
+	record := make([]string, len(row))
+	for i := range row {
+		record[i] = exportText(exportFormulaSafe(row[i]))
+	}
+	if err := cw.writer.Write(record); err != nil {
+		return err
+	}
+
+	// Send the rows regularly instead of buffering the whole file
+	cw.rows++
+	if cw.rows%100 == 0 {
+		cw.writer.Flush()
+	}
+	return cw.writer.Error()
+}
+
+func (cw *csvExportWriter) Close() error {
+	cw.writer.Flush()
+	return cw.writer.Error()
+}
+
+type xlsxExportWriter struct {
+	w      http.ResponseWriter
+	file   *excelize.File
+	stream *excelize.StreamWriter
+	rows   int
+}
+
+func newXLSXExportWriter(w http.ResponseWriter) (*xlsxExportWriter, error) {
+	file := excelize.NewFile()
+	stream, err := file.NewStreamWriter("Sheet1")
+	if err != nil {
+		return nil, err
+	}
+
+	return &xlsxExportWriter{
+		w:      w,
+		file:   file,
+		stream: stream,
+	}, nil
+}
+
+func (xw *xlsxExportWriter) WriteRow(row []any) error {
+	xw.rows++
+	cell, err := excelize.CoordinatesToCellName(1, xw.rows)
+	if err != nil {
+		return err
+	}
+	safeRow := make([]any, len(row))
+	for i := range row {
+		safeRow[i] = exportFormulaSafe(row[i])
+	}
+	return xw.stream.SetRow(cell, safeRow)
+}
+
+// Close writes the file: a XLSX file is an archive that can only be sent once complete
+func (xw *xlsxExportWriter) Close() error {
+	defer xw.file.Close()
+
+	if err := xw.stream.Flush(); err != nil {
+		return err
+	}
+	return xw.file.Write(xw.w)
+}
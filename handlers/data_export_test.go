@@ -0,0 +1,240 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/xuri/excelize/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"stockinos.com/api/handlers"
+	"stockinos.com/api/helpertest"
+	"stockinos.com/api/models"
+	"stockinos.com/api/storage"
+)
+
+type mockExportDataDB struct {
+	ForEachDataFunc func(ctx context.Context, arg storage.ForEachDataParams, fn func(data *models.Data) error) error
+	GetAllDataFunc  func(ctx context.Context, arg storage.GetAllDataParams) ([]*models.Data, error)
+}
+
+func (mdb *mockExportDataDB) ForEachData(ctx context.Context, arg storage.ForEachDataParams, fn func(data *models.Data) error) error {
+	return mdb.ForEachDataFunc(ctx, arg, fn)
+}
+
+func (mdb *mockExportDataDB) GetAllData(ctx context.Context, arg storage.GetAllDataParams) ([]*models.Data, error) {
+	return mdb.GetAllDataFunc(ctx, arg)
+}
+
+// newExportActivity returns an activity with a text, a number, a key, a list of keys and a note field,
+// the data of the activity and the data referenced by their keys
+func newExportActivity() (*models.Activity, []*models.Data, []*models.Data) {
+	clientCode := primitive.NewObjectID()
+	clientName := primitive.NewObjectID()
+	reference := models.ActivityField{Id: primitive.NewObjectID(), Name: "Reference", Type: models.FieldTypeText}
+	amount := models.ActivityField{Id: primitive.NewObjectID(), Name: "Amount", Type: models.FieldTypeNumber}
+	client := models.ActivityField{
+		Id:   primitive.NewObjectID(),
+		Name: "Client",
+		Type: models.FieldTypeKey,
+		Details: models.ActivityFieldType{ActivityFieldKey: &models.ActivityFieldKey{
+			ActivityId:   primitive.NewObjectID(),
+			FieldId:      clientCode,
+			FieldToUseId: clientName,
+		}},
+	}
+	contacts := client
+	contacts.Id = primitive.NewObjectID()
+	contacts.Name = "Contacts"
+	contacts.Options.Multiple = true
+	note := models.ActivityField{Id: primitive.NewObjectID(), Name: "Note", Type: models.FieldTypeText}
+	activity := &models.Activity{
+		Id:     primitive.NewObjectID(),
+		Name:   "Orders of the week",
+		Fields: []models.ActivityField{reference, amount, client, contacts, note},
+	}
+
+	datas := []*models.Data{
+		{Id: primitive.NewObjectID(), ActivityId: activity.Id, Values: map[string]any{
+			reference.Id.Hex(): "D-1",
+			amount.Id.Hex():    12.5,
+			client.Id.Hex():    "C1",
+			contacts.Id.Hex():  primitive.A{"C1", "C2", "C3"},
+			note.Id.Hex():      "=1+1",
+		}},
+		{Id: primitive.NewObjectID(), ActivityId: activity.Id, Values: map[string]any{
+			reference.Id.Hex(): "D-2",
+			amount.Id.Hex():    -4.0,
+			note.Id.Hex():      "-3 in stock",
+		}},
+	}
+	clients := []*models.Data{
+		{Id: primitive.NewObjectID(), Values: map[string]any{
+			clientCode.Hex(): "C1",
+			clientName.Hex(): "Acme",
+		}},
+		{Id: primitive.NewObjectID(), Values: map[string]any{
+			clientCode.Hex(): "C2",
+			clientName.Hex(): "Globex",
+		}},
+	}
+	return activity, datas, clients
+}
+
+func TestExportData(t *testing.T) {
+	handler := handlers.NewAppHandler()
+
+	exportData := func(activity *models.Activity, db *mockExportDataDB, query url.Values) (int, http.Header, string) {
+		mux := chi.NewMux()
+		handler.ExportData(mux, db)
+		_, w, response := helpertest.MakeGetRequest(
+			mux,
+			"/export?"+query.Encode(),
+			[]helpertest.ContextData{{Name: "activity", Value: activity}},
+		)
+		return w.StatusCode, w.Header, response
+	}
+
+	t.Run("wrong query", func(t *testing.T) {
+		activity, _, clients := newExportActivity()
+		db := &mockExportDataDB{
+			ForEachDataFunc: func(ctx context.Context, arg storage.ForEachDataParams, fn func(data *models.Data) error) error {
+				t.Errorf("ExportData(): the data are read; want the export rejected")
+				return nil
+			},
+			GetAllDataFunc: func(ctx context.Context, arg storage.GetAllDataParams) ([]*models.Data, error) {
+				return clients, nil
+			},
+		}
+
+		testCases := map[string]struct {
+			Query         url.Values
+			ResponseError string
+		}{
+			"unknown format": {
+				Query:         url.Values{"format": {"pdf"}},
+				ResponseError: "ERR_DATA_EXP_FORMAT",
+			},
+			"unknown sort field": {
+				Query:         url.Values{"sort": {primitive.NewObjectID().Hex()}},
+				ResponseError: "ERR_DATA_EXP_SORT",
+			},
+			"invalid filter": {
+				Query:         url.Values{"filter": {fmt.Sprintf("%s gt ten", activity.Fields[1].Id.Hex())}},
+				ResponseError: "ERR_DATA_EXP_FILTER",
+			},
+		}
+
+		for name, tc := range testCases {
+			t.Run(name, func(t *testing.T) {
+				code, _, response := exportData(activity, db, tc.Query)
+				if code != http.StatusBadRequest {
+					t.Fatalf("ExportData(): status - got %d; want %d", code, http.StatusBadRequest)
+				}
+				if !strings.HasPrefix(response, tc.ResponseError) {
+					got := handlers.DataValidationErrorResponse{}
+					json.Unmarshal([]byte(response), &got)
+					if got.Error != tc.ResponseError {
+						t.Fatalf("ExportData(): response error - got %s; want %s", response, tc.ResponseError)
+					}
+				}
+			})
+		}
+	})
+
+	t.Run("error from db", func(t *testing.T) {
+		activity, _, _ := newExportActivity()
+		db := &mockExportDataDB{
+			GetAllDataFunc: func(ctx context.Context, arg storage.GetAllDataParams) ([]*models.Data, error) {
+				return nil, errors.New("an error happens")
+			},
+		}
+
+		code, _, response := exportData(activity, db, url.Values{})
+		if code != http.StatusBadRequest {
+			t.Fatalf("ExportData(): status - got %d; want %d", code, http.StatusBadRequest)
+		}
+		wantError := "ERR_DATA_EXP_KEYS"
+		if response != wantError {
+			t.Fatalf("ExportData(): response error - got %s; want %s", response, wantError)
+		}
+	})
+
+	// the texts read as formulas by a spreadsheet are escaped, the negative numbers are not
+	want := [][]string{
+		{"Reference", "Amount", "Client", "Contacts", "Note"},
+		{"D-1", "12.5", "Acme", "Acme, Globex, C3", "'=1+1"},
+		{"D-2", "-4", "", "", "'-3 in stock"},
+	}
+	newDB := func(t *testing.T, activity *models.Activity, datas, clients []*models.Data) *mockExportDataDB {
+		return &mockExportDataDB{
+			ForEachDataFunc: func(ctx context.Context, arg storage.ForEachDataParams, fn func(data *models.Data) error) error {
+				if arg.ActivityId != activity.Id || arg.SortBy != "values."+activity.Fields[0].Id.Hex() || arg.SortOrder != -1 {
+					t.Errorf("ForEachData(): got %+v; want the data of the activity sorted by reference", arg)
+				}
+				for _, data := range datas {
+					if err := fn(data); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+			GetAllDataFunc: func(ctx context.Context, arg storage.GetAllDataParams) ([]*models.Data, error) {
+				return clients, nil
+			},
+		}
+	}
+
+	t.Run("csv", func(t *testing.T) {
+		activity, datas, clients := newExportActivity()
+		code, header, response := exportData(activity, newDB(t, activity, datas, clients), url.Values{
+			"sort": {"-" + activity.Fields[0].Id.Hex()},
+		})
+		if code != http.StatusOK {
+			t.Fatalf("ExportData(): status - got %d; want %d (%s)", code, http.StatusOK, response)
+		}
+		if disposition := header.Get("Content-Disposition"); !strings.Contains(disposition, `filename="Orders-of-the-week-`) {
+			t.Fatalf("ExportData(): Content-Disposition - got %s; want the name of the activity", disposition)
+		}
+
+		rows, err := csv.NewReader(strings.NewReader(response)).ReadAll()
+		if err != nil {
+			t.Fatalf("ExportData(): invalid csv %v", err)
+		}
+		if !reflect.DeepEqual(rows, want) {
+			t.Fatalf("ExportData(): rows - got %v; want %v", rows, want)
+		}
+	})
+
+	t.Run("xlsx", func(t *testing.T) {
+		activity, datas, clients := newExportActivity()
+		code, _, response := exportData(activity, newDB(t, activity, datas, clients), url.Values{
+			"format": {"xlsx"},
+			"sort":   {"-" + activity.Fields[0].Id.Hex()},
+		})
+		if code != http.StatusOK {
+			t.Fatalf("ExportData(): status - got %d; want %d (%s)", code, http.StatusOK, response)
+		}
+
+		file, err := excelize.OpenReader(strings.NewReader(response))
+		if err != nil {
+			t.Fatalf("ExportData(): invalid xlsx %v", err)
+		}
+		defer file.Close()
+		rows, err := file.GetRows("Sheet1")
+		if err != nil {
+			t.Fatalf("GetRows(): %v", err)
+		}
+		if !reflect.DeepEqual(rows, want) {
+			t.Fatalf("ExportData(): rows - got %v; want %v", rows, want)
+		}
+	})
+}
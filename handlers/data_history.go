@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"stockinos.com/api/models"
+	"stockinos.com/api/storage"
+)
+
+func dataAuthor(user *models.User) models.DataAuthor {
+	return models.DataAuthor{
+		Id:   user.Id,
+		Name: strings.TrimSpace(fmt.Sprintf("%s %s", user.LastName, user.FirstName)),
+	}
+}
+
+type getDataHistoryInterface interface {
+	GetDataHistory(ctx context.Context, arg storage.GetDataHistoryParams) ([]*models.DataHistory, error)
+}
+
+type GetDataHistoryResponse struct {
+	History []*models.DataHistory `json:"history"`
+}
+
+// GetDataHistory lists all the actions done on a data, the latest first
+func (appHandler *AppHandler) GetDataHistory(mux chi.Router, db getDataHistoryInterface) {
+	mux.Get("/history", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		activity := ctx.Value("activity").(*models.Activity)
+		data := ctx.Value("data").(*models.Data)
+
+		history, err := db.GetDataHistory(ctx, storage.GetDataHistoryParams{
+			DataId:     data.Id,
+			ActivityId: activity.Id,
+		})
+		if err != nil {
+			http.Error(w, "ERR_DATA_HIST_01", http.StatusBadRequest)
+			return
+		}
+
+		response := GetDataHistoryResponse{
+			History: history,
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			http.Error(w, "ERR_DATA_HIST_END", http.StatusBadRequest)
+			return
+		}
+	})
+}
+
+type restoreDataVersionInterface interface {
+	GetActivity(ctx context.Context, arg storage.GetActivityParams) (*models.Activity, error)
+	GetDataHistoryEntry(ctx context.Context, arg storage.GetDataHistoryEntryParams) (*models.DataHistory, error)
+	GetDataFilterByValues(ctx context.Context, arg storage.GetDataFilterByValuesParams) (*models.Data, error)
+	UpdateDataTx(ctx context.Context, arg storage.UpdateDataTxParams) (*models.Data, error)
+}
+
+// RestoreDataVersion replaces the values of the data by the ones it had after an entry of its history.
+// The values are checked against the current fields of the activity: the values of the fields
+// removed since are dropped, and the automatic fields are computed again.
+func (appHandler *AppHandler) RestoreDataVersion(mux chi.Router, db restoreDataVersionInterface) {
+	mux.Post("/history/{historyId}/restore", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authUser := appHandler.GetAuthenticatedUser(r)
+
+		historyId, err := primitive.ObjectIDFromHex(chi.URLParam(r, "historyId"))
+		if err != nil {
+			http.Error(w, "ERR_DATA_HIST_RST_01", http.StatusBadRequest)
+			return
+		}
+
+		activity := ctx.Value("activity").(*models.Activity)
+		data := ctx.Value("data").(*models.Data)
+
+		entry, err := db.GetDataHistoryEntry(ctx, storage.GetDataHistoryEntryParams{
+			Id:         historyId,
+			DataId:     data.Id,
+			ActivityId: activity.Id,
+		})
+		if err != nil {
+			http.Error(w, "ERR_DATA_HIST_RST_02", http.StatusBadRequest)
+			return
+		}
+		if entry == nil {
+			http.Error(w, "ERR_DATA_HIST_RST_03", http.StatusNotFound)
+			return
+		}
+		if entry.Action == models.DataHistoryDeleted {
+			http.Error(w, "ERR_DATA_HIST_RST_DELETED", http.StatusBadRequest)
+			return
+		}
+
+		dataFormulas, err := loadDataFormulas(ctx, activity, db)
+		if err != nil {
+			http.Error(w, "ERR_DATA_HIST_RST_FORMULA", http.StatusBadRequest)
+			return
+		}
+
+		fields := make(map[string]bool, len(activity.Fields))
+		for _, field := range activity.Fields {
+			fields[field.Id.Hex()] = !dataFormulas.computes(field.Id.Hex())
+		}
+		restoredValues := make(map[string]any, len(entry.Values))
+		for fieldId, value := range entry.Values {
+			if fields[fieldId] && value != nil {
+				restoredValues[fieldId] = value
+			}
+		}
+
+		values, fieldErrors, err := validateDataValues(ctx, activity, restoredValues, db)
+		if err != nil {
+			http.Error(w, "ERR_DATA_HIST_RST_VALIDATION", http.StatusBadRequest)
+			return
+		}
+		if len(fieldErrors) > 0 {
+			writeFieldErrors(w, "ERR_DATA_HIST_RST_INVALID_VALUES", fieldErrors)
+			return
+		}
+
+		_, fieldErrors, err = completeDataValues(ctx, activity, values, dataFormulas)
+		if err != nil {
+			http.Error(w, "ERR_DATA_HIST_RST_FORMULA", http.StatusBadRequest)
+			return
+		}
+		if len(fieldErrors) > 0 {
+			writeFieldErrors(w, "ERR_DATA_HIST_RST_INVALID_VALUES", fieldErrors)
+			return
+		}
+
+		if fieldErrors := activity.CheckRules(values, time.Now()); len(fieldErrors) > 0 {
+			writeFieldErrors(w, "ERR_DATA_HIST_RST_INVALID_VALUES", fieldErrors)
+			return
+		}
+
+		fieldErrors, err = checkDataUniqueness(ctx, activity, values, data.Id, db)
+		if err != nil {
+			http.Error(w, "ERR_DATA_HIST_RST_UNIQUENESS", http.StatusBadRequest)
+			return
+		}
+		if len(fieldErrors) > 0 {
+			writeFieldErrors(w, uniquenessErrorCode("ERR_DATA_HIST_RST", fieldErrors), fieldErrors)
+			return
+		}
+
+		updatedData, err := db.UpdateDataTx(ctx, storage.UpdateDataTxParams{
+			UpdateDataParams: storage.UpdateDataParams{
+				Id:         data.Id,
+				ActivityId: activity.Id,
+
+				Values:        values,
+				UniqueKeys:    activity.UniqueKeys(values),
+				SchemaVersion: activity.Version,
+				Replace:       true,
+			},
+			History: storage.AddDataHistoryParams{
+				Action:       models.DataHistoryRestored,
+				Before:       data.Values,
+				RestoredFrom: &entry.Id,
+				Author:       dataAuthor(authUser),
+			},
+		})
+		if errors.Is(err, storage.ErrDataNotUnique) {
+			http.Error(w, "ERR_DATA_HIST_RST_NOT_UNIQUE", http.StatusBadRequest)
+			return
+		}
+		if err != nil || updatedData == nil {
+			http.Error(w, "ERR_DATA_HIST_RST_04", http.StatusBadRequest)
+			return
+		}
+
+		response := UpdateDataResponse{
+			Data: *updatedData,
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			http.Error(w, "ERR_DATA_HIST_RST_END", http.StatusBadRequest)
+			return
+		}
+	})
+}
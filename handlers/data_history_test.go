@@ -0,0 +1,323 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"stockinos.com/api/handlers"
+	"stockinos.com/api/helpertest"
+	"stockinos.com/api/models"
+	"stockinos.com/api/storage"
+)
+
+type mockGetDataHistoryDB struct {
+	GetDataHistoryFunc func(ctx context.Context, arg storage.GetDataHistoryParams) ([]*models.DataHistory, error)
+}
+
+func (mdb *mockGetDataHistoryDB) GetDataHistory(ctx context.Context, arg storage.GetDataHistoryParams) ([]*models.DataHistory, error) {
+	return mdb.GetDataHistoryFunc(ctx, arg)
+}
+
+type mockRestoreDataVersionDB struct {
+	GetDataHistoryEntryFunc   func(ctx context.Context, arg storage.GetDataHistoryEntryParams) (*models.DataHistory, error)
+	GetDataFilterByValuesFunc func(ctx context.Context, arg storage.GetDataFilterByValuesParams) (*models.Data, error)
+	UpdateDataTxFunc          func(ctx context.Context, arg storage.UpdateDataTxParams) (*models.Data, error)
+}
+
+// GetActivity is only used by the formulas, the activities of the tests have none
+func (mdb *mockRestoreDataVersionDB) GetActivity(ctx context.Context, arg storage.GetActivityParams) (*models.Activity, error) {
+	return nil, nil
+}
+
+func (mdb *mockRestoreDataVersionDB) GetDataHistoryEntry(ctx context.Context, arg storage.GetDataHistoryEntryParams) (*models.DataHistory, error) {
+	return mdb.GetDataHistoryEntryFunc(ctx, arg)
+}
+
+// GetDataFilterByValues finds no other data with the same values, unless told otherwise
+func (mdb *mockRestoreDataVersionDB) GetDataFilterByValues(ctx context.Context, arg storage.GetDataFilterByValuesParams) (*models.Data, error) {
+	if mdb.GetDataFilterByValuesFunc == nil {
+		return nil, nil
+	}
+	return mdb.GetDataFilterByValuesFunc(ctx, arg)
+}
+
+func (mdb *mockRestoreDataVersionDB) UpdateDataTx(ctx context.Context, arg storage.UpdateDataTxParams) (*models.Data, error) {
+	return mdb.UpdateDataTxFunc(ctx, arg)
+}
+
+// newHistoryData returns an activity identified by its reference, with a number field,
+// and one of its data
+func newHistoryData() (*models.Activity, *models.Data) {
+	activity := &models.Activity{
+		Id:      primitive.NewObjectID(),
+		Name:    "Orders",
+		Version: 2,
+		Fields: []models.ActivityField{
+			{Id: primitive.NewObjectID(), Name: "Reference", Type: models.FieldTypeText, PrimaryKey: true},
+			{Id: primitive.NewObjectID(), Name: "Amount", Type: models.FieldTypeNumber},
+		},
+	}
+	data := &models.Data{
+		Id:         primitive.NewObjectID(),
+		ActivityId: activity.Id,
+		Values: map[string]any{
+			activity.Fields[0].Id.Hex(): "D-2",
+			activity.Fields[1].Id.Hex(): 20.0,
+		},
+	}
+	return activity, data
+}
+
+func TestGetDataHistory(t *testing.T) {
+	handler := handlers.NewAppHandler()
+	activity, data := newHistoryData()
+	ctxData := []helpertest.ContextData{
+		{Name: "activity", Value: activity},
+		{Name: "data", Value: data},
+	}
+
+	t.Run("error from db", func(t *testing.T) {
+		mux := chi.NewMux()
+		handler.GetDataHistory(mux, &mockGetDataHistoryDB{
+			GetDataHistoryFunc: func(ctx context.Context, arg storage.GetDataHistoryParams) ([]*models.DataHistory, error) {
+				return nil, errors.New("an error happens")
+			},
+		})
+		_, w, response := helpertest.MakeGetRequest(mux, "/history", ctxData)
+		if w.StatusCode != http.StatusBadRequest {
+			t.Fatalf("GetDataHistory(): status - got %d; want %d", w.StatusCode, http.StatusBadRequest)
+		}
+		wantError := "ERR_DATA_HIST_01"
+		if response != wantError {
+			t.Fatalf("GetDataHistory(): response error - got %s; want %s", response, wantError)
+		}
+	})
+
+	t.Run("history of the data", func(t *testing.T) {
+		history := []*models.DataHistory{
+			{Id: primitive.NewObjectID(), DataId: data.Id, ActivityId: activity.Id, Action: models.DataHistoryUpdated},
+			{Id: primitive.NewObjectID(), DataId: data.Id, ActivityId: activity.Id, Action: models.DataHistoryCreated},
+		}
+
+		mux := chi.NewMux()
+		handler.GetDataHistory(mux, &mockGetDataHistoryDB{
+			GetDataHistoryFunc: func(ctx context.Context, arg storage.GetDataHistoryParams) ([]*models.DataHistory, error) {
+				if arg.DataId != data.Id || arg.ActivityId != activity.Id {
+					t.Errorf("GetDataHistory(): got %+v; want the history of the data %s", arg, data.Id.Hex())
+				}
+				return history, nil
+			},
+		})
+		_, w, response := helpertest.MakeGetRequest(mux, "/history", ctxData)
+		if w.StatusCode != http.StatusOK {
+			t.Fatalf("GetDataHistory(): status - got %d; want %d (%s)", w.StatusCode, http.StatusOK, response)
+		}
+
+		got := handlers.GetDataHistoryResponse{}
+		json.Unmarshal([]byte(response), &got)
+		if len(got.History) != len(history) {
+			t.Fatalf("GetDataHistory(): got %d entries; want %d", len(got.History), len(history))
+		}
+		for i, entry := range got.History {
+			if entry.Id != history[i].Id || entry.Action != history[i].Action {
+				t.Fatalf("GetDataHistory(): entry %d - got %+v; want %+v", i, entry, history[i])
+			}
+		}
+	})
+}
+
+func TestRestoreDataVersion(t *testing.T) {
+	handler := handlers.NewAppHandler()
+	handler.GetAuthenticatedUser = func(req *http.Request) *models.User {
+		return authenticatedUser
+	}
+
+	restoreDataVersion := func(activity *models.Activity, data *models.Data, historyId string, db *mockRestoreDataVersionDB) (int, string) {
+		mux := chi.NewMux()
+		handler.RestoreDataVersion(mux, db)
+		code, _, response := helpertest.MakePostRequest(
+			mux,
+			"/history/"+historyId+"/restore",
+			helpertest.CreateFormHeader(),
+			nil,
+			[]helpertest.ContextData{
+				{Name: "activity", Value: activity},
+				{Name: "data", Value: data},
+			},
+		)
+		return code, response
+	}
+
+	// newEntry returns an entry of the history of the data, with the given values
+	newEntry := func(data *models.Data, action string, values map[string]any) *models.DataHistory {
+		return &models.DataHistory{
+			Id:         primitive.NewObjectID(),
+			DataId:     data.Id,
+			ActivityId: data.ActivityId,
+			Action:     action,
+			Values:     values,
+			CreatedAt:  time.Now().Add(-time.Hour),
+		}
+	}
+
+	t.Run("rejected restore", func(t *testing.T) {
+		activity, data := newHistoryData()
+		reference, amount := activity.Fields[0].Id.Hex(), activity.Fields[1].Id.Hex()
+
+		testCases := map[string]struct {
+			HistoryId             string
+			Entry                 *models.DataHistory
+			EntryErr              error
+			GetDataFilterByValues func(ctx context.Context, arg storage.GetDataFilterByValuesParams) (*models.Data, error)
+			UpdateErr             error
+			Status                int
+			ResponseError         string
+		}{
+			"invalid history id": {
+				HistoryId:     "not-an-id",
+				Status:        http.StatusBadRequest,
+				ResponseError: "ERR_DATA_HIST_RST_01",
+			},
+			"error when reading the entry": {
+				EntryErr:      errors.New("an error happens"),
+				Status:        http.StatusBadRequest,
+				ResponseError: "ERR_DATA_HIST_RST_02",
+			},
+			"unknown entry": {
+				Status:        http.StatusNotFound,
+				ResponseError: "ERR_DATA_HIST_RST_03",
+			},
+			"deleted version": {
+				Entry:         newEntry(data, models.DataHistoryDeleted, map[string]any{reference: "D-1"}),
+				Status:        http.StatusBadRequest,
+				ResponseError: "ERR_DATA_HIST_RST_DELETED",
+			},
+			"values no longer valid": {
+				Entry:         newEntry(data, models.DataHistoryUpdated, map[string]any{reference: "D-1", amount: "ten"}),
+				Status:        http.StatusBadRequest,
+				ResponseError: "ERR_DATA_HIST_RST_INVALID_VALUES",
+			},
+			"primary key used by another data": {
+				Entry: newEntry(data, models.DataHistoryUpdated, map[string]any{reference: "D-1"}),
+				GetDataFilterByValues: func(ctx context.Context, arg storage.GetDataFilterByValuesParams) (*models.Data, error) {
+					if arg.ExcludeId != data.Id {
+						t.Errorf("GetDataFilterByValues(): exclude id - got %s; want %s", arg.ExcludeId.Hex(), data.Id.Hex())
+					}
+					return &models.Data{Id: primitive.NewObjectID(), ActivityId: activity.Id}, nil
+				},
+				Status:        http.StatusBadRequest,
+				ResponseError: "ERR_DATA_HIST_RST_PRKEY_ALREADY_USED",
+			},
+			"primary key used meanwhile": {
+				Entry:         newEntry(data, models.DataHistoryUpdated, map[string]any{reference: "D-1"}),
+				UpdateErr:     storage.ErrDataNotUnique,
+				Status:        http.StatusBadRequest,
+				ResponseError: "ERR_DATA_HIST_RST_NOT_UNIQUE",
+			},
+			"error when updating": {
+				Entry:         newEntry(data, models.DataHistoryUpdated, map[string]any{reference: "D-1"}),
+				UpdateErr:     errors.New("an error happens"),
+				Status:        http.StatusBadRequest,
+				ResponseError: "ERR_DATA_HIST_RST_04",
+			},
+		}
+
+		for name, tc := range testCases {
+			t.Run(name, func(t *testing.T) {
+				historyId := tc.HistoryId
+				if historyId == "" {
+					historyId = primitive.NewObjectID().Hex()
+					if tc.Entry != nil {
+						historyId = tc.Entry.Id.Hex()
+					}
+				}
+
+				db := &mockRestoreDataVersionDB{
+					GetDataHistoryEntryFunc: func(ctx context.Context, arg storage.GetDataHistoryEntryParams) (*models.DataHistory, error) {
+						return tc.Entry, tc.EntryErr
+					},
+					GetDataFilterByValuesFunc: tc.GetDataFilterByValues,
+					UpdateDataTxFunc: func(ctx context.Context, arg storage.UpdateDataTxParams) (*models.Data, error) {
+						if tc.UpdateErr == nil {
+							t.Errorf("RestoreDataVersion(): the data is updated; want the restore rejected")
+						}
+						return nil, tc.UpdateErr
+					},
+				}
+
+				code, response := restoreDataVersion(activity, data, historyId, db)
+				if code != tc.Status {
+					t.Fatalf("RestoreDataVersion(): status - got %d; want %d (%s)", code, tc.Status, response)
+				}
+				if response != tc.ResponseError {
+					got := handlers.DataValidationErrorResponse{}
+					json.Unmarshal([]byte(response), &got)
+					if got.Error != tc.ResponseError {
+						t.Fatalf("RestoreDataVersion(): response error - got %s; want %s", response, tc.ResponseError)
+					}
+				}
+			})
+		}
+	})
+
+	t.Run("restored version", func(t *testing.T) {
+		activity, data := newHistoryData()
+		reference, amount := activity.Fields[0].Id.Hex(), activity.Fields[1].Id.Hex()
+		// the value of a field removed since the version is dropped
+		removedField := primitive.NewObjectID().Hex()
+		entry := newEntry(data, models.DataHistoryUpdated, map[string]any{
+			reference:    "D-1",
+			amount:       10.0,
+			removedField: "old",
+		})
+
+		var updated storage.UpdateDataTxParams
+		db := &mockRestoreDataVersionDB{
+			GetDataHistoryEntryFunc: func(ctx context.Context, arg storage.GetDataHistoryEntryParams) (*models.DataHistory, error) {
+				if arg.Id != entry.Id || arg.DataId != data.Id || arg.ActivityId != activity.Id {
+					return nil, nil
+				}
+				return entry, nil
+			},
+			UpdateDataTxFunc: func(ctx context.Context, arg storage.UpdateDataTxParams) (*models.Data, error) {
+				updated = arg
+				return &models.Data{Id: arg.Id, ActivityId: arg.ActivityId, Values: arg.Values}, nil
+			},
+		}
+
+		code, response := restoreDataVersion(activity, data, entry.Id.Hex(), db)
+		if code != http.StatusOK {
+			t.Fatalf("RestoreDataVersion(): status - got %d; want %d (%s)", code, http.StatusOK, response)
+		}
+
+		if updated.Id != data.Id || !updated.Replace || updated.SchemaVersion != activity.Version {
+			t.Fatalf("UpdateDataTx(): got %+v; want the values of the data %s replaced", updated.UpdateDataParams, data.Id.Hex())
+		}
+		if len(updated.Values) != 2 || updated.Values[reference] != "D-1" || updated.Values[amount] != 10.0 {
+			t.Fatalf("UpdateDataTx(): values - got %v; want the values of the version without the removed field", updated.Values)
+		}
+		if len(updated.UniqueKeys) != 1 {
+			t.Fatalf("UpdateDataTx(): unique keys - got %v; want the key of the reference", updated.UniqueKeys)
+		}
+
+		history := updated.History
+		if history.Action != models.DataHistoryRestored || history.RestoredFrom == nil || *history.RestoredFrom != entry.Id {
+			t.Fatalf("UpdateDataTx(): history - got %+v; want restored from %s", history, entry.Id.Hex())
+		}
+		if history.Author.Id != authenticatedUser.Id || history.Before[reference] != "D-2" {
+			t.Fatalf("UpdateDataTx(): history - got %+v; want the values before written by the user", history)
+		}
+
+		got := handlers.UpdateDataResponse{}
+		json.Unmarshal([]byte(response), &got)
+		if got.Data.Id != data.Id || got.Data.Values[reference] != "D-1" {
+			t.Fatalf("RestoreDataVersion(): got %+v; want the restored data", got.Data)
+		}
+	})
+}
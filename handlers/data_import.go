@@ -0,0 +1,503 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/xuri/excelize/v2"
+	"stockinos.com/api/models"
+	"stockinos.com/api/storage"
+)
+
+const (
+	maxImportFileSize = 20 << 20 // 20 MB
+	// The rows are created in a single transaction, which must stay well within the limits
+	// of MongoDB on its size and its duration: a bigger file must be split
+	maxImportRows   = 1000
+	importBatchSize = 500
+
+	// An import can take much longer than the usual requests
+	importDataTimeout = 5 * time.Minute
+)
+
+type importDataInterface interface {
+	GetActivity(ctx context.Context, arg storage.GetActivityParams) (*models.Activity, error)
+	GetDataFilterByValues(ctx context.Context, arg storage.GetDataFilterByValuesParams) (*models.Data, error)
+	GetAllData(ctx context.Context, arg storage.GetAllDataParams) ([]*models.Data, error)
+	CreateManyDataTx(ctx context.Context, arg storage.CreateManyDataTxParams) (int, error)
+}
+
+type ImportRowError struct {
+	Row    int                 `json:"row"`
+	Errors []models.FieldError `json:"errors"`
+}
+
+type ImportDataResponse struct {
+	DryRun         bool              `json:"dry_run"`
+	Columns        map[string]string `json:"columns"` // column name -> field id
+	IgnoredColumns []string          `json:"ignored_columns"`
+	TotalRows      int               `json:"total_rows"`
+	ValidRows      int               `json:"valid_rows"`
+	ImportedRows   int               `json:"imported_rows"`
+	Errors         []ImportRowError  `json:"errors"`
+}
+
+type importColumn struct {
+	index int
+	field models.ActivityField
+}
+
+type importRow struct {
+	line   int
+	values map[string]any
+	errors []models.FieldError
+}
+
+// ImportData creates the data of an activity from a CSV or a XLSX file sent as "uploaded-file".
+// The first row holds the name, the code or the id of the fields; unknown columns are ignored.
+// With dry_run=true nothing is created, the response only reports the errors of each row.
+// Otherwise the data are created in a single transaction, only if no row has an error.
+// A file has at most maxImportRows rows, without the first one.
+func (appHandler *AppHandler) ImportData(mux chi.Router, db importDataInterface) {
+	mux.Post("/import", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authUser := appHandler.GetAuthenticatedUser(r)
+		activity := ctx.Value("activity").(*models.Activity)
+
+		dryRun := false
+		if value := r.URL.Query().Get("dry_run"); value != "" {
+			parsed, err := strconv.ParseBool(value)
+			if err != nil {
+				http.Error(w, "ERR_DATA_IMP_DRY_RUN", http.StatusBadRequest)
+				return
+			}
+			dryRun = parsed
+		}
+
+		rc := http.NewResponseController(w)
+		if err := rc.SetWriteDeadline(time.Now().Add(importDataTimeout)); err != nil {
+			log.Println("error when extending the write deadline of the import: ", err)
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxImportFileSize+(1<<20))
+		if err := r.ParseMultipartForm(maxImportFileSize); err != nil {
+			http.Error(w, "ERR_DATA_IMP_FILE", http.StatusBadRequest)
+			return
+		}
+
+		file, handler, err := r.FormFile("uploaded-file")
+		if err != nil {
+			http.Error(w, "ERR_DATA_IMP_FILE", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+
+		records, err := readImportRecords(file, handler.Filename)
+		if err != nil {
+			log.Println("error when reading the imported file: ", err)
+			http.Error(w, "ERR_DATA_IMP_READ", http.StatusBadRequest)
+			return
+		}
+		if len(records) < 2 {
+			http.Error(w, "ERR_DATA_IMP_EMPTY", http.StatusBadRequest)
+			return
+		}
+		if len(records)-1 > maxImportRows {
+			http.Error(w, "ERR_DATA_IMP_TOO_MANY_ROWS", http.StatusBadRequest)
+			return
+		}
+
+		columns, mappedColumns, ignoredColumns := mapImportColumns(activity, records[0])
+		if len(columns) == 0 {
+			http.Error(w, "ERR_DATA_IMP_NO_COLUMN", http.StatusBadRequest)
+			return
+		}
+
+		keyValues, err := getKeyImportValues(ctx, activity, db)
+		if err != nil {
+			http.Error(w, "ERR_DATA_IMP_KEYS", http.StatusBadRequest)
+			return
+		}
+
+		dataFormulas, err := loadDataFormulas(ctx, activity, db)
+		if err != nil {
+			http.Error(w, "ERR_DATA_IMP_FORMULA", http.StatusBadRequest)
+			return
+		}
+
+		now := time.Now()
+		rows := make([]*importRow, 0, len(records)-1)
+		for i, record := range records[1:] {
+			if isEmptyRecord(record) {
+				continue
+			}
+
+			row := &importRow{
+				// Rows are numbered as in a spreadsheet: the header is the row 1
+				line: i + 2,
+			}
+
+			rawValues := make(map[string]any)
+			for _, column := range mappedColumns {
+				field := column.field
+				if column.index >= len(record) || dataFormulas.computes(field.Id.Hex()) {
+					continue
+				}
+				cell := strings.TrimSpace(record[column.index])
+				if cell == "" {
+					continue
+				}
+
+				value, fieldErr := importCellValue(field, cell, keyValues[field.Id.Hex()])
+				if fieldErr != nil {
+					row.errors = append(row.errors, *fieldErr)
+					continue
+				}
+				rawValues[field.Id.Hex()] = value
+			}
+
+			values, fieldErrors := activity.ValidateValues(rawValues)
+			if len(fieldErrors) == 0 {
+				_, fieldErrors, err = completeDataValues(ctx, activity, values, dataFormulas)
+				// the formulas failing for the row are reported with its other errors
+				if err != nil {
+					fieldErrors = []models.FieldError{formulaFieldError(err)}
+				}
+				if len(fieldErrors) == 0 {
+					fieldErrors = activity.CheckRules(values, now)
+				}
+			}
+			row.values = values
+			row.errors = append(row.errors, fieldErrors...)
+
+			rows = append(rows, row)
+		}
+
+		if err := checkImportUniqueness(ctx, activity, rows, db); err != nil {
+			http.Error(w, "ERR_DATA_IMP_UNIQUENESS", http.StatusBadRequest)
+			return
+		}
+
+		response := ImportDataResponse{
+			DryRun:         dryRun,
+			Columns:        columns,
+			IgnoredColumns: ignoredColumns,
+			TotalRows:      len(rows),
+			Errors:         []ImportRowError{},
+		}
+		for _, row := range rows {
+			if len(row.errors) > 0 {
+				response.Errors = append(response.Errors, ImportRowError{
+					Row:    row.line,
+					Errors: row.errors,
+				})
+			}
+		}
+		response.ValidRows = response.TotalRows - len(response.Errors)
+
+		status := http.StatusOK
+		switch {
+		case dryRun:
+		case len(response.Errors) > 0:
+			// All or nothing: a partial import is harder to fix than a rejected file
+			status = http.StatusBadRequest
+		default:
+			values := make([]map[string]any, len(rows))
+			uniqueKeys := make([][]string, len(rows))
+			for i, row := range rows {
+				values[i] = row.values
+				uniqueKeys[i] = activity.UniqueKeys(row.values)
+			}
+
+			created, err := db.CreateManyDataTx(ctx, storage.CreateManyDataTxParams{
+				Values:        values,
+				UniqueKeys:    uniqueKeys,
+				SchemaVersion: activity.Version,
+				ActivityId:    activity.Id,
+				CreatedBy:     dataAuthor(authUser),
+				BatchSize:     importBatchSize,
+			})
+			if errors.Is(err, storage.ErrDataNotUnique) {
+				http.Error(w, "ERR_DATA_IMP_NOT_UNIQUE", http.StatusBadRequest)
+				return
+			}
+			if err != nil {
+				http.Error(w, "ERR_DATA_IMP_01", http.StatusBadRequest)
+				return
+			}
+			response.ImportedRows = created
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			http.Error(w, "ERR_DATA_IMP_END", http.StatusBadRequest)
+			return
+		}
+	})
+}
+
+// readImportRecords reads all the rows of a XLSX file (first sheet) or of a CSV file,
+// whose separator can be a comma or a semicolon.
+func readImportRecords(file io.Reader, filename string) ([][]string, error) {
+	if strings.EqualFold(filepath.Ext(filename), ".xlsx") {
+		workbook, err := excelize.OpenReader(file)
+		if err != nil {
+			return nil, err
+		}
+		defer workbook.Close()
+
+		sheets := workbook.GetSheetList()
+		if len(sheets) == 0 {
+			return [][]string{}, nil
+		}
+		return workbook.GetRows(sheets[0])
+	}
+
+	content, err := io.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+	// Excel writes a BOM at the beginning of the UTF-8 files
+	content = bytes.TrimPrefix(content, []byte("\xef\xbb\xbf"))
+
+	reader := csv.NewReader(bytes.NewReader(content))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	firstLine, _ := bufio.NewReader(bytes.NewReader(content)).ReadString('\n')
+	if strings.Count(firstLine, ";") > strings.Count(firstLine, ",") {
+		reader.Comma = ';'
+	}
+
+	return reader.ReadAll()
+}
+
+// mapImportColumns finds the field of each column of the header, by name, code or id
+func mapImportColumns(activity *models.Activity, header []string) (map[string]string, []importColumn, []string) {
+	columns := make(map[string]string)
+	mappedColumns := make([]importColumn, 0, len(header))
+	ignoredColumns := make([]string, 0)
+	used := make(map[string]bool)
+
+	for i, column := range header {
+		name := strings.TrimSpace(column)
+		if name == "" {
+			continue
+		}
+
+		found := false
+		for _, field := range activity.Fields {
+			if !strings.EqualFold(name, strings.TrimSpace(field.Name)) &&
+				!(field.Code != "" && strings.EqualFold(name, field.Code)) &&
+				name != field.Id.Hex() {
+				continue
+			}
+
+			// The same field can only be filled by one column
+			if !used[field.Id.Hex()] {
+				used[field.Id.Hex()] = true
+				columns[name] = field.Id.Hex()
+				mappedColumns = append(mappedColumns, importColumn{index: i, field: field})
+				found = true
+			}
+			break
+		}
+		if !found {
+			ignoredColumns = append(ignoredColumns, name)
+		}
+	}
+
+	return columns, mappedColumns, ignoredColumns
+}
+
+// getKeyImportValues returns, for each key field of the activity, the key value matching
+// a text of a cell. A cell can hold the key itself or the value displayed for it.
+func getKeyImportValues(ctx context.Context, activity *models.Activity, db importDataInterface) (map[string]map[string]any, error) {
+	keyValues := make(map[string]map[string]any)
+
+	for _, field := range activity.Fields {
+		details := field.Details.ActivityFieldKey
+		if field.Type != models.FieldTypeKey || details == nil || details.ActivityId.IsZero() {
+			continue
+		}
+
+		fieldToUseId := details.FieldToUseId
+		if fieldToUseId.IsZero() {
+			fieldToUseId = details.FieldId
+		}
+
+		referencedData, err := db.GetAllData(ctx, storage.GetAllDataParams{
+			ActivityId: details.ActivityId,
+			Projections: map[string]int{
+				fmt.Sprintf("values.%s", details.FieldId.Hex()): 1,
+				fmt.Sprintf("values.%s", fieldToUseId.Hex()):    1,
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		values := make(map[string]any, 2*len(referencedData))
+		for _, data := range referencedData {
+			key := data.Values[details.FieldId.Hex()]
+			if key == nil {
+				continue
+			}
+			if displayValue := data.Values[fieldToUseId.Hex()]; displayValue != nil {
+				if _, ok := values[exportText(displayValue)]; !ok {
+					values[exportText(displayValue)] = key
+				}
+			}
+		}
+		// A key always wins over a displayed value
+		for _, data := range referencedData {
+			if key := data.Values[details.FieldId.Hex()]; key != nil {
+				values[exportText(key)] = key
+			}
+		}
+		keyValues[field.Id.Hex()] = values
+	}
+
+	return keyValues, nil
+}
+
+// importCellValue converts the text of a cell into a value that can be cast to the field.
+// Lists are written as in the exports: files one per line, other items separated by commas.
+func importCellValue(field models.ActivityField, cell string, keyValues map[string]any) (any, *models.FieldError) {
+	switch field.Type {
+	case models.FieldTypeKey:
+		if keyValues == nil {
+			return cell, nil
+		}
+		if value, ok := keyValues[cell]; ok {
+			return value, nil
+		}
+		return nil, &models.FieldError{
+			FieldId: field.Id.Hex(),
+			Code:    "ERR_FIELD_KEY_NOT_FOUND",
+			Message: fmt.Sprintf("no data found with the key %s", cell),
+		}
+	case models.FieldTypeUpload:
+		return splitImportCell(cell, "\n,"), nil
+	case models.FieldTypeMultipleChoices:
+		if details := field.Details.ActivityFieldMultipleChoices; details != nil && details.Multiple {
+			return splitImportCell(cell, ","), nil
+		}
+	case models.FieldTypeGeolocation:
+		// a location is written "latitude, longitude"
+		if field.Options.Multiple {
+			return splitImportCell(cell, "\n;"), nil
+		}
+		return cell, nil
+	}
+
+	if field.Options.Multiple {
+		return splitImportCell(cell, ","), nil
+	}
+	return cell, nil
+}
+
+func splitImportCell(cell string, separators string) []any {
+	parts := strings.FieldsFunc(cell, func(r rune) bool {
+		return strings.ContainsRune(separators, r)
+	})
+
+	items := make([]any, 0, len(parts))
+	for _, part := range parts {
+		if part = strings.TrimSpace(part); part != "" {
+			items = append(items, part)
+		}
+	}
+	return items
+}
+
+func isEmptyRecord(record []string) bool {
+	for _, cell := range record {
+		if strings.TrimSpace(cell) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// checkImportUniqueness does the same checks as the creation of a data: the primary key
+// is required and the unique values must not be used yet, neither by another data
+// nor by another row of the file.
+func checkImportUniqueness(ctx context.Context, activity *models.Activity, rows []*importRow, db importDataInterface) error {
+	for _, row := range rows {
+		row.errors = append(row.errors, activity.ValidatePrimaryKey(row.values)...)
+	}
+
+	for _, constraint := range activity.UniqueConstraints() {
+		rowsByKey := make(map[string]*importRow)
+		conditions := make([]any, 0)
+
+		for _, row := range rows {
+			key, ok := activity.UniqueKey(constraint, row.values)
+			if !ok {
+				continue
+			}
+
+			if other, ok := rowsByKey[key]; ok {
+				for _, fieldError := range constraint.UniqueErrors() {
+					fieldError.Message = fmt.Sprintf("the same value is used at the row %d", other.line)
+					row.errors = append(row.errors, fieldError)
+				}
+				continue
+			}
+			rowsByKey[key] = row
+
+			condition := make(map[string]any, len(constraint.FieldIds))
+			for _, fieldId := range constraint.FieldIds {
+				condition[fmt.Sprintf("values.%s", fieldId)] = row.values[fieldId]
+			}
+			conditions = append(conditions, condition)
+		}
+
+		projections := make(map[string]int, len(constraint.FieldIds))
+		for _, fieldId := range constraint.FieldIds {
+			projections[fmt.Sprintf("values.%s", fieldId)] = 1
+		}
+
+		for start := 0; start < len(conditions); start += importBatchSize {
+			end := start + importBatchSize
+			if end > len(conditions) {
+				end = len(conditions)
+			}
+
+			existingData, err := db.GetAllData(ctx, storage.GetAllDataParams{
+				ActivityId:  activity.Id,
+				Projections: projections,
+				FilterBy: map[string]any{
+					"$or": conditions[start:end],
+				},
+			})
+			if err != nil {
+				return err
+			}
+
+			for _, data := range existingData {
+				key, _ := activity.UniqueKey(constraint, data.Values)
+				if row, ok := rowsByKey[key]; ok {
+					row.errors = append(row.errors, constraint.UniqueErrors()...)
+				}
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,376 @@
+package handlers_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"stockinos.com/api/handlers"
+	"stockinos.com/api/models"
+	"stockinos.com/api/storage"
+)
+
+type mockImportDataDB struct {
+	GetAllDataFunc            func(ctx context.Context, arg storage.GetAllDataParams) ([]*models.Data, error)
+	CreateManyDataTxFunc      func(ctx context.Context, arg storage.CreateManyDataTxParams) (int, error)
+	GetActivityFunc           func(ctx context.Context, arg storage.GetActivityParams) (*models.Activity, error)
+	GetDataFilterByValuesFunc func(ctx context.Context, arg storage.GetDataFilterByValuesParams) (*models.Data, error)
+}
+
+func (mdb *mockImportDataDB) GetAllData(ctx context.Context, arg storage.GetAllDataParams) ([]*models.Data, error) {
+	return mdb.GetAllDataFunc(ctx, arg)
+}
+
+func (mdb *mockImportDataDB) CreateManyDataTx(ctx context.Context, arg storage.CreateManyDataTxParams) (int, error) {
+	return mdb.CreateManyDataTxFunc(ctx, arg)
+}
+
+// GetActivity is only used by the formulas, most activities of the tests have none
+func (mdb *mockImportDataDB) GetActivity(ctx context.Context, arg storage.GetActivityParams) (*models.Activity, error) {
+	if mdb.GetActivityFunc == nil {
+		return nil, nil
+	}
+	return mdb.GetActivityFunc(ctx, arg)
+}
+
+// GetDataFilterByValues is only used by the formulas, most activities of the tests have none
+func (mdb *mockImportDataDB) GetDataFilterByValues(ctx context.Context, arg storage.GetDataFilterByValuesParams) (*models.Data, error) {
+	if mdb.GetDataFilterByValuesFunc == nil {
+		return nil, nil
+	}
+	return mdb.GetDataFilterByValuesFunc(ctx, arg)
+}
+
+// makeImportRequest sends the content as the file "uploaded-file" of a multipart form
+func makeImportRequest(handler http.Handler, target string, filename string, content string, activity *models.Activity) (int, string) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	if filename != "" {
+		part, _ := writer.CreateFormFile("uploaded-file", filename)
+		io.WriteString(part, content)
+	}
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, target, body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req = req.WithContext(context.WithValue(req.Context(), "activity", activity))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	return w.Code, strings.TrimSpace(w.Body.String())
+}
+
+// newImportActivity returns an activity identified by its reference, with a number and
+// a key field, and the data referenced by the key field
+func newImportActivity() (*models.Activity, []*models.Data) {
+	clientCode := primitive.NewObjectID()
+	clientName := primitive.NewObjectID()
+	activity := &models.Activity{
+		Id:      primitive.NewObjectID(),
+		Name:    "Orders",
+		Version: 3,
+		Fields: []models.ActivityField{
+			{Id: primitive.NewObjectID(), Name: "Reference", Type: models.FieldTypeText, PrimaryKey: true},
+			{Id: primitive.NewObjectID(), Name: "Amount", Code: "amount", Type: models.FieldTypeNumber},
+			{
+				Id:   primitive.NewObjectID(),
+				Name: "Client",
+				Type: models.FieldTypeKey,
+				Details: models.ActivityFieldType{ActivityFieldKey: &models.ActivityFieldKey{
+					ActivityId:   primitive.NewObjectID(),
+					FieldId:      clientCode,
+					FieldToUseId: clientName,
+				}},
+			},
+		},
+	}
+	clients := []*models.Data{
+		{Id: primitive.NewObjectID(), Values: map[string]any{
+			clientCode.Hex(): "C1",
+			clientName.Hex(): "Acme",
+		}},
+	}
+	return activity, clients
+}
+
+func TestImportData(t *testing.T) {
+	handler := handlers.NewAppHandler()
+	handler.GetAuthenticatedUser = func(req *http.Request) *models.User {
+		return authenticatedUser
+	}
+
+	// newDB returns the clients for the key field and the existing data for the uniqueness
+	newDB := func(t *testing.T, activity *models.Activity, clients []*models.Data, existing []*models.Data) *mockImportDataDB {
+		return &mockImportDataDB{
+			GetAllDataFunc: func(ctx context.Context, arg storage.GetAllDataParams) ([]*models.Data, error) {
+				if arg.ActivityId == activity.Id {
+					return existing, nil
+				}
+				return clients, nil
+			},
+			CreateManyDataTxFunc: func(ctx context.Context, arg storage.CreateManyDataTxParams) (int, error) {
+				t.Errorf("ImportData(): the data are created; want the import rejected")
+				return 0, nil
+			},
+		}
+	}
+
+	t.Run("rejected file", func(t *testing.T) {
+		activity, clients := newImportActivity()
+		tooManyRows := "Reference\n" + strings.Repeat("D\n", 1001)
+
+		testCases := map[string]struct {
+			Target        string
+			Filename      string
+			Content       string
+			ResponseError string
+		}{
+			"wrong dry_run": {
+				Target:        "/import?dry_run=maybe",
+				Filename:      "orders.csv",
+				Content:       "Reference\nD-1\n",
+				ResponseError: "ERR_DATA_IMP_DRY_RUN",
+			},
+			"no file": {
+				Target:        "/import",
+				ResponseError: "ERR_DATA_IMP_FILE",
+			},
+			"unreadable xlsx": {
+				Target:        "/import",
+				Filename:      "orders.xlsx",
+				Content:       "Reference\nD-1\n",
+				ResponseError: "ERR_DATA_IMP_READ",
+			},
+			"only a header": {
+				Target:        "/import",
+				Filename:      "orders.csv",
+				Content:       "Reference,Amount\n",
+				ResponseError: "ERR_DATA_IMP_EMPTY",
+			},
+			"too many rows": {
+				Target:        "/import",
+				Filename:      "orders.csv",
+				Content:       tooManyRows,
+				ResponseError: "ERR_DATA_IMP_TOO_MANY_ROWS",
+			},
+			"no known column": {
+				Target:        "/import",
+				Filename:      "orders.csv",
+				Content:       "Price,Quantity\n1,2\n",
+				ResponseError: "ERR_DATA_IMP_NO_COLUMN",
+			},
+		}
+
+		for name, tc := range testCases {
+			t.Run(name, func(t *testing.T) {
+				mux := chi.NewMux()
+				handler.ImportData(mux, newDB(t, activity, clients, nil))
+				code, response := makeImportRequest(mux, tc.Target, tc.Filename, tc.Content, activity)
+				if code != http.StatusBadRequest {
+					t.Fatalf("ImportData(): status - got %d; want %d", code, http.StatusBadRequest)
+				}
+				if response != tc.ResponseError {
+					t.Fatalf("ImportData(): response error - got %s; want %s", response, tc.ResponseError)
+				}
+			})
+		}
+	})
+
+	t.Run("error when reading the keys", func(t *testing.T) {
+		activity, _ := newImportActivity()
+		db := &mockImportDataDB{
+			GetAllDataFunc: func(ctx context.Context, arg storage.GetAllDataParams) ([]*models.Data, error) {
+				return nil, errors.New("an error happens")
+			},
+		}
+
+		mux := chi.NewMux()
+		handler.ImportData(mux, db)
+		code, response := makeImportRequest(mux, "/import", "orders.csv", "Reference\nD-1\n", activity)
+		if code != http.StatusBadRequest {
+			t.Fatalf("ImportData(): status - got %d; want %d", code, http.StatusBadRequest)
+		}
+		wantError := "ERR_DATA_IMP_KEYS"
+		if response != wantError {
+			t.Fatalf("ImportData(): response error - got %s; want %s", response, wantError)
+		}
+	})
+
+	t.Run("rows with errors", func(t *testing.T) {
+		activity, clients := newImportActivity()
+		reference, amount, client := activity.Fields[0].Id.Hex(), activity.Fields[1].Id.Hex(), activity.Fields[2].Id.Hex()
+		existing := []*models.Data{
+			{Id: primitive.NewObjectID(), Values: map[string]any{reference: "D-0"}},
+		}
+		content := strings.Join([]string{
+			"Reference,amount,Client,Comment",
+			"D-1,12.5,Acme,first",
+			"D-0,3,C1,already imported",
+			"D-2,ten,C1,",
+			"D-3,1,Unknown,",
+			",,,",
+			"D-1,4,,twice",
+			",5,C1,without reference",
+		}, "\n")
+		// the rows are numbered as in a spreadsheet, from the header
+		wantErrors := map[int]string{
+			3: reference,
+			4: amount,
+			5: client,
+			7: reference,
+			8: reference,
+		}
+
+		for _, dryRun := range []bool{true, false} {
+			target := "/import"
+			wantStatus := http.StatusBadRequest
+			if dryRun {
+				target = "/import?dry_run=true"
+				wantStatus = http.StatusOK
+			}
+
+			mux := chi.NewMux()
+			handler.ImportData(mux, newDB(t, activity, clients, existing))
+			code, response := makeImportRequest(mux, target, "orders.csv", content, activity)
+			if code != wantStatus {
+				t.Fatalf("ImportData(dry_run=%v): status - got %d; want %d (%s)", dryRun, code, wantStatus, response)
+			}
+
+			got := handlers.ImportDataResponse{}
+			if err := json.Unmarshal([]byte(response), &got); err != nil {
+				t.Fatalf("ImportData(dry_run=%v): invalid response %s", dryRun, response)
+			}
+			if got.DryRun != dryRun || got.TotalRows != 6 || got.ValidRows != 1 || got.ImportedRows != 0 {
+				t.Fatalf("ImportData(dry_run=%v): got %+v; want 1 valid row of 6 and nothing imported", dryRun, got)
+			}
+			if len(got.IgnoredColumns) != 1 || got.IgnoredColumns[0] != "Comment" {
+				t.Fatalf("ImportData(dry_run=%v): ignored columns - got %v; want [Comment]", dryRun, got.IgnoredColumns)
+			}
+			if len(got.Errors) != len(wantErrors) {
+				t.Fatalf("ImportData(dry_run=%v): errors - got %+v; want the rows %v", dryRun, got.Errors, wantErrors)
+			}
+			for _, rowError := range got.Errors {
+				fieldId, ok := wantErrors[rowError.Row]
+				if !ok || len(rowError.Errors) == 0 || rowError.Errors[0].FieldId != fieldId {
+					t.Fatalf("ImportData(dry_run=%v): errors of the row %d - got %+v; want an error on %s", dryRun, rowError.Row, rowError.Errors, fieldId)
+				}
+			}
+		}
+	})
+
+	t.Run("formula failing for a row", func(t *testing.T) {
+		activity, clients := newImportActivity()
+		key := activity.Fields[2]
+		key.Code = "client"
+		rate := primitive.NewObjectID()
+		clientActivity := &models.Activity{
+			Id: key.Details.ActivityFieldKey.ActivityId,
+			Fields: []models.ActivityField{
+				{Id: key.Details.ActivityFieldKey.FieldId, Code: "code", Type: models.FieldTypeText},
+				{Id: rate, Code: "rate", Type: models.FieldTypeNumber},
+			},
+		}
+		expression := "{amount} * {client.rate}"
+		price := models.ActivityField{
+			Id:      primitive.NewObjectID(),
+			Code:    "price",
+			Type:    models.FieldTypeNumber,
+			Options: models.ActivityFieldOptions{Automatic: true, Formula: &expression},
+		}
+		activity.Fields = []models.ActivityField{activity.Fields[0], activity.Fields[1], key, price}
+
+		db := newDB(t, activity, clients, nil)
+		db.GetActivityFunc = func(ctx context.Context, arg storage.GetActivityParams) (*models.Activity, error) {
+			return clientActivity, nil
+		}
+		db.GetDataFilterByValuesFunc = func(ctx context.Context, arg storage.GetDataFilterByValuesParams) (*models.Data, error) {
+			return nil, errors.New("an error happens")
+		}
+
+		mux := chi.NewMux()
+		handler.ImportData(mux, db)
+		content := "Reference,Amount,Client\nD-1,12.5,C1\nD-2,3,\n"
+		code, response := makeImportRequest(mux, "/import?dry_run=true", "orders.csv", content, activity)
+		if code != http.StatusOK {
+			t.Fatalf("ImportData(): status - got %d; want %d (%s)", code, http.StatusOK, response)
+		}
+
+		got := handlers.ImportDataResponse{}
+		json.Unmarshal([]byte(response), &got)
+		if got.TotalRows != 2 || got.ValidRows != 1 || len(got.Errors) != 1 || got.Errors[0].Row != 2 {
+			t.Fatalf("ImportData(): got %+v; want the row 2 refused and the row 3 valid", got)
+		}
+		if rowErrors := got.Errors[0].Errors; len(rowErrors) != 1 || rowErrors[0].Code != "ERR_FIELD_INVALID_FORMULA" {
+			t.Fatalf("ImportData(): errors of the row 2 - got %+v; want the formula failing", rowErrors)
+		}
+	})
+
+	t.Run("imported rows", func(t *testing.T) {
+		activity, clients := newImportActivity()
+		reference, amount, client := activity.Fields[0].Id.Hex(), activity.Fields[1].Id.Hex(), activity.Fields[2].Id.Hex()
+		// the separator of the file is found from its header
+		content := "\xef\xbb\xbfReference;Amount;" + client + "\nD-1;12.5;Acme\nD-2;;C1\n"
+
+		var created storage.CreateManyDataTxParams
+		db := newDB(t, activity, clients, nil)
+		db.CreateManyDataTxFunc = func(ctx context.Context, arg storage.CreateManyDataTxParams) (int, error) {
+			created = arg
+			return len(arg.Values), nil
+		}
+
+		mux := chi.NewMux()
+		handler.ImportData(mux, db)
+		code, response := makeImportRequest(mux, "/import", "orders.csv", content, activity)
+		if code != http.StatusOK {
+			t.Fatalf("ImportData(): status - got %d; want %d (%s)", code, http.StatusOK, response)
+		}
+
+		got := handlers.ImportDataResponse{}
+		json.Unmarshal([]byte(response), &got)
+		if got.ImportedRows != 2 || len(got.Errors) != 0 {
+			t.Fatalf("ImportData(): got %+v; want 2 imported rows", got)
+		}
+
+		if created.ActivityId != activity.Id || created.SchemaVersion != activity.Version || created.CreatedBy.Id != authenticatedUser.Id {
+			t.Fatalf("CreateManyDataTx(): got %+v; want the data of the activity created by the user", created)
+		}
+		if created.BatchSize <= 0 || len(created.Values) != 2 || len(created.UniqueKeys) != 2 {
+			t.Fatalf("CreateManyDataTx(): got %+v; want 2 data created in batches", created)
+		}
+		first, second := created.Values[0], created.Values[1]
+		if first[reference] != "D-1" || first[amount] != 12.5 || first[client] != "C1" {
+			t.Fatalf("CreateManyDataTx(): first values - got %v; want D-1, 12.5 and the key C1", first)
+		}
+		if second[reference] != "D-2" || second[amount] != nil || second[client] != "C1" {
+			t.Fatalf("CreateManyDataTx(): second values - got %v; want D-2 and the key C1", second)
+		}
+	})
+
+	t.Run("data created meanwhile", func(t *testing.T) {
+		activity, clients := newImportActivity()
+		db := newDB(t, activity, clients, nil)
+		db.CreateManyDataTxFunc = func(ctx context.Context, arg storage.CreateManyDataTxParams) (int, error) {
+			return 0, storage.ErrDataNotUnique
+		}
+
+		mux := chi.NewMux()
+		handler.ImportData(mux, db)
+		code, response := makeImportRequest(mux, "/import", "orders.csv", "Reference\nD-1\n", activity)
+		if code != http.StatusBadRequest {
+			t.Fatalf("ImportData(): status - got %d; want %d", code, http.StatusBadRequest)
+		}
+		wantError := "ERR_DATA_IMP_NOT_UNIQUE"
+		if response != wantError {
+			t.Fatalf("ImportData(): response error - got %s; want %s", response, wantError)
+		}
+	})
+}
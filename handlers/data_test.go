@@ -6,16 +6,19 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"reflect"
 	"strings"
 	"testing"
 
 	"github.com/go-chi/chi/v5"
 	gofaker "github.com/go-faker/faker/v4"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"stockinos.com/api/handlers"
 	"stockinos.com/api/helpertest"
 	"stockinos.com/api/models"
+	"stockinos.com/api/services"
 	"stockinos.com/api/storage"
 	sfaker "syreclabs.com/go/faker"
 )
@@ -30,9 +33,10 @@ func TestData(t *testing.T) {
 		"DataMiddleware": testDataMiddleware,
 		"GetAllData":     testGetAllData,
 		"CreateData":     testCreateData,
-		// "GetData":          testGetData,
-		// "UpdateData":       testUpdateData,
-		// "DeleteData":       testDeleteData,
+		"GetData":        testGetData,
+		"UpdateData":     testUpdateData,
+		"PatchData":      testPatchData,
+		"DeleteData":     testDeleteData,
 	}
 
 	for name, tc := range tests {
@@ -169,7 +173,7 @@ func testDataMiddleware(t *testing.T, handler *handlers.AppHandler) {
 			},
 
 			ActivityId: activity.Id,
-			CreatedBy:  primitive.NewObjectID(),
+			CreatedBy:  models.DataAuthor{Id: primitive.NewObjectID()},
 		}
 		db := &mockDataMiddlewareDB{}
 		db.GetDataFunc = func(ctx context.Context, arg storage.GetDataParams) (*models.Data, error) {
@@ -205,18 +209,54 @@ func testDataMiddleware(t *testing.T, handler *handlers.AppHandler) {
 }
 
 type mockCreateDataDB struct {
-	CreateDataFunc func(ctx context.Context, arg storage.CreateDataParams) (*models.Data, error)
+	CreateDataTxFunc          func(ctx context.Context, arg storage.CreateDataParams) (*models.Data, error)
+	GetDataFilterByValuesFunc func(ctx context.Context, arg storage.GetDataFilterByValuesParams) (*models.Data, error)
 }
 
-func (mdb *mockCreateDataDB) CreateData(ctx context.Context, arg storage.CreateDataParams) (*models.Data, error) {
-	return mdb.CreateDataFunc(ctx, arg)
+// GetLastValueOfAuthor finds no previous value, the fields of the tests have no default value
+func (mdb *mockCreateDataDB) GetLastValueOfAuthor(ctx context.Context, arg storage.GetLastValueOfAuthorParams) (any, error) {
+	return nil, nil
+}
+
+// GetActivity is only used by the formulas, the activities of the tests have none
+func (mdb *mockCreateDataDB) GetActivity(ctx context.Context, arg storage.GetActivityParams) (*models.Activity, error) {
+	return nil, nil
+}
+
+func (mdb *mockCreateDataDB) CreateDataTx(ctx context.Context, arg storage.CreateDataParams) (*models.Data, error) {
+	return mdb.CreateDataTxFunc(ctx, arg)
+}
+
+// GetDataFilterByValues finds no other data with the same keys unless the test needs one
+func (mdb *mockCreateDataDB) GetDataFilterByValues(ctx context.Context, arg storage.GetDataFilterByValuesParams) (*models.Data, error) {
+	if mdb.GetDataFilterByValuesFunc == nil {
+		return nil, nil
+	}
+	return mdb.GetDataFilterByValuesFunc(ctx, arg)
+}
+
+// newDataActivity returns an activity with a text primary key and a number field, and values for them
+func newDataActivity() (*models.Activity, map[string]any) {
+	reference := models.ActivityField{Id: primitive.NewObjectID(), Name: "n_devis", Type: models.FieldTypeText, PrimaryKey: true}
+	amount := models.ActivityField{Id: primitive.NewObjectID(), Name: "montant_os", Type: models.FieldTypeNumber}
+	activity := &models.Activity{
+		Id:          primitive.NewObjectID(),
+		Name:        sfaker.App().Name(),
+		Description: gofaker.Paragraph(),
+		Fields:      []models.ActivityField{reference, amount},
+	}
+	values := map[string]any{
+		reference.Id.Hex(): gofaker.UUIDHyphenated(),
+		amount.Id.Hex():    float64(sfaker.Number().NumberInt(7)),
+	}
+	return activity, values
 }
 
 func testCreateData(t *testing.T, handler *handlers.AppHandler) {
 	t.Run("invalid input data", func(t *testing.T) {
 		mux := chi.NewMux()
 		db := &mockCreateDataDB{
-			CreateDataFunc: func(ctx context.Context, arg storage.CreateDataParams) (*models.Data, error) {
+			CreateDataTxFunc: func(ctx context.Context, arg storage.CreateDataParams) (*models.Data, error) {
 				return nil, nil
 			},
 		}
@@ -240,21 +280,12 @@ func testCreateData(t *testing.T, handler *handlers.AppHandler) {
 
 	t.Run("error from db", func(t *testing.T) {
 		mux := chi.NewMux()
-		activity := &models.Activity{
-			Id:          primitive.NewObjectID(),
-			Name:        sfaker.App().Name(),
-			Description: gofaker.Paragraph(),
-		}
+		activity, values := newDataActivity()
 		dataRequest := handlers.CreateDataRequest{
-			Values: map[string]any{
-				"n_devis":    gofaker.UUIDHyphenated(),
-				"n_os":       gofaker.UUIDDigit(),
-				"date_os":    gofaker.Date(),
-				"montant_os": sfaker.Number().Number(7),
-			},
+			Values: values,
 		}
 		db := &mockCreateDataDB{
-			CreateDataFunc: func(ctx context.Context, arg storage.CreateDataParams) (*models.Data, error) {
+			CreateDataTxFunc: func(ctx context.Context, arg storage.CreateDataParams) (*models.Data, error) {
 				return nil, errors.New("an error happens")
 			},
 		}
@@ -272,38 +303,118 @@ func testCreateData(t *testing.T, handler *handlers.AppHandler) {
 				},
 			},
 		)
-		if code != http.StatusBadRequest {
-			t.Fatalf("CreateData(): status - got %d; want %d", code, http.StatusBadRequest)
+		if code != http.StatusInternalServerError {
+			t.Fatalf("CreateData(): status - got %d; want %d", code, http.StatusInternalServerError)
 		}
-		want := "ERR_DATA_CRT_01"
+		want := "ERR_DATA_CRT_STORAGE"
 		if response != want {
 			t.Fatalf("CreateData(): response error - got %s, want %s", response, want)
 		}
 	})
 
-	t.Run("success", func(t *testing.T) {
-		activity := &models.Activity{
-			Id:          primitive.NewObjectID(),
-			Name:        sfaker.App().Name(),
-			Description: gofaker.Paragraph(),
+	t.Run("values already used", func(t *testing.T) {
+		activity, values := newDataActivity()
+		testCases := map[string]struct {
+			Existing      *models.Data
+			CreateErr     error
+			ResponseError string
+		}{
+			"primary key of another data": {
+				Existing:      &models.Data{Id: primitive.NewObjectID(), Values: values},
+				ResponseError: "ERR_DATA_CRT_PRKEY_ALREADY_USED",
+			},
+			"data created meanwhile": {
+				CreateErr:     storage.ErrDataNotUnique,
+				ResponseError: "ERR_DATA_CRT_NOT_UNIQUE",
+			},
+		}
+
+		for name, tc := range testCases {
+			mux := chi.NewMux()
+			db := &mockCreateDataDB{
+				CreateDataTxFunc: func(ctx context.Context, arg storage.CreateDataParams) (*models.Data, error) {
+					if tc.CreateErr == nil {
+						t.Errorf("CreateData(): %s - the data is created", name)
+					}
+					return nil, tc.CreateErr
+				},
+				GetDataFilterByValuesFunc: func(ctx context.Context, arg storage.GetDataFilterByValuesParams) (*models.Data, error) {
+					return tc.Existing, nil
+				},
+			}
+
+			handler.CreateData(mux, db)
+			code, _, response := helpertest.MakePostRequest(
+				mux,
+				"/",
+				helpertest.CreateFormHeader(),
+				handlers.CreateDataRequest{Values: values},
+				[]helpertest.ContextData{{Name: "activity", Value: activity}},
+			)
+			if code != http.StatusBadRequest {
+				t.Fatalf("CreateData(): %s - status - got %d; want %d", name, code, http.StatusBadRequest)
+			}
+			got := handlers.DataValidationErrorResponse{}
+			if json.Unmarshal([]byte(response), &got) != nil {
+				got.Error = response
+			}
+			if got.Error != tc.ResponseError {
+				t.Fatalf("CreateData(): %s - response error - got %s; want %s", name, response, tc.ResponseError)
+			}
+		}
+	})
+
+	t.Run("formula result invalid for its field", func(t *testing.T) {
+		// the result is too large to be kept as an integer
+		quantity := models.ActivityField{Id: primitive.NewObjectID(), Code: "quantity", Type: models.FieldTypeNumber}
+		expression := "{quantity} * 1000000000000000000"
+		total := models.ActivityField{
+			Id:      primitive.NewObjectID(),
+			Code:    "total",
+			Type:    models.FieldTypeInteger,
+			Options: models.ActivityFieldOptions{Automatic: true, Formula: &expression},
+		}
+		activity := &models.Activity{Id: primitive.NewObjectID(), Fields: []models.ActivityField{quantity, total}}
+
+		mux := chi.NewMux()
+		db := &mockCreateDataDB{
+			CreateDataTxFunc: func(ctx context.Context, arg storage.CreateDataParams) (*models.Data, error) {
+				t.Errorf("CreateData(): the data is created; want the result of the formula refused")
+				return nil, nil
+			},
+		}
+
+		handler.CreateData(mux, db)
+		code, _, response := helpertest.MakePostRequest(
+			mux,
+			"/",
+			helpertest.CreateFormHeader(),
+			handlers.CreateDataRequest{Values: map[string]any{quantity.Id.Hex(): 12}},
+			[]helpertest.ContextData{{Name: "activity", Value: activity}},
+		)
+		if code != http.StatusBadRequest {
+			t.Fatalf("CreateData(): status - got %d; want %d (%s)", code, http.StatusBadRequest, response)
 		}
-		dataValues := map[string]any{
-			"n_devis":    gofaker.UUIDHyphenated(),
-			"n_os":       gofaker.UUIDDigit(),
-			"date_os":    gofaker.Date(),
-			"montant_os": sfaker.Number().Number(7),
+		got := handlers.DataValidationErrorResponse{}
+		json.Unmarshal([]byte(response), &got)
+		if got.Error != "ERR_DATA_CRT_INVALID_VALUES" || len(got.Fields) != 1 || got.Fields[0].FieldId != total.Id.Hex() || got.Fields[0].Code != "ERR_FIELD_INVALID_FORMULA" {
+			t.Fatalf("CreateData(): got %s; want the result of the total refused", response)
 		}
+	})
+
+	t.Run("success", func(t *testing.T) {
+		activity, dataValues := newDataActivity()
 		data := &models.Data{
 			Id:     primitive.NewObjectID(),
 			Values: dataValues,
 
 			ActivityId: activity.Id,
-			CreatedBy:  authenticatedUser.Id,
+			CreatedBy:  models.DataAuthor{Id: authenticatedUser.Id},
 		}
 
 		mux := chi.NewMux()
 		db := &mockCreateDataDB{
-			CreateDataFunc: func(ctx context.Context, arg storage.CreateDataParams) (*models.Data, error) {
+			CreateDataTxFunc: func(ctx context.Context, arg storage.CreateDataParams) (*models.Data, error) {
 				return data, nil
 			},
 		}
@@ -331,7 +442,7 @@ func testCreateData(t *testing.T, handler *handlers.AppHandler) {
 		if got.Data.ActivityId != activity.Id {
 			t.Fatalf("CreateData(): activityId - got %s; want %s", got.Data.ActivityId, activity.Id)
 		}
-		if got.Data.CreatedBy != authenticatedUser.Id {
+		if got.Data.CreatedBy.Id != authenticatedUser.Id {
 			t.Fatalf("CreateData(): CreatedBy - got %s; want %s", got.Data.CreatedBy, authenticatedUser.Id)
 		}
 	})
@@ -339,11 +450,11 @@ func testCreateData(t *testing.T, handler *handlers.AppHandler) {
 }
 
 type mockGetAllData struct {
-	GetAllDataFunc func(ctx context.Context, arg storage.GetAllDataParams) ([]*models.Data, error)
+	GetPaginatedDataFunc func(ctx context.Context, arg storage.GetPaginatedDataParams) (*storage.PaginatedData, error)
 }
 
-func (mdb *mockGetAllData) GetAllData(ctx context.Context, arg storage.GetAllDataParams) ([]*models.Data, error) {
-	return mdb.GetAllDataFunc(ctx, arg)
+func (mdb *mockGetAllData) GetPaginatedData(ctx context.Context, arg storage.GetPaginatedDataParams) (*storage.PaginatedData, error) {
+	return mdb.GetPaginatedDataFunc(ctx, arg)
 }
 
 func testGetAllData(t *testing.T, handler *handlers.AppHandler) {
@@ -356,8 +467,8 @@ func testGetAllData(t *testing.T, handler *handlers.AppHandler) {
 			Name:        sfaker.App().Name(),
 			Description: gofaker.Paragraph(),
 		}
-		mockDb.GetAllDataFunc = func(ctx context.Context, arg storage.GetAllDataParams) ([]*models.Data, error) {
-			return []*models.Data{}, errors.New("error from db")
+		mockDb.GetPaginatedDataFunc = func(ctx context.Context, arg storage.GetPaginatedDataParams) (*storage.PaginatedData, error) {
+			return nil, errors.New("error from db")
 		}
 
 		handler.GetAllData(mux, mockDb)
@@ -382,6 +493,142 @@ func testGetAllData(t *testing.T, handler *handlers.AppHandler) {
 		}
 	})
 
+	t.Run("invalid query", func(t *testing.T) {
+		activity, _ := newDataActivity()
+		mockDb.GetPaginatedDataFunc = func(ctx context.Context, arg storage.GetPaginatedDataParams) (*storage.PaginatedData, error) {
+			t.Fatalf("GetAllData(): the data are read despite an invalid query")
+			return nil, nil
+		}
+		mux := chi.NewMux()
+		handler.GetAllData(mux, mockDb)
+
+		amount := activity.Fields[1].Id.Hex()
+		cursor := func(key []byte, value any, sortBy string, sortOrder int) string {
+			encoded, err := storage.EncodeDataCursor(storage.DataCursor{
+				Value:     value,
+				Id:        primitive.NewObjectID(),
+				SortBy:    sortBy,
+				SortOrder: sortOrder,
+			}, key)
+			if err != nil {
+				t.Fatalf("EncodeDataCursor(): got error %v; want nil", err)
+			}
+			return encoded
+		}
+		key := services.CursorSecretKey()
+
+		testCases := map[string]struct {
+			Query         string
+			ResponseError string
+		}{
+			"limit not a number": {Query: "limit=ten", ResponseError: "ERR_DATA_GALL_LIMIT"},
+			"limit of zero":      {Query: "limit=0", ResponseError: "ERR_DATA_GALL_LIMIT"},
+			"negative offset":    {Query: "offset=-1", ResponseError: "ERR_DATA_GALL_OFFSET"},
+			"unknown sort":       {Query: "sort=-" + primitive.NewObjectID().Hex(), ResponseError: "ERR_DATA_GALL_SORT"},
+			"malformed cursor":   {Query: "cursor=not-a-cursor", ResponseError: "ERR_DATA_GALL_CURSOR"},
+			"forged cursor": {
+				Query:         "sort=" + amount + "&cursor=" + cursor([]byte("another key"), 10.0, "values."+amount, 1),
+				ResponseError: "ERR_DATA_GALL_CURSOR",
+			},
+			"cursor of another sort": {
+				Query:         "sort=" + amount + "&cursor=" + cursor(key, 10.0, "values."+amount, -1),
+				ResponseError: "ERR_DATA_GALL_CURSOR",
+			},
+			"operator in the cursor": {
+				Query:         "sort=" + amount + "&cursor=" + cursor(key, bson.M{"$ne": nil}, "values."+amount, 1),
+				ResponseError: "ERR_DATA_GALL_CURSOR",
+			},
+			"value of another type": {
+				Query:         "sort=" + amount + "&cursor=" + cursor(key, "10", "values."+amount, 1),
+				ResponseError: "ERR_DATA_GALL_CURSOR",
+			},
+			"date of the creation not a date": {
+				Query:         "cursor=" + cursor(key, 10.0, "created_at", 1),
+				ResponseError: "ERR_DATA_GALL_CURSOR",
+			},
+		}
+		for name, tc := range testCases {
+			_, w, response := helpertest.MakeGetRequest(mux, "/?"+tc.Query, []helpertest.ContextData{{Name: "activity", Value: activity}})
+			if w.StatusCode != http.StatusBadRequest {
+				t.Fatalf("GetAllData(): %s - status - got %d; want %d", name, w.StatusCode, http.StatusBadRequest)
+			}
+			if response != tc.ResponseError {
+				t.Fatalf("GetAllData(): %s - response error - got %s; want %s", name, response, tc.ResponseError)
+			}
+		}
+	})
+
+	t.Run("filter", func(t *testing.T) {
+		activity, _ := newDataActivity()
+		amount := activity.Fields[1].Id.Hex()
+		var params storage.GetPaginatedDataParams
+		mockDb.GetPaginatedDataFunc = func(ctx context.Context, arg storage.GetPaginatedDataParams) (*storage.PaginatedData, error) {
+			params = arg
+			return &storage.PaginatedData{Data: []*models.Data{}}, nil
+		}
+		mux := chi.NewMux()
+		handler.GetAllData(mux, mockDb)
+		ctxData := []helpertest.ContextData{{Name: "activity", Value: activity}}
+
+		_, w, response := helpertest.MakeGetRequest(mux, "/?filter="+url.QueryEscape(amount+" gte ten"), ctxData)
+		got := handlers.DataValidationErrorResponse{}
+		json.Unmarshal([]byte(response), &got)
+		if w.StatusCode != http.StatusBadRequest || got.Error != "ERR_DATA_GALL_FILTER" || len(got.Fields) != 1 || got.Fields[0].FieldId != amount {
+			t.Fatalf("GetAllData(): invalid filter - got %d %s; want %d ERR_DATA_GALL_FILTER on the amount", w.StatusCode, response, http.StatusBadRequest)
+		}
+
+		_, w, response = helpertest.MakeGetRequest(mux, "/?filter="+url.QueryEscape(amount+" gte 10"), ctxData)
+		if w.StatusCode != http.StatusOK {
+			t.Fatalf("GetAllData(): status - got %d; want %d (%s)", w.StatusCode, http.StatusOK, response)
+		}
+		if filter, ok := params.FilterBy["values."+amount].(bson.M); !ok || filter["$gte"] != 10.0 {
+			t.Fatalf("GetAllData(): filter - got %v; want the amount greater or equal to 10", params.FilterBy)
+		}
+	})
+
+	t.Run("page", func(t *testing.T) {
+		activity, values := newDataActivity()
+		amount := activity.Fields[1].Id.Hex()
+		data := []*models.Data{
+			{Id: primitive.NewObjectID(), Values: values, ActivityId: activity.Id},
+			{Id: primitive.NewObjectID(), Values: values, ActivityId: activity.Id},
+		}
+		var params storage.GetPaginatedDataParams
+		mockDb.GetPaginatedDataFunc = func(ctx context.Context, arg storage.GetPaginatedDataParams) (*storage.PaginatedData, error) {
+			params = arg
+			return &storage.PaginatedData{Data: data, Total: 5, HasMore: true}, nil
+		}
+		mux := chi.NewMux()
+		handler.GetAllData(mux, mockDb)
+
+		_, w, response := helpertest.MakeGetRequest(mux, "/?limit=2&offset=2&sort=-"+amount, []helpertest.ContextData{{Name: "activity", Value: activity}})
+		if w.StatusCode != http.StatusOK {
+			t.Fatalf("GetAllData(): status - got %d; want %d (%s)", w.StatusCode, http.StatusOK, response)
+		}
+		if params.Limit != 2 || params.Offset != 2 || params.SortBy != "values."+amount || params.SortOrder != -1 {
+			t.Fatalf("GetAllData(): params - got %+v; want the page 2 by 2 sorted on the amount descending", params)
+		}
+
+		got := handlers.GetAllDataResponse{}
+		json.Unmarshal([]byte(response), &got)
+		if got.Pagination.Total != 5 || got.Pagination.NextCursor == "" || got.Pagination.PrevCursor == "" {
+			t.Fatalf("GetAllData(): pagination - got %+v; want the total and the cursors of both pages around", got.Pagination)
+		}
+		if w.Header.Get("Link") == "" {
+			t.Fatalf("GetAllData(): no Link header")
+		}
+
+		// the next page starts after the last data
+		cursor, err := storage.DecodeDataCursor(got.Pagination.NextCursor, services.CursorSecretKey())
+		if err != nil || cursor.Id != data[1].Id || cursor.Backward || cursor.SortBy != "values."+amount || cursor.SortOrder != -1 {
+			t.Fatalf("GetAllData(): next cursor - got %+v (%v); want the last data", cursor, err)
+		}
+		_, w, _ = helpertest.MakeGetRequest(mux, "/?offset=2&sort=-"+amount+"&cursor="+got.Pagination.NextCursor, []helpertest.ContextData{{Name: "activity", Value: activity}})
+		if w.StatusCode != http.StatusOK || params.Cursor == nil || params.Cursor.Id != data[1].Id || params.Offset != 0 {
+			t.Fatalf("GetAllData(): params - got %+v; want the cursor instead of the offset", params)
+		}
+	})
+
 	t.Run("success", func(t *testing.T) {
 		mux := chi.NewMux()
 		activity := &models.Activity{
@@ -400,7 +647,7 @@ func testGetAllData(t *testing.T, handler *handlers.AppHandler) {
 				},
 
 				ActivityId: activity.Id,
-				CreatedBy:  authenticatedUser.Id,
+				CreatedBy:  models.DataAuthor{Id: authenticatedUser.Id},
 			},
 			{
 				Values: map[string]any{
@@ -411,12 +658,12 @@ func testGetAllData(t *testing.T, handler *handlers.AppHandler) {
 				},
 
 				ActivityId: activity.Id,
-				CreatedBy:  authenticatedUser.Id,
+				CreatedBy:  models.DataAuthor{Id: authenticatedUser.Id},
 			},
 		}
 
-		mockDb.GetAllDataFunc = func(ctx context.Context, arg storage.GetAllDataParams) ([]*models.Data, error) {
-			return data, nil
+		mockDb.GetPaginatedDataFunc = func(ctx context.Context, arg storage.GetPaginatedDataParams) (*storage.PaginatedData, error) {
+			return &storage.PaginatedData{Data: data, Total: int64(len(data))}, nil
 		}
 
 		handler.GetAllData(mux, mockDb)
@@ -448,7 +695,7 @@ func testGetAllData(t *testing.T, handler *handlers.AppHandler) {
 			if got.Data[i].ActivityId != activity.Id {
 				t.Fatalf("GetAllData(): activityId#%d - got %s; want %s", i, got.Data[i].ActivityId, activity.Id)
 			}
-			if got.Data[i].CreatedBy != authenticatedUser.Id {
+			if got.Data[i].CreatedBy.Id != authenticatedUser.Id {
 				t.Fatalf("GetAllData(): createdBy#%d - got %s; want %s", i, got.Data[i].CreatedBy, authenticatedUser.Id)
 			}
 		}
@@ -468,7 +715,7 @@ func testGetData(t *testing.T, handler *handlers.AppHandler) {
 			},
 
 			ActivityId: primitive.NewObjectID(),
-			CreatedBy:  primitive.NewObjectID(),
+			CreatedBy:  models.DataAuthor{Id: primitive.NewObjectID()},
 		}
 
 		db := &struct{}{}
@@ -497,12 +744,350 @@ func testGetData(t *testing.T, handler *handlers.AppHandler) {
 	})
 }
 
+type mockUpdateDataDB struct {
+	UpdateDataTxFunc           func(ctx context.Context, arg storage.UpdateDataTxParams) (*models.Data, error)
+	UpdateSetInDataTxFunc      func(ctx context.Context, arg storage.UpdateSetInDataTxParams) (*models.Data, error)
+	UpdateAddToDataTxFunc      func(ctx context.Context, arg storage.UpdateAddToDataTxParams) (*models.Data, error)
+	UpdateRemoveFromDataTxFunc func(ctx context.Context, arg storage.UpdateRemoveFromDataTxParams) (*models.Data, error)
+	GetDataFilterByValuesFunc  func(ctx context.Context, arg storage.GetDataFilterByValuesParams) (*models.Data, error)
+}
+
+// GetActivity is only used by the formulas, the activities of the tests have none
+func (mdb *mockUpdateDataDB) GetActivity(ctx context.Context, arg storage.GetActivityParams) (*models.Activity, error) {
+	return nil, nil
+}
+
+func (mdb *mockUpdateDataDB) UpdateDataTx(ctx context.Context, arg storage.UpdateDataTxParams) (*models.Data, error) {
+	return mdb.UpdateDataTxFunc(ctx, arg)
+}
+
+func (mdb *mockUpdateDataDB) UpdateSetInDataTx(ctx context.Context, arg storage.UpdateSetInDataTxParams) (*models.Data, error) {
+	return mdb.UpdateSetInDataTxFunc(ctx, arg)
+}
+
+func (mdb *mockUpdateDataDB) UpdateAddToDataTx(ctx context.Context, arg storage.UpdateAddToDataTxParams) (*models.Data, error) {
+	return mdb.UpdateAddToDataTxFunc(ctx, arg)
+}
+
+func (mdb *mockUpdateDataDB) UpdateRemoveFromDataTx(ctx context.Context, arg storage.UpdateRemoveFromDataTxParams) (*models.Data, error) {
+	return mdb.UpdateRemoveFromDataTxFunc(ctx, arg)
+}
+
+// GetDataFilterByValues finds no other data with the same keys unless the test needs one
+func (mdb *mockUpdateDataDB) GetDataFilterByValues(ctx context.Context, arg storage.GetDataFilterByValuesParams) (*models.Data, error) {
+	if mdb.GetDataFilterByValuesFunc == nil {
+		return nil, nil
+	}
+	return mdb.GetDataFilterByValuesFunc(ctx, arg)
+}
+
+// newUniqueData returns a data of an activity whose text field is unique
+func newUniqueData() (*models.Activity, *models.Data) {
+	activity, values := newDataActivity()
+	activity.Fields[0].Unique = true
+	data := &models.Data{
+		Id:         primitive.NewObjectID(),
+		Values:     values,
+		ActivityId: activity.Id,
+		CreatedBy:  models.DataAuthor{Id: authenticatedUser.Id},
+	}
+	return activity, data
+}
+
+func testUpdateData(t *testing.T, handler *handlers.AppHandler) {
+	t.Run("unique values of the data itself", func(t *testing.T) {
+		activity, data := newUniqueData()
+		mux := chi.NewMux()
+		db := &mockUpdateDataDB{
+			// the data itself is the only one with its values
+			GetDataFilterByValuesFunc: func(ctx context.Context, arg storage.GetDataFilterByValuesParams) (*models.Data, error) {
+				if arg.ExcludeId == data.Id {
+					return nil, nil
+				}
+				return data, nil
+			},
+			UpdateDataTxFunc: func(ctx context.Context, arg storage.UpdateDataTxParams) (*models.Data, error) {
+				if !arg.Replace {
+					t.Errorf("UpdateData(): the values are updated; want them replaced")
+				}
+				return &models.Data{Id: arg.Id, ActivityId: arg.ActivityId, Values: arg.Values}, nil
+			},
+		}
+
+		handler.UpdateData(mux, db)
+		code, _, response := helpertest.MakePutRequest(
+			mux,
+			"/",
+			helpertest.CreateFormHeader(),
+			handlers.UpdateDataRequest{Values: data.Values},
+			[]helpertest.ContextData{{Name: "activity", Value: activity}, {Name: "data", Value: data}},
+		)
+		if code != http.StatusOK {
+			t.Fatalf("UpdateData(): status - got %d; want %d (%s)", code, http.StatusOK, response)
+		}
+
+		got := handlers.UpdateDataResponse{}
+		json.Unmarshal([]byte(response), &got)
+		if got.Data.Id != data.Id {
+			t.Fatalf("UpdateData(): id - got %s; want %s", got.Data.Id.Hex(), data.Id.Hex())
+		}
+	})
+
+	t.Run("unique value of another data", func(t *testing.T) {
+		activity, data := newUniqueData()
+		mux := chi.NewMux()
+		db := &mockUpdateDataDB{
+			GetDataFilterByValuesFunc: func(ctx context.Context, arg storage.GetDataFilterByValuesParams) (*models.Data, error) {
+				return &models.Data{Id: primitive.NewObjectID(), ActivityId: activity.Id}, nil
+			},
+			UpdateDataTxFunc: func(ctx context.Context, arg storage.UpdateDataTxParams) (*models.Data, error) {
+				t.Errorf("UpdateData(): the data is updated; want it rejected")
+				return nil, nil
+			},
+		}
+
+		handler.UpdateData(mux, db)
+		code, _, response := helpertest.MakePutRequest(
+			mux,
+			"/",
+			helpertest.CreateFormHeader(),
+			handlers.UpdateDataRequest{Values: data.Values},
+			[]helpertest.ContextData{{Name: "activity", Value: activity}, {Name: "data", Value: data}},
+		)
+		if code != http.StatusBadRequest {
+			t.Fatalf("UpdateData(): status - got %d; want %d", code, http.StatusBadRequest)
+		}
+		got := handlers.DataValidationErrorResponse{}
+		json.Unmarshal([]byte(response), &got)
+		wantError := "ERR_DATA_UPDT_PRKEY_ALREADY_USED"
+		if got.Error != wantError {
+			t.Fatalf("UpdateData(): response error - got %s; want %s", got.Error, wantError)
+		}
+	})
+
+	t.Run("not written", func(t *testing.T) {
+		testCases := map[string]struct {
+			UpdateErr  error
+			WantStatus int
+			WantError  string
+		}{
+			"error from db": {
+				UpdateErr:  errors.New("an error happens"),
+				WantStatus: http.StatusInternalServerError,
+				WantError:  "ERR_DATA_UPDT_STORAGE",
+			},
+			"data deleted meanwhile": {
+				WantStatus: http.StatusNotFound,
+				WantError:  "ERR_DATA_UPDT_NOT_FOUND",
+			},
+		}
+
+		for name, tc := range testCases {
+			t.Run(name, func(t *testing.T) {
+				activity, data := newUniqueData()
+				mux := chi.NewMux()
+				db := &mockUpdateDataDB{
+					UpdateDataTxFunc: func(ctx context.Context, arg storage.UpdateDataTxParams) (*models.Data, error) {
+						return nil, tc.UpdateErr
+					},
+				}
+
+				handler.UpdateData(mux, db)
+				code, _, response := helpertest.MakePutRequest(
+					mux,
+					"/",
+					helpertest.CreateFormHeader(),
+					handlers.UpdateDataRequest{Values: data.Values},
+					[]helpertest.ContextData{{Name: "activity", Value: activity}, {Name: "data", Value: data}},
+				)
+				if code != tc.WantStatus {
+					t.Fatalf("UpdateData(): status - got %d; want %d", code, tc.WantStatus)
+				}
+				if response != tc.WantError {
+					t.Fatalf("UpdateData(): response error - got %s; want %s", response, tc.WantError)
+				}
+			})
+		}
+	})
+}
+
+func testPatchData(t *testing.T, handler *handlers.AppHandler) {
+	activity, values := newDataActivity()
+	reference := activity.Fields[0].Id.Hex()
+	tags := models.ActivityField{
+		Id:      primitive.NewObjectID(),
+		Name:    "tags",
+		Type:    models.FieldTypeText,
+		Options: models.ActivityFieldOptions{Multiple: true},
+	}
+	activity.Fields = append(activity.Fields, tags)
+	values[tags.Id.Hex()] = []any{"a", "b", "c"}
+
+	position := func(p uint64) *uint64 {
+		return &p
+	}
+
+	type operation struct {
+		name     string
+		field    string
+		value    any
+		position uint
+	}
+	testCases := map[string]struct {
+		Input handlers.PatchDataRequest
+		// the list is shorter once in the storage
+		Shortened bool
+		// the data is deleted once in the storage
+		Deleted      bool
+		StorageError error
+		WantStatus   int // 400 when not set
+		WantError    string
+		Want         operation
+	}{
+		"set": {
+			Input: handlers.PatchDataRequest{Operation: "set", Field: reference, Value: "D-2024-001"},
+			Want:  operation{name: "set", field: reference, value: "D-2024-001"},
+		},
+		"add at a position": {
+			Input: handlers.PatchDataRequest{Operation: "add", Field: tags.Id.Hex(), Value: "x", Position: position(1)},
+			Want:  operation{name: "add", field: tags.Id.Hex(), value: "x", position: 1},
+		},
+		"add at the end": {
+			Input: handlers.PatchDataRequest{Operation: "add", Field: tags.Id.Hex(), Value: "x"},
+			Want:  operation{name: "add", field: tags.Id.Hex(), value: "x", position: 3},
+		},
+		"add after the end": {
+			Input:     handlers.PatchDataRequest{Operation: "add", Field: tags.Id.Hex(), Value: "x", Position: position(4)},
+			WantError: "ERR_DATA_PTCH_POSITION",
+		},
+		"add to a single value": {
+			Input:     handlers.PatchDataRequest{Operation: "add", Field: reference, Value: "x"},
+			WantError: "ERR_DATA_PTCH_NOT_LIST",
+		},
+		"remove at a position": {
+			Input: handlers.PatchDataRequest{Operation: "remove", Field: tags.Id.Hex(), Position: position(1)},
+			Want:  operation{name: "remove", field: tags.Id.Hex(), position: 1},
+		},
+		"remove without a position": {
+			Input:     handlers.PatchDataRequest{Operation: "remove", Field: tags.Id.Hex()},
+			WantError: "ERR_DATA_PTCH_POSITION",
+		},
+		"remove at the end": {
+			Input:      handlers.PatchDataRequest{Operation: "remove", Field: tags.Id.Hex(), Position: position(3)},
+			WantStatus: http.StatusNotFound,
+			WantError:  "ERR_DATA_PTCH_POSITION_NOT_FOUND",
+		},
+		"remove an item removed meanwhile": {
+			Input:      handlers.PatchDataRequest{Operation: "remove", Field: tags.Id.Hex(), Position: position(2)},
+			Shortened:  true,
+			WantStatus: http.StatusNotFound,
+			WantError:  "ERR_DATA_PTCH_POSITION_NOT_FOUND",
+		},
+		"unknown field": {
+			Input:     handlers.PatchDataRequest{Operation: "set", Field: primitive.NewObjectID().Hex(), Value: "x"},
+			WantError: "ERR_DATA_PTCH_FIELD",
+		},
+		"data deleted meanwhile": {
+			Input:      handlers.PatchDataRequest{Operation: "set", Field: reference, Value: "D-2024-001"},
+			Deleted:    true,
+			WantStatus: http.StatusNotFound,
+			WantError:  "ERR_DATA_PTCH_NOT_FOUND",
+		},
+		"error from db": {
+			Input:        handlers.PatchDataRequest{Operation: "add", Field: tags.Id.Hex(), Value: "x"},
+			StorageError: errors.New("an error happens"),
+			WantStatus:   http.StatusInternalServerError,
+			WantError:    "ERR_DATA_PTCH_STORAGE",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			data := &models.Data{
+				Id:         primitive.NewObjectID(),
+				Values:     values,
+				ActivityId: activity.Id,
+				CreatedBy:  models.DataAuthor{Id: authenticatedUser.Id},
+			}
+			var got *operation
+			updated := func() (*models.Data, error) {
+				if tc.StorageError != nil {
+					return nil, tc.StorageError
+				}
+				if tc.Shortened || tc.Deleted {
+					return nil, nil
+				}
+				return data, nil
+			}
+			db := &mockUpdateDataDB{
+				UpdateSetInDataTxFunc: func(ctx context.Context, arg storage.UpdateSetInDataTxParams) (*models.Data, error) {
+					got = &operation{name: "set", field: arg.Field, value: arg.Value}
+					return updated()
+				},
+				UpdateAddToDataTxFunc: func(ctx context.Context, arg storage.UpdateAddToDataTxParams) (*models.Data, error) {
+					got = &operation{name: "add", field: arg.Field, value: arg.Value, position: arg.Position}
+					return updated()
+				},
+				UpdateRemoveFromDataTxFunc: func(ctx context.Context, arg storage.UpdateRemoveFromDataTxParams) (*models.Data, error) {
+					got = &operation{name: "remove", field: arg.Field, position: arg.Position}
+					return updated()
+				},
+			}
+
+			mux := chi.NewMux()
+			handler.PatchData(mux, db)
+			code, _, response := helpertest.MakePatchRequest(
+				mux,
+				"/",
+				helpertest.CreateFormHeader(),
+				tc.Input,
+				[]helpertest.ContextData{{Name: "activity", Value: activity}, {Name: "data", Value: data}},
+			)
+
+			if tc.WantError != "" {
+				wantStatus := tc.WantStatus
+				if wantStatus == 0 {
+					wantStatus = http.StatusBadRequest
+				}
+				if code != wantStatus {
+					t.Fatalf("PatchData(): status - got %d; want %d", code, wantStatus)
+				}
+				if response != tc.WantError {
+					t.Fatalf("PatchData(): response error - got %s; want %s", response, tc.WantError)
+				}
+				written := tc.Shortened || tc.Deleted || tc.StorageError != nil
+				if got != nil && !written {
+					t.Fatalf("PatchData(): %s written; want nothing written", got.name)
+				}
+				return
+			}
+			if code != http.StatusOK {
+				t.Fatalf("PatchData(): status - got %d; want %d (%s)", code, http.StatusOK, response)
+			}
+			if got == nil || !reflect.DeepEqual(*got, tc.Want) {
+				t.Fatalf("PatchData(): written %+v; want %+v", got, tc.Want)
+			}
+		})
+	}
+}
+
 type mockDeleteDataDB struct {
-	DeleteDataFunc func(ctx context.Context, arg storage.DeleteDataParams) error
+	DeleteDataTxFunc func(ctx context.Context, arg storage.DeleteDataTxParams) error
+	GetAllDataFunc   func(ctx context.Context, arg storage.GetAllDataParams) ([]*models.Data, error)
 }
 
-func (mdb *mockDeleteDataDB) DeleteData(ctx context.Context, arg storage.DeleteDataParams) error {
-	return mdb.DeleteDataFunc(ctx, arg)
+func (mdb *mockDeleteDataDB) DeleteDataTx(ctx context.Context, arg storage.DeleteDataTxParams) error {
+	return mdb.DeleteDataTxFunc(ctx, arg)
+}
+
+func (mdb *mockDeleteDataDB) GetAllData(ctx context.Context, arg storage.GetAllDataParams) ([]*models.Data, error) {
+	return mdb.GetAllDataFunc(ctx, arg)
+}
+
+type mockDeleteFilesS3 struct{}
+
+func (s3 *mockDeleteFilesS3) DeleteFile(uploadKey string) error {
+	return nil
 }
 
 func testDeleteData(t *testing.T, handler *handlers.AppHandler) {
@@ -523,15 +1108,15 @@ func testDeleteData(t *testing.T, handler *handlers.AppHandler) {
 			},
 
 			ActivityId: activity.Id,
-			CreatedBy:  primitive.NewObjectID(),
+			CreatedBy:  models.DataAuthor{Id: primitive.NewObjectID()},
 		}
 		db := &mockDeleteDataDB{
-			DeleteDataFunc: func(ctx context.Context, arg storage.DeleteDataParams) error {
+			DeleteDataTxFunc: func(ctx context.Context, arg storage.DeleteDataTxParams) error {
 				return errors.New("an error happens")
 			},
 		}
 
-		handler.DeleteData(mux, db)
+		handler.DeleteData(mux, db, &mockDeleteFilesS3{})
 		code, _, response := helpertest.MakeDeleteRequest(
 			mux,
 			"/",
@@ -569,15 +1154,15 @@ func testDeleteData(t *testing.T, handler *handlers.AppHandler) {
 			},
 
 			ActivityId: activity.Id,
-			CreatedBy:  primitive.NewObjectID(),
+			CreatedBy:  models.DataAuthor{Id: primitive.NewObjectID()},
 		}
 		db := &mockDeleteDataDB{
-			DeleteDataFunc: func(ctx context.Context, arg storage.DeleteDataParams) error {
+			DeleteDataTxFunc: func(ctx context.Context, arg storage.DeleteDataTxParams) error {
 				return nil
 			},
 		}
 
-		handler.DeleteData(mux, db)
+		handler.DeleteData(mux, db, &mockDeleteFilesS3{})
 		code, _, response := helpertest.MakeDeleteRequest(
 			mux,
 			"/",
@@ -625,11 +1210,11 @@ func dataEq(got, want *models.Data) error {
 	}
 
 	if got.CreatedBy != want.CreatedBy {
-		return fmt.Errorf("CreatedBy - got %s; want %s", got.CreatedBy, want.CreatedBy)
+		return fmt.Errorf("CreatedBy - got %v; want %v", got.CreatedBy, want.CreatedBy)
 	}
 
 	if got.ActivityId != want.ActivityId {
-		return fmt.Errorf("ActivityId - got %s; want %s", got.CreatedBy, want.CreatedBy)
+		return fmt.Errorf("ActivityId - got %s; want %s", got.ActivityId, want.ActivityId)
 	}
 
 	return nil
@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"stockinos.com/api/formulas"
+	"stockinos.com/api/models"
+	"stockinos.com/api/storage"
+)
+
+type compileFormulasInterface interface {
+	GetActivity(ctx context.Context, arg storage.GetActivityParams) (*models.Activity, error)
+}
+
+// compileFormulas checks the formulas of the activity against the activities referenced by its key fields,
+// a *formulas.Error being returned for a formula rejected. The known activities are used instead of the stored ones.
+func compileFormulas(ctx context.Context, activity *models.Activity, db compileFormulasInterface, known ...*models.Activity) (*formulas.Program, error) {
+	if !formulas.HasFormulas(activity.Fields) {
+		return formulas.Compile(activity.Fields, nil)
+	}
+
+	referenced := map[primitive.ObjectID]*models.Activity{
+		activity.Id: activity,
+	}
+	for _, knownActivity := range known {
+		referenced[knownActivity.Id] = knownActivity
+	}
+	for _, field := range activity.Fields {
+		details := field.Details.ActivityFieldKey
+		if field.Type != models.FieldTypeKey || details == nil || details.ActivityId.IsZero() {
+			continue
+		}
+		if _, ok := referenced[details.ActivityId]; ok {
+			continue
+		}
+
+		referencedActivity, err := db.GetActivity(ctx, storage.GetActivityParams{
+			Id:             details.ActivityId,
+			OrganizationId: activity.OrganizationId,
+		})
+		if err != nil {
+			return nil, err
+		}
+		referenced[details.ActivityId] = referencedActivity
+	}
+
+	return formulas.Compile(activity.Fields, referenced)
+}
+
+type checkActivityFormulasInterface interface {
+	GetActivity(ctx context.Context, arg storage.GetActivityParams) (*models.Activity, error)
+	GetAllActivities(ctx context.Context, arg storage.GetAllActivitiesParams) ([]*models.Activity, error)
+}
+
+// checkActivityFormulas checks the formulas of the activity with its updated fields,
+// and the formulas of the other activities looking up the values of its data
+func checkActivityFormulas(ctx context.Context, activity *models.Activity, fields []models.ActivityField, db checkActivityFormulasInterface) error {
+	updatedActivity := *activity
+	updatedActivity.Fields = fields
+	if _, err := compileFormulas(ctx, &updatedActivity, db); err != nil {
+		return err
+	}
+
+	activities, err := db.GetAllActivities(ctx, storage.GetAllActivitiesParams{
+		OrganizationId: activity.OrganizationId,
+	})
+	if err != nil {
+		return err
+	}
+	for _, other := range activities {
+		if other.Id == activity.Id || !formulas.HasFormulas(other.Fields) || !referencesActivity(other, activity.Id) {
+			continue
+		}
+
+		_, err := compileFormulas(ctx, other, db, &updatedActivity)
+		var formulaErr *formulas.Error
+		if errors.As(err, &formulaErr) {
+			return &formulas.Error{
+				Field:   formulaErr.Field,
+				Message: fmt.Sprintf("in the activity %s, %s", other.Name, formulaErr.Message),
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// referencesActivity tells if a key field of the activity references the other one
+func referencesActivity(activity *models.Activity, otherId primitive.ObjectID) bool {
+	for _, field := range activity.Fields {
+		details := field.Details.ActivityFieldKey
+		if field.Type == models.FieldTypeKey && details != nil && details.ActivityId == otherId {
+			return true
+		}
+	}
+	return false
+}
+
+// writeFormulaError explains why a formula is rejected, the other errors only have the code
+func writeFormulaError(w http.ResponseWriter, code string, err error) {
+	var formulaErr *formulas.Error
+	if !errors.As(err, &formulaErr) {
+		http.Error(w, code, http.StatusBadRequest)
+		return
+	}
+
+	writeFieldErrors(w, code, []models.FieldError{formulaFieldError(err)})
+}
+
+// formulaFieldError is the error of the field whose formula fails, without a field
+// when the formula fails for another reason than the formula itself
+func formulaFieldError(err error) models.FieldError {
+	fieldError := models.FieldError{
+		Code:    "ERR_FIELD_INVALID_FORMULA",
+		Message: "the formula can not be computed",
+	}
+	var formulaErr *formulas.Error
+	if errors.As(err, &formulaErr) {
+		fieldError.FieldId = formulaErr.Field
+		fieldError.Message = formulaErr.Message
+	}
+	return fieldError
+}
+
+type dataFormulasInterface interface {
+	GetActivity(ctx context.Context, arg storage.GetActivityParams) (*models.Activity, error)
+	GetDataFilterByValues(ctx context.Context, arg storage.GetDataFilterByValuesParams) (*models.Data, error)
+}
+
+// dataFormulas computes the values of the automatic fields of an activity when its data are written
+type dataFormulas struct {
+	program  *formulas.Program
+	resolver *dataResolver
+}
+
+// loadDataFormulas returns nil when no field of the activity is computed
+func loadDataFormulas(ctx context.Context, activity *models.Activity, db dataFormulasInterface) (*dataFormulas, error) {
+	if !formulas.HasFormulas(activity.Fields) {
+		return nil, nil
+	}
+
+	program, err := compileFormulas(ctx, activity, db)
+	if err != nil {
+		return nil, err
+	}
+	return &dataFormulas{
+		program:  program,
+		resolver: newDataResolver(db),
+	}, nil
+}
+
+// computes tells if the value of the field is computed, it can not be written
+func (f *dataFormulas) computes(fieldId string) bool {
+	return f != nil && f.program.Computes(fieldId)
+}
+
+// withoutComputed drops the values sent for the computed fields, they are replaced by their result
+func (f *dataFormulas) withoutComputed(values map[string]any) map[string]any {
+	if f == nil {
+		return values
+	}
+	kept := make(map[string]any, len(values))
+	for key, value := range values {
+		if !f.computes(key) {
+			kept[key] = value
+		}
+	}
+	return kept
+}
+
+// compute sets the results of the formulas in the values, and returns them, nil when a formula has no result
+func (f *dataFormulas) compute(ctx context.Context, values map[string]any) (map[string]any, error) {
+	if f == nil {
+		return map[string]any{}, nil
+	}
+
+	computed, err := f.program.Evaluate(ctx, values, f.resolver)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range computed {
+		if value == nil {
+			delete(values, key)
+		} else {
+			values[key] = value
+		}
+	}
+	return computed, nil
+}
+
+type dataResolverInterface interface {
+	GetDataFilterByValues(ctx context.Context, arg storage.GetDataFilterByValuesParams) (*models.Data, error)
+}
+
+// dataResolver finds the data referenced by the key fields used in the formulas,
+// each of them once for all the data written by a request
+type dataResolver struct {
+	db    dataResolverInterface
+	cache map[string]map[string]any
+}
+
+func newDataResolver(db dataResolverInterface) *dataResolver {
+	return &dataResolver{
+		db:    db,
+		cache: make(map[string]map[string]any),
+	}
+}
+
+func (resolver *dataResolver) Resolve(ctx context.Context, key models.ActivityFieldKey, value any) (map[string]any, error) {
+	cacheKey := fmt.Sprintf("%s/%s/%T/%v", key.ActivityId.Hex(), key.FieldId.Hex(), value, value)
+	if values, ok := resolver.cache[cacheKey]; ok {
+		return values, nil
+	}
+
+	data, err := resolver.db.GetDataFilterByValues(ctx, storage.GetDataFilterByValuesParams{
+		Values: map[string]any{
+			key.FieldId.Hex(): value,
+		},
+		ActivityId: key.ActivityId,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var values map[string]any
+	if data != nil {
+		values = data.Values
+	}
+	resolver.cache[cacheKey] = values
+	return values, nil
+}
@@ -10,12 +10,15 @@ import (
 	"strings"
 
 	"stockinos.com/api/models"
+	"stockinos.com/api/ratelimit"
 	"stockinos.com/api/services"
 )
 
 type AppHandler struct {
 	GetAuthenticatedUser func(r *http.Request) *models.User
 	ParsingRequestBody   func(w http.ResponseWriter, r *http.Request, inputs interface{}) (int, error)
+	// IP of the client, read from the headers of the trusted proxies only
+	ClientIP func(r *http.Request) string
 }
 
 func NewAppHandler() *AppHandler {
@@ -127,5 +130,6 @@ func NewAppHandler() *AppHandler {
 
 			return -1, nil
 		},
+		ClientIP: ratelimit.ClientIP(0),
 	}
 }
@@ -0,0 +1,296 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"stockinos.com/api/models"
+	"stockinos.com/api/services"
+	"stockinos.com/api/storage"
+)
+
+type invitationSenderInterface interface {
+	SendInvitation(ctx context.Context, message services.InvitationMessage) (string, error)
+	SendJoinReview(ctx context.Context, message services.JoinReviewMessage) (string, error)
+}
+
+type markInvitationSentInterface interface {
+	MarkInvitationSent(ctx context.Context, arg storage.MarkInvitationSentParams) (*models.Invitation, error)
+}
+
+// sendInvitation sends the link to the phone number invited and records it, the invitation can be used until expiresAt
+func sendInvitation(ctx context.Context, db markInvitationSentInterface, sender invitationSenderInterface, organization *models.Organization, invitation *models.Invitation, language string, expiresAt time.Time) (*models.Invitation, error) {
+	_, err := sender.SendInvitation(ctx, services.InvitationMessage{
+		PhoneNumber:      invitation.PhoneNumber,
+		Language:         language,
+		OrganizationName: organization.Name,
+		Link:             services.InvitationLink(invitation.Token),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return db.MarkInvitationSent(ctx, storage.MarkInvitationSentParams{
+		Id:             invitation.Id,
+		OrganizationId: organization.Id,
+		SentAt:         time.Now(),
+		ExpiresAt:      expiresAt,
+	})
+}
+
+type InvitationResponse struct {
+	Invitation models.Invitation `json:"invitation"`
+	Link       string            `json:"link"`
+}
+
+type getInvitationsInterface interface {
+	GetInvitations(ctx context.Context, arg storage.GetInvitationsParams) ([]*models.Invitation, error)
+}
+
+type GetInvitationsResponse struct {
+	Invitations []*models.Invitation `json:"invitations"`
+}
+
+// GetInvitations lists the invitations of the organization not revoked, the latest created first
+func (appHandler *AppHandler) GetInvitations(mux chi.Router, db getInvitationsInterface) {
+	mux.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		organization := ctx.Value("organization").(*models.Organization)
+
+		invitations, err := db.GetInvitations(ctx, storage.GetInvitationsParams{
+			OrganizationId: organization.Id,
+		})
+		if err != nil {
+			http.Error(w, "ERR_INV_GALL_01", http.StatusBadRequest)
+			return
+		}
+
+		response := GetInvitationsResponse{
+			Invitations: invitations,
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			http.Error(w, "ERR_INV_GALL_END", http.StatusBadRequest)
+			return
+		}
+	})
+}
+
+type createInvitationInterface interface {
+	CreateInvitation(ctx context.Context, arg storage.CreateInvitationParams) (*models.Invitation, error)
+	MarkInvitationSent(ctx context.Context, arg storage.MarkInvitationSentParams) (*models.Invitation, error)
+}
+
+type CreateInvitationRequest struct {
+	// the link is sent to the phone number, only it can join with the invitation.
+	// None: anyone with the link can join, up to MaxUses members
+	PhoneNumber string `json:"phone_number"`
+	MaxUses     int    `json:"max_uses"`
+	// models.RoleEditor by default, the ownership is only transferred
+	Role string `json:"role"`
+	// services.InvitationTTL from now by default
+	ExpiresAt *time.Time `json:"expires_at"`
+	Language  string     `json:"language"` // Language for template
+}
+
+// CreateInvitation creates an invitation to join the organization and sends it to the phone number invited
+func (appHandler *AppHandler) CreateInvitation(mux chi.Router, db createInvitationInterface, sender invitationSenderInterface) {
+	mux.Post("/", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		organization := ctx.Value("organization").(*models.Organization)
+		user := appHandler.GetAuthenticatedUser(r)
+
+		var input CreateInvitationRequest
+		httpStatus, err := appHandler.ParsingRequestBody(w, r, &input)
+		if err != nil {
+			http.Error(w, err.Error(), httpStatus)
+			return
+		}
+
+		if input.Role == "" {
+			input.Role = models.RoleEditor
+		}
+		if !models.IsRole(input.Role) || input.Role == models.RoleOwner {
+			http.Error(w, "ERR_INV_CRT_ROLE", http.StatusBadRequest)
+			return
+		}
+
+		input.PhoneNumber = strings.TrimSpace(input.PhoneNumber)
+		if input.PhoneNumber != "" {
+			input.MaxUses = 1
+		}
+		if input.MaxUses < 1 {
+			http.Error(w, "ERR_INV_CRT_MAX_USES", http.StatusBadRequest)
+			return
+		}
+
+		now := time.Now()
+		expiresAt := now.Add(services.InvitationTTL())
+		if input.ExpiresAt != nil {
+			if !input.ExpiresAt.After(now) {
+				http.Error(w, "ERR_INV_CRT_EXPIRES_AT", http.StatusBadRequest)
+				return
+			}
+			expiresAt = *input.ExpiresAt
+		}
+
+		invitation, err := db.CreateInvitation(ctx, storage.CreateInvitationParams{
+			OrganizationId: organization.Id,
+			Token:          services.GenerateInvitationToken(),
+			PhoneNumber:    input.PhoneNumber,
+			Role:           input.Role,
+			MaxUses:        input.MaxUses,
+			CreatedBy:      dataAuthor(user),
+			ExpiresAt:      expiresAt,
+		})
+		if err != nil {
+			http.Error(w, "ERR_INV_CRT_01", http.StatusBadRequest)
+			return
+		}
+
+		// the link can still be shared by hand, or sent again
+		if invitation.PhoneNumber != "" {
+			sent, err := sendInvitation(ctx, db, sender, organization, invitation, input.Language, invitation.ExpiresAt)
+			if err != nil {
+				log.Println("error when sending the invitation: ", err)
+			} else if sent != nil {
+				invitation = sent
+			}
+		}
+
+		response := InvitationResponse{
+			Invitation: *invitation,
+			Link:       services.InvitationLink(invitation.Token),
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			http.Error(w, "ERR_INV_CRT_END", http.StatusBadRequest)
+			return
+		}
+	})
+}
+
+type resendInvitationInterface interface {
+	GetInvitation(ctx context.Context, arg storage.GetInvitationParams) (*models.Invitation, error)
+	MarkInvitationSent(ctx context.Context, arg storage.MarkInvitationSentParams) (*models.Invitation, error)
+}
+
+// ResendInvitation sends the link again to the phone number invited, in the ?language given.
+// An expired invitation is extended.
+func (appHandler *AppHandler) ResendInvitation(mux chi.Router, db resendInvitationInterface, sender invitationSenderInterface) {
+	mux.Post("/{invitationId}/resend", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		organization := ctx.Value("organization").(*models.Organization)
+
+		invitationId, err := primitive.ObjectIDFromHex(chi.URLParam(r, "invitationId"))
+		if err != nil {
+			http.Error(w, "ERR_INV_RSD_01", http.StatusBadRequest)
+			return
+		}
+
+		invitation, err := db.GetInvitation(ctx, storage.GetInvitationParams{
+			Id:             invitationId,
+			OrganizationId: organization.Id,
+		})
+		if err != nil {
+			http.Error(w, "ERR_INV_RSD_02", http.StatusBadRequest)
+			return
+		}
+		if invitation == nil {
+			http.Error(w, "ERR_INV_RSD_03", http.StatusNotFound)
+			return
+		}
+		if invitation.PhoneNumber == "" {
+			http.Error(w, "ERR_INV_RSD_OPEN", http.StatusBadRequest)
+			return
+		}
+		if invitation.Uses >= invitation.MaxUses {
+			http.Error(w, "ERR_INV_RSD_USED", http.StatusBadRequest)
+			return
+		}
+
+		expiresAt := invitation.ExpiresAt
+		if now := time.Now(); !now.Before(expiresAt) {
+			expiresAt = now.Add(services.InvitationTTL())
+		}
+
+		invitation, err = sendInvitation(ctx, db, sender, organization, invitation, r.URL.Query().Get("language"), expiresAt)
+		if err != nil {
+			log.Println("error when sending the invitation: ", err)
+			http.Error(w, "ERR_INV_RSD_04", http.StatusBadRequest)
+			return
+		}
+		if invitation == nil {
+			http.Error(w, "ERR_INV_RSD_03", http.StatusNotFound)
+			return
+		}
+
+		response := InvitationResponse{
+			Invitation: *invitation,
+			Link:       services.InvitationLink(invitation.Token),
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			http.Error(w, "ERR_INV_RSD_END", http.StatusBadRequest)
+			return
+		}
+	})
+}
+
+type revokeInvitationInterface interface {
+	RevokeInvitation(ctx context.Context, arg storage.RevokeInvitationParams) (*models.Invitation, error)
+}
+
+type RevokeInvitationResponse struct {
+	Invitation models.Invitation `json:"invitation"`
+}
+
+// RevokeInvitation refuses the link from now on, the members who joined with it stay
+func (appHandler *AppHandler) RevokeInvitation(mux chi.Router, db revokeInvitationInterface) {
+	mux.Delete("/{invitationId}", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		organization := ctx.Value("organization").(*models.Organization)
+
+		invitationId, err := primitive.ObjectIDFromHex(chi.URLParam(r, "invitationId"))
+		if err != nil {
+			http.Error(w, "ERR_INV_RVK_01", http.StatusBadRequest)
+			return
+		}
+
+		invitation, err := db.RevokeInvitation(ctx, storage.RevokeInvitationParams{
+			Id:             invitationId,
+			OrganizationId: organization.Id,
+		})
+		if err != nil {
+			http.Error(w, "ERR_INV_RVK_02", http.StatusBadRequest)
+			return
+		}
+		if invitation == nil {
+			http.Error(w, "ERR_INV_RVK_03", http.StatusNotFound)
+			return
+		}
+
+		response := RevokeInvitationResponse{
+			Invitation: *invitation,
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			http.Error(w, "ERR_INV_RVK_END", http.StatusBadRequest)
+			return
+		}
+	})
+}
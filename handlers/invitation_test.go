@@ -0,0 +1,454 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"stockinos.com/api/handlers"
+	"stockinos.com/api/helpertest"
+	"stockinos.com/api/models"
+	"stockinos.com/api/services"
+	"stockinos.com/api/storage"
+	sfaker "syreclabs.com/go/faker"
+)
+
+// mockInvitationDB keeps the invitations of one organization, as the storage would
+type mockInvitationDB struct {
+	organization *models.Organization
+	invitations  []*models.Invitation
+}
+
+func (mdb *mockInvitationDB) find(id primitive.ObjectID, organizationId primitive.ObjectID) *models.Invitation {
+	for _, invitation := range mdb.invitations {
+		if invitation.Id == id && invitation.OrganizationId == organizationId && invitation.RevokedAt == nil {
+			return invitation
+		}
+	}
+	return nil
+}
+
+func (mdb *mockInvitationDB) GetInvitations(ctx context.Context, arg storage.GetInvitationsParams) ([]*models.Invitation, error) {
+	invitations := make([]*models.Invitation, 0)
+	for _, invitation := range mdb.invitations {
+		if invitation.OrganizationId == arg.OrganizationId && invitation.RevokedAt == nil {
+			invitations = append(invitations, invitation)
+		}
+	}
+	return invitations, nil
+}
+
+func (mdb *mockInvitationDB) CreateInvitation(ctx context.Context, arg storage.CreateInvitationParams) (*models.Invitation, error) {
+	invitation := &models.Invitation{
+		Id:             primitive.NewObjectID(),
+		OrganizationId: arg.OrganizationId,
+		Token:          arg.Token,
+		PhoneNumber:    arg.PhoneNumber,
+		Role:           arg.Role,
+		MaxUses:        arg.MaxUses,
+		CreatedBy:      arg.CreatedBy,
+		CreatedAt:      time.Now(),
+		ExpiresAt:      arg.ExpiresAt,
+	}
+	mdb.invitations = append(mdb.invitations, invitation)
+	return invitation, nil
+}
+
+func (mdb *mockInvitationDB) GetInvitation(ctx context.Context, arg storage.GetInvitationParams) (*models.Invitation, error) {
+	return mdb.find(arg.Id, arg.OrganizationId), nil
+}
+
+func (mdb *mockInvitationDB) GetInvitationByToken(ctx context.Context, arg storage.GetInvitationByTokenParams) (*models.Invitation, error) {
+	for _, invitation := range mdb.invitations {
+		if invitation.Token == arg.Token {
+			return invitation, nil
+		}
+	}
+	return nil, nil
+}
+
+func (mdb *mockInvitationDB) GetOrganization(ctx context.Context, arg storage.GetOrganizationParams) (*models.Organization, error) {
+	if arg.Id != mdb.organization.Id {
+		return nil, nil
+	}
+	return mdb.organization, nil
+}
+
+func (mdb *mockInvitationDB) MarkInvitationSent(ctx context.Context, arg storage.MarkInvitationSentParams) (*models.Invitation, error) {
+	invitation := mdb.find(arg.Id, arg.OrganizationId)
+	if invitation == nil {
+		return nil, nil
+	}
+	invitation.SentAt = &arg.SentAt
+	invitation.ExpiresAt = arg.ExpiresAt
+	return invitation, nil
+}
+
+func (mdb *mockInvitationDB) RevokeInvitation(ctx context.Context, arg storage.RevokeInvitationParams) (*models.Invitation, error) {
+	invitation := mdb.find(arg.Id, arg.OrganizationId)
+	if invitation == nil {
+		return nil, nil
+	}
+	now := time.Now()
+	invitation.RevokedAt = &now
+	return invitation, nil
+}
+
+// mockInvitationSender records the invitations sent
+type mockInvitationSender struct {
+	err      error
+	messages []services.InvitationMessage
+}
+
+func (sender *mockInvitationSender) SendInvitation(ctx context.Context, message services.InvitationMessage) (string, error) {
+	if sender.err != nil {
+		return "", sender.err
+	}
+	sender.messages = append(sender.messages, message)
+	return "sent", nil
+}
+
+func (sender *mockInvitationSender) SendJoinReview(ctx context.Context, message services.JoinReviewMessage) (string, error) {
+	return "sent", nil
+}
+
+// newInvitations returns an organization with an invitation to a phone number, an open one,
+// an expired one, a used one and a revoked one
+func newInvitations() *mockInvitationDB {
+	organization := &models.Organization{
+		Id:   primitive.NewObjectID(),
+		Name: sfaker.Company().Name(),
+	}
+	now := time.Now()
+	revokedAt := now.Add(-time.Minute)
+	db := &mockInvitationDB{organization: organization}
+	for _, invitation := range []models.Invitation{
+		{PhoneNumber: "+33 6 12 34 56 78", MaxUses: 1, ExpiresAt: now.Add(time.Hour)},
+		{MaxUses: 10, Uses: 3, ExpiresAt: now.Add(time.Hour)},
+		{PhoneNumber: "+33 6 00 00 00 01", MaxUses: 1, ExpiresAt: now.Add(-time.Hour)},
+		{PhoneNumber: "+33 6 00 00 00 02", MaxUses: 1, Uses: 1, ExpiresAt: now.Add(time.Hour)},
+		{PhoneNumber: "+33 6 00 00 00 03", MaxUses: 1, ExpiresAt: now.Add(time.Hour), RevokedAt: &revokedAt},
+	} {
+		invitation := invitation
+		invitation.Id = primitive.NewObjectID()
+		invitation.OrganizationId = organization.Id
+		invitation.Token = services.GenerateInvitationToken()
+		invitation.Role = models.RoleEditor
+		invitation.CreatedAt = now.Add(-2 * time.Hour)
+		db.invitations = append(db.invitations, &invitation)
+	}
+	return db
+}
+
+func TestGetInvitations(t *testing.T) {
+	handler := handlers.NewAppHandler()
+	db := newInvitations()
+
+	mux := chi.NewMux()
+	handler.GetInvitations(mux, db)
+	_, w, response := helpertest.MakeGetRequest(mux, "/", []helpertest.ContextData{{Name: "organization", Value: db.organization}})
+	if w.StatusCode != http.StatusOK {
+		t.Fatalf("GetInvitations(): status - got %d; want %d (%s)", w.StatusCode, http.StatusOK, response)
+	}
+
+	got := handlers.GetInvitationsResponse{}
+	json.Unmarshal([]byte(response), &got)
+	if len(got.Invitations) != 4 {
+		t.Fatalf("GetInvitations(): got %d invitations; want the 4 not revoked", len(got.Invitations))
+	}
+}
+
+func TestCreateInvitation(t *testing.T) {
+	handler := handlers.NewAppHandler()
+	handler.GetAuthenticatedUser = func(req *http.Request) *models.User {
+		return authenticatedUser
+	}
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(48 * time.Hour).Truncate(time.Second)
+
+	testCases := map[string]struct {
+		Input         handlers.CreateInvitationRequest
+		SendErr       error
+		ResponseError string
+		Want          models.Invitation
+		Sent          bool
+	}{
+		"owner role": {
+			Input:         handlers.CreateInvitationRequest{PhoneNumber: "+33612345678", Role: models.RoleOwner},
+			ResponseError: "ERR_INV_CRT_ROLE",
+		},
+		"unknown role": {
+			Input:         handlers.CreateInvitationRequest{PhoneNumber: "+33612345678", Role: "boss"},
+			ResponseError: "ERR_INV_CRT_ROLE",
+		},
+		"open without uses": {
+			Input:         handlers.CreateInvitationRequest{},
+			ResponseError: "ERR_INV_CRT_MAX_USES",
+		},
+		"already expired": {
+			Input:         handlers.CreateInvitationRequest{MaxUses: 5, ExpiresAt: &past},
+			ResponseError: "ERR_INV_CRT_EXPIRES_AT",
+		},
+		"open invitation": {
+			Input: handlers.CreateInvitationRequest{MaxUses: 5, Role: models.RoleViewer, ExpiresAt: &future},
+			Want:  models.Invitation{MaxUses: 5, Role: models.RoleViewer, ExpiresAt: future},
+		},
+		"invitation to a phone number": {
+			Input: handlers.CreateInvitationRequest{PhoneNumber: " +33612345678 ", MaxUses: 5},
+			Want:  models.Invitation{PhoneNumber: "+33612345678", MaxUses: 1, Role: models.RoleEditor},
+			Sent:  true,
+		},
+		"invitation not sent": {
+			Input:   handlers.CreateInvitationRequest{PhoneNumber: "+33612345678"},
+			SendErr: errors.New("an error happens"),
+			Want:    models.Invitation{PhoneNumber: "+33612345678", MaxUses: 1, Role: models.RoleEditor},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			db := newInvitations()
+			sender := &mockInvitationSender{err: tc.SendErr}
+
+			mux := chi.NewMux()
+			handler.CreateInvitation(mux, db, sender)
+			code, _, response := helpertest.MakePostRequest(
+				mux,
+				"/",
+				helpertest.CreateFormHeader(),
+				tc.Input,
+				[]helpertest.ContextData{{Name: "organization", Value: db.organization}},
+			)
+
+			if tc.ResponseError != "" {
+				if code != http.StatusBadRequest {
+					t.Fatalf("CreateInvitation(): status - got %d; want %d", code, http.StatusBadRequest)
+				}
+				if response != tc.ResponseError {
+					t.Fatalf("CreateInvitation(): response error - got %s; want %s", response, tc.ResponseError)
+				}
+				return
+			}
+
+			if code != http.StatusOK {
+				t.Fatalf("CreateInvitation(): status - got %d; want %d (%s)", code, http.StatusOK, response)
+			}
+			got := handlers.InvitationResponse{}
+			json.Unmarshal([]byte(response), &got)
+			invitation := got.Invitation
+			if invitation.Token == "" || !strings.HasSuffix(got.Link, "/join/"+invitation.Token) {
+				t.Fatalf("CreateInvitation(): link - got %s; want the link of the token %s", got.Link, invitation.Token)
+			}
+			if invitation.PhoneNumber != tc.Want.PhoneNumber || invitation.MaxUses != tc.Want.MaxUses || invitation.Role != tc.Want.Role {
+				t.Fatalf("CreateInvitation(): got %+v; want %+v", invitation, tc.Want)
+			}
+			if !tc.Want.ExpiresAt.IsZero() && !invitation.ExpiresAt.Equal(tc.Want.ExpiresAt) {
+				t.Fatalf("CreateInvitation(): expires at - got %s; want %s", invitation.ExpiresAt, tc.Want.ExpiresAt)
+			}
+			if invitation.CreatedBy.Id != authenticatedUser.Id {
+				t.Fatalf("CreateInvitation(): created by %s; want %s", invitation.CreatedBy.Id.Hex(), authenticatedUser.Id.Hex())
+			}
+
+			if tc.Sent != (len(sender.messages) == 1) || tc.Sent != (invitation.SentAt != nil) {
+				t.Fatalf("CreateInvitation(): sent - got %d messages and %v; want %v", len(sender.messages), invitation.SentAt, tc.Sent)
+			}
+			if tc.Sent && sender.messages[0].Link != got.Link {
+				t.Fatalf("CreateInvitation(): link sent - got %s; want %s", sender.messages[0].Link, got.Link)
+			}
+		})
+	}
+}
+
+func TestResendInvitation(t *testing.T) {
+	handler := handlers.NewAppHandler()
+
+	testCases := map[string]struct {
+		InvitationId  func(db *mockInvitationDB) string
+		SendErr       error
+		Status        int
+		ResponseError string
+	}{
+		"invalid id": {
+			InvitationId:  func(db *mockInvitationDB) string { return "not-an-id" },
+			Status:        http.StatusBadRequest,
+			ResponseError: "ERR_INV_RSD_01",
+		},
+		"unknown invitation": {
+			InvitationId:  func(db *mockInvitationDB) string { return primitive.NewObjectID().Hex() },
+			Status:        http.StatusNotFound,
+			ResponseError: "ERR_INV_RSD_03",
+		},
+		"revoked invitation": {
+			InvitationId:  func(db *mockInvitationDB) string { return db.invitations[4].Id.Hex() },
+			Status:        http.StatusNotFound,
+			ResponseError: "ERR_INV_RSD_03",
+		},
+		"open invitation": {
+			InvitationId:  func(db *mockInvitationDB) string { return db.invitations[1].Id.Hex() },
+			Status:        http.StatusBadRequest,
+			ResponseError: "ERR_INV_RSD_OPEN",
+		},
+		"used invitation": {
+			InvitationId:  func(db *mockInvitationDB) string { return db.invitations[3].Id.Hex() },
+			Status:        http.StatusBadRequest,
+			ResponseError: "ERR_INV_RSD_USED",
+		},
+		"error when sending": {
+			InvitationId:  func(db *mockInvitationDB) string { return db.invitations[0].Id.Hex() },
+			SendErr:       errors.New("an error happens"),
+			Status:        http.StatusBadRequest,
+			ResponseError: "ERR_INV_RSD_04",
+		},
+		"invitation sent again": {
+			InvitationId: func(db *mockInvitationDB) string { return db.invitations[0].Id.Hex() },
+			Status:       http.StatusOK,
+		},
+		"expired invitation sent again": {
+			InvitationId: func(db *mockInvitationDB) string { return db.invitations[2].Id.Hex() },
+			Status:       http.StatusOK,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			db := newInvitations()
+			sender := &mockInvitationSender{err: tc.SendErr}
+
+			mux := chi.NewMux()
+			handler.ResendInvitation(mux, db, sender)
+			code, _, response := helpertest.MakePostRequest(
+				mux,
+				"/"+tc.InvitationId(db)+"/resend?language=fr",
+				helpertest.CreateFormHeader(),
+				nil,
+				[]helpertest.ContextData{{Name: "organization", Value: db.organization}},
+			)
+			if code != tc.Status {
+				t.Fatalf("ResendInvitation(): status - got %d; want %d (%s)", code, tc.Status, response)
+			}
+			if tc.ResponseError != "" {
+				if response != tc.ResponseError {
+					t.Fatalf("ResendInvitation(): response error - got %s; want %s", response, tc.ResponseError)
+				}
+				return
+			}
+
+			got := handlers.InvitationResponse{}
+			json.Unmarshal([]byte(response), &got)
+			if got.Invitation.SentAt == nil || !got.Invitation.IsActive(time.Now()) {
+				t.Fatalf("ResendInvitation(): got %+v; want an active invitation sent", got.Invitation)
+			}
+			if len(sender.messages) != 1 || sender.messages[0].PhoneNumber != got.Invitation.PhoneNumber || sender.messages[0].Language != "fr" {
+				t.Fatalf("ResendInvitation(): messages - got %+v; want the link sent in french to %s", sender.messages, got.Invitation.PhoneNumber)
+			}
+		})
+	}
+}
+
+func TestRevokeInvitation(t *testing.T) {
+	handler := handlers.NewAppHandler()
+	db := newInvitations()
+	mux := chi.NewMux()
+	handler.RevokeInvitation(mux, db)
+
+	revoke := func(invitationId string) (int, string) {
+		code, _, response := helpertest.MakeDeleteRequest(
+			mux,
+			"/"+invitationId,
+			helpertest.CreateFormHeader(),
+			nil,
+			[]helpertest.ContextData{{Name: "organization", Value: db.organization}},
+		)
+		return code, response
+	}
+
+	code, response := revoke("not-an-id")
+	if code != http.StatusBadRequest || response != "ERR_INV_RVK_01" {
+		t.Fatalf("RevokeInvitation(): got %d %s; want %d ERR_INV_RVK_01", code, response, http.StatusBadRequest)
+	}
+
+	code, response = revoke(db.invitations[1].Id.Hex())
+	if code != http.StatusOK {
+		t.Fatalf("RevokeInvitation(): status - got %d; want %d (%s)", code, http.StatusOK, response)
+	}
+	got := handlers.RevokeInvitationResponse{}
+	json.Unmarshal([]byte(response), &got)
+	if got.Invitation.Id != db.invitations[1].Id || got.Invitation.RevokedAt == nil {
+		t.Fatalf("RevokeInvitation(): got %+v; want the invitation revoked", got.Invitation)
+	}
+
+	// an invitation is only revoked once
+	code, response = revoke(db.invitations[1].Id.Hex())
+	if code != http.StatusNotFound || response != "ERR_INV_RVK_03" {
+		t.Fatalf("RevokeInvitation(): got %d %s; want %d ERR_INV_RVK_03", code, response, http.StatusNotFound)
+	}
+}
+
+func TestInvitationMiddleware(t *testing.T) {
+	handler := handlers.NewAppHandler()
+	db := newInvitations()
+
+	mux := chi.NewMux()
+	mux.Route("/join/{invitationToken}", func(r chi.Router) {
+		handler.InvitationMiddleware(r, db)
+		handler.GetOrganizationFromInvitationToken(r, nil)
+	})
+
+	testCases := map[string]struct {
+		Token         string
+		Status        int
+		ResponseError string
+	}{
+		"unknown token": {
+			Token:         services.GenerateInvitationToken(),
+			Status:        http.StatusNotFound,
+			ResponseError: "ERR_CMP_MDW_03",
+		},
+		"expired invitation": {
+			Token:         db.invitations[2].Token,
+			Status:        http.StatusGone,
+			ResponseError: "ERR_CMP_MDW_04",
+		},
+		"used invitation": {
+			Token:         db.invitations[3].Token,
+			Status:        http.StatusGone,
+			ResponseError: "ERR_CMP_MDW_04",
+		},
+		"revoked invitation": {
+			Token:         db.invitations[4].Token,
+			Status:        http.StatusGone,
+			ResponseError: "ERR_CMP_MDW_04",
+		},
+		"open invitation": {
+			Token:  db.invitations[1].Token,
+			Status: http.StatusOK,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			_, w, response := helpertest.MakeGetRequest(mux, "/join/"+tc.Token, nil)
+			if w.StatusCode != tc.Status {
+				t.Fatalf("InvitationMiddleware(): status - got %d; want %d (%s)", w.StatusCode, tc.Status, response)
+			}
+			if tc.ResponseError != "" {
+				if response != tc.ResponseError {
+					t.Fatalf("InvitationMiddleware(): response error - got %s; want %s", response, tc.ResponseError)
+				}
+				return
+			}
+
+			got := handlers.GetOrganizationFromInvitationTokenResponse{}
+			json.Unmarshal([]byte(response), &got)
+			if got.Organization.Id != db.organization.Id || got.Role != models.RoleEditor {
+				t.Fatalf("GetOrganizationFromInvitationToken(): got %+v; want the organization %s", got, db.organization.Id.Hex())
+			}
+		})
+	}
+}
@@ -26,8 +26,8 @@ func TestOnboarding(t *testing.T) {
 	}
 
 	tests := map[string]func(*testing.T, *handlers.AppHandler){
-		"SetProfile":        testSetProfile,
-		"FirstOrganization": testFirstOrganization,
+		"UpdateProfile":     testUpdateProfile,
+		"SetUpOrganization": testSetUpOrganization,
 	}
 
 	for name, tc := range tests {
@@ -37,23 +37,28 @@ func TestOnboarding(t *testing.T) {
 	}
 }
 
-type mockSetProfileDB struct {
+type mockUpdateProfileDB struct {
 	UpdateUserProfileFunc     func(ctx context.Context, arg storage.UpdateUserProfileParams) (*models.User, error)
 	UpdateUserPreferencesFunc func(ctx context.Context, arg storage.UpdateUserPreferencesParams) (*models.User, error)
 }
 
-func (mdb *mockSetProfileDB) UpdateUserProfile(ctx context.Context, arg storage.UpdateUserProfileParams) (*models.User, error) {
+// DoesUserExists always finds the authenticated user
+func (mdb *mockUpdateProfileDB) DoesUserExists(ctx context.Context, arg storage.DoesUserExistsParams) (*models.User, error) {
+	return authenticatedUser, nil
+}
+
+func (mdb *mockUpdateProfileDB) UpdateUserProfile(ctx context.Context, arg storage.UpdateUserProfileParams) (*models.User, error) {
 	return mdb.UpdateUserProfileFunc(ctx, arg)
 }
 
-func (mdb *mockSetProfileDB) UpdateUserPreferences(ctx context.Context, arg storage.UpdateUserPreferencesParams) (*models.User, error) {
+func (mdb *mockUpdateProfileDB) UpdateUserPreferences(ctx context.Context, arg storage.UpdateUserPreferencesParams) (*models.User, error) {
 	return mdb.UpdateUserPreferencesFunc(ctx, arg)
 }
 
-func testSetProfile(t *testing.T, handler *handlers.AppHandler) {
+func testUpdateProfile(t *testing.T, handler *handlers.AppHandler) {
 	t.Run("invalid input data", func(t *testing.T) {
 		mux := chi.NewMux()
-		db := &mockSetProfileDB{
+		db := &mockUpdateProfileDB{
 			UpdateUserProfileFunc: func(ctx context.Context, arg storage.UpdateUserProfileParams) (*models.User, error) {
 				return nil, nil
 			},
@@ -62,27 +67,27 @@ func testSetProfile(t *testing.T, handler *handlers.AppHandler) {
 			},
 		}
 
-		handler.SetProfile(mux, db)
+		handler.UpdateProfile(mux, db)
 		code, _, response := helpertest.MakePostRequest(
 			mux,
-			"/name",
+			"/profile",
 			helpertest.CreateFormHeader(),
 			"{\"test\": \"that\"}",
 			[]helpertest.ContextData{},
 		)
 		wantCode := http.StatusBadRequest
 		if code != wantCode {
-			t.Fatalf("SetProfile(): code - got %d; want %d", code, wantCode)
+			t.Fatalf("UpdateProfile(): code - got %d; want %d", code, wantCode)
 		}
 		wantError := "ERR_HDL_PRB_"
 		if !strings.HasPrefix(response, wantError) {
-			t.Fatalf("SetProfile(): response error - got %s; want %s", response, wantError)
+			t.Fatalf("UpdateProfile(): response error - got %s; want %s", response, wantError)
 		}
 	})
 
 	t.Run("error update user name", func(t *testing.T) {
 		mux := chi.NewMux()
-		db := &mockSetProfileDB{
+		db := &mockUpdateProfileDB{
 			UpdateUserProfileFunc: func(ctx context.Context, arg storage.UpdateUserProfileParams) (*models.User, error) {
 				return nil, errors.New("update user's name failed")
 			},
@@ -91,10 +96,10 @@ func testSetProfile(t *testing.T, handler *handlers.AppHandler) {
 			},
 		}
 
-		handler.SetProfile(mux, db)
+		handler.UpdateProfile(mux, db)
 		code, _, response := helpertest.MakePostRequest(
 			mux,
-			"/name",
+			"/profile",
 			helpertest.CreateFormHeader(),
 			handlers.UpdateProfileRequest{
 				FirstName: gofaker.FirstName(),
@@ -104,11 +109,11 @@ func testSetProfile(t *testing.T, handler *handlers.AppHandler) {
 		)
 		wantCode := http.StatusBadRequest
 		if code != wantCode {
-			t.Fatalf("SetProfile(): code - got %d; want %d", code, wantCode)
+			t.Fatalf("UpdateProfile(): code - got %d; want %d", code, wantCode)
 		}
 		wantError := "ERR_OBD_SN_01"
 		if !strings.HasPrefix(response, wantError) {
-			t.Fatalf("SetProfile(): response error - got %s; want %s", response, wantError)
+			t.Fatalf("UpdateProfile(): response error - got %s; want %s", response, wantError)
 		}
 	})
 
@@ -124,15 +129,12 @@ func testSetProfile(t *testing.T, handler *handlers.AppHandler) {
 			LastName:    dataRequest.LastName,
 
 			Preferences: models.UserPreferences{
-				Organization: models.UserPreferencesOrganization{
-					Id:   primitive.NewObjectID(),
-					Name: sfaker.Company().Name(),
-				},
-				OnboardingStep: 1,
+				CurrentOrganizationId: primitive.NewObjectID(),
+				CurrentStatus:         "account-checked",
 			},
 		}
 		mux := chi.NewMux()
-		db := &mockSetProfileDB{
+		db := &mockUpdateProfileDB{
 			UpdateUserProfileFunc: func(ctx context.Context, arg storage.UpdateUserProfileParams) (*models.User, error) {
 				return &updatedUser, nil
 			},
@@ -141,21 +143,21 @@ func testSetProfile(t *testing.T, handler *handlers.AppHandler) {
 			},
 		}
 
-		handler.SetProfile(mux, db)
+		handler.UpdateProfile(mux, db)
 		code, _, response := helpertest.MakePostRequest(
 			mux,
-			"/name",
+			"/profile",
 			helpertest.CreateFormHeader(),
 			dataRequest,
 			[]helpertest.ContextData{},
 		)
 		wantCode := http.StatusBadRequest
 		if code != wantCode {
-			t.Fatalf("SetProfile(): code - got %d; want %d", code, wantCode)
+			t.Fatalf("UpdateProfile(): code - got %d; want %d", code, wantCode)
 		}
 		wantError := "ERR_OBD_SN_02"
 		if !strings.HasPrefix(response, wantError) {
-			t.Fatalf("SetProfile(): response error - got %s; want %s", response, wantError)
+			t.Fatalf("UpdateProfile(): response error - got %s; want %s", response, wantError)
 		}
 	})
 
@@ -171,15 +173,12 @@ func testSetProfile(t *testing.T, handler *handlers.AppHandler) {
 			LastName:    dataRequest.LastName,
 
 			Preferences: models.UserPreferences{
-				Organization: models.UserPreferencesOrganization{
-					Id:   primitive.NewObjectID(),
-					Name: sfaker.Company().Name(),
-				},
-				OnboardingStep: 1,
+				CurrentOrganizationId: primitive.NewObjectID(),
+				CurrentStatus:         "account-checked",
 			},
 		}
 		mux := chi.NewMux()
-		db := &mockSetProfileDB{
+		db := &mockUpdateProfileDB{
 			UpdateUserProfileFunc: func(ctx context.Context, arg storage.UpdateUserProfileParams) (*models.User, error) {
 				return &updatedUser, nil
 			},
@@ -188,44 +187,53 @@ func testSetProfile(t *testing.T, handler *handlers.AppHandler) {
 			},
 		}
 
-		handler.SetProfile(mux, db)
+		handler.UpdateProfile(mux, db)
 		code, _, response := helpertest.MakePostRequest(
 			mux,
-			"/name",
+			"/profile",
 			helpertest.CreateFormHeader(),
 			dataRequest,
 			[]helpertest.ContextData{},
 		)
 		wantCode := http.StatusOK
 		if code != wantCode {
-			t.Fatalf("SetProfile(): code - got %d; want %d", code, wantCode)
+			t.Fatalf("UpdateProfile(): code - got %d; want %d", code, wantCode)
 		}
 
 		got := handlers.UpdateProfileResponse{}
 		json.Unmarshal([]byte(response), &got)
 		if !got.Done {
-			t.Fatalf("SetProfile(): response done - got %+v; want true", got.Done)
+			t.Fatalf("UpdateProfile(): response done - got %+v; want true", got.Done)
 		}
 	})
 }
 
-type mockFirstOrganizationDB struct {
+type mockSetUpOrganizationDB struct {
 	CreateOrganizationFunc    func(ctx context.Context, arg storage.CreateOrganizationParams) (*models.Organization, error)
 	UpdateUserPreferencesFunc func(ctx context.Context, arg storage.UpdateUserPreferencesParams) (*models.User, error)
 }
 
-func (mdb *mockFirstOrganizationDB) CreateOrganization(ctx context.Context, arg storage.CreateOrganizationParams) (*models.Organization, error) {
+// DoesUserExists always finds the authenticated user
+func (mdb *mockSetUpOrganizationDB) DoesUserExists(ctx context.Context, arg storage.DoesUserExistsParams) (*models.User, error) {
+	return authenticatedUser, nil
+}
+
+func (mdb *mockSetUpOrganizationDB) AddMemberIntoOrganization(ctx context.Context, arg storage.AddMemberIntoOrganizationParams) (*models.Member, error) {
+	return &models.Member{Id: primitive.NewObjectID(), OrganizationId: arg.OrganizationId, MemberId: arg.UserId}, nil
+}
+
+func (mdb *mockSetUpOrganizationDB) CreateOrganization(ctx context.Context, arg storage.CreateOrganizationParams) (*models.Organization, error) {
 	return mdb.CreateOrganizationFunc(ctx, arg)
 }
 
-func (mdb *mockFirstOrganizationDB) UpdateUserPreferences(ctx context.Context, arg storage.UpdateUserPreferencesParams) (*models.User, error) {
+func (mdb *mockSetUpOrganizationDB) UpdateUserPreferences(ctx context.Context, arg storage.UpdateUserPreferencesParams) (*models.User, error) {
 	return mdb.UpdateUserPreferencesFunc(ctx, arg)
 }
 
-func testFirstOrganization(t *testing.T, handler *handlers.AppHandler) {
+func testSetUpOrganization(t *testing.T, handler *handlers.AppHandler) {
 	t.Run("invalid input data", func(t *testing.T) {
 		mux := chi.NewMux()
-		db := &mockFirstOrganizationDB{
+		db := &mockSetUpOrganizationDB{
 			CreateOrganizationFunc: func(ctx context.Context, arg storage.CreateOrganizationParams) (*models.Organization, error) {
 				return nil, nil
 			},
@@ -234,7 +242,7 @@ func testFirstOrganization(t *testing.T, handler *handlers.AppHandler) {
 			},
 		}
 
-		handler.FirstOrganization(mux, db)
+		handler.SetUpOrganization(mux, db)
 		code, _, response := helpertest.MakePostRequest(
 			mux,
 			"/organization",
@@ -243,11 +251,11 @@ func testFirstOrganization(t *testing.T, handler *handlers.AppHandler) {
 			[]helpertest.ContextData{},
 		)
 		if code != http.StatusBadRequest {
-			t.Fatalf("FirstOrganization(): status - got %d; want %d", code, http.StatusBadRequest)
+			t.Fatalf("SetUpOrganization(): status - got %d; want %d", code, http.StatusBadRequest)
 		}
 		want := "ERR_HDL_PRB_"
 		if !strings.HasPrefix(response, want) {
-			t.Fatalf("FirstOrganization(): response error - got %s, want %s", response, want)
+			t.Fatalf("SetUpOrganization(): response error - got %s, want %s", response, want)
 		}
 	})
 
@@ -257,7 +265,7 @@ func testFirstOrganization(t *testing.T, handler *handlers.AppHandler) {
 			Bio:  gofaker.Paragraph(),
 		}
 		mux := chi.NewMux()
-		db := &mockFirstOrganizationDB{
+		db := &mockSetUpOrganizationDB{
 			CreateOrganizationFunc: func(ctx context.Context, arg storage.CreateOrganizationParams) (*models.Organization, error) {
 				return nil, errors.New("create organization error")
 			},
@@ -266,7 +274,7 @@ func testFirstOrganization(t *testing.T, handler *handlers.AppHandler) {
 			},
 		}
 
-		handler.FirstOrganization(mux, db)
+		handler.SetUpOrganization(mux, db)
 		code, _, response := helpertest.MakePostRequest(
 			mux,
 			"/organization",
@@ -275,11 +283,11 @@ func testFirstOrganization(t *testing.T, handler *handlers.AppHandler) {
 			[]helpertest.ContextData{},
 		)
 		if code != http.StatusBadRequest {
-			t.Fatalf("FirstOrganization(): status - got %d; want %d", code, http.StatusBadRequest)
+			t.Fatalf("SetUpOrganization(): status - got %d; want %d", code, http.StatusBadRequest)
 		}
 		want := "ERR_OBD_CPN_01"
 		if response != want {
-			t.Fatalf("FirstOrganization(): response error - got %s, want %s", response, want)
+			t.Fatalf("SetUpOrganization(): response error - got %s, want %s", response, want)
 		}
 	})
 
@@ -297,7 +305,7 @@ func testFirstOrganization(t *testing.T, handler *handlers.AppHandler) {
 		}
 
 		mux := chi.NewMux()
-		db := &mockFirstOrganizationDB{
+		db := &mockSetUpOrganizationDB{
 			CreateOrganizationFunc: func(ctx context.Context, arg storage.CreateOrganizationParams) (*models.Organization, error) {
 				return organization, nil
 			},
@@ -306,7 +314,7 @@ func testFirstOrganization(t *testing.T, handler *handlers.AppHandler) {
 			},
 		}
 
-		handler.FirstOrganization(mux, db)
+		handler.SetUpOrganization(mux, db)
 		code, _, response := helpertest.MakePostRequest(
 			mux,
 			"/organization",
@@ -316,11 +324,11 @@ func testFirstOrganization(t *testing.T, handler *handlers.AppHandler) {
 		)
 		wantCode := http.StatusBadRequest
 		if code != wantCode {
-			t.Fatalf("FirstOrganization(): code - got %d; want %d", code, wantCode)
+			t.Fatalf("SetUpOrganization(): code - got %d; want %d", code, wantCode)
 		}
 		wantError := "ERR_OBD_CPN_02"
 		if !strings.HasPrefix(response, wantError) {
-			t.Fatalf("FirstOrganization(): response error - got %s; want %s", response, wantError)
+			t.Fatalf("SetUpOrganization(): response error - got %s; want %s", response, wantError)
 		}
 	})
 
@@ -341,16 +349,13 @@ func testFirstOrganization(t *testing.T, handler *handlers.AppHandler) {
 			LastName:    gofaker.LastName(),
 
 			Preferences: models.UserPreferences{
-				Organization: models.UserPreferencesOrganization{
-					Id:   organization.Id,
-					Name: organization.Bio,
-				},
-				OnboardingStep: 1,
+				CurrentOrganizationId: organization.Id,
+				CurrentStatus:         "account-checked",
 			},
 		}
 
 		mux := chi.NewMux()
-		db := &mockFirstOrganizationDB{
+		db := &mockSetUpOrganizationDB{
 			CreateOrganizationFunc: func(ctx context.Context, arg storage.CreateOrganizationParams) (*models.Organization, error) {
 				return &organization, nil
 			},
@@ -359,7 +364,7 @@ func testFirstOrganization(t *testing.T, handler *handlers.AppHandler) {
 			},
 		}
 
-		handler.FirstOrganization(mux, db)
+		handler.SetUpOrganization(mux, db)
 		code, _, response := helpertest.MakePostRequest(
 			mux,
 			"/organization",
@@ -369,13 +374,13 @@ func testFirstOrganization(t *testing.T, handler *handlers.AppHandler) {
 		)
 		want := http.StatusOK
 		if code != want {
-			t.Fatalf("FirstOrganization(): status - got %d; want %d", code, want)
+			t.Fatalf("SetUpOrganization(): status - got %d; want %d", code, want)
 		}
 
 		got := handlers.SetUpOrganizationResponse{}
 		json.Unmarshal([]byte(response), &got)
 		if got.Id != organization.Id {
-			t.Fatalf("FirstOrganization(): response Id - got %s; want %s", got.Id, organization.Id)
+			t.Fatalf("SetUpOrganization(): response Id - got %s; want %s", got.Id, organization.Id)
 		}
 	})
 }
@@ -4,18 +4,22 @@ import (
 	"context"
 	"encoding/json"
 	"net/http"
+	"time"
 
 	"github.com/go-chi/chi/v5"
-	"github.com/google/uuid"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"stockinos.com/api/models"
+	"stockinos.com/api/services"
 	"stockinos.com/api/storage"
 )
 
 type getOrganizationCtxInterface interface {
 	GetOrganization(ctx context.Context, arg storage.GetOrganizationParams) (*models.Organization, error)
+	GetMember(ctx context.Context, arg storage.GetMemberParams) (*models.Member, error)
 }
 
+// OrganizationMiddleware loads the organization and the membership of the authenticated user in it.
+// The organizations the user is not a member of are not found.
 func (handler *AppHandler) OrganizationMiddleware(mux chi.Router, db getOrganizationCtxInterface) {
 	mux.Use(func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -41,23 +45,75 @@ func (handler *AppHandler) OrganizationMiddleware(mux chi.Router, db getOrganiza
 				return
 			}
 
+			// the API keys are scoped to their organization, their permissions are checked by RequirePermission
+			if apiKey := services.ApiKeyFromContext(ctx); apiKey != nil {
+				if apiKey.OrganizationId != organization.Id {
+					http.Error(w, "ERR_CMP_MDW_03", http.StatusNotFound)
+					return
+				}
+
+				ctx = context.WithValue(ctx, "organization", organization)
+				ctx = context.WithValue(ctx, "member", &models.Member{
+					OrganizationId: organization.Id,
+					MemberId:       apiKey.Id,
+					Status:         "confirmed",
+				})
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+
+			authUser := handler.GetAuthenticatedUser(r)
+			if authUser == nil {
+				http.Error(w, "ERR_CMP_MDW_04", http.StatusUnauthorized)
+				return
+			}
+
+			member, err := db.GetMember(ctx, storage.GetMemberParams{
+				OrganizationId: organization.Id,
+				UserId:         authUser.Id,
+			})
+			if err != nil {
+				http.Error(w, "ERR_CMP_MDW_05", http.StatusBadRequest)
+				return
+			}
+			if member == nil && organization.OwnedBy == authUser.Id {
+				// The organizations created before the roles have no member for their owner
+				member = &models.Member{
+					OrganizationId: organization.Id,
+					MemberId:       authUser.Id,
+					Status:         "confirmed",
+					Role:           models.RoleOwner,
+				}
+			}
+			if member == nil {
+				http.Error(w, "ERR_CMP_MDW_03", http.StatusNotFound)
+				return
+			}
+			// the join requests waiting for an admin
+			if member.Status == models.MemberStatusPending {
+				http.Error(w, "ERR_CMP_MDW_06", http.StatusForbidden)
+				return
+			}
+
 			ctx = context.WithValue(ctx, "organization", organization)
+			ctx = context.WithValue(ctx, "member", member)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	})
 }
 
 type getAllCompaniesInterface interface {
-	GetAllCompanies(ctx context.Context, arg storage.GetAllCompaniesParams) ([]*models.Organization, error)
+	GetAllCompanies(ctx context.Context, arg storage.GetAllCompaniesParams) ([]*models.UserOrganization, error)
 }
 
 type GetAllCompaniesResponse struct {
-	Companies []*models.Organization `json:"organizations,omitempty"`
+	Companies []*models.UserOrganization `json:"organizations,omitempty"`
 }
 
+// GetAllCompanies lists the organizations the user is a member of, with the role of the user in each
 func (handler *AppHandler) GetAllCompanies(mux chi.Router, db getAllCompaniesInterface) {
 	mux.Get("/", func(w http.ResponseWriter, r *http.Request) {
-		var organizations []*models.Organization
+		var organizations []*models.UserOrganization
 
 		ctx := r.Context()
 		authUser := handler.GetAuthenticatedUser(r)
@@ -85,6 +141,7 @@ func (handler *AppHandler) GetAllCompanies(mux chi.Router, db getAllCompaniesInt
 
 type createOrganizationInterface interface {
 	CreateOrganization(ctx context.Context, arg storage.CreateOrganizationParams) (*models.Organization, error)
+	AddMemberIntoOrganization(ctx context.Context, arg storage.AddMemberIntoOrganizationParams) (*models.Member, error)
 }
 
 type CreateOrganizationRequest struct {
@@ -109,17 +166,29 @@ func (handler *AppHandler) CreateOrganization(mux chi.Router, db createOrganizat
 		}
 
 		organization, err := db.CreateOrganization(ctx, storage.CreateOrganizationParams{
-			Name:            input.Name,
-			Bio:             input.Bio,
-			CreatedBy:       authUser.Id,
-			OwnedBy:         authUser.Id,
-			InvitationToken: uuid.New().String(),
+			Name:      input.Name,
+			Bio:       input.Bio,
+			CreatedBy: authUser.Id,
+			OwnedBy:   authUser.Id,
 		})
 		if err != nil {
 			http.Error(w, "ERR_C_CMP_01", http.StatusBadRequest)
 			return
 		}
 
+		now := time.Now()
+		_, err = db.AddMemberIntoOrganization(ctx, storage.AddMemberIntoOrganizationParams{
+			OrganizationId: organization.Id,
+			UserId:         authUser.Id,
+			InvitedAt:      now,
+			ConfirmedAt:    &now,
+			Role:           models.RoleOwner,
+		})
+		if err != nil {
+			http.Error(w, "ERR_C_CMP_02", http.StatusBadRequest)
+			return
+		}
+
 		response := CreateOrganizationResponse{
 			Organization: *organization,
 		}
@@ -167,6 +236,8 @@ type updateOrganizationInterface interface {
 type UpdateOrganizationRequest struct {
 	Name string `json:"name,omitempty"`
 	Bio  string `json:"description,omitempty"`
+	// models.JoinPolicyOpen or models.JoinPolicyApproval, unchanged when empty
+	JoinPolicy string `json:"join_policy,omitempty"`
 }
 
 type UpdateOrganizationResponse struct {
@@ -186,10 +257,16 @@ func (handler *AppHandler) UpdateOrganization(mux chi.Router, db updateOrganizat
 
 		organization := ctx.Value("organization").(*models.Organization)
 
+		if input.JoinPolicy != "" && !models.IsJoinPolicy(input.JoinPolicy) {
+			http.Error(w, "ERR_U_CMP_JOIN_POLICY", http.StatusBadRequest)
+			return
+		}
+
 		updatedOrganization, err := db.UpdateOrganization(ctx, storage.UpdateOrganizationParams{
-			Id:   organization.Id,
-			Name: input.Name,
-			Bio:  input.Bio,
+			Id:         organization.Id,
+			Name:       input.Name,
+			Bio:        input.Bio,
+			JoinPolicy: input.JoinPolicy,
 		})
 		if err != nil {
 			http.Error(w, "ERR_U_CMP_01", http.StatusBadRequest)
@@ -226,7 +303,8 @@ func (handler *AppHandler) DeleteOrganization(mux chi.Router, db deleteOrganizat
 		organization := ctx.Value("organization").(*models.Organization)
 
 		err := db.DeleteOrganization(ctx, storage.DeleteOrganizationParams{
-			Id: organization.Id,
+			Id:        organization.Id,
+			DeletedBy: dataAuthor(handler.GetAuthenticatedUser(r)),
 		})
 		if err != nil {
 			http.Error(w, "ERR_D_CMP_02", http.StatusBadRequest)
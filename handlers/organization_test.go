@@ -15,6 +15,7 @@ import (
 	"stockinos.com/api/handlers"
 	"stockinos.com/api/helpertest"
 	"stockinos.com/api/models"
+	"stockinos.com/api/services"
 	"stockinos.com/api/storage"
 	sfaker "syreclabs.com/go/faker"
 )
@@ -32,6 +33,7 @@ func TestOrganization(t *testing.T) {
 
 	tests := map[string]func(*testing.T, *handlers.AppHandler){
 		"OrganizationMiddleware": testOrganizationMiddleware,
+		"RequirePermission":      testRequirePermission,
 		"GetAllCompanies":        testGetAllCompanies,
 		"GetOrganization":        testGetOrganization,
 		"CreateOrganization":     testCreateOrganization,
@@ -48,12 +50,26 @@ func TestOrganization(t *testing.T) {
 
 type mockOrganizationMiddlewareDB struct {
 	GetOrganizationFunc func(ctx context.Context, arg storage.GetOrganizationParams) (*models.Organization, error)
+	GetMemberFunc       func(ctx context.Context, arg storage.GetMemberParams) (*models.Member, error)
 }
 
 func (mdb *mockOrganizationMiddlewareDB) GetOrganization(ctx context.Context, arg storage.GetOrganizationParams) (*models.Organization, error) {
 	return mdb.GetOrganizationFunc(ctx, arg)
 }
 
+// GetMember finds the user as a confirmed member unless the test needs another membership
+func (mdb *mockOrganizationMiddlewareDB) GetMember(ctx context.Context, arg storage.GetMemberParams) (*models.Member, error) {
+	if mdb.GetMemberFunc == nil {
+		return &models.Member{
+			OrganizationId: arg.OrganizationId,
+			MemberId:       arg.UserId,
+			Status:         models.MemberStatusConfirmed,
+			Role:           models.RoleEditor,
+		}, nil
+	}
+	return mdb.GetMemberFunc(ctx, arg)
+}
+
 func testOrganizationMiddleware(t *testing.T, handler *handlers.AppHandler) {
 	t.Run("invalid organization id", func(t *testing.T) {
 		mux := chi.NewMux()
@@ -182,11 +198,183 @@ func testOrganizationMiddleware(t *testing.T, handler *handlers.AppHandler) {
 
 }
 
+func testRequirePermission(t *testing.T, handler *handlers.AppHandler) {
+	organization := &models.Organization{
+		Id:      primitive.NewObjectID(),
+		Name:    sfaker.Company().Name(),
+		OwnedBy: primitive.NewObjectID(),
+	}
+	activityId := primitive.NewObjectID()
+	otherActivityId := primitive.NewObjectID()
+	dataPath := fmt.Sprintf("/%s/activities/%s/data", organization.Id.Hex(), activityId.Hex())
+	leavePath := fmt.Sprintf("/%s/team/leave", organization.Id.Hex())
+
+	member := func(status string, role string) *models.Member {
+		return &models.Member{
+			OrganizationId: organization.Id,
+			MemberId:       primitive.NewObjectID(),
+			Status:         status,
+			Role:           role,
+		}
+	}
+
+	testCases := map[string]struct {
+		Member        *models.Member
+		ApiKey        *models.ApiKey
+		Method        string
+		Target        string
+		Code          int
+		ResponseError string
+	}{
+		"not a member": {
+			Method:        http.MethodGet,
+			Target:        dataPath,
+			Code:          http.StatusNotFound,
+			ResponseError: "ERR_CMP_MDW_03",
+		},
+		"pending member": {
+			Member:        member(models.MemberStatusPending, models.RoleEditor),
+			Method:        http.MethodGet,
+			Target:        dataPath,
+			Code:          http.StatusForbidden,
+			ResponseError: "ERR_CMP_MDW_06",
+		},
+		"viewer on a read route": {
+			Member: member(models.MemberStatusConfirmed, models.RoleViewer),
+			Method: http.MethodGet,
+			Target: dataPath,
+			Code:   http.StatusOK,
+		},
+		"viewer on a write route": {
+			Member:        member(models.MemberStatusConfirmed, models.RoleViewer),
+			Method:        http.MethodPost,
+			Target:        dataPath,
+			Code:          http.StatusForbidden,
+			ResponseError: "ERR_PERM_DENIED",
+		},
+		"data entry on a write route": {
+			Member: member(models.MemberStatusConfirmed, models.RoleDataEntry),
+			Method: http.MethodPost,
+			Target: dataPath,
+			Code:   http.StatusOK,
+		},
+		"member on a member route": {
+			Member: member(models.MemberStatusConfirmed, models.RoleViewer),
+			Method: http.MethodPost,
+			Target: leavePath,
+			Code:   http.StatusOK,
+		},
+		"api key without the permission": {
+			ApiKey: &models.ApiKey{
+				Id:             primitive.NewObjectID(),
+				OrganizationId: organization.Id,
+				Scopes:         []models.ApiKeyScope{{Permission: models.PermDataRead}},
+			},
+			Method:        http.MethodPost,
+			Target:        dataPath,
+			Code:          http.StatusForbidden,
+			ResponseError: "ERR_PERM_DENIED",
+		},
+		"api key scoped to another activity": {
+			ApiKey: &models.ApiKey{
+				Id:             primitive.NewObjectID(),
+				OrganizationId: organization.Id,
+				Scopes:         []models.ApiKeyScope{{Permission: models.PermDataWrite, ActivityId: &otherActivityId}},
+			},
+			Method:        http.MethodPost,
+			Target:        dataPath,
+			Code:          http.StatusForbidden,
+			ResponseError: "ERR_PERM_DENIED",
+		},
+		"api key scoped to the activity": {
+			ApiKey: &models.ApiKey{
+				Id:             primitive.NewObjectID(),
+				OrganizationId: organization.Id,
+				Scopes:         []models.ApiKeyScope{{Permission: models.PermDataWrite, ActivityId: &activityId}},
+			},
+			Method: http.MethodPost,
+			Target: dataPath,
+			Code:   http.StatusOK,
+		},
+		"api key of another organization": {
+			ApiKey: &models.ApiKey{
+				Id:             primitive.NewObjectID(),
+				OrganizationId: primitive.NewObjectID(),
+			},
+			Method:        http.MethodGet,
+			Target:        dataPath,
+			Code:          http.StatusNotFound,
+			ResponseError: "ERR_CMP_MDW_03",
+		},
+		"api key on a member route": {
+			ApiKey: &models.ApiKey{
+				Id:             primitive.NewObjectID(),
+				OrganizationId: organization.Id,
+			},
+			Method:        http.MethodPost,
+			Target:        leavePath,
+			Code:          http.StatusForbidden,
+			ResponseError: "ERR_PERM_API_KEY",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			db := &mockOrganizationMiddlewareDB{
+				GetOrganizationFunc: func(ctx context.Context, arg storage.GetOrganizationParams) (*models.Organization, error) {
+					return organization, nil
+				},
+				GetMemberFunc: func(ctx context.Context, arg storage.GetMemberParams) (*models.Member, error) {
+					return tc.Member, nil
+				},
+			}
+			ok := func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}
+
+			mux := chi.NewMux()
+			// the API key authenticated by services.AuthenticateApiKey
+			mux.Use(func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if tc.ApiKey != nil {
+						r = r.WithContext(context.WithValue(r.Context(), services.ApiKeyKey, tc.ApiKey))
+					}
+					next.ServeHTTP(w, r)
+				})
+			})
+			mux.Route("/{organizationId}", func(r chi.Router) {
+				handler.OrganizationMiddleware(r, db)
+
+				r.With(handler.RequirePermission(models.PermDataRead)).Get("/activities/{activityId}/data", ok)
+				r.With(handler.RequirePermission(models.PermDataCreate)).Post("/activities/{activityId}/data", ok)
+				r.With(handler.RejectApiKeys).Post("/team/leave", ok)
+			})
+
+			var code int
+			var response string
+			if tc.Method == http.MethodGet {
+				var w *http.Response
+				_, w, response = helpertest.MakeGetRequest(mux, tc.Target, []helpertest.ContextData{})
+				code = w.StatusCode
+			} else {
+				code, _, response = helpertest.MakePostRequest(mux, tc.Target, helpertest.CreateFormHeader(), nil, []helpertest.ContextData{})
+			}
+
+			if code != tc.Code {
+				t.Fatalf("RequirePermission(): status - got %d; want %d (%s)", code, tc.Code, response)
+			}
+			if response != tc.ResponseError {
+				t.Fatalf("RequirePermission(): response error - got %s, want %s", response, tc.ResponseError)
+			}
+		})
+	}
+}
+
 type mockGetAllCompaniesDB struct {
-	GetAllCompaniesFunc func(ctx context.Context, arg storage.GetAllCompaniesParams) ([]*models.Organization, error)
+	GetAllCompaniesFunc func(ctx context.Context, arg storage.GetAllCompaniesParams) ([]*models.UserOrganization, error)
 }
 
-func (mdb *mockGetAllCompaniesDB) GetAllCompanies(ctx context.Context, arg storage.GetAllCompaniesParams) ([]*models.Organization, error) {
+func (mdb *mockGetAllCompaniesDB) GetAllCompanies(ctx context.Context, arg storage.GetAllCompaniesParams) ([]*models.UserOrganization, error) {
 	return mdb.GetAllCompaniesFunc(ctx, arg)
 }
 
@@ -194,7 +382,7 @@ func testGetAllCompanies(t *testing.T, handler *handlers.AppHandler) {
 	t.Run("error from db", func(t *testing.T) {
 		mux := chi.NewMux()
 		db := &mockGetAllCompaniesDB{
-			GetAllCompaniesFunc: func(ctx context.Context, arg storage.GetAllCompaniesParams) ([]*models.Organization, error) {
+			GetAllCompaniesFunc: func(ctx context.Context, arg storage.GetAllCompaniesParams) ([]*models.UserOrganization, error) {
 				return nil, errors.New("an error happens")
 			},
 		}
@@ -212,18 +400,21 @@ func testGetAllCompanies(t *testing.T, handler *handlers.AppHandler) {
 	})
 
 	t.Run("success", func(t *testing.T) {
-		var organizations []*models.Organization
+		var organizations []*models.UserOrganization
 
 		const NUM_COMPANIES_CREATED = 3
 		mux := chi.NewMux()
 
 		db := &mockGetAllCompaniesDB{
-			GetAllCompaniesFunc: func(ctx context.Context, arg storage.GetAllCompaniesParams) ([]*models.Organization, error) {
+			GetAllCompaniesFunc: func(ctx context.Context, arg storage.GetAllCompaniesParams) ([]*models.UserOrganization, error) {
 				for i := 0; i < NUM_COMPANIES_CREATED; i++ {
-					organization := &models.Organization{
-						Id:   primitive.NewObjectID(),
-						Name: sfaker.Company().Name(),
-						Bio:  gofaker.Paragraph(),
+					organization := &models.UserOrganization{
+						Organization: models.Organization{
+							Id:   primitive.NewObjectID(),
+							Name: sfaker.Company().Name(),
+							Bio:  gofaker.Paragraph(),
+						},
+						Role: models.RoleOwner,
 					}
 					organizations = append(organizations, organization)
 				}
@@ -241,7 +432,7 @@ func testGetAllCompanies(t *testing.T, handler *handlers.AppHandler) {
 		got := handlers.GetAllCompaniesResponse{}
 		json.Unmarshal([]byte(response), &got)
 		for i, c := range got.Companies {
-			if err := organizationEq(c, organizations[i]); err != nil {
+			if err := organizationEq(&c.Organization, &organizations[i].Organization); err != nil {
 				t.Fatalf("GetOrganization(): %d - %v", i, err)
 			}
 		}
@@ -292,6 +483,10 @@ func (mdb *mockCreateOrganizationDB) CreateOrganization(ctx context.Context, arg
 	return mdb.CreateOrganizationFunc(ctx, arg)
 }
 
+func (mdb *mockCreateOrganizationDB) AddMemberIntoOrganization(ctx context.Context, arg storage.AddMemberIntoOrganizationParams) (*models.Member, error) {
+	return &models.Member{Id: primitive.NewObjectID(), OrganizationId: arg.OrganizationId, MemberId: arg.UserId, Role: arg.Role}, nil
+}
+
 func testCreateOrganization(t *testing.T, handler *handlers.AppHandler) {
 	t.Run("invalid input data", func(t *testing.T) {
 		mux := chi.NewMux()
@@ -447,7 +642,7 @@ func testUpdateOrganization(t *testing.T, handler *handlers.AppHandler) {
 		if code != wantCode {
 			t.Fatalf("UpdateOrganization(): status - got %d; want %d", code, wantCode)
 		}
-		wantError := "ERR_U_CMP_02"
+		wantError := "ERR_U_CMP_01"
 		if response != wantError {
 			t.Fatalf("UpdateOrganization(): response error - got %s, want %s", response, wantError)
 		}
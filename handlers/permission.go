@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"stockinos.com/api/models"
+	"stockinos.com/api/services"
+)
+
+// RequirePermission lets through the members of the organization whose role has the permission,
+// and the API keys with the permission in their scopes.
+// It is used on the routes of an organization, after OrganizationMiddleware.
+func (handler *AppHandler) RequirePermission(permission models.Permission) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if apiKey := services.ApiKeyFromContext(r.Context()); apiKey != nil {
+				// zero on the routes not about an activity
+				activityId, _ := primitive.ObjectIDFromHex(chi.URLParam(r, "activityId"))
+				if !apiKey.Can(permission, activityId) {
+					http.Error(w, "ERR_PERM_DENIED", http.StatusForbidden)
+					return
+				}
+
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			member, _ := r.Context().Value("member").(*models.Member)
+			if member == nil || !member.Can(permission) {
+				http.Error(w, "ERR_PERM_DENIED", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RejectApiKeys keeps the routes of the members to them, the API keys only have access
+// to the routes of their organization
+func (handler *AppHandler) RejectApiKeys(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if services.ApiKeyFromContext(r.Context()) != nil {
+			http.Error(w, "ERR_PERM_API_KEY", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
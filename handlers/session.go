@@ -0,0 +1,333 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/fatih/structs"
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"stockinos.com/api/models"
+	"stockinos.com/api/services"
+	"stockinos.com/api/storage"
+)
+
+// sessionRevoker refuses at once the access tokens of the revoked sessions, see services.SessionRevocations
+type sessionRevoker interface {
+	Revoked(sessionIds ...string)
+}
+
+type refreshTokenInterface interface {
+	RotateSession(ctx context.Context, arg storage.RotateSessionParams) (*models.Session, error)
+	GetSessionByRefreshToken(ctx context.Context, arg storage.GetSessionByRefreshTokenParams) (*models.Session, error)
+	RevokeSession(ctx context.Context, arg storage.RevokeSessionParams) (*models.Session, error)
+	GetUserById(ctx context.Context, arg storage.GetUserByIdParams) (*models.User, error)
+}
+
+// RefreshTokenRequest is sent by the clients which do not keep the cookies, the mobile apps and the scripts.
+// They can also send the refresh token as a Bearer token.
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type RefreshTokenResponse struct {
+	ExpiresAt time.Time `json:"expires_at"` // of the new access token
+	// Only for a refresh token not sent as a cookie: the browsers keep the tokens out of reach of the scripts
+	AccessToken  string `json:"access_token,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+}
+
+// RefreshToken issues a new access token and a new refresh token for the session of the refresh token,
+// read from the cookie, or else from the body or the Authorization header.
+// A refresh token can be used once, when an old one is used again it has been stolen and the session is revoked.
+func (appHandler *AppHandler) RefreshToken(mux chi.Router, db refreshTokenInterface, revocations sessionRevoker) {
+	mux.Post("/refresh", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		refreshToken := services.TokenFromRefreshCookie(r)
+		fromCookie := refreshToken != ""
+		if !fromCookie {
+			refreshToken = refreshTokenFromRequest(r)
+		}
+		if refreshToken == "" {
+			http.Error(w, "ERR_AUTH_RFH_01", http.StatusUnauthorized)
+			return
+		}
+		refreshTokenHash := services.HashRefreshToken(refreshToken)
+
+		newRefreshToken, newRefreshTokenHash, err := services.GenerateRefreshToken()
+		if err != nil {
+			log.Println("error when generating the refresh token: ", err)
+			http.Error(w, "ERR_AUTH_RFH_02", http.StatusBadRequest)
+			return
+		}
+
+		config := services.GetSessionConfig()
+		session, err := db.RotateSession(ctx, storage.RotateSessionParams{
+			RefreshTokenHash:    refreshTokenHash,
+			NewRefreshTokenHash: newRefreshTokenHash,
+			ExpiresAt:           time.Now().Add(config.RefreshTTL),
+		})
+		if err != nil {
+			log.Println("error when rotating the refresh token: ", err)
+			http.Error(w, "ERR_AUTH_RFH_03", http.StatusBadRequest)
+			return
+		}
+		if session == nil {
+			reused, err := db.GetSessionByRefreshToken(ctx, storage.GetSessionByRefreshTokenParams{
+				RefreshTokenHash: refreshTokenHash,
+			})
+			if err == nil && reused != nil && reused.RevokedAt == nil && reused.PreviousRefreshTokenHash == refreshTokenHash {
+				log.Println("refresh token used again, revoking the session: ", reused.Id.Hex())
+				if _, err := db.RevokeSession(ctx, storage.RevokeSessionParams{Id: reused.Id}); err != nil {
+					log.Println("error when revoking the session: ", err)
+				}
+				revocations.Revoked(reused.Id.Hex())
+			}
+
+			services.ClearAuthCookies(w)
+			http.Error(w, "ERR_AUTH_RFH_04", http.StatusUnauthorized)
+			return
+		}
+
+		user, err := db.GetUserById(ctx, storage.GetUserByIdParams{Id: session.UserId})
+		if err != nil || user == nil {
+			http.Error(w, "ERR_AUTH_RFH_05", http.StatusUnauthorized)
+			return
+		}
+
+		accessToken, err := services.GenerateJwtToken(structs.Map(user), session.Id.Hex())
+		if err != nil {
+			log.Println("error when creating the token: ", err)
+			http.Error(w, "ERR_AUTH_RFH_06", http.StatusBadRequest)
+			return
+		}
+
+		services.SetAuthCookies(w, accessToken, newRefreshToken)
+
+		response := RefreshTokenResponse{
+			ExpiresAt: time.Now().Add(config.AccessTTL),
+		}
+		if !fromCookie {
+			response.AccessToken = accessToken
+			response.RefreshToken = newRefreshToken
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			http.Error(w, "ERR_AUTH_RFH_END", http.StatusBadRequest)
+			return
+		}
+	})
+}
+
+// refreshTokenFromRequest reads the refresh token of a client without cookies, from the body or the Authorization header
+func refreshTokenFromRequest(r *http.Request) string {
+	var input RefreshTokenRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 4096)).Decode(&input); err == nil && input.RefreshToken != "" {
+		return input.RefreshToken
+	}
+
+	refreshToken, err := services.TokenFromHeader(r)
+	if err != nil {
+		return ""
+	}
+	return refreshToken
+}
+
+type logoutInterface interface {
+	GetSessionByRefreshToken(ctx context.Context, arg storage.GetSessionByRefreshTokenParams) (*models.Session, error)
+	RevokeSession(ctx context.Context, arg storage.RevokeSessionParams) (*models.Session, error)
+}
+
+type LogoutResponse struct {
+	Done bool `json:"done"`
+}
+
+// Logout revokes the session of the request, found from its access token or, once expired, from its refresh token
+func (appHandler *AppHandler) Logout(mux chi.Router, db logoutInterface, revocations sessionRevoker) {
+	mux.Post("/logout", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		sessionId, ok := services.SessionIdFromContext(ctx)
+		if !ok {
+			if refreshToken := services.TokenFromRefreshCookie(r); refreshToken != "" {
+				session, err := db.GetSessionByRefreshToken(ctx, storage.GetSessionByRefreshTokenParams{
+					RefreshTokenHash: services.HashRefreshToken(refreshToken),
+				})
+				if err != nil {
+					http.Error(w, "ERR_AUTH_LGT_01", http.StatusBadRequest)
+					return
+				}
+				if session != nil {
+					sessionId, ok = session.Id, true
+				}
+			}
+		}
+
+		if ok {
+			if _, err := db.RevokeSession(ctx, storage.RevokeSessionParams{Id: sessionId}); err != nil {
+				http.Error(w, "ERR_AUTH_LGT_02", http.StatusBadRequest)
+				return
+			}
+			revocations.Revoked(sessionId.Hex())
+		}
+
+		services.ClearAuthCookies(w)
+
+		response := LogoutResponse{
+			Done: true,
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			http.Error(w, "ERR_AUTH_LGT_END", http.StatusBadRequest)
+			return
+		}
+	})
+}
+
+type getSessionsInterface interface {
+	GetUserSessions(ctx context.Context, arg storage.GetUserSessionsParams) ([]*models.Session, error)
+}
+
+type GetSessionsResponse struct {
+	Sessions []*models.Session `json:"sessions"`
+}
+
+// GetSessions lists the devices the user is signed in on, the latest used first
+func (appHandler *AppHandler) GetSessions(mux chi.Router, db getSessionsInterface) {
+	mux.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		user := appHandler.GetAuthenticatedUser(r)
+
+		sessions, err := db.GetUserSessions(ctx, storage.GetUserSessionsParams{
+			UserId: user.Id,
+		})
+		if err != nil {
+			http.Error(w, "ERR_SESS_GALL_01", http.StatusBadRequest)
+			return
+		}
+
+		currentId, _ := services.SessionIdFromContext(ctx)
+		for _, session := range sessions {
+			session.Current = session.Id == currentId
+		}
+
+		response := GetSessionsResponse{
+			Sessions: sessions,
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			http.Error(w, "ERR_SESS_GALL_END", http.StatusBadRequest)
+			return
+		}
+	})
+}
+
+type revokeSessionInterface interface {
+	RevokeSession(ctx context.Context, arg storage.RevokeSessionParams) (*models.Session, error)
+}
+
+type RevokeSessionResponse struct {
+	Session models.Session `json:"session"`
+}
+
+// RevokeSession signs out one of the devices of the user, a lost phone for instance
+func (appHandler *AppHandler) RevokeSession(mux chi.Router, db revokeSessionInterface, revocations sessionRevoker) {
+	mux.Delete("/{sessionId}", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		user := appHandler.GetAuthenticatedUser(r)
+
+		sessionId, err := primitive.ObjectIDFromHex(chi.URLParam(r, "sessionId"))
+		if err != nil {
+			http.Error(w, "ERR_SESS_RVK_01", http.StatusBadRequest)
+			return
+		}
+
+		session, err := db.RevokeSession(ctx, storage.RevokeSessionParams{
+			Id:     sessionId,
+			UserId: user.Id,
+		})
+		if err != nil {
+			http.Error(w, "ERR_SESS_RVK_02", http.StatusBadRequest)
+			return
+		}
+		if session == nil {
+			http.Error(w, "ERR_SESS_RVK_03", http.StatusNotFound)
+			return
+		}
+		revocations.Revoked(session.Id.Hex())
+
+		if currentId, _ := services.SessionIdFromContext(ctx); currentId == session.Id {
+			services.ClearAuthCookies(w)
+		}
+
+		response := RevokeSessionResponse{
+			Session: *session,
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			http.Error(w, "ERR_SESS_RVK_END", http.StatusBadRequest)
+			return
+		}
+	})
+}
+
+type revokeOtherSessionsInterface interface {
+	RevokeOtherSessions(ctx context.Context, arg storage.RevokeOtherSessionsParams) ([]primitive.ObjectID, error)
+}
+
+type RevokeOtherSessionsResponse struct {
+	Revoked int `json:"revoked"`
+}
+
+// RevokeOtherSessions signs the user out of all the devices but the one of the request
+func (appHandler *AppHandler) RevokeOtherSessions(mux chi.Router, db revokeOtherSessionsInterface, revocations sessionRevoker) {
+	mux.Delete("/", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		user := appHandler.GetAuthenticatedUser(r)
+
+		currentId, ok := services.SessionIdFromContext(ctx)
+		if !ok {
+			http.Error(w, "ERR_SESS_RVKO_01", http.StatusBadRequest)
+			return
+		}
+
+		ids, err := db.RevokeOtherSessions(ctx, storage.RevokeOtherSessionsParams{
+			UserId:   user.Id,
+			ExceptId: currentId,
+		})
+		if err != nil {
+			http.Error(w, "ERR_SESS_RVKO_02", http.StatusBadRequest)
+			return
+		}
+
+		sessionIds := make([]string, len(ids))
+		for i, id := range ids {
+			sessionIds[i] = id.Hex()
+		}
+		revocations.Revoked(sessionIds...)
+
+		response := RevokeOtherSessionsResponse{
+			Revoked: len(ids),
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			http.Error(w, "ERR_SESS_RVKO_END", http.StatusBadRequest)
+			return
+		}
+	})
+}
@@ -0,0 +1,434 @@
+package handlers_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"stockinos.com/api/handlers"
+	"stockinos.com/api/models"
+	"stockinos.com/api/services"
+	"stockinos.com/api/storage"
+)
+
+// mockSessionDB keeps the sessions of the users, as the storage would
+type mockSessionDB struct {
+	users    []*models.User
+	sessions []*models.Session
+}
+
+func (mdb *mockSessionDB) RotateSession(ctx context.Context, arg storage.RotateSessionParams) (*models.Session, error) {
+	for _, session := range mdb.sessions {
+		if session.RefreshTokenHash == arg.RefreshTokenHash && session.RevokedAt == nil && session.ExpiresAt.After(time.Now()) {
+			session.PreviousRefreshTokenHash = session.RefreshTokenHash
+			session.RefreshTokenHash = arg.NewRefreshTokenHash
+			session.ExpiresAt = arg.ExpiresAt
+			return session, nil
+		}
+	}
+	return nil, nil
+}
+
+func (mdb *mockSessionDB) GetSessionByRefreshToken(ctx context.Context, arg storage.GetSessionByRefreshTokenParams) (*models.Session, error) {
+	for _, session := range mdb.sessions {
+		if session.RefreshTokenHash == arg.RefreshTokenHash || session.PreviousRefreshTokenHash == arg.RefreshTokenHash {
+			return session, nil
+		}
+	}
+	return nil, nil
+}
+
+func (mdb *mockSessionDB) RevokeSession(ctx context.Context, arg storage.RevokeSessionParams) (*models.Session, error) {
+	for _, session := range mdb.sessions {
+		if session.Id == arg.Id && session.RevokedAt == nil && (arg.UserId.IsZero() || session.UserId == arg.UserId) {
+			now := time.Now()
+			session.RevokedAt = &now
+			return session, nil
+		}
+	}
+	return nil, nil
+}
+
+func (mdb *mockSessionDB) RevokeOtherSessions(ctx context.Context, arg storage.RevokeOtherSessionsParams) ([]primitive.ObjectID, error) {
+	ids := make([]primitive.ObjectID, 0)
+	for _, session := range mdb.sessions {
+		if session.UserId == arg.UserId && session.Id != arg.ExceptId && session.RevokedAt == nil {
+			now := time.Now()
+			session.RevokedAt = &now
+			ids = append(ids, session.Id)
+		}
+	}
+	return ids, nil
+}
+
+func (mdb *mockSessionDB) GetUserSessions(ctx context.Context, arg storage.GetUserSessionsParams) ([]*models.Session, error) {
+	sessions := make([]*models.Session, 0)
+	for _, session := range mdb.sessions {
+		if session.UserId == arg.UserId && session.RevokedAt == nil {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions, nil
+}
+
+func (mdb *mockSessionDB) GetUserById(ctx context.Context, arg storage.GetUserByIdParams) (*models.User, error) {
+	for _, user := range mdb.users {
+		if user.Id == arg.Id {
+			return user, nil
+		}
+	}
+	return nil, nil
+}
+
+// mockSessionRevoker records the sessions whose access tokens are refused
+type mockSessionRevoker struct {
+	revoked []string
+}
+
+func (revoker *mockSessionRevoker) Revoked(sessionIds ...string) {
+	revoker.revoked = append(revoker.revoked, sessionIds...)
+}
+
+// newSessions returns the sessions of the authenticated user on two devices and the one of another user,
+// with the refresh token of each session
+func newSessions() (*mockSessionDB, []string) {
+	otherUser := &models.User{Id: primitive.NewObjectID()}
+	db := &mockSessionDB{users: []*models.User{authenticatedUser, otherUser}}
+
+	refreshTokens := make([]string, 0)
+	for _, user := range []*models.User{authenticatedUser, authenticatedUser, otherUser} {
+		refreshToken, refreshTokenHash, _ := services.GenerateRefreshToken()
+		refreshTokens = append(refreshTokens, refreshToken)
+		db.sessions = append(db.sessions, &models.Session{
+			Id:               primitive.NewObjectID(),
+			UserId:           user.Id,
+			RefreshTokenHash: refreshTokenHash,
+			CreatedAt:        time.Now().Add(-time.Hour),
+			LastUsedAt:       time.Now().Add(-time.Minute),
+			ExpiresAt:        time.Now().Add(time.Hour),
+		})
+	}
+	return db, refreshTokens
+}
+
+// makeSessionRequest sends the request with the refresh token as a cookie and the session of its access token
+func makeSessionRequest(handler http.Handler, method string, target string, refreshToken string, sessionId primitive.ObjectID) *http.Response {
+	req := httptest.NewRequest(method, target, nil)
+	if refreshToken != "" {
+		req.AddCookie(&http.Cookie{Name: services.RefreshTokenCookie, Value: refreshToken})
+	}
+	if !sessionId.IsZero() {
+		req = req.WithContext(context.WithValue(req.Context(), services.JwtSessionKey, sessionId.Hex()))
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w.Result()
+}
+
+func readSessionResponse(t *testing.T, result *http.Response) string {
+	t.Helper()
+	body, err := io.ReadAll(result.Body)
+	if err != nil {
+		t.Fatalf("invalid response body: %v", err)
+	}
+	return strings.TrimSpace(string(body))
+}
+
+func findCookie(result *http.Response, name string) *http.Cookie {
+	for _, cookie := range result.Cookies() {
+		if cookie.Name == name {
+			return cookie
+		}
+	}
+	return nil
+}
+
+func TestRefreshToken(t *testing.T) {
+	handler := handlers.NewAppHandler()
+
+	refresh := func(db *mockSessionDB, revoker *mockSessionRevoker, refreshToken string) *http.Response {
+		mux := chi.NewMux()
+		handler.RefreshToken(mux, db, revoker)
+		return makeSessionRequest(mux, http.MethodPost, "/refresh", refreshToken, primitive.NilObjectID)
+	}
+
+	t.Run("without refresh token", func(t *testing.T) {
+		db, _ := newSessions()
+		result := refresh(db, &mockSessionRevoker{}, "")
+		response := readSessionResponse(t, result)
+		if result.StatusCode != http.StatusUnauthorized || response != "ERR_AUTH_RFH_01" {
+			t.Fatalf("RefreshToken(): got %d %s; want %d ERR_AUTH_RFH_01", result.StatusCode, response, http.StatusUnauthorized)
+		}
+	})
+
+	t.Run("unknown refresh token", func(t *testing.T) {
+		db, _ := newSessions()
+		revoker := &mockSessionRevoker{}
+		result := refresh(db, revoker, "unknown")
+		response := readSessionResponse(t, result)
+		if result.StatusCode != http.StatusUnauthorized || response != "ERR_AUTH_RFH_04" {
+			t.Fatalf("RefreshToken(): got %d %s; want %d ERR_AUTH_RFH_04", result.StatusCode, response, http.StatusUnauthorized)
+		}
+		if len(revoker.revoked) != 0 {
+			t.Fatalf("RefreshToken(): revoked %v; want no session revoked", revoker.revoked)
+		}
+	})
+
+	t.Run("rotated refresh token", func(t *testing.T) {
+		db, refreshTokens := newSessions()
+		revoker := &mockSessionRevoker{}
+		session := db.sessions[0]
+
+		result := refresh(db, revoker, refreshTokens[0])
+		response := readSessionResponse(t, result)
+		if result.StatusCode != http.StatusOK {
+			t.Fatalf("RefreshToken(): status - got %d; want %d (%s)", result.StatusCode, http.StatusOK, response)
+		}
+		accessCookie := findCookie(result, services.AccessTokenCookie)
+		refreshCookie := findCookie(result, services.RefreshTokenCookie)
+		if accessCookie == nil || accessCookie.Value == "" || refreshCookie == nil || refreshCookie.Value == "" {
+			t.Fatalf("RefreshToken(): cookies - got %v; want the new tokens", result.Cookies())
+		}
+		if refreshCookie.Value == refreshTokens[0] || services.HashRefreshToken(refreshCookie.Value) != session.RefreshTokenHash {
+			t.Fatalf("RefreshToken(): the refresh token is not rotated")
+		}
+
+		// the old refresh token is refused, and its session revoked as it was stolen
+		result = refresh(db, revoker, refreshTokens[0])
+		response = readSessionResponse(t, result)
+		if result.StatusCode != http.StatusUnauthorized || response != "ERR_AUTH_RFH_04" {
+			t.Fatalf("RefreshToken(): reused token - got %d %s; want %d ERR_AUTH_RFH_04", result.StatusCode, response, http.StatusUnauthorized)
+		}
+		if session.RevokedAt == nil || len(revoker.revoked) != 1 || revoker.revoked[0] != session.Id.Hex() {
+			t.Fatalf("RefreshToken(): revoked %v; want the session %s revoked", revoker.revoked, session.Id.Hex())
+		}
+		if cookie := findCookie(result, services.RefreshTokenCookie); cookie == nil || cookie.Value != "" {
+			t.Fatalf("RefreshToken(): cookie - got %v; want it cleared", cookie)
+		}
+
+		// the new refresh token goes with the revoked session
+		result = refresh(db, revoker, refreshCookie.Value)
+		if result.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("RefreshToken(): status - got %d; want %d", result.StatusCode, http.StatusUnauthorized)
+		}
+	})
+
+	t.Run("without cookies", func(t *testing.T) {
+		testCases := map[string]func(req *http.Request, refreshToken string){
+			"in the body": func(req *http.Request, refreshToken string) {
+				body, _ := json.Marshal(handlers.RefreshTokenRequest{RefreshToken: refreshToken})
+				req.Body = io.NopCloser(bytes.NewReader(body))
+				req.Header.Set("Content-Type", "application/json")
+			},
+			"in the Authorization header": func(req *http.Request, refreshToken string) {
+				req.Header.Set("Authorization", "Bearer "+refreshToken)
+			},
+		}
+
+		for name, send := range testCases {
+			t.Run(name, func(t *testing.T) {
+				db, refreshTokens := newSessions()
+				mux := chi.NewMux()
+				handler.RefreshToken(mux, db, &mockSessionRevoker{})
+				req := httptest.NewRequest(http.MethodPost, "/refresh", nil)
+				send(req, refreshTokens[0])
+				w := httptest.NewRecorder()
+				mux.ServeHTTP(w, req)
+
+				result := w.Result()
+				response := readSessionResponse(t, result)
+				if result.StatusCode != http.StatusOK {
+					t.Fatalf("RefreshToken(): status - got %d; want %d (%s)", result.StatusCode, http.StatusOK, response)
+				}
+				got := handlers.RefreshTokenResponse{}
+				json.Unmarshal([]byte(response), &got)
+				if got.AccessToken == "" || services.HashRefreshToken(got.RefreshToken) != db.sessions[0].RefreshTokenHash {
+					t.Fatalf("RefreshToken(): got %+v; want the new tokens in the body", got)
+				}
+			})
+		}
+
+		// the tokens of the browsers stay in their cookies
+		db, refreshTokens := newSessions()
+		result := refresh(db, &mockSessionRevoker{}, refreshTokens[0])
+		got := handlers.RefreshTokenResponse{}
+		json.Unmarshal([]byte(readSessionResponse(t, result)), &got)
+		if got.AccessToken != "" || got.RefreshToken != "" {
+			t.Fatalf("RefreshToken(): got %+v; want no token in the body of a request with cookies", got)
+		}
+	})
+
+	t.Run("user deleted", func(t *testing.T) {
+		db, refreshTokens := newSessions()
+		db.users = nil
+		result := refresh(db, &mockSessionRevoker{}, refreshTokens[0])
+		response := readSessionResponse(t, result)
+		if result.StatusCode != http.StatusUnauthorized || response != "ERR_AUTH_RFH_05" {
+			t.Fatalf("RefreshToken(): got %d %s; want %d ERR_AUTH_RFH_05", result.StatusCode, response, http.StatusUnauthorized)
+		}
+	})
+}
+
+func TestLogout(t *testing.T) {
+	handler := handlers.NewAppHandler()
+
+	testCases := map[string]struct {
+		RefreshToken func(refreshTokens []string) string
+		SessionId    func(db *mockSessionDB) primitive.ObjectID
+		Revoked      int // the index of the session revoked, -1 for none
+	}{
+		"with an access token": {
+			RefreshToken: func(refreshTokens []string) string { return refreshTokens[0] },
+			SessionId:    func(db *mockSessionDB) primitive.ObjectID { return db.sessions[1].Id },
+			Revoked:      1,
+		},
+		"with a refresh token": {
+			RefreshToken: func(refreshTokens []string) string { return refreshTokens[0] },
+			SessionId:    func(db *mockSessionDB) primitive.ObjectID { return primitive.NilObjectID },
+			Revoked:      0,
+		},
+		"signed out already": {
+			RefreshToken: func(refreshTokens []string) string { return "" },
+			SessionId:    func(db *mockSessionDB) primitive.ObjectID { return primitive.NilObjectID },
+			Revoked:      -1,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			db, refreshTokens := newSessions()
+			revoker := &mockSessionRevoker{}
+
+			mux := chi.NewMux()
+			handler.Logout(mux, db, revoker)
+			result := makeSessionRequest(mux, http.MethodPost, "/logout", tc.RefreshToken(refreshTokens), tc.SessionId(db))
+			response := readSessionResponse(t, result)
+			if result.StatusCode != http.StatusOK {
+				t.Fatalf("Logout(): status - got %d; want %d (%s)", result.StatusCode, http.StatusOK, response)
+			}
+			if cookie := findCookie(result, services.AccessTokenCookie); cookie == nil || cookie.Value != "" {
+				t.Fatalf("Logout(): cookie - got %v; want it cleared", cookie)
+			}
+
+			for i, session := range db.sessions {
+				if revoked := session.RevokedAt != nil; revoked != (i == tc.Revoked) {
+					t.Fatalf("Logout(): session %d revoked - got %v; want %v", i, revoked, i == tc.Revoked)
+				}
+			}
+			if tc.Revoked >= 0 && (len(revoker.revoked) != 1 || revoker.revoked[0] != db.sessions[tc.Revoked].Id.Hex()) {
+				t.Fatalf("Logout(): revoked %v; want the session %s", revoker.revoked, db.sessions[tc.Revoked].Id.Hex())
+			}
+		})
+	}
+}
+
+func TestSessions(t *testing.T) {
+	handler := handlers.NewAppHandler()
+	handler.GetAuthenticatedUser = func(req *http.Request) *models.User {
+		return authenticatedUser
+	}
+
+	t.Run("get sessions", func(t *testing.T) {
+		db, _ := newSessions()
+		mux := chi.NewMux()
+		handler.GetSessions(mux, db)
+		result := makeSessionRequest(mux, http.MethodGet, "/", "", db.sessions[1].Id)
+		response := readSessionResponse(t, result)
+		if result.StatusCode != http.StatusOK {
+			t.Fatalf("GetSessions(): status - got %d; want %d (%s)", result.StatusCode, http.StatusOK, response)
+		}
+
+		got := handlers.GetSessionsResponse{}
+		json.Unmarshal([]byte(response), &got)
+		if len(got.Sessions) != 2 {
+			t.Fatalf("GetSessions(): got %d sessions; want the 2 of the user", len(got.Sessions))
+		}
+		for _, session := range got.Sessions {
+			if session.Current != (session.Id == db.sessions[1].Id) {
+				t.Fatalf("GetSessions(): session %s current - got %v", session.Id.Hex(), session.Current)
+			}
+		}
+		if strings.Contains(response, db.sessions[0].RefreshTokenHash) {
+			t.Fatalf("GetSessions(): the refresh token hash is sent")
+		}
+	})
+
+	t.Run("revoke session", func(t *testing.T) {
+		db, _ := newSessions()
+		revoker := &mockSessionRevoker{}
+		mux := chi.NewMux()
+		handler.RevokeSession(mux, db, revoker)
+
+		testCases := []struct {
+			Name          string
+			SessionId     string
+			Status        int
+			ResponseError string
+			Cleared       bool
+		}{
+			{Name: "invalid id", SessionId: "not-an-id", Status: http.StatusBadRequest, ResponseError: "ERR_SESS_RVK_01"},
+			{Name: "session of another user", SessionId: db.sessions[2].Id.Hex(), Status: http.StatusNotFound, ResponseError: "ERR_SESS_RVK_03"},
+			{Name: "other device", SessionId: db.sessions[0].Id.Hex(), Status: http.StatusOK},
+			{Name: "other device again", SessionId: db.sessions[0].Id.Hex(), Status: http.StatusNotFound, ResponseError: "ERR_SESS_RVK_03"},
+			{Name: "current device", SessionId: db.sessions[1].Id.Hex(), Status: http.StatusOK, Cleared: true},
+		}
+
+		for _, tc := range testCases {
+			result := makeSessionRequest(mux, http.MethodDelete, "/"+tc.SessionId, "", db.sessions[1].Id)
+			response := readSessionResponse(t, result)
+			if result.StatusCode != tc.Status {
+				t.Fatalf("RevokeSession(%s): status - got %d; want %d (%s)", tc.Name, result.StatusCode, tc.Status, response)
+			}
+			if tc.ResponseError != "" && response != tc.ResponseError {
+				t.Fatalf("RevokeSession(%s): response error - got %s; want %s", tc.Name, response, tc.ResponseError)
+			}
+			if cleared := findCookie(result, services.AccessTokenCookie) != nil; cleared != tc.Cleared {
+				t.Fatalf("RevokeSession(%s): cookies cleared - got %v; want %v", tc.Name, cleared, tc.Cleared)
+			}
+		}
+
+		if db.sessions[2].RevokedAt != nil {
+			t.Fatalf("RevokeSession(): the session of another user is revoked")
+		}
+		want := []string{db.sessions[0].Id.Hex(), db.sessions[1].Id.Hex()}
+		if len(revoker.revoked) != 2 || revoker.revoked[0] != want[0] || revoker.revoked[1] != want[1] {
+			t.Fatalf("RevokeSession(): revoked %v; want %v", revoker.revoked, want)
+		}
+	})
+
+	t.Run("revoke other sessions", func(t *testing.T) {
+		db, _ := newSessions()
+		revoker := &mockSessionRevoker{}
+		mux := chi.NewMux()
+		handler.RevokeOtherSessions(mux, db, revoker)
+
+		// the current session is only known from an access token
+		result := makeSessionRequest(mux, http.MethodDelete, "/", "", primitive.NilObjectID)
+		response := readSessionResponse(t, result)
+		if result.StatusCode != http.StatusBadRequest || response != "ERR_SESS_RVKO_01" {
+			t.Fatalf("RevokeOtherSessions(): got %d %s; want %d ERR_SESS_RVKO_01", result.StatusCode, response, http.StatusBadRequest)
+		}
+
+		result = makeSessionRequest(mux, http.MethodDelete, "/", "", db.sessions[1].Id)
+		response = readSessionResponse(t, result)
+		if result.StatusCode != http.StatusOK {
+			t.Fatalf("RevokeOtherSessions(): status - got %d; want %d (%s)", result.StatusCode, http.StatusOK, response)
+		}
+		got := handlers.RevokeOtherSessionsResponse{}
+		json.Unmarshal([]byte(response), &got)
+		if got.Revoked != 1 || len(revoker.revoked) != 1 || revoker.revoked[0] != db.sessions[0].Id.Hex() {
+			t.Fatalf("RevokeOtherSessions(): got %d, revoked %v; want the session %s", got.Revoked, revoker.revoked, db.sessions[0].Id.Hex())
+		}
+		if db.sessions[1].RevokedAt != nil || db.sessions[2].RevokedAt != nil {
+			t.Fatalf("RevokeOtherSessions(): the current session or the one of another user is revoked")
+		}
+	})
+}
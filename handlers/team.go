@@ -3,33 +3,80 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"time"
 
 	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"stockinos.com/api/models"
+	"stockinos.com/api/services"
 	"stockinos.com/api/storage"
-	"stockinos.com/api/utils"
 )
 
 type getTeamInterface interface {
-	GetMembersFromOrganization(ctx context.Context, arg storage.GetMembersFromOrganizationParams) ([]models.Member, error)
+	GetMembersFromOrganization(ctx context.Context, arg storage.GetMembersFromOrganizationParams) (*storage.PaginatedMembers, error)
+}
+
+const (
+	defaultTeamPageLimit = 50
+	maxTeamPageLimit     = 200
+)
+
+type TeamPagination struct {
+	Total   int64 `json:"total"`
+	Limit   int64 `json:"limit"`
+	Offset  int64 `json:"offset"`
+	HasMore bool  `json:"has_more"`
 }
 
 type GetTeamResponse struct {
-	Members []models.Member
+	Members    []models.Member
+	Pagination TeamPagination `json:"pagination"`
 }
 
+// GetTeam lists the members of the organization, page by page.
+// Query parameters:
+//   - limit: number of members per page
+//   - offset: number of members to skip
+//   - status: only the members with this status
+//   - role: only the members with this role
 func (appHandler *AppHandler) GetTeam(mux chi.Router, db getTeamInterface) {
 	mux.Get("/", func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
+		query := r.URL.Query()
 
 		organization := ctx.Value("organization").(*models.Organization)
 
-		members, err := db.GetMembersFromOrganization(ctx, storage.GetMembersFromOrganizationParams{
+		limit, err := queryInt(query.Get("limit"), defaultTeamPageLimit)
+		if err != nil || limit <= 0 {
+			http.Error(w, "ERR_TEAM_GTM_LIMIT", http.StatusBadRequest)
+			return
+		}
+		if limit > maxTeamPageLimit {
+			limit = maxTeamPageLimit
+		}
+
+		offset, err := queryInt(query.Get("offset"), 0)
+		if err != nil || offset < 0 {
+			http.Error(w, "ERR_TEAM_GTM_OFFSET", http.StatusBadRequest)
+			return
+		}
+
+		role := query.Get("role")
+		if role != "" && !models.IsRole(role) {
+			http.Error(w, "ERR_TEAM_GTM_ROLE", http.StatusBadRequest)
+			return
+		}
+
+		page, err := db.GetMembersFromOrganization(ctx, storage.GetMembersFromOrganizationParams{
 			OrganizationId: organization.Id,
+			Status:         query.Get("status"),
+			Role:           role,
+			Limit:          limit,
+			Offset:         offset,
 		})
 		if err != nil {
 			http.Error(w, "ERR_TEAM_GTM_DB", http.StatusBadRequest)
@@ -37,7 +84,13 @@ func (appHandler *AppHandler) GetTeam(mux chi.Router, db getTeamInterface) {
 		}
 
 		response := GetTeamResponse{
-			Members: members,
+			Members: page.Members,
+			Pagination: TeamPagination{
+				Total:   page.Total,
+				Limit:   limit,
+				Offset:  offset,
+				HasMore: page.HasMore,
+			},
 		}
 
 		w.Header().Set("Content-Type", "application/json")
@@ -49,10 +102,350 @@ func (appHandler *AppHandler) GetTeam(mux chi.Router, db getTeamInterface) {
 	})
 }
 
+type removeMemberInterface interface {
+	GetMemberById(ctx context.Context, arg storage.GetMemberByIdParams) (*models.Member, error)
+	RemoveMember(ctx context.Context, arg storage.RemoveMemberParams) (*models.Member, error)
+}
+
+type MemberResponse struct {
+	Member models.Member `json:"member"`
+}
+
+// RemoveMember takes a member out of the organization, the member loses access right away.
+// The owner can not be removed, the ownership has to be transferred first.
+func (appHandler *AppHandler) RemoveMember(mux chi.Router, db removeMemberInterface) {
+	mux.Delete("/{memberId}", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		organization := ctx.Value("organization").(*models.Organization)
+
+		memberId, err := primitive.ObjectIDFromHex(chi.URLParam(r, "memberId"))
+		if err != nil {
+			http.Error(w, "ERR_TEAM_RMV_01", http.StatusBadRequest)
+			return
+		}
+
+		member, err := db.GetMemberById(ctx, storage.GetMemberByIdParams{
+			Id:             memberId,
+			OrganizationId: organization.Id,
+		})
+		if err != nil {
+			http.Error(w, "ERR_TEAM_RMV_02", http.StatusBadRequest)
+			return
+		}
+		if member == nil {
+			http.Error(w, "ERR_TEAM_RMV_03", http.StatusNotFound)
+			return
+		}
+		if member.Role == models.RoleOwner || member.MemberId == organization.OwnedBy {
+			http.Error(w, "ERR_TEAM_RMV_OWNER", http.StatusBadRequest)
+			return
+		}
+
+		member, err = db.RemoveMember(ctx, storage.RemoveMemberParams{
+			Id:             memberId,
+			OrganizationId: organization.Id,
+			Status:         models.MemberStatusRemoved,
+		})
+		if err != nil {
+			http.Error(w, "ERR_TEAM_RMV_04", http.StatusBadRequest)
+			return
+		}
+		if member == nil {
+			http.Error(w, "ERR_TEAM_RMV_03", http.StatusNotFound)
+			return
+		}
+
+		response := MemberResponse{
+			Member: *member,
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			http.Error(w, "ERR_TEAM_RMV_END", http.StatusBadRequest)
+			return
+		}
+	})
+}
+
+type updateMemberRoleInterface interface {
+	GetMemberById(ctx context.Context, arg storage.GetMemberByIdParams) (*models.Member, error)
+	UpdateMemberRole(ctx context.Context, arg storage.UpdateMemberRoleParams) (*models.Member, error)
+}
+
+type UpdateMemberRoleRequest struct {
+	Role string `json:"role"`
+}
+
+// UpdateMemberRole changes the role of a member, the owner only changes with TransferOwnership
+func (appHandler *AppHandler) UpdateMemberRole(mux chi.Router, db updateMemberRoleInterface) {
+	mux.Put("/{memberId}/role", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		organization := ctx.Value("organization").(*models.Organization)
+
+		memberId, err := primitive.ObjectIDFromHex(chi.URLParam(r, "memberId"))
+		if err != nil {
+			http.Error(w, "ERR_TEAM_ROLE_01", http.StatusBadRequest)
+			return
+		}
+
+		var input UpdateMemberRoleRequest
+		httpStatus, err := appHandler.ParsingRequestBody(w, r, &input)
+		if err != nil {
+			http.Error(w, err.Error(), httpStatus)
+			return
+		}
+		if !models.IsRole(input.Role) || input.Role == models.RoleOwner {
+			http.Error(w, "ERR_TEAM_ROLE_ROLE", http.StatusBadRequest)
+			return
+		}
+
+		member, err := db.GetMemberById(ctx, storage.GetMemberByIdParams{
+			Id:             memberId,
+			OrganizationId: organization.Id,
+		})
+		if err != nil {
+			http.Error(w, "ERR_TEAM_ROLE_02", http.StatusBadRequest)
+			return
+		}
+		if member == nil {
+			http.Error(w, "ERR_TEAM_ROLE_03", http.StatusNotFound)
+			return
+		}
+		if member.Role == models.RoleOwner || member.MemberId == organization.OwnedBy {
+			http.Error(w, "ERR_TEAM_ROLE_OWNER", http.StatusBadRequest)
+			return
+		}
+
+		member, err = db.UpdateMemberRole(ctx, storage.UpdateMemberRoleParams{
+			Id:             memberId,
+			OrganizationId: organization.Id,
+			Role:           input.Role,
+		})
+		if err != nil {
+			http.Error(w, "ERR_TEAM_ROLE_04", http.StatusBadRequest)
+			return
+		}
+		if member == nil {
+			http.Error(w, "ERR_TEAM_ROLE_03", http.StatusNotFound)
+			return
+		}
+
+		response := MemberResponse{
+			Member: *member,
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			http.Error(w, "ERR_TEAM_ROLE_END", http.StatusBadRequest)
+			return
+		}
+	})
+}
+
+type leaveOrganizationInterface interface {
+	RemoveMember(ctx context.Context, arg storage.RemoveMemberParams) (*models.Member, error)
+}
+
+// LeaveOrganization takes the authenticated user out of the organization,
+// the owner has to transfer the ownership first
+func (appHandler *AppHandler) LeaveOrganization(mux chi.Router, db leaveOrganizationInterface) {
+	mux.Post("/leave", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		organization := ctx.Value("organization").(*models.Organization)
+		member := ctx.Value("member").(*models.Member)
+
+		if member.Role == models.RoleOwner || member.MemberId == organization.OwnedBy {
+			http.Error(w, "ERR_TEAM_LEAVE_OWNER", http.StatusBadRequest)
+			return
+		}
+
+		member, err := db.RemoveMember(ctx, storage.RemoveMemberParams{
+			Id:             member.Id,
+			OrganizationId: organization.Id,
+			Status:         models.MemberStatusLeft,
+		})
+		if err != nil {
+			http.Error(w, "ERR_TEAM_LEAVE_01", http.StatusBadRequest)
+			return
+		}
+		if member == nil {
+			http.Error(w, "ERR_TEAM_LEAVE_02", http.StatusNotFound)
+			return
+		}
+
+		response := MemberResponse{
+			Member: *member,
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			http.Error(w, "ERR_TEAM_LEAVE_END", http.StatusBadRequest)
+			return
+		}
+	})
+}
+
+type transferOwnershipInterface interface {
+	GetMemberById(ctx context.Context, arg storage.GetMemberByIdParams) (*models.Member, error)
+	TransferOwnershipTx(ctx context.Context, arg storage.TransferOwnershipTxParams) (*models.Organization, error)
+}
+
+type TransferOwnershipRequest struct {
+	// the id of the membership of the new owner
+	MemberId string `json:"member_id"`
+}
+
+type TransferOwnershipResponse struct {
+	Organization models.Organization `json:"organization"`
+}
+
+// TransferOwnership gives the organization to another confirmed member, the owner becomes an admin
+func (appHandler *AppHandler) TransferOwnership(mux chi.Router, db transferOwnershipInterface) {
+	mux.Post("/transfer-ownership", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		organization := ctx.Value("organization").(*models.Organization)
+
+		var input TransferOwnershipRequest
+		httpStatus, err := appHandler.ParsingRequestBody(w, r, &input)
+		if err != nil {
+			http.Error(w, err.Error(), httpStatus)
+			return
+		}
+
+		memberId, err := primitive.ObjectIDFromHex(input.MemberId)
+		if err != nil {
+			http.Error(w, "ERR_TEAM_TRF_01", http.StatusBadRequest)
+			return
+		}
+
+		member, err := db.GetMemberById(ctx, storage.GetMemberByIdParams{
+			Id:             memberId,
+			OrganizationId: organization.Id,
+		})
+		if err != nil {
+			http.Error(w, "ERR_TEAM_TRF_02", http.StatusBadRequest)
+			return
+		}
+		if member == nil || member.Status != models.MemberStatusConfirmed {
+			http.Error(w, "ERR_TEAM_TRF_03", http.StatusNotFound)
+			return
+		}
+		if member.MemberId == organization.OwnedBy {
+			http.Error(w, "ERR_TEAM_TRF_SELF", http.StatusBadRequest)
+			return
+		}
+
+		updated, err := db.TransferOwnershipTx(ctx, storage.TransferOwnershipTxParams{
+			OrganizationId: organization.Id,
+			FromUserId:     organization.OwnedBy,
+			ToUserId:       member.MemberId,
+		})
+		if errors.Is(err, storage.ErrNotMember) {
+			http.Error(w, "ERR_TEAM_TRF_03", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "ERR_TEAM_TRF_04", http.StatusBadRequest)
+			return
+		}
+		// transferred at the same time by someone else
+		if updated == nil {
+			http.Error(w, "ERR_TEAM_TRF_05", http.StatusConflict)
+			return
+		}
+
+		response := TransferOwnershipResponse{
+			Organization: *updated,
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			http.Error(w, "ERR_TEAM_TRF_END", http.StatusBadRequest)
+			return
+		}
+	})
+}
+
+type reviewJoinRequestInterface interface {
+	ReviewJoinRequest(ctx context.Context, arg storage.ReviewJoinRequestParams) (*models.Member, error)
+	GetUserById(ctx context.Context, arg storage.GetUserByIdParams) (*models.User, error)
+}
+
+// ApproveJoinRequest makes a pending member a member of the organization and tells the requester
+func (appHandler *AppHandler) ApproveJoinRequest(mux chi.Router, db reviewJoinRequestInterface, sender invitationSenderInterface) {
+	mux.Post("/{memberId}/approve", reviewJoinRequest(db, sender, true))
+}
+
+// RejectJoinRequest turns a pending member away and tells the requester
+func (appHandler *AppHandler) RejectJoinRequest(mux chi.Router, db reviewJoinRequestInterface, sender invitationSenderInterface) {
+	mux.Post("/{memberId}/reject", reviewJoinRequest(db, sender, false))
+}
+
+func reviewJoinRequest(db reviewJoinRequestInterface, sender invitationSenderInterface, approve bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		organization := ctx.Value("organization").(*models.Organization)
+
+		memberId, err := primitive.ObjectIDFromHex(chi.URLParam(r, "memberId"))
+		if err != nil {
+			http.Error(w, "ERR_TEAM_JREQ_01", http.StatusBadRequest)
+			return
+		}
+
+		member, err := db.ReviewJoinRequest(ctx, storage.ReviewJoinRequestParams{
+			Id:             memberId,
+			OrganizationId: organization.Id,
+			Approve:        approve,
+		})
+		if err != nil {
+			http.Error(w, "ERR_TEAM_JREQ_02", http.StatusBadRequest)
+			return
+		}
+		if member == nil {
+			http.Error(w, "ERR_TEAM_JREQ_03", http.StatusNotFound)
+			return
+		}
+
+		// the answer is given even when the requester can not be told
+		user, err := db.GetUserById(ctx, storage.GetUserByIdParams{
+			Id: member.MemberId,
+		})
+		if err == nil && user != nil {
+			_, err = sender.SendJoinReview(ctx, services.JoinReviewMessage{
+				PhoneNumber:      user.PhoneNumber,
+				Language:         r.URL.Query().Get("language"),
+				OrganizationName: organization.Name,
+				Approved:         approve,
+			})
+		}
+		if err != nil {
+			log.Println("error when telling the answer to the join request: ", err)
+		}
+
+		response := MemberResponse{
+			Member: *member,
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			http.Error(w, "ERR_TEAM_JREQ_END", http.StatusBadRequest)
+			return
+		}
+	}
+}
+
 type invitationMiddlewareInterface interface {
-	GetOrganizationFromInvitationToken(ctx context.Context, arg storage.GetOrganizationFromInvitationTokenParams) (*models.Organization, error)
+	GetInvitationByToken(ctx context.Context, arg storage.GetInvitationByTokenParams) (*models.Invitation, error)
+	GetOrganization(ctx context.Context, arg storage.GetOrganizationParams) (*models.Organization, error)
 }
 
+// InvitationMiddleware puts the invitation of the link and its organization in the context,
+// the invitations revoked, expired or used up are refused
 func (handler *AppHandler) InvitationMiddleware(mux chi.Router, db invitationMiddlewareInterface) {
 	mux.Use(func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -60,20 +453,36 @@ func (handler *AppHandler) InvitationMiddleware(mux chi.Router, db invitationMid
 
 			invitationToken := chi.URLParamFromCtx(ctx, "invitationToken")
 
-			organization, err := db.GetOrganizationFromInvitationToken(ctx, storage.GetOrganizationFromInvitationTokenParams{
-				InvitationToken: invitationToken,
+			invitation, err := db.GetInvitationByToken(ctx, storage.GetInvitationByTokenParams{
+				Token: invitationToken,
 			})
 			if err != nil {
 				http.Error(w, "ERR_CMP_MDW_02", http.StatusBadRequest)
 				return
 			}
+			if invitation == nil {
+				http.Error(w, "ERR_CMP_MDW_03", http.StatusNotFound)
+				return
+			}
+			if !invitation.IsActive(time.Now()) {
+				http.Error(w, "ERR_CMP_MDW_04", http.StatusGone)
+				return
+			}
 
+			organization, err := db.GetOrganization(ctx, storage.GetOrganizationParams{
+				Id: invitation.OrganizationId,
+			})
+			if err != nil {
+				http.Error(w, "ERR_CMP_MDW_02", http.StatusBadRequest)
+				return
+			}
 			if organization == nil {
 				http.Error(w, "ERR_CMP_MDW_03", http.StatusNotFound)
 				return
 			}
 
 			ctx = context.WithValue(ctx, "organization", organization)
+			ctx = context.WithValue(ctx, "invitation", invitation)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	})
@@ -83,6 +492,9 @@ type getOrganizationFromInvitationTokenInterface interface{}
 
 type GetOrganizationFromInvitationTokenResponse struct {
 	Organization models.Organization `json:"organization,omitempty"`
+	// the role given and until when the link can be used
+	Role      string    `json:"role"`
+	ExpiresAt time.Time `json:"expires_at"`
 }
 
 func (handler *AppHandler) GetOrganizationFromInvitationToken(mux chi.Router, db getOrganizationFromInvitationTokenInterface) {
@@ -90,9 +502,12 @@ func (handler *AppHandler) GetOrganizationFromInvitationToken(mux chi.Router, db
 		ctx := r.Context()
 
 		organization := ctx.Value("organization").(*models.Organization)
+		invitation := ctx.Value("invitation").(*models.Invitation)
 
 		response := GetOrganizationFromInvitationTokenResponse{
 			Organization: *organization,
+			Role:         invitation.Role,
+			ExpiresAt:    invitation.ExpiresAt,
 		}
 
 		w.Header().Set("Content-Type", "application/json")
@@ -108,6 +523,8 @@ type addMemberInterface interface {
 	CreateUser(ctx context.Context, arg storage.CreateUserParams) (*models.User, error)
 	DoesUserExists(ctx context.Context, arg storage.DoesUserExistsParams) (*models.User, error)
 	CreateOTPx(ctx context.Context, arg storage.CreateOTPParams) (*models.OTP, error)
+	GetMember(ctx context.Context, arg storage.GetMemberParams) (*models.Member, error)
+	UseInvitation(ctx context.Context, arg storage.UseInvitationParams) (*models.Invitation, error)
 	AddMemberIntoOrganization(ctx context.Context, arg storage.AddMemberIntoOrganizationParams) (*models.Member, error)
 	UpdateUserPreferences(ctx context.Context, arg storage.UpdateUserPreferencesParams) (*models.User, error)
 }
@@ -115,17 +532,24 @@ type addMemberInterface interface {
 type AddMemberRequest struct {
 	PhoneNumber string `json:"phone_number"` // Phone number of the customer
 	Language    string `json:"language"`     // Language for template
+	// whatsapp, sms or email, the channel of the last code received by default
+	Channel string `json:"channel,omitempty"`
 }
 
 type AddMemberResponse struct {
 	PhoneNumber   string `json:"phone_number"`
 	RedirectToUrl string `json:"redirect_to_url"`
+	// the organization requires the approval of an admin
+	Pending bool `json:"pending"`
 }
 
-func (appHandler *AppHandler) AddMember(mux chi.Router, db addMemberInterface) {
+// AddMember joins the organization with the invitation of the link, with the role of the invitation,
+// and sends a code to sign in. The organizations requiring an approval get a pending join request.
+func (appHandler *AppHandler) AddMember(mux chi.Router, db addMemberInterface, sender otpSenderInterface) {
 	mux.Post("/", func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 		organization := ctx.Value("organization").(*models.Organization)
+		invitation := ctx.Value("invitation").(*models.Invitation)
 
 		var input AddMemberRequest
 		httpStatus, err := appHandler.ParsingRequestBody(w, r, &input)
@@ -134,9 +558,23 @@ func (appHandler *AppHandler) AddMember(mux chi.Router, db addMemberInterface) {
 			return
 		}
 
-		// generate the pin code of 4 digits
+		if input.Channel != "" && !services.IsOTPChannel(input.Channel) {
+			http.Error(w, "ERR_COTP_CHANNEL", http.StatusBadRequest)
+			return
+		}
+		if !invitation.Accepts(input.PhoneNumber) {
+			http.Error(w, "ERR_JOIN_PHONE_NUMBER", http.StatusForbidden)
+			return
+		}
+
+		// generate the pin code, only its hash is saved
 		now := time.Now()
-		pinCode := utils.GenerateOTP(now)
+		otp, err := services.IssueOTP(input.PhoneNumber, now)
+		if err != nil {
+			log.Println("error when generating the OTP: ", err)
+			http.Error(w, "ERR_COTP_151", http.StatusBadRequest)
+			return
+		}
 
 		var user *models.User
 		// Check if there is an user with this phone number
@@ -161,51 +599,77 @@ func (appHandler *AppHandler) AddMember(mux chi.Router, db addMemberInterface) {
 			}
 		}
 
-		// send the pin code to a the phone number using Whatsapp API
-		// waMessageId, err := requests.SendWoZOTP(
-		// 	input.PhoneNumber,
-		// 	input.Language,
-		// 	pinCode,
-		// )
-		// if err != nil {
-		// 	log.Println("error when sending the OTP via WhatsApp: ", err)
-		// 	http.Error(w, "ERR_COTP_150", http.StatusBadRequest)
-		// 	return
-		// }
-		waMessageId := "xxx-yyy-zzz"
+		// send the pin code to the user
+		otpParams, err := sendOTP(ctx, sender, user, otp, input.Language, input.Channel)
+		if err != nil {
+			log.Println("error when sending the OTP: ", err)
+			http.Error(w, "ERR_COTP_150", http.StatusBadRequest)
+			return
+		}
 
 		// Then we save the OTP
 		// 1- update all otps to not active
 		// 2- create the new otp as active
-		_, err = db.CreateOTPx(ctx, storage.CreateOTPParams{
-			WaMessageId: waMessageId,
-			PhoneNumber: input.PhoneNumber,
-			PinCode:     pinCode,
-		})
+		_, err = db.CreateOTPx(ctx, *otpParams)
 		if err != nil {
 			log.Println("error when saving the OTP: ", err)
 			http.Error(w, "ERR_COTP_152", http.StatusBadRequest)
 			return
 		}
 
-		_, err = db.AddMemberIntoOrganization(ctx, storage.AddMemberIntoOrganizationParams{
+		member, err := db.GetMember(ctx, storage.GetMemberParams{
 			OrganizationId: organization.Id,
 			UserId:         user.Id,
-			InvitedAt:      now,
-			ConfirmedAt:    &now,
 		})
 		if err != nil {
 			http.Error(w, "ERR_COTP_ADD_MBR_ORG", http.StatusBadRequest)
 			return
 		}
 
-		_, err = db.UpdateUserPreferences(ctx, storage.UpdateUserPreferencesParams{
-			Id: user.Id,
+		// a member only signs in, the invitation is kept for the others
+		if member == nil {
+			used, err := db.UseInvitation(ctx, storage.UseInvitationParams{
+				Id: invitation.Id,
+			})
+			if err != nil {
+				http.Error(w, "ERR_COTP_ADD_MBR_ORG", http.StatusBadRequest)
+				return
+			}
+			if used == nil {
+				http.Error(w, "ERR_JOIN_INVITATION_USED", http.StatusGone)
+				return
+			}
 
-			Changes: map[string]any{
-				"current_organization_id": organization.Id,
-				"current_status":          user.Preferences.CurrentStatus,
-			},
+			params := storage.AddMemberIntoOrganizationParams{
+				OrganizationId: organization.Id,
+				UserId:         user.Id,
+				InvitedAt:      invitation.CreatedAt,
+				ConfirmedAt:    &now,
+				Role:           invitation.Role,
+			}
+			// the request waits for an admin, see ApproveJoinRequest
+			if organization.RequiresApproval() {
+				params.ConfirmedAt = nil
+				params.Status = models.MemberStatusPending
+			}
+
+			member, err = db.AddMemberIntoOrganization(ctx, params)
+			if err != nil {
+				http.Error(w, "ERR_COTP_ADD_MBR_ORG", http.StatusBadRequest)
+				return
+			}
+		}
+		pending := member.Status == models.MemberStatusPending
+
+		changes := map[string]any{
+			"current_status": user.Preferences.CurrentStatus,
+		}
+		if !pending {
+			changes["current_organization_id"] = organization.Id
+		}
+		_, err = db.UpdateUserPreferences(ctx, storage.UpdateUserPreferencesParams{
+			Id:      user.Id,
+			Changes: changes,
 		})
 		if err != nil {
 			http.Error(w, "ERR_OBD_CPN_02", http.StatusBadRequest)
@@ -214,12 +678,13 @@ func (appHandler *AppHandler) AddMember(mux chi.Router, db addMemberInterface) {
 
 		redirectToUrl := fmt.Sprintf(
 			"/join/%s/check-otp?phone-number=%s",
-			organization.InvitationToken, input.PhoneNumber,
+			invitation.Token, input.PhoneNumber,
 		)
 
 		response := AddMemberResponse{
 			RedirectToUrl: redirectToUrl,
 			PhoneNumber:   input.PhoneNumber,
+			Pending:       pending,
 		}
 
 		w.Header().Set("Content-Type", "application/json")
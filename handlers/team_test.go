@@ -0,0 +1,500 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"stockinos.com/api/handlers"
+	"stockinos.com/api/helpertest"
+	"stockinos.com/api/models"
+	"stockinos.com/api/storage"
+	sfaker "syreclabs.com/go/faker"
+)
+
+// mockTeamDB keeps the members of one organization, as the storage would
+type mockTeamDB struct {
+	organization *models.Organization
+	members      []*models.Member
+
+	// the errors returned instead of the members, when set
+	err                  error
+	transferOwnershipErr error
+}
+
+func (mdb *mockTeamDB) GetMembersFromOrganization(ctx context.Context, arg storage.GetMembersFromOrganizationParams) (*storage.PaginatedMembers, error) {
+	if mdb.err != nil {
+		return nil, mdb.err
+	}
+	members := make([]models.Member, 0)
+	for _, member := range mdb.members {
+		if (arg.Status == "" || member.Status == arg.Status) && (arg.Role == "" || member.Role == arg.Role) {
+			members = append(members, *member)
+		}
+	}
+	total := int64(len(members))
+	start, end := arg.Offset, arg.Offset+arg.Limit
+	if start > total {
+		start = total
+	}
+	if end > total {
+		end = total
+	}
+	return &storage.PaginatedMembers{
+		Members: members[start:end],
+		Total:   total,
+		HasMore: end < total,
+	}, nil
+}
+
+func (mdb *mockTeamDB) GetMemberById(ctx context.Context, arg storage.GetMemberByIdParams) (*models.Member, error) {
+	if mdb.err != nil {
+		return nil, mdb.err
+	}
+	for _, member := range mdb.members {
+		if member.Id == arg.Id && member.OrganizationId == arg.OrganizationId && member.DeletedAt == nil {
+			copied := *member
+			return &copied, nil
+		}
+	}
+	return nil, nil
+}
+
+func (mdb *mockTeamDB) RemoveMember(ctx context.Context, arg storage.RemoveMemberParams) (*models.Member, error) {
+	member, err := mdb.GetMemberById(ctx, storage.GetMemberByIdParams{Id: arg.Id, OrganizationId: arg.OrganizationId})
+	if member == nil || member.Role == models.RoleOwner {
+		return nil, err
+	}
+	for _, m := range mdb.members {
+		if m.Id == arg.Id {
+			now := time.Now()
+			m.Status = arg.Status
+			m.DeletedAt = &now
+			member = m
+		}
+	}
+	return member, nil
+}
+
+func (mdb *mockTeamDB) UpdateMemberRole(ctx context.Context, arg storage.UpdateMemberRoleParams) (*models.Member, error) {
+	member, err := mdb.GetMemberById(ctx, storage.GetMemberByIdParams{Id: arg.Id, OrganizationId: arg.OrganizationId})
+	if member == nil || member.Role == models.RoleOwner {
+		return nil, err
+	}
+	for _, m := range mdb.members {
+		if m.Id == arg.Id {
+			m.Role = arg.Role
+			member = m
+		}
+	}
+	return member, nil
+}
+
+func (mdb *mockTeamDB) TransferOwnershipTx(ctx context.Context, arg storage.TransferOwnershipTxParams) (*models.Organization, error) {
+	if mdb.transferOwnershipErr != nil {
+		return nil, mdb.transferOwnershipErr
+	}
+	if mdb.organization.OwnedBy != arg.FromUserId {
+		return nil, nil
+	}
+	for _, member := range mdb.members {
+		switch member.MemberId {
+		case arg.FromUserId:
+			member.Role = models.RoleAdmin
+		case arg.ToUserId:
+			member.Role = models.RoleOwner
+		}
+	}
+	mdb.organization.OwnedBy = arg.ToUserId
+	organization := *mdb.organization
+	return &organization, nil
+}
+
+// newTeam returns an organization owned by its first member, with a confirmed admin,
+// a confirmed editor and a pending viewer
+func newTeam() *mockTeamDB {
+	organization := &models.Organization{
+		Id:   primitive.NewObjectID(),
+		Name: sfaker.Company().Name(),
+	}
+	db := &mockTeamDB{organization: organization}
+	for _, member := range []struct {
+		Role   string
+		Status string
+	}{
+		{models.RoleOwner, models.MemberStatusConfirmed},
+		{models.RoleAdmin, models.MemberStatusConfirmed},
+		{models.RoleEditor, models.MemberStatusConfirmed},
+		{models.RoleViewer, models.MemberStatusPending},
+	} {
+		db.members = append(db.members, &models.Member{
+			Id:             primitive.NewObjectID(),
+			OrganizationId: organization.Id,
+			MemberId:       primitive.NewObjectID(),
+			InvitedAt:      time.Now(),
+			Status:         member.Status,
+			Role:           member.Role,
+		})
+	}
+	organization.OwnedBy = db.members[0].MemberId
+	organization.CreatedBy = db.members[0].MemberId
+	return db
+}
+
+func TestGetTeam(t *testing.T) {
+	handler := handlers.NewAppHandler()
+
+	testCases := map[string]struct {
+		Query         string
+		Err           error
+		ResponseError string
+		Members       int
+		Pagination    handlers.TeamPagination
+	}{
+		"wrong limit": {
+			Query:         "?limit=0",
+			ResponseError: "ERR_TEAM_GTM_LIMIT",
+		},
+		"wrong offset": {
+			Query:         "?offset=-1",
+			ResponseError: "ERR_TEAM_GTM_OFFSET",
+		},
+		"unknown role": {
+			Query:         "?role=boss",
+			ResponseError: "ERR_TEAM_GTM_ROLE",
+		},
+		"error from db": {
+			Err:           context.DeadlineExceeded,
+			ResponseError: "ERR_TEAM_GTM_DB",
+		},
+		"whole team": {
+			Members:    4,
+			Pagination: handlers.TeamPagination{Total: 4, Limit: 50},
+		},
+		"page of the confirmed members": {
+			Query:      "?status=" + models.MemberStatusConfirmed + "&limit=2&offset=1",
+			Members:    2,
+			Pagination: handlers.TeamPagination{Total: 3, Limit: 2, Offset: 1},
+		},
+		"first page of the admins with a limit too high": {
+			Query:      "?role=" + models.RoleAdmin + "&limit=1000",
+			Members:    1,
+			Pagination: handlers.TeamPagination{Total: 1, Limit: 200},
+		},
+		"first page": {
+			Query:      "?limit=3",
+			Members:    3,
+			Pagination: handlers.TeamPagination{Total: 4, Limit: 3, HasMore: true},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			db := newTeam()
+			db.err = tc.Err
+
+			mux := chi.NewMux()
+			handler.GetTeam(mux, db)
+			_, w, response := helpertest.MakeGetRequest(mux, "/"+tc.Query, []helpertest.ContextData{{Name: "organization", Value: db.organization}})
+
+			if tc.ResponseError != "" {
+				if w.StatusCode != http.StatusBadRequest {
+					t.Fatalf("GetTeam(): status - got %d; want %d", w.StatusCode, http.StatusBadRequest)
+				}
+				if response != tc.ResponseError {
+					t.Fatalf("GetTeam(): response error - got %s; want %s", response, tc.ResponseError)
+				}
+				return
+			}
+
+			if w.StatusCode != http.StatusOK {
+				t.Fatalf("GetTeam(): status - got %d; want %d (%s)", w.StatusCode, http.StatusOK, response)
+			}
+			got := handlers.GetTeamResponse{}
+			json.Unmarshal([]byte(response), &got)
+			if len(got.Members) != tc.Members {
+				t.Fatalf("GetTeam(): got %d members; want %d", len(got.Members), tc.Members)
+			}
+			if got.Pagination != tc.Pagination {
+				t.Fatalf("GetTeam(): pagination - got %+v; want %+v", got.Pagination, tc.Pagination)
+			}
+		})
+	}
+}
+
+func TestRemoveMember(t *testing.T) {
+	handler := handlers.NewAppHandler()
+
+	testCases := map[string]struct {
+		MemberId      func(db *mockTeamDB) string
+		Status        int
+		ResponseError string
+	}{
+		"invalid id": {
+			MemberId:      func(db *mockTeamDB) string { return "not-an-id" },
+			Status:        http.StatusBadRequest,
+			ResponseError: "ERR_TEAM_RMV_01",
+		},
+		"unknown member": {
+			MemberId:      func(db *mockTeamDB) string { return primitive.NewObjectID().Hex() },
+			Status:        http.StatusNotFound,
+			ResponseError: "ERR_TEAM_RMV_03",
+		},
+		"owner": {
+			MemberId:      func(db *mockTeamDB) string { return db.members[0].Id.Hex() },
+			Status:        http.StatusBadRequest,
+			ResponseError: "ERR_TEAM_RMV_OWNER",
+		},
+		"removed member": {
+			MemberId: func(db *mockTeamDB) string { return db.members[2].Id.Hex() },
+			Status:   http.StatusOK,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			db := newTeam()
+			mux := chi.NewMux()
+			handler.RemoveMember(mux, db)
+			code, _, response := helpertest.MakeDeleteRequest(
+				mux,
+				"/"+tc.MemberId(db),
+				helpertest.CreateFormHeader(),
+				nil,
+				[]helpertest.ContextData{{Name: "organization", Value: db.organization}},
+			)
+			if code != tc.Status {
+				t.Fatalf("RemoveMember(): status - got %d; want %d (%s)", code, tc.Status, response)
+			}
+			if tc.ResponseError != "" {
+				if response != tc.ResponseError {
+					t.Fatalf("RemoveMember(): response error - got %s; want %s", response, tc.ResponseError)
+				}
+				return
+			}
+
+			got := handlers.MemberResponse{}
+			json.Unmarshal([]byte(response), &got)
+			if got.Member.Id != db.members[2].Id || got.Member.Status != models.MemberStatusRemoved {
+				t.Fatalf("RemoveMember(): got %+v; want the member %s removed", got.Member, db.members[2].Id.Hex())
+			}
+		})
+	}
+}
+
+func TestUpdateMemberRole(t *testing.T) {
+	handler := handlers.NewAppHandler()
+
+	testCases := map[string]struct {
+		MemberId      func(db *mockTeamDB) string
+		Role          string
+		Status        int
+		ResponseError string
+	}{
+		"invalid id": {
+			MemberId:      func(db *mockTeamDB) string { return "not-an-id" },
+			Role:          models.RoleViewer,
+			Status:        http.StatusBadRequest,
+			ResponseError: "ERR_TEAM_ROLE_01",
+		},
+		"unknown role": {
+			MemberId:      func(db *mockTeamDB) string { return db.members[2].Id.Hex() },
+			Role:          "boss",
+			Status:        http.StatusBadRequest,
+			ResponseError: "ERR_TEAM_ROLE_ROLE",
+		},
+		"owner role": {
+			MemberId:      func(db *mockTeamDB) string { return db.members[2].Id.Hex() },
+			Role:          models.RoleOwner,
+			Status:        http.StatusBadRequest,
+			ResponseError: "ERR_TEAM_ROLE_ROLE",
+		},
+		"unknown member": {
+			MemberId:      func(db *mockTeamDB) string { return primitive.NewObjectID().Hex() },
+			Role:          models.RoleViewer,
+			Status:        http.StatusNotFound,
+			ResponseError: "ERR_TEAM_ROLE_03",
+		},
+		"owner": {
+			MemberId:      func(db *mockTeamDB) string { return db.members[0].Id.Hex() },
+			Role:          models.RoleViewer,
+			Status:        http.StatusBadRequest,
+			ResponseError: "ERR_TEAM_ROLE_OWNER",
+		},
+		"updated role": {
+			MemberId: func(db *mockTeamDB) string { return db.members[2].Id.Hex() },
+			Role:     models.RoleViewer,
+			Status:   http.StatusOK,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			db := newTeam()
+			mux := chi.NewMux()
+			handler.UpdateMemberRole(mux, db)
+			code, _, response := helpertest.MakePutRequest(
+				mux,
+				"/"+tc.MemberId(db)+"/role",
+				helpertest.CreateFormHeader(),
+				handlers.UpdateMemberRoleRequest{Role: tc.Role},
+				[]helpertest.ContextData{{Name: "organization", Value: db.organization}},
+			)
+			if code != tc.Status {
+				t.Fatalf("UpdateMemberRole(): status - got %d; want %d (%s)", code, tc.Status, response)
+			}
+			if tc.ResponseError != "" {
+				if response != tc.ResponseError {
+					t.Fatalf("UpdateMemberRole(): response error - got %s; want %s", response, tc.ResponseError)
+				}
+				return
+			}
+
+			got := handlers.MemberResponse{}
+			json.Unmarshal([]byte(response), &got)
+			if got.Member.Id != db.members[2].Id || got.Member.Role != tc.Role {
+				t.Fatalf("UpdateMemberRole(): got %+v; want the role %s", got.Member, tc.Role)
+			}
+		})
+	}
+}
+
+func TestLeaveOrganization(t *testing.T) {
+	handler := handlers.NewAppHandler()
+
+	leave := func(db *mockTeamDB, member *models.Member) (int, string) {
+		mux := chi.NewMux()
+		handler.LeaveOrganization(mux, db)
+		code, _, response := helpertest.MakePostRequest(
+			mux,
+			"/leave",
+			helpertest.CreateFormHeader(),
+			nil,
+			[]helpertest.ContextData{
+				{Name: "organization", Value: db.organization},
+				{Name: "member", Value: member},
+			},
+		)
+		return code, response
+	}
+
+	t.Run("owner", func(t *testing.T) {
+		db := newTeam()
+		code, response := leave(db, db.members[0])
+		if code != http.StatusBadRequest {
+			t.Fatalf("LeaveOrganization(): status - got %d; want %d", code, http.StatusBadRequest)
+		}
+		wantError := "ERR_TEAM_LEAVE_OWNER"
+		if response != wantError {
+			t.Fatalf("LeaveOrganization(): response error - got %s; want %s", response, wantError)
+		}
+	})
+
+	t.Run("member", func(t *testing.T) {
+		db := newTeam()
+		code, response := leave(db, db.members[1])
+		if code != http.StatusOK {
+			t.Fatalf("LeaveOrganization(): status - got %d; want %d (%s)", code, http.StatusOK, response)
+		}
+		got := handlers.MemberResponse{}
+		json.Unmarshal([]byte(response), &got)
+		if got.Member.Id != db.members[1].Id || got.Member.Status != models.MemberStatusLeft {
+			t.Fatalf("LeaveOrganization(): got %+v; want the member gone", got.Member)
+		}
+
+		// the member can not leave twice
+		code, response = leave(db, db.members[1])
+		if code != http.StatusNotFound {
+			t.Fatalf("LeaveOrganization(): status - got %d; want %d (%s)", code, http.StatusNotFound, response)
+		}
+	})
+}
+
+func TestTransferOwnership(t *testing.T) {
+	handler := handlers.NewAppHandler()
+
+	testCases := map[string]struct {
+		MemberId             func(db *mockTeamDB) string
+		TransferOwnershipErr error
+		// the organization is transferred by someone else in the meantime
+		TransferredMeanwhile bool
+		Status               int
+		ResponseError        string
+	}{
+		"invalid id": {
+			MemberId:      func(db *mockTeamDB) string { return "not-an-id" },
+			Status:        http.StatusBadRequest,
+			ResponseError: "ERR_TEAM_TRF_01",
+		},
+		"pending member": {
+			MemberId:      func(db *mockTeamDB) string { return db.members[3].Id.Hex() },
+			Status:        http.StatusNotFound,
+			ResponseError: "ERR_TEAM_TRF_03",
+		},
+		"owner": {
+			MemberId:      func(db *mockTeamDB) string { return db.members[0].Id.Hex() },
+			Status:        http.StatusBadRequest,
+			ResponseError: "ERR_TEAM_TRF_SELF",
+		},
+		"member removed meanwhile": {
+			MemberId:             func(db *mockTeamDB) string { return db.members[1].Id.Hex() },
+			TransferOwnershipErr: storage.ErrNotMember,
+			Status:               http.StatusNotFound,
+			ResponseError:        "ERR_TEAM_TRF_03",
+		},
+		"transferred meanwhile": {
+			MemberId:             func(db *mockTeamDB) string { return db.members[1].Id.Hex() },
+			TransferredMeanwhile: true,
+			Status:               http.StatusConflict,
+			ResponseError:        "ERR_TEAM_TRF_05",
+		},
+		"transferred": {
+			MemberId: func(db *mockTeamDB) string { return db.members[1].Id.Hex() },
+			Status:   http.StatusOK,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			db := newTeam()
+			db.transferOwnershipErr = tc.TransferOwnershipErr
+			// the handler is given the organization as read by the middleware
+			organization := *db.organization
+			if tc.TransferredMeanwhile {
+				db.organization.OwnedBy = db.members[2].MemberId
+			}
+
+			mux := chi.NewMux()
+			handler.TransferOwnership(mux, db)
+			code, _, response := helpertest.MakePostRequest(
+				mux,
+				"/transfer-ownership",
+				helpertest.CreateFormHeader(),
+				handlers.TransferOwnershipRequest{MemberId: tc.MemberId(db)},
+				[]helpertest.ContextData{{Name: "organization", Value: &organization}},
+			)
+			if code != tc.Status {
+				t.Fatalf("TransferOwnership(): status - got %d; want %d (%s)", code, tc.Status, response)
+			}
+			if tc.ResponseError != "" {
+				if response != tc.ResponseError {
+					t.Fatalf("TransferOwnership(): response error - got %s; want %s", response, tc.ResponseError)
+				}
+				return
+			}
+
+			got := handlers.TransferOwnershipResponse{}
+			json.Unmarshal([]byte(response), &got)
+			if got.Organization.OwnedBy != db.members[1].MemberId {
+				t.Fatalf("TransferOwnership(): owned by %s; want %s", got.Organization.OwnedBy.Hex(), db.members[1].MemberId.Hex())
+			}
+			if db.members[0].Role != models.RoleAdmin || db.members[1].Role != models.RoleOwner {
+				t.Fatalf("TransferOwnership(): roles - got %s and %s; want the previous owner admin", db.members[0].Role, db.members[1].Role)
+			}
+		})
+	}
+}
@@ -0,0 +1,564 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"stockinos.com/api/models"
+	"stockinos.com/api/storage"
+)
+
+// DeleteUploadedFiles deletes the purged files from the file storage.
+// The data are already purged at that point, the errors are logged.
+func DeleteUploadedFiles(files []string, s3 deleteFilesS3Interface) {
+	var wg sync.WaitGroup
+
+	wg.Add(len(files))
+	for i := range files {
+		go func(fileKey string) {
+			defer wg.Done()
+
+			if err := s3.DeleteFile(fileKey); err != nil {
+				log.Printf("error when deleting the file %s: %s\n", fileKey, err)
+			}
+		}(strings.TrimPrefix(files[i], AWS_S3_ROOT))
+	}
+	wg.Wait()
+}
+
+type GetTrashResponse struct {
+	Items []models.TrashItem `json:"items"`
+}
+
+type getTrashInterface interface {
+	GetTrash(ctx context.Context, arg storage.GetTrashParams) ([]models.TrashItem, error)
+}
+
+// GetTrash lists the activities and the data deleted in the organization, the latest deleted first.
+// Query parameters:
+//   - type: activity or data, both by default
+func (appHandler *AppHandler) GetTrash(mux chi.Router, db getTrashInterface, retention time.Duration) {
+	mux.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		organization := ctx.Value("organization").(*models.Organization)
+
+		itemType := strings.ToLower(r.URL.Query().Get("type"))
+		if itemType != "" && itemType != models.TrashItemActivity && itemType != models.TrashItemData {
+			http.Error(w, "ERR_TRASH_GALL_TYPE", http.StatusBadRequest)
+			return
+		}
+
+		items, err := db.GetTrash(ctx, storage.GetTrashParams{
+			OrganizationId: organization.Id,
+			Type:           itemType,
+			Retention:      retention,
+		})
+		if err != nil {
+			http.Error(w, "ERR_TRASH_GALL_01", http.StatusBadRequest)
+			return
+		}
+
+		response := GetTrashResponse{
+			Items: items,
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			http.Error(w, "ERR_TRASH_GALL_END", http.StatusBadRequest)
+			return
+		}
+	})
+}
+
+type restoreActivityInterface interface {
+	RestoreActivityTx(ctx context.Context, arg storage.RestoreActivityTxParams) (*models.Activity, error)
+	MigrateDataTx(ctx context.Context, arg storage.MigrateDataTxParams) error
+	RefreshDataUniqueKeysTx(ctx context.Context, arg storage.RefreshDataUniqueKeysTxParams) error
+}
+
+type RestoreActivityResponse struct {
+	Activity models.Activity `json:"activity"`
+}
+
+// RestoreActivity takes an activity out of the trash, along with the data deleted with it.
+// The migration of the data or the refresh of their keys in progress when the activity was deleted is resumed.
+func (appHandler *AppHandler) RestoreActivity(mux chi.Router, db restoreActivityInterface) {
+	mux.Post("/activities/{activityId}/restore", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		organization := ctx.Value("organization").(*models.Organization)
+
+		activityId, err := primitive.ObjectIDFromHex(chi.URLParam(r, "activityId"))
+		if err != nil {
+			http.Error(w, "ERR_TRASH_ATVT_RST_ID", http.StatusBadRequest)
+			return
+		}
+
+		activity, err := db.RestoreActivityTx(ctx, storage.RestoreActivityTxParams{
+			Id:             activityId,
+			OrganizationId: organization.Id,
+		})
+		if errors.Is(err, storage.ErrDataNotUnique) {
+			http.Error(w, "ERR_TRASH_ATVT_RST_NOT_UNIQUE", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, "ERR_TRASH_ATVT_RST_01", http.StatusBadRequest)
+			return
+		}
+		if activity == nil {
+			http.Error(w, "ERR_TRASH_ATVT_RST_NOT_FOUND", http.StatusNotFound)
+			return
+		}
+		// a migration or a refresh of the keys of the data which fails here is resumed later
+		if activity.Migration != nil {
+			if err := db.MigrateDataTx(ctx, storage.MigrateDataTxParams{Activity: *activity}); err != nil {
+				log.Printf("Error while migrating the data of the activity %s: %v", activity.Id.Hex(), err)
+			} else {
+				activity.Migration = nil
+			}
+		}
+		if activity.UniqueKeysRefresh != nil {
+			if err := db.RefreshDataUniqueKeysTx(ctx, storage.RefreshDataUniqueKeysTxParams{Activity: *activity}); err != nil {
+				log.Printf("Error while refreshing the unique keys of the data of the activity %s: %v", activity.Id.Hex(), err)
+			} else {
+				activity.UniqueKeysRefresh = nil
+			}
+		}
+
+		response := RestoreActivityResponse{
+			Activity: *activity,
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			http.Error(w, "ERR_TRASH_ATVT_RST_END", http.StatusBadRequest)
+			return
+		}
+	})
+}
+
+type purgeActivityInterface interface {
+	PurgeActivityTx(ctx context.Context, arg storage.PurgeActivityTxParams) ([]string, error)
+}
+
+type PurgeResponse struct {
+	Purged bool `json:"purged"`
+}
+
+// PurgeActivity deletes for good an activity in the trash, its data and their uploaded files
+func (appHandler *AppHandler) PurgeActivity(mux chi.Router, db purgeActivityInterface, s3 deleteFilesS3Interface) {
+	mux.Delete("/activities/{activityId}", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		organization := ctx.Value("organization").(*models.Organization)
+
+		activityId, err := primitive.ObjectIDFromHex(chi.URLParam(r, "activityId"))
+		if err != nil {
+			http.Error(w, "ERR_TRASH_ATVT_PRG_ID", http.StatusBadRequest)
+			return
+		}
+
+		files, err := db.PurgeActivityTx(ctx, storage.PurgeActivityTxParams{
+			Id:             activityId,
+			OrganizationId: organization.Id,
+		})
+		if err != nil {
+			http.Error(w, "ERR_TRASH_ATVT_PRG_01", http.StatusBadRequest)
+			return
+		}
+
+		DeleteUploadedFiles(files, s3)
+
+		response := PurgeResponse{
+			Purged: true,
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			http.Error(w, "ERR_TRASH_ATVT_PRG_END", http.StatusBadRequest)
+			return
+		}
+	})
+}
+
+type restoreDataInterface interface {
+	GetActivity(ctx context.Context, arg storage.GetActivityParams) (*models.Activity, error)
+	GetData(ctx context.Context, arg storage.GetDataParams) (*models.Data, error)
+	GetDataFilterByValues(ctx context.Context, arg storage.GetDataFilterByValuesParams) (*models.Data, error)
+	RestoreDataTx(ctx context.Context, arg storage.RestoreDataTxParams) (*models.Data, error)
+}
+
+type RestoreDataResponse struct {
+	Data models.Data `json:"data"`
+}
+
+// RestoreData takes a data out of the trash. The data of an activity in the trash
+// are restored with the activity.
+// The fields of the activity may have changed since the deletion: the pending migration is applied
+// to the values, which are then checked against the current fields like the values of an update.
+// A conflict with the unique values of the data alive is refused with 409.
+func (appHandler *AppHandler) RestoreData(mux chi.Router, db restoreDataInterface) {
+	mux.Post("/activities/{activityId}/data/{dataId}/restore", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		organization := ctx.Value("organization").(*models.Organization)
+
+		activityId, err := primitive.ObjectIDFromHex(chi.URLParam(r, "activityId"))
+		if err != nil {
+			http.Error(w, "ERR_TRASH_DATA_RST_ACTIVITY_ID", http.StatusBadRequest)
+			return
+		}
+		dataId, err := primitive.ObjectIDFromHex(chi.URLParam(r, "dataId"))
+		if err != nil {
+			http.Error(w, "ERR_TRASH_DATA_RST_ID", http.StatusBadRequest)
+			return
+		}
+
+		activity, err := db.GetActivity(ctx, storage.GetActivityParams{
+			Id:             activityId,
+			OrganizationId: organization.Id,
+			WithDeleted:    true,
+		})
+		if err != nil {
+			http.Error(w, "ERR_TRASH_DATA_RST_01", http.StatusBadRequest)
+			return
+		}
+		if activity == nil {
+			http.Error(w, "ERR_TRASH_DATA_RST_NOT_FOUND", http.StatusNotFound)
+			return
+		}
+		if activity.DeletedAt != nil {
+			http.Error(w, "ERR_TRASH_DATA_RST_ACTIVITY_DELETED", http.StatusBadRequest)
+			return
+		}
+
+		data, err := db.GetData(ctx, storage.GetDataParams{
+			Id:          dataId,
+			ActivityId:  activity.Id,
+			WithDeleted: true,
+		})
+		if err != nil {
+			http.Error(w, "ERR_TRASH_DATA_RST_02", http.StatusBadRequest)
+			return
+		}
+		if data == nil || data.DeletedAt == nil {
+			http.Error(w, "ERR_TRASH_DATA_RST_NOT_FOUND", http.StatusNotFound)
+			return
+		}
+
+		// The data in the trash are left out of the migrations
+		restoredValues := data.Values
+		if activity.Migration != nil && data.SchemaVersion < activity.Migration.Version {
+			migration := *activity.Migration
+			migration.Migrations = models.BindFieldMigrations(migration.Migrations, activity.Fields)
+			restoredValues = migration.MigrateValues(restoredValues)
+		}
+
+		dataFormulas, err := loadDataFormulas(ctx, activity, db)
+		if err != nil {
+			http.Error(w, "ERR_TRASH_DATA_RST_FORMULA", http.StatusBadRequest)
+			return
+		}
+
+		fields := make(map[string]bool, len(activity.Fields))
+		for _, field := range activity.Fields {
+			fields[field.Id.Hex()] = !dataFormulas.computes(field.Id.Hex())
+		}
+		inputValues := make(map[string]any, len(restoredValues))
+		for fieldId, value := range restoredValues {
+			if fields[fieldId] && value != nil {
+				inputValues[fieldId] = value
+			}
+		}
+
+		values, fieldErrors, err := validateDataValues(ctx, activity, inputValues, db)
+		if err != nil {
+			http.Error(w, "ERR_TRASH_DATA_RST_VALIDATION", http.StatusBadRequest)
+			return
+		}
+		if len(fieldErrors) > 0 {
+			writeFieldErrors(w, "ERR_TRASH_DATA_RST_INVALID_VALUES", fieldErrors)
+			return
+		}
+
+		_, fieldErrors, err = completeDataValues(ctx, activity, values, dataFormulas)
+		if err != nil {
+			http.Error(w, "ERR_TRASH_DATA_RST_FORMULA", http.StatusBadRequest)
+			return
+		}
+		if len(fieldErrors) > 0 {
+			writeFieldErrors(w, "ERR_TRASH_DATA_RST_INVALID_VALUES", fieldErrors)
+			return
+		}
+
+		if fieldErrors := activity.CheckRules(values, time.Now()); len(fieldErrors) > 0 {
+			writeFieldErrors(w, "ERR_TRASH_DATA_RST_INVALID_VALUES", fieldErrors)
+			return
+		}
+
+		fieldErrors, err = checkDataUniqueness(ctx, activity, values, data.Id, db)
+		if err != nil {
+			http.Error(w, "ERR_TRASH_DATA_RST_UNIQUENESS", http.StatusBadRequest)
+			return
+		}
+		if len(fieldErrors) > 0 {
+			code := uniquenessErrorCode("ERR_TRASH_DATA_RST", fieldErrors)
+			status := http.StatusConflict
+			// a missing primary key conflicts with no data
+			if code == "ERR_TRASH_DATA_RST_PRKEY_NOT_FOUND" {
+				status = http.StatusBadRequest
+			}
+			writeFieldErrorsStatus(w, status, code, fieldErrors)
+			return
+		}
+
+		restoredData, err := db.RestoreDataTx(ctx, storage.RestoreDataTxParams{
+			RestoreDataParams: storage.RestoreDataParams{
+				Id:         data.Id,
+				ActivityId: activity.Id,
+
+				Values:        values,
+				UniqueKeys:    activity.UniqueKeys(values),
+				SchemaVersion: activity.Version,
+			},
+			RestoredBy: dataAuthor(appHandler.GetAuthenticatedUser(r)),
+			Before:     data.Values,
+		})
+		if errors.Is(err, storage.ErrDataNotUnique) {
+			http.Error(w, "ERR_TRASH_DATA_RST_NOT_UNIQUE", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, "ERR_TRASH_DATA_RST_03", http.StatusBadRequest)
+			return
+		}
+		if restoredData == nil {
+			http.Error(w, "ERR_TRASH_DATA_RST_NOT_FOUND", http.StatusNotFound)
+			return
+		}
+
+		response := RestoreDataResponse{
+			Data: *restoredData,
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			http.Error(w, "ERR_TRASH_DATA_RST_END", http.StatusBadRequest)
+			return
+		}
+	})
+}
+
+type purgeDataInterface interface {
+	GetActivity(ctx context.Context, arg storage.GetActivityParams) (*models.Activity, error)
+	PurgeDataTx(ctx context.Context, arg storage.PurgeDataTxParams) ([]string, error)
+}
+
+// PurgeData deletes for good a data in the trash, its history and its uploaded files
+func (appHandler *AppHandler) PurgeData(mux chi.Router, db purgeDataInterface, s3 deleteFilesS3Interface) {
+	mux.Delete("/activities/{activityId}/data/{dataId}", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		organization := ctx.Value("organization").(*models.Organization)
+
+		activityId, err := primitive.ObjectIDFromHex(chi.URLParam(r, "activityId"))
+		if err != nil {
+			http.Error(w, "ERR_TRASH_DATA_PRG_ACTIVITY_ID", http.StatusBadRequest)
+			return
+		}
+		dataId, err := primitive.ObjectIDFromHex(chi.URLParam(r, "dataId"))
+		if err != nil {
+			http.Error(w, "ERR_TRASH_DATA_PRG_ID", http.StatusBadRequest)
+			return
+		}
+
+		// The activity must belong to the organization
+		activity, err := db.GetActivity(ctx, storage.GetActivityParams{
+			Id:             activityId,
+			OrganizationId: organization.Id,
+			WithDeleted:    true,
+		})
+		if err != nil {
+			http.Error(w, "ERR_TRASH_DATA_PRG_01", http.StatusBadRequest)
+			return
+		}
+		if activity == nil {
+			http.Error(w, "ERR_TRASH_DATA_PRG_NOT_FOUND", http.StatusNotFound)
+			return
+		}
+
+		files, err := db.PurgeDataTx(ctx, storage.PurgeDataTxParams{
+			Id:         dataId,
+			ActivityId: activity.Id,
+		})
+		if err != nil {
+			http.Error(w, "ERR_TRASH_DATA_PRG_02", http.StatusBadRequest)
+			return
+		}
+
+		DeleteUploadedFiles(files, s3)
+
+		response := PurgeResponse{
+			Purged: true,
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			http.Error(w, "ERR_TRASH_DATA_PRG_END", http.StatusBadRequest)
+			return
+		}
+	})
+}
+
+type getDeletedOrganizationsInterface interface {
+	GetDeletedOrganizations(ctx context.Context, arg storage.GetDeletedOrganizationsParams) ([]models.TrashItem, error)
+}
+
+// GetDeletedOrganizations lists the organizations owned by the user which are in the trash
+func (appHandler *AppHandler) GetDeletedOrganizations(mux chi.Router, db getDeletedOrganizationsInterface, retention time.Duration) {
+	mux.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authUser := appHandler.GetAuthenticatedUser(r)
+
+		items, err := db.GetDeletedOrganizations(ctx, storage.GetDeletedOrganizationsParams{
+			UserId:    authUser.Id,
+			Retention: retention,
+		})
+		if err != nil {
+			http.Error(w, "ERR_TRASH_CMP_GALL_01", http.StatusBadRequest)
+			return
+		}
+
+		response := GetTrashResponse{
+			Items: items,
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			http.Error(w, "ERR_TRASH_CMP_GALL_END", http.StatusBadRequest)
+			return
+		}
+	})
+}
+
+type getDeletedOrganizationInterface interface {
+	GetOrganization(ctx context.Context, arg storage.GetOrganizationParams) (*models.Organization, error)
+}
+
+// getDeletedOrganization returns the organization in the trash, if it is owned by the user
+func getDeletedOrganization(ctx context.Context, r *http.Request, user *models.User, db getDeletedOrganizationInterface) (*models.Organization, string, int) {
+	organizationId, err := primitive.ObjectIDFromHex(chi.URLParam(r, "organizationId"))
+	if err != nil {
+		return nil, "ID", http.StatusBadRequest
+	}
+
+	organization, err := db.GetOrganization(ctx, storage.GetOrganizationParams{
+		Id:          organizationId,
+		WithDeleted: true,
+	})
+	if err != nil {
+		return nil, "01", http.StatusBadRequest
+	}
+	if organization == nil || organization.DeletedAt == nil || organization.OwnedBy != user.Id {
+		return nil, "NOT_FOUND", http.StatusNotFound
+	}
+
+	return organization, "", 0
+}
+
+type restoreOrganizationInterface interface {
+	GetOrganization(ctx context.Context, arg storage.GetOrganizationParams) (*models.Organization, error)
+	RestoreOrganization(ctx context.Context, arg storage.RestoreOrganizationParams) (*models.Organization, error)
+}
+
+type RestoreOrganizationResponse struct {
+	Organization models.Organization `json:"organization"`
+}
+
+// RestoreOrganization takes an organization out of the trash, as it was when deleted
+func (appHandler *AppHandler) RestoreOrganization(mux chi.Router, db restoreOrganizationInterface) {
+	mux.Post("/{organizationId}/restore", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		organization, code, httpStatus := getDeletedOrganization(ctx, r, appHandler.GetAuthenticatedUser(r), db)
+		if organization == nil {
+			http.Error(w, "ERR_TRASH_CMP_RST_"+code, httpStatus)
+			return
+		}
+
+		restoredOrganization, err := db.RestoreOrganization(ctx, storage.RestoreOrganizationParams{
+			Id: organization.Id,
+		})
+		if err != nil || restoredOrganization == nil {
+			http.Error(w, "ERR_TRASH_CMP_RST_02", http.StatusBadRequest)
+			return
+		}
+
+		response := RestoreOrganizationResponse{
+			Organization: *restoredOrganization,
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			http.Error(w, "ERR_TRASH_CMP_RST_END", http.StatusBadRequest)
+			return
+		}
+	})
+}
+
+type purgeOrganizationInterface interface {
+	GetOrganization(ctx context.Context, arg storage.GetOrganizationParams) (*models.Organization, error)
+	PurgeOrganizationTx(ctx context.Context, arg storage.PurgeOrganizationTxParams) ([]string, error)
+}
+
+// PurgeOrganization deletes for good an organization in the trash, with its team,
+// its activities, their data and the uploaded files
+func (appHandler *AppHandler) PurgeOrganization(mux chi.Router, db purgeOrganizationInterface, s3 deleteFilesS3Interface) {
+	mux.Delete("/{organizationId}", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		organization, code, httpStatus := getDeletedOrganization(ctx, r, appHandler.GetAuthenticatedUser(r), db)
+		if organization == nil {
+			http.Error(w, "ERR_TRASH_CMP_PRG_"+code, httpStatus)
+			return
+		}
+
+		files, err := db.PurgeOrganizationTx(ctx, storage.PurgeOrganizationTxParams{
+			Id: organization.Id,
+		})
+		if err != nil {
+			http.Error(w, "ERR_TRASH_CMP_PRG_02", http.StatusBadRequest)
+			return
+		}
+
+		DeleteUploadedFiles(files, s3)
+
+		response := PurgeResponse{
+			Purged: true,
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			http.Error(w, "ERR_TRASH_CMP_PRG_END", http.StatusBadRequest)
+			return
+		}
+	})
+}
@@ -0,0 +1,683 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	gofaker "github.com/go-faker/faker/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"stockinos.com/api/handlers"
+	"stockinos.com/api/helpertest"
+	"stockinos.com/api/models"
+	"stockinos.com/api/storage"
+	sfaker "syreclabs.com/go/faker"
+)
+
+// mockOrganizationTrashDB keeps one organization and its members, so that the
+// changes of a handler are seen by the next one
+type mockOrganizationTrashDB struct {
+	organization *models.Organization
+	members      []*models.Member
+}
+
+func (mdb *mockOrganizationTrashDB) GetOrganization(ctx context.Context, arg storage.GetOrganizationParams) (*models.Organization, error) {
+	if arg.Id != mdb.organization.Id || (mdb.organization.DeletedAt != nil && !arg.WithDeleted) {
+		return nil, nil
+	}
+	organization := *mdb.organization
+	return &organization, nil
+}
+
+func (mdb *mockOrganizationTrashDB) GetMemberById(ctx context.Context, arg storage.GetMemberByIdParams) (*models.Member, error) {
+	for _, member := range mdb.members {
+		if member.Id == arg.Id && member.OrganizationId == arg.OrganizationId {
+			return member, nil
+		}
+	}
+	return nil, nil
+}
+
+func (mdb *mockOrganizationTrashDB) TransferOwnershipTx(ctx context.Context, arg storage.TransferOwnershipTxParams) (*models.Organization, error) {
+	if mdb.organization.OwnedBy != arg.FromUserId {
+		return nil, nil
+	}
+	for _, member := range mdb.members {
+		switch member.MemberId {
+		case arg.FromUserId:
+			member.Role = models.RoleAdmin
+		case arg.ToUserId:
+			member.Role = models.RoleOwner
+		}
+	}
+	mdb.organization.OwnedBy = arg.ToUserId
+	return mdb.GetOrganization(ctx, storage.GetOrganizationParams{Id: arg.OrganizationId})
+}
+
+func (mdb *mockOrganizationTrashDB) DeleteOrganization(ctx context.Context, arg storage.DeleteOrganizationParams) error {
+	now := time.Now()
+	mdb.organization.DeletedAt = &now
+	mdb.organization.DeletedBy = &arg.DeletedBy
+	return nil
+}
+
+func (mdb *mockOrganizationTrashDB) RestoreOrganization(ctx context.Context, arg storage.RestoreOrganizationParams) (*models.Organization, error) {
+	if arg.Id != mdb.organization.Id || mdb.organization.DeletedAt == nil {
+		return nil, nil
+	}
+	mdb.organization.DeletedAt = nil
+	mdb.organization.DeletedBy = nil
+	return mdb.GetOrganization(ctx, storage.GetOrganizationParams{Id: arg.Id})
+}
+
+func TestRestoreOrganizationAfterTransfer(t *testing.T) {
+	newUser := func() *models.User {
+		return &models.User{
+			Id:          primitive.NewObjectID(),
+			FirstName:   gofaker.FirstName(),
+			LastName:    gofaker.LastName(),
+			PhoneNumber: gofaker.Phonenumber(),
+		}
+	}
+	creator := newUser()
+	newOwner := newUser()
+
+	organization := &models.Organization{
+		Id:        primitive.NewObjectID(),
+		Name:      sfaker.Company().Name(),
+		CreatedBy: creator.Id,
+		OwnedBy:   creator.Id,
+	}
+	newOwnerMember := &models.Member{
+		Id:             primitive.NewObjectID(),
+		OrganizationId: organization.Id,
+		MemberId:       newOwner.Id,
+		Status:         models.MemberStatusConfirmed,
+		Role:           models.RoleAdmin,
+	}
+	db := &mockOrganizationTrashDB{
+		organization: organization,
+		members: []*models.Member{
+			{
+				Id:             primitive.NewObjectID(),
+				OrganizationId: organization.Id,
+				MemberId:       creator.Id,
+				Status:         models.MemberStatusConfirmed,
+				Role:           models.RoleOwner,
+			},
+			newOwnerMember,
+		},
+	}
+
+	var authUser *models.User
+	handler := handlers.NewAppHandler()
+	handler.GetAuthenticatedUser = func(req *http.Request) *models.User {
+		return authUser
+	}
+	// the handlers are given a copy, as the organization middleware would
+	organizationContext := func() []helpertest.ContextData {
+		current, _ := db.GetOrganization(context.Background(), storage.GetOrganizationParams{Id: organization.Id})
+		return []helpertest.ContextData{{Name: "organization", Value: current}}
+	}
+
+	authUser = creator
+	mux := chi.NewMux()
+	handler.TransferOwnership(mux, db)
+	code, _, response := helpertest.MakePostRequest(
+		mux,
+		"/transfer-ownership",
+		helpertest.CreateFormHeader(),
+		handlers.TransferOwnershipRequest{MemberId: newOwnerMember.Id.Hex()},
+		organizationContext(),
+	)
+	if code != http.StatusOK {
+		t.Fatalf("TransferOwnership(): status - got %d; want %d (%s)", code, http.StatusOK, response)
+	}
+
+	authUser = newOwner
+	mux = chi.NewMux()
+	handler.DeleteOrganization(mux, db)
+	code, _, response = helpertest.MakeDeleteRequest(mux, "/", helpertest.CreateFormHeader(), nil, organizationContext())
+	if code != http.StatusOK {
+		t.Fatalf("DeleteOrganization(): status - got %d; want %d (%s)", code, http.StatusOK, response)
+	}
+
+	restoreURL := "/" + organization.Id.Hex() + "/restore"
+
+	t.Run("not restored by its creator", func(t *testing.T) {
+		authUser = creator
+		mux := chi.NewMux()
+		handler.RestoreOrganization(mux, db)
+		code, _, response := helpertest.MakePostRequest(mux, restoreURL, helpertest.CreateFormHeader(), nil, nil)
+		if code != http.StatusNotFound {
+			t.Fatalf("RestoreOrganization(): status - got %d; want %d", code, http.StatusNotFound)
+		}
+		wantError := "ERR_TRASH_CMP_RST_NOT_FOUND"
+		if response != wantError {
+			t.Fatalf("RestoreOrganization(): response error - got %s, want %s", response, wantError)
+		}
+		if db.organization.DeletedAt == nil {
+			t.Fatalf("RestoreOrganization(): the organization is restored; want it in the trash")
+		}
+	})
+
+	t.Run("restored by its owner", func(t *testing.T) {
+		authUser = newOwner
+		mux := chi.NewMux()
+		handler.RestoreOrganization(mux, db)
+		code, _, response := helpertest.MakePostRequest(mux, restoreURL, helpertest.CreateFormHeader(), nil, nil)
+		if code != http.StatusOK {
+			t.Fatalf("RestoreOrganization(): status - got %d; want %d (%s)", code, http.StatusOK, response)
+		}
+
+		got := handlers.RestoreOrganizationResponse{}
+		json.Unmarshal([]byte(response), &got)
+		if got.Organization.Id != organization.Id || got.Organization.DeletedAt != nil {
+			t.Fatalf("RestoreOrganization(): got %+v; want the organization %s out of the trash", got.Organization, organization.Id.Hex())
+		}
+		if got.Organization.OwnedBy != newOwner.Id {
+			t.Fatalf("RestoreOrganization(): owned by %s; want %s", got.Organization.OwnedBy.Hex(), newOwner.Id.Hex())
+		}
+	})
+}
+
+type mockGetTrashDB struct {
+	GetTrashFunc func(ctx context.Context, arg storage.GetTrashParams) ([]models.TrashItem, error)
+}
+
+func (mdb *mockGetTrashDB) GetTrash(ctx context.Context, arg storage.GetTrashParams) ([]models.TrashItem, error) {
+	return mdb.GetTrashFunc(ctx, arg)
+}
+
+type mockRestoreActivityDB struct {
+	RestoreActivityTxFunc func(ctx context.Context, arg storage.RestoreActivityTxParams) (*models.Activity, error)
+	MigrateDataTxFunc     func(ctx context.Context, arg storage.MigrateDataTxParams) error
+}
+
+func (mdb *mockRestoreActivityDB) RestoreActivityTx(ctx context.Context, arg storage.RestoreActivityTxParams) (*models.Activity, error) {
+	return mdb.RestoreActivityTxFunc(ctx, arg)
+}
+
+// MigrateDataTx migrates nothing, unless told otherwise
+func (mdb *mockRestoreActivityDB) MigrateDataTx(ctx context.Context, arg storage.MigrateDataTxParams) error {
+	if mdb.MigrateDataTxFunc == nil {
+		return nil
+	}
+	return mdb.MigrateDataTxFunc(ctx, arg)
+}
+
+// RefreshDataUniqueKeysTx refreshes nothing, the keys of the data being tested with the storage
+func (mdb *mockRestoreActivityDB) RefreshDataUniqueKeysTx(ctx context.Context, arg storage.RefreshDataUniqueKeysTxParams) error {
+	return nil
+}
+
+type mockPurgeActivityDB struct {
+	PurgeActivityTxFunc func(ctx context.Context, arg storage.PurgeActivityTxParams) ([]string, error)
+}
+
+func (mdb *mockPurgeActivityDB) PurgeActivityTx(ctx context.Context, arg storage.PurgeActivityTxParams) ([]string, error) {
+	return mdb.PurgeActivityTxFunc(ctx, arg)
+}
+
+type mockRestoreDataDB struct {
+	GetActivityFunc           func(ctx context.Context, arg storage.GetActivityParams) (*models.Activity, error)
+	GetDataFunc               func(ctx context.Context, arg storage.GetDataParams) (*models.Data, error)
+	GetDataFilterByValuesFunc func(ctx context.Context, arg storage.GetDataFilterByValuesParams) (*models.Data, error)
+	RestoreDataTxFunc         func(ctx context.Context, arg storage.RestoreDataTxParams) (*models.Data, error)
+}
+
+func (mdb *mockRestoreDataDB) GetActivity(ctx context.Context, arg storage.GetActivityParams) (*models.Activity, error) {
+	return mdb.GetActivityFunc(ctx, arg)
+}
+
+func (mdb *mockRestoreDataDB) GetData(ctx context.Context, arg storage.GetDataParams) (*models.Data, error) {
+	return mdb.GetDataFunc(ctx, arg)
+}
+
+// GetDataFilterByValues finds no other data with the same values, unless told otherwise
+func (mdb *mockRestoreDataDB) GetDataFilterByValues(ctx context.Context, arg storage.GetDataFilterByValuesParams) (*models.Data, error) {
+	if mdb.GetDataFilterByValuesFunc == nil {
+		return nil, nil
+	}
+	return mdb.GetDataFilterByValuesFunc(ctx, arg)
+}
+
+func (mdb *mockRestoreDataDB) RestoreDataTx(ctx context.Context, arg storage.RestoreDataTxParams) (*models.Data, error) {
+	return mdb.RestoreDataTxFunc(ctx, arg)
+}
+
+type mockPurgeDataDB struct {
+	GetActivityFunc func(ctx context.Context, arg storage.GetActivityParams) (*models.Activity, error)
+	PurgeDataTxFunc func(ctx context.Context, arg storage.PurgeDataTxParams) ([]string, error)
+}
+
+func (mdb *mockPurgeDataDB) GetActivity(ctx context.Context, arg storage.GetActivityParams) (*models.Activity, error) {
+	return mdb.GetActivityFunc(ctx, arg)
+}
+
+func (mdb *mockPurgeDataDB) PurgeDataTx(ctx context.Context, arg storage.PurgeDataTxParams) ([]string, error) {
+	return mdb.PurgeDataTxFunc(ctx, arg)
+}
+
+// mockPurgeS3 records the files deleted, which are deleted concurrently
+type mockPurgeS3 struct {
+	mu      sync.Mutex
+	deleted []string
+}
+
+func (s3 *mockPurgeS3) DeleteFile(uploadKey string) error {
+	s3.mu.Lock()
+	defer s3.mu.Unlock()
+	s3.deleted = append(s3.deleted, uploadKey)
+	return nil
+}
+
+func TestTrash(t *testing.T) {
+	handler := handlers.NewAppHandler()
+	handler.GetAuthenticatedUser = func(req *http.Request) *models.User {
+		return authenticatedUser
+	}
+	organization := &models.Organization{
+		Id:   primitive.NewObjectID(),
+		Name: sfaker.Company().Name(),
+	}
+	ctxData := []helpertest.ContextData{{Name: "organization", Value: organization}}
+
+	checkError := func(t *testing.T, name string, code int, response string, wantCode int, wantError string) {
+		t.Helper()
+		if code != wantCode {
+			t.Fatalf("%s(): status - got %d; want %d (%s)", name, code, wantCode, response)
+		}
+		// the errors of the values come with the errors of their fields
+		if strings.HasPrefix(response, "{") {
+			validationError := handlers.DataValidationErrorResponse{}
+			json.Unmarshal([]byte(response), &validationError)
+			response = validationError.Error
+		}
+		if response != wantError {
+			t.Fatalf("%s(): response error - got %s; want %s", name, response, wantError)
+		}
+	}
+
+	t.Run("get trash", func(t *testing.T) {
+		db := &mockGetTrashDB{
+			GetTrashFunc: func(ctx context.Context, arg storage.GetTrashParams) ([]models.TrashItem, error) {
+				if arg.OrganizationId != organization.Id || arg.Type != models.TrashItemData || arg.Retention != 30*24*time.Hour {
+					t.Errorf("GetTrash(): got %+v; want the data of the organization", arg)
+				}
+				return []models.TrashItem{{Type: models.TrashItemData, Id: primitive.NewObjectID()}}, nil
+			},
+		}
+		mux := chi.NewMux()
+		handler.GetTrash(mux, db, 30*24*time.Hour)
+
+		_, w, response := helpertest.MakeGetRequest(mux, "/?type=user", ctxData)
+		checkError(t, "GetTrash", w.StatusCode, response, http.StatusBadRequest, "ERR_TRASH_GALL_TYPE")
+
+		_, w, response = helpertest.MakeGetRequest(mux, "/?type=Data", ctxData)
+		if w.StatusCode != http.StatusOK {
+			t.Fatalf("GetTrash(): status - got %d; want %d (%s)", w.StatusCode, http.StatusOK, response)
+		}
+		got := handlers.GetTrashResponse{}
+		json.Unmarshal([]byte(response), &got)
+		if len(got.Items) != 1 || got.Items[0].Type != models.TrashItemData {
+			t.Fatalf("GetTrash(): got %+v; want the deleted data", got.Items)
+		}
+	})
+
+	t.Run("restore activity", func(t *testing.T) {
+		activityId := primitive.NewObjectID()
+		testCases := map[string]struct {
+			ActivityId    string
+			Activity      *models.Activity
+			Err           error
+			Status        int
+			ResponseError string
+		}{
+			"invalid id": {
+				ActivityId:    "not-an-id",
+				Status:        http.StatusBadRequest,
+				ResponseError: "ERR_TRASH_ATVT_RST_ID",
+			},
+			"values used meanwhile": {
+				Err:           storage.ErrDataNotUnique,
+				Status:        http.StatusConflict,
+				ResponseError: "ERR_TRASH_ATVT_RST_NOT_UNIQUE",
+			},
+			"not in the trash": {
+				Status:        http.StatusNotFound,
+				ResponseError: "ERR_TRASH_ATVT_RST_NOT_FOUND",
+			},
+		}
+
+		for name, tc := range testCases {
+			t.Run(name, func(t *testing.T) {
+				target := tc.ActivityId
+				if target == "" {
+					target = activityId.Hex()
+				}
+				mux := chi.NewMux()
+				handler.RestoreActivity(mux, &mockRestoreActivityDB{
+					RestoreActivityTxFunc: func(ctx context.Context, arg storage.RestoreActivityTxParams) (*models.Activity, error) {
+						return tc.Activity, tc.Err
+					},
+				})
+				code, _, response := helpertest.MakePostRequest(mux, "/activities/"+target+"/restore", helpertest.CreateFormHeader(), nil, ctxData)
+				checkError(t, "RestoreActivity", code, response, tc.Status, tc.ResponseError)
+			})
+		}
+
+		t.Run("migration resumed", func(t *testing.T) {
+			migrated := false
+			mux := chi.NewMux()
+			handler.RestoreActivity(mux, &mockRestoreActivityDB{
+				RestoreActivityTxFunc: func(ctx context.Context, arg storage.RestoreActivityTxParams) (*models.Activity, error) {
+					return &models.Activity{
+						Id:             arg.Id,
+						OrganizationId: arg.OrganizationId,
+						Version:        2,
+						Migration:      &models.ActivityMigration{Version: 2},
+					}, nil
+				},
+				MigrateDataTxFunc: func(ctx context.Context, arg storage.MigrateDataTxParams) error {
+					migrated = arg.Activity.Id == activityId
+					return nil
+				},
+			})
+			code, _, response := helpertest.MakePostRequest(mux, "/activities/"+activityId.Hex()+"/restore", helpertest.CreateFormHeader(), nil, ctxData)
+			if code != http.StatusOK {
+				t.Fatalf("RestoreActivity(): status - got %d; want %d (%s)", code, http.StatusOK, response)
+			}
+			got := handlers.RestoreActivityResponse{}
+			json.Unmarshal([]byte(response), &got)
+			if !migrated || got.Activity.Migration != nil {
+				t.Fatalf("RestoreActivity(): got %+v; want the migration of the data done", got.Activity)
+			}
+		})
+	})
+
+	t.Run("purge activity", func(t *testing.T) {
+		activityId := primitive.NewObjectID()
+		s3 := &mockPurgeS3{}
+		purged := false
+		db := &mockPurgeActivityDB{
+			PurgeActivityTxFunc: func(ctx context.Context, arg storage.PurgeActivityTxParams) ([]string, error) {
+				if arg.Id != activityId || arg.OrganizationId != organization.Id {
+					return nil, errors.New("not in the trash")
+				}
+				purged = true
+				return []string{handlers.AWS_S3_ROOT + "files/a.png", handlers.AWS_S3_ROOT + "files/b.pdf"}, nil
+			},
+		}
+		mux := chi.NewMux()
+		handler.PurgeActivity(mux, db, s3)
+
+		code, _, response := helpertest.MakeDeleteRequest(mux, "/activities/not-an-id", helpertest.CreateFormHeader(), nil, ctxData)
+		checkError(t, "PurgeActivity", code, response, http.StatusBadRequest, "ERR_TRASH_ATVT_PRG_ID")
+
+		code, _, response = helpertest.MakeDeleteRequest(mux, "/activities/"+primitive.NewObjectID().Hex(), helpertest.CreateFormHeader(), nil, ctxData)
+		checkError(t, "PurgeActivity", code, response, http.StatusBadRequest, "ERR_TRASH_ATVT_PRG_01")
+
+		code, _, response = helpertest.MakeDeleteRequest(mux, "/activities/"+activityId.Hex(), helpertest.CreateFormHeader(), nil, ctxData)
+		if code != http.StatusOK || !purged {
+			t.Fatalf("PurgeActivity(): status - got %d; want %d (%s)", code, http.StatusOK, response)
+		}
+		sort.Strings(s3.deleted)
+		if len(s3.deleted) != 2 || s3.deleted[0] != "files/a.png" || s3.deleted[1] != "files/b.pdf" {
+			t.Fatalf("PurgeActivity(): deleted files - got %v; want the keys of the 2 files", s3.deleted)
+		}
+	})
+
+	t.Run("restore data", func(t *testing.T) {
+		activity := &models.Activity{
+			Id:             primitive.NewObjectID(),
+			OrganizationId: organization.Id,
+			Fields: []models.ActivityField{
+				{Id: primitive.NewObjectID(), Name: "Reference", Type: models.FieldTypeText, PrimaryKey: true},
+			},
+		}
+		deletedAt := time.Now().Add(-time.Hour)
+		reference := activity.Fields[0].Id.Hex()
+		deletedData := &models.Data{
+			Id:         primitive.NewObjectID(),
+			ActivityId: activity.Id,
+			Values:     map[string]any{reference: "D-1"},
+			DeletedAt:  &deletedAt,
+		}
+		deletedActivity := *activity
+		deletedActivity.DeletedAt = &deletedAt
+		liveData := *deletedData
+		liveData.DeletedAt = nil
+		invalidData := *deletedData
+		invalidData.Values = map[string]any{reference: []any{"D-1", "D-2"}}
+
+		testCases := map[string]struct {
+			Target                string
+			Activity              *models.Activity
+			Data                  *models.Data
+			GetDataFilterByValues func(ctx context.Context, arg storage.GetDataFilterByValuesParams) (*models.Data, error)
+			RestoreErr            error
+			Status                int
+			ResponseError         string
+		}{
+			"invalid activity id": {
+				Target:        "/activities/not-an-id/data/" + deletedData.Id.Hex() + "/restore",
+				Status:        http.StatusBadRequest,
+				ResponseError: "ERR_TRASH_DATA_RST_ACTIVITY_ID",
+			},
+			"invalid data id": {
+				Target:        "/activities/" + activity.Id.Hex() + "/data/not-an-id/restore",
+				Status:        http.StatusBadRequest,
+				ResponseError: "ERR_TRASH_DATA_RST_ID",
+			},
+			"activity of another organization": {
+				Status:        http.StatusNotFound,
+				ResponseError: "ERR_TRASH_DATA_RST_NOT_FOUND",
+			},
+			"activity in the trash": {
+				Activity:      &deletedActivity,
+				Data:          deletedData,
+				Status:        http.StatusBadRequest,
+				ResponseError: "ERR_TRASH_DATA_RST_ACTIVITY_DELETED",
+			},
+			"data not in the trash": {
+				Activity:      activity,
+				Data:          &liveData,
+				Status:        http.StatusNotFound,
+				ResponseError: "ERR_TRASH_DATA_RST_NOT_FOUND",
+			},
+			"primary key used since": {
+				Activity: activity,
+				Data:     deletedData,
+				GetDataFilterByValues: func(ctx context.Context, arg storage.GetDataFilterByValuesParams) (*models.Data, error) {
+					return &models.Data{Id: primitive.NewObjectID(), ActivityId: activity.Id}, nil
+				},
+				Status:        http.StatusConflict,
+				ResponseError: "ERR_TRASH_DATA_RST_PRKEY_ALREADY_USED",
+			},
+			"primary key used meanwhile": {
+				Activity:      activity,
+				Data:          deletedData,
+				RestoreErr:    storage.ErrDataNotUnique,
+				Status:        http.StatusConflict,
+				ResponseError: "ERR_TRASH_DATA_RST_NOT_UNIQUE",
+			},
+			"value invalid for the field": {
+				Activity:      activity,
+				Data:          &invalidData,
+				Status:        http.StatusBadRequest,
+				ResponseError: "ERR_TRASH_DATA_RST_INVALID_VALUES",
+			},
+		}
+
+		for name, tc := range testCases {
+			t.Run(name, func(t *testing.T) {
+				target := tc.Target
+				if target == "" {
+					target = "/activities/" + activity.Id.Hex() + "/data/" + deletedData.Id.Hex() + "/restore"
+				}
+				db := &mockRestoreDataDB{
+					GetActivityFunc: func(ctx context.Context, arg storage.GetActivityParams) (*models.Activity, error) {
+						return tc.Activity, nil
+					},
+					GetDataFunc: func(ctx context.Context, arg storage.GetDataParams) (*models.Data, error) {
+						return tc.Data, nil
+					},
+					GetDataFilterByValuesFunc: tc.GetDataFilterByValues,
+					RestoreDataTxFunc: func(ctx context.Context, arg storage.RestoreDataTxParams) (*models.Data, error) {
+						if tc.RestoreErr == nil {
+							t.Errorf("RestoreData(): the data is restored; want the restore rejected")
+						}
+						return nil, tc.RestoreErr
+					},
+				}
+				mux := chi.NewMux()
+				handler.RestoreData(mux, db)
+				code, _, response := helpertest.MakePostRequest(mux, target, helpertest.CreateFormHeader(), nil, ctxData)
+				checkError(t, "RestoreData", code, response, tc.Status, tc.ResponseError)
+			})
+		}
+
+		t.Run("restored", func(t *testing.T) {
+			var restored storage.RestoreDataTxParams
+			db := &mockRestoreDataDB{
+				GetActivityFunc: func(ctx context.Context, arg storage.GetActivityParams) (*models.Activity, error) {
+					if arg.Id != activity.Id || arg.OrganizationId != organization.Id {
+						return nil, nil
+					}
+					return activity, nil
+				},
+				GetDataFunc: func(ctx context.Context, arg storage.GetDataParams) (*models.Data, error) {
+					return deletedData, nil
+				},
+				RestoreDataTxFunc: func(ctx context.Context, arg storage.RestoreDataTxParams) (*models.Data, error) {
+					restored = arg
+					return &liveData, nil
+				},
+			}
+			mux := chi.NewMux()
+			handler.RestoreData(mux, db)
+			target := "/activities/" + activity.Id.Hex() + "/data/" + deletedData.Id.Hex() + "/restore"
+			code, _, response := helpertest.MakePostRequest(mux, target, helpertest.CreateFormHeader(), nil, ctxData)
+			if code != http.StatusOK {
+				t.Fatalf("RestoreData(): status - got %d; want %d (%s)", code, http.StatusOK, response)
+			}
+			if restored.Id != deletedData.Id || len(restored.UniqueKeys) != 1 || restored.RestoredBy.Id != authenticatedUser.Id {
+				t.Fatalf("RestoreDataTx(): got %+v; want the data restored with its unique key by the user", restored)
+			}
+			got := handlers.RestoreDataResponse{}
+			json.Unmarshal([]byte(response), &got)
+			if got.Data.Id != deletedData.Id || got.Data.DeletedAt != nil {
+				t.Fatalf("RestoreData(): got %+v; want the data out of the trash", got.Data)
+			}
+		})
+
+		t.Run("migrated", func(t *testing.T) {
+			// the amount was a text when the data was deleted, and its values are cleared since
+			amount := primitive.NewObjectID()
+			removed := primitive.NewObjectID()
+			migratedActivity := *activity
+			migratedActivity.Version = 2
+			migratedActivity.Fields = append([]models.ActivityField{{Id: amount, Name: "Amount", Type: models.FieldTypeNumber}}, activity.Fields...)
+			migratedActivity.Migration = &models.ActivityMigration{
+				Version: 2,
+				Migrations: []models.FieldMigration{
+					{FieldId: amount.Hex(), From: models.FieldTypeText, To: models.FieldTypeNumber, Strategy: models.MigrationClear},
+				},
+			}
+			oldData := *deletedData
+			oldData.SchemaVersion = 1
+			oldData.Values = map[string]any{reference: "D-1", amount.Hex(): "12", removed.Hex(): "gone"}
+
+			var restored storage.RestoreDataTxParams
+			db := &mockRestoreDataDB{
+				GetActivityFunc: func(ctx context.Context, arg storage.GetActivityParams) (*models.Activity, error) {
+					return &migratedActivity, nil
+				},
+				GetDataFunc: func(ctx context.Context, arg storage.GetDataParams) (*models.Data, error) {
+					return &oldData, nil
+				},
+				RestoreDataTxFunc: func(ctx context.Context, arg storage.RestoreDataTxParams) (*models.Data, error) {
+					restored = arg
+					return &liveData, nil
+				},
+			}
+			mux := chi.NewMux()
+			handler.RestoreData(mux, db)
+			target := "/activities/" + activity.Id.Hex() + "/data/" + deletedData.Id.Hex() + "/restore"
+			code, _, response := helpertest.MakePostRequest(mux, target, helpertest.CreateFormHeader(), nil, ctxData)
+			if code != http.StatusOK {
+				t.Fatalf("RestoreData(): status - got %d; want %d (%s)", code, http.StatusOK, response)
+			}
+			if len(restored.Values) != 1 || restored.Values[reference] != "D-1" || restored.SchemaVersion != 2 {
+				t.Fatalf("RestoreDataTx(): got %+v; want only the reference, at the version 2 of the fields", restored.RestoreDataParams)
+			}
+			if len(restored.Before) != 3 {
+				t.Fatalf("RestoreDataTx(): before - got %v; want the values in the trash", restored.Before)
+			}
+		})
+	})
+
+	t.Run("purge data", func(t *testing.T) {
+		activity := &models.Activity{Id: primitive.NewObjectID(), OrganizationId: organization.Id}
+		dataId := primitive.NewObjectID()
+		db := &mockPurgeDataDB{
+			GetActivityFunc: func(ctx context.Context, arg storage.GetActivityParams) (*models.Activity, error) {
+				if arg.Id != activity.Id || arg.OrganizationId != organization.Id {
+					return nil, nil
+				}
+				return activity, nil
+			},
+			PurgeDataTxFunc: func(ctx context.Context, arg storage.PurgeDataTxParams) ([]string, error) {
+				if arg.Id != dataId || arg.ActivityId != activity.Id {
+					return nil, errors.New("not in the trash")
+				}
+				return []string{}, nil
+			},
+		}
+		mux := chi.NewMux()
+		handler.PurgeData(mux, db, &mockPurgeS3{})
+
+		code, _, response := helpertest.MakeDeleteRequest(mux, "/activities/"+activity.Id.Hex()+"/data/not-an-id", helpertest.CreateFormHeader(), nil, ctxData)
+		checkError(t, "PurgeData", code, response, http.StatusBadRequest, "ERR_TRASH_DATA_PRG_ID")
+
+		code, _, response = helpertest.MakeDeleteRequest(mux, "/activities/"+primitive.NewObjectID().Hex()+"/data/"+dataId.Hex(), helpertest.CreateFormHeader(), nil, ctxData)
+		checkError(t, "PurgeData", code, response, http.StatusNotFound, "ERR_TRASH_DATA_PRG_NOT_FOUND")
+
+		code, _, response = helpertest.MakeDeleteRequest(mux, "/activities/"+activity.Id.Hex()+"/data/"+primitive.NewObjectID().Hex(), helpertest.CreateFormHeader(), nil, ctxData)
+		checkError(t, "PurgeData", code, response, http.StatusBadRequest, "ERR_TRASH_DATA_PRG_02")
+
+		code, _, response = helpertest.MakeDeleteRequest(mux, "/activities/"+activity.Id.Hex()+"/data/"+dataId.Hex(), helpertest.CreateFormHeader(), nil, ctxData)
+		if code != http.StatusOK {
+			t.Fatalf("PurgeData(): status - got %d; want %d (%s)", code, http.StatusOK, response)
+		}
+	})
+
+	t.Run("organization of another owner", func(t *testing.T) {
+		deletedAt := time.Now()
+		deleted := &models.Organization{
+			Id:        primitive.NewObjectID(),
+			OwnedBy:   primitive.NewObjectID(),
+			DeletedAt: &deletedAt,
+		}
+		db := &mockOrganizationTrashDB{organization: deleted}
+		mux := chi.NewMux()
+		handler.RestoreOrganization(mux, db)
+
+		code, _, response := helpertest.MakePostRequest(mux, "/not-an-id/restore", helpertest.CreateFormHeader(), nil, nil)
+		checkError(t, "RestoreOrganization", code, response, http.StatusBadRequest, "ERR_TRASH_CMP_RST_ID")
+
+		code, _, response = helpertest.MakePostRequest(mux, "/"+deleted.Id.Hex()+"/restore", helpertest.CreateFormHeader(), nil, nil)
+		checkError(t, "RestoreOrganization", code, response, http.StatusNotFound, "ERR_TRASH_CMP_RST_NOT_FOUND")
+		if deleted.DeletedAt == nil {
+			t.Fatalf("RestoreOrganization(): the organization is restored; want it in the trash")
+		}
+	})
+}
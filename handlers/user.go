@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"log"
 	"net/http"
@@ -9,6 +10,7 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"stockinos.com/api/models"
 	"stockinos.com/api/services"
+	"stockinos.com/api/storage"
 )
 
 type GetCurrentUserResponse struct {
@@ -50,3 +52,89 @@ func GetCurrentUser(mux chi.Router) {
 		}
 	})
 }
+
+type switchCurrentOrganizationInterface interface {
+	GetOrganization(ctx context.Context, arg storage.GetOrganizationParams) (*models.Organization, error)
+	GetMember(ctx context.Context, arg storage.GetMemberParams) (*models.Member, error)
+	UpdateUserPreferences(ctx context.Context, arg storage.UpdateUserPreferencesParams) (*models.User, error)
+}
+
+type SwitchCurrentOrganizationRequest struct {
+	OrganizationId string `json:"organization_id"`
+}
+
+// SwitchCurrentOrganization changes the organization the user works in, among the ones the user is a member of
+func (appHandler *AppHandler) SwitchCurrentOrganization(mux chi.Router, db switchCurrentOrganizationInterface) {
+	mux.Put("/current-organization", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		user := appHandler.GetAuthenticatedUser(r)
+
+		var input SwitchCurrentOrganizationRequest
+		httpStatus, err := appHandler.ParsingRequestBody(w, r, &input)
+		if err != nil {
+			http.Error(w, err.Error(), httpStatus)
+			return
+		}
+
+		organizationId, err := primitive.ObjectIDFromHex(input.OrganizationId)
+		if err != nil {
+			http.Error(w, "ERR_USR_SWO_01", http.StatusBadRequest)
+			return
+		}
+
+		organization, err := db.GetOrganization(ctx, storage.GetOrganizationParams{
+			Id: organizationId,
+		})
+		if err != nil {
+			http.Error(w, "ERR_USR_SWO_02", http.StatusBadRequest)
+			return
+		}
+		if organization == nil {
+			http.Error(w, "ERR_USR_SWO_03", http.StatusNotFound)
+			return
+		}
+
+		member, err := db.GetMember(ctx, storage.GetMemberParams{
+			OrganizationId: organization.Id,
+			UserId:         user.Id,
+		})
+		if err != nil {
+			http.Error(w, "ERR_USR_SWO_02", http.StatusBadRequest)
+			return
+		}
+		// The organizations created before the roles have no member for their owner
+		isMember := member != nil && member.Status == models.MemberStatusConfirmed
+		if !isMember && organization.OwnedBy != user.Id {
+			http.Error(w, "ERR_USR_SWO_03", http.StatusNotFound)
+			return
+		}
+
+		user, err = db.UpdateUserPreferences(ctx, storage.UpdateUserPreferencesParams{
+			Id: user.Id,
+			Changes: map[string]any{
+				"current_organization_id": organization.Id,
+			},
+		})
+		if err != nil || user == nil {
+			http.Error(w, "ERR_USR_SWO_04", http.StatusBadRequest)
+			return
+		}
+
+		response := GetCurrentUserResponse{
+			FirstName:   user.FirstName,
+			LastName:    user.LastName,
+			Email:       user.Email,
+			PhoneNumber: user.PhoneNumber,
+			ID:          user.Id,
+
+			Preferences: user.Preferences,
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			http.Error(w, "ERR_USR_SWO_END", http.StatusBadRequest)
+			return
+		}
+	})
+}
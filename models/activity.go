@@ -1,6 +1,8 @@
 package models
 
 import (
+	"encoding/json"
+	"strings"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -12,6 +14,8 @@ type ActivityFieldOptions struct {
 	Automatic    bool    `bson:"automatic" json:"automatic"`
 	DefaultValue *string `bson:"default_value" json:"default_value"`
 	Reference    *string `bson:"reference" json:"reference"` // Is it an id from another activity
+	// Computes the value of an automatic field from the other values, see the package formulas
+	Formula *string `bson:"formula,omitempty" json:"formula,omitempty"`
 }
 
 type ActivityFieldUpload struct {
@@ -30,48 +34,191 @@ type ActivityFieldKey struct {
 	FieldToUseId primitive.ObjectID `bson:"field_to_use_id" json:"field_to_use_id"`
 }
 
+// ActivityFieldNumber details an integer or a decimal
+type ActivityFieldNumber struct {
+	Precision int    `bson:"precision" json:"precision"` // number of decimals kept, always 0 for an integer
+	Unit      string `bson:"unit" json:"unit"`           // shown next to the values, like kg or m²
+}
+
+type ActivityFieldCurrency struct {
+	Currency string `bson:"currency" json:"currency"` // ISO 4217 code, like XAF or EUR
+}
+
+type ActivityFieldPhone struct {
+	DefaultCountryCode string `bson:"default_country_code" json:"default_country_code"` // used for the numbers written without one, like 237
+}
+
+type ActivityFieldBarcode struct {
+	Formats []string `bson:"barcode_formats" json:"barcode_formats"` // any format when empty, see BarcodeFormats
+}
+
+type ActivityFieldRating struct {
+	MaxRating   int  `bson:"max_rating" json:"max_rating"`
+	HalfRatings bool `bson:"half_ratings" json:"half_ratings"`
+}
+
+type ActivityFieldDateTime struct {
+	TimeZone string `bson:"time_zone" json:"time_zone"` // IANA name, used for the values written without an offset and to show them
+}
+
+type ActivityFieldRichText struct {
+	Format string `bson:"text_format" json:"text_format"` // markdown or html
+}
+
+// ActivityFieldType holds the details of the type of a field, one of them at most being set.
+// Their keys are all different as they are inlined.
 type ActivityFieldType struct {
 	*ActivityFieldMultipleChoices `bson:",inline" json:",inline"`
 	*ActivityFieldKey             `bson:",inline" json:",inline"`
 	*ActivityFieldUpload          `bson:",inline" json:",inline"`
+	*ActivityFieldNumber          `bson:",inline" json:",inline"`
+	*ActivityFieldCurrency        `bson:",inline" json:",inline"`
+	*ActivityFieldPhone           `bson:",inline" json:",inline"`
+	*ActivityFieldBarcode         `bson:",inline" json:",inline"`
+	*ActivityFieldRating          `bson:",inline" json:",inline"`
+	*ActivityFieldDateTime        `bson:",inline" json:",inline"`
+	*ActivityFieldRichText        `bson:",inline" json:",inline"`
 }
 
 func NewActivityFieldType(fieldType string) ActivityFieldType {
 	switch fieldType {
 	case "multiple-choices":
 		return ActivityFieldType{
-			&ActivityFieldMultipleChoices{
+			ActivityFieldMultipleChoices: &ActivityFieldMultipleChoices{
 				Multiple: false,
 				Choices:  []string{},
 			},
-			nil,
-			nil,
 		}
 	case "key":
 		return ActivityFieldType{
-			nil,
-			&ActivityFieldKey{
+			ActivityFieldKey: &ActivityFieldKey{
 				ActivityId: primitive.NilObjectID,
 				FieldId:    primitive.NilObjectID,
 			},
-			nil,
 		}
 	case "upload":
 		return ActivityFieldType{
-			nil,
-			nil,
-			&ActivityFieldUpload{
+			ActivityFieldUpload: &ActivityFieldUpload{
 				TypeOfFiles:      []string{},
 				MaxNumberOfFiles: 0,
 			},
 		}
-
-	default:
+	case "integer":
 		return ActivityFieldType{
-			ActivityFieldMultipleChoices: nil,
-			ActivityFieldKey:             nil,
-			ActivityFieldUpload:          nil,
+			ActivityFieldNumber: &ActivityFieldNumber{
+				Precision: 0,
+				Unit:      "",
+			},
 		}
+	case "decimal":
+		return ActivityFieldType{
+			ActivityFieldNumber: &ActivityFieldNumber{
+				Precision: 2,
+				Unit:      "",
+			},
+		}
+	case "currency":
+		return ActivityFieldType{
+			ActivityFieldCurrency: &ActivityFieldCurrency{
+				Currency: "",
+			},
+		}
+	case "phone":
+		return ActivityFieldType{
+			ActivityFieldPhone: &ActivityFieldPhone{
+				DefaultCountryCode: "",
+			},
+		}
+	case "barcode":
+		return ActivityFieldType{
+			ActivityFieldBarcode: &ActivityFieldBarcode{
+				Formats: []string{},
+			},
+		}
+	case "rating":
+		return ActivityFieldType{
+			ActivityFieldRating: &ActivityFieldRating{
+				MaxRating:   5,
+				HalfRatings: false,
+			},
+		}
+	case "date-time":
+		return ActivityFieldType{
+			ActivityFieldDateTime: &ActivityFieldDateTime{
+				TimeZone: "UTC",
+			},
+		}
+	case "rich-text":
+		return ActivityFieldType{
+			ActivityFieldRichText: &ActivityFieldRichText{
+				Format: "markdown",
+			},
+		}
+
+	default:
+		return ActivityFieldType{}
+	}
+}
+
+// DecodeActivityFieldType reads the details of a field of the type from their JSON, the details
+// missing keeping their default value and the details of the other types being ignored
+func DecodeActivityFieldType(fieldType string, data []byte) (ActivityFieldType, error) {
+	details := NewActivityFieldType(fieldType)
+	if err := json.Unmarshal(data, &details); err != nil {
+		return ActivityFieldType{}, err
+	}
+
+	switch fieldType {
+	case FieldTypeMultipleChoices:
+		return ActivityFieldType{ActivityFieldMultipleChoices: details.ActivityFieldMultipleChoices}, nil
+	case FieldTypeKey:
+		return ActivityFieldType{ActivityFieldKey: details.ActivityFieldKey}, nil
+	case FieldTypeUpload:
+		return ActivityFieldType{ActivityFieldUpload: details.ActivityFieldUpload}, nil
+	case FieldTypeInteger, FieldTypeDecimal:
+		return ActivityFieldType{ActivityFieldNumber: details.ActivityFieldNumber}, nil
+	case FieldTypeCurrency:
+		return ActivityFieldType{ActivityFieldCurrency: details.ActivityFieldCurrency}, nil
+	case FieldTypePhone:
+		return ActivityFieldType{ActivityFieldPhone: details.ActivityFieldPhone}, nil
+	case FieldTypeBarcode:
+		return ActivityFieldType{ActivityFieldBarcode: details.ActivityFieldBarcode}, nil
+	case FieldTypeRating:
+		return ActivityFieldType{ActivityFieldRating: details.ActivityFieldRating}, nil
+	case FieldTypeDateTime:
+		return ActivityFieldType{ActivityFieldDateTime: details.ActivityFieldDateTime}, nil
+	case FieldTypeRichText:
+		return ActivityFieldType{ActivityFieldRichText: details.ActivityFieldRichText}, nil
+	default:
+		return ActivityFieldType{}, nil
+	}
+}
+
+// Value returns the details set as they are stored, nil when the type has none
+func (details ActivityFieldType) Value() any {
+	switch {
+	case details.ActivityFieldMultipleChoices != nil:
+		return *details.ActivityFieldMultipleChoices
+	case details.ActivityFieldKey != nil:
+		return *details.ActivityFieldKey
+	case details.ActivityFieldUpload != nil:
+		return *details.ActivityFieldUpload
+	case details.ActivityFieldNumber != nil:
+		return *details.ActivityFieldNumber
+	case details.ActivityFieldCurrency != nil:
+		return *details.ActivityFieldCurrency
+	case details.ActivityFieldPhone != nil:
+		return *details.ActivityFieldPhone
+	case details.ActivityFieldBarcode != nil:
+		return *details.ActivityFieldBarcode
+	case details.ActivityFieldRating != nil:
+		return *details.ActivityFieldRating
+	case details.ActivityFieldDateTime != nil:
+		return *details.ActivityFieldDateTime
+	case details.ActivityFieldRichText != nil:
+		return *details.ActivityFieldRichText
+	default:
+		return nil
 	}
 }
 
@@ -79,11 +226,21 @@ type ActivityField struct {
 	Id          primitive.ObjectID   `bson:"_id" json:"id"`
 	Name        string               `bson:"name" json:"name"`
 	Description string               `bson:"description" json:"description"`
-	Type        string               `bson:"type" json:"type"`       // Text, Number, Date, Time, Uploaded file
+	Type        string               `bson:"type" json:"type"`       // see the FieldType constants
 	PrimaryKey  bool                 `bons:"key" json:"primary_key"` // Is it an identifier?
+	Unique      bool                 `bson:"unique" json:"unique"`   // Can a value be used only once?
 	Options     ActivityFieldOptions `bson:"options" json:"options"` // There can be options
 	Code        string               `bson:"code" json:"code"`       // the id associated to the field, created internally
 	Details     ActivityFieldType    `bson:"details" json:"details"`
+	// Constraints on the values, beyond their type
+	Rules *ActivityFieldRules `bson:"rules,omitempty" json:"rules,omitempty"`
+	// When the field is shown and required, depending on the values of the other fields
+	Conditions *ActivityFieldConditions `bson:"conditions,omitempty" json:"conditions,omitempty"`
+}
+
+// HasFormula tells if the value of the field is computed from the other values of the data
+func (field ActivityField) HasFormula() bool {
+	return field.Options.Automatic && field.Options.Formula != nil && strings.TrimSpace(*field.Options.Formula) != ""
 }
 
 type ActivityRelationshipDetail struct {
@@ -105,6 +262,12 @@ type Activity struct {
 	Description   string                 `bson:"description" json:"description"`
 	Fields        []ActivityField        `bson:"fields" json:"fields"`
 	Relationships []ActivityRelationship `bson:"relationships" json:"relationships"`
+	// Version of the fields, increased on each change of the fields
+	Version int `bson:"version" json:"version"`
+	// Migration of the values of the data still in progress, if any
+	Migration *ActivityMigration `bson:"migration,omitempty" json:"migration,omitempty"`
+	// Refresh of the unique keys of the data still in progress, if any
+	UniqueKeysRefresh *UniqueKeysRefresh `bson:"unique_keys_refresh,omitempty" json:"unique_keys_refresh,omitempty"`
 
 	CreatedAt time.Time  `bson:"created_at" json:"created_at"`
 	UpdatedAt time.Time  `bson:"updated_at" json:"updated_at"`
@@ -112,4 +275,5 @@ type Activity struct {
 
 	OrganizationId primitive.ObjectID `bson:"organization_id" json:"organization_id"`
 	CreatedBy      primitive.ObjectID `bson:"created_by" json:"created_by"`
+	DeletedBy      *DataAuthor        `bson:"deleted_by,omitempty" json:"deleted_by,omitempty"`
 }
@@ -0,0 +1,99 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ApiKeyPrefix tells the API keys apart from the access tokens
+const ApiKeyPrefix = "stk_"
+
+// PermDataWrite is a scope of the API keys only, for pushing data: creating, updating and importing them
+const PermDataWrite Permission = "data:write"
+
+// ApiKeyPermissions are the permissions an API key can be given, the others are kept to the members
+var ApiKeyPermissions = []Permission{
+	PermOrganizationRead,
+	PermActivityRead,
+	PermDataRead, PermDataCreate, PermDataUpdate, PermDataDelete, PermDataImport, PermDataExport,
+}
+
+// ApiKeyScope is something an API key can do, on the data of an activity only or on all of them
+type ApiKeyScope struct {
+	Permission Permission          `bson:"permission" json:"permission"`
+	ActivityId *primitive.ObjectID `bson:"activity_id,omitempty" json:"activity_id,omitempty"`
+}
+
+// ApiKey lets a machine client act in an organization without a member signing in
+type ApiKey struct {
+	Id             primitive.ObjectID `bson:"_id" json:"id"`
+	OrganizationId primitive.ObjectID `bson:"organization_id" json:"organization_id"`
+	Name           string             `bson:"name" json:"name"`
+	// the last characters of the key, to recognize it
+	Hint    string `bson:"hint" json:"hint"`
+	KeyHash string `bson:"key_hash" json:"-"` // see services.HashApiKey
+	// no scopes: every permission of ApiKeyPermissions
+	Scopes []ApiKeyScope `bson:"scopes" json:"scopes"`
+
+	CreatedBy  DataAuthor `bson:"created_by" json:"created_by"`
+	CreatedAt  time.Time  `bson:"created_at" json:"created_at"`
+	ExpiresAt  *time.Time `bson:"expires_at" json:"expires_at"`
+	LastUsedAt *time.Time `bson:"last_used_at" json:"last_used_at"`
+	RevokedAt  *time.Time `bson:"revoked_at" json:"revoked_at,omitempty"`
+}
+
+// IsApiKeyScope tells if an API key can be given the permission
+func IsApiKeyScope(permission Permission) bool {
+	if permission == PermDataWrite {
+		return true
+	}
+	for _, p := range ApiKeyPermissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// grants tells if the scope covers the permission
+func (scope ApiKeyScope) grants(permission Permission) bool {
+	if scope.Permission == PermDataWrite {
+		return permission == PermDataCreate || permission == PermDataUpdate || permission == PermDataImport
+	}
+	return scope.Permission == permission
+}
+
+// Can tells if the key has the permission, on the activity when the route is about one
+func (key ApiKey) Can(permission Permission, activityId primitive.ObjectID) bool {
+	if permission == PermDataWrite || !IsApiKeyScope(permission) {
+		return false
+	}
+	if len(key.Scopes) == 0 {
+		return true
+	}
+
+	for _, scope := range key.Scopes {
+		if !scope.grants(permission) {
+			continue
+		}
+		if scope.ActivityId == nil || *scope.ActivityId == activityId {
+			return true
+		}
+	}
+	return false
+}
+
+// IsActive tells if the key can be used
+func (key ApiKey) IsActive(now time.Time) bool {
+	return key.RevokedAt == nil && (key.ExpiresAt == nil || now.Before(*key.ExpiresAt))
+}
+
+// AsUser is who the requests of the key are made as, the key itself
+// so that its changes are told apart from the ones of the members
+func (key ApiKey) AsUser() *User {
+	return &User{
+		Id:       key.Id,
+		LastName: key.Name,
+	}
+}
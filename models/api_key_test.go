@@ -0,0 +1,69 @@
+package models_test
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"stockinos.com/api/models"
+)
+
+func TestApiKeyCan(t *testing.T) {
+	activityId := primitive.NewObjectID()
+	otherActivityId := primitive.NewObjectID()
+
+	unscoped := models.ApiKey{}
+	scoped := models.ApiKey{
+		Scopes: []models.ApiKeyScope{
+			{Permission: models.PermDataRead, ActivityId: &activityId},
+			{Permission: models.PermDataWrite},
+		},
+	}
+
+	tests := []struct {
+		name       string
+		key        models.ApiKey
+		permission models.Permission
+		activityId primitive.ObjectID
+		want       bool
+	}{
+		{"no scopes, data", unscoped, models.PermDataDelete, activityId, true},
+		{"no scopes, member only", unscoped, models.PermTeamManage, primitive.NilObjectID, false},
+		{"no scopes, managing keys", unscoped, models.PermApiKeyManage, primitive.NilObjectID, false},
+		{"read on its activity", scoped, models.PermDataRead, activityId, true},
+		{"read on another activity", scoped, models.PermDataRead, otherActivityId, false},
+		{"write covers create", scoped, models.PermDataCreate, otherActivityId, true},
+		{"write covers import", scoped, models.PermDataImport, activityId, true},
+		{"write does not cover delete", scoped, models.PermDataDelete, activityId, false},
+		{"write is not a permission", scoped, models.PermDataWrite, activityId, false},
+	}
+
+	for _, test := range tests {
+		if got := test.key.Can(test.permission, test.activityId); got != test.want {
+			t.Errorf("%s: Can(%q) = %v; want %v", test.name, test.permission, got, test.want)
+		}
+	}
+}
+
+func TestApiKeyIsActive(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Hour)
+	future := now.Add(time.Hour)
+
+	tests := []struct {
+		name string
+		key  models.ApiKey
+		want bool
+	}{
+		{"no expiry", models.ApiKey{}, true},
+		{"not expired", models.ApiKey{ExpiresAt: &future}, true},
+		{"expired", models.ApiKey{ExpiresAt: &past}, false},
+		{"revoked", models.ApiKey{RevokedAt: &past}, false},
+	}
+
+	for _, test := range tests {
+		if got := test.key.IsActive(now); got != test.want {
+			t.Errorf("%s: IsActive() = %v; want %v", test.name, got, test.want)
+		}
+	}
+}
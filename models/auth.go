@@ -1,6 +1,10 @@
 package models
 
-import "go.mongodb.org/mongo-driver/bson/primitive"
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
 
 type UserPreferencesOrganization struct {
 	Id   primitive.ObjectID `bson:"_id" json:"id"`
@@ -11,6 +15,8 @@ type UserPreferences struct {
 	// Organization  UserPreferencesOrganization `bson:"organization" json:"organization,omitempty"`
 	CurrentOrganizationId primitive.ObjectID `bson:"current_organization_id" json:"current_organization_id"`
 	CurrentStatus         string             `bson:"current_status" json:"current_status"`
+	// the channel of the last code received, see services.OTPDispatcher
+	OTPChannel string `bson:"otp_channel,omitempty" json:"otp_channel,omitempty"`
 }
 
 type User struct {
@@ -26,7 +32,36 @@ type User struct {
 type OTP struct {
 	Id          primitive.ObjectID `bson:"_id" json:"id"`
 	WaMessageId string             `bson:"wa_message_id" json:"wa_message_id,omitempty"`
-	PhoneNumber string             `bson:"phone_number" json:"phone_number,omitempty"`
-	PinCode     string             `bson:"pin_code" json:"pin_code,omitempty"`
-	Active      bool               `bson:"active,omitempty" json:"active,omitempty"`
+	// how the code was sent, the id of the message is given by the provider of the channel
+	Channel     string `bson:"channel,omitempty" json:"channel,omitempty"`
+	MessageId   string `bson:"message_id,omitempty" json:"message_id,omitempty"`
+	PhoneNumber string `bson:"phone_number" json:"phone_number,omitempty"`
+	PinCode     string `bson:"pin_code" json:"-"` // hashed, see services.HashOTP
+	Active      bool   `bson:"active,omitempty" json:"active,omitempty"`
+	// Number of wrong codes entered
+	Attempts int `bson:"attempts" json:"attempts"`
+
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+	ExpiresAt time.Time `bson:"expires_at" json:"expires_at"`
+}
+
+// Session is a device signed in, it lasts as long as its refresh token is used
+type Session struct {
+	Id     primitive.ObjectID `bson:"_id" json:"id"`
+	UserId primitive.ObjectID `bson:"user_id" json:"user_id"`
+	// hashed, see services.HashRefreshToken. The previous token is kept to detect when it is used again,
+	// only a stolen token would be.
+	RefreshTokenHash         string `bson:"refresh_token_hash" json:"-"`
+	PreviousRefreshTokenHash string `bson:"previous_refresh_token_hash,omitempty" json:"-"`
+
+	UserAgent string `bson:"user_agent" json:"user_agent"`
+	IP        string `bson:"ip" json:"ip"`
+
+	CreatedAt  time.Time  `bson:"created_at" json:"created_at"`
+	LastUsedAt time.Time  `bson:"last_used_at" json:"last_used_at"`
+	ExpiresAt  time.Time  `bson:"expires_at" json:"expires_at"`
+	RevokedAt  *time.Time `bson:"revoked_at" json:"revoked_at,omitempty"`
+
+	// the session of the request
+	Current bool `bson:"-" json:"current"`
 }
@@ -17,6 +17,10 @@ type Data struct {
 	// value: the value entered by the user
 	// value type: depends on the type associated to the field when creating the activity
 	Values map[string]any `bson:"values" json:"values"`
+	// keys of the unique constraints of the activity, see Activity.UniqueKeys
+	UniqueKeys []string `bson:"unique_keys,omitempty" json:"-"`
+	// version of the fields of the activity the values were written with
+	SchemaVersion int `bson:"schema_version" json:"schema_version"`
 
 	CreatedAt time.Time  `bson:"created_at" json:"created_at"`
 	UpdatedAt time.Time  `bson:"updated_at" json:"updated_at"`
@@ -24,6 +28,9 @@ type Data struct {
 
 	ActivityId primitive.ObjectID `bson:"activity_id" json:"activity_id"`
 	CreatedBy  DataAuthor         `bson:"created_by" json:"created_by"`
+	DeletedBy  *DataAuthor        `bson:"deleted_by,omitempty" json:"deleted_by,omitempty"`
+	// the activity deleted along with the data, the data are restored with it
+	DeletedWith *primitive.ObjectID `bson:"deleted_with,omitempty" json:"-"`
 }
 
 type UploadedFile struct {
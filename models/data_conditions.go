@@ -0,0 +1,403 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Operators of the conditions on the value of a field
+const (
+	ConditionEq       = "eq"
+	ConditionNe       = "ne"
+	ConditionGt       = "gt"
+	ConditionGte      = "gte"
+	ConditionLt       = "lt"
+	ConditionLte      = "lte"
+	ConditionIn       = "in"
+	ConditionNin      = "nin"
+	ConditionContains = "contains"
+	ConditionIsNull   = "isnull"
+	ConditionNotNull  = "notnull"
+)
+
+// ActivityFieldConditions tell when a field is shown and when it is required.
+// The values of a hidden field are dropped, and it is never required.
+type ActivityFieldConditions struct {
+	// shown only when the condition is true, always when nil
+	VisibleIf *FieldCondition `bson:"visible_if,omitempty" json:"visible_if,omitempty"`
+	// required when the condition is true, in addition to the rules
+	RequiredIf *FieldCondition `bson:"required_if,omitempty" json:"required_if,omitempty"`
+}
+
+// FieldCondition is either a group of conditions, all or any of them being true,
+// or a comparison of the value of a field of the activity:
+//   - eq, ne: equal to the value, or containing it for a list
+//   - gt, gte, lt, lte: for the numbers, the dates and the times
+//   - isnull and notnull only for a location
+//   - in, nin: one of the values of a list
+//   - contains: an item of a list, or a part of a text whatever the case
+//   - isnull, notnull: without a value
+type FieldCondition struct {
+	All []FieldCondition `bson:"all,omitempty" json:"all,omitempty"`
+	Any []FieldCondition `bson:"any,omitempty" json:"any,omitempty"`
+
+	FieldId  string `bson:"field_id,omitempty" json:"field_id,omitempty"`
+	Operator string `bson:"operator,omitempty" json:"operator,omitempty"`
+	Value    any    `bson:"value,omitempty" json:"value,omitempty"`
+}
+
+// fieldIds returns the fields the condition depends on
+func (condition FieldCondition) fieldIds() []string {
+	if condition.FieldId != "" {
+		return []string{condition.FieldId}
+	}
+	fieldIds := make([]string, 0)
+	for _, group := range [][]FieldCondition{condition.All, condition.Any} {
+		for _, c := range group {
+			fieldIds = append(fieldIds, c.fieldIds()...)
+		}
+	}
+	return fieldIds
+}
+
+// validate checks the condition against the fields of the activity
+func (condition FieldCondition) validate(fields map[string]ActivityField) error {
+	isGroup := len(condition.All) > 0 || len(condition.Any) > 0
+	if isGroup {
+		if condition.FieldId != "" || (len(condition.All) > 0 && len(condition.Any) > 0) {
+			return fmt.Errorf("a condition is either all, any, or a comparison of a field")
+		}
+		for _, group := range [][]FieldCondition{condition.All, condition.Any} {
+			for _, c := range group {
+				if err := c.validate(fields); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	field, ok := fields[condition.FieldId]
+	if !ok {
+		return fmt.Errorf("unknown field %q", condition.FieldId)
+	}
+
+	switch condition.Operator {
+	case ConditionIsNull, ConditionNotNull:
+		return nil
+	}
+	if field.Type == FieldTypeGeolocation {
+		return fmt.Errorf("a location can only be compared with isnull or notnull")
+	}
+
+	switch condition.Operator {
+	case ConditionIn, ConditionNin:
+		items, ok := toList(condition.Value)
+		if !ok {
+			return fmt.Errorf("%s expects a list of values", condition.Operator)
+		}
+		for _, item := range items {
+			if _, err := field.castConditionValue(item); err != nil {
+				return fmt.Errorf("%s: %s", condition.FieldId, err.Message)
+			}
+		}
+		return nil
+	case ConditionGt, ConditionGte, ConditionLt, ConditionLte:
+		switch field.Type {
+		case FieldTypeDate, FieldTypeTime, FieldTypeDateTime:
+		default:
+			if IsNumberType(field.Type) {
+				break
+			}
+			return fmt.Errorf("%s can not be used on a %s field", condition.Operator, field.Type)
+		}
+	case ConditionEq, ConditionNe, ConditionContains:
+	default:
+		return fmt.Errorf("unknown operator %q", condition.Operator)
+	}
+
+	if condition.Value == nil {
+		return fmt.Errorf("%s expects a value", condition.Operator)
+	}
+	if condition.Operator == ConditionContains && !field.IsList() {
+		if _, ok := condition.Value.(string); !ok {
+			return fmt.Errorf("contains expects a text")
+		}
+		return nil
+	}
+	if _, err := field.castConditionValue(condition.Value); err != nil {
+		return fmt.Errorf("%s: %s", condition.FieldId, err.Message)
+	}
+	return nil
+}
+
+// castConditionValue casts a value compared to the values of the field, one item for a list
+func (field ActivityField) castConditionValue(value any) (any, *FieldError) {
+	switch field.Type {
+	case FieldTypeMultipleChoices, FieldTypeUpload:
+		v, ok := value.(string)
+		if !ok {
+			return nil, newFieldError(field, "ERR_FIELD_NOT_TEXT", "a text is expected")
+		}
+		return v, nil
+	default:
+		return field.castOne(value)
+	}
+}
+
+// Evaluate tells if the condition is true for the values of a data, once cast
+func (condition FieldCondition) Evaluate(fields map[string]ActivityField, values map[string]any) bool {
+	if len(condition.All) > 0 {
+		for _, c := range condition.All {
+			if !c.Evaluate(fields, values) {
+				return false
+			}
+		}
+		return true
+	}
+	if len(condition.Any) > 0 {
+		for _, c := range condition.Any {
+			if c.Evaluate(fields, values) {
+				return true
+			}
+		}
+		return false
+	}
+
+	field, ok := fields[condition.FieldId]
+	if !ok {
+		return false
+	}
+	value := values[condition.FieldId]
+	items, isList := toList(value)
+	isNull := isEmptyValue(value)
+	if !isList {
+		items = []any{value}
+	}
+
+	switch condition.Operator {
+	case ConditionIsNull:
+		return isNull
+	case ConditionNotNull:
+		return !isNull
+	case ConditionNe, ConditionNin:
+		if isNull {
+			return true
+		}
+	}
+	if isNull {
+		return false
+	}
+
+	expected := make([]any, 0)
+	if condition.Operator == ConditionIn || condition.Operator == ConditionNin {
+		list, _ := toList(condition.Value)
+		for _, item := range list {
+			if v, err := field.castConditionValue(item); err == nil {
+				expected = append(expected, v)
+			}
+		}
+	} else if condition.Operator == ConditionContains && !isList {
+		text, _ := value.(string)
+		part, _ := condition.Value.(string)
+		return part != "" && strings.Contains(strings.ToLower(text), strings.ToLower(part))
+	} else if v, err := field.castConditionValue(condition.Value); err == nil {
+		expected = append(expected, v)
+	} else {
+		return false
+	}
+
+	switch condition.Operator {
+	case ConditionEq, ConditionIn, ConditionContains:
+		return containsAny(items, expected)
+	case ConditionNe, ConditionNin:
+		return !containsAny(items, expected)
+	default:
+		for _, item := range items {
+			order, ok := compareValues(item, expected[0])
+			if !ok {
+				return false
+			}
+			switch condition.Operator {
+			case ConditionGt:
+				ok = order > 0
+			case ConditionGte:
+				ok = order >= 0
+			case ConditionLt:
+				ok = order < 0
+			case ConditionLte:
+				ok = order <= 0
+			}
+			if !ok {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// ValidateFieldConditions checks the conditions of all the fields, and that a field is not
+// shown depending on itself
+func ValidateFieldConditions(fields []ActivityField) []FieldError {
+	fieldsById := make(map[string]ActivityField, len(fields))
+	for _, field := range fields {
+		fieldsById[field.Id.Hex()] = field
+	}
+
+	errors := make([]FieldError, 0)
+	for _, field := range fields {
+		if field.Conditions == nil {
+			continue
+		}
+		if condition := field.Conditions.VisibleIf; condition != nil {
+			if err := condition.validate(fieldsById); err != nil {
+				errors = append(errors, *newFieldError(field, "ERR_FIELD_INVALID_CONDITIONS", "visible_if: %s", err))
+				continue
+			}
+		}
+		if condition := field.Conditions.RequiredIf; condition != nil {
+			if err := condition.validate(fieldsById); err != nil {
+				errors = append(errors, *newFieldError(field, "ERR_FIELD_INVALID_CONDITIONS", "required_if: %s", err))
+			}
+		}
+	}
+	if len(errors) > 0 {
+		return errors
+	}
+
+	if _, cycle := visibilityOrder(fields); cycle != nil {
+		errors = append(errors, *newFieldError(*cycle, "ERR_FIELD_INVALID_CONDITIONS", "visible_if: the field is shown depending on itself"))
+	}
+	return errors
+}
+
+// visibilityOrder returns the fields in the order their visibility is decided, the fields their
+// conditions depend on first. It returns a field shown depending on itself, if any.
+func visibilityOrder(fields []ActivityField) ([]ActivityField, *ActivityField) {
+	fieldsById := make(map[string]ActivityField, len(fields))
+	for _, field := range fields {
+		fieldsById[field.Id.Hex()] = field
+	}
+
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	order := make([]ActivityField, 0, len(fields))
+	states := make(map[string]int)
+	var cycle *ActivityField
+	var visit func(field ActivityField)
+	visit = func(field ActivityField) {
+		fieldId := field.Id.Hex()
+		switch states[fieldId] {
+		case visiting:
+			if cycle == nil {
+				cycle = &field
+			}
+			return
+		case visited:
+			return
+		}
+		states[fieldId] = visiting
+		if field.Conditions != nil && field.Conditions.VisibleIf != nil {
+			for _, dependency := range field.Conditions.VisibleIf.fieldIds() {
+				if dependencyField, ok := fieldsById[dependency]; ok {
+					visit(dependencyField)
+				}
+			}
+		}
+		states[fieldId] = visited
+		order = append(order, field)
+	}
+	for _, field := range fields {
+		visit(field)
+	}
+	return order, cycle
+}
+
+// HiddenFields returns the fields hidden by their conditions for the values of a data.
+// A field is hidden when its condition is false, the values of the other hidden fields being ignored.
+func (activity Activity) HiddenFields(values map[string]any) map[string]bool {
+	hidden := make(map[string]bool)
+	if !activity.hasConditions() {
+		return hidden
+	}
+
+	fieldsById := make(map[string]ActivityField, len(activity.Fields))
+	for _, field := range activity.Fields {
+		fieldsById[field.Id.Hex()] = field
+	}
+	visibleValues := make(map[string]any, len(values))
+	for key, value := range values {
+		visibleValues[key] = value
+	}
+
+	order, _ := visibilityOrder(activity.Fields)
+	for _, field := range order {
+		if field.Conditions == nil || field.Conditions.VisibleIf == nil {
+			continue
+		}
+		if !field.Conditions.VisibleIf.Evaluate(fieldsById, visibleValues) {
+			hidden[field.Id.Hex()] = true
+			delete(visibleValues, field.Id.Hex())
+		}
+	}
+	return hidden
+}
+
+// StripHiddenValues drops the values of the hidden fields, and returns the ids of the fields whose value was dropped
+func (activity Activity) StripHiddenValues(values map[string]any) []string {
+	stripped := make([]string, 0)
+	for fieldId := range activity.HiddenFields(values) {
+		if _, ok := values[fieldId]; ok {
+			delete(values, fieldId)
+			stripped = append(stripped, fieldId)
+		}
+	}
+	return stripped
+}
+
+func (activity Activity) hasConditions() bool {
+	for _, field := range activity.Fields {
+		if field.Conditions != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAny(items, expected []any) bool {
+	for _, item := range items {
+		for _, e := range expected {
+			if order, ok := compareValues(item, e); (ok && order == 0) || item == e {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// compareValues orders two numbers, or two texts written in a sortable layout like the dates and the times
+func compareValues(a, b any) (int, bool) {
+	if x, ok := NumberValue(a); ok {
+		y, ok := NumberValue(b)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	}
+	if x, ok := a.(string); ok {
+		y, ok := b.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(x, y), true
+	}
+	return 0, false
+}
@@ -0,0 +1,187 @@
+package models_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"stockinos.com/api/models"
+)
+
+func TestFieldConditionEvaluate(t *testing.T) {
+	status := models.ActivityField{Id: primitive.NewObjectID(), Type: models.FieldTypeText}
+	weight := models.ActivityField{Id: primitive.NewObjectID(), Type: models.FieldTypeNumber}
+	tags := models.ActivityField{
+		Id:      primitive.NewObjectID(),
+		Type:    models.FieldTypeMultipleChoices,
+		Details: models.ActivityFieldType{ActivityFieldMultipleChoices: &models.ActivityFieldMultipleChoices{Multiple: true}},
+	}
+	notes := models.ActivityField{Id: primitive.NewObjectID(), Type: models.FieldTypeText}
+	fields := map[string]models.ActivityField{
+		notes.Id.Hex():  notes,
+		status.Id.Hex(): status,
+		weight.Id.Hex(): weight,
+		tags.Id.Hex():   tags,
+	}
+	values := map[string]any{
+		status.Id.Hex(): "Damaged",
+		weight.Id.Hex(): 12.5,
+		tags.Id.Hex():   []string{"fragile", "urgent"},
+	}
+
+	tests := map[string]struct {
+		condition models.FieldCondition
+		want      bool
+	}{
+		"eq": {
+			condition: models.FieldCondition{FieldId: status.Id.Hex(), Operator: "eq", Value: "Damaged"},
+			want:      true,
+		},
+		"ne on a missing value": {
+			condition: models.FieldCondition{FieldId: notes.Id.Hex(), Operator: "ne", Value: "x"},
+			want:      true,
+		},
+		"gt on a number given as a text": {
+			condition: models.FieldCondition{FieldId: weight.Id.Hex(), Operator: "gt", Value: "10"},
+			want:      true,
+		},
+		"lte": {
+			condition: models.FieldCondition{FieldId: weight.Id.Hex(), Operator: "lte", Value: 12},
+			want:      false,
+		},
+		"in": {
+			condition: models.FieldCondition{FieldId: status.Id.Hex(), Operator: "in", Value: []any{"Lost", "Damaged"}},
+			want:      true,
+		},
+		"eq on a list contains": {
+			condition: models.FieldCondition{FieldId: tags.Id.Hex(), Operator: "eq", Value: "urgent"},
+			want:      true,
+		},
+		"nin on a list": {
+			condition: models.FieldCondition{FieldId: tags.Id.Hex(), Operator: "nin", Value: []any{"fragile"}},
+			want:      false,
+		},
+		"contains a part of a text": {
+			condition: models.FieldCondition{FieldId: status.Id.Hex(), Operator: "contains", Value: "damage"},
+			want:      true,
+		},
+		"isnull": {
+			condition: models.FieldCondition{FieldId: weight.Id.Hex(), Operator: "isnull"},
+			want:      false,
+		},
+		"all": {
+			condition: models.FieldCondition{All: []models.FieldCondition{
+				{FieldId: status.Id.Hex(), Operator: "eq", Value: "Damaged"},
+				{FieldId: weight.Id.Hex(), Operator: "lt", Value: 10},
+			}},
+			want: false,
+		},
+		"any": {
+			condition: models.FieldCondition{Any: []models.FieldCondition{
+				{FieldId: status.Id.Hex(), Operator: "eq", Value: "Lost"},
+				{FieldId: weight.Id.Hex(), Operator: "gte", Value: 10},
+			}},
+			want: true,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := tt.condition.Evaluate(fields, values); got != tt.want {
+				t.Errorf("Evaluate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateFieldConditions(t *testing.T) {
+	status := models.ActivityField{Id: primitive.NewObjectID(), Type: models.FieldTypeText}
+	withVisibleIf := func(condition models.FieldCondition) models.ActivityField {
+		return models.ActivityField{
+			Id:         primitive.NewObjectID(),
+			Type:       models.FieldTypeText,
+			Conditions: &models.ActivityFieldConditions{VisibleIf: &condition},
+		}
+	}
+
+	tests := map[string]struct {
+		fields  []models.ActivityField
+		wantErr bool
+	}{
+		"valid": {
+			fields: []models.ActivityField{status, withVisibleIf(models.FieldCondition{FieldId: status.Id.Hex(), Operator: "eq", Value: "Damaged"})},
+		},
+		"unknown field": {
+			fields:  []models.ActivityField{status, withVisibleIf(models.FieldCondition{FieldId: primitive.NewObjectID().Hex(), Operator: "isnull"})},
+			wantErr: true,
+		},
+		"unknown operator": {
+			fields:  []models.ActivityField{status, withVisibleIf(models.FieldCondition{FieldId: status.Id.Hex(), Operator: "like", Value: "x"})},
+			wantErr: true,
+		},
+		"order on a text": {
+			fields:  []models.ActivityField{status, withVisibleIf(models.FieldCondition{FieldId: status.Id.Hex(), Operator: "gt", Value: "x"})},
+			wantErr: true,
+		},
+		"in without a list": {
+			fields:  []models.ActivityField{status, withVisibleIf(models.FieldCondition{FieldId: status.Id.Hex(), Operator: "in", Value: "x"})},
+			wantErr: true,
+		},
+		"shown depending on itself": {
+			fields: func() []models.ActivityField {
+				a := withVisibleIf(models.FieldCondition{})
+				b := withVisibleIf(models.FieldCondition{FieldId: a.Id.Hex(), Operator: "notnull"})
+				a.Conditions.VisibleIf = &models.FieldCondition{FieldId: b.Id.Hex(), Operator: "notnull"}
+				return []models.ActivityField{a, b}
+			}(),
+			wantErr: true,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if errors := models.ValidateFieldConditions(tt.fields); (len(errors) > 0) != tt.wantErr {
+				t.Errorf("ValidateFieldConditions() = %v, wantErr %v", errors, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestActivityConditions(t *testing.T) {
+	status := models.ActivityField{Id: primitive.NewObjectID(), Type: models.FieldTypeText}
+	damage := models.ActivityField{
+		Id:   primitive.NewObjectID(),
+		Type: models.FieldTypeText,
+		Conditions: &models.ActivityFieldConditions{
+			VisibleIf: &models.FieldCondition{FieldId: status.Id.Hex(), Operator: "eq", Value: "Damaged"},
+		},
+		Rules: &models.ActivityFieldRules{Required: true},
+	}
+	photo := models.ActivityField{
+		Id:   primitive.NewObjectID(),
+		Type: models.FieldTypeUpload,
+		Conditions: &models.ActivityFieldConditions{
+			VisibleIf:  &models.FieldCondition{FieldId: damage.Id.Hex(), Operator: "notnull"},
+			RequiredIf: &models.FieldCondition{FieldId: damage.Id.Hex(), Operator: "eq", Value: "Broken"},
+		},
+	}
+	activity := models.Activity{Fields: []models.ActivityField{photo, damage, status}}
+
+	values := map[string]any{
+		status.Id.Hex(): "Delivered",
+		damage.Id.Hex(): "Broken",
+		photo.Id.Hex():  []string{"photo.jpg"},
+	}
+	stripped := activity.StripHiddenValues(values)
+	if len(stripped) != 2 || !reflect.DeepEqual(values, map[string]any{status.Id.Hex(): "Delivered"}) {
+		t.Errorf("StripHiddenValues() = %v, values = %v, want the damage and the photo dropped", stripped, values)
+	}
+	if errors := activity.CheckRules(values, time.Now()); len(errors) != 0 {
+		t.Errorf("CheckRules() = %v, want no error on the hidden fields", errors)
+	}
+
+	values = map[string]any{status.Id.Hex(): "Damaged", damage.Id.Hex(): "Broken"}
+	errors := activity.CheckRules(values, time.Now())
+	if len(errors) != 1 || errors[0].FieldId != photo.Id.Hex() || errors[0].Code != "ERR_FIELD_REQUIRED" {
+		t.Errorf("CheckRules() = %v, want the photo required", errors)
+	}
+}
@@ -0,0 +1,80 @@
+package models
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	DataHistoryCreated  = "created"
+	DataHistoryImported = "imported"
+	DataHistoryUpdated  = "updated"
+	DataHistoryDeleted  = "deleted"
+	DataHistoryRestored = "restored"
+	DataHistoryMigrated = "migrated"
+)
+
+type DataChange struct {
+	FieldId string `bson:"field_id" json:"field_id"`
+	Before  any    `bson:"before" json:"before"`
+	After   any    `bson:"after" json:"after"`
+}
+
+// DataHistory is an entry of the history of a data, never updated nor deleted
+type DataHistory struct {
+	Id         primitive.ObjectID `bson:"_id" json:"id"`
+	DataId     primitive.ObjectID `bson:"data_id" json:"data_id"`
+	ActivityId primitive.ObjectID `bson:"activity_id" json:"activity_id"`
+
+	Action  string       `bson:"action" json:"action"`
+	Changes []DataChange `bson:"changes" json:"changes"`
+	// The values of the data once the action done, used to restore this version
+	Values map[string]any `bson:"values" json:"values"`
+	// The entry restored, for a restored action
+	RestoredFrom *primitive.ObjectID `bson:"restored_from,omitempty" json:"restored_from,omitempty"`
+
+	Author    DataAuthor `bson:"author" json:"author"`
+	CreatedAt time.Time  `bson:"created_at" json:"created_at"`
+}
+
+// DiffValues returns the changes of each field between two versions of the values, sorted by field id.
+// A nil map stands for a data which does not exist.
+func DiffValues(before, after map[string]any) []DataChange {
+	fieldIds := make([]string, 0, len(before)+len(after))
+	for fieldId := range before {
+		fieldIds = append(fieldIds, fieldId)
+	}
+	for fieldId := range after {
+		if _, ok := before[fieldId]; !ok {
+			fieldIds = append(fieldIds, fieldId)
+		}
+	}
+	sort.Strings(fieldIds)
+
+	changes := make([]DataChange, 0)
+	for _, fieldId := range fieldIds {
+		if sameValue(before[fieldId], after[fieldId]) {
+			continue
+		}
+		changes = append(changes, DataChange{
+			FieldId: fieldId,
+			Before:  before[fieldId],
+			After:   after[fieldId],
+		})
+	}
+	return changes
+}
+
+// sameValue compares the JSON of the values: a list read from the database
+// is not of the same type as the list it was written from
+func sameValue(a, b any) bool {
+	encodedA, errA := json.Marshal(a)
+	encodedB, errB := json.Marshal(b)
+	if errA != nil || errB != nil {
+		return false
+	}
+	return string(encodedA) == string(encodedB)
+}
@@ -0,0 +1,48 @@
+package models_test
+
+import (
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"stockinos.com/api/models"
+)
+
+func TestDiffValues(t *testing.T) {
+	tests := map[string]struct {
+		before map[string]any
+		after  map[string]any
+		want   []models.DataChange
+	}{
+		"creation": {
+			before: nil,
+			after:  map[string]any{"b": 2.0, "a": "x"},
+			want: []models.DataChange{
+				{FieldId: "a", Before: nil, After: "x"},
+				{FieldId: "b", Before: nil, After: 2.0},
+			},
+		},
+		"update": {
+			before: map[string]any{"a": "x", "b": 2.0, "c": "removed"},
+			after:  map[string]any{"a": "x", "b": 3.0},
+			want: []models.DataChange{
+				{FieldId: "b", Before: 2.0, After: 3.0},
+				{FieldId: "c", Before: "removed", After: nil},
+			},
+		},
+		"same list of another type": {
+			before: map[string]any{"a": primitive.A{"x", "y"}},
+			after:  map[string]any{"a": []string{"x", "y"}},
+			want:   []models.DataChange{},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := models.DiffValues(tc.before, tc.after)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("DiffValues() = %#v; want %#v", got, tc.want)
+			}
+		})
+	}
+}
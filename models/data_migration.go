@@ -0,0 +1,152 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// The values are cast to the new type of the field, the ones which can not be are removed
+	MigrationConvert = "convert"
+	// All the values of the field are removed
+	MigrationClear = "clear"
+	// The change is refused when a data has a value for the field
+	MigrationReject = "reject"
+)
+
+func IsMigrationStrategy(strategy string) bool {
+	switch strategy {
+	case MigrationConvert, MigrationClear, MigrationReject:
+		return true
+	default:
+		return false
+	}
+}
+
+// FieldMigration is what happens to the values of a field of the existing data
+// when the definition of the field changes
+type FieldMigration struct {
+	FieldId  string `bson:"field_id" json:"field_id"`
+	From     string `bson:"from" json:"from"`
+	To       string `bson:"to" json:"to"` // empty when the field is removed
+	Strategy string `bson:"strategy" json:"strategy"`
+
+	field *ActivityField
+}
+
+// ActivityMigration is the migration of the values of the data of an activity in progress.
+// The data are migrated in batches, in the order of their ids, the migration resuming after the last one.
+type ActivityMigration struct {
+	// Version of the fields the data are migrated to
+	Version    int                `bson:"version" json:"version"`
+	Migrations []FieldMigration   `bson:"migrations" json:"migrations"`
+	Author     DataAuthor         `bson:"author" json:"author"`
+	LastDataId primitive.ObjectID `bson:"last_data_id" json:"-"`
+
+	StartedAt time.Time `bson:"started_at" json:"started_at"`
+	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
+}
+
+// FieldMigrationImpact counts the data changed by a migration
+type FieldMigrationImpact struct {
+	FieldMigration
+	WithValue int64 `json:"with_value"` // the data having a value for the field
+	Converted int64 `json:"converted"`
+	Cleared   int64 `json:"cleared"`
+	Rejected  bool  `json:"rejected"`
+}
+
+// Migrate returns the value of the field once migrated, or false when the value is removed
+func (migration FieldMigration) Migrate(value any) (any, bool) {
+	if value == nil || migration.field == nil || migration.Strategy == MigrationClear {
+		return nil, false
+	}
+	if migration.Strategy == MigrationReject {
+		return value, true
+	}
+
+	castValue, err := migration.field.Cast(value)
+	if err != nil || castValue == nil {
+		return nil, false
+	}
+	return castValue, true
+}
+
+// MigrateValues returns the values of a data once migrated, the migrations being bound to the fields of the activity
+func (migration ActivityMigration) MigrateValues(values map[string]any) map[string]any {
+	migrated := make(map[string]any, len(values))
+	for key, value := range values {
+		migrated[key] = value
+	}
+	for _, m := range migration.Migrations {
+		if value, kept := m.Migrate(values[m.FieldId]); kept {
+			migrated[m.FieldId] = value
+		} else {
+			delete(migrated, m.FieldId)
+		}
+	}
+	return migrated
+}
+
+// PlanFieldMigrations compares two versions of the fields of an activity and returns the migrations
+// of the fields removed or whose type changed. The values of a removed field are cleared unless
+// the strategy is reject, a change of type is rejected unless another strategy is given.
+func PlanFieldMigrations(before, after []ActivityField, strategy string) []FieldMigration {
+	afterFields := make(map[string]ActivityField, len(after))
+	for _, field := range after {
+		afterFields[field.Id.Hex()] = field
+	}
+
+	migrations := make([]FieldMigration, 0)
+	for _, field := range before {
+		newField, ok := afterFields[field.Id.Hex()]
+		if !ok {
+			migration := FieldMigration{
+				FieldId:  field.Id.Hex(),
+				From:     field.Type,
+				Strategy: MigrationClear,
+			}
+			if strategy == MigrationReject {
+				migration.Strategy = MigrationReject
+			}
+			migrations = append(migrations, migration)
+			continue
+		}
+
+		if newField.Type == field.Type && newField.IsList() == field.IsList() {
+			continue
+		}
+		migration := FieldMigration{
+			FieldId:  field.Id.Hex(),
+			From:     field.Type,
+			To:       newField.Type,
+			Strategy: strategy,
+			field:    &newField,
+		}
+		if migration.Strategy == "" {
+			migration.Strategy = MigrationReject
+		}
+		migrations = append(migrations, migration)
+	}
+
+	return migrations
+}
+
+// BindFieldMigrations returns the migrations with the fields of the activity their values are cast to,
+// for the migrations read back from the database
+func BindFieldMigrations(migrations []FieldMigration, fields []ActivityField) []FieldMigration {
+	fieldsById := make(map[string]ActivityField, len(fields))
+	for _, field := range fields {
+		fieldsById[field.Id.Hex()] = field
+	}
+
+	bound := make([]FieldMigration, len(migrations))
+	for i, migration := range migrations {
+		bound[i] = migration
+		if field, ok := fieldsById[migration.FieldId]; ok && migration.To != "" {
+			bound[i].field = &field
+		}
+	}
+	return bound
+}
@@ -0,0 +1,74 @@
+package models_test
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"stockinos.com/api/models"
+)
+
+func TestPlanFieldMigrations(t *testing.T) {
+	quantity := models.ActivityField{Id: primitive.NewObjectID(), Type: models.FieldTypeText}
+	note := models.ActivityField{Id: primitive.NewObjectID(), Type: models.FieldTypeText}
+	name := models.ActivityField{Id: primitive.NewObjectID(), Type: models.FieldTypeText}
+	before := []models.ActivityField{quantity, note, name}
+
+	quantityAsNumber := quantity
+	quantityAsNumber.Type = models.FieldTypeNumber
+	after := []models.ActivityField{quantityAsNumber, name}
+
+	migrations := models.PlanFieldMigrations(before, after, "")
+	if len(migrations) != 2 {
+		t.Fatalf("PlanFieldMigrations() = %+v; want 2 migrations", migrations)
+	}
+	if migrations[0].FieldId != quantity.Id.Hex() || migrations[0].Strategy != models.MigrationReject {
+		t.Errorf("migration of the type = %+v; want rejected by default", migrations[0])
+	}
+	if migrations[1].FieldId != note.Id.Hex() || migrations[1].Strategy != models.MigrationClear || migrations[1].To != "" {
+		t.Errorf("migration of the removed field = %+v; want cleared", migrations[1])
+	}
+
+	migrations = models.PlanFieldMigrations(before, after, models.MigrationConvert)
+	convert := migrations[0]
+	if value, ok := convert.Migrate("12.5"); !ok || value != 12.5 {
+		t.Errorf("Migrate(\"12.5\") = %v, %v; want 12.5, true", value, ok)
+	}
+	if value, ok := convert.Migrate("twelve"); ok {
+		t.Errorf("Migrate(\"twelve\") = %v, %v; want the value removed", value, ok)
+	}
+	if _, ok := migrations[1].Migrate("a note"); ok {
+		t.Errorf("Migrate() of a removed field kept the value")
+	}
+
+	if migrations := models.PlanFieldMigrations(before, before, models.MigrationConvert); len(migrations) != 0 {
+		t.Errorf("PlanFieldMigrations() = %+v; want no migration when nothing changes", migrations)
+	}
+}
+
+func TestBindFieldMigrations(t *testing.T) {
+	quantity := models.ActivityField{Id: primitive.NewObjectID(), Type: models.FieldTypeText}
+	quantityAsNumber := quantity
+	quantityAsNumber.Type = models.FieldTypeNumber
+	after := []models.ActivityField{quantityAsNumber}
+
+	// The migrations are saved on the activity without their fields
+	data, err := bson.Marshal(models.ActivityMigration{
+		Migrations: models.PlanFieldMigrations([]models.ActivityField{quantity}, after, models.MigrationConvert),
+	})
+	if err != nil {
+		t.Fatalf("bson.Marshal() error = %v", err)
+	}
+	var saved models.ActivityMigration
+	if err := bson.Unmarshal(data, &saved); err != nil {
+		t.Fatalf("bson.Unmarshal() error = %v", err)
+	}
+	if _, ok := saved.Migrations[0].Migrate("12.5"); ok {
+		t.Fatalf("Migrate() converted a value without the field")
+	}
+
+	migrations := models.BindFieldMigrations(saved.Migrations, after)
+	if value, ok := migrations[0].Migrate("12.5"); !ok || value != 12.5 {
+		t.Errorf("Migrate(\"12.5\") = %v, %v; want 12.5, true", value, ok)
+	}
+}
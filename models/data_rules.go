@@ -0,0 +1,247 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ActivityFieldRules are the constraints on the values of a field, each of them for some types of fields only
+type ActivityFieldRules struct {
+	// a value must be given, for all the types
+	Required bool `bson:"required" json:"required"`
+
+	// number, and the other types whose values are numbers, see IsNumberType
+	Min *float64 `bson:"min,omitempty" json:"min,omitempty"`
+	Max *float64 `bson:"max,omitempty" json:"max,omitempty"`
+
+	// text and rich text, in characters
+	MinLength *int `bson:"min_length,omitempty" json:"min_length,omitempty"`
+	MaxLength *int `bson:"max_length,omitempty" json:"max_length,omitempty"`
+	// regular expression the whole text must match
+	Pattern string `bson:"pattern,omitempty" json:"pattern,omitempty"`
+	// shown instead of the pattern when a text does not match it
+	PatternMessage string `bson:"pattern_message,omitempty" json:"pattern_message,omitempty"`
+
+	// date, written as DateLayout
+	MinDate     string `bson:"min_date,omitempty" json:"min_date,omitempty"`
+	MaxDate     string `bson:"max_date,omitempty" json:"max_date,omitempty"`
+	NotInFuture bool   `bson:"not_in_future,omitempty" json:"not_in_future,omitempty"`
+
+	// multiple choices, when several can be chosen
+	MinSelections *int `bson:"min_selections,omitempty" json:"min_selections,omitempty"`
+	MaxSelections *int `bson:"max_selections,omitempty" json:"max_selections,omitempty"`
+}
+
+// ValidateRules checks that the rules of the field can be used with its type and do not contradict each other
+func (field ActivityField) ValidateRules() *FieldError {
+	rules := field.Rules
+	if rules == nil {
+		return nil
+	}
+	invalid := func(format string, args ...any) *FieldError {
+		return newFieldError(field, "ERR_FIELD_INVALID_RULES", format, args...)
+	}
+
+	if (rules.Min != nil || rules.Max != nil) && !IsNumberType(field.Type) {
+		return invalid("min and max can only be used on a number")
+	}
+	if rules.Min != nil && rules.Max != nil && *rules.Min > *rules.Max {
+		return invalid("min is greater than max")
+	}
+
+	if (rules.MinLength != nil || rules.MaxLength != nil || rules.Pattern != "") && field.Type != FieldTypeText && field.Type != FieldTypeRichText {
+		return invalid("the length and the pattern can only be used on a text")
+	}
+	if (rules.MinLength != nil && *rules.MinLength < 0) || (rules.MaxLength != nil && *rules.MaxLength < 0) {
+		return invalid("a length can not be negative")
+	}
+	if rules.MinLength != nil && rules.MaxLength != nil && *rules.MinLength > *rules.MaxLength {
+		return invalid("min_length is greater than max_length")
+	}
+	if rules.Pattern != "" {
+		if _, err := rules.pattern(); err != nil {
+			return invalid("the pattern is not a valid regular expression: %s", err)
+		}
+	}
+
+	if (rules.MinDate != "" || rules.MaxDate != "" || rules.NotInFuture) && field.Type != FieldTypeDate {
+		return invalid("the date range can only be used on a date")
+	}
+	for _, date := range []string{rules.MinDate, rules.MaxDate} {
+		if _, ok := ParseDate(date); date != "" && !ok {
+			return invalid("%q is not a valid date", date)
+		}
+	}
+	if rules.MinDate != "" && rules.MaxDate != "" && normalizeDate(rules.MinDate) > normalizeDate(rules.MaxDate) {
+		return invalid("min_date is after max_date")
+	}
+
+	if rules.MinSelections != nil || rules.MaxSelections != nil {
+		details := field.Details.ActivityFieldMultipleChoices
+		if field.Type != FieldTypeMultipleChoices || details == nil || !details.Multiple {
+			return invalid("the number of selections can only be limited when several choices can be made")
+		}
+		if (rules.MinSelections != nil && *rules.MinSelections < 0) || (rules.MaxSelections != nil && *rules.MaxSelections < 0) {
+			return invalid("a number of selections can not be negative")
+		}
+		if rules.MinSelections != nil && rules.MaxSelections != nil && *rules.MinSelections > *rules.MaxSelections {
+			return invalid("min_selections is greater than max_selections")
+		}
+	}
+
+	return nil
+}
+
+// ValidateFieldRules checks the rules of all the fields
+func ValidateFieldRules(fields []ActivityField) []FieldError {
+	errors := make([]FieldError, 0)
+	for _, field := range fields {
+		if err := field.ValidateRules(); err != nil {
+			errors = append(errors, *err)
+		}
+	}
+	return errors
+}
+
+// CheckRules checks all the values of a data, once cast, against the rules and the conditions of their field.
+// The values are the whole values of the data: a required field missing is an error, unless it is hidden.
+func (activity Activity) CheckRules(values map[string]any, now time.Time) []FieldError {
+	errors := make([]FieldError, 0)
+	hidden := activity.HiddenFields(values)
+	fieldsById := make(map[string]ActivityField, len(activity.Fields))
+	for _, field := range activity.Fields {
+		fieldsById[field.Id.Hex()] = field
+	}
+
+	for _, field := range activity.Fields {
+		if hidden[field.Id.Hex()] {
+			continue
+		}
+		value := values[field.Id.Hex()]
+		if field.Conditions != nil && field.Conditions.RequiredIf != nil && isEmptyValue(value) &&
+			field.Conditions.RequiredIf.Evaluate(fieldsById, values) {
+			errors = append(errors, *newFieldError(field, "ERR_FIELD_REQUIRED", "a value is required"))
+			continue
+		}
+		if field.Rules == nil {
+			continue
+		}
+		if err := field.CheckRules(value, now); err != nil {
+			errors = append(errors, *err)
+		}
+	}
+	return errors
+}
+
+// CheckRules checks a value of the field, once cast, against its rules
+func (field ActivityField) CheckRules(value any, now time.Time) *FieldError {
+	rules := field.Rules
+	if rules == nil {
+		return nil
+	}
+
+	items, isList := toList(value)
+	if isEmptyValue(value) {
+		if rules.Required {
+			return newFieldError(field, "ERR_FIELD_REQUIRED", "a value is required")
+		}
+		return nil
+	}
+
+	if field.Type == FieldTypeMultipleChoices {
+		if !isList {
+			return nil
+		}
+		if rules.MinSelections != nil && len(items) < *rules.MinSelections {
+			return newFieldError(field, "ERR_FIELD_TOO_FEW_CHOICES", "at least %d choices are expected", *rules.MinSelections)
+		}
+		if rules.MaxSelections != nil && len(items) > *rules.MaxSelections {
+			return newFieldError(field, "ERR_FIELD_TOO_MANY_CHOICES", "at most %d choices are expected", *rules.MaxSelections)
+		}
+		return nil
+	}
+
+	if !isList {
+		items = []any{value}
+	}
+	for _, item := range items {
+		if err := field.checkItemRules(item, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (field ActivityField) checkItemRules(value any, now time.Time) *FieldError {
+	rules := field.Rules
+
+	if v, ok := NumberValue(value); ok {
+		if rules.Min != nil && v < *rules.Min {
+			return newFieldError(field, "ERR_FIELD_TOO_SMALL", "%s is less than the minimum %s", formatNumber(v), formatNumber(*rules.Min))
+		}
+		if rules.Max != nil && v > *rules.Max {
+			return newFieldError(field, "ERR_FIELD_TOO_LARGE", "%s is more than the maximum %s", formatNumber(v), formatNumber(*rules.Max))
+		}
+		return nil
+	}
+
+	if v, ok := value.(string); ok {
+		switch field.Type {
+		case FieldTypeText, FieldTypeRichText:
+			length := utf8.RuneCountInString(v)
+			if rules.MinLength != nil && length < *rules.MinLength {
+				return newFieldError(field, "ERR_FIELD_TOO_SHORT", "at least %d characters are expected", *rules.MinLength)
+			}
+			if rules.MaxLength != nil && length > *rules.MaxLength {
+				return newFieldError(field, "ERR_FIELD_TOO_LONG", "at most %d characters are expected", *rules.MaxLength)
+			}
+			if rules.Pattern != "" {
+				pattern, err := rules.pattern()
+				if err == nil && !pattern.MatchString(v) {
+					if rules.PatternMessage != "" {
+						return newFieldError(field, "ERR_FIELD_PATTERN", "%s", rules.PatternMessage)
+					}
+					return newFieldError(field, "ERR_FIELD_PATTERN", "%q does not match the pattern %s", v, rules.Pattern)
+				}
+			}
+		case FieldTypeDate:
+			// the dates are stored with DateLayout, they can be compared as texts
+			if rules.MinDate != "" && v < normalizeDate(rules.MinDate) {
+				return newFieldError(field, "ERR_FIELD_DATE_TOO_EARLY", "the date must be on or after %s", normalizeDate(rules.MinDate))
+			}
+			if rules.MaxDate != "" && v > normalizeDate(rules.MaxDate) {
+				return newFieldError(field, "ERR_FIELD_DATE_TOO_LATE", "the date must be on or before %s", normalizeDate(rules.MaxDate))
+			}
+			if rules.NotInFuture && v > now.Format(DateLayout) {
+				return newFieldError(field, "ERR_FIELD_DATE_IN_FUTURE", "the date can not be in the future")
+			}
+		}
+	}
+	return nil
+}
+
+// pattern compiles the pattern to match the whole text
+func (rules ActivityFieldRules) pattern() (*regexp.Regexp, error) {
+	return regexp.Compile(fmt.Sprintf("^(?:%s)$", rules.Pattern))
+}
+
+// isEmptyValue tells if a value is missing, an empty text or an empty list
+func isEmptyValue(value any) bool {
+	items, isList := toList(value)
+	return value == nil || value == "" || (isList && len(items) == 0)
+}
+
+func normalizeDate(value string) string {
+	date, ok := ParseDate(value)
+	if !ok {
+		return strings.TrimSpace(value)
+	}
+	return date.Format(DateLayout)
+}
+
+func formatNumber(value float64) string {
+	return fmt.Sprintf("%g", value)
+}
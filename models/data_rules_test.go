@@ -0,0 +1,183 @@
+package models_test
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"stockinos.com/api/models"
+)
+
+func intRule(n int) *int {
+	return &n
+}
+
+func numberRule(n float64) *float64 {
+	return &n
+}
+
+func TestActivityFieldCheckRules(t *testing.T) {
+	now := time.Date(2024, 3, 5, 10, 0, 0, 0, time.UTC)
+	severalChoices := models.ActivityFieldType{ActivityFieldMultipleChoices: &models.ActivityFieldMultipleChoices{Multiple: true}}
+
+	tests := map[string]struct {
+		field    models.ActivityField
+		value    any
+		wantCode string
+	}{
+		"required missing": {
+			field:    models.ActivityField{Type: models.FieldTypeText, Rules: &models.ActivityFieldRules{Required: true}},
+			value:    nil,
+			wantCode: "ERR_FIELD_REQUIRED",
+		},
+		"required empty text": {
+			field:    models.ActivityField{Type: models.FieldTypeText, Rules: &models.ActivityFieldRules{Required: true}},
+			value:    "",
+			wantCode: "ERR_FIELD_REQUIRED",
+		},
+		"required empty list": {
+			field:    models.ActivityField{Type: models.FieldTypeUpload, Rules: &models.ActivityFieldRules{Required: true}},
+			value:    []string{},
+			wantCode: "ERR_FIELD_REQUIRED",
+		},
+		"missing not required": {
+			field: models.ActivityField{Type: models.FieldTypeNumber, Rules: &models.ActivityFieldRules{Min: numberRule(1)}},
+			value: nil,
+		},
+		"number in range": {
+			field: models.ActivityField{Type: models.FieldTypeNumber, Rules: &models.ActivityFieldRules{Min: numberRule(0), Max: numberRule(10)}},
+			value: 10.0,
+		},
+		"number too small": {
+			field:    models.ActivityField{Type: models.FieldTypeNumber, Rules: &models.ActivityFieldRules{Min: numberRule(0)}},
+			value:    -1.5,
+			wantCode: "ERR_FIELD_TOO_SMALL",
+		},
+		"item of a list too large": {
+			field: models.ActivityField{
+				Type:    models.FieldTypeNumber,
+				Options: models.ActivityFieldOptions{Multiple: true},
+				Rules:   &models.ActivityFieldRules{Max: numberRule(5)},
+			},
+			value:    []any{1.0, 6.0},
+			wantCode: "ERR_FIELD_TOO_LARGE",
+		},
+		"text length in characters": {
+			field: models.ActivityField{Type: models.FieldTypeText, Rules: &models.ActivityFieldRules{MaxLength: intRule(5)}},
+			value: "éàèùç",
+		},
+		"text too short": {
+			field:    models.ActivityField{Type: models.FieldTypeText, Rules: &models.ActivityFieldRules{MinLength: intRule(3)}},
+			value:    "ab",
+			wantCode: "ERR_FIELD_TOO_SHORT",
+		},
+		"pattern matches the whole text": {
+			field:    models.ActivityField{Type: models.FieldTypeText, Rules: &models.ActivityFieldRules{Pattern: "[A-Z]{2}[0-9]+"}},
+			value:    "AB12x",
+			wantCode: "ERR_FIELD_PATTERN",
+		},
+		"pattern matched": {
+			field: models.ActivityField{Type: models.FieldTypeText, Rules: &models.ActivityFieldRules{Pattern: "[A-Z]{2}[0-9]+"}},
+			value: "AB12",
+		},
+		"date too early": {
+			field:    models.ActivityField{Type: models.FieldTypeDate, Rules: &models.ActivityFieldRules{MinDate: "01/03/2024"}},
+			value:    "2024-02-29",
+			wantCode: "ERR_FIELD_DATE_TOO_EARLY",
+		},
+		"date in the future": {
+			field:    models.ActivityField{Type: models.FieldTypeDate, Rules: &models.ActivityFieldRules{NotInFuture: true}},
+			value:    "2024-03-06",
+			wantCode: "ERR_FIELD_DATE_IN_FUTURE",
+		},
+		"date today": {
+			field: models.ActivityField{Type: models.FieldTypeDate, Rules: &models.ActivityFieldRules{NotInFuture: true}},
+			value: "2024-03-05",
+		},
+		"too few choices": {
+			field: models.ActivityField{
+				Type:    models.FieldTypeMultipleChoices,
+				Details: severalChoices,
+				Rules:   &models.ActivityFieldRules{MinSelections: intRule(2)},
+			},
+			value:    []string{"a"},
+			wantCode: "ERR_FIELD_TOO_FEW_CHOICES",
+		},
+		"too many choices": {
+			field: models.ActivityField{
+				Type:    models.FieldTypeMultipleChoices,
+				Details: severalChoices,
+				Rules:   &models.ActivityFieldRules{MaxSelections: intRule(1)},
+			},
+			value:    []string{"a", "b"},
+			wantCode: "ERR_FIELD_TOO_MANY_CHOICES",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tt.field.CheckRules(tt.value, now)
+			if tt.wantCode == "" {
+				if err != nil {
+					t.Errorf("CheckRules() error = %v", err)
+				}
+				return
+			}
+			if err == nil || err.Code != tt.wantCode {
+				t.Errorf("CheckRules() error = %v, want %s", err, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestActivityFieldValidateRules(t *testing.T) {
+	tests := map[string]struct {
+		field   models.ActivityField
+		wantErr bool
+	}{
+		"no rules": {
+			field: models.ActivityField{Type: models.FieldTypeText},
+		},
+		"required on any type": {
+			field: models.ActivityField{Type: models.FieldTypeUpload, Rules: &models.ActivityFieldRules{Required: true}},
+		},
+		"min on a text": {
+			field:   models.ActivityField{Type: models.FieldTypeText, Rules: &models.ActivityFieldRules{Min: numberRule(1)}},
+			wantErr: true,
+		},
+		"min greater than max": {
+			field:   models.ActivityField{Type: models.FieldTypeNumber, Rules: &models.ActivityFieldRules{Min: numberRule(2), Max: numberRule(1)}},
+			wantErr: true,
+		},
+		"invalid pattern": {
+			field:   models.ActivityField{Type: models.FieldTypeText, Rules: &models.ActivityFieldRules{Pattern: "[a-"}},
+			wantErr: true,
+		},
+		"invalid date": {
+			field:   models.ActivityField{Type: models.FieldTypeDate, Rules: &models.ActivityFieldRules{MaxDate: "tomorrow"}},
+			wantErr: true,
+		},
+		"selections on a single choice": {
+			field:   models.ActivityField{Type: models.FieldTypeMultipleChoices, Rules: &models.ActivityFieldRules{MaxSelections: intRule(2)}},
+			wantErr: true,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := tt.field.ValidateRules(); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateRules() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestActivityCheckRules(t *testing.T) {
+	site := models.ActivityField{Id: primitive.NewObjectID(), Type: models.FieldTypeText, Rules: &models.ActivityFieldRules{Required: true}}
+	weight := models.ActivityField{Id: primitive.NewObjectID(), Type: models.FieldTypeNumber, Rules: &models.ActivityFieldRules{Min: numberRule(0)}}
+	notes := models.ActivityField{Id: primitive.NewObjectID(), Type: models.FieldTypeText}
+	activity := models.Activity{Fields: []models.ActivityField{site, weight, notes}}
+
+	errors := activity.CheckRules(map[string]any{weight.Id.Hex(): -1.0}, time.Now())
+	if len(errors) != 2 || errors[0].FieldId != site.Id.Hex() || errors[1].FieldId != weight.Id.Hex() {
+		t.Errorf("CheckRules() = %v, want errors on the site and the weight", errors)
+	}
+}
@@ -0,0 +1,142 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const PrimaryKeyConstraint = "primary_key"
+
+// UniqueConstraint is a set of fields whose values can not be shared by two data of an activity.
+// The primary key is made of all the fields marked as primary key: the activity can have none.
+// Each field marked as unique has its own constraint.
+type UniqueConstraint struct {
+	Name       string
+	PrimaryKey bool
+	FieldIds   []string
+}
+
+func (activity Activity) PrimaryKeyFields() []ActivityField {
+	fields := make([]ActivityField, 0)
+	for _, field := range activity.Fields {
+		if field.PrimaryKey {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+func (activity Activity) UniqueConstraints() []UniqueConstraint {
+	constraints := make([]UniqueConstraint, 0)
+
+	primaryKeyFields := activity.PrimaryKeyFields()
+	if len(primaryKeyFields) > 0 {
+		constraint := UniqueConstraint{
+			Name:       PrimaryKeyConstraint,
+			PrimaryKey: true,
+		}
+		for _, field := range primaryKeyFields {
+			constraint.FieldIds = append(constraint.FieldIds, field.Id.Hex())
+		}
+		constraints = append(constraints, constraint)
+	}
+
+	for _, field := range activity.Fields {
+		// A primary key on a single field is already unique
+		if !field.Unique || (field.PrimaryKey && len(primaryKeyFields) == 1) {
+			continue
+		}
+		constraints = append(constraints, UniqueConstraint{
+			Name:     field.Id.Hex(),
+			FieldIds: []string{field.Id.Hex()},
+		})
+	}
+
+	return constraints
+}
+
+// UniqueKeysRefresh is the computation of the unique keys of the data of an activity in progress,
+// once its unique constraints changed. The data are refreshed in batches, in the order of their ids,
+// the refresh resuming after the last one.
+type UniqueKeysRefresh struct {
+	LastDataId primitive.ObjectID `bson:"last_data_id" json:"-"`
+
+	StartedAt time.Time `bson:"started_at" json:"started_at"`
+	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
+}
+
+// ValidatePrimaryKey checks that every field of the primary key has a value
+func (activity Activity) ValidatePrimaryKey(values map[string]any) []FieldError {
+	errors := make([]FieldError, 0)
+	for _, field := range activity.PrimaryKeyFields() {
+		if values[field.Id.Hex()] == nil {
+			errors = append(errors, FieldError{
+				FieldId: field.Id.Hex(),
+				Code:    "ERR_FIELD_PRKEY_REQUIRED",
+				Message: "this field is part of the primary key",
+			})
+		}
+	}
+	return errors
+}
+
+// UniqueKey returns the key identifying the values of the constraint in the activity.
+// There is no key when one of the values is missing: like in SQL, nulls are never duplicates.
+func (activity Activity) UniqueKey(constraint UniqueConstraint, values map[string]any) (string, bool) {
+	parts := make([]any, 0, 2*len(constraint.FieldIds))
+	for _, fieldId := range constraint.FieldIds {
+		value := values[fieldId]
+		if value == nil {
+			return "", false
+		}
+		parts = append(parts, fieldId, value)
+	}
+
+	encoded, err := json.Marshal(parts)
+	if err != nil {
+		return "", false
+	}
+
+	// The values can be long: only their hash is indexed
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%s", activity.Id.Hex(), constraint.Name, encoded)))
+	return hex.EncodeToString(hash[:]), true
+}
+
+// UniqueKeys returns the keys of all the constraints of the activity, stored with the data
+// so a unique index can reject the duplicates.
+func (activity Activity) UniqueKeys(values map[string]any) []string {
+	keys := make([]string, 0)
+	for _, constraint := range activity.UniqueConstraints() {
+		if key, ok := activity.UniqueKey(constraint, values); ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// UniqueErrors returns the errors of the fields of a constraint violated by the values
+func (constraint UniqueConstraint) UniqueErrors() []FieldError {
+	code := "ERR_FIELD_NOT_UNIQUE"
+	message := "this value is already used"
+	if constraint.PrimaryKey {
+		code = "ERR_FIELD_PRKEY_ALREADY_USED"
+		if len(constraint.FieldIds) > 1 {
+			message = "this combination of values is already used"
+		}
+	}
+
+	errors := make([]FieldError, len(constraint.FieldIds))
+	for i, fieldId := range constraint.FieldIds {
+		errors[i] = FieldError{
+			FieldId: fieldId,
+			Code:    code,
+			Message: message,
+		}
+	}
+	return errors
+}
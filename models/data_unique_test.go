@@ -0,0 +1,52 @@
+package models_test
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"stockinos.com/api/models"
+)
+
+func TestActivityUniqueKeys(t *testing.T) {
+	batch := models.ActivityField{Id: primitive.NewObjectID(), Type: models.FieldTypeText, PrimaryKey: true}
+	site := models.ActivityField{Id: primitive.NewObjectID(), Type: models.FieldTypeText, PrimaryKey: true}
+	serial := models.ActivityField{Id: primitive.NewObjectID(), Type: models.FieldTypeText, Unique: true}
+	note := models.ActivityField{Id: primitive.NewObjectID(), Type: models.FieldTypeText}
+	activity := models.Activity{
+		Id:     primitive.NewObjectID(),
+		Fields: []models.ActivityField{batch, site, serial, note},
+	}
+
+	constraints := activity.UniqueConstraints()
+	if len(constraints) != 2 || !constraints[0].PrimaryKey || len(constraints[0].FieldIds) != 2 {
+		t.Fatalf("UniqueConstraints() = %+v; want a composite primary key and a unique field", constraints)
+	}
+
+	values := map[string]any{batch.Id.Hex(): "B1", site.Id.Hex(): "S1", serial.Id.Hex(): "X", note.Id.Hex(): "a"}
+	keys := activity.UniqueKeys(values)
+	if len(keys) != 2 {
+		t.Fatalf("UniqueKeys() = %v; want 2 keys", keys)
+	}
+
+	sameKey := activity.UniqueKeys(map[string]any{batch.Id.Hex(): "B1", site.Id.Hex(): "S1", note.Id.Hex(): "b"})
+	if len(sameKey) != 1 || sameKey[0] != keys[0] {
+		t.Fatalf("UniqueKeys() = %v; want the primary key %s only", sameKey, keys[0])
+	}
+
+	otherSite := activity.UniqueKeys(map[string]any{batch.Id.Hex(): "B1", site.Id.Hex(): "S2"})
+	if len(otherSite) != 1 || otherSite[0] == keys[0] {
+		t.Fatalf("UniqueKeys() = %v; want a different primary key", otherSite)
+	}
+
+	if errors := activity.ValidatePrimaryKey(map[string]any{batch.Id.Hex(): "B1"}); len(errors) != 1 || errors[0].FieldId != site.Id.Hex() {
+		t.Fatalf("ValidatePrimaryKey() = %v; want the site to be required", errors)
+	}
+
+	withoutKey := models.Activity{Id: primitive.NewObjectID(), Fields: []models.ActivityField{note}}
+	if errors := withoutKey.ValidatePrimaryKey(map[string]any{}); len(errors) != 0 {
+		t.Fatalf("ValidatePrimaryKey() = %v; want no error without primary key", errors)
+	}
+	if keys := withoutKey.UniqueKeys(map[string]any{note.Id.Hex(): "a"}); len(keys) != 0 {
+		t.Fatalf("UniqueKeys() = %v; want no key without constraint", keys)
+	}
+}
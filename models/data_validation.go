@@ -0,0 +1,405 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	FieldTypeText            = "text"
+	FieldTypeNumber          = "number"
+	FieldTypeDate            = "date"
+	FieldTypeTime            = "time"
+	FieldTypeUpload          = "upload"
+	FieldTypeMultipleChoices = "multiple-choices"
+	FieldTypeKey             = "key"
+	FieldTypeBoolean         = "boolean"
+	FieldTypeInteger         = "integer"
+	FieldTypeDecimal         = "decimal"
+	FieldTypeCurrency        = "currency"
+	FieldTypeEmail           = "email"
+	FieldTypePhone           = "phone"
+	FieldTypeURL             = "url"
+	FieldTypeGeolocation     = "geolocation"
+	FieldTypeBarcode         = "barcode"
+	FieldTypeRating          = "rating"
+	FieldTypeDateTime        = "date-time"
+	FieldTypeDuration        = "duration"
+	FieldTypeRichText        = "rich-text"
+)
+
+// Layout used to store date and time values, whatever the format they were sent with
+const (
+	DateLayout = "2006-01-02"
+	TimeLayout = "15:04"
+)
+
+var acceptedDateLayouts = []string{
+	DateLayout,
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006/01/02",
+	"02/01/2006",
+	"02-01-2006",
+}
+
+var acceptedTimeLayouts = []string{
+	TimeLayout,
+	"15:04:05",
+	"3:04PM",
+	"3:04 PM",
+	"3:04pm",
+	"3:04 pm",
+}
+
+// FieldError explains why a value has been rejected for a field of an activity
+type FieldError struct {
+	FieldId string `json:"field_id"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e FieldError) Error() string {
+	return fmt.Sprintf("%s: %s (%s)", e.FieldId, e.Message, e.Code)
+}
+
+func newFieldError(field ActivityField, code, format string, args ...any) *FieldError {
+	return &FieldError{
+		FieldId: field.Id.Hex(),
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+// ValidateValues checks each value against the field of the activity it belongs to.
+// It returns the values cast to the representation of their field type,
+// and the list of all the errors found.
+func (activity Activity) ValidateValues(values map[string]any) (map[string]any, []FieldError) {
+	fields := make(map[string]ActivityField, len(activity.Fields))
+	for _, field := range activity.Fields {
+		fields[field.Id.Hex()] = field
+	}
+
+	castValues := make(map[string]any, len(values))
+	errors := make([]FieldError, 0)
+	for fieldId, value := range values {
+		field, ok := fields[fieldId]
+		if !ok {
+			errors = append(errors, FieldError{
+				FieldId: fieldId,
+				Code:    "ERR_FIELD_UNKNOWN",
+				Message: "this field does not exist in the activity",
+			})
+			continue
+		}
+
+		castValue, err := field.Cast(value)
+		if err != nil {
+			errors = append(errors, *err)
+			continue
+		}
+		castValues[fieldId] = castValue
+	}
+
+	return castValues, errors
+}
+
+// Cast converts the value into the representation expected for the type of the field.
+// A nil value is always accepted, it means that the field has no value.
+func (field ActivityField) Cast(value any) (any, *FieldError) {
+	if value == nil {
+		return nil, nil
+	}
+
+	switch field.Type {
+	case FieldTypeUpload:
+		return field.castUpload(value)
+	case FieldTypeMultipleChoices:
+		return field.castMultipleChoices(value)
+	}
+
+	if !field.Options.Multiple {
+		return field.castOne(value)
+	}
+
+	items, ok := toList(value)
+	if !ok {
+		items = []any{value}
+	}
+	castItems := make([]any, len(items))
+	for i, item := range items {
+		castItem, err := field.castOne(item)
+		if err != nil {
+			return nil, err
+		}
+		castItems[i] = castItem
+	}
+	return castItems, nil
+}
+
+// IsList tells if the values of the field are lists
+func (field ActivityField) IsList() bool {
+	switch field.Type {
+	case FieldTypeUpload:
+		return true
+	case FieldTypeMultipleChoices:
+		details := field.Details.ActivityFieldMultipleChoices
+		return details != nil && details.Multiple
+	default:
+		return field.Options.Multiple
+	}
+}
+
+// ListItems returns the items of a list value, or nil when the value is not a list
+func ListItems(value any) []any {
+	items, _ := toList(value)
+	return items
+}
+
+func (field ActivityField) castOne(value any) (any, *FieldError) {
+	switch field.Type {
+	case FieldTypeText:
+		return field.castText(value)
+	case FieldTypeNumber:
+		return field.castNumber(value)
+	case FieldTypeDate:
+		return field.castDate(value)
+	case FieldTypeTime:
+		return field.castTime(value)
+	case FieldTypeKey:
+		return field.castKey(value)
+	case FieldTypeBoolean:
+		return field.castBoolean(value)
+	case FieldTypeInteger:
+		return field.castInteger(value)
+	case FieldTypeDecimal:
+		return field.castDecimal(value)
+	case FieldTypeCurrency:
+		return field.castCurrency(value)
+	case FieldTypeEmail:
+		return field.castEmail(value)
+	case FieldTypePhone:
+		return field.castPhone(value)
+	case FieldTypeURL:
+		return field.castURL(value)
+	case FieldTypeGeolocation:
+		return field.castGeolocation(value)
+	case FieldTypeBarcode:
+		return field.castBarcode(value)
+	case FieldTypeRating:
+		return field.castRating(value)
+	case FieldTypeDateTime:
+		return field.castDateTime(value)
+	case FieldTypeDuration:
+		return field.castDuration(value)
+	case FieldTypeRichText:
+		return field.castRichText(value)
+	default:
+		return value, nil
+	}
+}
+
+func (field ActivityField) castText(value any) (any, *FieldError) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case int, int32, int64:
+		return fmt.Sprintf("%d", v), nil
+	default:
+		return nil, newFieldError(field, "ERR_FIELD_NOT_TEXT", "a text is expected")
+	}
+}
+
+func (field ActivityField) castNumber(value any) (any, *FieldError) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case float32:
+		return float64(v), nil
+	case int:
+		return float64(v), nil
+	case int32:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case string:
+		n, err := strconv.ParseFloat(strings.ReplaceAll(strings.TrimSpace(v), ",", "."), 64)
+		if err != nil {
+			return nil, newFieldError(field, "ERR_FIELD_NOT_NUMBER", "%q is not a number", v)
+		}
+		return n, nil
+	default:
+		return nil, newFieldError(field, "ERR_FIELD_NOT_NUMBER", "a number is expected")
+	}
+}
+
+func (field ActivityField) castDate(value any) (any, *FieldError) {
+	v, ok := value.(string)
+	if !ok {
+		return nil, newFieldError(field, "ERR_FIELD_NOT_DATE", "a date is expected")
+	}
+
+	date, ok := ParseDate(v)
+	if !ok {
+		return nil, newFieldError(field, "ERR_FIELD_NOT_DATE", "%q is not a valid date", v)
+	}
+	return date.Format(DateLayout), nil
+}
+
+func (field ActivityField) castTime(value any) (any, *FieldError) {
+	v, ok := value.(string)
+	if !ok {
+		return nil, newFieldError(field, "ERR_FIELD_NOT_TIME", "a time is expected")
+	}
+
+	for _, layout := range acceptedTimeLayouts {
+		if t, err := time.Parse(layout, strings.TrimSpace(v)); err == nil {
+			return t.Format(TimeLayout), nil
+		}
+	}
+	return nil, newFieldError(field, "ERR_FIELD_NOT_TIME", "%q is not a valid time", v)
+}
+
+func (field ActivityField) castKey(value any) (any, *FieldError) {
+	switch v := value.(type) {
+	case string, float64:
+		return v, nil
+	default:
+		return nil, newFieldError(field, "ERR_FIELD_INVALID_KEY", "a key must be a text or a number")
+	}
+}
+
+func (field ActivityField) castMultipleChoices(value any) (any, *FieldError) {
+	details := field.Details.ActivityFieldMultipleChoices
+
+	items, isList := toList(value)
+	if !isList {
+		items = []any{value}
+	}
+
+	choices := make([]string, len(items))
+	for i, item := range items {
+		choice, ok := item.(string)
+		if !ok {
+			return nil, newFieldError(field, "ERR_FIELD_INVALID_CHOICE", "a choice must be a text")
+		}
+		if details != nil && len(details.Choices) > 0 && !contains(details.Choices, choice) {
+			return nil, newFieldError(field, "ERR_FIELD_INVALID_CHOICE", "%q is not one of the available choices", choice)
+		}
+		choices[i] = choice
+	}
+
+	if details != nil && details.Multiple {
+		return choices, nil
+	}
+	if len(choices) != 1 {
+		return nil, newFieldError(field, "ERR_FIELD_INVALID_CHOICE", "only one choice is expected")
+	}
+	return choices[0], nil
+}
+
+func (field ActivityField) castUpload(value any) (any, *FieldError) {
+	items, ok := toList(value)
+	if !ok {
+		v, isString := value.(string)
+		if !isString {
+			return nil, newFieldError(field, "ERR_FIELD_NOT_LIST", "a list of files is expected")
+		}
+		// Files used to be saved as a JSON encoded list
+		var files []any
+		if err := json.Unmarshal([]byte(v), &files); err == nil {
+			items = files
+		} else {
+			items = []any{v}
+		}
+	}
+
+	details := field.Details.ActivityFieldUpload
+	if details != nil && details.MaxNumberOfFiles > 0 && len(items) > details.MaxNumberOfFiles {
+		return nil, newFieldError(field, "ERR_FIELD_TOO_MANY_FILES", "at most %d files are expected", details.MaxNumberOfFiles)
+	}
+
+	files := make([]string, len(items))
+	for i, item := range items {
+		file, ok := item.(string)
+		if !ok {
+			return nil, newFieldError(field, "ERR_FIELD_NOT_LIST", "a file must be a text")
+		}
+		if details != nil && len(details.TypeOfFiles) > 0 && !isFileOfType(file, details.TypeOfFiles) {
+			return nil, newFieldError(field, "ERR_FIELD_INVALID_FILE_TYPE", "%q is not an accepted type of file", file)
+		}
+		files[i] = file
+	}
+	return files, nil
+}
+
+// ParseDate reads a date written in any of the accepted formats
+func ParseDate(value string) (time.Time, bool) {
+	for _, layout := range acceptedDateLayouts {
+		if date, err := time.Parse(layout, strings.TrimSpace(value)); err == nil {
+			return date, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// isFileOfType checks the file against types that can be an extension (pdf, .pdf)
+// or a mime type (application/pdf, image/*)
+func isFileOfType(file string, types []string) bool {
+	extension := strings.ToLower(path.Ext(file))
+	mimeType := mime.TypeByExtension(extension)
+
+	for _, t := range types {
+		t = strings.ToLower(strings.TrimSpace(t))
+		switch {
+		case strings.HasSuffix(t, "/*"):
+			if strings.HasPrefix(mimeType, strings.TrimSuffix(t, "*")) {
+				return true
+			}
+		case strings.Contains(t, "/"):
+			if strings.HasPrefix(mimeType, t) {
+				return true
+			}
+		default:
+			if extension == "."+strings.TrimPrefix(t, ".") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func toList(value any) ([]any, bool) {
+	switch v := value.(type) {
+	case []any:
+		return v, true
+	case primitive.A:
+		return []any(v), true
+	case []string:
+		items := make([]any, len(v))
+		for i := range v {
+			items[i] = v[i]
+		}
+		return items, true
+	default:
+		return nil, false
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
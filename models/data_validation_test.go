@@ -0,0 +1,161 @@
+package models_test
+
+import (
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"stockinos.com/api/models"
+)
+
+func TestActivityFieldCast(t *testing.T) {
+	tests := map[string]struct {
+		field    models.ActivityField
+		value    any
+		want     any
+		wantCode string
+	}{
+		"nil value": {
+			field: models.ActivityField{Type: models.FieldTypeNumber},
+			value: nil,
+			want:  nil,
+		},
+		"text from number": {
+			field: models.ActivityField{Type: models.FieldTypeText},
+			value: 12.5,
+			want:  "12.5",
+		},
+		"text from boolean": {
+			field:    models.ActivityField{Type: models.FieldTypeText},
+			value:    true,
+			wantCode: "ERR_FIELD_NOT_TEXT",
+		},
+		"number from string": {
+			field: models.ActivityField{Type: models.FieldTypeNumber},
+			value: " 12,5 ",
+			want:  12.5,
+		},
+		"number from invalid string": {
+			field:    models.ActivityField{Type: models.FieldTypeNumber},
+			value:    "twelve",
+			wantCode: "ERR_FIELD_NOT_NUMBER",
+		},
+		"date with slashes": {
+			field: models.ActivityField{Type: models.FieldTypeDate},
+			value: "25/12/2023",
+			want:  "2023-12-25",
+		},
+		"date from datetime": {
+			field: models.ActivityField{Type: models.FieldTypeDate},
+			value: "2023-12-25T10:00:00Z",
+			want:  "2023-12-25",
+		},
+		"invalid date": {
+			field:    models.ActivityField{Type: models.FieldTypeDate},
+			value:    "2023-13-45",
+			wantCode: "ERR_FIELD_NOT_DATE",
+		},
+		"time with meridiem": {
+			field: models.ActivityField{Type: models.FieldTypeTime},
+			value: "3:04 PM",
+			want:  "15:04",
+		},
+		"multiple numbers": {
+			field: models.ActivityField{
+				Type:    models.FieldTypeNumber,
+				Options: models.ActivityFieldOptions{Multiple: true},
+			},
+			value: []any{"1", 2.0},
+			want:  []any{1.0, 2.0},
+		},
+		"single choice": {
+			field: models.ActivityField{
+				Type:    models.FieldTypeMultipleChoices,
+				Details: models.ActivityFieldType{ActivityFieldMultipleChoices: &models.ActivityFieldMultipleChoices{Choices: []string{"a", "b"}}},
+			},
+			value: "a",
+			want:  "a",
+		},
+		"unknown choice": {
+			field: models.ActivityField{
+				Type:    models.FieldTypeMultipleChoices,
+				Details: models.ActivityFieldType{ActivityFieldMultipleChoices: &models.ActivityFieldMultipleChoices{Choices: []string{"a", "b"}}},
+			},
+			value:    "c",
+			wantCode: "ERR_FIELD_INVALID_CHOICE",
+		},
+		"many choices": {
+			field: models.ActivityField{
+				Type:    models.FieldTypeMultipleChoices,
+				Details: models.ActivityFieldType{ActivityFieldMultipleChoices: &models.ActivityFieldMultipleChoices{Multiple: true, Choices: []string{"a", "b"}}},
+			},
+			value: []any{"a", "b"},
+			want:  []string{"a", "b"},
+		},
+		"upload from json string": {
+			field: models.ActivityField{Type: models.FieldTypeUpload},
+			value: `["https://s3/a.pdf"]`,
+			want:  []string{"https://s3/a.pdf"},
+		},
+		"upload too many files": {
+			field: models.ActivityField{
+				Type:    models.FieldTypeUpload,
+				Details: models.ActivityFieldType{ActivityFieldUpload: &models.ActivityFieldUpload{MaxNumberOfFiles: 1}},
+			},
+			value:    []any{"a.pdf", "b.pdf"},
+			wantCode: "ERR_FIELD_TOO_MANY_FILES",
+		},
+		"upload of wrong type": {
+			field: models.ActivityField{
+				Type:    models.FieldTypeUpload,
+				Details: models.ActivityFieldType{ActivityFieldUpload: &models.ActivityFieldUpload{TypeOfFiles: []string{"image/*"}}},
+			},
+			value:    []any{"a.pdf"},
+			wantCode: "ERR_FIELD_INVALID_FILE_TYPE",
+		},
+		"key from list": {
+			field:    models.ActivityField{Type: models.FieldTypeKey},
+			value:    []any{"a"},
+			wantCode: "ERR_FIELD_INVALID_KEY",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := tc.field.Cast(tc.value)
+			if tc.wantCode != "" {
+				if err == nil || err.Code != tc.wantCode {
+					t.Fatalf("Cast(%v) error = %v; want code %s", tc.value, err, tc.wantCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Cast(%v) error = %v; want nil", tc.value, err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("Cast(%v) = %#v; want %#v", tc.value, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestActivityValidateValues(t *testing.T) {
+	quantity := models.ActivityField{Id: primitive.NewObjectID(), Type: models.FieldTypeNumber}
+	date := models.ActivityField{Id: primitive.NewObjectID(), Type: models.FieldTypeDate}
+	activity := models.Activity{Fields: []models.ActivityField{quantity, date}}
+
+	values, errors := activity.ValidateValues(map[string]any{
+		quantity.Id.Hex():             "10",
+		date.Id.Hex():                 "yesterday",
+		primitive.NewObjectID().Hex(): "unknown",
+	})
+	if len(errors) != 2 {
+		t.Fatalf("ValidateValues() errors = %v; want 2 errors", errors)
+	}
+	if values[quantity.Id.Hex()] != 10.0 {
+		t.Fatalf("ValidateValues() quantity = %v; want 10", values[quantity.Id.Hex()])
+	}
+	if _, ok := values[date.Id.Hex()]; ok {
+		t.Fatalf("ValidateValues() date should not be kept when invalid")
+	}
+}
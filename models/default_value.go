@@ -0,0 +1,143 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// Dynamic default values, computed when the data is created.
+// A default value starting with @ is written @@.
+const (
+	DefaultNow              = "@now"
+	DefaultToday            = "@today"
+	DefaultCurrentUser      = "@current_user"
+	DefaultCurrentUserPhone = "@current_user_phone"
+	// the last value entered in the field by the user
+	DefaultLastValue = "@last"
+)
+
+// defaultTokenTypes are the types of the fields each dynamic default value can be used for, all when empty
+var defaultTokenTypes = map[string][]string{
+	DefaultNow:              {FieldTypeDate, FieldTypeTime, FieldTypeDateTime, FieldTypeText},
+	DefaultToday:            {FieldTypeDate, FieldTypeDateTime, FieldTypeText},
+	DefaultCurrentUser:      {FieldTypeText},
+	DefaultCurrentUserPhone: {FieldTypeText, FieldTypePhone},
+	DefaultLastValue:        {},
+}
+
+// DefaultValueContext is what the dynamic default values are computed from
+type DefaultValueContext struct {
+	Now             time.Time
+	UserName        string
+	UserPhoneNumber string
+	// the last value entered by the user, by field id
+	LastValues map[string]any
+}
+
+// DefaultValueToken returns the dynamic default value of the field, if it has one
+func (field ActivityField) DefaultValueToken() (string, bool) {
+	if !field.HasDefaultValue() {
+		return "", false
+	}
+	value := strings.TrimSpace(*field.Options.DefaultValue)
+	if !strings.HasPrefix(value, "@") || strings.HasPrefix(value, "@@") {
+		return "", false
+	}
+	return strings.ToLower(value), true
+}
+
+// HasDefaultValue tells if the field has a default value, an empty one being none
+func (field ActivityField) HasDefaultValue() bool {
+	return field.Options.DefaultValue != nil && strings.TrimSpace(*field.Options.DefaultValue) != ""
+}
+
+// ValidateDefaultValue checks that the default value of the field can be one of its values
+func (field ActivityField) ValidateDefaultValue() *FieldError {
+	if !field.HasDefaultValue() {
+		return nil
+	}
+
+	token, ok := field.DefaultValueToken()
+	if !ok {
+		_, err := field.Cast(field.literalDefaultValue())
+		if err != nil {
+			return newFieldError(field, "ERR_FIELD_INVALID_DEFAULT", "the default value is invalid: %s", err.Message)
+		}
+		return nil
+	}
+
+	types, ok := defaultTokenTypes[token]
+	if !ok {
+		return newFieldError(field, "ERR_FIELD_INVALID_DEFAULT", "unknown default value %s", token)
+	}
+	if len(types) > 0 && !contains(types, field.Type) {
+		return newFieldError(field, "ERR_FIELD_INVALID_DEFAULT", "%s can not be the default value of a %s field", token, field.Type)
+	}
+	return nil
+}
+
+// ValidateDefaultValues checks the default values of all the fields
+func ValidateDefaultValues(fields []ActivityField) []FieldError {
+	errors := make([]FieldError, 0)
+	for _, field := range fields {
+		if err := field.ValidateDefaultValue(); err != nil {
+			errors = append(errors, *err)
+		}
+	}
+	return errors
+}
+
+// DefaultValue returns the value of the field when none is given, nil when it has no default value.
+// The value still has to be cast, see ActivityField.Cast.
+func (field ActivityField) DefaultValue(context DefaultValueContext) (any, *FieldError) {
+	if err := field.ValidateDefaultValue(); err != nil {
+		return nil, err
+	}
+
+	if !field.HasDefaultValue() {
+		return nil, nil
+	}
+	token, ok := field.DefaultValueToken()
+	if !ok {
+		return field.literalDefaultValue(), nil
+	}
+
+	switch token {
+	case DefaultNow:
+		switch field.Type {
+		case FieldTypeDate:
+			return context.Now.Format(DateLayout), nil
+		case FieldTypeTime:
+			return context.Now.Format(TimeLayout), nil
+		default:
+			return context.Now.Format(time.RFC3339), nil
+		}
+	case DefaultToday:
+		return context.Now.Format(DateLayout), nil
+	case DefaultCurrentUser:
+		if context.UserName == "" {
+			return nilIfEmpty(context.UserPhoneNumber), nil
+		}
+		return context.UserName, nil
+	case DefaultCurrentUserPhone:
+		return nilIfEmpty(context.UserPhoneNumber), nil
+	default:
+		return context.LastValues[field.Id.Hex()], nil
+	}
+}
+
+// literalDefaultValue is the default value as written, without the escape of its @
+func (field ActivityField) literalDefaultValue() string {
+	value := *field.Options.DefaultValue
+	if strings.HasPrefix(strings.TrimSpace(value), "@@") {
+		return strings.Replace(value, "@@", "@", 1)
+	}
+	return value
+}
+
+func nilIfEmpty(value string) any {
+	if value == "" {
+		return nil
+	}
+	return value
+}
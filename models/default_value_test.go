@@ -0,0 +1,117 @@
+package models_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"stockinos.com/api/models"
+)
+
+func withDefault(fieldType, defaultValue string) models.ActivityField {
+	return models.ActivityField{
+		Id:      primitive.NewObjectID(),
+		Type:    fieldType,
+		Options: models.ActivityFieldOptions{DefaultValue: &defaultValue},
+	}
+}
+
+func TestActivityFieldDefaultValue(t *testing.T) {
+	lastSite := withDefault(models.FieldTypeText, "@last")
+	context := models.DefaultValueContext{
+		Now:             time.Date(2024, 3, 5, 14, 30, 0, 0, time.UTC),
+		UserName:        "Doe John",
+		UserPhoneNumber: "+237600000000",
+		LastValues:      map[string]any{lastSite.Id.Hex(): "Douala"},
+	}
+
+	tests := map[string]struct {
+		field    models.ActivityField
+		want     any
+		wantCode string
+	}{
+		"no default value": {
+			field: models.ActivityField{Type: models.FieldTypeText},
+			want:  nil,
+		},
+		"empty default value": {
+			field: withDefault(models.FieldTypeNumber, " "),
+			want:  nil,
+		},
+		"literal": {
+			field: withDefault(models.FieldTypeNumber, "12"),
+			want:  "12",
+		},
+		"escaped literal": {
+			field: withDefault(models.FieldTypeText, "@@home"),
+			want:  "@home",
+		},
+		"today": {
+			field: withDefault(models.FieldTypeDate, "@today"),
+			want:  "2024-03-05",
+		},
+		"now as a time": {
+			field: withDefault(models.FieldTypeTime, "@NOW"),
+			want:  "14:30",
+		},
+		"current user": {
+			field: withDefault(models.FieldTypeText, "@current_user"),
+			want:  "Doe John",
+		},
+		"current user phone": {
+			field: withDefault(models.FieldTypeText, "@current_user_phone"),
+			want:  "+237600000000",
+		},
+		"last value": {
+			field: lastSite,
+			want:  "Douala",
+		},
+		"no last value": {
+			field: withDefault(models.FieldTypeText, "@last"),
+			want:  nil,
+		},
+		"invalid literal": {
+			field:    withDefault(models.FieldTypeNumber, "twelve"),
+			wantCode: "ERR_FIELD_INVALID_DEFAULT",
+		},
+		"unknown token": {
+			field:    withDefault(models.FieldTypeText, "@yesterday"),
+			wantCode: "ERR_FIELD_INVALID_DEFAULT",
+		},
+		"token of another type": {
+			field:    withDefault(models.FieldTypeNumber, "@today"),
+			wantCode: "ERR_FIELD_INVALID_DEFAULT",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := tt.field.DefaultValue(context)
+			if tt.wantCode != "" {
+				if err == nil || err.Code != tt.wantCode {
+					t.Fatalf("DefaultValue() error = %v, want %s", err, tt.wantCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DefaultValue() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DefaultValue() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateDefaultValues(t *testing.T) {
+	fields := []models.ActivityField{
+		withDefault(models.FieldTypeDate, "2024-02-30"),
+		withDefault(models.FieldTypeDate, "01/02/2024"),
+		withDefault(models.FieldTypeText, "@current_user"),
+	}
+
+	errors := models.ValidateDefaultValues(fields)
+	if len(errors) != 1 || errors[0].FieldId != fields[0].Id.Hex() {
+		t.Errorf("ValidateDefaultValues() = %v, want an error on the first field", errors)
+	}
+}
@@ -0,0 +1,550 @@
+package models
+
+import (
+	"math"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	// the time zones of the date-time fields must be known whatever the system the api runs on
+	_ "time/tzdata"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DateTimeLayout is used to store the date-time values, always in UTC for them to be sorted as texts.
+// The time zone of the field reads the values written without an offset and shows them.
+const DateTimeLayout = time.RFC3339
+
+var acceptedDateTimeLayouts = []string{
+	time.RFC3339,
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05Z0700",
+}
+
+// layouts without an offset, read in the time zone of the field
+var acceptedLocalDateTimeLayouts = []string{
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"02/01/2006 15:04:05",
+	"02/01/2006 15:04",
+	DateLayout,
+}
+
+// Formats of the barcodes
+const (
+	BarcodeQR         = "qr"
+	BarcodeDataMatrix = "data-matrix"
+	BarcodeEAN13      = "ean-13"
+	BarcodeEAN8       = "ean-8"
+	BarcodeUPCA       = "upc-a"
+	BarcodeCode128    = "code-128"
+	BarcodeCode39     = "code-39"
+)
+
+var BarcodeFormats = []string{BarcodeQR, BarcodeDataMatrix, BarcodeEAN13, BarcodeEAN8, BarcodeUPCA, BarcodeCode128, BarcodeCode39}
+
+// Formats of the rich texts. The html is stored as it is sent, it must be sanitized where it is shown.
+const (
+	RichTextMarkdown = "markdown"
+	RichTextHTML     = "html"
+)
+
+const (
+	defaultDecimalPrecision = 2
+	defaultMaxRating        = 5
+	maxDecimalPrecision     = 10
+	maxRating               = 10
+)
+
+// decimals of the currencies whose minor unit is not the cent
+var currencyDecimals = map[string]int{
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0, "KMF": 0, "KRW": 0, "PYG": 0,
+	"RWF": 0, "UGX": 0, "VND": 0, "VUV": 0, "XAF": 0, "XOF": 0, "XPF": 0,
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+}
+
+var (
+	currencyPattern    = regexp.MustCompile(`^[A-Z]{3}$`)
+	countryCodePattern = regexp.MustCompile(`^\+?[1-9][0-9]{0,2}$`)
+	phonePattern       = regexp.MustCompile(`^\+[1-9][0-9]{6,14}$`)
+	code39Pattern      = regexp.MustCompile(`^[0-9A-Z \-.$/+%]+$`)
+	isoDurationPattern = regexp.MustCompile(`^P(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+	clockPattern       = regexp.MustCompile(`^(\d+):([0-5]\d)(?::([0-5]\d))?$`)
+)
+
+// IsNumberType tells if the values of the fields of the type are numbers
+func IsNumberType(fieldType string) bool {
+	switch fieldType {
+	case FieldTypeNumber, FieldTypeInteger, FieldTypeDecimal, FieldTypeCurrency, FieldTypeRating, FieldTypeDuration:
+		return true
+	default:
+		return false
+	}
+}
+
+// NumberValue reads a number, as it is cast or as it is read from the database
+func NumberValue(value any) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	default:
+		return 0, false
+	}
+}
+
+// ValidateDetails checks the details of the type of the field
+func (field ActivityField) ValidateDetails() *FieldError {
+	invalid := func(format string, args ...any) *FieldError {
+		return newFieldError(field, "ERR_FIELD_INVALID_DETAILS", format, args...)
+	}
+	details := field.Details
+
+	switch field.Type {
+	case FieldTypeInteger:
+		if details.ActivityFieldNumber != nil && details.ActivityFieldNumber.Precision != 0 {
+			return invalid("an integer has no decimals")
+		}
+	case FieldTypeDecimal:
+		if d := details.ActivityFieldNumber; d != nil && (d.Precision < 0 || d.Precision > maxDecimalPrecision) {
+			return invalid("the precision must be between 0 and %d", maxDecimalPrecision)
+		}
+	case FieldTypeCurrency:
+		if d := details.ActivityFieldCurrency; d != nil && d.Currency != "" && !currencyPattern.MatchString(d.Currency) {
+			return invalid("%q is not a currency code", d.Currency)
+		}
+	case FieldTypePhone:
+		if d := details.ActivityFieldPhone; d != nil && d.DefaultCountryCode != "" && !countryCodePattern.MatchString(d.DefaultCountryCode) {
+			return invalid("%q is not a country calling code", d.DefaultCountryCode)
+		}
+	case FieldTypeBarcode:
+		if d := details.ActivityFieldBarcode; d != nil {
+			for _, format := range d.Formats {
+				if !contains(BarcodeFormats, format) {
+					return invalid("unknown barcode format %q", format)
+				}
+			}
+		}
+	case FieldTypeRating:
+		if d := details.ActivityFieldRating; d != nil && (d.MaxRating < 0 || d.MaxRating > maxRating) {
+			return invalid("the maximum rating must be between 1 and %d", maxRating)
+		}
+	case FieldTypeDateTime:
+		if d := details.ActivityFieldDateTime; d != nil && d.TimeZone != "" {
+			if _, err := time.LoadLocation(d.TimeZone); err != nil {
+				return invalid("unknown time zone %q", d.TimeZone)
+			}
+		}
+	case FieldTypeRichText:
+		if d := details.ActivityFieldRichText; d != nil && d.Format != "" && d.Format != RichTextMarkdown && d.Format != RichTextHTML {
+			return invalid("unknown text format %q", d.Format)
+		}
+	}
+	return nil
+}
+
+// ValidateFieldDetails checks the details of the types of all the fields
+func ValidateFieldDetails(fields []ActivityField) []FieldError {
+	errors := make([]FieldError, 0)
+	for _, field := range fields {
+		if err := field.ValidateDetails(); err != nil {
+			errors = append(errors, *err)
+		}
+	}
+	return errors
+}
+
+func (field ActivityField) castBoolean(value any) (any, *FieldError) {
+	switch v := value.(type) {
+	case bool:
+		return v, nil
+	case string:
+		switch strings.ToLower(strings.TrimSpace(v)) {
+		case "true", "yes", "y", "on", "1":
+			return true, nil
+		case "false", "no", "n", "off", "0":
+			return false, nil
+		}
+	default:
+		if n, ok := NumberValue(v); ok && (n == 0 || n == 1) {
+			return n == 1, nil
+		}
+	}
+	return nil, newFieldError(field, "ERR_FIELD_NOT_BOOLEAN", "true or false is expected")
+}
+
+func (field ActivityField) castInteger(value any) (any, *FieldError) {
+	n, err := field.castNumber(value)
+	if err != nil {
+		return nil, err
+	}
+	number := n.(float64)
+	if number != math.Trunc(number) || math.Abs(number) > 1<<53 {
+		return nil, newFieldError(field, "ERR_FIELD_NOT_INTEGER", "%s is not an integer", formatNumber(number))
+	}
+	return int64(number), nil
+}
+
+func (field ActivityField) castDecimal(value any) (any, *FieldError) {
+	n, err := field.castNumber(value)
+	if err != nil {
+		return nil, err
+	}
+	precision := defaultDecimalPrecision
+	if details := field.Details.ActivityFieldNumber; details != nil {
+		precision = details.Precision
+	}
+	return roundTo(n.(float64), precision), nil
+}
+
+func (field ActivityField) castCurrency(value any) (any, *FieldError) {
+	currency := ""
+	if details := field.Details.ActivityFieldCurrency; details != nil {
+		currency = details.Currency
+	}
+
+	if v, ok := value.(string); ok {
+		// amounts are often written with their currency and with spaces between the thousands
+		v = strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(v), currency), currency))
+		value = strings.NewReplacer(" ", "", "\u00a0", "", "\u202f", "").Replace(v)
+	}
+	n, err := field.castNumber(value)
+	if err != nil {
+		return nil, err
+	}
+
+	decimals, ok := currencyDecimals[currency]
+	if !ok {
+		decimals = 2
+	}
+	return roundTo(n.(float64), decimals), nil
+}
+
+func (field ActivityField) castEmail(value any) (any, *FieldError) {
+	v, ok := value.(string)
+	if !ok {
+		return nil, newFieldError(field, "ERR_FIELD_NOT_EMAIL", "an email address is expected")
+	}
+	v = strings.TrimSpace(v)
+
+	address, err := mail.ParseAddress(v)
+	at := strings.LastIndex(v, "@")
+	if err != nil || address.Name != "" || address.Address != v || !strings.Contains(v[at+1:], ".") {
+		return nil, newFieldError(field, "ERR_FIELD_NOT_EMAIL", "%q is not a valid email address", v)
+	}
+	// only the domain is case insensitive
+	return v[:at+1] + strings.ToLower(v[at+1:]), nil
+}
+
+// castPhone normalises a phone number in the E.164 format, +237600000000
+func (field ActivityField) castPhone(value any) (any, *FieldError) {
+	text, err := field.castText(value)
+	if err != nil {
+		return nil, newFieldError(field, "ERR_FIELD_NOT_PHONE", "a phone number is expected")
+	}
+	v := strings.TrimSpace(text.(string))
+	number := strings.NewReplacer(" ", "", "-", "", ".", "", "(", "", ")", "", "/", "", "\u00a0", "").Replace(v)
+
+	switch {
+	case strings.HasPrefix(number, "+"):
+	case strings.HasPrefix(number, "00"):
+		number = "+" + strings.TrimPrefix(number, "00")
+	default:
+		details := field.Details.ActivityFieldPhone
+		if details == nil || details.DefaultCountryCode == "" {
+			return nil, newFieldError(field, "ERR_FIELD_NOT_PHONE", "%q has no country code", v)
+		}
+		// the trunk prefix is not written with the country code
+		number = "+" + strings.TrimPrefix(details.DefaultCountryCode, "+") + strings.TrimPrefix(number, "0")
+	}
+
+	if !phonePattern.MatchString(number) {
+		return nil, newFieldError(field, "ERR_FIELD_NOT_PHONE", "%q is not a valid phone number", v)
+	}
+	return number, nil
+}
+
+func (field ActivityField) castURL(value any) (any, *FieldError) {
+	v, ok := value.(string)
+	if !ok {
+		return nil, newFieldError(field, "ERR_FIELD_NOT_URL", "a link is expected")
+	}
+	v = strings.TrimSpace(v)
+
+	link := v
+	if !strings.Contains(link, "://") {
+		link = "https://" + link
+	}
+	u, err := url.Parse(link)
+	if err != nil || u.Host == "" {
+		return nil, newFieldError(field, "ERR_FIELD_NOT_URL", "%q is not a valid link", v)
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, newFieldError(field, "ERR_FIELD_NOT_URL", "only the http and https links are accepted")
+	}
+	u.Host = strings.ToLower(u.Host)
+	return u.String(), nil
+}
+
+// castGeolocation stores a location as [longitude, latitude], the order of GeoJSON which
+// the geospatial indexes read. It is sent like that, as {"lat": …, "lng": …},
+// as a GeoJSON point, or written "latitude, longitude".
+func (field ActivityField) castGeolocation(value any) (any, *FieldError) {
+	invalid := newFieldError(field, "ERR_FIELD_NOT_GEOLOCATION", "a latitude and a longitude are expected")
+
+	var coordinates []any
+	switch v := value.(type) {
+	case string:
+		parts := strings.Split(v, ",")
+		if len(parts) != 2 {
+			return nil, invalid
+		}
+		coordinates = []any{parts[1], parts[0]}
+	case []float64:
+		coordinates = []any{}
+		for _, c := range v {
+			coordinates = append(coordinates, c)
+		}
+	case primitive.D:
+		return field.castGeolocation(v.Map())
+	case primitive.M:
+		return field.castGeolocation(map[string]any(v))
+	case map[string]any:
+		if c, ok := toList(v["coordinates"]); ok {
+			coordinates = c
+			break
+		}
+		coordinates = []any{firstOf(v, "lng", "lon", "longitude"), firstOf(v, "lat", "latitude")}
+	default:
+		items, ok := toList(v)
+		if !ok {
+			return nil, invalid
+		}
+		coordinates = items
+	}
+	if len(coordinates) != 2 {
+		return nil, invalid
+	}
+
+	lng, lngErr := field.castNumber(coordinates[0])
+	lat, latErr := field.castNumber(coordinates[1])
+	if lngErr != nil || latErr != nil {
+		return nil, invalid
+	}
+	if math.Abs(lat.(float64)) > 90 || math.Abs(lng.(float64)) > 180 {
+		return nil, newFieldError(field, "ERR_FIELD_NOT_GEOLOCATION", "the latitude must be between -90 and 90, the longitude between -180 and 180")
+	}
+	return []float64{lng.(float64), lat.(float64)}, nil
+}
+
+func (field ActivityField) castBarcode(value any) (any, *FieldError) {
+	text, err := field.castText(value)
+	if err != nil {
+		return nil, newFieldError(field, "ERR_FIELD_NOT_BARCODE", "a barcode is expected")
+	}
+	v := strings.TrimSpace(text.(string))
+	if v == "" {
+		return nil, newFieldError(field, "ERR_FIELD_NOT_BARCODE", "a barcode is expected")
+	}
+
+	details := field.Details.ActivityFieldBarcode
+	if details == nil || len(details.Formats) == 0 {
+		return v, nil
+	}
+	for _, format := range details.Formats {
+		if isBarcodeOfFormat(v, format) {
+			return v, nil
+		}
+	}
+	return nil, newFieldError(field, "ERR_FIELD_NOT_BARCODE", "%q is not a %s barcode", v, strings.Join(details.Formats, " or "))
+}
+
+func (field ActivityField) castRating(value any) (any, *FieldError) {
+	n, err := field.castNumber(value)
+	if err != nil {
+		return nil, err
+	}
+	rating := n.(float64)
+
+	max, step := float64(defaultMaxRating), 1.0
+	if details := field.Details.ActivityFieldRating; details != nil {
+		if details.MaxRating > 0 {
+			max = float64(details.MaxRating)
+		}
+		if details.HalfRatings {
+			step = 0.5
+		}
+	}
+	if rating < step || rating > max || math.Mod(rating, step) != 0 {
+		return nil, newFieldError(field, "ERR_FIELD_INVALID_RATING", "the rating must be between %s and %s, by %s", formatNumber(step), formatNumber(max), formatNumber(step))
+	}
+	return rating, nil
+}
+
+func (field ActivityField) castDateTime(value any) (any, *FieldError) {
+	switch v := value.(type) {
+	case time.Time:
+		return v.UTC().Format(DateTimeLayout), nil
+	case primitive.DateTime:
+		return v.Time().UTC().Format(DateTimeLayout), nil
+	case string:
+		v = strings.TrimSpace(v)
+		for _, layout := range acceptedDateTimeLayouts {
+			if t, err := time.Parse(layout, v); err == nil {
+				return t.UTC().Format(DateTimeLayout), nil
+			}
+		}
+		location := field.location()
+		for _, layout := range acceptedLocalDateTimeLayouts {
+			if t, err := time.ParseInLocation(layout, v, location); err == nil {
+				return t.UTC().Format(DateTimeLayout), nil
+			}
+		}
+		return nil, newFieldError(field, "ERR_FIELD_NOT_DATE_TIME", "%q is not a valid date and time", v)
+	default:
+		return nil, newFieldError(field, "ERR_FIELD_NOT_DATE_TIME", "a date and a time are expected")
+	}
+}
+
+// location is the time zone of a date-time field, UTC by default
+func (field ActivityField) location() *time.Location {
+	details := field.Details.ActivityFieldDateTime
+	if details == nil || details.TimeZone == "" {
+		return time.UTC
+	}
+	location, err := time.LoadLocation(details.TimeZone)
+	if err != nil {
+		return time.UTC
+	}
+	return location
+}
+
+// castDuration stores a duration as a number of seconds. It is sent like that, or written
+// 1h30m, 1:30, 1:30:00 or in the ISO 8601 format, PT1H30M.
+func (field ActivityField) castDuration(value any) (any, *FieldError) {
+	var seconds float64
+	if v, ok := value.(string); ok {
+		s, ok := parseDuration(strings.TrimSpace(v))
+		if !ok {
+			return nil, newFieldError(field, "ERR_FIELD_NOT_DURATION", "%q is not a valid duration", v)
+		}
+		seconds = s
+	} else {
+		n, ok := NumberValue(value)
+		if !ok {
+			return nil, newFieldError(field, "ERR_FIELD_NOT_DURATION", "a duration is expected")
+		}
+		seconds = n
+	}
+
+	if seconds < 0 {
+		return nil, newFieldError(field, "ERR_FIELD_NOT_DURATION", "a duration can not be negative")
+	}
+	return int64(math.Round(seconds)), nil
+}
+
+func (field ActivityField) castRichText(value any) (any, *FieldError) {
+	v, ok := value.(string)
+	if !ok {
+		return nil, newFieldError(field, "ERR_FIELD_NOT_TEXT", "a text is expected")
+	}
+	return strings.ReplaceAll(v, "\x00", ""), nil
+}
+
+// parseDuration reads a duration in seconds
+func parseDuration(value string) (float64, bool) {
+	if n, err := strconv.ParseFloat(value, 64); err == nil {
+		return n, true
+	}
+	if m := clockPattern.FindStringSubmatch(value); m != nil {
+		hours, _ := strconv.Atoi(m[1])
+		minutes, _ := strconv.Atoi(m[2])
+		seconds, _ := strconv.Atoi(m[3])
+		return float64(hours*3600 + minutes*60 + seconds), true
+	}
+	if m := isoDurationPattern.FindStringSubmatch(value); m != nil && value != "P" && !strings.HasSuffix(value, "T") {
+		total := 0
+		for i, unit := range []int{86400, 3600, 60, 1} {
+			n, _ := strconv.Atoi(m[i+1])
+			total += n * unit
+		}
+		return float64(total), true
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return d.Seconds(), true
+	}
+	return 0, false
+}
+
+func isBarcodeOfFormat(value, format string) bool {
+	switch format {
+	case BarcodeEAN13:
+		return len(value) == 13 && hasGTINCheckDigit(value)
+	case BarcodeEAN8:
+		return len(value) == 8 && hasGTINCheckDigit(value)
+	case BarcodeUPCA:
+		return len(value) == 12 && hasGTINCheckDigit(value)
+	case BarcodeCode39:
+		return code39Pattern.MatchString(value)
+	case BarcodeCode128:
+		for _, r := range value {
+			if r < 32 || r > 126 {
+				return false
+			}
+		}
+		return true
+	case BarcodeQR, BarcodeDataMatrix:
+		return true
+	default:
+		return false
+	}
+}
+
+// hasGTINCheckDigit checks the last digit of an EAN or a UPC code
+func hasGTINCheckDigit(value string) bool {
+	sum := 0
+	for i := len(value) - 1; i >= 0; i-- {
+		digit := int(value[i] - '0')
+		if digit < 0 || digit > 9 {
+			return false
+		}
+		if i == len(value)-1 {
+			continue
+		}
+		// the digits are weighted 3 and 1 from the right, the check digit excepted
+		if (len(value)-1-i)%2 == 1 {
+			sum += 3 * digit
+		} else {
+			sum += digit
+		}
+	}
+	return (10-sum%10)%10 == int(value[len(value)-1]-'0')
+}
+
+func roundTo(value float64, decimals int) float64 {
+	power := math.Pow(10, float64(decimals))
+	return math.Round(value*power) / power
+}
+
+// firstOf returns the first value found in the map for the keys
+func firstOf(values map[string]any, keys ...string) any {
+	for _, key := range keys {
+		if value, ok := values[key]; ok {
+			return value
+		}
+	}
+	return nil
+}
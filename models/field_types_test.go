@@ -0,0 +1,263 @@
+package models_test
+
+import (
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"stockinos.com/api/models"
+)
+
+func TestActivityFieldCastTypes(t *testing.T) {
+	typed := func(fieldType string, details models.ActivityFieldType) models.ActivityField {
+		return models.ActivityField{Id: primitive.NewObjectID(), Type: fieldType, Details: details}
+	}
+	decimal := typed(models.FieldTypeDecimal, models.ActivityFieldType{ActivityFieldNumber: &models.ActivityFieldNumber{Precision: 1}})
+	xaf := typed(models.FieldTypeCurrency, models.ActivityFieldType{ActivityFieldCurrency: &models.ActivityFieldCurrency{Currency: "XAF"}})
+	phone := typed(models.FieldTypePhone, models.ActivityFieldType{ActivityFieldPhone: &models.ActivityFieldPhone{DefaultCountryCode: "237"}})
+	ean := typed(models.FieldTypeBarcode, models.ActivityFieldType{ActivityFieldBarcode: &models.ActivityFieldBarcode{Formats: []string{models.BarcodeEAN13}}})
+	halves := typed(models.FieldTypeRating, models.ActivityFieldType{ActivityFieldRating: &models.ActivityFieldRating{MaxRating: 5, HalfRatings: true}})
+	douala := typed(models.FieldTypeDateTime, models.ActivityFieldType{ActivityFieldDateTime: &models.ActivityFieldDateTime{TimeZone: "Africa/Douala"}})
+
+	tests := map[string]struct {
+		field    models.ActivityField
+		value    any
+		want     any
+		wantCode string
+	}{
+		"boolean from a text": {
+			field: typed(models.FieldTypeBoolean, models.ActivityFieldType{}),
+			value: "Yes",
+			want:  true,
+		},
+		"boolean from a number": {
+			field: typed(models.FieldTypeBoolean, models.ActivityFieldType{}),
+			value: 0.0,
+			want:  false,
+		},
+		"not a boolean": {
+			field:    typed(models.FieldTypeBoolean, models.ActivityFieldType{}),
+			value:    "maybe",
+			wantCode: "ERR_FIELD_NOT_BOOLEAN",
+		},
+		"integer": {
+			field: typed(models.FieldTypeInteger, models.ActivityFieldType{}),
+			value: "42",
+			want:  int64(42),
+		},
+		"not an integer": {
+			field:    typed(models.FieldTypeInteger, models.ActivityFieldType{}),
+			value:    4.2,
+			wantCode: "ERR_FIELD_NOT_INTEGER",
+		},
+		"decimal rounded to its precision": {
+			field: decimal,
+			value: "3,14159",
+			want:  3.1,
+		},
+		"currency without decimals": {
+			field: xaf,
+			value: "12 500,4 XAF",
+			want:  12500.0,
+		},
+		"email with an uppercase domain": {
+			field: typed(models.FieldTypeEmail, models.ActivityFieldType{}),
+			value: " John.Doe@Example.COM ",
+			want:  "John.Doe@example.com",
+		},
+		"email with a name": {
+			field:    typed(models.FieldTypeEmail, models.ActivityFieldType{}),
+			value:    "John <john@example.com>",
+			wantCode: "ERR_FIELD_NOT_EMAIL",
+		},
+		"national phone number": {
+			field: phone,
+			value: "6 99 00-00 00",
+			want:  "+237699000000",
+		},
+		"international phone number": {
+			field: phone,
+			value: "0033 6 12 34 56 78",
+			want:  "+33612345678",
+		},
+		"phone number without a country code": {
+			field:    typed(models.FieldTypePhone, models.ActivityFieldType{}),
+			value:    "699000000",
+			wantCode: "ERR_FIELD_NOT_PHONE",
+		},
+		"url without a scheme": {
+			field: typed(models.FieldTypeURL, models.ActivityFieldType{}),
+			value: "Example.com/Path",
+			want:  "https://example.com/Path",
+		},
+		"url of another scheme": {
+			field:    typed(models.FieldTypeURL, models.ActivityFieldType{}),
+			value:    "ftp://example.com",
+			wantCode: "ERR_FIELD_NOT_URL",
+		},
+		"geolocation from a text": {
+			field: typed(models.FieldTypeGeolocation, models.ActivityFieldType{}),
+			value: "4.0511, 9.7679",
+			want:  []float64{9.7679, 4.0511},
+		},
+		"geolocation from an object": {
+			field: typed(models.FieldTypeGeolocation, models.ActivityFieldType{}),
+			value: map[string]any{"lat": 4.0511, "lng": 9.7679},
+			want:  []float64{9.7679, 4.0511},
+		},
+		"geolocation as stored": {
+			field: typed(models.FieldTypeGeolocation, models.ActivityFieldType{}),
+			value: primitive.A{9.7679, 4.0511},
+			want:  []float64{9.7679, 4.0511},
+		},
+		"latitude out of range": {
+			field:    typed(models.FieldTypeGeolocation, models.ActivityFieldType{}),
+			value:    "91, 9",
+			wantCode: "ERR_FIELD_NOT_GEOLOCATION",
+		},
+		"ean-13": {
+			field: ean,
+			value: "4006381333931",
+			want:  "4006381333931",
+		},
+		"ean-13 with a wrong check digit": {
+			field:    ean,
+			value:    "4006381333932",
+			wantCode: "ERR_FIELD_NOT_BARCODE",
+		},
+		"half rating": {
+			field: halves,
+			value: 3.5,
+			want:  3.5,
+		},
+		"rating above the maximum": {
+			field:    halves,
+			value:    6,
+			wantCode: "ERR_FIELD_INVALID_RATING",
+		},
+		"date-time with an offset": {
+			field: douala,
+			value: "2024-03-05T10:00:00+02:00",
+			want:  "2024-03-05T08:00:00Z",
+		},
+		"date-time in the time zone of the field": {
+			field: douala,
+			value: "2024-03-05 10:00",
+			want:  "2024-03-05T09:00:00Z",
+		},
+		"duration as a clock": {
+			field: typed(models.FieldTypeDuration, models.ActivityFieldType{}),
+			value: "1:30",
+			want:  int64(5400),
+		},
+		"iso duration": {
+			field: typed(models.FieldTypeDuration, models.ActivityFieldType{}),
+			value: "P1DT2H",
+			want:  int64(93600),
+		},
+		"go duration": {
+			field: typed(models.FieldTypeDuration, models.ActivityFieldType{}),
+			value: "1h15m",
+			want:  int64(4500),
+		},
+		"negative duration": {
+			field:    typed(models.FieldTypeDuration, models.ActivityFieldType{}),
+			value:    -5,
+			wantCode: "ERR_FIELD_NOT_DURATION",
+		},
+		"rich text": {
+			field: typed(models.FieldTypeRichText, models.ActivityFieldType{}),
+			value: "**bold**",
+			want:  "**bold**",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := tt.field.Cast(tt.value)
+			if tt.wantCode != "" {
+				if err == nil || err.Code != tt.wantCode {
+					t.Fatalf("Cast() error = %v, want %s", err, tt.wantCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Cast() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Cast() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestActivityFieldValidateDetails(t *testing.T) {
+	tests := map[string]struct {
+		field   models.ActivityField
+		wantErr bool
+	}{
+		"default details": {
+			field: models.ActivityField{Type: models.FieldTypeRating, Details: models.NewActivityFieldType(models.FieldTypeRating)},
+		},
+		"integer with decimals": {
+			field: models.ActivityField{
+				Type:    models.FieldTypeInteger,
+				Details: models.ActivityFieldType{ActivityFieldNumber: &models.ActivityFieldNumber{Precision: 2}},
+			},
+			wantErr: true,
+		},
+		"unknown currency code": {
+			field: models.ActivityField{
+				Type:    models.FieldTypeCurrency,
+				Details: models.ActivityFieldType{ActivityFieldCurrency: &models.ActivityFieldCurrency{Currency: "francs"}},
+			},
+			wantErr: true,
+		},
+		"unknown time zone": {
+			field: models.ActivityField{
+				Type:    models.FieldTypeDateTime,
+				Details: models.ActivityFieldType{ActivityFieldDateTime: &models.ActivityFieldDateTime{TimeZone: "Mars/Olympus"}},
+			},
+			wantErr: true,
+		},
+		"unknown barcode format": {
+			field: models.ActivityField{
+				Type:    models.FieldTypeBarcode,
+				Details: models.ActivityFieldType{ActivityFieldBarcode: &models.ActivityFieldBarcode{Formats: []string{"isbn"}}},
+			},
+			wantErr: true,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := tt.field.ValidateDetails(); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateDetails() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDecodeActivityFieldType(t *testing.T) {
+	details, err := models.DecodeActivityFieldType(models.FieldTypeRating, []byte(`{"half_ratings": true, "currency": "EUR"}`))
+	if err != nil {
+		t.Fatalf("DecodeActivityFieldType() error = %v", err)
+	}
+	want := models.ActivityFieldRating{MaxRating: 5, HalfRatings: true}
+	if !reflect.DeepEqual(details.Value(), want) || details.ActivityFieldCurrency != nil {
+		t.Errorf("DecodeActivityFieldType() = %#v, want only %#v", details, want)
+	}
+
+	// all the details are inlined in the same document, their keys must not collide
+	field := models.ActivityField{Type: models.FieldTypeRating, Details: details}
+	data, err := bson.Marshal(field)
+	if err != nil {
+		t.Fatalf("bson.Marshal() error = %v", err)
+	}
+	var decoded models.ActivityField
+	if err := bson.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("bson.Unmarshal() error = %v", err)
+	}
+	if !reflect.DeepEqual(decoded.Details.Value(), want) {
+		t.Errorf("decoded details = %#v, want %#v", decoded.Details.Value(), want)
+	}
+}
@@ -0,0 +1,55 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Invitation lets people join an organization with a link, it targets a phone number
+// or is open to anyone with the link up to a number of uses
+type Invitation struct {
+	Id             primitive.ObjectID `bson:"_id" json:"id"`
+	OrganizationId primitive.ObjectID `bson:"organization_id" json:"organization_id"`
+	Token          string             `bson:"token" json:"token"`
+	// the only phone number that can join with it, open when empty
+	PhoneNumber string `bson:"phone_number,omitempty" json:"phone_number,omitempty"`
+	// the role given to the members who join with it
+	Role string `bson:"role" json:"role"`
+	// how many members can join with it, 1 for a phone number
+	MaxUses int `bson:"max_uses" json:"max_uses"`
+	Uses    int `bson:"uses" json:"uses"`
+
+	CreatedBy DataAuthor `bson:"created_by" json:"created_by"`
+	CreatedAt time.Time  `bson:"created_at" json:"created_at"`
+	ExpiresAt time.Time  `bson:"expires_at" json:"expires_at"`
+	// the last time the link was sent to the phone number
+	SentAt    *time.Time `bson:"sent_at" json:"sent_at"`
+	RevokedAt *time.Time `bson:"revoked_at" json:"revoked_at,omitempty"`
+}
+
+// IsActive tells if someone can still join with the invitation
+func (invitation Invitation) IsActive(now time.Time) bool {
+	return invitation.RevokedAt == nil &&
+		now.Before(invitation.ExpiresAt) &&
+		invitation.Uses < invitation.MaxUses
+}
+
+// Accepts tells if the phone number can join with the invitation
+func (invitation Invitation) Accepts(phoneNumber string) bool {
+	if invitation.PhoneNumber == "" {
+		return true
+	}
+	return phoneDigits(invitation.PhoneNumber) == phoneDigits(phoneNumber)
+}
+
+// phoneDigits drops the spaces, the dashes and the + of a phone number
+func phoneDigits(phoneNumber string) string {
+	return strings.Map(func(r rune) rune {
+		if r < '0' || r > '9' {
+			return -1
+		}
+		return r
+	}, phoneNumber)
+}
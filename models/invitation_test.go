@@ -0,0 +1,55 @@
+package models_test
+
+import (
+	"testing"
+	"time"
+
+	"stockinos.com/api/models"
+)
+
+func TestInvitationIsActive(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Hour)
+	future := now.Add(time.Hour)
+
+	tests := []struct {
+		name       string
+		invitation models.Invitation
+		want       bool
+	}{
+		{"not used", models.Invitation{MaxUses: 1, ExpiresAt: future}, true},
+		{"uses left", models.Invitation{MaxUses: 5, Uses: 4, ExpiresAt: future}, true},
+		{"used up", models.Invitation{MaxUses: 5, Uses: 5, ExpiresAt: future}, false},
+		{"expired", models.Invitation{MaxUses: 1, ExpiresAt: past}, false},
+		{"revoked", models.Invitation{MaxUses: 1, ExpiresAt: future, RevokedAt: &past}, false},
+	}
+
+	for _, test := range tests {
+		if got := test.invitation.IsActive(now); got != test.want {
+			t.Errorf("%s: IsActive() = %v; want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestInvitationAccepts(t *testing.T) {
+	open := models.Invitation{}
+	targeted := models.Invitation{PhoneNumber: "+237 695 16 50 33"}
+
+	tests := []struct {
+		name        string
+		invitation  models.Invitation
+		phoneNumber string
+		want        bool
+	}{
+		{"open", open, "695165033", true},
+		{"same number", targeted, "237695165033", true},
+		{"same number written differently", targeted, "+237-695-165-033", true},
+		{"another number", targeted, "237695165034", false},
+	}
+
+	for _, test := range tests {
+		if got := test.invitation.Accepts(test.phoneNumber); got != test.want {
+			t.Errorf("%s: Accepts(%q) = %v; want %v", test.name, test.phoneNumber, got, test.want)
+		}
+	}
+}
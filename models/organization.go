@@ -26,11 +26,46 @@ type Organization struct {
 	DeletedAt *time.Time `bson:"deleted_at" json:"deleted_at,omitempty"`
 
 	CreatedBy primitive.ObjectID `bson:"created_by" json:"created_by,omitempty"`
+	DeletedBy *DataAuthor        `bson:"deleted_by,omitempty" json:"deleted_by,omitempty"`
 
-	OwnedBy         primitive.ObjectID `bson:"owned_by" json:"owned_by"`
-	InvitationToken string             `bson:"invitation_token" json:"invitation_token"`
+	OwnedBy primitive.ObjectID `bson:"owned_by" json:"owned_by"`
+	// How the people joining with an invitation become members, JoinPolicyOpen when empty
+	JoinPolicy string `bson:"join_policy,omitempty" json:"join_policy,omitempty"`
 }
 
+const (
+	// The people joining are members right away
+	JoinPolicyOpen = "open"
+	// The people joining wait for an admin to approve their request
+	JoinPolicyApproval = "approval"
+)
+
+// IsJoinPolicy tells if an organization can have the policy
+func IsJoinPolicy(policy string) bool {
+	return policy == JoinPolicyOpen || policy == JoinPolicyApproval
+}
+
+// RequiresApproval tells if the people joining have to be approved by an admin
+func (organization Organization) RequiresApproval() bool {
+	return organization.JoinPolicy == JoinPolicyApproval
+}
+
+// UserOrganization is an organization the user is a member of, with the role of the user in it
+type UserOrganization struct {
+	Organization `bson:",inline"`
+	Role         string `bson:"role" json:"role"`
+}
+
+const (
+	MemberStatusConfirmed = "confirmed"
+	// The people who asked to join, they can not access the organization until approved
+	MemberStatusPending = "pending"
+	// The members who are not part of the organization anymore, they are not listed
+	MemberStatusRejected = "rejected"
+	MemberStatusRemoved  = "removed"
+	MemberStatusLeft     = "left"
+)
+
 type Member struct {
 	Id             primitive.ObjectID `bson:"_id" json:"id"`
 	OrganizationId primitive.ObjectID `bson:"organization_id" json:"organization_id"`
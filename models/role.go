@@ -0,0 +1,115 @@
+package models
+
+const (
+	RoleOwner     = "owner"
+	RoleAdmin     = "admin"
+	RoleEditor    = "editor"
+	RoleDataEntry = "data-entry"
+	RoleViewer    = "viewer"
+
+	// Role given to the members before the roles existed, same as an editor
+	roleMember = "member"
+)
+
+type Permission string
+
+const (
+	PermOrganizationRead   Permission = "organization:read"
+	PermOrganizationUpdate Permission = "organization:update"
+	PermOrganizationDelete Permission = "organization:delete"
+	// Giving the ownership of the organization to another member
+	PermOrganizationTransfer Permission = "organization:transfer"
+
+	PermTeamRead   Permission = "team:read"
+	PermTeamManage Permission = "team:manage"
+
+	PermActivityRead   Permission = "activity:read"
+	PermActivityCreate Permission = "activity:create"
+	PermActivityUpdate Permission = "activity:update"
+	PermActivityDelete Permission = "activity:delete"
+
+	PermDataRead   Permission = "data:read"
+	PermDataCreate Permission = "data:create"
+	PermDataUpdate Permission = "data:update"
+	PermDataDelete Permission = "data:delete"
+	PermDataImport Permission = "data:import"
+	PermDataExport Permission = "data:export"
+
+	PermTrashRead    Permission = "trash:read"
+	PermTrashRestore Permission = "trash:restore"
+	PermTrashPurge   Permission = "trash:purge"
+
+	PermApiKeyManage Permission = "api-key:manage"
+)
+
+// RolePermissions is what each role can do in an organization
+var RolePermissions = map[string][]Permission{
+	RoleOwner: {
+		PermOrganizationRead, PermOrganizationUpdate, PermOrganizationDelete, PermOrganizationTransfer,
+		PermTeamRead, PermTeamManage,
+		PermActivityRead, PermActivityCreate, PermActivityUpdate, PermActivityDelete,
+		PermDataRead, PermDataCreate, PermDataUpdate, PermDataDelete, PermDataImport, PermDataExport,
+		PermTrashRead, PermTrashRestore, PermTrashPurge,
+		PermApiKeyManage,
+	},
+	RoleAdmin: {
+		PermOrganizationRead, PermOrganizationUpdate,
+		PermTeamRead, PermTeamManage,
+		PermActivityRead, PermActivityCreate, PermActivityUpdate, PermActivityDelete,
+		PermDataRead, PermDataCreate, PermDataUpdate, PermDataDelete, PermDataImport, PermDataExport,
+		PermTrashRead, PermTrashRestore, PermTrashPurge,
+		PermApiKeyManage,
+	},
+	RoleEditor: {
+		PermOrganizationRead,
+		PermTeamRead,
+		PermActivityRead, PermActivityCreate, PermActivityUpdate,
+		PermDataRead, PermDataCreate, PermDataUpdate, PermDataDelete, PermDataImport, PermDataExport,
+		PermTrashRead, PermTrashRestore,
+	},
+	RoleDataEntry: {
+		PermOrganizationRead,
+		PermTeamRead,
+		PermActivityRead,
+		PermDataRead, PermDataCreate, PermDataUpdate,
+	},
+	RoleViewer: {
+		PermOrganizationRead,
+		PermTeamRead,
+		PermActivityRead,
+		PermDataRead, PermDataExport,
+	},
+}
+
+// IsRole tells if the role can be given to a member
+func IsRole(role string) bool {
+	_, ok := RolePermissions[role]
+	return ok
+}
+
+// RoleAliases returns the roles stored for the role, the editors include the members from before the roles
+func RoleAliases(role string) []string {
+	if role == RoleEditor {
+		return []string{RoleEditor, roleMember}
+	}
+	return []string{role}
+}
+
+// RoleCan tells if the role has the permission
+func RoleCan(role string, permission Permission) bool {
+	if role == roleMember {
+		role = RoleEditor
+	}
+
+	for _, p := range RolePermissions[role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// Can tells if the member has the permission in the organization
+func (member Member) Can(permission Permission) bool {
+	return RoleCan(member.Role, permission)
+}
@@ -0,0 +1,42 @@
+package models_test
+
+import (
+	"testing"
+
+	"stockinos.com/api/models"
+)
+
+func TestRoleCan(t *testing.T) {
+	tests := []struct {
+		role       string
+		permission models.Permission
+		want       bool
+	}{
+		{models.RoleOwner, models.PermOrganizationDelete, true},
+		{models.RoleAdmin, models.PermOrganizationDelete, false},
+		{models.RoleOwner, models.PermOrganizationTransfer, true},
+		{models.RoleAdmin, models.PermOrganizationTransfer, false},
+		{models.RoleAdmin, models.PermTeamManage, true},
+		{models.RoleEditor, models.PermActivityUpdate, true},
+		{models.RoleEditor, models.PermActivityDelete, false},
+		{models.RoleDataEntry, models.PermDataCreate, true},
+		{models.RoleDataEntry, models.PermDataDelete, false},
+		{models.RoleViewer, models.PermDataRead, true},
+		{models.RoleViewer, models.PermDataUpdate, false},
+		{"member", models.PermDataDelete, true},
+		{"", models.PermOrganizationRead, false},
+		{"unknown", models.PermOrganizationRead, false},
+	}
+
+	for _, test := range tests {
+		if got := models.RoleCan(test.role, test.permission); got != test.want {
+			t.Errorf("RoleCan(%q, %q) = %v; want %v", test.role, test.permission, got, test.want)
+		}
+	}
+
+	for role := range models.RolePermissions {
+		if !models.RoleCan(role, models.PermOrganizationRead) {
+			t.Errorf("the %s role can not read its organization", role)
+		}
+	}
+}
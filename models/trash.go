@@ -0,0 +1,53 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	TrashItemOrganization = "organization"
+	TrashItemActivity     = "activity"
+	TrashItemData         = "data"
+)
+
+// TrashItem is an organization, an activity or a data deleted but not purged yet
+type TrashItem struct {
+	Type string             `json:"type"`
+	Id   primitive.ObjectID `json:"id"`
+	Name string             `json:"name,omitempty"` // of the organization or the activity
+
+	OrganizationId primitive.ObjectID `json:"organization_id,omitempty"` // of the activity
+	ActivityId     primitive.ObjectID `json:"activity_id,omitempty"`     // of the data
+	Values         map[string]any     `json:"values,omitempty"`          // of the data
+	// the data deleted along with the activity
+	DataCount int64 `json:"data_count,omitempty"`
+
+	DeletedAt time.Time   `json:"deleted_at"`
+	DeletedBy *DataAuthor `json:"deleted_by"`
+	// when the item is purged for good
+	PurgeAt time.Time `json:"purge_at"`
+}
+
+// UploadedFiles returns the uploaded files referenced by the values of a data
+func (activity Activity) UploadedFiles(values map[string]any) []string {
+	files := make([]string, 0)
+	for _, field := range activity.Fields {
+		if field.Type != FieldTypeUpload {
+			continue
+		}
+
+		value := values[field.Id.Hex()]
+		items := ListItems(value)
+		if items == nil && value != nil {
+			items = []any{value}
+		}
+		for _, item := range items {
+			if file, ok := item.(string); ok && file != "" {
+				files = append(files, file)
+			}
+		}
+	}
+	return files
+}
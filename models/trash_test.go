@@ -0,0 +1,31 @@
+package models_test
+
+import (
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"stockinos.com/api/models"
+)
+
+func TestActivityUploadedFiles(t *testing.T) {
+	photos := models.ActivityField{Id: primitive.NewObjectID(), Type: models.FieldTypeUpload}
+	invoice := models.ActivityField{Id: primitive.NewObjectID(), Type: models.FieldTypeUpload}
+	note := models.ActivityField{Id: primitive.NewObjectID(), Type: models.FieldTypeText}
+	activity := models.Activity{Fields: []models.ActivityField{photos, invoice, note}}
+
+	files := activity.UploadedFiles(map[string]any{
+		photos.Id.Hex():  primitive.A{"data/1-a.png", "data/2-b.png"},
+		invoice.Id.Hex(): "data/3-invoice.pdf",
+		note.Id.Hex():    "data/not-a-file",
+	})
+
+	want := []string{"data/1-a.png", "data/2-b.png", "data/3-invoice.pdf"}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("UploadedFiles() = %v; want %v", files, want)
+	}
+
+	if files := activity.UploadedFiles(map[string]any{}); len(files) != 0 {
+		t.Errorf("UploadedFiles() = %v; want no file", files)
+	}
+}
@@ -0,0 +1,62 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// How often the keys not hit for a while are removed from the memory
+const memorySweepInterval = time.Minute
+
+type memoryEntry struct {
+	hits      []time.Time
+	expiresAt time.Time
+}
+
+// MemoryStore keeps the hits in the memory of the process, the limits are not shared between the nodes
+type MemoryStore struct {
+	mu        sync.Mutex
+	entries   map[string]*memoryEntry
+	lastSweep time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		entries: make(map[string]*memoryEntry),
+	}
+}
+
+func (s *MemoryStore) Hit(ctx context.Context, key string, rule Rule, now time.Time) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastSweep) >= memorySweepInterval {
+		s.sweep(now)
+	}
+
+	var hits []time.Time
+	if entry, ok := s.entries[key]; ok {
+		hits = entry.hits
+	}
+
+	hits, result := decide(hits, rule, now)
+	if len(hits) == 0 {
+		delete(s.entries, key)
+	} else {
+		s.entries[key] = &memoryEntry{
+			hits:      hits,
+			expiresAt: hits[len(hits)-1].Add(rule.retention()),
+		}
+	}
+	return result, nil
+}
+
+func (s *MemoryStore) sweep(now time.Time) {
+	for key, entry := range s.entries {
+		if !now.Before(entry.expiresAt) {
+			delete(s.entries, key)
+		}
+	}
+	s.lastSweep = now
+}
@@ -0,0 +1,180 @@
+package ratelimit
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+)
+
+// Largest body read to find a key, the handlers refuse larger bodies anyway
+const maxBodyRead = 1048576
+
+// KeyFunc returns what the requests are counted by, the request is not limited when it is empty
+type KeyFunc func(r *http.Request) string
+
+// Policy limits the requests sharing the same key
+type Policy struct {
+	// Prefix of the keys, the policies with the same name share their hits
+	Name string
+	Key  KeyFunc
+	Rule Rule
+}
+
+type Limiter struct {
+	store Store
+	log   *zap.Logger
+	now   func() time.Time
+}
+
+func New(store Store, log *zap.Logger) *Limiter {
+	if log == nil {
+		log = zap.NewNop()
+	}
+
+	return &Limiter{
+		store: store,
+		log:   log,
+		now:   time.Now,
+	}
+}
+
+// Limit returns a middleware refusing the requests over any of the policies with a 429 status
+// and a Retry-After header. The policies are checked in order and the first one refusing
+// the request stops the others, so a refused request is not counted by the next ones.
+// The requests are let through when the store fails, the service must not depend on it.
+func (l *Limiter) Limit(policies ...Policy) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			now := l.now()
+
+			for _, policy := range policies {
+				if policy.Rule.isZero() {
+					continue
+				}
+				key := policy.Key(r)
+				if key == "" {
+					continue
+				}
+
+				result, err := l.store.Hit(r.Context(), policy.Name+":"+key, policy.Rule, now)
+				if err != nil {
+					l.log.Error("Failed to check the rate limit", zap.String("policy", policy.Name), zap.Error(err))
+					continue
+				}
+				if !result.Allowed {
+					l.log.Info("Rate limited", zap.String("policy", policy.Name), zap.String("path", r.URL.Path))
+					w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(result.RetryAfter)))
+					http.Error(w, "ERR_RATE_LIMITED", http.StatusTooManyRequests)
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// retryAfterSeconds rounds up, a client retrying on time must not be refused again
+func retryAfterSeconds(retryAfter time.Duration) int {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		return 1
+	}
+	return seconds
+}
+
+// ClientIP keys the requests by the IP of the client. Behind trusted proxies, each one appends
+// the address it received the request from to X-Forwarded-For, so the client is the entry added
+// by the outermost one, counted from the right: the entries on its left are sent by the client
+// and can not be trusted. Without any proxy, only the remote address is used.
+func ClientIP(trustedProxies int) KeyFunc {
+	return func(r *http.Request) string {
+		if trustedProxies > 0 {
+			if forwarded := forwardedFor(r); len(forwarded) > 0 {
+				if len(forwarded) < trustedProxies {
+					// the request went through fewer proxies than configured
+					return forwarded[0]
+				}
+				return forwarded[len(forwarded)-trustedProxies]
+			}
+			if realIP := r.Header.Get("X-Real-IP"); realIP != "" {
+				return strings.TrimSpace(realIP)
+			}
+		}
+
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			return r.RemoteAddr
+		}
+		return host
+	}
+}
+
+// forwardedFor returns the addresses of all the X-Forwarded-For headers, in order
+func forwardedFor(r *http.Request) []string {
+	addresses := make([]string, 0)
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		for _, address := range strings.Split(header, ",") {
+			if address = strings.TrimSpace(address); address != "" {
+				addresses = append(addresses, address)
+			}
+		}
+	}
+	return addresses
+}
+
+// URLParam keys the requests by a parameter of the route
+func URLParam(name string) KeyFunc {
+	return func(r *http.Request) string {
+		return chi.URLParam(r, name)
+	}
+}
+
+// JSONField keys the requests by a string field of their JSON body.
+// The body is read again by the handler.
+func JSONField(name string) KeyFunc {
+	return func(r *http.Request) string {
+		if r.Body == nil {
+			return ""
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxBodyRead))
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+		if err != nil {
+			return ""
+		}
+
+		var fields map[string]any
+		if err := json.Unmarshal(body, &fields); err != nil {
+			return ""
+		}
+		value, _ := fields[name].(string)
+		return strings.TrimSpace(value)
+	}
+}
+
+// PhoneNumberField keys the requests by a phone number of their JSON body, only its digits are kept
+// so the same number written differently shares the same hits
+func PhoneNumberField(name string) KeyFunc {
+	field := JSONField(name)
+	return func(r *http.Request) string {
+		return strings.Map(func(c rune) rune {
+			if c >= '0' && c <= '9' {
+				return c
+			}
+			return -1
+		}, field(r))
+	}
+}
@@ -0,0 +1,79 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// How many times a hit is tried again when the key is hit by another node at the same time
+const mongoMaxRetries = 5
+
+var ErrContention = errors.New("ratelimit: too many concurrent hits on the same key")
+
+type mongoEntry struct {
+	Key     string      `bson:"_id"`
+	Hits    []time.Time `bson:"hits"`
+	Version int64       `bson:"version"`
+	// removed by a TTL index once the hits do not matter anymore
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+// MongoStore keeps the hits in a collection, one document per key, so the limits are shared between the nodes.
+// The documents are updated only if they did not change since they were read.
+type MongoStore struct {
+	collection *mongo.Collection
+}
+
+func NewMongoStore(collection *mongo.Collection) *MongoStore {
+	return &MongoStore{collection: collection}
+}
+
+func (s *MongoStore) Hit(ctx context.Context, key string, rule Rule, now time.Time) (Result, error) {
+	for i := 0; i < mongoMaxRetries; i++ {
+		var entry mongoEntry
+		err := s.collection.FindOne(ctx, bson.M{"_id": key}).Decode(&entry)
+		exists := err == nil
+		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			return Result{}, err
+		}
+
+		hits, result := decide(entry.Hits, rule, now)
+		if !result.Allowed {
+			return result, nil
+		}
+		expiresAt := now.Add(rule.retention())
+
+		if !exists {
+			_, err = s.collection.InsertOne(ctx, mongoEntry{
+				Key:       key,
+				Hits:      hits,
+				Version:   1,
+				ExpiresAt: expiresAt,
+			})
+			if mongo.IsDuplicateKeyError(err) {
+				continue
+			}
+			return result, err
+		}
+
+		updated, err := s.collection.UpdateOne(ctx, bson.M{
+			"_id":     key,
+			"version": entry.Version,
+		}, bson.M{
+			"$set": bson.M{"hits": hits, "expires_at": expiresAt},
+			"$inc": bson.M{"version": 1},
+		})
+		if err != nil {
+			return Result{}, err
+		}
+		if updated.MatchedCount == 1 {
+			return result, nil
+		}
+	}
+
+	return Result{}, ErrContention
+}
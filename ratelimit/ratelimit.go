@@ -0,0 +1,79 @@
+// Package ratelimit limits how often a request can be made, by phone number, IP or any other key.
+// The hits are counted over sliding windows, in memory for a single node or in Mongo or Redis
+// when the limits are shared by several nodes.
+package ratelimit
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// Rule is how often a key can be hit
+type Rule struct {
+	// Number of hits allowed within the window, no limit when 0
+	Limit  int
+	Window time.Duration
+	// Minimum time between two hits, none when 0
+	Cooldown time.Duration
+}
+
+// retention is how long the hits have to be kept to apply the rule
+func (rule Rule) retention() time.Duration {
+	if rule.Window > rule.Cooldown {
+		return rule.Window
+	}
+	return rule.Cooldown
+}
+
+func (rule Rule) isZero() bool {
+	return (rule.Limit <= 0 || rule.Window <= 0) && rule.Cooldown <= 0
+}
+
+type Result struct {
+	Allowed bool
+	// How long to wait before the key can be hit again, when not allowed
+	RetryAfter time.Duration
+}
+
+// Store counts the hits of the keys. A hit is only counted when it is allowed,
+// so the clients waiting as told by RetryAfter are not blocked for longer.
+type Store interface {
+	Hit(ctx context.Context, key string, rule Rule, now time.Time) (Result, error)
+}
+
+// decide applies the rule to the previous hits of a key, it returns the hits to keep
+// including the new one when it is allowed
+func decide(hits []time.Time, rule Rule, now time.Time) ([]time.Time, Result) {
+	if rule.isZero() {
+		return hits, Result{Allowed: true}
+	}
+
+	sort.Slice(hits, func(i, j int) bool { return hits[i].Before(hits[j]) })
+
+	retention := rule.retention()
+	kept := make([]time.Time, 0, len(hits)+1)
+	for _, hit := range hits {
+		if now.Sub(hit) < retention {
+			kept = append(kept, hit)
+		}
+	}
+
+	if rule.Cooldown > 0 && len(kept) > 0 {
+		last := kept[len(kept)-1]
+		if wait := last.Add(rule.Cooldown).Sub(now); wait > 0 {
+			return kept, Result{RetryAfter: wait}
+		}
+	}
+
+	if rule.Limit > 0 && rule.Window > 0 {
+		inWindow := kept[sort.Search(len(kept), func(i int) bool { return now.Sub(kept[i]) < rule.Window }):]
+		if len(inWindow) >= rule.Limit {
+			// the key can be hit again once enough hits are out of the window
+			oldest := inWindow[len(inWindow)-rule.Limit]
+			return kept, Result{RetryAfter: oldest.Add(rule.Window).Sub(now)}
+		}
+	}
+
+	return append(kept, now), Result{Allowed: true}
+}
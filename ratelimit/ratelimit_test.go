@@ -0,0 +1,139 @@
+package ratelimit_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"stockinos.com/api/ratelimit"
+)
+
+func hit(t *testing.T, store ratelimit.Store, rule ratelimit.Rule, at time.Time) ratelimit.Result {
+	t.Helper()
+	result, err := store.Hit(context.Background(), "key", rule, at)
+	if err != nil {
+		t.Fatalf("Hit() error = %v", err)
+	}
+	return result
+}
+
+func TestMemoryStoreSlidingWindow(t *testing.T) {
+	store := ratelimit.NewMemoryStore()
+	rule := ratelimit.Rule{Limit: 3, Window: time.Minute}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 3; i++ {
+		if result := hit(t, store, rule, start.Add(time.Duration(i)*10*time.Second)); !result.Allowed {
+			t.Fatalf("hit %d refused", i)
+		}
+	}
+
+	result := hit(t, store, rule, start.Add(30*time.Second))
+	if result.Allowed {
+		t.Fatal("hit over the limit allowed")
+	}
+	if result.RetryAfter != 30*time.Second {
+		t.Errorf("RetryAfter = %v; want 30s, when the first hit leaves the window", result.RetryAfter)
+	}
+
+	// the refused hit is not counted
+	if result := hit(t, store, rule, start.Add(time.Minute)); !result.Allowed {
+		t.Error("hit refused once the first hit left the window")
+	}
+	if result := hit(t, store, rule, start.Add(time.Minute+time.Second)); result.Allowed {
+		t.Error("hit allowed while the window is full again")
+	}
+}
+
+func TestMemoryStoreCooldown(t *testing.T) {
+	store := ratelimit.NewMemoryStore()
+	rule := ratelimit.Rule{Limit: 10, Window: time.Hour, Cooldown: time.Minute}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	if result := hit(t, store, rule, start); !result.Allowed {
+		t.Fatal("first hit refused")
+	}
+
+	result := hit(t, store, rule, start.Add(20*time.Second))
+	if result.Allowed || result.RetryAfter != 40*time.Second {
+		t.Errorf("hit during the cooldown = %+v; want refused for 40s", result)
+	}
+
+	if result := hit(t, store, rule, start.Add(time.Minute)); !result.Allowed {
+		t.Error("hit refused after the cooldown")
+	}
+}
+
+func TestLimit(t *testing.T) {
+	limiter := ratelimit.New(ratelimit.NewMemoryStore(), nil)
+	middleware := limiter.Limit(ratelimit.Policy{
+		Name: "otp-phone",
+		Key:  ratelimit.PhoneNumberField("phone_number"),
+		Rule: ratelimit.Rule{Limit: 1, Window: time.Hour},
+	})
+
+	var bodies []string
+	handler := middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+	}))
+
+	send := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/auth/otp", strings.NewReader(body)))
+		return w
+	}
+
+	first := `{"phone_number": "+33 6 12 34 56 78"}`
+	if w := send(first); w.Code != http.StatusOK {
+		t.Fatalf("first request status = %d", w.Code)
+	}
+	if len(bodies) != 1 || bodies[0] != first {
+		t.Errorf("the handler read %q; want the whole body", bodies)
+	}
+
+	w := send(`{"phone_number": "+33612345678"}`)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("same phone number written differently, status = %d; want 429", w.Code)
+	}
+	if got := w.Header().Get("Retry-After"); got != "3600" {
+		t.Errorf("Retry-After = %q; want 3600", got)
+	}
+
+	if w := send(`{"phone_number": "+33700000000"}`); w.Code != http.StatusOK {
+		t.Errorf("other phone number, status = %d", w.Code)
+	}
+	// no key, not limited: the handler refuses the body
+	if w := send(`not json`); w.Code != http.StatusOK {
+		t.Errorf("request without phone number, status = %d", w.Code)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/auth/otp", nil)
+	r.RemoteAddr = "10.0.0.2:1234"
+	// the client sent a fake address, the load balancer and then the reverse proxy appended theirs
+	r.Header.Add("X-Forwarded-For", "198.51.100.1, 203.0.113.7")
+	r.Header.Add("X-Forwarded-For", "10.0.0.1")
+
+	tests := map[string]struct {
+		trustedProxies int
+		want           string
+	}{
+		"no proxy":                  {trustedProxies: 0, want: "10.0.0.2"},
+		"one proxy":                 {trustedProxies: 1, want: "10.0.0.1"},
+		"two proxies":               {trustedProxies: 2, want: "203.0.113.7"},
+		"more proxies than entries": {trustedProxies: 5, want: "198.51.100.1"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := ratelimit.ClientIP(tt.trustedProxies)(r); got != tt.want {
+				t.Errorf("ClientIP(%d) = %q; want %q", tt.trustedProxies, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+	"stockinos.com/api/storage"
+)
+
+// How long a migration of data stays without progress before being resumed
+const dataMigrationResumeDelay = 5 * time.Minute
+
+// resumeDataMigrationsPeriodically resumes the migrations of data stopped before their end until the context is done
+func (s *Server) resumeDataMigrationsPeriodically(ctx context.Context) {
+	ticker := time.NewTicker(dataMigrationResumeDelay)
+	defer ticker.Stop()
+
+	for {
+		s.resumeDataMigrations(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// resumeDataMigrations migrates the remaining data of the activities whose migration made
+// no progress for a while, after a failed batch or a restart of the server, and refreshes
+// the remaining unique keys of the data the same way
+func (s *Server) resumeDataMigrations(ctx context.Context) {
+	db := s.database.Storage
+	if db == nil {
+		return
+	}
+
+	updatedBefore := time.Now().Add(-dataMigrationResumeDelay)
+	activities, err := db.GetActivitiesWithPendingMigration(ctx, storage.GetActivitiesWithPendingMigrationParams{
+		UpdatedBefore: updatedBefore,
+	})
+	if err != nil {
+		s.log.Error("Failed to get the activities with a pending migration", zap.Error(err))
+		return
+	}
+
+	for _, activity := range activities {
+		if ctx.Err() != nil {
+			return
+		}
+
+		// the migration can still be running when only the refresh of the keys stopped
+		if activity.Migration != nil && activity.Migration.UpdatedAt.Before(updatedBefore) {
+			if err := db.MigrateDataTx(ctx, storage.MigrateDataTxParams{Activity: *activity}); err != nil {
+				s.log.Error("Failed to migrate the data of an activity", zap.String("id", activity.Id.Hex()), zap.Error(err))
+				continue
+			}
+			s.log.Info("Resumed the migration of the data of an activity", zap.String("id", activity.Id.Hex()))
+		}
+
+		if activity.UniqueKeysRefresh != nil && activity.UniqueKeysRefresh.UpdatedAt.Before(updatedBefore) {
+			if err := db.RefreshDataUniqueKeysTx(ctx, storage.RefreshDataUniqueKeysTxParams{Activity: *activity}); err != nil {
+				s.log.Error("Failed to refresh the unique keys of the data of an activity", zap.String("id", activity.Id.Hex()), zap.Error(err))
+				continue
+			}
+			s.log.Info("Resumed the refresh of the unique keys of the data of an activity", zap.String("id", activity.Id.Hex()))
+		}
+	}
+}
+
+// backfillDataUniqueKeys computes the unique keys of the data created before them, once when the server starts.
+// The activities whose data already break their constraints are left as they are, to be fixed by hand.
+func (s *Server) backfillDataUniqueKeys(ctx context.Context) {
+	db := s.database.Storage
+	if db == nil {
+		return
+	}
+
+	activities, err := db.GetActivitiesWithLegacyUniqueKeys(ctx)
+	if err != nil {
+		s.log.Error("Failed to get the activities with data without unique keys", zap.Error(err))
+		return
+	}
+
+	for _, activity := range activities {
+		if ctx.Err() != nil {
+			return
+		}
+
+		started, err := db.StartDataUniqueKeysRefreshTx(ctx, storage.StartDataUniqueKeysRefreshTxParams{Activity: *activity})
+		if errors.Is(err, storage.ErrDataNotUnique) {
+			s.log.Warn("The data of an activity break its unique constraints, their keys are not computed", zap.String("id", activity.Id.Hex()))
+			continue
+		}
+		if err != nil {
+			s.log.Error("Failed to start the refresh of the unique keys of the data of an activity", zap.String("id", activity.Id.Hex()), zap.Error(err))
+			continue
+		}
+
+		// a refresh which fails is resumed by resumeDataMigrations
+		if err := db.RefreshDataUniqueKeysTx(ctx, storage.RefreshDataUniqueKeysTxParams{Activity: *started}); err != nil {
+			s.log.Error("Failed to refresh the unique keys of the data of an activity", zap.String("id", activity.Id.Hex()), zap.Error(err))
+			continue
+		}
+		s.log.Info("Computed the unique keys of the data of an activity", zap.String("id", activity.Id.Hex()))
+	}
+}
@@ -28,6 +28,11 @@ func (srw *statusResponseWriter) WriteHeader(statusCode int) {
 	srw.ResponseWriter.WriteHeader(statusCode)
 }
 
+// Unwrap gives access to the original ResponseWriter, for http.ResponseController
+func (srw *statusResponseWriter) Unwrap() http.ResponseWriter {
+	return srw.ResponseWriter
+}
+
 func (s *Server) requestLoggerMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
@@ -53,6 +58,13 @@ func (s *Server) convertJWTTokenToMember(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		ctx := req.Context()
 
+		// the machine clients act as their API key
+		if apiKey := services.ApiKeyFromContext(ctx); apiKey != nil {
+			ctx = context.WithValue(ctx, services.JwtUserKey, apiKey.AsUser())
+			next.ServeHTTP(w, req.WithContext(ctx))
+			return
+		}
+
 		claims := ctx.Value(services.JwtClaimsKey)
 		log.Println("convert jwt token to member : ", claims)
 		if claims == nil {
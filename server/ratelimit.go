@@ -0,0 +1,57 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"stockinos.com/api/ratelimit"
+)
+
+// The rate limits of the public routes. Each OTP sent costs a message,
+// so the phone numbers get a cooldown between two codes.
+var (
+	otpPerPhoneRule   = ratelimit.Rule{Limit: 5, Window: time.Hour, Cooldown: time.Minute}
+	otpPerIPRule      = ratelimit.Rule{Limit: 20, Window: time.Hour}
+	otpCheckPhoneRule = ratelimit.Rule{Limit: 10, Window: 15 * time.Minute}
+	otpCheckIPRule    = ratelimit.Rule{Limit: 50, Window: 15 * time.Minute}
+	// the invitation links are shared with a whole team
+	joinPerTokenRule = ratelimit.Rule{Limit: 50, Window: time.Hour}
+	// also covers the guesses of invitation tokens
+	joinPerIPRule = ratelimit.Rule{Limit: 30, Window: time.Hour}
+)
+
+func (s *Server) clientIP() ratelimit.KeyFunc {
+	return ratelimit.ClientIP(s.trustedProxies)
+}
+
+// limitOTPRequests limits the codes sent by POST /auth/otp
+func (s *Server) limitOTPRequests() func(http.Handler) http.Handler {
+	return s.limiter.Limit(
+		ratelimit.Policy{Name: "otp-ip", Key: s.clientIP(), Rule: otpPerIPRule},
+		ratelimit.Policy{Name: "otp-phone", Key: ratelimit.PhoneNumberField("phone_number"), Rule: otpPerPhoneRule},
+	)
+}
+
+// limitOTPChecks limits the guesses of the codes by POST /auth/otp-check
+func (s *Server) limitOTPChecks() func(http.Handler) http.Handler {
+	return s.limiter.Limit(
+		ratelimit.Policy{Name: "otp-check-ip", Key: s.clientIP(), Rule: otpCheckIPRule},
+		ratelimit.Policy{Name: "otp-check-phone", Key: ratelimit.PhoneNumberField("phone_number"), Rule: otpCheckPhoneRule},
+	)
+}
+
+// limitJoinRoutes limits all the requests on an invitation link by IP, before the token is looked up
+func (s *Server) limitJoinRoutes() func(http.Handler) http.Handler {
+	return s.limiter.Limit(
+		ratelimit.Policy{Name: "join-ip", Key: s.clientIP(), Rule: joinPerIPRule},
+	)
+}
+
+// limitJoins limits the joins by POST /join/{invitationToken}, which sends a code as /auth/otp
+func (s *Server) limitJoins() func(http.Handler) http.Handler {
+	return s.limiter.Limit(
+		ratelimit.Policy{Name: "join-token", Key: ratelimit.URLParam("invitationToken"), Rule: joinPerTokenRule},
+		// same name as for /auth/otp, a phone number gets the codes from both at the same pace
+		ratelimit.Policy{Name: "otp-phone", Key: ratelimit.PhoneNumberField("phone_number"), Rule: otpPerPhoneRule},
+	)
+}
@@ -5,6 +5,7 @@ import (
 	"github.com/go-chi/cors"
 	"stockinos.com/api/broker/publishers"
 	"stockinos.com/api/handlers"
+	"stockinos.com/api/models"
 	"stockinos.com/api/services"
 	"stockinos.com/api/storage"
 )
@@ -16,6 +17,11 @@ type facebookWebhookStruct struct {
 
 func (s *Server) setupRoutes() {
 	appHandler := handlers.NewAppHandler()
+	appHandler.ClientIP = s.clientIP()
+	// The permission needed on a route of an organization, see models.RolePermissions
+	can := appHandler.RequirePermission
+	// The sessions signed out, their access tokens are refused
+	revocations := services.NewSessionRevocations(s.database.Storage)
 
 	s.mux.Use(s.requestLoggerMiddleware)
 	s.mux.Use(cors.Handler(cors.Options{
@@ -28,7 +34,8 @@ func (s *Server) setupRoutes() {
 	}))
 
 	s.mux.Use(services.Verifier)
-	s.mux.Use(services.ParseJwtToken)
+	s.mux.Use(services.ParseJwtToken(revocations))
+	s.mux.Use(services.AuthenticateApiKey(s.database.Storage))
 
 	// Protected Routes
 	s.mux.Group(func(r chi.Router) {
@@ -36,57 +43,109 @@ func (s *Server) setupRoutes() {
 		r.Use(s.convertJWTTokenToMember)
 
 		r.Route("/user", func(r chi.Router) {
+			r.Use(appHandler.RejectApiKeys)
+
 			handlers.GetCurrentUser(r)
+			appHandler.SwitchCurrentOrganization(r, s.database.Storage)
 
 			r.Route("/onboarding", func(r chi.Router) {
 				appHandler.UpdateProfile(r, s.database.Storage)
 				appHandler.SetUpOrganization(r, s.database.Storage)
 			})
+
+			r.Route("/sessions", func(r chi.Router) {
+				appHandler.GetSessions(r, s.database.Storage)
+				appHandler.RevokeSession(r, s.database.Storage, revocations)
+				appHandler.RevokeOtherSessions(r, s.database.Storage, revocations)
+			})
 		})
 
 		r.Route("/organizations", func(r chi.Router) {
-			appHandler.GetAllCompanies(r, s.database.Storage)
-			appHandler.CreateOrganization(r, s.database.Storage)
+			appHandler.GetAllCompanies(r.With(appHandler.RejectApiKeys), s.database.Storage)
+			appHandler.CreateOrganization(r.With(appHandler.RejectApiKeys), s.database.Storage)
+
+			r.Route("/trash", func(r chi.Router) {
+				r.Use(appHandler.RejectApiKeys)
+
+				appHandler.GetDeletedOrganizations(r, s.database.Storage, s.trashRetention)
+				appHandler.RestoreOrganization(r, s.database.Storage)
+				appHandler.PurgeOrganization(r, s.database.Storage, s.s3)
+			})
 
 			r.Route("/{organizationId}", func(r chi.Router) {
 				appHandler.OrganizationMiddleware(r, s.database.Storage)
 
-				appHandler.GetOrganization(r, s.database.Storage)
-				appHandler.UpdateOrganization(r, s.database.Storage)
-				appHandler.DeleteOrganization(r, s.database.Storage)
+				appHandler.GetOrganization(r.With(can(models.PermOrganizationRead)), s.database.Storage)
+				appHandler.UpdateOrganization(r.With(can(models.PermOrganizationUpdate)), s.database.Storage)
+				appHandler.DeleteOrganization(r.With(can(models.PermOrganizationDelete)), s.database.Storage)
 
 				r.Route("/activities", func(r chi.Router) {
-					appHandler.GetAllActivities(r, s.database.Storage)
-					appHandler.CreateActivity(r, s.database.Storage)
+					appHandler.GetAllActivities(r.With(can(models.PermActivityRead)), s.database.Storage)
+					appHandler.CreateActivity(r.With(can(models.PermActivityCreate)), s.database.Storage)
 
 					r.Route("/{activityId}", func(r chi.Router) {
 						appHandler.ActivityMiddleware(r, s.database.Storage)
 
-						appHandler.GetActivity(r, s.database.Storage)
-						appHandler.DeleteActivity(r, s.database.Storage)
-						appHandler.UpdateActivity(r, s.database.Storage)
+						appHandler.GetActivity(r.With(can(models.PermActivityRead)), s.database.Storage)
+						appHandler.DeleteActivity(r.With(can(models.PermActivityDelete)), s.database.Storage)
+						appHandler.UpdateActivity(r.With(can(models.PermActivityUpdate)), s.database.Storage)
+						appHandler.PreviewActivityUpdate(r.With(can(models.PermActivityUpdate)), s.database.Storage)
 
 						r.Route("/data", func(r chi.Router) {
-							appHandler.CreateData(r, s.database.Storage)
-							appHandler.GetAllData(r, s.database.Storage)
+							appHandler.CreateData(r.With(can(models.PermDataCreate)), s.database.Storage)
+							appHandler.GetAllData(r.With(can(models.PermDataRead)), s.database.Storage)
+							appHandler.ExportData(r.With(can(models.PermDataExport)), s.database.Storage)
+							appHandler.ImportData(r.With(can(models.PermDataImport)), s.database.Storage)
 
 							r.Route("/{dataId}", func(r chi.Router) {
 								appHandler.DataMiddleware(r, s.database.Storage)
 
-								appHandler.GetData(r, s.database.Storage)
-								appHandler.UpdateData(r, s.database.Storage)
-								appHandler.DeleteData(r, s.database.Storage, s.s3)
-								appHandler.GetUploadedFiles(r, s.database.Storage)
+								appHandler.GetData(r.With(can(models.PermDataRead)), s.database.Storage)
+								appHandler.UpdateData(r.With(can(models.PermDataUpdate)), s.database.Storage)
+								appHandler.PatchData(r.With(can(models.PermDataUpdate)), s.database.Storage)
+								appHandler.GetDataHistory(r.With(can(models.PermDataRead)), s.database.Storage)
+								appHandler.RestoreDataVersion(r.With(can(models.PermDataUpdate)), s.database.Storage)
+								appHandler.DeleteData(r.With(can(models.PermDataDelete)), s.database.Storage, s.s3)
+								appHandler.GetUploadedFiles(r.With(can(models.PermDataRead)), s.database.Storage)
 							})
 
-							appHandler.UploadFiles(r, s.database.Storage, s.s3)
-							appHandler.DeleteUploadedFile(r, s.database.Storage, s.s3)
+							appHandler.UploadFiles(r.With(can(models.PermDataCreate)), s.database.Storage, s.s3)
+							appHandler.DeleteUploadedFile(r.With(can(models.PermDataUpdate)), s.database.Storage, s.s3)
 						})
 					})
 				})
 
 				r.Route("/team", func(r chi.Router) {
-					appHandler.GetTeam(r, s.database.Storage)
+					appHandler.GetTeam(r.With(can(models.PermTeamRead)), s.database.Storage)
+					appHandler.RemoveMember(r.With(can(models.PermTeamManage)), s.database.Storage)
+					appHandler.UpdateMemberRole(r.With(can(models.PermTeamManage)), s.database.Storage)
+					appHandler.ApproveJoinRequest(r.With(can(models.PermTeamManage)), s.database.Storage, s.invitationSender)
+					appHandler.RejectJoinRequest(r.With(can(models.PermTeamManage)), s.database.Storage, s.invitationSender)
+					appHandler.LeaveOrganization(r.With(appHandler.RejectApiKeys), s.database.Storage)
+					appHandler.TransferOwnership(r.With(can(models.PermOrganizationTransfer)), s.database.Storage)
+
+					r.Route("/invitations", func(r chi.Router) {
+						r.Use(can(models.PermTeamManage))
+
+						appHandler.GetInvitations(r, s.database.Storage)
+						appHandler.CreateInvitation(r, s.database.Storage, s.invitationSender)
+						appHandler.RevokeInvitation(r, s.database.Storage)
+						appHandler.ResendInvitation(r, s.database.Storage, s.invitationSender)
+					})
+				})
+
+				r.Route("/api-keys", func(r chi.Router) {
+					appHandler.GetApiKeys(r.With(can(models.PermApiKeyManage)), s.database.Storage)
+					appHandler.CreateApiKey(r.With(can(models.PermApiKeyManage)), s.database.Storage)
+					appHandler.RevokeApiKey(r.With(can(models.PermApiKeyManage)), s.database.Storage)
+				})
+
+				r.Route("/trash", func(r chi.Router) {
+					appHandler.GetTrash(r.With(can(models.PermTrashRead)), s.database.Storage, s.trashRetention)
+					appHandler.RestoreActivity(r.With(can(models.PermTrashRestore)), s.database.Storage)
+					appHandler.PurgeActivity(r.With(can(models.PermTrashPurge)), s.database.Storage, s.s3)
+					appHandler.RestoreData(r.With(can(models.PermTrashRestore)), s.database.Storage)
+					appHandler.PurgeData(r.With(can(models.PermTrashPurge)), s.database.Storage, s.s3)
 				})
 			})
 		})
@@ -101,19 +160,22 @@ func (s *Server) setupRoutes() {
 		r.Group(func(r chi.Router) {
 			// Auth
 			r.Route("/auth", func(r chi.Router) {
-				appHandler.CreateOTP(r, s.database.Storage)
-				appHandler.CheckOTP(r, s.database.Storage)
+				appHandler.CreateOTP(r.With(s.limitOTPRequests()), s.database.Storage, s.otpSender)
+				appHandler.CheckOTP(r.With(s.limitOTPChecks()), s.database.Storage)
+				appHandler.RefreshToken(r, s.database.Storage, revocations)
+				appHandler.Logout(r, s.database.Storage, revocations)
 				appHandler.UpdateProfile(r, s.database.Storage)
 				appHandler.SetUpOrganization(r, s.database.Storage)
 				// handlers.ResendOTP(r, s.database)
 			})
 
 			r.Route("/join/{invitationToken}", func(r chi.Router) {
+				r.Use(s.limitJoinRoutes())
 				appHandler.InvitationMiddleware(r, s.database.Storage)
 
 				appHandler.GetOrganizationFromInvitationToken(r, s.database.Storage)
 
-				appHandler.AddMember(r, s.database.Storage)
+				appHandler.AddMember(r.With(s.limitJoins()), s.database.Storage, s.otpSender)
 				// handlers.ResendOTP(r, s.database)
 			})
 		})
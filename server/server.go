@@ -14,6 +14,8 @@ import (
 	"go.uber.org/zap"
 	awss3 "stockinos.com/api/aws_s3"
 	"stockinos.com/api/broker"
+	"stockinos.com/api/ratelimit"
+	"stockinos.com/api/services"
 	"stockinos.com/api/storage"
 	"stockinos.com/api/utils"
 )
@@ -26,6 +28,16 @@ type Server struct {
 	log    *zap.Logger
 	mux    chi.Router
 	server *http.Server
+
+	trashRetention     time.Duration
+	trashPurgeInterval time.Duration
+	stopJobs           context.CancelFunc
+
+	limiter        *ratelimit.Limiter
+	trustedProxies int
+
+	otpSender        *services.OTPDispatcher
+	invitationSender services.InvitationSender
 }
 
 type Options struct {
@@ -35,12 +47,40 @@ type Options struct {
 	Host string
 	Log  *zap.Logger
 	Port int
+	// How long the deleted organizations, activities and data stay in the trash
+	TrashRetention time.Duration
+	// How often the trash is checked for the items to purge
+	TrashPurgeInterval time.Duration
+	// Where the hits of the rate limits are counted, in memory when nil
+	RateLimitStore ratelimit.Store
+	// How many proxies in front of the server append the IP of the clients to X-Forwarded-For,
+	// the headers are ignored when 0
+	TrustedProxies int
+	// How the one time passwords are sent, on the channels of OTP_CHANNELS when nil
+	OTPSender *services.OTPDispatcher
+	// How the invitations are sent to the phone numbers, on INVITATION_CHANNEL when nil
+	InvitationSender services.InvitationSender
 }
 
 func New(opts Options) *Server {
 	if opts.Log == nil {
 		opts.Log = zap.NewNop()
 	}
+	if opts.TrashRetention <= 0 {
+		opts.TrashRetention = 30 * 24 * time.Hour
+	}
+	if opts.TrashPurgeInterval <= 0 {
+		opts.TrashPurgeInterval = time.Hour
+	}
+	if opts.RateLimitStore == nil {
+		opts.RateLimitStore = ratelimit.NewMemoryStore()
+	}
+	if opts.OTPSender == nil {
+		opts.OTPSender = services.NewOTPDispatcherFromEnv()
+	}
+	if opts.InvitationSender == nil {
+		opts.InvitationSender = services.NewInvitationSenderFromEnv()
+	}
 
 	address := net.JoinHostPort(opts.Host, strconv.Itoa(opts.Port))
 	mux := chi.NewMux()
@@ -60,6 +100,12 @@ func New(opts Options) *Server {
 			WriteTimeout:      5 * time.Second,
 			IdleTimeout:       5 * time.Second,
 		},
+		trashRetention:     opts.TrashRetention,
+		trashPurgeInterval: opts.TrashPurgeInterval,
+		limiter:            ratelimit.New(opts.RateLimitStore, opts.Log),
+		trustedProxies:     opts.TrustedProxies,
+		otpSender:          opts.OTPSender,
+		invitationSender:   opts.InvitationSender,
 	}
 }
 
@@ -94,6 +140,12 @@ func (s *Server) Start() error {
 
 	s.setupRoutes()
 
+	var jobsCtx context.Context
+	jobsCtx, s.stopJobs = context.WithCancel(context.Background())
+	go s.purgeTrashPeriodically(jobsCtx)
+	go s.resumeDataMigrationsPeriodically(jobsCtx)
+	go s.backfillDataUniqueKeys(jobsCtx)
+
 	// subscribers.NewMessageWoZSentSubscriber(*s.nats).Subscribe(*s.database)
 
 	s.log.Info("Starting on", zap.String("address", s.address))
@@ -107,6 +159,10 @@ func (s *Server) Start() error {
 func (s *Server) Stop() error {
 	s.log.Info("Stopping")
 
+	if s.stopJobs != nil {
+		s.stopJobs()
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
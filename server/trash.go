@@ -0,0 +1,72 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+	"stockinos.com/api/handlers"
+	"stockinos.com/api/models"
+	"stockinos.com/api/storage"
+)
+
+// purgeTrashPeriodically purges the expired items of the trash until the context is done
+func (s *Server) purgeTrashPeriodically(ctx context.Context) {
+	ticker := time.NewTicker(s.trashPurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		s.purgeExpiredTrash(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// purgeExpiredTrash deletes for good the organizations, activities and data
+// in the trash for longer than the retention period
+func (s *Server) purgeExpiredTrash(ctx context.Context) {
+	db := s.database.Storage
+	if db == nil {
+		return
+	}
+
+	items, err := db.GetExpiredTrash(ctx, storage.GetExpiredTrashParams{
+		DeletedBefore: time.Now().Add(-s.trashRetention),
+	})
+	if err != nil {
+		s.log.Error("Failed to get the expired items of the trash", zap.Error(err))
+		return
+	}
+
+	for _, item := range items {
+		if ctx.Err() != nil {
+			return
+		}
+
+		var files []string
+		switch item.Type {
+		case models.TrashItemOrganization:
+			files, err = db.PurgeOrganizationTx(ctx, storage.PurgeOrganizationTxParams{Id: item.Id})
+		case models.TrashItemActivity:
+			files, err = db.PurgeActivityTx(ctx, storage.PurgeActivityTxParams{Id: item.Id, OrganizationId: item.OrganizationId})
+		case models.TrashItemData:
+			files, err = db.PurgeDataTx(ctx, storage.PurgeDataTxParams{Id: item.Id, ActivityId: item.ActivityId})
+		}
+		if err != nil {
+			s.log.Error("Failed to purge an item of the trash", zap.String("type", item.Type), zap.String("id", item.Id.Hex()), zap.Error(err))
+			continue
+		}
+
+		if s.s3 != nil {
+			handlers.DeleteUploadedFiles(files, s.s3)
+		}
+	}
+
+	if len(items) > 0 {
+		s.log.Info("Purged the expired items of the trash", zap.Int("items", len(items)))
+	}
+}
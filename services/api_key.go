@@ -0,0 +1,82 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"stockinos.com/api/models"
+	"stockinos.com/api/storage"
+)
+
+// IsApiKey tells if the token of a request is an API key rather than an access token
+func IsApiKey(token string) bool {
+	return strings.HasPrefix(token, models.ApiKeyPrefix)
+}
+
+// GenerateApiKey returns a new API key and its hash, only the hash is stored
+func GenerateApiKey() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+
+	key := models.ApiKeyPrefix + base64.RawURLEncoding.EncodeToString(b)
+	return key, HashApiKey(key), nil
+}
+
+// HashApiKey returns the hash of an API key stored instead of the key
+func HashApiKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// ApiKeyFromContext returns the API key the request is authenticated with, nil for the members
+func ApiKeyFromContext(ctx context.Context) *models.ApiKey {
+	apiKey, _ := ctx.Value(ApiKeyKey).(*models.ApiKey)
+	return apiKey
+}
+
+type apiKeyGetter interface {
+	GetApiKeyByHash(ctx context.Context, arg storage.GetApiKeyByHashParams) (*models.ApiKey, error)
+	TouchApiKey(ctx context.Context, arg storage.TouchApiKeyParams) error
+}
+
+// AuthenticateApiKey checks the API key read by Verifier, the request is refused by Authenticator
+// when the key is unknown, revoked or expired
+func AuthenticateApiKey(db apiKeyGetter) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			token, _ := ctx.Value(ApiKeyTokenKey).(string)
+			if token == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			apiKey, err := db.GetApiKeyByHash(ctx, storage.GetApiKeyByHashParams{
+				KeyHash: HashApiKey(token),
+			})
+			switch {
+			case err != nil:
+				ctx = context.WithValue(ctx, JwtErrorKey, errors.New("api key not checked"))
+			case apiKey == nil || !apiKey.IsActive(time.Now()):
+				ctx = context.WithValue(ctx, JwtErrorKey, errors.New("invalid api key"))
+			default:
+				if err := db.TouchApiKey(ctx, storage.TouchApiKeyParams{Id: apiKey.Id}); err != nil {
+					log.Println("error when recording the use of the api key: ", err)
+				}
+				ctx = context.WithValue(ctx, ApiKeyKey, apiKey)
+			}
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
@@ -0,0 +1,21 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"os"
+)
+
+// cursorSecretKey signs the cursors of the paginated lists, derived from the secret of the tokens
+var cursorSecretKey []byte
+
+func init() {
+	mac := hmac.New(sha256.New, []byte(os.Getenv("JWT_SECRET")))
+	mac.Write([]byte("cursor"))
+	cursorSecretKey = mac.Sum(nil)
+}
+
+// CursorSecretKey returns the key the cursors given to the clients are signed with
+func CursorSecretKey() []byte {
+	return cursorSecretKey
+}
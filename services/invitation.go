@@ -0,0 +1,176 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"stockinos.com/api/requests"
+	"stockinos.com/api/utils"
+)
+
+// GenerateInvitationToken returns the token of the link of a new invitation
+func GenerateInvitationToken() string {
+	return uuid.New().String()
+}
+
+// InvitationTTL is how long an invitation can be used when no expiry is given, INVITATION_TTL
+func InvitationTTL() time.Duration {
+	return utils.GetDurationDefault("INVITATION_TTL", 7*24*time.Hour)
+}
+
+// InvitationLink is the page of the front end where the invitation is accepted, under APP_URL
+func InvitationLink(token string) string {
+	return strings.TrimRight(utils.GetDefault("APP_URL", "http://localhost:5173"), "/") + "/join/" + token
+}
+
+// InvitationMessage is the link of an invitation to send to a phone number
+type InvitationMessage struct {
+	PhoneNumber      string
+	Language         string
+	OrganizationName string
+	Link             string
+}
+
+// JoinReviewMessage tells the people who asked to join an organization the answer of the admins
+type JoinReviewMessage struct {
+	PhoneNumber      string
+	Language         string
+	OrganizationName string
+	Approved         bool
+}
+
+// InvitationSender sends the links of the invitations to the phone numbers invited,
+// and the answers to the join requests
+type InvitationSender interface {
+	// SendInvitation returns the id of the message given by the provider
+	SendInvitation(ctx context.Context, message InvitationMessage) (string, error)
+	SendJoinReview(ctx context.Context, message JoinReviewMessage) (string, error)
+}
+
+// NewInvitationSenderFromEnv sends the invitations on the channel of INVITATION_CHANNEL:
+// whatsapp by default, or fake to only log the links
+func NewInvitationSenderFromEnv() InvitationSender {
+	switch channel := utils.GetDefault("INVITATION_CHANNEL", OTPChannelWhatsApp); channel {
+	case "fake":
+		return NewFakeInvitationSender()
+	case OTPChannelWhatsApp:
+	default:
+		log.Printf("unknown invitation channel %q, whatsapp is used\n", channel)
+	}
+	return NewWhatsAppInvitationSender(WhatsAppInvitationTemplates{
+		Invitation: utils.GetDefault("INVITATION_WHATSAPP_TEMPLATE", "stockinos_invitation"),
+		Approved:   utils.GetDefault("JOIN_APPROVED_WHATSAPP_TEMPLATE", "stockinos_join_approved"),
+		Rejected:   utils.GetDefault("JOIN_REJECTED_WHATSAPP_TEMPLATE", "stockinos_join_rejected"),
+	})
+}
+
+// WhatsAppInvitationTemplates are the templates of the WhatsApp Business API used for the invitations.
+// The body of Invitation takes the name of the organization then the link,
+// the ones of Approved and Rejected the name of the organization.
+type WhatsAppInvitationTemplates struct {
+	Invitation string
+	Approved   string
+	Rejected   string
+}
+
+// WhatsAppInvitationSender sends the invitations with templates of the WhatsApp Business API
+type WhatsAppInvitationSender struct {
+	templates WhatsAppInvitationTemplates
+}
+
+func NewWhatsAppInvitationSender(templates WhatsAppInvitationTemplates) *WhatsAppInvitationSender {
+	return &WhatsAppInvitationSender{templates: templates}
+}
+
+func (sender *WhatsAppInvitationSender) SendInvitation(ctx context.Context, message InvitationMessage) (string, error) {
+	return sender.send(message.PhoneNumber, sender.templates.Invitation, message.Language, message.OrganizationName, message.Link)
+}
+
+func (sender *WhatsAppInvitationSender) SendJoinReview(ctx context.Context, message JoinReviewMessage) (string, error) {
+	template := sender.templates.Rejected
+	if message.Approved {
+		template = sender.templates.Approved
+	}
+	return sender.send(message.PhoneNumber, template, message.Language, message.OrganizationName)
+}
+
+// send sends the template with the texts as the parameters of its body
+func (sender *WhatsAppInvitationSender) send(phoneNumber, template, language string, texts ...string) (string, error) {
+	if phoneNumber == "" {
+		return "", ErrOTPNoRecipient
+	}
+
+	// written as is in the request
+	if language == "" || strings.Trim(language, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ_") != "" {
+		language = utils.GetDefault("OTP_DEFAULT_LANGUAGE", "fr")
+	}
+
+	parameters := make([]map[string]string, 0, len(texts))
+	for _, text := range texts {
+		parameters = append(parameters, map[string]string{"type": "text", "text": text})
+	}
+	body, err := json.Marshal(parameters)
+	if err != nil {
+		return "", err
+	}
+
+	response, err := requests.SendMessageTextFromTemplate(phoneNumber, template, language, string(body))
+	if err != nil {
+		return "", err
+	}
+	if len(response.Messages) == 0 {
+		return "", errors.New("whatsapp: the message was not accepted")
+	}
+	return response.Messages[0].ID, nil
+}
+
+// FakeInvitationSender keeps the invitations instead of sending them, for the tests and the development
+type FakeInvitationSender struct {
+	mu       sync.Mutex
+	messages []InvitationMessage
+	reviews  []JoinReviewMessage
+}
+
+func NewFakeInvitationSender() *FakeInvitationSender {
+	return &FakeInvitationSender{}
+}
+
+func (sender *FakeInvitationSender) SendInvitation(ctx context.Context, message InvitationMessage) (string, error) {
+	sender.mu.Lock()
+	defer sender.mu.Unlock()
+
+	sender.messages = append(sender.messages, message)
+	log.Printf("[fake] invitation for %s: %s\n", message.PhoneNumber, message.Link)
+	return "fake-invitation", nil
+}
+
+func (sender *FakeInvitationSender) SendJoinReview(ctx context.Context, message JoinReviewMessage) (string, error) {
+	sender.mu.Lock()
+	defer sender.mu.Unlock()
+
+	sender.reviews = append(sender.reviews, message)
+	log.Printf("[fake] join request to %s for %s approved: %v\n", message.OrganizationName, message.PhoneNumber, message.Approved)
+	return "fake-join-review", nil
+}
+
+// Reviews returns the answers to the join requests sent so far
+func (sender *FakeInvitationSender) Reviews() []JoinReviewMessage {
+	sender.mu.Lock()
+	defer sender.mu.Unlock()
+
+	return append([]JoinReviewMessage(nil), sender.reviews...)
+}
+
+// Messages returns the invitations sent so far
+func (sender *FakeInvitationSender) Messages() []InvitationMessage {
+	sender.mu.Lock()
+	defer sender.mu.Unlock()
+
+	return append([]InvitationMessage(nil), sender.messages...)
+}
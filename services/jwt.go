@@ -20,11 +20,16 @@ var JwtUserKey *contextKey
 var JwtClaimsKey *contextKey
 var JwtTokenKey *contextKey
 var JwtErrorKey *contextKey
+var JwtSessionKey *contextKey
+var ApiKeyTokenKey *contextKey
+var ApiKeyKey *contextKey
 var jwtSecretKey []byte // (os.Getenv("Jwt_SECRET"))
 
 type DDXJwtClaims struct {
 	*jwt.RegisteredClaims
 	User interface{}
+	// the session the access token was issued for, see models.Session
+	SessionId string `json:"sid,omitempty"`
 }
 
 func (k *contextKey) String() string {
@@ -36,17 +41,22 @@ func init() {
 	JwtClaimsKey = &contextKey{"Claims"}
 	JwtTokenKey = &contextKey{"Token"}
 	JwtErrorKey = &contextKey{"Error"}
+	JwtSessionKey = &contextKey{"Session"}
+	ApiKeyTokenKey = &contextKey{"ApiKeyToken"}
+	ApiKeyKey = &contextKey{"ApiKey"}
 	jwtSecretKey = []byte(os.Getenv("JWT_SECRET"))
 	// TokenAuth = jwtauth.New("HS256", []byte(os.Getenv("Jwt_SECRET")), "s-tschwaa")
 }
 
-func GenerateJwtToken(data map[string]interface{}) (string, error) {
+// GenerateJwtToken issues a short-lived access token for a session, it is renewed with the refresh token of the session
+func GenerateJwtToken(data map[string]interface{}, sessionId string) (string, error) {
 	now := time.Now().UTC()
 	claims := DDXJwtClaims{
-		User: data,
+		User:      data,
+		SessionId: sessionId,
 		RegisteredClaims: &jwt.RegisteredClaims{
 			IssuedAt:  jwt.NewNumericDate(now),
-			ExpiresAt: jwt.NewNumericDate(now.Add(24 * time.Hour)),
+			ExpiresAt: jwt.NewNumericDate(now.Add(GetSessionConfig().AccessTTL)),
 		},
 	}
 
@@ -92,24 +102,41 @@ func TokenFromHeader(r *http.Request) (string, error) {
 	return tokenString, nil
 }
 
+// Verifier reads the token of the request from the Authorization header, for the scripts and the integrations,
+// or from the cookie of the browsers. The API keys are checked by AuthenticateApiKey.
 func Verifier(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		tokenString, err := TokenFromCookie(r)
+		tokenString, err := TokenFromHeader(r)
+		if err != nil {
+			tokenString, err = TokenFromCookie(r)
+		}
 
 		ctx := r.Context()
+		if err == nil && IsApiKey(tokenString) {
+			ctx = context.WithValue(ctx, ApiKeyTokenKey, tokenString)
+			tokenString = ""
+		}
 		ctx = context.WithValue(ctx, JwtTokenKey, tokenString)
 		ctx = context.WithValue(ctx, JwtErrorKey, err)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
-func ParseJwtToken(next http.Handler) http.Handler {
+// ParseJwtToken reads the claims of the access token, the tokens of the revoked sessions are refused.
+// The revocations are not checked when the list is nil.
+func ParseJwtToken(revocations RevocationList) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return parseJwtToken(next, revocations)
+	}
+}
+
+func parseJwtToken(next http.Handler, revocations RevocationList) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 		tokenString, _ := ctx.Value(JwtTokenKey).(string)
 		err, _ := ctx.Value(JwtErrorKey).(error)
 
-		if err != nil {
+		if err != nil || tokenString == "" {
 			next.ServeHTTP(w, r)
 			return
 		}
@@ -141,7 +168,29 @@ func ParseJwtToken(next http.Handler) http.Handler {
 			return
 		}
 
+		// the tokens issued before the sessions can not be revoked
+		if claims.SessionId == "" {
+			ctx = context.WithValue(ctx, JwtErrorKey, errors.New("token without session"))
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
+		if revocations != nil {
+			revoked, err := revocations.IsRevoked(ctx, claims.SessionId)
+			if err != nil {
+				ctx = context.WithValue(ctx, JwtErrorKey, fmt.Errorf("session not checked: %v", err))
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+			if revoked {
+				ctx = context.WithValue(ctx, JwtErrorKey, errors.New("session revoked"))
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+		}
+
 		ctx = context.WithValue(ctx, JwtClaimsKey, claims.User)
+		ctx = context.WithValue(ctx, JwtSessionKey, claims.SessionId)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
@@ -154,6 +203,11 @@ func Authenticator(next http.Handler) http.Handler {
 			http.Error(w, err.Error(), http.StatusUnauthorized)
 			return
 		}
+		// an API key not checked by AuthenticateApiKey
+		if ctx.Value(ApiKeyTokenKey) != nil && ApiKeyFromContext(ctx) == nil {
+			http.Error(w, "api key not checked", http.StatusUnauthorized)
+			return
+		}
 
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
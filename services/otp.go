@@ -0,0 +1,81 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"stockinos.com/api/utils"
+)
+
+const (
+	minOTPLength = 4
+	maxOTPLength = 10
+)
+
+// OTPConfig is how the one-time passwords are issued
+type OTPConfig struct {
+	Length int
+	TTL    time.Duration
+	// Number of wrong codes after which the code can not be used anymore
+	MaxAttempts int
+}
+
+// GetOTPConfig reads the configuration of the one-time passwords from the environment:
+// OTP_LENGTH (6 digits), OTP_TTL (10 minutes) and OTP_MAX_ATTEMPTS (5)
+func GetOTPConfig() OTPConfig {
+	config := OTPConfig{
+		Length:      utils.GetIntDefault("OTP_LENGTH", 6),
+		TTL:         utils.GetDurationDefault("OTP_TTL", 10*time.Minute),
+		MaxAttempts: utils.GetIntDefault("OTP_MAX_ATTEMPTS", 5),
+	}
+
+	if config.Length < minOTPLength {
+		config.Length = minOTPLength
+	}
+	if config.Length > maxOTPLength {
+		config.Length = maxOTPLength
+	}
+	if config.TTL <= 0 {
+		config.TTL = 10 * time.Minute
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = 5
+	}
+	return config
+}
+
+type IssuedOTP struct {
+	Code      string // to send to the user, never stored
+	Hash      string // to store
+	ExpiresAt time.Time
+}
+
+// IssueOTP generates a new one-time password for the phone number
+func IssueOTP(phoneNumber string, now time.Time) (*IssuedOTP, error) {
+	config := GetOTPConfig()
+
+	code, err := utils.GenerateOTP(config.Length)
+	if err != nil {
+		return nil, err
+	}
+
+	return &IssuedOTP{
+		Code:      code,
+		Hash:      HashOTP(phoneNumber, code),
+		ExpiresAt: now.Add(config.TTL),
+	}, nil
+}
+
+// HashOTP returns the hash of a code stored instead of the code. It is keyed by a secret
+// of the server, the few possible codes can not be guessed from the hash alone.
+func HashOTP(phoneNumber, code string) string {
+	secret := utils.GetDefault("OTP_SECRET", string(jwtSecretKey))
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(phoneNumber))
+	mac.Write([]byte{0})
+	mac.Write([]byte(code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
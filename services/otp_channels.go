@@ -0,0 +1,232 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"stockinos.com/api/requests"
+	"stockinos.com/api/utils"
+)
+
+// otpTexts are the texts of the codes sent by SMS or email, by language
+var otpTexts = map[string]struct{ Subject, Body string }{
+	"en": {"Your Stockinos code", "Your Stockinos code is %s. It expires in %d minutes."},
+	"fr": {"Votre code Stockinos", "Votre code Stockinos est %s. Il expire dans %d minutes."},
+}
+
+func otpText(message OTPMessage) (string, string) {
+	language := strings.ToLower(strings.SplitN(strings.ReplaceAll(message.Language, "_", "-"), "-", 2)[0])
+	text, ok := otpTexts[language]
+	if !ok {
+		text = otpTexts[utils.GetDefault("OTP_DEFAULT_LANGUAGE", "fr")]
+	}
+
+	minutes := int(time.Until(message.ExpiresAt).Round(time.Minute).Minutes())
+	if minutes < 1 {
+		minutes = 1
+	}
+	return text.Subject, fmt.Sprintf(text.Body, message.Code, minutes)
+}
+
+// WhatsAppOTPSender sends the codes with a template of the WhatsApp Business API,
+// the code is the only parameter of the body of the template
+type WhatsAppOTPSender struct {
+	template string
+}
+
+func NewWhatsAppOTPSender(template string) *WhatsAppOTPSender {
+	return &WhatsAppOTPSender{template: template}
+}
+
+func (sender *WhatsAppOTPSender) Channel() string {
+	return OTPChannelWhatsApp
+}
+
+func (sender *WhatsAppOTPSender) Send(ctx context.Context, message OTPMessage) (string, error) {
+	if message.PhoneNumber == "" {
+		return "", ErrOTPNoRecipient
+	}
+
+	// written as is in the request
+	language := message.Language
+	if language == "" || strings.Trim(language, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ_") != "" {
+		language = utils.GetDefault("OTP_DEFAULT_LANGUAGE", "fr")
+	}
+
+	parameters, err := json.Marshal([]map[string]string{
+		{"type": "text", "text": message.Code},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	response, err := requests.SendMessageTextFromTemplate(message.PhoneNumber, sender.template, language, string(parameters))
+	if err != nil {
+		return "", err
+	}
+	// the errors of the API come back without message
+	if len(response.Messages) == 0 {
+		return "", errors.New("whatsapp: the message was not accepted")
+	}
+	return response.Messages[0].ID, nil
+}
+
+type TwilioConfig struct {
+	AccountSid string
+	AuthToken  string
+	From       string
+}
+
+// TwilioConfigFromEnv reads TWILIO_ACCOUNT_SID, TWILIO_AUTH_TOKEN and TWILIO_FROM
+func TwilioConfigFromEnv() TwilioConfig {
+	return TwilioConfig{
+		AccountSid: utils.GetDefault("TWILIO_ACCOUNT_SID", ""),
+		AuthToken:  utils.GetDefault("TWILIO_AUTH_TOKEN", ""),
+		From:       utils.GetDefault("TWILIO_FROM", ""),
+	}
+}
+
+// TwilioSMSSender sends the codes by SMS with the API of Twilio
+type TwilioSMSSender struct {
+	config TwilioConfig
+	client *http.Client
+}
+
+func NewTwilioSMSSender(config TwilioConfig) *TwilioSMSSender {
+	return &TwilioSMSSender{
+		config: config,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (sender *TwilioSMSSender) Channel() string {
+	return OTPChannelSMS
+}
+
+func (sender *TwilioSMSSender) Send(ctx context.Context, message OTPMessage) (string, error) {
+	if message.PhoneNumber == "" {
+		return "", ErrOTPNoRecipient
+	}
+
+	to := message.PhoneNumber
+	if !strings.HasPrefix(to, "+") {
+		to = "+" + to
+	}
+	_, body := otpText(message)
+
+	form := url.Values{}
+	form.Set("To", to)
+	form.Set("From", sender.config.From)
+	form.Set("Body", body)
+
+	requestUrl := fmt.Sprintf("https://api.twilio.com/2010-04-01/Accounts/%s/Messages.json", sender.config.AccountSid)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, requestUrl, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(sender.config.AccountSid, sender.config.AuthToken)
+
+	res, err := sender.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	resBody, err := io.ReadAll(res.Body)
+	if err != nil {
+		return "", err
+	}
+	if res.StatusCode >= 300 {
+		return "", fmt.Errorf("twilio: status %d: %s", res.StatusCode, resBody)
+	}
+
+	var data struct {
+		Sid string `json:"sid"`
+	}
+	if err := json.Unmarshal(resBody, &data); err != nil {
+		return "", err
+	}
+	return data.Sid, nil
+}
+
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+// SMTPConfigFromEnv reads SMTP_HOST, SMTP_PORT (587), SMTP_USERNAME, SMTP_PASSWORD and SMTP_FROM
+func SMTPConfigFromEnv() SMTPConfig {
+	return SMTPConfig{
+		Host:     utils.GetDefault("SMTP_HOST", ""),
+		Port:     utils.GetIntDefault("SMTP_PORT", 587),
+		Username: utils.GetDefault("SMTP_USERNAME", ""),
+		Password: utils.GetDefault("SMTP_PASSWORD", ""),
+		From:     utils.GetDefault("SMTP_FROM", ""),
+	}
+}
+
+// SMTPEmailSender sends the codes by email, to the users who gave one
+type SMTPEmailSender struct {
+	config SMTPConfig
+}
+
+func NewSMTPEmailSender(config SMTPConfig) *SMTPEmailSender {
+	return &SMTPEmailSender{config: config}
+}
+
+func (sender *SMTPEmailSender) Channel() string {
+	return OTPChannelEmail
+}
+
+func (sender *SMTPEmailSender) Send(ctx context.Context, message OTPMessage) (string, error) {
+	// a line break would add headers to the email
+	if message.Email == "" || strings.ContainsAny(message.Email, "\r\n") {
+		return "", ErrOTPNoRecipient
+	}
+
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	messageId := fmt.Sprintf("<%s@%s>", hex.EncodeToString(b), sender.config.Host)
+
+	subject, body := otpText(message)
+	content := strings.Join([]string{
+		"From: " + sender.config.From,
+		"To: " + message.Email,
+		"Subject: " + subject,
+		"Message-ID: " + messageId,
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		body,
+	}, "\r\n")
+
+	var auth smtp.Auth
+	if sender.config.Username != "" {
+		auth = smtp.PlainAuth("", sender.config.Username, sender.config.Password, sender.config.Host)
+	}
+
+	address := net.JoinHostPort(sender.config.Host, strconv.Itoa(sender.config.Port))
+	if err := smtp.SendMail(address, auth, sender.config.From, []string{message.Email}, []byte(content)); err != nil {
+		return "", err
+	}
+	return messageId, nil
+}
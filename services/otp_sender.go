@@ -0,0 +1,160 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"stockinos.com/api/utils"
+)
+
+const (
+	OTPChannelWhatsApp = "whatsapp"
+	OTPChannelSMS      = "sms"
+	OTPChannelEmail    = "email"
+)
+
+// ErrOTPNoRecipient is returned by a sender when the user can not be reached on its channel,
+// an email sender for a user without email for instance
+var ErrOTPNoRecipient = errors.New("no recipient for the channel")
+
+var ErrOTPNotSent = errors.New("the code could not be sent on any channel")
+
+// OTPMessage is a code to deliver to a user
+type OTPMessage struct {
+	PhoneNumber string
+	Email       string
+	Language    string
+	Code        string
+	ExpiresAt   time.Time
+}
+
+// OTPSender delivers the codes on a channel
+type OTPSender interface {
+	Channel() string
+	// Send returns the id of the message given by the provider
+	Send(ctx context.Context, message OTPMessage) (string, error)
+}
+
+// IsOTPChannel tells if the codes can be sent on the channel
+func IsOTPChannel(channel string) bool {
+	return channel == OTPChannelWhatsApp || channel == OTPChannelSMS || channel == OTPChannelEmail
+}
+
+type OTPDelivery struct {
+	Channel   string
+	MessageId string
+}
+
+// OTPDispatcher sends a code on the channel preferred by the user, or on the next channels when it fails
+type OTPDispatcher struct {
+	// in the order they are tried
+	senders []OTPSender
+}
+
+func NewOTPDispatcher(senders ...OTPSender) *OTPDispatcher {
+	return &OTPDispatcher{senders: senders}
+}
+
+// NewOTPDispatcherFromEnv sets up the channels listed in OTP_CHANNELS, in the order they are tried:
+// whatsapp, sms, email or fake to only log the codes. WhatsApp is used when none is set.
+func NewOTPDispatcherFromEnv() *OTPDispatcher {
+	var senders []OTPSender
+	for _, name := range strings.Split(utils.GetDefault("OTP_CHANNELS", OTPChannelWhatsApp), ",") {
+		switch name = strings.TrimSpace(strings.ToLower(name)); name {
+		case OTPChannelWhatsApp:
+			senders = append(senders, NewWhatsAppOTPSender(utils.GetDefault("OTP_WHATSAPP_TEMPLATE", "woz_otp")))
+		case OTPChannelSMS:
+			senders = append(senders, NewTwilioSMSSender(TwilioConfigFromEnv()))
+		case OTPChannelEmail:
+			senders = append(senders, NewSMTPEmailSender(SMTPConfigFromEnv()))
+		case "fake":
+			senders = append(senders, NewFakeOTPSender(OTPChannelWhatsApp))
+		case "":
+		default:
+			log.Printf("unknown OTP channel %q, ignored\n", name)
+		}
+	}
+	return NewOTPDispatcher(senders...)
+}
+
+// SendOTP sends the code on the preferred channel first, then on the others
+func (dispatcher *OTPDispatcher) SendOTP(ctx context.Context, message OTPMessage, preferred string) (*OTPDelivery, error) {
+	senders := make([]OTPSender, 0, len(dispatcher.senders))
+	for _, sender := range dispatcher.senders {
+		if sender.Channel() == preferred {
+			senders = append(senders, sender)
+		}
+	}
+	for _, sender := range dispatcher.senders {
+		if sender.Channel() != preferred {
+			senders = append(senders, sender)
+		}
+	}
+
+	err := ErrOTPNotSent
+	for _, sender := range senders {
+		messageId, sendErr := sender.Send(ctx, message)
+		if errors.Is(sendErr, ErrOTPNoRecipient) {
+			continue
+		}
+		if sendErr != nil {
+			log.Printf("error when sending the OTP by %s: %s\n", sender.Channel(), sendErr)
+			err = fmt.Errorf("%w: %s", ErrOTPNotSent, sendErr)
+			continue
+		}
+
+		return &OTPDelivery{Channel: sender.Channel(), MessageId: messageId}, nil
+	}
+	return nil, err
+}
+
+// FakeOTPSender keeps the codes instead of sending them, for the tests and the development
+type FakeOTPSender struct {
+	channel string
+
+	mu       sync.Mutex
+	messages []OTPMessage
+}
+
+func NewFakeOTPSender(channel string) *FakeOTPSender {
+	return &FakeOTPSender{channel: channel}
+}
+
+func (sender *FakeOTPSender) Channel() string {
+	return sender.channel
+}
+
+func (sender *FakeOTPSender) Send(ctx context.Context, message OTPMessage) (string, error) {
+	sender.mu.Lock()
+	defer sender.mu.Unlock()
+
+	sender.messages = append(sender.messages, message)
+	log.Printf("[fake %s] OTP for %s: %s\n", sender.channel, message.PhoneNumber, message.Code)
+	return fmt.Sprintf("fake-%s-%d", sender.channel, len(sender.messages)), nil
+}
+
+// Messages returns the codes sent so far
+func (sender *FakeOTPSender) Messages() []OTPMessage {
+	sender.mu.Lock()
+	defer sender.mu.Unlock()
+
+	return append([]OTPMessage(nil), sender.messages...)
+}
+
+// LastCode returns the last code sent to the phone number
+func (sender *FakeOTPSender) LastCode(phoneNumber string) string {
+	sender.mu.Lock()
+	defer sender.mu.Unlock()
+
+	for i := len(sender.messages) - 1; i >= 0; i-- {
+		if sender.messages[i].PhoneNumber == phoneNumber {
+			return sender.messages[i].Code
+		}
+	}
+	return ""
+}
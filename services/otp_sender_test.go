@@ -0,0 +1,76 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"stockinos.com/api/services"
+)
+
+type failingOTPSender struct {
+	channel string
+	err     error
+}
+
+func (sender *failingOTPSender) Channel() string {
+	return sender.channel
+}
+
+func (sender *failingOTPSender) Send(ctx context.Context, message services.OTPMessage) (string, error) {
+	return "", sender.err
+}
+
+func TestSendOTP(t *testing.T) {
+	message := services.OTPMessage{
+		PhoneNumber: "695165033",
+		Code:        "123456",
+		ExpiresAt:   time.Now().Add(5 * time.Minute),
+	}
+
+	t.Run("preferred channel first", func(t *testing.T) {
+		whatsapp := services.NewFakeOTPSender(services.OTPChannelWhatsApp)
+		sms := services.NewFakeOTPSender(services.OTPChannelSMS)
+		dispatcher := services.NewOTPDispatcher(whatsapp, sms)
+
+		delivery, err := dispatcher.SendOTP(context.Background(), message, services.OTPChannelSMS)
+		if err != nil {
+			t.Fatalf("SendOTP() error = %v", err)
+		}
+		if delivery.Channel != services.OTPChannelSMS {
+			t.Fatalf("SendOTP() channel = %s; want = %s", delivery.Channel, services.OTPChannelSMS)
+		}
+		if sms.LastCode(message.PhoneNumber) != message.Code || len(whatsapp.Messages()) != 0 {
+			t.Fatalf("SendOTP() sent on the wrong channels")
+		}
+	})
+
+	t.Run("fallback when the channel fails", func(t *testing.T) {
+		sms := services.NewFakeOTPSender(services.OTPChannelSMS)
+		dispatcher := services.NewOTPDispatcher(
+			&failingOTPSender{channel: services.OTPChannelEmail, err: services.ErrOTPNoRecipient},
+			&failingOTPSender{channel: services.OTPChannelWhatsApp, err: errors.New("unavailable")},
+			sms,
+		)
+
+		delivery, err := dispatcher.SendOTP(context.Background(), message, services.OTPChannelEmail)
+		if err != nil {
+			t.Fatalf("SendOTP() error = %v", err)
+		}
+		if delivery.Channel != services.OTPChannelSMS {
+			t.Fatalf("SendOTP() channel = %s; want = %s", delivery.Channel, services.OTPChannelSMS)
+		}
+	})
+
+	t.Run("no channel left", func(t *testing.T) {
+		dispatcher := services.NewOTPDispatcher(
+			&failingOTPSender{channel: services.OTPChannelWhatsApp, err: errors.New("unavailable")},
+		)
+
+		_, err := dispatcher.SendOTP(context.Background(), message, "")
+		if !errors.Is(err, services.ErrOTPNotSent) {
+			t.Fatalf("SendOTP() error = %v; want = %v", err, services.ErrOTPNotSent)
+		}
+	})
+}
@@ -0,0 +1,208 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"stockinos.com/api/models"
+	"stockinos.com/api/storage"
+	"stockinos.com/api/utils"
+)
+
+const (
+	AccessTokenCookie  = "jwt"
+	RefreshTokenCookie = "refresh_token"
+	// The refresh token is only sent to the routes using it
+	refreshTokenCookiePath = "/auth"
+)
+
+// SessionConfig is how long the tokens of a session last
+type SessionConfig struct {
+	AccessTTL time.Duration
+	// A session expires when its refresh token is not used for that long
+	RefreshTTL time.Duration
+}
+
+// GetSessionConfig reads the configuration of the sessions from the environment:
+// JWT_ACCESS_TTL (15 minutes) and JWT_REFRESH_TTL (30 days)
+func GetSessionConfig() SessionConfig {
+	config := SessionConfig{
+		AccessTTL:  utils.GetDurationDefault("JWT_ACCESS_TTL", 15*time.Minute),
+		RefreshTTL: utils.GetDurationDefault("JWT_REFRESH_TTL", 30*24*time.Hour),
+	}
+
+	if config.AccessTTL <= 0 {
+		config.AccessTTL = 15 * time.Minute
+	}
+	if config.RefreshTTL <= 0 {
+		config.RefreshTTL = 30 * 24 * time.Hour
+	}
+	return config
+}
+
+// GenerateRefreshToken returns a new refresh token and its hash, only the hash is stored
+func GenerateRefreshToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, HashRefreshToken(token), nil
+}
+
+// HashRefreshToken returns the hash of a refresh token stored instead of the token
+func HashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func TokenFromRefreshCookie(r *http.Request) string {
+	cookie, err := r.Cookie(RefreshTokenCookie)
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
+
+// SetAuthCookies gives the tokens of a session to the browser
+func SetAuthCookies(w http.ResponseWriter, accessToken, refreshToken string) {
+	config := GetSessionConfig()
+	now := time.Now()
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     AccessTokenCookie,
+		Value:    accessToken,
+		Path:     "/",
+		Expires:  now.Add(config.AccessTTL),
+		MaxAge:   int(config.AccessTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     RefreshTokenCookie,
+		Value:    refreshToken,
+		Path:     refreshTokenCookiePath,
+		Expires:  now.Add(config.RefreshTTL),
+		MaxAge:   int(config.RefreshTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// ClearAuthCookies removes the tokens from the browser
+func ClearAuthCookies(w http.ResponseWriter) {
+	for name, path := range map[string]string{AccessTokenCookie: "/", RefreshTokenCookie: refreshTokenCookiePath} {
+		http.SetCookie(w, &http.Cookie{
+			Name:     name,
+			Value:    "",
+			Path:     path,
+			Expires:  time.Unix(0, 0),
+			MaxAge:   -1,
+			HttpOnly: true,
+			Secure:   true,
+		})
+	}
+}
+
+// SessionIdFromContext returns the session of the access token of the request
+func SessionIdFromContext(ctx context.Context) (primitive.ObjectID, bool) {
+	sessionId, _ := ctx.Value(JwtSessionKey).(string)
+	id, err := primitive.ObjectIDFromHex(sessionId)
+	return id, err == nil
+}
+
+// RevocationList tells if the session of an access token has been revoked
+type RevocationList interface {
+	IsRevoked(ctx context.Context, sessionId string) (bool, error)
+}
+
+type sessionGetter interface {
+	GetSession(ctx context.Context, arg storage.GetSessionParams) (*models.Session, error)
+}
+
+type revocationEntry struct {
+	revoked   bool
+	expiresAt time.Time
+}
+
+// SessionRevocations checks the sessions in the database and remembers them for a while,
+// a session revoked on another node is refused once its check expires.
+type SessionRevocations struct {
+	db sessionGetter
+	// How long an active session is not checked again
+	checkInterval time.Duration
+
+	mu        sync.Mutex
+	entries   map[string]revocationEntry
+	lastSweep time.Time
+}
+
+func NewSessionRevocations(db sessionGetter) *SessionRevocations {
+	return &SessionRevocations{
+		db:            db,
+		checkInterval: utils.GetDurationDefault("SESSION_CHECK_INTERVAL", 30*time.Second),
+		entries:       make(map[string]revocationEntry),
+	}
+}
+
+func (list *SessionRevocations) IsRevoked(ctx context.Context, sessionId string) (bool, error) {
+	now := time.Now()
+
+	list.mu.Lock()
+	if now.Sub(list.lastSweep) >= time.Minute {
+		for id, entry := range list.entries {
+			if !now.Before(entry.expiresAt) {
+				delete(list.entries, id)
+			}
+		}
+		list.lastSweep = now
+	}
+	entry, ok := list.entries[sessionId]
+	list.mu.Unlock()
+
+	if ok && now.Before(entry.expiresAt) {
+		return entry.revoked, nil
+	}
+
+	id, err := primitive.ObjectIDFromHex(sessionId)
+	if err != nil {
+		return true, nil
+	}
+	session, err := list.db.GetSession(ctx, storage.GetSessionParams{Id: id})
+	if err != nil {
+		return false, err
+	}
+
+	// an expired session is revoked as well
+	revoked := session == nil || session.RevokedAt != nil
+	if revoked {
+		list.Revoked(sessionId)
+	} else {
+		list.mu.Lock()
+		list.entries[sessionId] = revocationEntry{expiresAt: now.Add(list.checkInterval)}
+		list.mu.Unlock()
+	}
+	return revoked, nil
+}
+
+// Revoked refuses at once the access tokens of the sessions revoked by this node
+func (list *SessionRevocations) Revoked(sessionIds ...string) {
+	// the access tokens of the session are all expired after that
+	expiresAt := time.Now().Add(GetSessionConfig().AccessTTL)
+
+	list.mu.Lock()
+	defer list.mu.Unlock()
+	for _, sessionId := range sessionIds {
+		list.entries[sessionId] = revocationEntry{revoked: true, expiresAt: expiresAt}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -27,6 +28,7 @@ func (q *Queries) CreateActivity(ctx context.Context, arg CreateActivityParams)
 		Name:        arg.Name,
 		Description: arg.Description,
 		Fields:      arg.Fields, // Default to [] empty array instead of null
+		Version:     1,
 
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
@@ -47,6 +49,8 @@ func (q *Queries) CreateActivity(ctx context.Context, arg CreateActivityParams)
 type GetActivityParams struct {
 	Id             primitive.ObjectID
 	OrganizationId primitive.ObjectID
+	// Also look for the activity in the trash
+	WithDeleted bool
 }
 
 func (q *Queries) GetActivity(ctx context.Context, arg GetActivityParams) (*models.Activity, error) {
@@ -55,7 +59,9 @@ func (q *Queries) GetActivity(ctx context.Context, arg GetActivityParams) (*mode
 	filter := bson.M{
 		"_id":             arg.Id,
 		"organization_id": arg.OrganizationId,
-		"deleted_at":      nil,
+	}
+	if !arg.WithDeleted {
+		filter["deleted_at"] = nil
 	}
 	err := q.activitiesCollection.FindOne(ctx, filter).Decode(&activity)
 	if err != nil {
@@ -96,6 +102,7 @@ func (q *Queries) GetAllActivities(ctx context.Context, arg GetAllActivitiesPara
 type DeleteActivityParams struct {
 	Id             primitive.ObjectID
 	OrganizationId primitive.ObjectID
+	DeletedBy      models.DataAuthor
 }
 
 func (q *Queries) DeleteActivity(ctx context.Context, arg DeleteActivityParams) error {
@@ -106,6 +113,7 @@ func (q *Queries) DeleteActivity(ctx context.Context, arg DeleteActivityParams)
 	update := bson.M{
 		"$set": bson.M{
 			"deleted_at": time.Now(),
+			"deleted_by": arg.DeletedBy,
 		},
 	}
 
@@ -146,6 +154,11 @@ func (q *Queries) UpdateSetInActivity(ctx context.Context, arg UpdateSetInActivi
 	update := bson.M{
 		"$set": set,
 	}
+	for field := range arg.FieldsToSet {
+		if isSchemaChange(field) {
+			update["$inc"] = bson.M{"version": 1}
+		}
+	}
 
 	return CommonUpdateQuery[models.Activity](ctx, *q.activitiesCollection, filter, update)
 }
@@ -174,6 +187,9 @@ func (q *Queries) UpdateAddToActivity(ctx context.Context, arg UpdateAddToActivi
 			},
 		},
 	}
+	if isSchemaChange(arg.Field) {
+		update["$inc"] = bson.M{"version": 1}
+	}
 
 	return CommonUpdateQuery[models.Activity](ctx, *q.activitiesCollection, filter, update)
 }
@@ -208,6 +224,9 @@ func (q *Queries) UpdateRemoveFromActivity(ctx context.Context, arg UpdateRemove
 			arg.Field: nil,
 		},
 	}
+	if isSchemaChange(arg.Field) {
+		update["$inc"] = bson.M{"version": 1}
+	}
 
 	return CommonUpdateQuery[models.Activity](ctx, *q.activitiesCollection, filter, update)
 }
@@ -307,3 +326,8 @@ func (q *Queries) RemoveRelationshipFromActivity(ctx context.Context, arg Remove
 
 	return CommonUpdateQuery[models.Activity](ctx, *q.activitiesCollection, filter, update)
 }
+
+// isSchemaChange tells if updating the field of an activity changes the version of its fields
+func isSchemaChange(field string) bool {
+	return field == "fields" || strings.HasPrefix(field, "fields.")
+}
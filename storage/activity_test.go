@@ -2,6 +2,7 @@ package storage_test
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math/rand/v2"
 	"sort"
@@ -26,6 +27,7 @@ func TestActivity(t *testing.T) {
 		"CreateActivity":   testCreateActivity,
 		"DeleteActivity":   testDeleteActivity,
 		"UpdateActivity":   testUpdateActivity,
+		"AddUniqueField":   testUpdateAddToActivityTx,
 		"GetAllActivities": testGetAllActivities,
 	}
 
@@ -139,6 +141,172 @@ func testDeleteActivity(t *testing.T, db *storage.Database) {
 	}
 }
 
+func testUpdateAddToActivityTx(t *testing.T, db *storage.Database) {
+	newActivity := func(t *testing.T) *models.Activity {
+		activity, err := db.Storage.CreateActivity(context.Background(), storage.CreateActivityParams{
+			Name: "a1",
+			Fields: []models.ActivityField{
+				{Id: primitive.NewObjectID(), Name: "f1", Type: models.FieldTypeText},
+			},
+
+			OrganizationId: primitive.NewObjectID(),
+			CreatedBy:      primitive.NewObjectID(),
+		})
+		if err != nil {
+			t.Fatalf("CreateActivity() err = %v; want nil", err)
+		}
+		return activity
+	}
+	// the data keep the values of a field removed before, under the id of the field added again
+	createData := func(t *testing.T, activity *models.Activity, fieldId primitive.ObjectID, value string) *models.Data {
+		data, err := db.Storage.CreateData(context.Background(), storage.CreateDataParams{
+			Values: map[string]any{
+				activity.Fields[0].Id.Hex(): gofaker.Word(),
+				fieldId.Hex():               value,
+			},
+			ActivityId: activity.Id,
+			CreatedBy:  models.DataAuthor{Id: primitive.NewObjectID()},
+		})
+		if err != nil {
+			t.Fatalf("CreateData() err = %v; want nil", err)
+		}
+		return data
+	}
+
+	t.Run("unique values", func(t *testing.T) {
+		activity := newActivity(t)
+		field := models.ActivityField{Id: primitive.NewObjectID(), Name: "f2", Type: models.FieldTypeText, Unique: true}
+		data := createData(t, activity, field.Id, "v1")
+		createData(t, activity, field.Id, "v2")
+
+		updated, err := db.Storage.UpdateAddToActivityTx(context.Background(), storage.UpdateAddToActivityTxParams{
+			Activity:       *activity,
+			OrganizationId: activity.OrganizationId,
+
+			Field:    "fields",
+			Value:    field,
+			Position: 1,
+		})
+		if err != nil {
+			t.Fatalf("UpdateAddToActivityTx(): got error %v; want nil", err)
+		}
+		if len(updated.Fields) != 2 || updated.Fields[1].Id != field.Id {
+			t.Fatalf("UpdateAddToActivityTx(): fields - got %+v; want f2 added", updated.Fields)
+		}
+		if updated.UniqueKeysRefresh == nil {
+			t.Fatalf("UpdateAddToActivityTx(): got no refresh of the unique keys; want one started")
+		}
+
+		// one data in each batch, the refresh going through several of them
+		err = db.Storage.RefreshDataUniqueKeysTx(context.Background(), storage.RefreshDataUniqueKeysTxParams{
+			Activity:  *updated,
+			BatchSize: 1,
+		})
+		if err != nil {
+			t.Fatalf("RefreshDataUniqueKeysTx(): got error %v; want nil", err)
+		}
+
+		got, err := db.Storage.GetData(context.Background(), storage.GetDataParams{Id: data.Id, ActivityId: activity.Id})
+		if err != nil {
+			t.Fatalf("GetData(): got error %v; want nil", err)
+		}
+		if want := updated.UniqueKeys(got.Values); fmt.Sprint(got.UniqueKeys) != fmt.Sprint(want) {
+			t.Fatalf("RefreshDataUniqueKeysTx(): unique keys - got %v; want %v", got.UniqueKeys, want)
+		}
+
+		refreshed, err := db.Storage.GetActivity(context.Background(), storage.GetActivityParams{
+			Id:             activity.Id,
+			OrganizationId: activity.OrganizationId,
+		})
+		if err != nil {
+			t.Fatalf("GetActivity(): got error %v; want nil", err)
+		}
+		if refreshed.UniqueKeysRefresh != nil {
+			t.Fatalf("RefreshDataUniqueKeysTx(): refresh - got %+v; want it removed once done", refreshed.UniqueKeysRefresh)
+		}
+	})
+
+	t.Run("data created before the unique keys", func(t *testing.T) {
+		activity := newActivity(t)
+		field := models.ActivityField{Id: primitive.NewObjectID(), Name: "f2", Type: models.FieldTypeText, Unique: true}
+		activity, err := db.Storage.UpdateAddToActivity(context.Background(), storage.UpdateAddToActivityParams{
+			Id:             activity.Id,
+			OrganizationId: activity.OrganizationId,
+
+			Field:    "fields",
+			Value:    field,
+			Position: 1,
+		})
+		if err != nil {
+			t.Fatalf("UpdateAddToActivity(): got error %v; want nil", err)
+		}
+		// created without their keys
+		data := createData(t, activity, field.Id, "v1")
+		createData(t, activity, field.Id, "v2")
+
+		legacy, err := db.Storage.GetActivitiesWithLegacyUniqueKeys(context.Background())
+		if err != nil {
+			t.Fatalf("GetActivitiesWithLegacyUniqueKeys(): got error %v; want nil", err)
+		}
+		found := false
+		for _, a := range legacy {
+			found = found || a.Id == activity.Id
+		}
+		if !found {
+			t.Fatalf("GetActivitiesWithLegacyUniqueKeys(): activity %s not found", activity.Id.Hex())
+		}
+
+		started, err := db.Storage.StartDataUniqueKeysRefreshTx(context.Background(), storage.StartDataUniqueKeysRefreshTxParams{
+			Activity: *activity,
+		})
+		if err != nil {
+			t.Fatalf("StartDataUniqueKeysRefreshTx(): got error %v; want nil", err)
+		}
+		if err := db.Storage.RefreshDataUniqueKeysTx(context.Background(), storage.RefreshDataUniqueKeysTxParams{Activity: *started}); err != nil {
+			t.Fatalf("RefreshDataUniqueKeysTx(): got error %v; want nil", err)
+		}
+
+		got, err := db.Storage.GetData(context.Background(), storage.GetDataParams{Id: data.Id, ActivityId: activity.Id})
+		if err != nil {
+			t.Fatalf("GetData(): got error %v; want nil", err)
+		}
+		if want := activity.UniqueKeys(got.Values); len(want) == 0 || fmt.Sprint(got.UniqueKeys) != fmt.Sprint(want) {
+			t.Fatalf("RefreshDataUniqueKeysTx(): unique keys - got %v; want %v", got.UniqueKeys, want)
+		}
+	})
+
+	t.Run("duplicate values", func(t *testing.T) {
+		activity := newActivity(t)
+		field := models.ActivityField{Id: primitive.NewObjectID(), Name: "f2", Type: models.FieldTypeText, Unique: true}
+		createData(t, activity, field.Id, "v1")
+		createData(t, activity, field.Id, "v1")
+
+		_, err := db.Storage.UpdateAddToActivityTx(context.Background(), storage.UpdateAddToActivityTxParams{
+			Activity:       *activity,
+			OrganizationId: activity.OrganizationId,
+
+			Field:    "fields",
+			Value:    field,
+			Position: 1,
+		})
+		if !errors.Is(err, storage.ErrDataNotUnique) {
+			t.Fatalf("UpdateAddToActivityTx(): got error %v; want %v", err, storage.ErrDataNotUnique)
+		}
+
+		// the field is not added without the unique keys of the data
+		got, err := db.Storage.GetActivity(context.Background(), storage.GetActivityParams{
+			Id:             activity.Id,
+			OrganizationId: activity.OrganizationId,
+		})
+		if err != nil {
+			t.Fatalf("GetActivity(): got error %v; want nil", err)
+		}
+		if len(got.Fields) != 1 {
+			t.Fatalf("GetActivity(): got %d fields; want 1", len(got.Fields))
+		}
+	})
+}
+
 func testUpdateActivity(t *testing.T, db *storage.Database) {
 	arg := storage.CreateActivityParams{
 		Name:        "a1",
@@ -164,54 +332,42 @@ func testUpdateActivity(t *testing.T, db *storage.Database) {
 			Id:             activity.Id,
 			OrganizationId: arg.OrganizationId,
 
-			Field: "name",
-			Value: "a2",
+			FieldsToSet: map[string]any{"name": "a2"},
 		}
 		updated, err := db.Storage.UpdateSetInActivity(context.Background(), argForUpdate)
 		if err != nil {
 			t.Fatalf("UpdateActivity(): got error %v; want nil", err)
 		}
-		if updated.Name != argForUpdate.Value {
-			t.Fatalf(
-				"UpdateActivity(): updated %s value - got %s; want %s",
-				argForUpdate.Field, updated.Name, argForUpdate.Value,
-			)
+		if updated.Name != "a2" {
+			t.Fatalf("UpdateActivity(): updated name value - got %s; want a2", updated.Name)
 		}
 
 		argForUpdate = storage.UpdateSetInActivityParams{
 			Id:             activity.Id,
 			OrganizationId: arg.OrganizationId,
 
-			Field: "fields.1.code",
-			Value: "f1",
+			FieldsToSet: map[string]any{"fields.1.code": "f1"},
 		}
 		updated, err = db.Storage.UpdateSetInActivity(context.Background(), argForUpdate)
 		if err != nil {
 			t.Fatalf("UpdateActivity(): got error %v; want nil", err)
 		}
-		if updated.Fields[1].Code != argForUpdate.Value {
-			t.Fatalf(
-				"UpdateActivity(): updated %s value - got %s; want %s",
-				argForUpdate.Field, updated.Name, argForUpdate.Value,
-			)
+		if updated.Fields[1].Code != "f1" {
+			t.Fatalf("UpdateActivity(): updated fields.1.code value - got %s; want f1", updated.Fields[1].Code)
 		}
 
 		argForUpdate = storage.UpdateSetInActivityParams{
 			Id:             activity.Id,
 			OrganizationId: arg.OrganizationId,
 
-			Field: "fields.1.id",
-			Value: false,
+			FieldsToSet: map[string]any{"fields.0.primary_key": false},
 		}
 		updated, err = db.Storage.UpdateSetInActivity(context.Background(), argForUpdate)
 		if err != nil {
 			t.Fatalf("UpdateActivity(): got error %v; want nil", err)
 		}
-		if updated.Fields[0].PrimaryKey != argForUpdate.Value {
-			t.Fatalf(
-				"UpdateActivity(): updated %s value - got %s; want %s",
-				argForUpdate.Field, updated.Name, argForUpdate.Value,
-			)
+		if updated.Fields[0].PrimaryKey {
+			t.Fatalf("UpdateActivity(): updated fields.0.primary_key value - got true; want false")
 		}
 
 		got, err := db.Storage.GetActivity(context.Background(), storage.GetActivityParams{
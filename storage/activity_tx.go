@@ -18,6 +18,10 @@ type UpdateSetInActivityTxParams struct {
 
 	Field   string
 	Details any // models.ActivityFieldType
+
+	// What happens to the values of the existing data, see models.PlanFieldMigrations
+	Migrations []models.FieldMigration
+	Author     models.DataAuthor
 }
 
 func (store *MongoStorage) UpdateSetInActivityTx(ctx context.Context, arg UpdateSetInActivityTxParams) (*models.Activity, error) {
@@ -44,7 +48,7 @@ func (store *MongoStorage) UpdateSetInActivityTx(ctx context.Context, arg Update
 						}
 					}
 					if fieldRelationship != nil {
-						_, err := store.RemoveRelationshipFromActivity(ctx, RemoveRelationshipFromActivityParams{
+						_, err := store.RemoveRelationshipFromActivity(sessCtx, RemoveRelationshipFromActivityParams{
 							Id:             arg.Activity.Id,
 							OrganizationId: arg.OrganizationId,
 
@@ -64,7 +68,7 @@ func (store *MongoStorage) UpdateSetInActivityTx(ctx context.Context, arg Update
 					// type: "belongs-to"
 					// activityId: activity_id
 					// field_id: field_id
-					_, err := store.AddRelationshipIntoActivity(ctx, AddRelationshipIntoActivityParams{
+					_, err := store.AddRelationshipIntoActivity(sessCtx, AddRelationshipIntoActivityParams{
 						Id:             arg.Activity.Id,
 						OrganizationId: arg.OrganizationId,
 
@@ -82,7 +86,7 @@ func (store *MongoStorage) UpdateSetInActivityTx(ctx context.Context, arg Update
 					// type: "has-many or has-one"
 					// activityId: id
 					// field_id:
-					_, err = store.AddRelationshipIntoActivity(ctx, AddRelationshipIntoActivityParams{
+					_, err = store.AddRelationshipIntoActivity(sessCtx, AddRelationshipIntoActivityParams{
 						Id:             details.ActivityId,
 						OrganizationId: arg.OrganizationId,
 
@@ -111,7 +115,7 @@ func (store *MongoStorage) UpdateSetInActivityTx(ctx context.Context, arg Update
 							break
 						}
 					}
-					_, err := store.RemoveRelationshipFromActivity(ctx, RemoveRelationshipFromActivityParams{
+					_, err := store.RemoveRelationshipFromActivity(sessCtx, RemoveRelationshipFromActivityParams{
 						Id:             arg.Activity.Id,
 						OrganizationId: arg.OrganizationId,
 
@@ -128,12 +132,70 @@ func (store *MongoStorage) UpdateSetInActivityTx(ctx context.Context, arg Update
 			}
 		}
 
-		return store.UpdateSetInActivity(ctx, UpdateSetInActivityParams{
+		updatedActivity, err := store.UpdateSetInActivity(sessCtx, UpdateSetInActivityParams{
 			Id:             arg.Activity.Id,
 			OrganizationId: arg.OrganizationId,
 
 			FieldsToSet: arg.FieldsToSet,
 		})
+		if err != nil || updatedActivity == nil {
+			return updatedActivity, err
+		}
+
+		if err := store.startDataMigration(sessCtx, updatedActivity, arg.Migrations, arg.Author); err != nil {
+			return nil, err
+		}
+
+		if !sameUniqueConstraints(arg.Activity, *updatedActivity) {
+			if err := store.startDataUniqueKeysRefresh(sessCtx, updatedActivity); err != nil {
+				return nil, err
+			}
+		}
+		return updatedActivity, nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if updatedActivity, ok := result.(*models.Activity); ok {
+		return updatedActivity, err
+	} else {
+		return nil, err
+	}
+}
+
+type UpdateAddToActivityTxParams struct {
+	Activity       models.Activity
+	OrganizationId primitive.ObjectID
+
+	Position uint
+	Field    string
+	Value    models.ActivityField
+}
+
+// UpdateAddToActivityTx adds the field to the activity. When the field is a new unique constraint, the data
+// are checked against it in the same transaction, and their keys are then refreshed by RefreshDataUniqueKeysTx.
+func (store *MongoStorage) UpdateAddToActivityTx(ctx context.Context, arg UpdateAddToActivityTxParams) (*models.Activity, error) {
+	result, err := store.withTx(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		updatedActivity, err := store.UpdateAddToActivity(sessCtx, UpdateAddToActivityParams{
+			Id:             arg.Activity.Id,
+			OrganizationId: arg.OrganizationId,
+
+			Field:    arg.Field,
+			Value:    arg.Value,
+			Position: arg.Position,
+		})
+		if err != nil || updatedActivity == nil {
+			return updatedActivity, err
+		}
+
+		if !sameUniqueConstraints(arg.Activity, *updatedActivity) {
+			if err := store.startDataUniqueKeysRefresh(sessCtx, updatedActivity); err != nil {
+				return nil, err
+			}
+		}
+		return updatedActivity, nil
 	})
 
 	if err != nil {
@@ -154,6 +216,10 @@ type UpdateRemoveFromActivityTxParams struct {
 	Position uint
 	Field    string
 	// Value interface{}
+
+	// What happens to the values of the existing data, see models.PlanFieldMigrations
+	Migrations []models.FieldMigration
+	Author     models.DataAuthor
 }
 
 func (store *MongoStorage) UpdateRemoveFromActivityTx(ctx context.Context, arg UpdateRemoveFromActivityTxParams) (*models.Activity, error) {
@@ -169,7 +235,7 @@ func (store *MongoStorage) UpdateRemoveFromActivityTx(ctx context.Context, arg U
 					break
 				}
 			}
-			_, err := store.RemoveRelationshipFromActivity(ctx, RemoveRelationshipFromActivityParams{
+			_, err := store.RemoveRelationshipFromActivity(sessCtx, RemoveRelationshipFromActivityParams{
 				Id:             arg.Activity.Id,
 				OrganizationId: arg.OrganizationId,
 
@@ -184,13 +250,27 @@ func (store *MongoStorage) UpdateRemoveFromActivityTx(ctx context.Context, arg U
 			}
 		}
 
-		return store.UpdateRemoveFromActivity(ctx, UpdateRemoveFromActivityParams{
+		updatedActivity, err := store.UpdateRemoveFromActivity(sessCtx, UpdateRemoveFromActivityParams{
 			Id:             arg.Activity.Id,
 			OrganizationId: arg.OrganizationId,
 
 			Field:    arg.Field,
 			Position: arg.Position,
 		})
+		if err != nil || updatedActivity == nil {
+			return updatedActivity, err
+		}
+
+		if err := store.startDataMigration(sessCtx, updatedActivity, arg.Migrations, arg.Author); err != nil {
+			return nil, err
+		}
+
+		if !sameUniqueConstraints(arg.Activity, *updatedActivity) {
+			if err := store.startDataUniqueKeysRefresh(sessCtx, updatedActivity); err != nil {
+				return nil, err
+			}
+		}
+		return updatedActivity, nil
 	})
 
 	if err != nil {
@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"stockinos.com/api/models"
+)
+
+// The last use of a key is not written more often than that
+const apiKeyLastUsedPrecision = time.Minute
+
+type CreateApiKeyParams struct {
+	OrganizationId primitive.ObjectID
+	Name           string
+	Hint           string
+	KeyHash        string
+	Scopes         []models.ApiKeyScope
+	CreatedBy      models.DataAuthor
+	ExpiresAt      *time.Time
+}
+
+func (q *Queries) CreateApiKey(ctx context.Context, arg CreateApiKeyParams) (*models.ApiKey, error) {
+	scopes := arg.Scopes
+	if scopes == nil {
+		scopes = []models.ApiKeyScope{}
+	}
+
+	apiKey := models.ApiKey{
+		Id:             primitive.NewObjectID(),
+		OrganizationId: arg.OrganizationId,
+		Name:           arg.Name,
+		Hint:           arg.Hint,
+		KeyHash:        arg.KeyHash,
+		Scopes:         scopes,
+		CreatedBy:      arg.CreatedBy,
+		CreatedAt:      time.Now(),
+		ExpiresAt:      arg.ExpiresAt,
+	}
+
+	if _, err := q.apiKeysCollection.InsertOne(ctx, apiKey); err != nil {
+		return nil, err
+	}
+	return &apiKey, nil
+}
+
+type GetApiKeysParams struct {
+	OrganizationId primitive.ObjectID
+}
+
+// GetApiKeys returns the keys of the organization not revoked, the latest created first
+func (q *Queries) GetApiKeys(ctx context.Context, arg GetApiKeysParams) ([]*models.ApiKey, error) {
+	apiKeys := make([]*models.ApiKey, 0)
+
+	cursor, err := q.apiKeysCollection.Find(ctx, bson.M{
+		"organization_id": arg.OrganizationId,
+		"revoked_at":      nil,
+	}, options.Find().SetSort(bson.M{"created_at": -1}))
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &apiKeys); err != nil {
+		return nil, err
+	}
+
+	return apiKeys, nil
+}
+
+type GetApiKeyByHashParams struct {
+	KeyHash string
+}
+
+// GetApiKeyByHash returns the key even when revoked or expired, see models.ApiKey.IsActive
+func (q *Queries) GetApiKeyByHash(ctx context.Context, arg GetApiKeyByHashParams) (*models.ApiKey, error) {
+	var apiKey models.ApiKey
+	err := q.apiKeysCollection.FindOne(ctx, bson.M{"key_hash": arg.KeyHash}).Decode(&apiKey)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &apiKey, nil
+}
+
+type RevokeApiKeyParams struct {
+	Id             primitive.ObjectID
+	OrganizationId primitive.ObjectID
+}
+
+func (q *Queries) RevokeApiKey(ctx context.Context, arg RevokeApiKeyParams) (*models.ApiKey, error) {
+	filter := bson.M{
+		"_id":             arg.Id,
+		"organization_id": arg.OrganizationId,
+		"revoked_at":      nil,
+	}
+	update := bson.M{
+		"$set": bson.M{"revoked_at": time.Now()},
+	}
+
+	return CommonUpdateQuery[models.ApiKey](ctx, *q.apiKeysCollection, filter, update)
+}
+
+type TouchApiKeyParams struct {
+	Id primitive.ObjectID
+}
+
+// TouchApiKey records the use of a key
+func (q *Queries) TouchApiKey(ctx context.Context, arg TouchApiKeyParams) error {
+	now := time.Now()
+	_, err := q.apiKeysCollection.UpdateOne(ctx, bson.M{
+		"_id": arg.Id,
+		"$or": bson.A{
+			bson.M{"last_used_at": nil},
+			bson.M{"last_used_at": bson.M{"$lt": now.Add(-apiKeyLastUsedPrecision)}},
+		},
+	}, bson.M{
+		"$set": bson.M{"last_used_at": now},
+	})
+	return err
+}
@@ -2,8 +2,12 @@ package storage
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -13,16 +17,23 @@ import (
 	"stockinos.com/api/models"
 )
 
+// ErrDataNotUnique is returned when a data breaks a unique constraint of its activity
+var ErrDataNotUnique = errors.New("data not unique")
+
 type CreateDataParams struct {
-	Values     map[string]any
-	ActivityId primitive.ObjectID
-	CreatedBy  models.DataAuthor
+	Values        map[string]any
+	UniqueKeys    []string
+	SchemaVersion int
+	ActivityId    primitive.ObjectID
+	CreatedBy     models.DataAuthor
 }
 
 func (q *Queries) CreateData(ctx context.Context, arg CreateDataParams) (*models.Data, error) {
 	var data models.Data = models.Data{
-		Id:     primitive.NewObjectID(),
-		Values: arg.Values,
+		Id:            primitive.NewObjectID(),
+		Values:        arg.Values,
+		UniqueKeys:    arg.UniqueKeys,
+		SchemaVersion: arg.SchemaVersion,
 
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
@@ -34,6 +45,9 @@ func (q *Queries) CreateData(ctx context.Context, arg CreateDataParams) (*models
 
 	_, err := q.datasCollections.InsertOne(ctx, data)
 	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, ErrDataNotUnique
+		}
 		return nil, err
 	} else {
 		return &data, nil
@@ -43,6 +57,8 @@ func (q *Queries) CreateData(ctx context.Context, arg CreateDataParams) (*models
 type GetDataParams struct {
 	Id         primitive.ObjectID
 	ActivityId primitive.ObjectID
+	// Also look for the data in the trash
+	WithDeleted bool
 }
 
 func (q *Queries) GetData(ctx context.Context, arg GetDataParams) (*models.Data, error) {
@@ -51,7 +67,9 @@ func (q *Queries) GetData(ctx context.Context, arg GetDataParams) (*models.Data,
 	filter := bson.M{
 		"_id":         arg.Id,
 		"activity_id": arg.ActivityId,
-		"deleted_at":  nil,
+	}
+	if !arg.WithDeleted {
+		filter["deleted_at"] = nil
 	}
 	err := q.datasCollections.FindOne(ctx, filter).Decode(&data)
 	if err != nil {
@@ -67,6 +85,7 @@ func (q *Queries) GetData(ctx context.Context, arg GetDataParams) (*models.Data,
 type GetDataFilterByValuesParams struct {
 	Values     map[string]any
 	ActivityId primitive.ObjectID
+	ExcludeId  primitive.ObjectID // the data being updated, if any
 }
 
 func (q *Queries) GetDataFilterByValues(ctx context.Context, arg GetDataFilterByValuesParams) (*models.Data, error) {
@@ -78,6 +97,9 @@ func (q *Queries) GetDataFilterByValues(ctx context.Context, arg GetDataFilterBy
 	}
 	filter["activity_id"] = arg.ActivityId
 	filter["deleted_at"] = nil
+	if !arg.ExcludeId.IsZero() {
+		filter["_id"] = bson.M{"$ne": arg.ExcludeId}
+	}
 
 	err := q.datasCollections.FindOne(ctx, filter).Decode(&data)
 	if err != nil {
@@ -90,6 +112,38 @@ func (q *Queries) GetDataFilterByValues(ctx context.Context, arg GetDataFilterBy
 	return &data, nil
 }
 
+type GetLastValueOfAuthorParams struct {
+	ActivityId primitive.ObjectID
+	FieldId    string
+	AuthorId   primitive.ObjectID
+}
+
+// GetLastValueOfAuthor returns the value of the field in the last data created by the author
+// with a value for it, nil when there is none
+func (q *Queries) GetLastValueOfAuthor(ctx context.Context, arg GetLastValueOfAuthorParams) (any, error) {
+	var data models.Data
+
+	field := fmt.Sprintf("values.%s", arg.FieldId)
+	filter := bson.M{
+		"activity_id":    arg.ActivityId,
+		"created_by._id": arg.AuthorId,
+		"deleted_at":     nil,
+		field:            bson.M{"$ne": nil},
+	}
+	opts := options.FindOne().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetProjection(bson.M{field: 1})
+
+	err := q.datasCollections.FindOne(ctx, filter, opts).Decode(&data)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return data.Values[arg.FieldId], nil
+}
+
 type GetAllDataParams struct {
 	ActivityId  primitive.ObjectID
 	Projections map[string]int
@@ -135,9 +189,262 @@ func (q *Queries) GetAllData(ctx context.Context, arg GetAllDataParams) ([]*mode
 	return data, nil
 }
 
+type ForEachDataParams struct {
+	ActivityId primitive.ObjectID
+	FilterBy   map[string]any
+	SortBy     string
+	SortOrder  int
+}
+
+// ForEachData calls fn on each data of the activity, one at a time, without loading them all in memory.
+// It stops at the first error returned by fn.
+func (q *Queries) ForEachData(ctx context.Context, arg ForEachDataParams, fn func(data *models.Data) error) error {
+	filter := bson.M{
+		"activity_id": arg.ActivityId,
+		"deleted_at":  nil,
+	}
+	for key, value := range arg.FilterBy {
+		filter[key] = value
+	}
+
+	opts := options.Find()
+	if arg.SortBy != "" {
+		sortOrder := arg.SortOrder
+		if sortOrder == 0 {
+			sortOrder = 1
+		}
+		opts.SetSort(bson.D{{Key: arg.SortBy, Value: sortOrder}, {Key: "_id", Value: sortOrder}})
+	}
+
+	cursor, err := q.datasCollections.Find(ctx, filter, opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var data models.Data
+		if err := cursor.Decode(&data); err != nil {
+			return err
+		}
+		if err := fn(&data); err != nil {
+			return err
+		}
+	}
+
+	return cursor.Err()
+}
+
+// DataCursor points to a data in a sorted list of data.
+// It holds the value of the sorting field and the id of the data.
+type DataCursor struct {
+	Value    any                `bson:"v"`
+	Id       primitive.ObjectID `bson:"id"`
+	Backward bool               `bson:"b"`
+	// The sort the cursor was made for, it can not be used with another one
+	SortBy    string `bson:"s"`
+	SortOrder int    `bson:"o"`
+}
+
+// EncodeDataCursor makes an opaque string of the cursor, to be shared with the clients.
+// The cursor is signed with the key, so the clients can not forge one.
+func EncodeDataCursor(cursor DataCursor, key []byte) (string, error) {
+	raw, err := bson.Marshal(cursor)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw) + "." + base64.RawURLEncoding.EncodeToString(signDataCursor(raw, key)), nil
+}
+
+// DecodeDataCursor returns the cursor made by EncodeDataCursor with the same key.
+// Its value is compared with the values of the data in the queries, it can only be a scalar or a list of scalars.
+func DecodeDataCursor(encoded string, key []byte) (*DataCursor, error) {
+	encodedRaw, encodedSignature, ok := strings.Cut(encoded, ".")
+	if !ok {
+		return nil, errors.New("unsigned cursor")
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(encodedRaw)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(signature, signDataCursor(raw, key)) {
+		return nil, errors.New("invalid signature of the cursor")
+	}
+
+	var cursor DataCursor
+	if err := bson.Unmarshal(raw, &cursor); err != nil {
+		return nil, err
+	}
+	if cursor.Id.IsZero() || !isDataCursorValue(cursor.Value, true) {
+		return nil, errors.New("invalid cursor")
+	}
+	return &cursor, nil
+}
+
+func signDataCursor(raw []byte, key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(raw)
+	return mac.Sum(nil)
+}
+
+// isDataCursorValue tells if the value of a cursor is a scalar, the documents could hold query operators
+func isDataCursorValue(value any, list bool) bool {
+	switch v := value.(type) {
+	case nil, string, bool, int32, int64, float64, primitive.DateTime, primitive.ObjectID, primitive.Decimal128:
+		return true
+	case primitive.A:
+		if !list {
+			return false
+		}
+		for _, item := range v {
+			if !isDataCursorValue(item, false) {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+// DataSortValue returns the value of the data used for sorting on sortBy
+func DataSortValue(data *models.Data, sortBy string) any {
+	switch sortBy {
+	case "created_at":
+		return data.CreatedAt
+	case "updated_at":
+		return data.UpdatedAt
+	default:
+		return data.Values[strings.TrimPrefix(sortBy, "values.")]
+	}
+}
+
+type GetPaginatedDataParams struct {
+	ActivityId primitive.ObjectID
+	FilterBy   map[string]any
+
+	// SortBy is a field of the document: created_at, updated_at or values.<field id>
+	SortBy    string
+	SortOrder int // 1 for ascending, -1 for descending
+
+	Limit  int64
+	Offset int64
+	Cursor *DataCursor
+}
+
+type PaginatedData struct {
+	Data    []*models.Data
+	Total   int64
+	HasMore bool // There are other data after (or before for a backward cursor) this page
+}
+
+func (q *Queries) GetPaginatedData(ctx context.Context, arg GetPaginatedDataParams) (*PaginatedData, error) {
+	filter := bson.M{
+		"activity_id": arg.ActivityId,
+		"deleted_at":  nil,
+	}
+	for key, value := range arg.FilterBy {
+		filter[key] = value
+	}
+
+	total, err := q.datasCollections.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	sortOrder := arg.SortOrder
+	if sortOrder == 0 {
+		sortOrder = 1
+	}
+	backward := arg.Cursor != nil && arg.Cursor.Backward
+	if backward {
+		sortOrder = -sortOrder
+	}
+
+	pageFilter := filter
+	if arg.Cursor != nil {
+		pageFilter = bson.M{
+			"$and": bson.A{
+				filter,
+				keysetFilter(arg.SortBy, sortOrder, arg.Cursor.Value, arg.Cursor.Id),
+			},
+		}
+	}
+
+	// One more data is requested to know if there is a next page
+	opts := options.Find().
+		SetSort(bson.D{{Key: arg.SortBy, Value: sortOrder}, {Key: "_id", Value: sortOrder}}).
+		SetLimit(arg.Limit + 1)
+	if arg.Cursor == nil && arg.Offset > 0 {
+		opts.SetSkip(arg.Offset)
+	}
+
+	cursor, err := q.datasCollections.Find(ctx, pageFilter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	data := make([]*models.Data, 0)
+	if err = cursor.All(ctx, &data); err != nil {
+		return nil, err
+	}
+
+	hasMore := int64(len(data)) > arg.Limit
+	if hasMore {
+		data = data[:arg.Limit]
+	}
+	if backward {
+		for i, j := 0, len(data)-1; i < j; i, j = i+1, j-1 {
+			data[i], data[j] = data[j], data[i]
+		}
+	}
+
+	return &PaginatedData{
+		Data:    data,
+		Total:   total,
+		HasMore: hasMore,
+	}, nil
+}
+
+// keysetFilter matches the documents placed after (value, id) when sorting on field then _id.
+// Null values are the lowest ones in MongoDB sort order.
+func keysetFilter(field string, sortOrder int, value any, id primitive.ObjectID) bson.M {
+	operator := "$gt"
+	if sortOrder < 0 {
+		operator = "$lt"
+	}
+
+	if value == nil {
+		if sortOrder > 0 {
+			return bson.M{
+				"$or": bson.A{
+					bson.M{field: bson.M{"$ne": nil}},
+					bson.M{field: nil, "_id": bson.M{operator: id}},
+				},
+			}
+		}
+		return bson.M{field: nil, "_id": bson.M{operator: id}}
+	}
+
+	after := bson.A{
+		bson.M{field: bson.M{operator: value}},
+		bson.M{field: value, "_id": bson.M{operator: id}},
+	}
+	if sortOrder < 0 {
+		after = append(after, bson.M{field: nil})
+	}
+	return bson.M{"$or": after}
+}
+
 type DeleteDataParams struct {
 	Id         primitive.ObjectID
 	ActivityId primitive.ObjectID
+	DeletedBy  models.DataAuthor
 }
 
 func (q *Queries) DeleteData(ctx context.Context, arg DeleteDataParams) error {
@@ -148,6 +455,7 @@ func (q *Queries) DeleteData(ctx context.Context, arg DeleteDataParams) error {
 	update := bson.M{
 		"$set": bson.M{
 			"deleted_at": time.Now(),
+			"deleted_by": arg.DeletedBy,
 		},
 	}
 
@@ -167,8 +475,11 @@ type UpdateSetInDataParams struct {
 	Id         primitive.ObjectID
 	ActivityId primitive.ObjectID
 
-	Field string
-	Value interface{}
+	Field         string
+	Value         interface{}
+	Computed      map[string]any // values of the automatic fields and of the hidden fields, a nil value removing it
+	UniqueKeys    []string       // of all the values of the data, once updated
+	SchemaVersion int
 }
 
 func (q *Queries) UpdateSetInData(ctx context.Context, arg UpdateSetInDataParams) (*models.Data, error) {
@@ -182,17 +493,22 @@ func (q *Queries) UpdateSetInData(ctx context.Context, arg UpdateSetInDataParams
 			field: arg.Value,
 		},
 	}
+	setDataComputed(update, arg.Computed)
+	setDataUpdated(update, arg.UniqueKeys, arg.SchemaVersion)
 
-	return CommonUpdateQuery[models.Data](ctx, *q.datasCollections, filter, update)
+	return updateDataQuery(ctx, q, filter, update)
 }
 
 type UpdateAddToDataParams struct {
 	Id         primitive.ObjectID
 	ActivityId primitive.ObjectID
 
-	Position uint
-	Field    string
-	Value    interface{}
+	Position      uint
+	Field         string
+	Value         interface{}
+	Computed      map[string]any // values of the automatic fields and of the hidden fields, a nil value removing it
+	UniqueKeys    []string       // of all the values of the data, once updated
+	SchemaVersion int
 }
 
 func (q *Queries) UpdateAddToData(ctx context.Context, arg UpdateAddToDataParams) (*models.Data, error) {
@@ -211,48 +527,88 @@ func (q *Queries) UpdateAddToData(ctx context.Context, arg UpdateAddToDataParams
 			},
 		},
 	}
+	setDataComputed(update, arg.Computed)
+	setDataUpdated(update, arg.UniqueKeys, arg.SchemaVersion)
 
-	return CommonUpdateQuery[models.Data](ctx, *q.datasCollections, filter, update)
+	return updateDataQuery(ctx, q, filter, update)
 }
 
 type UpdateRemoveFromDataParams struct {
 	Id         primitive.ObjectID
 	ActivityId primitive.ObjectID
 
-	Position uint
-	Field    string
+	Position      uint
+	Field         string
+	Computed      map[string]any // values of the automatic fields and of the hidden fields, a nil value removing it
+	UniqueKeys    []string       // of all the values of the data, once updated
+	SchemaVersion int
 }
 
+// UpdateRemoveFromData removes the item of a list at its position, in a single update so that the list is never
+// left with a hole. No data is returned when the list has no item at the position anymore.
 func (q *Queries) UpdateRemoveFromData(ctx context.Context, arg UpdateRemoveFromDataParams) (*models.Data, error) {
-	field := fmt.Sprintf("values.%s", arg.Field)
-	fieldWithPosition := fmt.Sprintf("%s.%d", field, arg.Position)
+	list := fmt.Sprintf("$values.%s", arg.Field)
 	filter := bson.M{
 		"_id":         arg.Id,
 		"activity_id": arg.ActivityId,
+		"$expr": bson.M{"$gt": bson.A{
+			bson.M{"$size": bson.M{"$cond": bson.A{bson.M{"$isArray": list}, list, bson.A{}}}},
+			arg.Position,
+		}},
 	}
-	update := bson.M{
-		"$unset": bson.M{
-			fieldWithPosition: 1,
-		},
+
+	var head interface{} = bson.A{}
+	if arg.Position > 0 {
+		head = bson.M{"$slice": bson.A{list, arg.Position}}
 	}
-	_, err := CommonUpdateQuery[models.Data](ctx, *q.datasCollections, filter, update)
-	if err != nil {
-		return nil, err
+	tail := bson.M{"$slice": bson.A{list, arg.Position + 1, bson.M{"$size": list}}}
+	set := bson.M{
+		fmt.Sprintf("values.%s", arg.Field): bson.M{"$concatArrays": bson.A{head, tail}},
 	}
 
-	update = bson.M{
-		"$pull": bson.M{
-			field: nil,
-		},
+	// In a pipeline, a text starting with $ would be read as a field: the other values are written as literals
+	written := bson.M{}
+	setDataComputed(written, arg.Computed)
+	setDataUpdated(written, arg.UniqueKeys, arg.SchemaVersion)
+	for key, value := range written["$set"].(bson.M) {
+		set[key] = bson.M{"$literal": value}
+	}
+	pipeline := bson.A{bson.M{"$set": set}}
+	if unset, ok := written["$unset"].(bson.M); ok {
+		fields := make(bson.A, 0, len(unset))
+		for key := range unset {
+			fields = append(fields, key)
+		}
+		pipeline = append(pipeline, bson.M{"$unset": fields})
+	}
+
+	returnAfter := options.After
+	var data models.Data
+	err := q.datasCollections.FindOneAndUpdate(ctx, filter, pipeline, &options.FindOneAndUpdateOptions{
+		ReturnDocument: &returnAfter,
+	}).Decode(&data)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, ErrDataNotUnique
+	}
+	if err != nil {
+		return nil, err
 	}
-	return CommonUpdateQuery[models.Data](ctx, *q.datasCollections, filter, update)
+	return &data, nil
 }
 
 type UpdateDataParams struct {
 	Id         primitive.ObjectID
 	ActivityId primitive.ObjectID
 
-	Values map[string]any
+	Values        map[string]any
+	UniqueKeys    []string // of all the values of the data, once updated
+	SchemaVersion int
+	// Replace all the values by the given ones, instead of only updating them.
+	// Without it, a nil value removes the value of the field.
+	Replace bool
 }
 
 func (q *Queries) UpdateData(ctx context.Context, arg UpdateDataParams) (*models.Data, error) {
@@ -262,13 +618,75 @@ func (q *Queries) UpdateData(ctx context.Context, arg UpdateDataParams) (*models
 	}
 
 	set := bson.M{}
-	for key, value := range arg.Values {
-		field := fmt.Sprintf("values.%s", key)
-		set[field] = value
+	unset := bson.M{}
+	if arg.Replace {
+		set["values"] = arg.Values
+	} else {
+		for key, value := range arg.Values {
+			field := fmt.Sprintf("values.%s", key)
+			if value == nil {
+				unset[field] = ""
+			} else {
+				set[field] = value
+			}
+		}
 	}
 	update := bson.M{
 		"$set": set,
 	}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	setDataUpdated(update, arg.UniqueKeys, arg.SchemaVersion)
+
+	return updateDataQuery(ctx, q, filter, update)
+}
+
+// setDataComputed completes an update of a value of a data with the values of the automatic fields
+func setDataComputed(update bson.M, computed map[string]any) {
+	for key, value := range computed {
+		operator := "$set"
+		if value == nil {
+			operator = "$unset"
+			value = ""
+		}
+		fields, ok := update[operator].(bson.M)
+		if !ok {
+			fields = bson.M{}
+			update[operator] = fields
+		}
+		fields[fmt.Sprintf("values.%s", key)] = value
+	}
+}
+
+// setDataUpdated completes an update of the values of a data with its update date,
+// the version of the fields it is written with and its unique keys
+func setDataUpdated(update bson.M, uniqueKeys []string, schemaVersion int) {
+	set, ok := update["$set"].(bson.M)
+	if !ok {
+		set = bson.M{}
+		update["$set"] = set
+	}
+	set["updated_at"] = time.Now()
+	set["schema_version"] = schemaVersion
+
+	if len(uniqueKeys) > 0 {
+		set["unique_keys"] = uniqueKeys
+		return
+	}
 
-	return CommonUpdateQuery[models.Data](ctx, *q.datasCollections, filter, update)
+	unset, ok := update["$unset"].(bson.M)
+	if !ok {
+		unset = bson.M{}
+		update["$unset"] = unset
+	}
+	unset["unique_keys"] = ""
+}
+
+func updateDataQuery(ctx context.Context, q *Queries, filter, update bson.M) (*models.Data, error) {
+	data, err := CommonUpdateQuery[models.Data](ctx, *q.datasCollections, filter, update)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, ErrDataNotUnique
+	}
+	return data, err
 }
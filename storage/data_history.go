@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"stockinos.com/api/models"
+)
+
+type AddDataHistoryParams struct {
+	DataId     primitive.ObjectID
+	ActivityId primitive.ObjectID
+
+	Action string
+	// Values of the data before and after the action, nil when the data does not exist
+	Before map[string]any
+	After  map[string]any
+
+	RestoredFrom *primitive.ObjectID
+	Author       models.DataAuthor
+}
+
+func newDataHistory(arg AddDataHistoryParams) models.DataHistory {
+	return models.DataHistory{
+		Id:         primitive.NewObjectID(),
+		DataId:     arg.DataId,
+		ActivityId: arg.ActivityId,
+
+		Action:       arg.Action,
+		Changes:      models.DiffValues(arg.Before, arg.After),
+		Values:       arg.After,
+		RestoredFrom: arg.RestoredFrom,
+
+		Author:    arg.Author,
+		CreatedAt: time.Now(),
+	}
+}
+
+func (q *Queries) AddDataHistory(ctx context.Context, arg AddDataHistoryParams) (*models.DataHistory, error) {
+	history := newDataHistory(arg)
+
+	_, err := q.dataHistoriesCollection.InsertOne(ctx, history)
+	if err != nil {
+		return nil, err
+	} else {
+		return &history, nil
+	}
+}
+
+type GetDataHistoryParams struct {
+	DataId     primitive.ObjectID
+	ActivityId primitive.ObjectID
+}
+
+// GetDataHistory returns the history of a data, the latest entry first
+func (q *Queries) GetDataHistory(ctx context.Context, arg GetDataHistoryParams) ([]*models.DataHistory, error) {
+	var history []*models.DataHistory
+
+	filter := bson.M{
+		"data_id":     arg.DataId,
+		"activity_id": arg.ActivityId,
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}})
+
+	cursor, err := q.dataHistoriesCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	if err = cursor.All(ctx, &history); err != nil {
+		return nil, err
+	}
+	if history == nil {
+		return []*models.DataHistory{}, nil
+	}
+	return history, nil
+}
+
+type GetDataHistoryEntryParams struct {
+	Id         primitive.ObjectID
+	DataId     primitive.ObjectID
+	ActivityId primitive.ObjectID
+}
+
+func (q *Queries) GetDataHistoryEntry(ctx context.Context, arg GetDataHistoryEntryParams) (*models.DataHistory, error) {
+	var history models.DataHistory
+
+	filter := bson.M{
+		"_id":         arg.Id,
+		"data_id":     arg.DataId,
+		"activity_id": arg.ActivityId,
+	}
+	err := q.dataHistoriesCollection.FindOne(ctx, filter).Decode(&history)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil
+		} else {
+			return nil, err
+		}
+	}
+	return &history, nil
+}
@@ -0,0 +1,292 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"stockinos.com/api/models"
+)
+
+// ErrMigrationRejected is returned when a field can not change because data have a value for it
+var ErrMigrationRejected = errors.New("migration rejected")
+
+// ErrMigrationPending is returned when a field can not change because the data are still migrated
+// after a previous change
+var ErrMigrationPending = errors.New("migration pending")
+
+// forEachDataToMigrate calls fn on each data having a value for one of the migrated fields
+func forEachDataToMigrate(ctx context.Context, collection *mongo.Collection, activityId primitive.ObjectID, migrations []models.FieldMigration, fn func(data *models.Data) error) error {
+	conditions := make(bson.A, len(migrations))
+	for i, migration := range migrations {
+		conditions[i] = bson.M{fmt.Sprintf("values.%s", migration.FieldId): bson.M{"$ne": nil}}
+	}
+
+	cursor, err := collection.Find(ctx, bson.M{
+		"activity_id": activityId,
+		"deleted_at":  nil,
+		"$or":         conditions,
+	})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var data models.Data
+		if err := cursor.Decode(&data); err != nil {
+			return err
+		}
+		if err := fn(&data); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+type PreviewDataMigrationParams struct {
+	ActivityId primitive.ObjectID
+	Migrations []models.FieldMigration
+}
+
+// PreviewDataMigration counts the data each migration would change, without changing them
+func (q *Queries) PreviewDataMigration(ctx context.Context, arg PreviewDataMigrationParams) ([]models.FieldMigrationImpact, error) {
+	impacts := make([]models.FieldMigrationImpact, len(arg.Migrations))
+	for i, migration := range arg.Migrations {
+		impacts[i].FieldMigration = migration
+	}
+	if len(arg.Migrations) == 0 {
+		return impacts, nil
+	}
+
+	err := forEachDataToMigrate(ctx, q.datasCollections, arg.ActivityId, arg.Migrations, func(data *models.Data) error {
+		for i, migration := range arg.Migrations {
+			value := data.Values[migration.FieldId]
+			if value == nil {
+				continue
+			}
+
+			impacts[i].WithValue++
+			switch _, kept := migration.Migrate(value); {
+			case migration.Strategy == models.MigrationReject:
+				impacts[i].Rejected = true
+			case kept:
+				impacts[i].Converted++
+			default:
+				impacts[i].Cleared++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return impacts, nil
+}
+
+// startDataMigration checks the migrations can be applied and saves them on the activity once its fields updated,
+// the values of its data are then migrated by MigrateDataTx
+func (store *MongoStorage) startDataMigration(sessCtx mongo.SessionContext, activity *models.Activity, migrations []models.FieldMigration, author models.DataAuthor) error {
+	if len(migrations) == 0 {
+		return nil
+	}
+	if activity.Migration != nil {
+		return ErrMigrationPending
+	}
+
+	for _, migration := range migrations {
+		if migration.Strategy != models.MigrationReject {
+			continue
+		}
+		count, err := store.datasCollections.CountDocuments(sessCtx, bson.M{
+			"activity_id": activity.Id,
+			"deleted_at":  nil,
+			fmt.Sprintf("values.%s", migration.FieldId): bson.M{"$ne": nil},
+		})
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrMigrationRejected
+		}
+	}
+
+	now := time.Now()
+	migration := models.ActivityMigration{
+		Version:    activity.Version,
+		Migrations: migrations,
+		Author:     author,
+		StartedAt:  now,
+		UpdatedAt:  now,
+	}
+	_, err := store.activitiesCollection.UpdateOne(sessCtx, bson.M{
+		"_id": activity.Id,
+	}, bson.M{
+		"$set": bson.M{"migration": migration},
+	})
+	if err != nil {
+		return err
+	}
+
+	activity.Migration = &migration
+	return nil
+}
+
+type MigrateDataTxParams struct {
+	Activity models.Activity
+	// How many data are migrated in each transaction, defaultCreateManyDataBatchSize when 0
+	BatchSize int
+}
+
+// MigrateDataTx applies the pending migration of the activity to the values of its data, batch by batch.
+// Each data changed is stamped with the new version of the fields and added to its history, and the
+// progress is saved with each batch, so a failed migration resumes after the last batch done.
+func (store *MongoStorage) MigrateDataTx(ctx context.Context, arg MigrateDataTxParams) error {
+	if arg.Activity.Migration == nil {
+		return nil
+	}
+	if arg.BatchSize <= 0 {
+		arg.BatchSize = defaultCreateManyDataBatchSize
+	}
+
+	migration := *arg.Activity.Migration
+	migration.Migrations = models.BindFieldMigrations(migration.Migrations, arg.Activity.Fields)
+	for {
+		result, err := store.withTx(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+			return store.migrateDataBatch(sessCtx, arg.Activity, migration, arg.BatchSize)
+		})
+		if err != nil {
+			return err
+		}
+
+		lastDataId, _ := result.(primitive.ObjectID)
+		if lastDataId.IsZero() {
+			return nil
+		}
+		migration.LastDataId = lastDataId
+	}
+}
+
+// migrateDataBatch migrates the data following the last one migrated, and returns the id of the last data
+// of the batch, or a zero id once all the data migrated and the migration removed from the activity
+func (store *MongoStorage) migrateDataBatch(sessCtx mongo.SessionContext, activity models.Activity, migration models.ActivityMigration, batchSize int) (primitive.ObjectID, error) {
+	conditions := make(bson.A, len(migration.Migrations))
+	for i, m := range migration.Migrations {
+		conditions[i] = bson.M{fmt.Sprintf("values.%s", m.FieldId): bson.M{"$ne": nil}}
+	}
+
+	cursor, err := store.datasCollections.Find(sessCtx, bson.M{
+		"_id":            bson.M{"$gt": migration.LastDataId},
+		"activity_id":    activity.Id,
+		"deleted_at":     nil,
+		"schema_version": bson.M{"$not": bson.M{"$gte": migration.Version}},
+		"$or":            conditions,
+	}, options.Find().SetSort(bson.M{"_id": 1}).SetLimit(int64(batchSize)))
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+
+	dataToMigrate := make([]models.Data, 0, batchSize)
+	if err := cursor.All(sessCtx, &dataToMigrate); err != nil {
+		return primitive.NilObjectID, err
+	}
+
+	updates := make([]mongo.WriteModel, 0, len(dataToMigrate))
+	histories := make([]interface{}, 0, len(dataToMigrate))
+	for _, data := range dataToMigrate {
+		values := migration.MigrateValues(data.Values)
+
+		set := bson.M{}
+		unset := bson.M{}
+		for _, m := range migration.Migrations {
+			field := fmt.Sprintf("values.%s", m.FieldId)
+			if value, kept := values[m.FieldId]; kept {
+				set[field] = value
+			} else {
+				unset[field] = ""
+			}
+		}
+
+		update := bson.M{"$set": set}
+		if len(unset) > 0 {
+			update["$unset"] = unset
+		}
+		setDataUpdated(update, activity.UniqueKeys(values), migration.Version)
+		updates = append(updates, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": data.Id}).
+			SetUpdate(update))
+		histories = append(histories, newDataHistory(AddDataHistoryParams{
+			DataId:     data.Id,
+			ActivityId: activity.Id,
+			Action:     models.DataHistoryMigrated,
+			Before:     data.Values,
+			After:      values,
+			Author:     migration.Author,
+		}))
+	}
+
+	if len(updates) > 0 {
+		_, err := store.datasCollections.BulkWrite(sessCtx, updates)
+		if mongo.IsDuplicateKeyError(err) {
+			return primitive.NilObjectID, ErrDataNotUnique
+		}
+		if err != nil {
+			return primitive.NilObjectID, err
+		}
+		if _, err := store.dataHistoriesCollection.InsertMany(sessCtx, histories); err != nil {
+			return primitive.NilObjectID, err
+		}
+	}
+
+	if len(dataToMigrate) < batchSize {
+		_, err := store.activitiesCollection.UpdateOne(sessCtx, bson.M{
+			"_id": activity.Id,
+		}, bson.M{
+			"$unset": bson.M{"migration": ""},
+		})
+		return primitive.NilObjectID, err
+	}
+
+	lastDataId := dataToMigrate[len(dataToMigrate)-1].Id
+	_, err = store.activitiesCollection.UpdateOne(sessCtx, bson.M{
+		"_id": activity.Id,
+	}, bson.M{
+		"$set": bson.M{
+			"migration.last_data_id": lastDataId,
+			"migration.updated_at":   time.Now(),
+		},
+	})
+	return lastDataId, err
+}
+
+type GetActivitiesWithPendingMigrationParams struct {
+	// Only the migrations without progress since then, the others may still be running
+	UpdatedBefore time.Time
+}
+
+// GetActivitiesWithPendingMigration returns the activities whose migration of the data,
+// or refresh of the unique keys of the data, stopped before its end
+func (q *Queries) GetActivitiesWithPendingMigration(ctx context.Context, arg GetActivitiesWithPendingMigrationParams) ([]*models.Activity, error) {
+	cursor, err := q.activitiesCollection.Find(ctx, bson.M{
+		"$or": bson.A{
+			bson.M{"migration.updated_at": bson.M{"$lt": arg.UpdatedBefore}},
+			bson.M{"unique_keys_refresh.updated_at": bson.M{"$lt": arg.UpdatedBefore}},
+		},
+		"deleted_at": nil,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	activities := make([]*models.Activity, 0)
+	if err := cursor.All(ctx, &activities); err != nil {
+		return nil, err
+	}
+	return activities, nil
+}
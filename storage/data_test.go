@@ -57,7 +57,7 @@ func testCreateData(t *testing.T, db *storage.Database) {
 		},
 
 		ActivityId: primitive.NewObjectID(),
-		CreatedBy:  primitive.NewObjectID(),
+		CreatedBy:  models.DataAuthor{Id: primitive.NewObjectID()},
 	}
 	data, err := db.Storage.CreateData(context.Background(), arg)
 	if err != nil {
@@ -97,7 +97,7 @@ func testDeleteData(t *testing.T, db *storage.Database) {
 		},
 
 		ActivityId: primitive.NewObjectID(),
-		CreatedBy:  primitive.NewObjectID(),
+		CreatedBy:  models.DataAuthor{Id: primitive.NewObjectID()},
 	}
 	data, err := db.Storage.CreateData(context.Background(), arg)
 	if err != nil {
@@ -158,7 +158,7 @@ func testUpdateData(t *testing.T, db *storage.Database) {
 		},
 
 		ActivityId: primitive.NewObjectID(),
-		CreatedBy:  primitive.NewObjectID(),
+		CreatedBy:  models.DataAuthor{Id: primitive.NewObjectID()},
 	}
 	data, err := db.Storage.CreateData(context.Background(), arg)
 	if err != nil {
@@ -314,6 +314,13 @@ func testUpdateData(t *testing.T, db *storage.Database) {
 		if err = dataEq(got, updated); err != nil {
 			t.Fatalf("GetData(): %v", err.Error())
 		}
+
+		// the list has no item at the position anymore
+		argForUpdate.Position = uint(len(imagesUpdated))
+		missing, err := db.Storage.UpdateRemoveFromData(context.Background(), argForUpdate)
+		if err != nil || missing != nil {
+			t.Fatalf("UpdateRemoveFromData(): got %+v, %v; want no data after the end of the list", missing, err)
+		}
 	})
 
 }
@@ -335,7 +342,7 @@ func testGetAllData(t *testing.T, db *storage.Database) {
 			},
 
 			ActivityId: activityA,
-			CreatedBy:  primitive.NewObjectID(),
+			CreatedBy:  models.DataAuthor{Id: primitive.NewObjectID()},
 		})
 		datas = append(datas, data)
 	}
@@ -411,11 +418,11 @@ func dataEq(got, want *models.Data) error {
 	}
 
 	if got.CreatedBy != want.CreatedBy {
-		return fmt.Errorf("CreatedBy - got %s; want %s", got.CreatedBy, want.CreatedBy)
+		return fmt.Errorf("CreatedBy - got %v; want %v", got.CreatedBy, want.CreatedBy)
 	}
 
 	if got.ActivityId != want.ActivityId {
-		return fmt.Errorf("ActivityId - got %s; want %s", got.CreatedBy, want.CreatedBy)
+		return fmt.Errorf("ActivityId - got %s; want %s", got.ActivityId, want.ActivityId)
 	}
 
 	return nil
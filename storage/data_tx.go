@@ -0,0 +1,200 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"stockinos.com/api/models"
+)
+
+const defaultCreateManyDataBatchSize = 500
+
+type CreateManyDataTxParams struct {
+	Values        []map[string]any
+	UniqueKeys    [][]string // the unique keys of each item of Values
+	SchemaVersion int
+	ActivityId    primitive.ObjectID
+	CreatedBy     models.DataAuthor
+	BatchSize     int
+}
+
+// CreateManyDataTx inserts all the data, batch by batch, in a single transaction:
+// either all the data are created, or none.
+func (store *MongoStorage) CreateManyDataTx(ctx context.Context, arg CreateManyDataTxParams) (int, error) {
+	batchSize := arg.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultCreateManyDataBatchSize
+	}
+
+	result, err := store.withTx(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		now := time.Now()
+		created := 0
+
+		for start := 0; start < len(arg.Values); start += batchSize {
+			end := start + batchSize
+			if end > len(arg.Values) {
+				end = len(arg.Values)
+			}
+
+			documents := make([]interface{}, 0, end-start)
+			histories := make([]interface{}, 0, end-start)
+			for i := start; i < end; i++ {
+				var uniqueKeys []string
+				if i < len(arg.UniqueKeys) {
+					uniqueKeys = arg.UniqueKeys[i]
+				}
+
+				data := models.Data{
+					Id:            primitive.NewObjectID(),
+					Values:        arg.Values[i],
+					UniqueKeys:    uniqueKeys,
+					SchemaVersion: arg.SchemaVersion,
+
+					CreatedAt: now,
+					UpdatedAt: now,
+
+					ActivityId: arg.ActivityId,
+					CreatedBy:  arg.CreatedBy,
+				}
+				documents = append(documents, data)
+				histories = append(histories, newDataHistory(AddDataHistoryParams{
+					DataId:     data.Id,
+					ActivityId: data.ActivityId,
+					Action:     models.DataHistoryImported,
+					After:      data.Values,
+					Author:     data.CreatedBy,
+				}))
+			}
+
+			inserted, err := store.datasCollections.InsertMany(sessCtx, documents)
+			if err != nil {
+				if mongo.IsDuplicateKeyError(err) {
+					return nil, ErrDataNotUnique
+				}
+				return nil, err
+			}
+			created += len(inserted.InsertedIDs)
+
+			if _, err := store.dataHistoriesCollection.InsertMany(sessCtx, histories); err != nil {
+				return nil, err
+			}
+		}
+
+		return created, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	created, _ := result.(int)
+	return created, nil
+}
+
+// withDataHistory writes a data and adds the entry of its history in the same transaction, the entry
+// being completed with the data once written. Nothing is added when the data is not found.
+func (store *MongoStorage) withDataHistory(ctx context.Context, history AddDataHistoryParams, write func(sessCtx mongo.SessionContext) (*models.Data, error)) (*models.Data, error) {
+	result, err := store.withTx(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		data, err := write(sessCtx)
+		if err != nil || data == nil {
+			return data, err
+		}
+
+		history.DataId = data.Id
+		history.ActivityId = data.ActivityId
+		history.After = data.Values
+		if _, err := store.AddDataHistory(sessCtx, history); err != nil {
+			return nil, err
+		}
+		return data, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	data, _ := result.(*models.Data)
+	return data, nil
+}
+
+// CreateDataTx creates a data along with the first entry of its history
+func (store *MongoStorage) CreateDataTx(ctx context.Context, arg CreateDataParams) (*models.Data, error) {
+	history := AddDataHistoryParams{
+		Action: models.DataHistoryCreated,
+		Author: arg.CreatedBy,
+	}
+	return store.withDataHistory(ctx, history, func(sessCtx mongo.SessionContext) (*models.Data, error) {
+		return store.CreateData(sessCtx, arg)
+	})
+}
+
+type UpdateDataTxParams struct {
+	UpdateDataParams
+	// Entry added to the history: its action, its author and the values of the data before the change.
+	// The data and its values after the change are set once written.
+	History AddDataHistoryParams
+}
+
+func (store *MongoStorage) UpdateDataTx(ctx context.Context, arg UpdateDataTxParams) (*models.Data, error) {
+	return store.withDataHistory(ctx, arg.History, func(sessCtx mongo.SessionContext) (*models.Data, error) {
+		return store.UpdateData(sessCtx, arg.UpdateDataParams)
+	})
+}
+
+type UpdateSetInDataTxParams struct {
+	UpdateSetInDataParams
+	History AddDataHistoryParams // see UpdateDataTxParams
+}
+
+func (store *MongoStorage) UpdateSetInDataTx(ctx context.Context, arg UpdateSetInDataTxParams) (*models.Data, error) {
+	return store.withDataHistory(ctx, arg.History, func(sessCtx mongo.SessionContext) (*models.Data, error) {
+		return store.UpdateSetInData(sessCtx, arg.UpdateSetInDataParams)
+	})
+}
+
+type UpdateAddToDataTxParams struct {
+	UpdateAddToDataParams
+	History AddDataHistoryParams // see UpdateDataTxParams
+}
+
+func (store *MongoStorage) UpdateAddToDataTx(ctx context.Context, arg UpdateAddToDataTxParams) (*models.Data, error) {
+	return store.withDataHistory(ctx, arg.History, func(sessCtx mongo.SessionContext) (*models.Data, error) {
+		return store.UpdateAddToData(sessCtx, arg.UpdateAddToDataParams)
+	})
+}
+
+type UpdateRemoveFromDataTxParams struct {
+	UpdateRemoveFromDataParams
+	History AddDataHistoryParams // see UpdateDataTxParams
+}
+
+// UpdateRemoveFromDataTx removes the item of a list of the data, and adds the change to its history
+func (store *MongoStorage) UpdateRemoveFromDataTx(ctx context.Context, arg UpdateRemoveFromDataTxParams) (*models.Data, error) {
+	return store.withDataHistory(ctx, arg.History, func(sessCtx mongo.SessionContext) (*models.Data, error) {
+		return store.UpdateRemoveFromData(sessCtx, arg.UpdateRemoveFromDataParams)
+	})
+}
+
+type DeleteDataTxParams struct {
+	DeleteDataParams
+	// values of the data when deleted
+	Before map[string]any
+}
+
+// DeleteDataTx moves a data to the trash, and adds the deletion to its history
+func (store *MongoStorage) DeleteDataTx(ctx context.Context, arg DeleteDataTxParams) error {
+	_, err := store.withTx(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		if err := store.DeleteData(sessCtx, arg.DeleteDataParams); err != nil {
+			return nil, err
+		}
+
+		return store.AddDataHistory(sessCtx, AddDataHistoryParams{
+			DataId:     arg.Id,
+			ActivityId: arg.ActivityId,
+			Action:     models.DataHistoryDeleted,
+			Before:     arg.Before,
+			Author:     arg.DeletedBy,
+		})
+	})
+	return err
+}
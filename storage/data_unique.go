@@ -0,0 +1,255 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"stockinos.com/api/models"
+)
+
+func sameUniqueConstraints(activity, updatedActivity models.Activity) bool {
+	return reflect.DeepEqual(activity.UniqueConstraints(), updatedActivity.UniqueConstraints())
+}
+
+// uniqueConstraintsConditions returns a condition for each constraint of the activity, matching the data
+// having all the values of the constraint: like in the keys, the data missing one are never duplicates
+func uniqueConstraintsConditions(constraints []models.UniqueConstraint) bson.A {
+	conditions := make(bson.A, len(constraints))
+	for i, constraint := range constraints {
+		condition := bson.M{}
+		for _, fieldId := range constraint.FieldIds {
+			condition[fmt.Sprintf("values.%s", fieldId)] = bson.M{"$ne": nil}
+		}
+		conditions[i] = condition
+	}
+	return conditions
+}
+
+// checkDataUniqueConstraints groups the data of the activity by the values of each of its unique constraints,
+// and fails with ErrDataNotUnique when two data share the values of one
+func (store *MongoStorage) checkDataUniqueConstraints(sessCtx mongo.SessionContext, activity models.Activity) error {
+	constraints := activity.UniqueConstraints()
+	conditions := uniqueConstraintsConditions(constraints)
+	for i, constraint := range constraints {
+		match := conditions[i].(bson.M)
+		match["activity_id"] = activity.Id
+		match["deleted_at"] = nil
+
+		key := bson.M{}
+		for _, fieldId := range constraint.FieldIds {
+			key[fieldId] = fmt.Sprintf("$values.%s", fieldId)
+		}
+
+		cursor, err := store.datasCollections.Aggregate(sessCtx, mongo.Pipeline{
+			{{Key: "$match", Value: match}},
+			{{Key: "$group", Value: bson.M{"_id": key, "count": bson.M{"$sum": 1}}}},
+			{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+			{{Key: "$limit", Value: 1}},
+		})
+		if err != nil {
+			return err
+		}
+		duplicated := cursor.Next(sessCtx)
+		err = cursor.Err()
+		cursor.Close(sessCtx)
+		if err != nil {
+			return err
+		}
+		if duplicated {
+			return ErrDataNotUnique
+		}
+	}
+	return nil
+}
+
+// startDataUniqueKeysRefresh checks the data follow the unique constraints of the activity and saves on the activity,
+// once its fields updated, the refresh of the keys of its data, done by RefreshDataUniqueKeysTx.
+// A refresh still in progress after a previous change starts again from the first data.
+func (store *MongoStorage) startDataUniqueKeysRefresh(sessCtx mongo.SessionContext, activity *models.Activity) error {
+	if err := store.checkDataUniqueConstraints(sessCtx, *activity); err != nil {
+		return err
+	}
+
+	// the refresh is found by its start, at the precision of the dates stored
+	now := time.Now().Truncate(time.Millisecond)
+	refresh := models.UniqueKeysRefresh{
+		StartedAt: now,
+		UpdatedAt: now,
+	}
+	_, err := store.activitiesCollection.UpdateOne(sessCtx, bson.M{
+		"_id": activity.Id,
+	}, bson.M{
+		"$set": bson.M{"unique_keys_refresh": refresh},
+	})
+	if err != nil {
+		return err
+	}
+
+	activity.UniqueKeysRefresh = &refresh
+	return nil
+}
+
+type StartDataUniqueKeysRefreshTxParams struct {
+	Activity models.Activity
+}
+
+// StartDataUniqueKeysRefreshTx starts the refresh of the unique keys of the data of the activity, whose fields
+// did not change, and returns the activity with the refresh to give to RefreshDataUniqueKeysTx.
+// It fails with ErrDataNotUnique when existing data break the constraints.
+func (store *MongoStorage) StartDataUniqueKeysRefreshTx(ctx context.Context, arg StartDataUniqueKeysRefreshTxParams) (*models.Activity, error) {
+	activity := arg.Activity
+	_, err := store.withTx(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		return nil, store.startDataUniqueKeysRefresh(sessCtx, &activity)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &activity, nil
+}
+
+type RefreshDataUniqueKeysTxParams struct {
+	Activity models.Activity
+	// How many data are refreshed in each transaction, defaultCreateManyDataBatchSize when 0
+	BatchSize int
+}
+
+// RefreshDataUniqueKeysTx computes again the unique keys of the data of the activity once its unique constraints
+// changed, batch by batch. The progress is saved with each batch, so a failed refresh resumes after the last batch done.
+func (store *MongoStorage) RefreshDataUniqueKeysTx(ctx context.Context, arg RefreshDataUniqueKeysTxParams) error {
+	if arg.Activity.UniqueKeysRefresh == nil {
+		return nil
+	}
+	if arg.BatchSize <= 0 {
+		arg.BatchSize = defaultCreateManyDataBatchSize
+	}
+
+	refresh := *arg.Activity.UniqueKeysRefresh
+	for {
+		result, err := store.withTx(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+			return store.refreshDataUniqueKeysBatch(sessCtx, arg.Activity, refresh, arg.BatchSize)
+		})
+		if err != nil {
+			return err
+		}
+
+		lastDataId, _ := result.(primitive.ObjectID)
+		if lastDataId.IsZero() {
+			return nil
+		}
+		refresh.LastDataId = lastDataId
+	}
+}
+
+// refreshDataUniqueKeysBatch refreshes the keys of the data following the last one refreshed, and returns the id
+// of the last data of the batch, or a zero id once all the data refreshed and the refresh removed from the activity.
+// A refresh started again by another change of the constraints is left to the new one.
+func (store *MongoStorage) refreshDataUniqueKeysBatch(sessCtx mongo.SessionContext, activity models.Activity, refresh models.UniqueKeysRefresh, batchSize int) (primitive.ObjectID, error) {
+	cursor, err := store.datasCollections.Find(sessCtx, bson.M{
+		"_id":         bson.M{"$gt": refresh.LastDataId},
+		"activity_id": activity.Id,
+		"deleted_at":  nil,
+	}, options.Find().
+		SetSort(bson.M{"_id": 1}).
+		SetLimit(int64(batchSize)).
+		SetProjection(bson.M{"values": 1}))
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+
+	dataToRefresh := make([]models.Data, 0, batchSize)
+	if err := cursor.All(sessCtx, &dataToRefresh); err != nil {
+		return primitive.NilObjectID, err
+	}
+
+	activityFilter := bson.M{
+		"_id":                            activity.Id,
+		"unique_keys_refresh.started_at": refresh.StartedAt,
+	}
+	var progress bson.M
+	lastDataId := primitive.NilObjectID
+	if len(dataToRefresh) < batchSize {
+		progress = bson.M{"$unset": bson.M{"unique_keys_refresh": ""}}
+	} else {
+		lastDataId = dataToRefresh[len(dataToRefresh)-1].Id
+		progress = bson.M{"$set": bson.M{
+			"unique_keys_refresh.last_data_id": lastDataId,
+			"unique_keys_refresh.updated_at":   time.Now(),
+		}}
+	}
+	result, err := store.activitiesCollection.UpdateOne(sessCtx, activityFilter, progress)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	if result.MatchedCount == 0 {
+		return primitive.NilObjectID, nil
+	}
+
+	updates := make([]mongo.WriteModel, len(dataToRefresh))
+	for i, data := range dataToRefresh {
+		update := bson.M{"$unset": bson.M{"unique_keys": ""}}
+		if uniqueKeys := activity.UniqueKeys(data.Values); len(uniqueKeys) > 0 {
+			update = bson.M{"$set": bson.M{"unique_keys": uniqueKeys}}
+		}
+		updates[i] = mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": data.Id}).
+			SetUpdate(update)
+	}
+	if len(updates) > 0 {
+		_, err := store.datasCollections.BulkWrite(sessCtx, updates)
+		if mongo.IsDuplicateKeyError(err) {
+			return primitive.NilObjectID, ErrDataNotUnique
+		}
+		if err != nil {
+			return primitive.NilObjectID, err
+		}
+	}
+
+	return lastDataId, nil
+}
+
+// GetActivitiesWithLegacyUniqueKeys returns the activities with unique constraints having data created
+// before the unique keys, which have none
+func (q *Queries) GetActivitiesWithLegacyUniqueKeys(ctx context.Context) ([]*models.Activity, error) {
+	cursor, err := q.activitiesCollection.Find(ctx, bson.M{
+		"deleted_at":          nil,
+		"unique_keys_refresh": bson.M{"$exists": false},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	activities := make([]*models.Activity, 0)
+	for cursor.Next(ctx) {
+		var activity models.Activity
+		if err := cursor.Decode(&activity); err != nil {
+			return nil, err
+		}
+		constraints := activity.UniqueConstraints()
+		if len(constraints) == 0 {
+			continue
+		}
+
+		err := q.datasCollections.FindOne(ctx, bson.M{
+			"activity_id": activity.Id,
+			"deleted_at":  nil,
+			"unique_keys": bson.M{"$exists": false},
+			"$or":         uniqueConstraintsConditions(constraints),
+		}, options.FindOne().SetProjection(bson.M{"_id": 1})).Err()
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		activities = append(activities, &activity)
+	}
+	return activities, cursor.Err()
+}
@@ -70,6 +70,10 @@ func (d *Database) Connect() error {
 		d.log.Fatal("Ping to database has failed")
 	}
 
+	if err = d.CreateIndexes(context.Background()); err != nil {
+		d.log.Error("Failed to create the indexes", zap.Error(err))
+	}
+
 	d.log.Info("Successfully connected to MongoDB")
 	return nil
 }
@@ -102,6 +106,10 @@ type DBCollections struct {
 	activitiesCollection     *mongo.Collection
 	datasCollections         *mongo.Collection
 	uploadedFilesCollections *mongo.Collection
+	dataHistoriesCollection  *mongo.Collection
+	sessionsCollection       *mongo.Collection
+	apiKeysCollection        *mongo.Collection
+	invitationsCollection    *mongo.Collection
 }
 
 func (d *Database) GetAllCollections() *DBCollections {
@@ -113,5 +121,9 @@ func (d *Database) GetAllCollections() *DBCollections {
 		activitiesCollection:     d.GetCollection("activities"),
 		datasCollections:         d.GetCollection("datas"),
 		uploadedFilesCollections: d.GetCollection("uploaded_files"),
+		dataHistoriesCollection:  d.GetCollection("data_histories"),
+		sessionsCollection:       d.GetCollection("sessions"),
+		apiKeysCollection:        d.GetCollection("api_keys"),
+		invitationsCollection:    d.GetCollection("invitations"),
 	}
 }
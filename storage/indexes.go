@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CreateIndexes creates the indexes needed by the queries, if they do not exist yet
+func (d *Database) CreateIndexes(ctx context.Context) error {
+	_, err := d.GetCollection("datas").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{
+				{Key: "activity_id", Value: 1},
+				{Key: "deleted_at", Value: 1},
+				{Key: "created_at", Value: 1},
+				{Key: "_id", Value: 1},
+			},
+		},
+		{
+			Keys: bson.D{
+				{Key: "activity_id", Value: 1},
+				{Key: "deleted_at", Value: 1},
+				{Key: "updated_at", Value: 1},
+				{Key: "_id", Value: 1},
+			},
+		},
+		{
+			// The last values entered by a user, used as default values
+			Keys: bson.D{
+				{Key: "activity_id", Value: 1},
+				{Key: "created_by._id", Value: 1},
+				{Key: "created_at", Value: -1},
+			},
+		},
+		{
+			// The unique constraints of the activities, see models.Activity.UniqueKeys.
+			// The deleted data are not part of it, so their values can be used again.
+			Keys: bson.D{
+				{Key: "unique_keys", Value: 1},
+			},
+			Options: options.Index().
+				SetName("unique_keys").
+				SetUnique(true).
+				SetPartialFilterExpression(bson.M{
+					"unique_keys": bson.M{"$exists": true},
+					"deleted_at":  bson.M{"$type": "null"},
+				}),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = d.GetCollection("otps").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{
+				{Key: "phone_number", Value: 1},
+				{Key: "active", Value: 1},
+			},
+		},
+		{
+			// The codes are removed once expired
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetName("expires_at_ttl").SetExpireAfterSeconds(0),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = d.GetCollection("sessions").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "refresh_token_hash", Value: 1}}},
+		{Keys: bson.D{{Key: "previous_refresh_token_hash", Value: 1}}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "last_used_at", Value: -1}}},
+		{
+			// The sessions are removed once expired, revoked or not
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetName("expires_at_ttl").SetExpireAfterSeconds(0),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = d.GetCollection("api_keys").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "key_hash", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "organization_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = d.GetCollection("invitations").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "token", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "organization_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	// The hits of the rate limits are removed once they do not matter anymore, see ratelimit.MongoStore
+	_, err = d.GetCollection("rate_limits").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetName("expires_at_ttl").SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = d.GetCollection("data_histories").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "data_id", Value: 1},
+			{Key: "created_at", Value: -1},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,167 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"stockinos.com/api/models"
+)
+
+type CreateInvitationParams struct {
+	OrganizationId primitive.ObjectID
+	Token          string
+	PhoneNumber    string
+	Role           string
+	MaxUses        int
+	CreatedBy      models.DataAuthor
+	ExpiresAt      time.Time
+}
+
+func (q *Queries) CreateInvitation(ctx context.Context, arg CreateInvitationParams) (*models.Invitation, error) {
+	invitation := models.Invitation{
+		Id:             primitive.NewObjectID(),
+		OrganizationId: arg.OrganizationId,
+		Token:          arg.Token,
+		PhoneNumber:    arg.PhoneNumber,
+		Role:           arg.Role,
+		MaxUses:        arg.MaxUses,
+		CreatedBy:      arg.CreatedBy,
+		CreatedAt:      time.Now(),
+		ExpiresAt:      arg.ExpiresAt,
+	}
+
+	if _, err := q.invitationsCollection.InsertOne(ctx, invitation); err != nil {
+		return nil, err
+	}
+	return &invitation, nil
+}
+
+type GetInvitationsParams struct {
+	OrganizationId primitive.ObjectID
+}
+
+// GetInvitations returns the invitations of the organization not revoked, the latest created first
+func (q *Queries) GetInvitations(ctx context.Context, arg GetInvitationsParams) ([]*models.Invitation, error) {
+	invitations := make([]*models.Invitation, 0)
+
+	cursor, err := q.invitationsCollection.Find(ctx, bson.M{
+		"organization_id": arg.OrganizationId,
+		"revoked_at":      nil,
+	}, options.Find().SetSort(bson.M{"created_at": -1}))
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &invitations); err != nil {
+		return nil, err
+	}
+
+	return invitations, nil
+}
+
+type GetInvitationParams struct {
+	Id             primitive.ObjectID
+	OrganizationId primitive.ObjectID
+}
+
+func (q *Queries) GetInvitation(ctx context.Context, arg GetInvitationParams) (*models.Invitation, error) {
+	var invitation models.Invitation
+
+	filter := bson.M{
+		"_id":             arg.Id,
+		"organization_id": arg.OrganizationId,
+		"revoked_at":      nil,
+	}
+	err := q.invitationsCollection.FindOne(ctx, filter).Decode(&invitation)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &invitation, nil
+}
+
+type GetInvitationByTokenParams struct {
+	Token string
+}
+
+// GetInvitationByToken returns the invitation even when revoked, expired or used up, see models.Invitation.IsActive
+func (q *Queries) GetInvitationByToken(ctx context.Context, arg GetInvitationByTokenParams) (*models.Invitation, error) {
+	var invitation models.Invitation
+
+	err := q.invitationsCollection.FindOne(ctx, bson.M{"token": arg.Token}).Decode(&invitation)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &invitation, nil
+}
+
+type UseInvitationParams struct {
+	Id primitive.ObjectID
+}
+
+// UseInvitation counts a member joining with the invitation, nil when it can not be used anymore
+func (q *Queries) UseInvitation(ctx context.Context, arg UseInvitationParams) (*models.Invitation, error) {
+	filter := bson.M{
+		"_id":        arg.Id,
+		"revoked_at": nil,
+		"expires_at": bson.M{"$gt": time.Now()},
+		// two members joining at once can not go over the limit
+		"$expr": bson.M{"$lt": bson.A{"$uses", "$max_uses"}},
+	}
+	update := bson.M{
+		"$inc": bson.M{"uses": 1},
+	}
+
+	return CommonUpdateQuery[models.Invitation](ctx, *q.invitationsCollection, filter, update)
+}
+
+type MarkInvitationSentParams struct {
+	Id             primitive.ObjectID
+	OrganizationId primitive.ObjectID
+	SentAt         time.Time
+	ExpiresAt      time.Time
+}
+
+// MarkInvitationSent records the link was sent again, until the new expiry
+func (q *Queries) MarkInvitationSent(ctx context.Context, arg MarkInvitationSentParams) (*models.Invitation, error) {
+	filter := bson.M{
+		"_id":             arg.Id,
+		"organization_id": arg.OrganizationId,
+		"revoked_at":      nil,
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"sent_at":    arg.SentAt,
+			"expires_at": arg.ExpiresAt,
+		},
+	}
+
+	return CommonUpdateQuery[models.Invitation](ctx, *q.invitationsCollection, filter, update)
+}
+
+type RevokeInvitationParams struct {
+	Id             primitive.ObjectID
+	OrganizationId primitive.ObjectID
+}
+
+func (q *Queries) RevokeInvitation(ctx context.Context, arg RevokeInvitationParams) (*models.Invitation, error) {
+	filter := bson.M{
+		"_id":             arg.Id,
+		"organization_id": arg.OrganizationId,
+		"revoked_at":      nil,
+	}
+	update := bson.M{
+		"$set": bson.M{"revoked_at": time.Now()},
+	}
+
+	return CommonUpdateQuery[models.Invitation](ctx, *q.invitationsCollection, filter, update)
+}
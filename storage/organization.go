@@ -3,6 +3,8 @@ package storage
 import (
 	"context"
 	"errors"
+	"sort"
+	"strings"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -18,9 +20,8 @@ type CreateOrganizationParams struct {
 	Email   string
 	Address models.Address
 
-	CreatedBy       primitive.ObjectID
-	OwnedBy         primitive.ObjectID
-	InvitationToken string
+	CreatedBy primitive.ObjectID
+	OwnedBy   primitive.ObjectID
 }
 
 func (q *Queries) CreateOrganization(ctx context.Context, arg CreateOrganizationParams) (*models.Organization, error) {
@@ -33,9 +34,8 @@ func (q *Queries) CreateOrganization(ctx context.Context, arg CreateOrganization
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 
-		CreatedBy:       arg.CreatedBy,
-		OwnedBy:         arg.OwnedBy,
-		InvitationToken: arg.InvitationToken,
+		CreatedBy: arg.CreatedBy,
+		OwnedBy:   arg.OwnedBy,
 	}
 
 	_, err := q.organizationsCollection.InsertOne(ctx, organization)
@@ -50,55 +50,80 @@ type GetAllCompaniesParams struct {
 	UserId primitive.ObjectID
 }
 
-func (q *Queries) GetAllCompanies(ctx context.Context, arg GetAllCompaniesParams) ([]*models.Organization, error) {
-	var organizations []*models.Organization
-
-	filter := bson.M{
-		"created_by": arg.UserId,
-		"deleted_at": nil,
+// GetAllCompanies returns the organizations the user is a confirmed member of, by name
+func (q *Queries) GetAllCompanies(ctx context.Context, arg GetAllCompaniesParams) ([]*models.UserOrganization, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"member_id":  arg.UserId,
+			"deleted_at": nil,
+			"status":     models.MemberStatusConfirmed,
+		}}},
+		{{Key: "$lookup", Value: bson.M{
+			"from":         "organizations",
+			"localField":   "organization_id",
+			"foreignField": "_id",
+			"as":           "organization",
+		}}},
+		{{Key: "$unwind", Value: "$organization"}},
+		{{Key: "$match", Value: bson.M{"organization.deleted_at": nil}}},
+		{{Key: "$replaceRoot", Value: bson.M{
+			"newRoot": bson.M{"$mergeObjects": bson.A{"$organization", bson.M{"role": "$role"}}},
+		}}},
 	}
-	cursor, err := q.organizationsCollection.Find(ctx, filter)
+
+	cursor, err := q.teamsCollection.Aggregate(ctx, pipeline)
 	if err != nil {
 		return nil, err
 	}
+	organizations := make([]*models.UserOrganization, 0)
 	if err = cursor.All(ctx, &organizations); err != nil {
 		return nil, err
 	}
+
+	// The organizations created before the roles have no member for their owner
+	ids := make(bson.A, 0, len(organizations))
+	for _, organization := range organizations {
+		ids = append(ids, organization.Id)
+	}
+	cursor, err = q.organizationsCollection.Find(ctx, bson.M{
+		"owned_by":   arg.UserId,
+		"deleted_at": nil,
+		"_id":        bson.M{"$nin": ids},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var owned []models.Organization
+	if err = cursor.All(ctx, &owned); err != nil {
+		return nil, err
+	}
+	for _, organization := range owned {
+		organizations = append(organizations, &models.UserOrganization{
+			Organization: organization,
+			Role:         models.RoleOwner,
+		})
+	}
+
+	sort.SliceStable(organizations, func(i, j int) bool {
+		return strings.ToLower(organizations[i].Name) < strings.ToLower(organizations[j].Name)
+	})
 	return organizations, nil
 }
 
 type GetOrganizationParams struct {
 	Id primitive.ObjectID
+	// Also look for the organization in the trash
+	WithDeleted bool
 }
 
 func (q *Queries) GetOrganization(ctx context.Context, arg GetOrganizationParams) (*models.Organization, error) {
 	var organization models.Organization
 
 	filter := bson.M{
-		"_id":        arg.Id,
-		"deleted_at": nil,
-	}
-	err := q.organizationsCollection.FindOne(ctx, filter).Decode(&organization)
-	if err != nil {
-		if errors.Is(err, mongo.ErrNoDocuments) {
-			return nil, nil
-		} else {
-			return nil, err
-		}
+		"_id": arg.Id,
 	}
-	return &organization, nil
-}
-
-type GetOrganizationFromInvitationTokenParams struct {
-	InvitationToken string
-}
-
-func (q *Queries) GetOrganizationFromInvitationToken(ctx context.Context, arg GetOrganizationFromInvitationTokenParams) (*models.Organization, error) {
-	var organization models.Organization
-
-	filter := bson.M{
-		"invitation_token": arg.InvitationToken,
-		"deleted_at":       nil,
+	if !arg.WithDeleted {
+		filter["deleted_at"] = nil
 	}
 	err := q.organizationsCollection.FindOne(ctx, filter).Decode(&organization)
 	if err != nil {
@@ -115,17 +140,23 @@ type UpdateOrganizationParams struct {
 	Id   primitive.ObjectID
 	Name string
 	Bio  string
+	// Unchanged when empty
+	JoinPolicy string
 }
 
 func (q *Queries) UpdateOrganization(ctx context.Context, arg UpdateOrganizationParams) (*models.Organization, error) {
 	filter := bson.M{
 		"_id": arg.Id,
 	}
+	set := bson.M{
+		"name":        arg.Name,
+		"description": arg.Bio,
+	}
+	if arg.JoinPolicy != "" {
+		set["join_policy"] = arg.JoinPolicy
+	}
 	update := bson.M{
-		"$set": bson.M{
-			"name":        arg.Name,
-			"description": arg.Bio,
-		},
+		"$set": set,
 	}
 	after := options.After
 
@@ -150,7 +181,8 @@ func (q *Queries) UpdateOrganization(ctx context.Context, arg UpdateOrganization
 }
 
 type DeleteOrganizationParams struct {
-	Id primitive.ObjectID
+	Id        primitive.ObjectID
+	DeletedBy models.DataAuthor
 }
 
 func (q *Queries) DeleteOrganization(ctx context.Context, arg DeleteOrganizationParams) error {
@@ -161,6 +193,7 @@ func (q *Queries) DeleteOrganization(ctx context.Context, arg DeleteOrganization
 	update := bson.M{
 		"$set": bson.M{
 			"deleted_at": time.Now(),
+			"deleted_by": arg.DeletedBy,
 		},
 	}
 	after := options.After
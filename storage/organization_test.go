@@ -84,13 +84,13 @@ func testGetAllCompanies(t *testing.T, db *storage.Database) {
 	if len(got) != NUM_COMPANIES_CREATED {
 		t.Fatalf("GetAllCompanies(): got %d organizations; want %d organizations", len(got), NUM_COMPANIES_CREATED)
 	}
-	if err := organizationEq(got[0], organizations[0]); err != nil {
+	if err := organizationEq(&got[0].Organization, organizations[0]); err != nil {
 		t.Fatalf("GetAllCompanies(): %v", err)
 	}
-	if err := organizationEq(got[1], organizations[1]); err != nil {
+	if err := organizationEq(&got[1].Organization, organizations[1]); err != nil {
 		t.Fatalf("GetAllCompanies(): %v", err)
 	}
-	if err := organizationEq(got[len(got)-1], organizations[len(organizations)-1]); err != nil {
+	if err := organizationEq(&got[len(got)-1].Organization, organizations[len(organizations)-1]); err != nil {
 		t.Fatalf("GetAllCompanies(): %v", err)
 	}
 
@@ -3,6 +3,7 @@ package storage
 import (
 	"context"
 	"errors"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -19,7 +20,12 @@ func (q *Queries) GetActivateOTP(ctx context.Context, arg GetActivateOTPParams)
 	var otp models.OTP
 	err := q.otpsCollection.FindOne(
 		ctx,
-		bson.D{{Key: "phone_number", Value: arg.PhoneNumber}, {Key: "active", Value: true}},
+		bson.D{
+			{Key: "phone_number", Value: arg.PhoneNumber},
+			{Key: "active", Value: true},
+			{Key: "expires_at", Value: bson.M{"$gt": time.Now()}},
+		},
+		options.FindOne().SetSort(bson.M{"created_at": -1}),
 	).Decode(&otp)
 	if err != nil {
 		if errors.Is(err, mongo.ErrNoDocuments) {
@@ -33,9 +39,12 @@ func (q *Queries) GetActivateOTP(ctx context.Context, arg GetActivateOTPParams)
 }
 
 type CreateOTPParams struct {
-	WaMessageId string
-	PinCode     string
+	WaMessageId string // when sent by WhatsApp
+	Channel     string
+	MessageId   string
+	PinCode     string // hashed
 	PhoneNumber string
+	ExpiresAt   time.Time
 }
 
 func (q *Queries) CreateOTP(ctx context.Context, arg CreateOTPParams) (*models.OTP, error) {
@@ -43,8 +52,13 @@ func (q *Queries) CreateOTP(ctx context.Context, arg CreateOTPParams) (*models.O
 		Id:          primitive.NewObjectID(),
 		PhoneNumber: arg.PhoneNumber,
 		WaMessageId: arg.WaMessageId,
+		Channel:     arg.Channel,
+		MessageId:   arg.MessageId,
 		PinCode:     arg.PinCode,
 		Active:      true,
+
+		CreatedAt: time.Now(),
+		ExpiresAt: arg.ExpiresAt,
 	}
 
 	_, err := q.otpsCollection.InsertOne(ctx, otp)
@@ -122,7 +136,9 @@ func (q *Queries) DesactivateAllOTPFromPhoneNumber(ctx context.Context, arg Desa
 
 type CheckOTPParams struct {
 	PhoneNumber string
-	UserOTP     string
+	UserOTP     string // hashed
+	// Number of wrong codes after which the code is desactivated, used by CheckOTPTx
+	MaxAttempts int
 }
 
 func (q *Queries) CheckOTP(ctx context.Context, arg CheckOTPParams) (*models.OTP, error) {
@@ -132,6 +148,7 @@ func (q *Queries) CheckOTP(ctx context.Context, arg CheckOTPParams) (*models.OTP
 		"phone_number": arg.PhoneNumber,
 		"pin_code":     arg.UserOTP,
 		"active":       true,
+		"expires_at":   bson.M{"$gt": time.Now()},
 	}
 	err := q.otpsCollection.FindOne(ctx, filter).Decode(&otp)
 	if err != nil {
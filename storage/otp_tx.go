@@ -2,16 +2,29 @@ package storage
 
 import (
 	"context"
+	"crypto/subtle"
+	"errors"
 	"fmt"
 	"log"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"stockinos.com/api/models"
 )
 
+var (
+	// ErrOTPNotFound is returned when the phone number has no code, or its code expired
+	ErrOTPNotFound = errors.New("no active otp")
+	// ErrOTPInvalid is returned when the code entered is wrong
+	ErrOTPInvalid = errors.New("invalid otp")
+	// ErrOTPTooManyAttempts is returned when the code entered is wrong too many times,
+	// the code can not be used anymore
+	ErrOTPTooManyAttempts = errors.New("too many otp attempts")
+)
+
 func (store *MongoStorage) CreateOTPx(ctx context.Context, arg CreateOTPParams) (*models.OTP, error) {
 	result, err := store.withTx(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
-		err := store.DesactivateAllOTPFromPhoneNumber(ctx, DesactivateAllOTPFromPhoneNumberParams{
+		err := store.DesactivateAllOTPFromPhoneNumber(sessCtx, DesactivateAllOTPFromPhoneNumberParams{
 			PhoneNumber: arg.PhoneNumber,
 		})
 		if err != nil {
@@ -19,12 +32,7 @@ func (store *MongoStorage) CreateOTPx(ctx context.Context, arg CreateOTPParams)
 			return nil, fmt.Errorf("%v", err)
 		}
 
-		waMessageId := "xxx-yyy-zzz"
-		otp, err := store.CreateOTP(ctx, CreateOTPParams{
-			WaMessageId: waMessageId,
-			PhoneNumber: arg.PhoneNumber,
-			PinCode:     arg.PinCode,
-		})
+		otp, err := store.CreateOTP(sessCtx, arg)
 		if err != nil {
 			log.Println("error when saving the OTP: ", err)
 			return nil, fmt.Errorf("%v", err)
@@ -40,34 +48,53 @@ func (store *MongoStorage) CreateOTPx(ctx context.Context, arg CreateOTPParams)
 	}
 }
 
+// CheckOTPTx checks the code entered against the active code of the phone number.
+// A right code is desactivated so it is used only once, a wrong one counts as an attempt.
 func (store *MongoStorage) CheckOTPTx(ctx context.Context, arg CheckOTPParams) (*models.OTP, error) {
+	// The attempts must be saved, so a wrong code does not fail the transaction
+	var checkErr error
+
 	result, err := store.withTx(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
-		otp, err := store.CheckOTP(ctx, CheckOTPParams{
+		checkErr = nil
+
+		otp, err := store.GetActivateOTP(sessCtx, GetActivateOTPParams{
 			PhoneNumber: arg.PhoneNumber,
-			UserOTP:     arg.UserOTP,
 		})
 		if err != nil {
 			log.Println("error when checking the otp: ", err)
-			return nil, fmt.Errorf("ERR_COTP_102_%v", err)
+			return nil, err
 		}
 		if otp == nil {
-			log.Println("error when checking the otp - no corresponding otp found: ", err)
-			return nil, fmt.Errorf("ERR_COTP_102_%s", err)
+			checkErr = ErrOTPNotFound
+			return nil, nil
 		}
 
-		otp, err = store.DesactivateOTP(ctx, DesactivateOTPParams{
-			Id: otp.Id,
-		})
-		if err != nil {
-			return nil, fmt.Errorf("ERR_", err)
+		if subtle.ConstantTimeCompare([]byte(otp.PinCode), []byte(arg.UserOTP)) != 1 {
+			update := bson.M{"$inc": bson.M{"attempts": 1}}
+			checkErr = ErrOTPInvalid
+			if arg.MaxAttempts > 0 && otp.Attempts+1 >= arg.MaxAttempts {
+				update["$set"] = bson.M{"active": false}
+				checkErr = ErrOTPTooManyAttempts
+			}
+
+			_, err := store.otpsCollection.UpdateOne(sessCtx, bson.M{"_id": otp.Id}, update)
+			return nil, err
 		}
 
-		return otp, nil
+		return store.DesactivateOTP(sessCtx, DesactivateOTPParams{
+			Id: otp.Id,
+		})
 	})
+	if err != nil {
+		return nil, err
+	}
+	if checkErr != nil {
+		return nil, checkErr
+	}
 
 	if otp, ok := result.(*models.OTP); ok {
-		return otp, err
+		return otp, nil
 	} else {
-		return nil, err
+		return nil, ErrOTPNotFound
 	}
 }
@@ -3,6 +3,8 @@ package storage
 import (
 	"context"
 
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
 	"stockinos.com/api/models"
 )
 
@@ -10,9 +12,26 @@ type Querier interface {
 	CreateUser(ctx context.Context, arg CreateUserParams) (*models.User, error)
 	DoesUserExists(ctx context.Context, arg DoesUserExistsParams) (*models.User, error)
 	GetUserByPhoneNumber(ctx context.Context, arg GetUserByPhoneNumberParams) (*models.User, error)
+	GetUserById(ctx context.Context, arg GetUserByIdParams) (*models.User, error)
 	UpdateUserProfile(ctx context.Context, arg UpdateUserProfileParams) (*models.User, error)
 	UpdateUserPreferences(ctx context.Context, arg UpdateUserPreferencesParams) (*models.User, error)
 
+	// Session
+	CreateSession(ctx context.Context, arg CreateSessionParams) (*models.Session, error)
+	GetSession(ctx context.Context, arg GetSessionParams) (*models.Session, error)
+	GetSessionByRefreshToken(ctx context.Context, arg GetSessionByRefreshTokenParams) (*models.Session, error)
+	GetUserSessions(ctx context.Context, arg GetUserSessionsParams) ([]*models.Session, error)
+	RotateSession(ctx context.Context, arg RotateSessionParams) (*models.Session, error)
+	RevokeSession(ctx context.Context, arg RevokeSessionParams) (*models.Session, error)
+	RevokeOtherSessions(ctx context.Context, arg RevokeOtherSessionsParams) ([]primitive.ObjectID, error)
+
+	// API keys
+	CreateApiKey(ctx context.Context, arg CreateApiKeyParams) (*models.ApiKey, error)
+	GetApiKeys(ctx context.Context, arg GetApiKeysParams) ([]*models.ApiKey, error)
+	GetApiKeyByHash(ctx context.Context, arg GetApiKeyByHashParams) (*models.ApiKey, error)
+	RevokeApiKey(ctx context.Context, arg RevokeApiKeyParams) (*models.ApiKey, error)
+	TouchApiKey(ctx context.Context, arg TouchApiKeyParams) error
+
 	// OTP
 	CheckOTP(ctx context.Context, arg CheckOTPParams) (*models.OTP, error)
 	DesactivateOTP(ctx context.Context, arg DesactivateOTPParams) (*models.OTP, error)
@@ -22,15 +41,28 @@ type Querier interface {
 
 	// Organization
 	CreateOrganization(ctx context.Context, arg CreateOrganizationParams) (*models.Organization, error)
-	GetAllCompanies(ctx context.Context, arg GetAllCompaniesParams) ([]*models.Organization, error)
+	GetAllCompanies(ctx context.Context, arg GetAllCompaniesParams) ([]*models.UserOrganization, error)
 	GetOrganization(ctx context.Context, arg GetOrganizationParams) (*models.Organization, error)
-	GetOrganizationFromInvitationToken(ctx context.Context, arg GetOrganizationFromInvitationTokenParams) (*models.Organization, error)
 	UpdateOrganization(ctx context.Context, arg UpdateOrganizationParams) (*models.Organization, error)
 	DeleteOrganization(ctx context.Context, arg DeleteOrganizationParams) error
 
 	// Team
-	GetMembersFromOrganization(ctx context.Context, arg GetMembersFromOrganizationParams) ([]models.Member, error)
+	GetMembersFromOrganization(ctx context.Context, arg GetMembersFromOrganizationParams) (*PaginatedMembers, error)
+	GetMember(ctx context.Context, arg GetMemberParams) (*models.Member, error)
+	GetMemberById(ctx context.Context, arg GetMemberByIdParams) (*models.Member, error)
 	AddMemberIntoOrganization(ctx context.Context, arg AddMemberIntoOrganizationParams) (*models.Member, error)
+	RemoveMember(ctx context.Context, arg RemoveMemberParams) (*models.Member, error)
+	UpdateMemberRole(ctx context.Context, arg UpdateMemberRoleParams) (*models.Member, error)
+	ReviewJoinRequest(ctx context.Context, arg ReviewJoinRequestParams) (*models.Member, error)
+
+	// Invitation
+	CreateInvitation(ctx context.Context, arg CreateInvitationParams) (*models.Invitation, error)
+	GetInvitations(ctx context.Context, arg GetInvitationsParams) ([]*models.Invitation, error)
+	GetInvitation(ctx context.Context, arg GetInvitationParams) (*models.Invitation, error)
+	GetInvitationByToken(ctx context.Context, arg GetInvitationByTokenParams) (*models.Invitation, error)
+	UseInvitation(ctx context.Context, arg UseInvitationParams) (*models.Invitation, error)
+	MarkInvitationSent(ctx context.Context, arg MarkInvitationSentParams) (*models.Invitation, error)
+	RevokeInvitation(ctx context.Context, arg RevokeInvitationParams) (*models.Invitation, error)
 
 	// Monitoring
 	// Activity
@@ -48,15 +80,31 @@ type Querier interface {
 	UpdateData(ctx context.Context, arg UpdateDataParams) (*models.Data, error)
 	GetData(ctx context.Context, arg GetDataParams) (*models.Data, error)
 	GetDataFilterByValues(ctx context.Context, arg GetDataFilterByValuesParams) (*models.Data, error)
+	GetLastValueOfAuthor(ctx context.Context, arg GetLastValueOfAuthorParams) (any, error)
 	GetAllData(ctx context.Context, arg GetAllDataParams) ([]*models.Data, error)
+	GetPaginatedData(ctx context.Context, arg GetPaginatedDataParams) (*PaginatedData, error)
+	ForEachData(ctx context.Context, arg ForEachDataParams, fn func(data *models.Data) error) error
 	DeleteData(ctx context.Context, arg DeleteDataParams) error
 	UpdateSetInData(ctx context.Context, arg UpdateSetInDataParams) (*models.Data, error)
 	UpdateAddToData(ctx context.Context, arg UpdateAddToDataParams) (*models.Data, error)
 	UpdateRemoveFromData(ctx context.Context, arg UpdateRemoveFromDataParams) (*models.Data, error)
+	PreviewDataMigration(ctx context.Context, arg PreviewDataMigrationParams) ([]models.FieldMigrationImpact, error)
+	GetActivitiesWithPendingMigration(ctx context.Context, arg GetActivitiesWithPendingMigrationParams) ([]*models.Activity, error)
+	GetActivitiesWithLegacyUniqueKeys(ctx context.Context) ([]*models.Activity, error)
+	AddDataHistory(ctx context.Context, arg AddDataHistoryParams) (*models.DataHistory, error)
+	GetDataHistory(ctx context.Context, arg GetDataHistoryParams) ([]*models.DataHistory, error)
+	GetDataHistoryEntry(ctx context.Context, arg GetDataHistoryEntryParams) (*models.DataHistory, error)
 	AddUploadedFile(ctx context.Context, arg AddUploadedFileParams) (*models.UploadedFile, error)
 	GetAllUploadedFiles(ctx context.Context, arg GetAllUploadedFilesParams) ([]*models.UploadedFile, error)
 	RemoveUploadedFile(ctx context.Context, arg RemoveUploadedFileParams) error
 	RemoveAllUploadedFile(ctx context.Context, arg RemoveUploadedFileParams) error
+
+	// Trash
+	GetTrash(ctx context.Context, arg GetTrashParams) ([]models.TrashItem, error)
+	GetDeletedOrganizations(ctx context.Context, arg GetDeletedOrganizationsParams) ([]models.TrashItem, error)
+	GetExpiredTrash(ctx context.Context, arg GetExpiredTrashParams) ([]models.TrashItem, error)
+	RestoreOrganization(ctx context.Context, arg RestoreOrganizationParams) (*models.Organization, error)
+	RestoreData(ctx context.Context, arg RestoreDataParams) (*models.Data, error)
 }
 
 type QuerierTx interface {
@@ -64,9 +112,33 @@ type QuerierTx interface {
 	CheckOTPTx(ctx context.Context, arg CheckOTPParams) (*models.OTP, error)
 	CreateOTPx(ctx context.Context, arg CreateOTPParams) (*models.OTP, error)
 
+	// Team
+	TransferOwnershipTx(ctx context.Context, arg TransferOwnershipTxParams) (*models.Organization, error)
+
 	// Activity
 	UpdateSetInActivityTx(ctx context.Context, arg UpdateSetInActivityTxParams) (*models.Activity, error)
+	UpdateAddToActivityTx(ctx context.Context, arg UpdateAddToActivityTxParams) (*models.Activity, error)
 	UpdateRemoveFromActivityTx(ctx context.Context, arg UpdateRemoveFromActivityTxParams) (*models.Activity, error)
+
+	// Data
+	CreateManyDataTx(ctx context.Context, arg CreateManyDataTxParams) (int, error)
+	CreateDataTx(ctx context.Context, arg CreateDataParams) (*models.Data, error)
+	UpdateDataTx(ctx context.Context, arg UpdateDataTxParams) (*models.Data, error)
+	UpdateSetInDataTx(ctx context.Context, arg UpdateSetInDataTxParams) (*models.Data, error)
+	UpdateAddToDataTx(ctx context.Context, arg UpdateAddToDataTxParams) (*models.Data, error)
+	UpdateRemoveFromDataTx(ctx context.Context, arg UpdateRemoveFromDataTxParams) (*models.Data, error)
+	DeleteDataTx(ctx context.Context, arg DeleteDataTxParams) error
+	StartDataUniqueKeysRefreshTx(ctx context.Context, arg StartDataUniqueKeysRefreshTxParams) (*models.Activity, error)
+	RefreshDataUniqueKeysTx(ctx context.Context, arg RefreshDataUniqueKeysTxParams) error
+	MigrateDataTx(ctx context.Context, arg MigrateDataTxParams) error
+
+	// Trash
+	DeleteActivityTx(ctx context.Context, arg DeleteActivityParams) error
+	RestoreActivityTx(ctx context.Context, arg RestoreActivityTxParams) (*models.Activity, error)
+	RestoreDataTx(ctx context.Context, arg RestoreDataTxParams) (*models.Data, error)
+	PurgeDataTx(ctx context.Context, arg PurgeDataTxParams) ([]string, error)
+	PurgeActivityTx(ctx context.Context, arg PurgeActivityTxParams) ([]string, error)
+	PurgeOrganizationTx(ctx context.Context, arg PurgeOrganizationTxParams) ([]string, error)
 }
 
 var _ Querier = (*Queries)(nil)
@@ -0,0 +1,200 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"stockinos.com/api/models"
+)
+
+type CreateSessionParams struct {
+	UserId           primitive.ObjectID
+	RefreshTokenHash string
+	UserAgent        string
+	IP               string
+	ExpiresAt        time.Time
+}
+
+func (q *Queries) CreateSession(ctx context.Context, arg CreateSessionParams) (*models.Session, error) {
+	now := time.Now()
+	session := models.Session{
+		Id:               primitive.NewObjectID(),
+		UserId:           arg.UserId,
+		RefreshTokenHash: arg.RefreshTokenHash,
+		UserAgent:        arg.UserAgent,
+		IP:               arg.IP,
+
+		CreatedAt:  now,
+		LastUsedAt: now,
+		ExpiresAt:  arg.ExpiresAt,
+	}
+
+	if _, err := q.sessionsCollection.InsertOne(ctx, session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+type GetSessionParams struct {
+	Id primitive.ObjectID
+}
+
+// GetSession returns the session even when revoked, nil once expired
+func (q *Queries) GetSession(ctx context.Context, arg GetSessionParams) (*models.Session, error) {
+	var session models.Session
+	err := q.sessionsCollection.FindOne(ctx, bson.M{
+		"_id":        arg.Id,
+		"expires_at": bson.M{"$gt": time.Now()},
+	}).Decode(&session)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+type GetSessionByRefreshTokenParams struct {
+	RefreshTokenHash string
+}
+
+// GetSessionByRefreshToken returns the session of a refresh token, the current one or the previous one
+func (q *Queries) GetSessionByRefreshToken(ctx context.Context, arg GetSessionByRefreshTokenParams) (*models.Session, error) {
+	var session models.Session
+	err := q.sessionsCollection.FindOne(ctx, bson.M{
+		"$or": bson.A{
+			bson.M{"refresh_token_hash": arg.RefreshTokenHash},
+			bson.M{"previous_refresh_token_hash": arg.RefreshTokenHash},
+		},
+	}).Decode(&session)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+type GetUserSessionsParams struct {
+	UserId primitive.ObjectID
+}
+
+// GetUserSessions returns the active sessions of the user, the latest used first
+func (q *Queries) GetUserSessions(ctx context.Context, arg GetUserSessionsParams) ([]*models.Session, error) {
+	sessions := make([]*models.Session, 0)
+
+	cursor, err := q.sessionsCollection.Find(ctx, bson.M{
+		"user_id":    arg.UserId,
+		"revoked_at": nil,
+		"expires_at": bson.M{"$gt": time.Now()},
+	}, options.Find().SetSort(bson.M{"last_used_at": -1}))
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &sessions); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+type RotateSessionParams struct {
+	RefreshTokenHash    string
+	NewRefreshTokenHash string
+	ExpiresAt           time.Time
+}
+
+// RotateSession replaces the refresh token of an active session,
+// nil is returned when the token is not the current one of an active session
+func (q *Queries) RotateSession(ctx context.Context, arg RotateSessionParams) (*models.Session, error) {
+	now := time.Now()
+	filter := bson.M{
+		"refresh_token_hash": arg.RefreshTokenHash,
+		"revoked_at":         nil,
+		"expires_at":         bson.M{"$gt": now},
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"refresh_token_hash":          arg.NewRefreshTokenHash,
+			"previous_refresh_token_hash": arg.RefreshTokenHash,
+			"last_used_at":                now,
+			"expires_at":                  arg.ExpiresAt,
+		},
+	}
+
+	return CommonUpdateQuery[models.Session](ctx, *q.sessionsCollection, filter, update)
+}
+
+type RevokeSessionParams struct {
+	Id primitive.ObjectID
+	// when set, the session must belong to the user
+	UserId primitive.ObjectID
+}
+
+// RevokeSession signs a device out, nil is returned when the session is not active
+func (q *Queries) RevokeSession(ctx context.Context, arg RevokeSessionParams) (*models.Session, error) {
+	filter := bson.M{
+		"_id":        arg.Id,
+		"revoked_at": nil,
+	}
+	if !arg.UserId.IsZero() {
+		filter["user_id"] = arg.UserId
+	}
+	update := bson.M{
+		"$set": bson.M{"revoked_at": time.Now()},
+	}
+
+	return CommonUpdateQuery[models.Session](ctx, *q.sessionsCollection, filter, update)
+}
+
+type RevokeOtherSessionsParams struct {
+	UserId   primitive.ObjectID
+	ExceptId primitive.ObjectID
+}
+
+// RevokeOtherSessions signs the user out of all the devices but the current one, it returns the revoked sessions
+func (q *Queries) RevokeOtherSessions(ctx context.Context, arg RevokeOtherSessionsParams) ([]primitive.ObjectID, error) {
+	filter := bson.M{
+		"user_id":    arg.UserId,
+		"_id":        bson.M{"$ne": arg.ExceptId},
+		"revoked_at": nil,
+	}
+
+	var sessions []*models.Session
+	cursor, err := q.sessionsCollection.Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &sessions); err != nil {
+		return nil, err
+	}
+
+	ids := make([]primitive.ObjectID, len(sessions))
+	for i, session := range sessions {
+		ids[i] = session.Id
+	}
+	if len(ids) == 0 {
+		return ids, nil
+	}
+
+	_, err = q.sessionsCollection.UpdateMany(ctx, bson.M{
+		"_id":        bson.M{"$in": ids},
+		"revoked_at": nil,
+	}, bson.M{
+		"$set": bson.M{"revoked_at": time.Now()},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
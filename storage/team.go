@@ -2,6 +2,7 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -12,16 +13,49 @@ import (
 
 type GetMembersFromOrganizationParams struct {
 	OrganizationId primitive.ObjectID
+	// Only the members with this status or this role, all of them when empty
+	Status string
+	Role   string
+
+	// No limit: all the members
+	Limit  int64
+	Offset int64
+}
+
+type PaginatedMembers struct {
+	Members []models.Member
+	Total   int64
+	HasMore bool // There are other members after this page
 }
 
-func (q *Queries) GetMembersFromOrganization(ctx context.Context, arg GetMembersFromOrganizationParams) ([]models.Member, error) {
+// GetMembersFromOrganization returns the members with their user, the first invited first
+func (q *Queries) GetMembersFromOrganization(ctx context.Context, arg GetMembersFromOrganizationParams) (*PaginatedMembers, error) {
+	filter := bson.M{
+		"organization_id": arg.OrganizationId,
+		"deleted_at":      nil,
+	}
+	if arg.Status != "" {
+		filter["status"] = arg.Status
+	}
+	if arg.Role != "" {
+		filter["role"] = bson.M{"$in": models.RoleAliases(arg.Role)}
+	}
+
+	total, err := q.teamsCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
 	matchStage := bson.D{
 		{
-			Key: "$match",
-			Value: bson.M{
-				"organization_id": arg.OrganizationId,
-				"deleted_at":      nil,
-			},
+			Key:   "$match",
+			Value: filter,
+		},
+	}
+	sortStage := bson.D{
+		{
+			Key:   "$sort",
+			Value: bson.D{{Key: "invited_at", Value: 1}, {Key: "_id", Value: 1}},
 		},
 	}
 	lookupStage := bson.D{
@@ -45,23 +79,60 @@ func (q *Queries) GetMembersFromOrganization(ctx context.Context, arg GetMembers
 		},
 	}
 
-	showLoadedCursor, err := q.teamsCollection.Aggregate(
-		ctx,
-		mongo.Pipeline{
-			matchStage,
-			lookupStage,
-			unwindStage,
-		},
-	)
+	pipeline := mongo.Pipeline{matchStage, sortStage}
+	if arg.Offset > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$skip", Value: arg.Offset}})
+	}
+	// One more member is requested to know if there is a next page
+	if arg.Limit > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$limit", Value: arg.Limit + 1}})
+	}
+	pipeline = append(pipeline, lookupStage, unwindStage)
+
+	showLoadedCursor, err := q.teamsCollection.Aggregate(ctx, pipeline)
 	if err != nil {
 		return nil, err
 	}
-	var members []models.Member
+	members := make([]models.Member, 0)
 	if err = showLoadedCursor.All(ctx, &members); err != nil {
 		return nil, err
 	}
 
-	return members, nil
+	hasMore := arg.Limit > 0 && int64(len(members)) > arg.Limit
+	if hasMore {
+		members = members[:arg.Limit]
+	}
+
+	return &PaginatedMembers{
+		Members: members,
+		Total:   total,
+		HasMore: hasMore,
+	}, nil
+}
+
+type GetMemberParams struct {
+	OrganizationId primitive.ObjectID
+	UserId         primitive.ObjectID
+}
+
+// GetMember returns the membership of the user in the organization, nil if the user is not a member
+func (q *Queries) GetMember(ctx context.Context, arg GetMemberParams) (*models.Member, error) {
+	var member models.Member
+
+	filter := bson.M{
+		"organization_id": arg.OrganizationId,
+		"member_id":       arg.UserId,
+		"deleted_at":      nil,
+	}
+	err := q.teamsCollection.FindOne(ctx, filter).Decode(&member)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil
+		} else {
+			return nil, err
+		}
+	}
+	return &member, nil
 }
 
 type AddMemberIntoOrganizationParams struct {
@@ -69,9 +140,20 @@ type AddMemberIntoOrganizationParams struct {
 	UserId         primitive.ObjectID
 	InvitedAt      time.Time
 	ConfirmedAt    *time.Time
+	Role           string // models.RoleViewer by default
+	Status         string // models.MemberStatusConfirmed by default
 }
 
 func (q *Queries) AddMemberIntoOrganization(ctx context.Context, arg AddMemberIntoOrganizationParams) (*models.Member, error) {
+	role := arg.Role
+	if role == "" {
+		role = models.RoleViewer
+	}
+	status := arg.Status
+	if status == "" {
+		status = models.MemberStatusConfirmed
+	}
+
 	var member models.Member = models.Member{
 		Id:             primitive.NewObjectID(),
 		OrganizationId: arg.OrganizationId,
@@ -81,8 +163,8 @@ func (q *Queries) AddMemberIntoOrganization(ctx context.Context, arg AddMemberIn
 		ConfirmedAt: arg.ConfirmedAt,
 		DeletedAt:   nil,
 
-		Status: "confirmed",
-		Role:   "member",
+		Status: status,
+		Role:   role,
 	}
 
 	_, err := q.teamsCollection.InsertOne(ctx, member)
@@ -92,3 +174,107 @@ func (q *Queries) AddMemberIntoOrganization(ctx context.Context, arg AddMemberIn
 		return &member, nil
 	}
 }
+
+type GetMemberByIdParams struct {
+	Id             primitive.ObjectID
+	OrganizationId primitive.ObjectID
+}
+
+// GetMemberById returns a member of the organization by the id of its membership
+func (q *Queries) GetMemberById(ctx context.Context, arg GetMemberByIdParams) (*models.Member, error) {
+	var member models.Member
+
+	filter := bson.M{
+		"_id":             arg.Id,
+		"organization_id": arg.OrganizationId,
+		"deleted_at":      nil,
+	}
+	err := q.teamsCollection.FindOne(ctx, filter).Decode(&member)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &member, nil
+}
+
+type RemoveMemberParams struct {
+	Id             primitive.ObjectID
+	OrganizationId primitive.ObjectID
+	// models.MemberStatusRemoved or models.MemberStatusLeft
+	Status string
+}
+
+// RemoveMember takes the member out of the organization, nil for the owner who can not be removed
+func (q *Queries) RemoveMember(ctx context.Context, arg RemoveMemberParams) (*models.Member, error) {
+	filter := bson.M{
+		"_id":             arg.Id,
+		"organization_id": arg.OrganizationId,
+		"deleted_at":      nil,
+		"role":            bson.M{"$ne": models.RoleOwner},
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"deleted_at": time.Now(),
+			"status":     arg.Status,
+		},
+	}
+
+	return CommonUpdateQuery[models.Member](ctx, *q.teamsCollection, filter, update)
+}
+
+type UpdateMemberRoleParams struct {
+	Id             primitive.ObjectID
+	OrganizationId primitive.ObjectID
+	Role           string
+}
+
+// UpdateMemberRole changes the role of a member, nil for the owner whose role only changes with the ownership
+func (q *Queries) UpdateMemberRole(ctx context.Context, arg UpdateMemberRoleParams) (*models.Member, error) {
+	filter := bson.M{
+		"_id":             arg.Id,
+		"organization_id": arg.OrganizationId,
+		"deleted_at":      nil,
+		"role":            bson.M{"$ne": models.RoleOwner},
+	}
+	update := bson.M{
+		"$set": bson.M{"role": arg.Role},
+	}
+
+	return CommonUpdateQuery[models.Member](ctx, *q.teamsCollection, filter, update)
+}
+
+type ReviewJoinRequestParams struct {
+	Id             primitive.ObjectID
+	OrganizationId primitive.ObjectID
+	// The requester becomes a member, or is turned away
+	Approve bool
+}
+
+// ReviewJoinRequest answers a pending join request, nil when there is none
+func (q *Queries) ReviewJoinRequest(ctx context.Context, arg ReviewJoinRequestParams) (*models.Member, error) {
+	filter := bson.M{
+		"_id":             arg.Id,
+		"organization_id": arg.OrganizationId,
+		"deleted_at":      nil,
+		"status":          models.MemberStatusPending,
+	}
+
+	now := time.Now()
+	set := bson.M{
+		"status":       models.MemberStatusConfirmed,
+		"confirmed_at": now,
+	}
+	if !arg.Approve {
+		set = bson.M{
+			"status":     models.MemberStatusRejected,
+			"deleted_at": now,
+		}
+	}
+	update := bson.M{
+		"$set": set,
+	}
+
+	return CommonUpdateQuery[models.Member](ctx, *q.teamsCollection, filter, update)
+}
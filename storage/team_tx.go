@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"stockinos.com/api/models"
+)
+
+// ErrNotMember is returned when the ownership is given to someone who is not a confirmed member
+var ErrNotMember = errors.New("not a member of the organization")
+
+type TransferOwnershipTxParams struct {
+	OrganizationId primitive.ObjectID
+	FromUserId     primitive.ObjectID
+	ToUserId       primitive.ObjectID
+}
+
+// TransferOwnershipTx gives the organization to another member, the previous owner stays as an admin.
+// It returns nil when FromUserId does not own the organization anymore.
+func (store *MongoStorage) TransferOwnershipTx(ctx context.Context, arg TransferOwnershipTxParams) (*models.Organization, error) {
+	result, err := store.withTx(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		now := time.Now()
+
+		organization, err := CommonUpdateQuery[models.Organization](sessCtx, *store.organizationsCollection, bson.M{
+			"_id":        arg.OrganizationId,
+			"owned_by":   arg.FromUserId,
+			"deleted_at": nil,
+		}, bson.M{
+			"$set": bson.M{
+				"owned_by":   arg.ToUserId,
+				"updated_at": now,
+			},
+		})
+		if err != nil || organization == nil {
+			return organization, err
+		}
+
+		updated, err := store.teamsCollection.UpdateOne(sessCtx, bson.M{
+			"organization_id": arg.OrganizationId,
+			"member_id":       arg.ToUserId,
+			"deleted_at":      nil,
+			"status":          models.MemberStatusConfirmed,
+		}, bson.M{
+			"$set": bson.M{"role": models.RoleOwner},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if updated.MatchedCount == 0 {
+			return nil, ErrNotMember
+		}
+
+		updated, err = store.teamsCollection.UpdateOne(sessCtx, bson.M{
+			"organization_id": arg.OrganizationId,
+			"member_id":       arg.FromUserId,
+			"deleted_at":      nil,
+		}, bson.M{
+			"$set": bson.M{"role": models.RoleAdmin},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		// The organizations created before the roles have no member for their owner
+		if updated.MatchedCount == 0 {
+			_, err = store.teamsCollection.InsertOne(sessCtx, models.Member{
+				Id:             primitive.NewObjectID(),
+				OrganizationId: arg.OrganizationId,
+				MemberId:       arg.FromUserId,
+				InvitedAt:      organization.CreatedAt,
+				ConfirmedAt:    &now,
+				Status:         models.MemberStatusConfirmed,
+				Role:           models.RoleAdmin,
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return organization, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	organization, _ := result.(*models.Organization)
+	return organization, nil
+}
@@ -0,0 +1,251 @@
+package storage
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"stockinos.com/api/models"
+)
+
+var deletedFilter = bson.M{"$ne": nil}
+
+type GetTrashParams struct {
+	OrganizationId primitive.ObjectID
+	Type           string // models.TrashItemActivity or models.TrashItemData, both when empty
+	// How long the items stay in the trash, to tell when they are purged
+	Retention time.Duration
+}
+
+// GetTrash returns the activities and the data deleted in an organization, the latest deleted first.
+// The data deleted along with an activity are not listed, they are counted with the activity.
+func (q *Queries) GetTrash(ctx context.Context, arg GetTrashParams) ([]models.TrashItem, error) {
+	var activities []*models.Activity
+	cursor, err := q.activitiesCollection.Find(
+		ctx,
+		bson.M{"organization_id": arg.OrganizationId},
+		options.Find().SetProjection(bson.M{"name": 1, "deleted_at": 1, "deleted_by": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &activities); err != nil {
+		return nil, err
+	}
+
+	items := make([]models.TrashItem, 0)
+	activityIds := make([]primitive.ObjectID, len(activities))
+	deletedActivities := make(map[primitive.ObjectID]int)
+	for i, activity := range activities {
+		activityIds[i] = activity.Id
+		if activity.DeletedAt == nil || arg.Type == models.TrashItemData {
+			continue
+		}
+
+		deletedActivities[activity.Id] = len(items)
+		items = append(items, models.TrashItem{
+			Type:           models.TrashItemActivity,
+			Id:             activity.Id,
+			Name:           activity.Name,
+			OrganizationId: arg.OrganizationId,
+			DeletedAt:      *activity.DeletedAt,
+			DeletedBy:      activity.DeletedBy,
+			PurgeAt:        activity.DeletedAt.Add(arg.Retention),
+		})
+	}
+
+	if len(deletedActivities) > 0 {
+		deletedIds := make([]primitive.ObjectID, 0, len(deletedActivities))
+		for id := range deletedActivities {
+			deletedIds = append(deletedIds, id)
+		}
+
+		countCursor, err := q.datasCollections.Aggregate(ctx, mongo.Pipeline{
+			{{Key: "$match", Value: bson.M{"deleted_with": bson.M{"$in": deletedIds}}}},
+			{{Key: "$group", Value: bson.M{"_id": "$deleted_with", "count": bson.M{"$sum": 1}}}},
+		})
+		if err != nil {
+			return nil, err
+		}
+		var counts []struct {
+			Id    primitive.ObjectID `bson:"_id"`
+			Count int64              `bson:"count"`
+		}
+		if err = countCursor.All(ctx, &counts); err != nil {
+			return nil, err
+		}
+		for _, count := range counts {
+			items[deletedActivities[count.Id]].DataCount = count.Count
+		}
+	}
+
+	if arg.Type != models.TrashItemActivity && len(activityIds) > 0 {
+		var datas []*models.Data
+		cursor, err := q.datasCollections.Find(ctx, bson.M{
+			"activity_id":  bson.M{"$in": activityIds},
+			"deleted_at":   deletedFilter,
+			"deleted_with": bson.M{"$exists": false},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err = cursor.All(ctx, &datas); err != nil {
+			return nil, err
+		}
+
+		for _, data := range datas {
+			items = append(items, models.TrashItem{
+				Type:       models.TrashItemData,
+				Id:         data.Id,
+				ActivityId: data.ActivityId,
+				Values:     data.Values,
+				DeletedAt:  *data.DeletedAt,
+				DeletedBy:  data.DeletedBy,
+				PurgeAt:    data.DeletedAt.Add(arg.Retention),
+			})
+		}
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].DeletedAt.After(items[j].DeletedAt)
+	})
+
+	return items, nil
+}
+
+type GetDeletedOrganizationsParams struct {
+	UserId    primitive.ObjectID
+	Retention time.Duration
+}
+
+// GetDeletedOrganizations returns the organizations owned by the user and deleted, the latest deleted first
+func (q *Queries) GetDeletedOrganizations(ctx context.Context, arg GetDeletedOrganizationsParams) ([]models.TrashItem, error) {
+	var organizations []*models.Organization
+
+	filter := bson.M{
+		"owned_by":   arg.UserId,
+		"deleted_at": deletedFilter,
+	}
+	cursor, err := q.organizationsCollection.Find(ctx, filter, options.Find().SetSort(bson.M{"deleted_at": -1}))
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &organizations); err != nil {
+		return nil, err
+	}
+
+	items := make([]models.TrashItem, len(organizations))
+	for i, organization := range organizations {
+		items[i] = models.TrashItem{
+			Type:      models.TrashItemOrganization,
+			Id:        organization.Id,
+			Name:      organization.Name,
+			DeletedAt: *organization.DeletedAt,
+			DeletedBy: organization.DeletedBy,
+			PurgeAt:   organization.DeletedAt.Add(arg.Retention),
+		}
+	}
+	return items, nil
+}
+
+type GetExpiredTrashParams struct {
+	DeletedBefore time.Time
+}
+
+// GetExpiredTrash returns the organizations, the activities and the data deleted for too long,
+// in the order they have to be purged. The data deleted along with an activity are purged with it.
+func (q *Queries) GetExpiredTrash(ctx context.Context, arg GetExpiredTrashParams) ([]models.TrashItem, error) {
+	filter := bson.M{"deleted_at": bson.M{"$ne": nil, "$lt": arg.DeletedBefore}}
+	items := make([]models.TrashItem, 0)
+
+	var organizations []*models.Organization
+	cursor, err := q.organizationsCollection.Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &organizations); err != nil {
+		return nil, err
+	}
+	for _, organization := range organizations {
+		items = append(items, models.TrashItem{Type: models.TrashItemOrganization, Id: organization.Id})
+	}
+
+	var activities []*models.Activity
+	cursor, err = q.activitiesCollection.Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1, "organization_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &activities); err != nil {
+		return nil, err
+	}
+	for _, activity := range activities {
+		items = append(items, models.TrashItem{Type: models.TrashItemActivity, Id: activity.Id, OrganizationId: activity.OrganizationId})
+	}
+
+	var datas []*models.Data
+	cursor, err = q.datasCollections.Find(ctx, bson.M{
+		"deleted_at":   filter["deleted_at"],
+		"deleted_with": bson.M{"$exists": false},
+	}, options.Find().SetProjection(bson.M{"_id": 1, "activity_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &datas); err != nil {
+		return nil, err
+	}
+	for _, data := range datas {
+		items = append(items, models.TrashItem{Type: models.TrashItemData, Id: data.Id, ActivityId: data.ActivityId})
+	}
+
+	return items, nil
+}
+
+type RestoreOrganizationParams struct {
+	Id primitive.ObjectID
+}
+
+func (q *Queries) RestoreOrganization(ctx context.Context, arg RestoreOrganizationParams) (*models.Organization, error) {
+	filter := bson.M{
+		"_id":        arg.Id,
+		"deleted_at": deletedFilter,
+	}
+	update := bson.M{
+		"$set":   bson.M{"deleted_at": nil},
+		"$unset": bson.M{"deleted_by": ""},
+	}
+
+	return CommonUpdateQuery[models.Organization](ctx, *q.organizationsCollection, filter, update)
+}
+
+type RestoreDataParams struct {
+	Id         primitive.ObjectID
+	ActivityId primitive.ObjectID
+	// checked again, the fields and the unique constraints may have changed since the deletion
+	Values        map[string]any
+	UniqueKeys    []string
+	SchemaVersion int
+}
+
+// RestoreData takes a data out of the trash with its values migrated to the current fields of the activity,
+// ErrDataNotUnique is returned when its unique values have been used again in the meantime
+func (q *Queries) RestoreData(ctx context.Context, arg RestoreDataParams) (*models.Data, error) {
+	filter := bson.M{
+		"_id":         arg.Id,
+		"activity_id": arg.ActivityId,
+		"deleted_at":  deletedFilter,
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"values":     arg.Values,
+			"deleted_at": nil,
+		},
+		"$unset": bson.M{"deleted_by": "", "deleted_with": ""},
+	}
+	setDataUpdated(update, arg.UniqueKeys, arg.SchemaVersion)
+
+	return updateDataQuery(ctx, q, filter, update)
+}
@@ -0,0 +1,355 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"stockinos.com/api/models"
+)
+
+// DeleteActivityTx moves an activity to the trash along with its data
+func (store *MongoStorage) DeleteActivityTx(ctx context.Context, arg DeleteActivityParams) error {
+	_, err := store.withTx(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		deletedAt := time.Now()
+
+		result, err := store.activitiesCollection.UpdateOne(sessCtx, bson.M{
+			"_id":             arg.Id,
+			"organization_id": arg.OrganizationId,
+			"deleted_at":      nil,
+		}, bson.M{
+			"$set": bson.M{
+				"deleted_at": deletedAt,
+				"deleted_by": arg.DeletedBy,
+			},
+		})
+		if err != nil || result.MatchedCount == 0 {
+			return nil, err
+		}
+
+		// The data already in the trash keep their own deletion
+		_, err = store.datasCollections.UpdateMany(sessCtx, bson.M{
+			"activity_id": arg.Id,
+			"deleted_at":  nil,
+		}, bson.M{
+			"$set": bson.M{
+				"deleted_at":   deletedAt,
+				"deleted_by":   arg.DeletedBy,
+				"deleted_with": arg.Id,
+			},
+		})
+		return nil, err
+	})
+
+	return err
+}
+
+type RestoreActivityTxParams struct {
+	Id             primitive.ObjectID
+	OrganizationId primitive.ObjectID
+	// How many data are restored in each transaction, defaultCreateManyDataBatchSize when 0
+	BatchSize int
+}
+
+// RestoreActivityTx takes an activity out of the trash along with the data deleted with it.
+// The data are restored first, in batches of their own transaction, and the activity last:
+// a restoration which fails leaves the activity in the trash, restoring it again resumes with the data left.
+// The data restored with the activity were all alive together, their unique keys are still accurate.
+func (store *MongoStorage) RestoreActivityTx(ctx context.Context, arg RestoreActivityTxParams) (*models.Activity, error) {
+	if arg.BatchSize <= 0 {
+		arg.BatchSize = defaultCreateManyDataBatchSize
+	}
+
+	activity, err := store.GetActivity(ctx, GetActivityParams{
+		Id:             arg.Id,
+		OrganizationId: arg.OrganizationId,
+		WithDeleted:    true,
+	})
+	if err != nil || activity == nil || activity.DeletedAt == nil {
+		return nil, err
+	}
+
+	for {
+		result, err := store.withTx(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+			return store.restoreActivityDataBatch(sessCtx, arg.Id, arg.BatchSize)
+		})
+		if err != nil {
+			return nil, err
+		}
+		if restored, _ := result.(int); restored < arg.BatchSize {
+			break
+		}
+	}
+
+	return CommonUpdateQuery[models.Activity](ctx, *store.activitiesCollection, bson.M{
+		"_id":             arg.Id,
+		"organization_id": arg.OrganizationId,
+		"deleted_at":      deletedFilter,
+	}, bson.M{
+		"$set":   bson.M{"deleted_at": nil},
+		"$unset": bson.M{"deleted_by": ""},
+	})
+}
+
+// restoreActivityDataBatch restores a batch of the data deleted with an activity and returns how many were
+func (store *MongoStorage) restoreActivityDataBatch(sessCtx mongo.SessionContext, activityId primitive.ObjectID, batchSize int) (int, error) {
+	cursor, err := store.datasCollections.Find(sessCtx, bson.M{
+		"activity_id":  activityId,
+		"deleted_with": activityId,
+	}, options.Find().
+		SetProjection(bson.M{"_id": 1}).
+		SetSort(bson.M{"_id": 1}).
+		SetLimit(int64(batchSize)))
+	if err != nil {
+		return 0, err
+	}
+
+	var batch []struct {
+		Id primitive.ObjectID `bson:"_id"`
+	}
+	if err := cursor.All(sessCtx, &batch); err != nil {
+		return 0, err
+	}
+	if len(batch) == 0 {
+		return 0, nil
+	}
+
+	ids := make(bson.A, len(batch))
+	for i, data := range batch {
+		ids[i] = data.Id
+	}
+	_, err = store.datasCollections.UpdateMany(sessCtx, bson.M{
+		"_id": bson.M{"$in": ids},
+	}, bson.M{
+		"$set":   bson.M{"deleted_at": nil},
+		"$unset": bson.M{"deleted_by": "", "deleted_with": ""},
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return 0, ErrDataNotUnique
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(batch), nil
+}
+
+type PurgeDataTxParams struct {
+	Id         primitive.ObjectID
+	ActivityId primitive.ObjectID
+}
+
+// PurgeDataTx deletes for good a data in the trash and its history.
+// It returns the uploaded files of the data, to delete from the file storage.
+func (store *MongoStorage) PurgeDataTx(ctx context.Context, arg PurgeDataTxParams) ([]string, error) {
+	result, err := store.withTx(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		var data models.Data
+		err := store.datasCollections.FindOneAndDelete(sessCtx, bson.M{
+			"_id":         arg.Id,
+			"activity_id": arg.ActivityId,
+			"deleted_at":  deletedFilter,
+		}).Decode(&data)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return []string{}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := store.dataHistoriesCollection.DeleteMany(sessCtx, bson.M{"data_id": data.Id}); err != nil {
+			return nil, err
+		}
+
+		var activity models.Activity
+		err = store.activitiesCollection.FindOne(sessCtx, bson.M{"_id": data.ActivityId}).Decode(&activity)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return []string{}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		files := activity.UploadedFiles(data.Values)
+		if len(files) > 0 {
+			_, err = store.uploadedFilesCollections.DeleteMany(sessCtx, bson.M{
+				"activity_id": data.ActivityId,
+				"file_key":    bson.M{"$in": files},
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+		return files, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result.([]string), nil
+}
+
+type PurgeActivityTxParams struct {
+	Id             primitive.ObjectID
+	OrganizationId primitive.ObjectID
+}
+
+// PurgeActivityTx deletes for good an activity in the trash, all its data and their history.
+// It returns the uploaded files of the activity, to delete from the file storage.
+func (store *MongoStorage) PurgeActivityTx(ctx context.Context, arg PurgeActivityTxParams) ([]string, error) {
+	result, err := store.withTx(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		var activities []*models.Activity
+		cursor, err := store.activitiesCollection.Find(sessCtx, bson.M{
+			"_id":             arg.Id,
+			"organization_id": arg.OrganizationId,
+			"deleted_at":      deletedFilter,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err = cursor.All(sessCtx, &activities); err != nil {
+			return nil, err
+		}
+
+		return store.purgeActivities(sessCtx, activities)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result.([]string), nil
+}
+
+type PurgeOrganizationTxParams struct {
+	Id primitive.ObjectID
+}
+
+// PurgeOrganizationTx deletes for good an organization in the trash, its team and all its activities.
+// It returns the uploaded files of the activities, to delete from the file storage.
+func (store *MongoStorage) PurgeOrganizationTx(ctx context.Context, arg PurgeOrganizationTxParams) ([]string, error) {
+	result, err := store.withTx(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		deleted, err := store.organizationsCollection.DeleteOne(sessCtx, bson.M{
+			"_id":        arg.Id,
+			"deleted_at": deletedFilter,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if deleted.DeletedCount == 0 {
+			return []string{}, nil
+		}
+
+		if _, err := store.teamsCollection.DeleteMany(sessCtx, bson.M{"organization_id": arg.Id}); err != nil {
+			return nil, err
+		}
+
+		var activities []*models.Activity
+		cursor, err := store.activitiesCollection.Find(sessCtx, bson.M{"organization_id": arg.Id})
+		if err != nil {
+			return nil, err
+		}
+		if err = cursor.All(sessCtx, &activities); err != nil {
+			return nil, err
+		}
+
+		return store.purgeActivities(sessCtx, activities)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result.([]string), nil
+}
+
+// purgeActivities deletes the activities with everything attached to them
+// and returns their uploaded files
+func (store *MongoStorage) purgeActivities(sessCtx mongo.SessionContext, activities []*models.Activity) ([]string, error) {
+	files := make([]string, 0)
+	if len(activities) == 0 {
+		return files, nil
+	}
+
+	seen := make(map[string]bool)
+	addFile := func(file string) {
+		if !seen[file] {
+			seen[file] = true
+			files = append(files, file)
+		}
+	}
+
+	activityIds := make([]primitive.ObjectID, len(activities))
+	for i, activity := range activities {
+		activityIds[i] = activity.Id
+
+		err := forEachDocument(sessCtx, store.datasCollections, bson.M{"activity_id": activity.Id}, func(data *models.Data) error {
+			for _, file := range activity.UploadedFiles(data.Values) {
+				addFile(file)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := forEachDocument(sessCtx, store.uploadedFilesCollections, bson.M{"activity_id": bson.M{"$in": activityIds}}, func(file *models.UploadedFile) error {
+		addFile(file.FileKey)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	filter := bson.M{"activity_id": bson.M{"$in": activityIds}}
+	for _, collection := range []*mongo.Collection{store.datasCollections, store.dataHistoriesCollection, store.uploadedFilesCollections} {
+		if _, err := collection.DeleteMany(sessCtx, filter); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := store.activitiesCollection.DeleteMany(sessCtx, bson.M{"_id": bson.M{"$in": activityIds}}); err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+// forEachDocument decodes the documents one by one instead of loading them all
+func forEachDocument[T any](ctx context.Context, collection *mongo.Collection, filter bson.M, fn func(document *T) error) error {
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var document T
+		if err := cursor.Decode(&document); err != nil {
+			return err
+		}
+		if err := fn(&document); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+type RestoreDataTxParams struct {
+	RestoreDataParams
+	RestoredBy models.DataAuthor
+	// the values of the data in the trash, for the history
+	Before map[string]any
+}
+
+// RestoreDataTx takes a data out of the trash, and adds the restoration to its history
+func (store *MongoStorage) RestoreDataTx(ctx context.Context, arg RestoreDataTxParams) (*models.Data, error) {
+	history := AddDataHistoryParams{
+		Action: models.DataHistoryRestored,
+		Before: arg.Before,
+		Author: arg.RestoredBy,
+	}
+	return store.withDataHistory(ctx, history, func(sessCtx mongo.SessionContext) (*models.Data, error) {
+		return store.RestoreData(sessCtx, arg.RestoreDataParams)
+	})
+}
@@ -164,3 +164,21 @@ func (q *Queries) UpdateUserPreferences(ctx context.Context, arg UpdateUserPrefe
 
 	return &user, nil
 }
+
+type GetUserByIdParams struct {
+	Id primitive.ObjectID
+}
+
+func (q *Queries) GetUserById(ctx context.Context, arg GetUserByIdParams) (*models.User, error) {
+	var user models.User
+
+	err := q.usersCollection.FindOne(ctx, bson.M{"_id": arg.Id}).Decode(&user)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &user, nil
+}
@@ -1,30 +1,28 @@
 package utils
 
 import (
-	"math/rand"
-	"time"
+	"crypto/rand"
+	"math/big"
 )
 
-func createSeed(now time.Time) *rand.Rand {
-	return rand.New(
-		rand.NewSource(
-			now.UnixNano(),
-		),
-	)
-}
-
-func stringWithCharset(now time.Time, charset string, length int) string {
-	seed := createSeed(now)
+// randomStringWithCharset draws each character from a cryptographically secure source
+func randomStringWithCharset(charset string, length int) (string, error) {
+	max := big.NewInt(int64(len(charset)))
 
 	b := make([]byte, length)
 	for i := range b {
-		b[i] = charset[seed.Intn(len(charset))]
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b[i] = charset[n.Int64()]
 	}
 
-	return string(b)
+	return string(b), nil
 }
 
-func GenerateOTP(now time.Time) string {
+// GenerateOTP returns a random code made of length digits
+func GenerateOTP(length int) (string, error) {
 	charset := "0123456789"
-	return stringWithCharset(now, charset, 4)
+	return randomStringWithCharset(charset, length)
 }